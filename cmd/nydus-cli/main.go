@@ -1,14 +1,33 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/nydusaccelerator/nydus-cli/pkg/batch"
+	"github.com/nydusaccelerator/nydus-cli/pkg/builder"
+	"github.com/nydusaccelerator/nydus-cli/pkg/bundle"
+	"github.com/nydusaccelerator/nydus-cli/pkg/completion"
 	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/harness"
+	"github.com/nydusaccelerator/nydus-cli/pkg/mountns"
+	"github.com/nydusaccelerator/nydus-cli/pkg/picker"
+	"github.com/nydusaccelerator/nydus-cli/pkg/progress"
+	"github.com/nydusaccelerator/nydus-cli/pkg/schedule"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
 	"github.com/nydusaccelerator/nydus-cli/pkg/workflow"
 
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -26,6 +45,17 @@ func main() {
 	version := fmt.Sprintf("%s.%s", revision, buildTime)
 	logrus.Infof("version %s\n", version)
 
+	err := newApp(version).Run(os.Args)
+	if err != nil {
+		// The exit code tells the class of failure, see workflow.ExitCodes.
+		logrus.Error(err)
+		os.Exit(workflow.ExitCode(err))
+	}
+}
+
+// newApp returns the app of all commands, the `version` is shown by
+// --version and recorded in the committed images.
+func newApp(version string) *cli.App {
 	printOption := func(c *cli.Context, options []string) {
 		logrus.Infof("options:")
 		for _, option := range options {
@@ -53,71 +83,478 @@ func main() {
 		return withPaths, withoutPaths
 	}
 
+	parseOwnership := func(c *cli.Context) (*tarstream.Ownership, error) {
+		if c.String("chown") == "" && len(c.StringSlice("uid-map")) == 0 && len(c.StringSlice("gid-map")) == 0 {
+			return nil, nil
+		}
+
+		ownership := tarstream.Ownership{}
+		if c.String("chown") != "" {
+			uid, gid, err := tarstream.ParseChown(c.String("chown"))
+			if err != nil {
+				return nil, err
+			}
+			ownership.UID = uid
+			ownership.GID = gid
+		}
+		for _, mapping := range c.StringSlice("uid-map") {
+			parsed, err := tarstream.ParseIDMapping(mapping)
+			if err != nil {
+				return nil, err
+			}
+			ownership.UIDMaps = append(ownership.UIDMaps, *parsed)
+		}
+		for _, mapping := range c.StringSlice("gid-map") {
+			parsed, err := tarstream.ParseIDMapping(mapping)
+			if err != nil {
+				return nil, err
+			}
+			ownership.GIDMaps = append(ownership.GIDMaps, *parsed)
+		}
+
+		return &ownership, nil
+	}
+
+	parseSourceDateEpoch := func(c *cli.Context) (*time.Time, error) {
+		value := os.Getenv("SOURCE_DATE_EPOCH")
+		if value == "" {
+			if !c.Bool("reproducible") {
+				return nil, nil
+			}
+			value = "0"
+		}
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid SOURCE_DATE_EPOCH %s", value)
+		}
+		epoch := time.Unix(seconds, 0).UTC()
+		return &epoch, nil
+	}
+
+	applyOptionsFrom := func(c *cli.Context) error {
+		source := c.String("options-from")
+		if source == "" {
+			return nil
+		}
+		var data []byte
+		var err error
+		if source == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(source)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read options from %s", source)
+		}
+		options, err := config.ParseOptions(data)
+		if err != nil {
+			return err
+		}
+
+		known := map[string]bool{}
+		for _, flag := range c.Command.Flags {
+			for _, name := range flag.Names() {
+				known[name] = true
+			}
+		}
+		for _, name := range config.OptionNames(options) {
+			if !known[name] || name == "options-from" {
+				return fmt.Errorf("unknown option %s", name)
+			}
+			if c.IsSet(name) {
+				continue
+			}
+			for _, value := range options[name] {
+				if err := c.Set(name, value); err != nil {
+					return errors.Wrapf(err, "set option %s", name)
+				}
+			}
+		}
+
+		return nil
+	}
+
 	app := &cli.App{
-		Name:    "nydus-cli",
-		Usage:   "Nydus utility tool to operate nydus image",
-		Version: version,
+		Name:                 "nydus-cli",
+		Usage:                "Nydus utility tool to operate nydus image",
+		Version:              version,
+		EnableBashCompletion: true,
 	}
 
 	app.Flags = []cli.Flag{
-		&cli.StringFlag{Name: "log-level", Value: "info", Usage: "Set the logging level [trace, debug, info, warn, error, fatal, panic]"},
+		&cli.StringFlag{Name: "log-level", Value: "info", Usage: "Set the logging level [trace, debug, info, warn, error, fatal, panic], trace level logs the registry and OSS requests", EnvVars: []string{"NYDUS_CLI_LOG_LEVEL"}},
 		&cli.StringFlag{
 			Name:    "config",
-			Usage:   "Path to configuration file",
-			EnvVars: []string{"CONFIG"},
+			Usage:   "Path to configuration file, optional if configs are set by NYDUS_CLI_* envs",
+			EnvVars: []string{"NYDUS_CLI_CONFIG", "CONFIG"},
 		},
 	}
 
+	app.Before = func(c *cli.Context) error {
+		level, err := logrus.ParseLevel(c.String("log-level"))
+		if err != nil {
+			return err
+		}
+		logrus.SetLevel(level)
+		return nil
+	}
+
+	// workdirFlag and builderFlag are also declared by the commands not
+	// taking all of baseFlags.
+	workdirFlag := &cli.StringFlag{
+		Name:        "workdir",
+		Required:    false,
+		DefaultText: "/tmp",
+		Value:       "/tmp",
+		EnvVars:     []string{"NYDUS_CLI_WORKDIR"},
+	}
+	builderFlag := &cli.StringFlag{
+		Name:        "builder",
+		Required:    false,
+		DefaultText: "nydus-image",
+		Value:       "nydus-image",
+		EnvVars:     []string{"NYDUS_CLI_BUILDER"},
+	}
+
 	baseFlags := []cli.Flag{
+		workdirFlag,
+		&cli.StringFlag{
+			Name:     "workdir-encryption",
+			Required: false,
+			Usage:    "Keep data in workdir encrypted at rest [require, ephemeral]",
+			EnvVars:  []string{"NYDUS_CLI_WORKDIR_ENCRYPTION", "WORKDIR_ENCRYPTION"},
+		},
 		&cli.StringFlag{
-			Name:        "workdir",
+			Name:        "workdir-encryption-size",
 			Required:    false,
-			DefaultText: "/tmp",
-			Value:       "/tmp",
+			DefaultText: "20GiB",
+			Value:       "20GiB",
+			Usage:       "The size of ephemeral encrypted workdir",
+			EnvVars:     []string{"NYDUS_CLI_WORKDIR_ENCRYPTION_SIZE"},
 		},
 		&cli.StringFlag{
-			Name:        "builder",
+			Name:        "workdir-quota",
 			Required:    false,
-			DefaultText: "nydus-image",
-			Value:       "nydus-image",
+			DefaultText: "0",
+			Value:       "0",
+			Usage:       "Limit the size of files written into workdir while committing, e.g. 10GiB, 0 means unlimited",
+			EnvVars:     []string{"NYDUS_CLI_WORKDIR_QUOTA"},
+		},
+		builderFlag,
+		&cli.StringFlag{
+			Name:     "builder-log-level",
+			Required: false,
+			Usage:    "Log level of builder, one of trace, debug, info, warn and error, the builder output is kept in workdir and its tail is reported on failure",
+			EnvVars:  []string{"NYDUS_CLI_BUILDER_LOG_LEVEL"},
+		},
+		&cli.StringSliceFlag{
+			Name:     "builder-arg",
+			Required: false,
+			Usage:    "Extra argument appended to the builder invocations, prefix it with create: or merge: to append to the subcommand only, e.g. create:--chunk-size=0x100000, appended to the args in config",
+			EnvVars:  []string{"NYDUS_CLI_BUILDER_ARG"},
 		},
+	}
+	// runtimeFlags are the addresses of container engines, also declared
+	// by the commands not taking all of baseFlags.
+	runtimeFlags := []cli.Flag{
 		&cli.StringFlag{
 			Name:        "pouch.addr",
 			Required:    false,
 			DefaultText: "/var/run/pouchd.sock",
 			Value:       "/var/run/pouchd.sock",
+			EnvVars:     []string{"NYDUS_CLI_POUCH_ADDR"},
 		},
 		&cli.StringFlag{
 			Name:        "docker.addr",
 			Required:    false,
 			DefaultText: "/var/run/docker.sock",
 			Value:       "/var/run/docker.sock",
+			EnvVars:     []string{"NYDUS_CLI_DOCKER_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:        "containerd.addr",
+			Required:    false,
+			DefaultText: "/run/containerd/containerd.sock",
+			Value:       "/run/containerd/containerd.sock",
+			Usage:       "The containerd under docker or pouch, used by --pause-mode task",
+			EnvVars:     []string{"NYDUS_CLI_CONTAINERD_ADDR"},
 		},
 	}
+	baseFlags = append(baseFlags, runtimeFlags...)
+
+	// pickContainer prompts on terminal to pick the container of nydus
+	// image to commit, and sets it to --container.
+	pickContainer := func(c *cli.Context) error {
+		cm, err := container.NewManager(&config.Runtime{
+			PouchAddr:  c.String("pouch.addr"),
+			DockerAddr: c.String("docker.addr"),
+		})
+		if err != nil {
+			return errors.Wrap(err, "new container manager")
+		}
+		containers, err := cm.List(c.Context)
+		if err != nil {
+			return err
+		}
+		picked, err := picker.Pick(os.Stdin, os.Stderr, containers)
+		if err != nil {
+			return err
+		}
+		return c.Set("container", picked.ID)
+	}
+
+	// completeContainers completes the value of --container by the running
+	// containers of engines, and the flags and subcommands otherwise.
+	completeContainers := func(c *cli.Context) {
+		if !completion.CompletingFlag(os.Args, "container") {
+			cli.DefaultCompleteWithFlags(c.Command)(c)
+			return
+		}
+		cm, err := container.NewManager(&config.Runtime{
+			PouchAddr:  c.String("pouch.addr"),
+			DockerAddr: c.String("docker.addr"),
+		})
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Context, 5*time.Second)
+		defer cancel()
+		containers, err := cm.List(ctx)
+		if err != nil {
+			return
+		}
+		completion.PrintContainers(c.App.Writer, os.Getenv("SHELL"), containers)
+	}
 
 	app.Commands = []*cli.Command{
 		{
-			Name:  "commit",
-			Usage: "Commit a container into nydus image based a nydus image",
+			Name:         "commit",
+			Usage:        "Commit a container into nydus image based a nydus image",
+			BashComplete: completeContainers,
 			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:     "container",
-					Required: true,
-					Usage:    "Target container id",
+					Required: false,
+					Usage:    "Target container in format `[<engine>://]<id|name|id-prefix>`, the name or unique id prefix is resolved among the running containers, picked from the running containers of nydus image on terminal if neither container nor pod is set",
 					EnvVars:  []string{"CONTAINER"},
 				},
 				&cli.StringFlag{
+					Name:    "pod",
+					Usage:   "Commit the containers of kubernetes pod in format `[<engine>://]<namespace>/<name>` instead of --container, each into its own image by default, the targets must use {{.ContainerName}} if there are several containers",
+					EnvVars: []string{"POD"},
+				},
+				&cli.StringFlag{
+					Name:    "pod-combined",
+					Usage:   "Commit the pod into one image based on the image of the named container, the upper dirs of the other containers are committed under --pod-prefix",
+					EnvVars: []string{"POD_COMBINED"},
+				},
+				&cli.StringFlag{
+					Name:    "pod-prefix",
+					Value:   "/pod",
+					Usage:   "The directory which the upper dirs of the other containers are committed under with --pod-combined, one sub directory per container name",
+					EnvVars: []string{"POD_PREFIX"},
+				},
+				&cli.StringSliceFlag{
 					Name:     "target",
-					Required: true,
-					Usage:    "Target nydus image reference",
+					Required: false,
+					Usage:    "Target image reference in format `ref[=format]`, format is nydus (default) or oci, ref can be a template using {{.ContainerID}}, {{.Date}}, {{.Timestamp}}, {{.Sequence}} and {{.BaseTag}}, can be specified multiple times",
 					EnvVars:  []string{"TARGET"},
 				},
+				&cli.StringFlag{
+					Name:    "interval",
+					Usage:   "Keep running and commit the container on schedule, a duration (e.g. 1h) or a cron expression (e.g. '0 */6 * * *'), the runs are skipped if nothing changed since the last commit",
+					EnvVars: []string{"INTERVAL"},
+				},
+				&cli.DurationFlag{
+					Name:        "jitter",
+					DefaultText: "a tenth of the time until next run",
+					Usage:       "Maximum random delay added to each scheduled commit with --interval, so the commits of a fleet are not pushed at the same time",
+					EnvVars:     []string{"JITTER"},
+				},
+				&cli.DurationFlag{
+					Name:    "time-budget",
+					Usage:   "Abort the commit if it isn't finished in the duration (e.g. 10m), the container is unpaused, the uploads are aborted and the workdir is cleaned, then it exits with code 18, 0 means no limit",
+					EnvVars: []string{"TIME_BUDGET"},
+				},
+				&cli.StringFlag{
+					Name:    "options-from",
+					Usage:   "Read commit options from a JSON or YAML document keyed by flag names in the file, or stdin if it's -, the flags set in command line or envs take precedence",
+					EnvVars: []string{"OPTIONS_FROM"},
+				},
+				&cli.BoolFlag{
+					Name:    "keep-workdir",
+					Value:   false,
+					Usage:   "Keep the workdir of a failed commit for debugging, see debug-bundle command",
+					EnvVars: []string{"KEEP_WORKDIR"},
+				},
+				&cli.BoolFlag{
+					Name:    "stream-push",
+					Value:   false,
+					Usage:   "Push blobs while packing instead of after packed, the data of blobs is not written to work dir",
+					EnvVars: []string{"STREAM_PUSH"},
+				},
+				&cli.BoolFlag{
+					Name:    "skip-space-check",
+					Value:   false,
+					Usage:   "Skip checking the estimated size of committed data against the available space of workdir before packing",
+					EnvVars: []string{"SKIP_SPACE_CHECK"},
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Value:   workflow.OutputText,
+					Usage:   "Format of the summary with the elapsed time of each phase written to stdout at the end of commit [text, json]",
+					EnvVars: []string{"OUTPUT"},
+				},
+				&cli.StringFlag{
+					Name:    "progress",
+					Value:   string(progress.ModeAuto),
+					Usage:   "Display the progress of packing and pushing blobs [auto, bar, log, none], auto draws a status line on terminal and logs periodically otherwise",
+					EnvVars: []string{"PROGRESS"},
+				},
+				&cli.BoolFlag{
+					Name:    "readonly-upper",
+					Value:   false,
+					Usage:   "Make diff on a read-only bind mount of container's upper dir, the commit is run in a private mount namespace",
+					EnvVars: []string{"READONLY_UPPER"},
+				},
+				&cli.BoolFlag{
+					Name:    "clone-upper",
+					Value:   false,
+					Usage:   "Make diff on a reflink clone of container's upper dir in workdir, the container is paused only while cloning",
+					EnvVars: []string{"CLONE_UPPER"},
+				},
+				&cli.BoolFlag{
+					Name:    "force",
+					Value:   false,
+					Usage:   "Overwrite the target images if they already exist",
+					EnvVars: []string{"FORCE"},
+				},
+				&cli.BoolFlag{
+					Name:    "allow-empty",
+					Value:   false,
+					Usage:   "Push the image even if nothing changed since the last commit",
+					EnvVars: []string{"ALLOW_EMPTY"},
+				},
+				&cli.BoolFlag{
+					Name:    "push-by-digest",
+					Value:   false,
+					Usage:   "Push the manifests of target images by digest without tagging them",
+					EnvVars: []string{"PUSH_BY_DIGEST"},
+				},
+				&cli.StringFlag{
+					Name:    "digest-file",
+					Usage:   "Write the digests of pushed manifests to the file, one line per target in order",
+					EnvVars: []string{"DIGEST_FILE"},
+				},
+				&cli.BoolFlag{
+					Name:    "convert-base",
+					Usage:   "Commit the container of OCI image on top of its nydus image, which is converted on the fly if not found in registry",
+					EnvVars: []string{"CONVERT_BASE"},
+				},
+				&cli.BoolFlag{
+					Name:    "provenance",
+					Usage:   "Attach the SLSA provenance attestation to each pushed manifest by OCI referrers API, or its fallback tag if unsupported by registry",
+					EnvVars: []string{"PROVENANCE"},
+				},
+				&cli.BoolFlag{
+					Name:    "metadata",
+					Usage:   "Attach the commit metadata (options, source container, base image, file stats and version) to each pushed manifest by OCI referrers API, shown by metadata command",
+					EnvVars: []string{"METADATA"},
+				},
+				&cli.IntFlag{
+					Name:    "keep-last",
+					Value:   0,
+					Usage:   "Keep only the latest N tags expanded from each templated target after pushed, the older ones are deleted from registry, 0 means keeping all",
+					EnvVars: []string{"KEEP_LAST"},
+				},
+				&cli.IntFlag{
+					Name:    "parallelism",
+					Value:   0,
+					Usage:   "Maximum count of concurrent pack and push jobs for upper and mount paths, 0 means no limit",
+					EnvVars: []string{"PARALLELISM"},
+				},
+				&cli.IntFlag{
+					Name:    "diff-workers",
+					Value:   1,
+					Usage:   "Count of workers walking the top-level directories of container's upper dir concurrently for diff, 1 walks it serially",
+					EnvVars: []string{"DIFF_WORKERS"},
+				},
+				&cli.IntFlag{
+					Name:    "max-mount-entries",
+					Value:   0,
+					Usage:   "Maximum count of entries copied from container for each mount path, 0 means no limit",
+					EnvVars: []string{"MAX_MOUNT_ENTRIES"},
+				},
+				&cli.StringFlag{
+					Name:    "max-mount-size",
+					Value:   "0",
+					Usage:   "Maximum total size of files copied from container for each mount path, e.g. 10GiB, 0 means no limit",
+					EnvVars: []string{"MAX_MOUNT_SIZE"},
+				},
+				&cli.StringFlag{
+					Name:        "special-files",
+					DefaultText: "keep",
+					Value:       "keep",
+					Usage:       "How device nodes, sockets and FIFOs in the upper dir and mount paths are packed, one of keep, skip and fail, sockets can't be kept and are always skipped unless fail",
+					EnvVars:     []string{"SPECIAL_FILES"},
+				},
+				&cli.StringFlag{
+					Name:        "mount-strategy",
+					DefaultText: "auto",
+					Value:       "auto",
+					Usage:       "How the mount paths are copied, one of nsenter (tar in the mount namespace of container), host (tar on host from the host paths of volumes) and auto (host if nsenter fails)",
+					EnvVars:     []string{"MOUNT_STRATEGY"},
+				},
+				&cli.DurationFlag{
+					Name:        "mount-stall-timeout",
+					DefaultText: "10m",
+					Value:       10 * time.Minute,
+					Usage:       "Abort the commit if nothing is copied from a mount path of container for the duration, e.g. on a dead network mount, 0 means no timeout",
+					EnvVars:     []string{"MOUNT_STALL_TIMEOUT"},
+				},
+				&cli.BoolFlag{
+					Name:    "mount-numeric-owner",
+					Value:   false,
+					Usage:   "Archive the owners of files in mount paths by uid and gid only, without the user and group names in container",
+					EnvVars: []string{"MOUNT_NUMERIC_OWNER"},
+				},
+				&cli.BoolFlag{
+					Name:    "mount-no-acls",
+					Value:   false,
+					Usage:   "Don't archive the POSIX ACLs of files in mount paths",
+					EnvVars: []string{"MOUNT_NO_ACLS"},
+				},
+				&cli.BoolFlag{
+					Name:    "mount-selinux",
+					Value:   false,
+					Usage:   "Archive the SELinux contexts of files in mount paths",
+					EnvVars: []string{"MOUNT_SELINUX"},
+				},
+				&cli.StringFlag{
+					Name:        "mount-unreadable",
+					DefaultText: "warn",
+					Value:       "warn",
+					Usage:       "How the files in mount paths which tar fails to read are handled, one of warn (skipped with warnings) and fail (fail the commit)",
+					EnvVars:     []string{"MOUNT_UNREADABLE"},
+				},
+				&cli.StringFlag{
+					Name:    "chunk-dict",
+					Usage:   "Deduplicate chunks of committed blobs against chunk dict in format `bootstrap=<ref or path>`, ref is a nydus image",
+					EnvVars: []string{"CHUNK_DICT"},
+				},
 				&cli.BoolFlag{
 					Name:     "pause-container",
 					Required: false,
 					Usage:    "Pause container during commit",
 					EnvVars:  []string{"PAUSE_CONTAINER"},
 				},
+				&cli.StringFlag{
+					Name:        "pause-mode",
+					DefaultText: "engine",
+					Value:       "engine",
+					Usage:       "How --pause-container pauses container, one of engine (pause API of docker or pouch), cgroup (freeze the cgroup directly) and task (pause API of containerd task)",
+					EnvVars:     []string{"PAUSE_MODE"},
+				},
 				&cli.IntFlag{
 					Name:        "maximum-times",
 					Required:    false,
@@ -126,6 +563,37 @@ func main() {
 					Usage:       "The maximum times allowed to be committed",
 					EnvVars:     []string{"MAXIMUM_TIMES"},
 				},
+				&cli.StringFlag{
+					Name:        "maximum-times-policy",
+					DefaultText: "layers",
+					Value:       "layers",
+					Usage:       "How the commits are counted against --maximum-times, one of layers (the committed blobs of base image), chain (the commits in the chain by commit history), daily (the commits in the chain made in the current UTC day) and disabled",
+					EnvVars:     []string{"MAXIMUM_TIMES_POLICY"},
+				},
+				&cli.StringFlag{
+					Name:     "chown",
+					Required: false,
+					Usage:    "Set the owner (uid[:gid]) of all files in committed layers, the POSIX ACLs naming users (or groups with gid) are dropped",
+					EnvVars:  []string{"CHOWN"},
+				},
+				&cli.StringSliceFlag{
+					Name:     "uid-map",
+					Required: false,
+					Usage:    "Map host uids to container uids in committed layers, also the users named by POSIX ACLs (container_id:host_id:size)",
+					EnvVars:  []string{"UID_MAP"},
+				},
+				&cli.StringSliceFlag{
+					Name:     "gid-map",
+					Required: false,
+					Usage:    "Map host gids to container gids in committed layers, also the groups named by POSIX ACLs (container_id:host_id:size)",
+					EnvVars:  []string{"GID_MAP"},
+				},
+				&cli.BoolFlag{
+					Name:     "reproducible",
+					Required: false,
+					Usage:    "Normalize timestamps and order of files to make committed layers reproducible, also enabled by SOURCE_DATE_EPOCH",
+					EnvVars:  []string{"REPRODUCIBLE"},
+				},
 				&cli.StringSliceFlag{
 					Name:     "with-path",
 					Aliases:  []string{"with-mount-path"},
@@ -135,34 +603,1128 @@ func main() {
 				},
 			}, baseFlags...),
 			Action: func(c *cli.Context) error {
+				if err := applyOptionsFrom(c); err != nil {
+					return errors.Wrap(err, "apply options-from")
+				}
+				// A commit canceled by signal returns through the cleanup
+				// of workflow, which unpauses the container, unmounts and
+				// aborts the uploads, a second signal kills it at once.
+				ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				go func() {
+					<-ctx.Done()
+					stop()
+				}()
+				if c.Bool("readonly-upper") {
+					// The read-only bind mount of upper dir is made in a
+					// private mount namespace, released even if killed.
+					entered, err := mountns.Enter()
+					if err != nil {
+						return errors.Wrap(err, "enter private mount namespace")
+					}
+					if !entered {
+						code, err := mountns.Reexec()
+						if err != nil {
+							return errors.Wrap(err, "commit in private mount namespace")
+						}
+						os.Exit(code)
+					}
+				}
+				if c.String("container") == "" && c.String("pod") == "" && picker.IsTerminal(os.Stdin) && picker.IsTerminal(os.Stderr) {
+					if err := pickContainer(c); err != nil {
+						return errors.Wrap(err, "pick container")
+					}
+				}
+				if c.String("container") == "" && c.String("pod") == "" {
+					return fmt.Errorf("option container or pod is required")
+				}
+				if c.String("container") != "" && c.String("pod") != "" {
+					return fmt.Errorf("option container can't be used with pod")
+				}
+				if c.String("pod-combined") != "" && c.String("pod") == "" {
+					return fmt.Errorf("option pod-combined requires pod")
+				}
+				if len(c.StringSlice("target")) == 0 {
+					return fmt.Errorf("option target is required")
+				}
+
 				cfg, err := config.Parse(c, c.String("config"))
 				if err != nil {
 					return errors.Wrap(err, "parse config file")
 				}
-
-				wf, err := workflow.NewWorkflow(cfg)
+				releaseClient, err := builder.ReleaseClient(cfg)
 				if err != nil {
-					return errors.Wrap(err, "create workflow")
+					return errors.Wrap(err, "create builder release client")
+				}
+				if cfg.Base.Builder, err = builder.Discover(ctx, cfg.Base.Builder, cfg.Builder, filepath.Join(cfg.Base.WorkDir, "builder"), releaseClient); err != nil {
+					return errors.Wrap(err, "discover builder")
 				}
-				defer wf.Destory() //nolint:errcheck
 
-				printOption(c, []string{"container", "target", "with-path", "maximum-times"})
+				printOption(c, []string{"container", "pod", "pod-combined", "pod-prefix", "interval", "jitter", "time-budget", "target", "with-path", "maximum-times", "maximum-times-policy", "chown", "uid-map", "gid-map", "chunk-dict", "max-mount-entries", "max-mount-size", "parallelism", "diff-workers", "keep-last"})
 				withPaths, withoutPaths := parsePaths(c, c.StringSlice("with-path"))
+				ownership, err := parseOwnership(c)
+				if err != nil {
+					return errors.Wrap(err, "parse ownership options")
+				}
+				sourceDateEpoch, err := parseSourceDateEpoch(c)
+				if err != nil {
+					return errors.Wrap(err, "parse reproducible options")
+				}
+
+				if output := c.String("output"); output != workflow.OutputText && output != workflow.OutputJSON {
+					return fmt.Errorf("invalid output format %s, must be text or json", output)
+				}
+				progressMode, err := progress.ParseMode(c.String("progress"))
+				if err != nil {
+					return errors.Wrap(err, "parse progress option")
+				}
+				progressMode = progressMode.Resolve(picker.IsTerminal(os.Stderr))
+				pauseMode, err := container.ParsePauseMode(c.String("pause-mode"))
+				if err != nil {
+					return errors.Wrap(err, "parse pause mode option")
+				}
+				mountStrategy, err := workflow.ParseMountStrategy(c.String("mount-strategy"))
+				if err != nil {
+					return errors.Wrap(err, "parse mount strategy option")
+				}
+				maximumTimesPolicy, err := workflow.ParseMaximumTimesPolicy(c.String("maximum-times-policy"))
+				if err != nil {
+					return errors.Wrap(err, "parse maximum times policy option")
+				}
+				specialFiles, err := tarstream.ParseSpecialFiles(c.String("special-files"))
+				if err != nil {
+					return errors.Wrap(err, "parse special files option")
+				}
+				mountUnreadable, err := workflow.ParseUnreadablePolicy(c.String("mount-unreadable"))
+				if err != nil {
+					return errors.Wrap(err, "parse mount unreadable option")
+				}
+
+				maxMountSize, err := humanize.ParseBytes(c.String("max-mount-size"))
+				if err != nil {
+					return errors.Wrap(err, "parse max mount size option")
+				}
+
+				var chunkDict string
+				if c.String("chunk-dict") != "" {
+					chunkDict, err = workflow.ParseChunkDict(c.String("chunk-dict"))
+					if err != nil {
+						return errors.Wrap(err, "parse chunk dict option")
+					}
+				}
 
-				return wf.Commit(c.Context, workflow.CommitOption{
+				targets := []workflow.Target{}
+				for _, target := range c.StringSlice("target") {
+					parsed, err := workflow.ParseTarget(target)
+					if err != nil {
+						return errors.Wrap(err, "parse target option")
+					}
+					targets = append(targets, *parsed)
+				}
+
+				opt := workflow.CommitOption{
 					ContainerIDWithType: c.String("container"),
-					TargetRef:           c.String("target"),
+					Targets:             targets,
 					WithPaths:           withPaths,
 					WithoutPaths:        withoutPaths,
 					PauseContainer:      c.Bool("pause-container"),
+					PauseMode:           pauseMode,
 					MaximumTimes:        c.Int("maximum-times"),
+					MaximumTimesPolicy:  maximumTimesPolicy,
+					Ownership:           ownership,
+					SourceDateEpoch:     sourceDateEpoch,
+					ChunkDict:           chunkDict,
+					MaxMountEntries:     c.Int("max-mount-entries"),
+					MountStrategy:       mountStrategy,
+					SpecialFiles:        specialFiles,
+					MountStallTimeout:   c.Duration("mount-stall-timeout"),
+					MountNumericOwner:   c.Bool("mount-numeric-owner"),
+					MountNoACLs:         c.Bool("mount-no-acls"),
+					MountSELinux:        c.Bool("mount-selinux"),
+					MountUnreadable:     mountUnreadable,
+					MaxMountSize:        int64(maxMountSize),
+					Parallelism:         c.Int("parallelism"),
+					DiffWorkers:         c.Int("diff-workers"),
+					ReadOnlyUpper:       c.Bool("readonly-upper"),
+					CloneUpper:          c.Bool("clone-upper"),
+					ConvertBase:         c.Bool("convert-base"),
+					Force:               c.Bool("force"),
+					AllowEmpty:          c.Bool("allow-empty"),
+					PushByDigest:        c.Bool("push-by-digest"),
+					DigestFile:          c.String("digest-file"),
+					KeepLast:            c.Int("keep-last"),
+					Provenance:          c.Bool("provenance"),
+					Metadata:            c.Bool("metadata"),
+					Version:             version,
+					StreamPush:          c.Bool("stream-push"),
+					SkipSpaceCheck:      c.Bool("skip-space-check"),
+					Output:              c.String("output"),
+					TimeBudget:          c.Duration("time-budget"),
+				}
+				cm, err := container.NewManager(&cfg.Base.Runtime)
+				if err != nil {
+					return errors.Wrap(err, "create container manager")
+				}
+				// The containers of pod or by name are resolved on each run
+				// of watch as they may be restarted.
+				resolve := func(ctx context.Context) ([]workflow.CommitOption, error) {
+					if c.String("pod") == "" {
+						containerID, err := cm.Resolve(ctx, opt.ContainerIDWithType)
+						if err != nil {
+							return nil, errors.Wrap(err, "resolve container")
+						}
+						resolved := opt
+						resolved.ContainerIDWithType = containerID
+						return []workflow.CommitOption{resolved}, nil
+					}
+					containers, err := cm.PodContainers(ctx, c.String("pod"))
+					if err != nil {
+						return nil, errors.Wrap(err, "resolve containers of pod")
+					}
+					opts, err := workflow.PodCommitOptions(opt, containers, c.String("pod-combined"), c.String("pod-prefix"))
+					if err != nil {
+						return nil, errors.Wrap(err, "parse pod options")
+					}
+					return opts, nil
+				}
+
+				// Each container is committed by its own workflow, so the
+				// work dirs of them are separated.
+				commit := func(ctx context.Context, opt workflow.CommitOption) error {
+					wf, err := workflow.NewWorkflow(cfg)
+					if err != nil {
+						return errors.Wrap(err, "create workflow")
+					}
+					defer func() {
+						if err := wf.Destory(); err != nil {
+							logrus.WithError(err).Warn("destroy workflow")
+						}
+					}()
+					wf.SetProgress(progressMode)
+					if c.Bool("keep-workdir") {
+						if err := wf.CaptureLogs(); err != nil {
+							return errors.Wrap(err, "capture logs")
+						}
+					}
+					if opt.ContainerName != "" {
+						logrus.Infof("committing container %s of pod %s", opt.ContainerName, c.String("pod"))
+					}
+					err = wf.Commit(ctx, opt)
+					if err != nil && c.Bool("keep-workdir") {
+						wf.KeepWorkDir()
+					}
+					return err
+				}
+				commitAll := func(ctx context.Context) error {
+					opts, err := resolve(ctx)
+					if err != nil {
+						return err
+					}
+					for _, opt := range opts {
+						if err := commit(ctx, opt); err != nil {
+							if opt.ContainerName != "" {
+								return errors.Wrapf(err, "commit container %s of pod", opt.ContainerName)
+							}
+							return err
+						}
+					}
+					return nil
+				}
+
+				if c.String("interval") == "" {
+					return commitAll(ctx)
+				}
+				sched, err := schedule.Parse(c.String("interval"))
+				if err != nil {
+					return errors.Wrap(err, "parse interval option")
+				}
+				if err := workflow.CheckWatchTargets(opt, sched); err != nil {
+					return err
+				}
+				watchOpt := workflow.WatchOption{Schedule: sched}
+				if c.IsSet("jitter") {
+					jitter := c.Duration("jitter")
+					watchOpt.Jitter = &jitter
+				}
+				fingerprint := func(ctx context.Context) (digest.Digest, error) {
+					opts, err := resolve(ctx)
+					if err != nil {
+						return "", err
+					}
+					return workflow.CommitFingerprint(ctx, cm, opts)
+				}
+				return workflow.Watch(ctx, watchOpt, fingerprint, commitAll)
+			},
+		},
+		{
+			Name:  "commit-batch",
+			Usage: "Commit the containers described in a batch file, each job by the commit command in a child process",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "file",
+					Required: true,
+					Usage:    "The batch file in JSON or YAML, whose `jobs` are the options of commit command keyed by flag names as --options-from, with an optional `name`",
+				},
+				&cli.IntFlag{
+					Name:        "parallelism",
+					DefaultText: "4",
+					Value:       4,
+					Usage:       "Maximum count of jobs run concurrently, 0 means no limit",
+				},
+				&cli.BoolFlag{
+					Name:  "fail-fast",
+					Usage: "Skip the jobs not started yet once a job failed, all jobs are run by default",
+				},
+				&cli.StringFlag{
+					Name:  "log-dir",
+					Usage: "Write the output of each job to <name>.log in the directory, the output is prefixed by job name in stderr by default",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "Write the results of jobs in JSON to the path",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				data, err := os.ReadFile(c.String("file"))
+				if err != nil {
+					return errors.Wrap(err, "read batch file")
+				}
+				jobs, err := batch.ParseJobs(data)
+				if err != nil {
+					return err
+				}
+				executable, err := os.Executable()
+				if err != nil {
+					return errors.Wrap(err, "find executable of nydus-cli")
+				}
+				if c.String("log-dir") != "" {
+					if err := os.MkdirAll(c.String("log-dir"), 0755); err != nil {
+						return errors.Wrap(err, "create log dir")
+					}
+				}
+
+				printOption(c, []string{"file", "parallelism", "fail-fast", "log-dir", "report"})
+				args := []string{"--log-level", c.String("log-level")}
+				if c.String("config") != "" {
+					args = append(args, "--config", c.String("config"))
+				}
+				// The envs of commit options (e.g. CONTAINER) take precedence
+				// over --options-from, they are dropped so not to override
+				// the jobs, while the NYDUS_CLI_* envs of config are kept.
+				dropped := map[string]bool{}
+				for _, flag := range c.App.Command("commit").Flags {
+					if envFlag, ok := flag.(interface{ GetEnvVars() []string }); ok {
+						for _, env := range envFlag.GetEnvVars() {
+							dropped[env] = !strings.HasPrefix(env, "NYDUS_CLI_")
+						}
+					}
+				}
+				env := []string{}
+				for _, kv := range os.Environ() {
+					if name, _, _ := strings.Cut(kv, "="); !dropped[name] {
+						env = append(env, kv)
+					}
+				}
+				report := batch.Run(c.Context, jobs, batch.CommandRunner(executable, args, env), batch.Option{
+					Parallelism: c.Int("parallelism"),
+					FailFast:    c.Bool("fail-fast"),
+					LogDir:      c.String("log-dir"),
+					Output:      os.Stderr,
 				})
+				report.Print(os.Stdout)
+
+				if c.String("report") != "" {
+					file, err := os.OpenFile(c.String("report"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+					if err != nil {
+						return errors.Wrap(err, "create report file")
+					}
+					defer file.Close()
+					if err := report.WriteJSON(file); err != nil {
+						return errors.Wrap(err, "write report")
+					}
+				}
+
+				return report.Err()
 			},
 		},
-	}
+		{
+			Name:  "ps",
+			Usage: "List the running containers of nydus images with the size of upper dirs and the count of commits",
+			Flags: append([]cli.Flag{
+				&cli.IntFlag{
+					Name:        "parallelism",
+					DefaultText: "4",
+					Value:       4,
+					Usage:       "Maximum count of containers inspected concurrently, 0 means no limit",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the containers in JSON instead of a table",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
 
-	err := app.Run(os.Args)
-	if err != nil {
-		logrus.Fatal(err)
-	}
+				report, err := wf.Ps(c.Context, workflow.PsOption{
+					Parallelism: c.Int("parallelism"),
+				})
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					return report.WriteJSON(os.Stdout)
+				}
+				return report.Print(os.Stdout)
+			},
+		},
+		{
+			Name:         "diff",
+			Usage:        "List the paths added, modified and deleted in the upper dir of container, and optionally in its mount paths, which a commit would capture",
+			BashComplete: completeContainers,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "container",
+					Required: true,
+					Usage:    "The container to diff by id, name or unique id prefix, e.g. docker://<id>",
+					EnvVars:  []string{"CONTAINER"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "with-path",
+					Aliases: []string{"with-mount-path"},
+					Usage:   "The mount path listed as committed, or the path excluded if prefixed by !, same with commit command",
+					EnvVars: []string{"WITH_PATH"},
+				},
+				&cli.StringFlag{
+					Name:        "mount-strategy",
+					DefaultText: "auto",
+					Value:       "auto",
+					Usage:       "How the mount paths are copied, same with commit command",
+					EnvVars:     []string{"MOUNT_STRATEGY"},
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the changes in JSON",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				withPaths, withoutPaths := parsePaths(c, c.StringSlice("with-path"))
+				mountStrategy, err := workflow.ParseMountStrategy(c.String("mount-strategy"))
+				if err != nil {
+					return errors.Wrap(err, "parse mount strategy option")
+				}
+
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				cm, err := container.NewManager(&cfg.Base.Runtime)
+				if err != nil {
+					return errors.Wrap(err, "create container manager")
+				}
+				containerID, err := cm.Resolve(c.Context, c.String("container"))
+				if err != nil {
+					return errors.Wrap(err, "resolve container")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				report, err := wf.Diff(c.Context, workflow.DiffOption{
+					ContainerIDWithType: containerID,
+					WithPaths:           withPaths,
+					WithoutPaths:        withoutPaths,
+					MountStrategy:       mountStrategy,
+				})
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					return report.WriteJSON(os.Stdout)
+				}
+				return report.Print(os.Stdout)
+			},
+		},
+		{
+			Name:         "restore",
+			Usage:        "Extract the committed changes of an image into the upper dir of a running or stopped container",
+			BashComplete: completeContainers,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The committed nydus image reference to restore",
+					EnvVars:  []string{"TARGET"},
+				},
+				&cli.StringFlag{
+					Name:     "container",
+					Required: true,
+					Usage:    "The container to restore into by id, name or unique id prefix, e.g. docker://<id>, a stopped container is referenced by full id",
+					EnvVars:  []string{"CONTAINER"},
+				},
+				&cli.BoolFlag{
+					Name:    "pause-container",
+					Usage:   "Pause the running container while extracting",
+					EnvVars: []string{"PAUSE_CONTAINER"},
+				},
+				&cli.StringFlag{
+					Name:        "pause-mode",
+					DefaultText: "engine",
+					Value:       "engine",
+					Usage:       "How --pause-container pauses container, same with commit command",
+					EnvVars:     []string{"PAUSE_MODE"},
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				pauseMode, err := container.ParsePauseMode(c.String("pause-mode"))
+				if err != nil {
+					return errors.Wrap(err, "parse pause mode option")
+				}
+				// A restore canceled by signal returns through the cleanup
+				// of workflow which unpauses the container as commit.
+				ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				go func() {
+					<-ctx.Done()
+					stop()
+				}()
+
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				cm, err := container.NewManager(&cfg.Base.Runtime)
+				if err != nil {
+					return errors.Wrap(err, "create container manager")
+				}
+				containerID, err := cm.Resolve(ctx, c.String("container"))
+				if err != nil {
+					return errors.Wrap(err, "resolve container")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				printOption(c, []string{"target", "container", "pause-container", "pause-mode"})
+				return wf.Restore(ctx, workflow.RestoreOption{
+					ContainerIDWithType: containerID,
+					Target:              c.String("target"),
+					PauseContainer:      c.Bool("pause-container"),
+					PauseMode:           pauseMode,
+				})
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "Verify the blobs referenced by a nydus image in parallel",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The nydus image reference to check",
+				},
+				&cli.BoolFlag{
+					Name:  "shallow",
+					Usage: "Only check the existence and size of blobs, it's the default mode",
+				},
+				&cli.BoolFlag{
+					Name:  "deep",
+					Usage: "Fetch the content of blobs and check their digests, it's expensive for large images",
+				},
+				&cli.IntFlag{
+					Name:        "parallelism",
+					DefaultText: "8",
+					Value:       8,
+					Usage:       "Maximum count of blobs verified concurrently, 0 means no limit",
+					EnvVars:     []string{"PARALLELISM"},
+				},
+				&cli.StringFlag{
+					Name:  "trust-bundle",
+					Usage: "Verify the identity attesting the image by the SPIFFE trust bundle in the path",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "Write the results of blobs in JSON to the path",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				if c.Bool("deep") && c.Bool("shallow") {
+					return fmt.Errorf("options deep and shallow are mutually exclusive")
+				}
+				mode := workflow.CheckShallow
+				if c.Bool("deep") {
+					mode = workflow.CheckDeep
+				}
+
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				printOption(c, []string{"target", "deep", "parallelism", "trust-bundle", "report"})
+				report, err := wf.Check(c.Context, workflow.CheckOption{
+					Ref:         c.String("target"),
+					Mode:        mode,
+					Parallelism: c.Int("parallelism"),
+					TrustBundle: c.String("trust-bundle"),
+				})
+				if err != nil {
+					return err
+				}
+				report.Print(os.Stdout)
+
+				if c.String("report") != "" {
+					file, err := os.OpenFile(c.String("report"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+					if err != nil {
+						return errors.Wrap(err, "create report file")
+					}
+					defer file.Close()
+					if err := report.WriteJSON(file); err != nil {
+						return errors.Wrap(err, "write report")
+					}
+				}
+
+				return report.Err()
+			},
+		},
+		{
+			Name:  "compare",
+			Usage: "Compare the files and blobs of two nydus images, e.g. to verify a rebase or flatten preserved the files",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: true,
+					Usage:    "The nydus image reference compared from",
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The nydus image reference compared to",
+				},
+				&cli.StringFlag{
+					Name:        "nydusd",
+					DefaultText: "nydusd",
+					Value:       "nydusd",
+					Usage:       "The path of nydusd binary mounting the bootstraps",
+				},
+				&cli.BoolFlag{
+					Name:  "exit-code",
+					Usage: "Fail if the files differ, the differing blobs are not counted",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the differences in JSON",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				printOption(c, []string{"source", "target", "nydusd"})
+				report, err := wf.Compare(c.Context, workflow.CompareOption{
+					Source: c.String("source"),
+					Target: c.String("target"),
+					Nydusd: c.String("nydusd"),
+				})
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					err = report.WriteJSON(os.Stdout)
+				} else {
+					err = report.Print(os.Stdout)
+				}
+				if err != nil {
+					return err
+				}
+
+				if c.Bool("exit-code") {
+					return report.Err()
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "copy",
+			Usage: "Copy a nydus image with its bootstrap and blobs between registries or backends",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "source",
+					Required: true,
+					Usage:    "The nydus image reference copied from",
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The nydus image reference copied to",
+				},
+				&cli.StringFlag{
+					Name:  "source-config",
+					Usage: "The config file with the backend and annotations of source image, the same config as target if not set",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				var from *workflow.Workflow
+				if c.String("source-config") != "" {
+					sourceCfg, err := config.Parse(c, c.String("source-config"))
+					if err != nil {
+						return errors.Wrap(err, "parse source config file")
+					}
+					if from, err = workflow.NewWorkflow(sourceCfg); err != nil {
+						return errors.Wrap(err, "create source workflow")
+					}
+					defer func() {
+						if err := from.Destory(); err != nil {
+							logrus.WithError(err).Warn("destroy source workflow")
+						}
+					}()
+				}
+
+				printOption(c, []string{"source", "target", "source-config"})
+				return wf.Copy(c.Context, workflow.CopyOption{
+					Source: c.String("source"),
+					Target: c.String("target"),
+					From:   from,
+				})
+			},
+		},
+		{
+			Name:  "save",
+			Usage: "Save a nydus image with its blobs into an OCI image layout directory",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The nydus image reference saved",
+				},
+				&cli.StringFlag{
+					Name:     "output",
+					Required: true,
+					Usage:    "The OCI image layout directory saved to, an existing layout gets one more image",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				printOption(c, []string{"target", "output"})
+				return wf.Save(c.Context, workflow.SaveOption{
+					Ref:    c.String("target"),
+					Output: c.String("output"),
+				})
+			},
+		},
+		{
+			Name:  "load",
+			Usage: "Load a nydus image saved in an OCI image layout directory into a registry",
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:     "input",
+					Required: true,
+					Usage:    "The OCI image layout directory loaded from",
+				},
+				&cli.StringFlag{
+					Name:     "target",
+					Required: true,
+					Usage:    "The nydus image reference loaded to",
+				},
+				&cli.StringFlag{
+					Name:  "name",
+					Usage: "The reference the image is saved by, required if the layout has more than one image",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				printOption(c, []string{"input", "target", "name"})
+				return wf.Load(c.Context, workflow.LoadOption{
+					Input:  c.String("input"),
+					Name:   c.String("name"),
+					Target: c.String("target"),
+				})
+			},
+		},
+		{
+			Name:      "metadata",
+			Usage:     "Show the commit metadata attached to an image committed with --metadata",
+			ArgsUsage: "<image reference>",
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the metadata in JSON",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("requires one image reference")
+				}
+
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+				wf, err := workflow.NewWorkflow(cfg)
+				if err != nil {
+					return errors.Wrap(err, "create workflow")
+				}
+				defer func() {
+					if err := wf.Destory(); err != nil {
+						logrus.WithError(err).Warn("destroy workflow")
+					}
+				}()
+
+				metadata, err := wf.Metadata(c.Context, c.Args().First())
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					return metadata.WriteJSON(os.Stdout)
+				}
+				return metadata.Print(os.Stdout)
+			},
+		},
+		{
+			Name:         "debug-bundle",
+			Usage:        "Gather the workdir kept by a failed commit, container inspect output and environment info into a tarball",
+			BashComplete: completeContainers,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "path",
+					Usage: "The workdir kept by --keep-workdir, default to the latest one in --workdir",
+				},
+				&cli.StringFlag{
+					Name:  "container",
+					Usage: "The container to inspect by id, name or unique id prefix, e.g. docker://<id>",
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "The path of bundle tarball, default to nydus-cli-debug-<timestamp>.tar.gz",
+				},
+				workdirFlag,
+			}, runtimeFlags...),
+			Action: func(c *cli.Context) error {
+				opt := bundle.Option{Version: version}
+
+				opt.WorkDir = c.String("path")
+				if opt.WorkDir == "" {
+					workDir, err := bundle.LatestWorkDir(c.String("workdir"))
+					if err != nil {
+						logrus.WithError(err).Warn("skip workdir")
+					}
+					opt.WorkDir = workDir
+				}
+
+				if c.String("container") != "" {
+					runtime, err := config.ParseRuntime(c, c.String("config"))
+					if err != nil {
+						return errors.Wrap(err, "parse config file")
+					}
+					cm, err := container.NewManager(runtime)
+					if err != nil {
+						return errors.Wrap(err, "new container manager")
+					}
+					containerID, err := cm.Resolve(c.Context, c.String("container"))
+					if err != nil {
+						return errors.Wrap(err, "resolve container")
+					}
+					opt.Inspect, err = cm.InspectRaw(c.Context, containerID)
+					if err != nil {
+						return errors.Wrap(err, "inspect container")
+					}
+				}
+
+				output := c.String("output")
+				if output == "" {
+					output = fmt.Sprintf("nydus-cli-debug-%s.tar.gz", time.Now().Format("20060102150405"))
+				}
+				file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				if err != nil {
+					return errors.Wrap(err, "create bundle file")
+				}
+				defer file.Close()
+				if err := bundle.Write(file, opt); err != nil {
+					return errors.Wrap(err, "write bundle")
+				}
+				logrus.Infof("wrote debug bundle to %s", output)
+
+				return nil
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Generate or validate the configuration file",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "init",
+					Usage: "Write a commented template of configuration file with all settings",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "output",
+							Usage: "The path of configuration file, default to stdout",
+						},
+						&cli.BoolFlag{
+							Name:  "force",
+							Usage: "Overwrite the existing configuration file",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						output := c.String("output")
+						if output == "" {
+							_, err := fmt.Fprint(os.Stdout, config.Template)
+							return err
+						}
+						flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+						if c.Bool("force") {
+							flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+						}
+						file, err := os.OpenFile(output, flags, 0600)
+						if err != nil {
+							return errors.Wrap(err, "create config file")
+						}
+						defer file.Close()
+						if _, err := file.WriteString(config.Template); err != nil {
+							return errors.Wrap(err, "write config file")
+						}
+						logrus.Infof("wrote config template to %s", output)
+						return nil
+					},
+				},
+				{
+					Name:      "validate",
+					Usage:     "Strictly validate the configuration file overridden by NYDUS_CLI_* envs, e.g. unknown keys, missing required settings and suspicious credentials",
+					ArgsUsage: "[path, default to --config]",
+					Action: func(c *cli.Context) error {
+						path := c.Args().First()
+						if path == "" {
+							path = c.String("config")
+						}
+						var data []byte
+						if path != "" {
+							var err error
+							if data, err = os.ReadFile(path); err != nil {
+								return errors.Wrap(err, "read config file")
+							}
+						}
+
+						errs := 0
+						for _, problem := range config.Lint(data, os.LookupEnv) {
+							fmt.Println(problem)
+							if problem.Severity == config.SeverityError {
+								errs++
+							}
+						}
+						if errs > 0 {
+							return fmt.Errorf("found %d errors in config", errs)
+						}
+						fmt.Println("config is valid")
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "doctor",
+			Usage: "Check the environment of commits, i.e. the builder, container engines, kernel, workdir, registries and backend, and print a report",
+			Flags: append([]cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    "target",
+					Usage:   "Check the pull and push access of the registry of target image reference, repeatable",
+					EnvVars: []string{"TARGET"},
+				},
+				&cli.StringFlag{
+					Name:        "min-free",
+					DefaultText: "10GiB",
+					Value:       "10GiB",
+					Usage:       "The minimum available space of workdir",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "Write the report of checks in JSON to the path",
+				},
+			}, baseFlags...),
+			Action: func(c *cli.Context) error {
+				minFree, err := humanize.ParseBytes(c.String("min-free"))
+				if err != nil {
+					return errors.Wrap(err, "parse min-free")
+				}
+				cfg, err := config.Parse(c, c.String("config"))
+				if err != nil {
+					return errors.Wrap(err, "parse config file")
+				}
+
+				printOption(c, []string{"target", "min-free", "builder", "workdir", "report"})
+				report := workflow.Doctor(c.Context, cfg, workflow.DoctorOption{
+					Targets: c.StringSlice("target"),
+					MinFree: minFree,
+				})
+				report.Print(os.Stdout)
+
+				if c.String("report") != "" {
+					file, err := os.OpenFile(c.String("report"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+					if err != nil {
+						return errors.Wrap(err, "create report file")
+					}
+					defer file.Close()
+					if err := report.WriteJSON(file); err != nil {
+						return errors.Wrap(err, "write report")
+					}
+				}
+
+				return report.Err()
+			},
+		},
+		{
+			Name:  "selftest",
+			Usage: "Qualify the node by committing containers against a local registry or OSS in parallel, and verifying the committed images mounted by nydusd",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:        "containers",
+					DefaultText: "4",
+					Value:       4,
+					Usage:       "The count of containers committed in parallel",
+				},
+				&cli.StringFlag{
+					Name:        "backend",
+					DefaultText: harness.BackendRegistry,
+					Value:       harness.BackendRegistry,
+					Usage:       "The backend of blobs, one of `registry` and `oss`",
+				},
+				&cli.StringFlag{
+					Name:        "nydusd",
+					DefaultText: "nydusd",
+					Value:       "nydusd",
+					Usage:       "The path of nydusd binary",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "Write the report of checks and cases in JSON to the path",
+				},
+				workdirFlag,
+				builderFlag,
+			},
+			Action: func(c *cli.Context) error {
+				printOption(c, []string{"containers", "backend", "nydusd", "builder", "workdir", "report"})
+				report := harness.Qualify(c.Context, harness.Config{
+					WorkDir:    c.String("workdir"),
+					Containers: c.Int("containers"),
+					Backend:    c.String("backend"),
+					Builder:    c.String("builder"),
+					Nydusd:     c.String("nydusd"),
+					Output:     os.Stderr,
+				})
+				report.Print(os.Stdout)
+
+				if c.String("report") != "" {
+					file, err := os.OpenFile(c.String("report"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+					if err != nil {
+						return errors.Wrap(err, "create report file")
+					}
+					defer file.Close()
+					if err := report.WriteJSON(file); err != nil {
+						return errors.Wrap(err, "write report")
+					}
+				}
+
+				return report.Err()
+			},
+		},
+		{
+			Name:      "completion",
+			Usage:     "Print the shell completion script, e.g. `source <(nydus-cli completion bash)`",
+			ArgsUsage: strings.Join(completion.Shells, "|"),
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return fmt.Errorf("requires one shell of %s", strings.Join(completion.Shells, ", "))
+				}
+				script, err := completion.Script(c.Args().First())
+				if err != nil {
+					return err
+				}
+				_, err = fmt.Fprint(c.App.Writer, script)
+				return err
+			},
+		},
+	}
+
+	return app
 }
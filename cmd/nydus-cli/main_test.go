@@ -0,0 +1,121 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/doctor"
+)
+
+// runApp runs the command line `args` of app, the `%s` in args is the host
+// of a plain HTTP registry which has no image, and returns the paths
+// requested to it.
+func runApp(t *testing.T, args ...string) ([]string, error) {
+	var mu sync.Mutex
+	paths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(configPath, []byte(fmt.Sprintf("registries:\n  %s:\n    insecure: \"true\"\n", host)), 0644))
+
+	cmdline := []string{"nydus-cli", "--config", configPath}
+	for _, arg := range args {
+		if strings.Contains(arg, "%s") {
+			arg = fmt.Sprintf(arg, host)
+		}
+		cmdline = append(cmdline, arg)
+	}
+	err := newApp("test").Run(cmdline)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return paths, err
+}
+
+func TestCheckCommand(t *testing.T) {
+	paths, err := runApp(t, "check", "--workdir", t.TempDir(), "--target", "%s/app:nydus")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "parse config")
+	// The config is parsed and the image is resolved from registry.
+	require.Contains(t, paths, "/v2/app/manifests/nydus")
+}
+
+func TestMetadataCommand(t *testing.T) {
+	paths, err := runApp(t, "metadata", "--workdir", t.TempDir(), "%s/app:nydus")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "parse config")
+	require.Contains(t, paths, "/v2/app/manifests/nydus")
+}
+
+func TestCompareCommand(t *testing.T) {
+	paths, err := runApp(t, "compare", "--workdir", t.TempDir(), "--source", "%s/app:v1", "--target", "%s/app:v2")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "parse config")
+	require.Contains(t, paths, "/v2/app/manifests/v1")
+}
+
+func TestCopyCommand(t *testing.T) {
+	paths, err := runApp(t, "copy", "--workdir", t.TempDir(), "--source", "%s/app:v1", "--target", "%s/copy:v1")
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "parse config")
+	require.Contains(t, paths, "/v2/app/manifests/v1")
+}
+
+func TestSaveCommand(t *testing.T) {
+	paths, err := runApp(t, "save", "--workdir", t.TempDir(), "--target", "%s/app:v1", "--output", t.TempDir())
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "parse config")
+	require.Contains(t, paths, "/v2/app/manifests/v1")
+}
+
+func TestLoadCommand(t *testing.T) {
+	_, err := runApp(t, "load", "--workdir", t.TempDir(), "--input", filepath.Join(t.TempDir(), "layout"), "--target", "%s/app:v1")
+	require.Error(t, err)
+	// The config is parsed and the layout is read.
+	require.Contains(t, err.Error(), "open image layout")
+}
+
+func TestSelftestCommand(t *testing.T) {
+	// The builder is found in PATH by default, and nydusd is missing so
+	// only the preflight checks are run.
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "nydus-image"), []byte("#!/bin/sh\necho 'Version: v2.2.4'\n"), 0755))
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+	workDir := t.TempDir()
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	_, err := runApp(t, "selftest", "--workdir", workDir, "--nydusd", filepath.Join(binDir, "nydusd"), "--report", reportPath)
+	require.Error(t, err)
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	report := doctor.Report{}
+	require.NoError(t, json.Unmarshal(data, &report))
+	results := map[string]doctor.Result{}
+	for _, result := range report.Results {
+		results[result.Name] = result
+	}
+	require.True(t, results["builder"].Passed, results["builder"].Error)
+	require.Contains(t, results["builder"].Detail, filepath.Join(binDir, "nydus-image"))
+	require.False(t, results["nydusd"].Passed)
+	require.True(t, results["workdir"].Passed, results["workdir"].Error)
+}
@@ -14,3 +14,27 @@ type Backend interface {
 	Pull(blobDigest digest.Digest) (io.ReadCloser, error)
 	External() bool
 }
+
+// Aborter is implemented by backends which can abort the uploads still
+// in progress, e.g. the multipart uploads of OSS.
+type Aborter interface {
+	Abort() error
+}
+
+// StreamPusher is implemented by backends which can push blob of unknown
+// digest and size, both are calculated on the fly while uploading.
+type StreamPusher interface {
+	PushStream(ctx context.Context, reader io.Reader) (digest.Digest, int64, error)
+}
+
+// Stater is implemented by backends which can get the size of blob without
+// fetching its content.
+type Stater interface {
+	Stat(ctx context.Context, blobDigest digest.Digest) (int64, error)
+}
+
+// BytesPusher is implemented by backends which can push the small blob in
+// memory by fewer requests than Push.
+type BytesPusher interface {
+	PushBytes(ctx context.Context, data []byte, desc ocispec.Descriptor) error
+}
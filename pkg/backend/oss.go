@@ -7,15 +7,26 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	goerrors "errors"
 	"fmt"
 	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"unsafe"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/nydusaccelerator/nydus-cli/pkg/config"
@@ -28,6 +39,10 @@ type OSSBackend struct {
 	objectPrefix string
 	bucket       *oss.Bucket
 	forcePush    bool
+
+	// The multipart uploads in progress, keyed by upload id.
+	uploads      map[string]oss.InitiateMultipartUploadResult
+	uploadsMutex sync.Mutex
 }
 
 func NewOSSBackend(cfg *config.OSS, forcePush bool) (*OSSBackend, error) {
@@ -43,10 +58,29 @@ func NewOSSBackend(cfg *config.OSS, forcePush bool) (*OSSBackend, error) {
 	accessKeySecret := cfg.AccessKeySecret
 	objectPrefix := cfg.ObjectPrefix
 
-	client, err := oss.New(endpoint, accessKeyID, accessKeySecret)
+	// OSS client doesn't honor the proxy environment variables itself.
+	proxyFunc, err := remote.NewProxyFunc(cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	proxyURL, err := remote.ProxyURL(proxyFunc, endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "get proxy of endpoint")
+	}
+	options := []oss.ClientOption{}
+	if proxyURL != nil {
+		options = append(options, oss.Proxy(proxyURL.String()))
+	}
+
+	client, err := oss.New(endpoint, accessKeyID, accessKeySecret, options...)
 	if err != nil {
 		return nil, errors.Wrap(err, "Create client")
 	}
+	if logrus.IsLevelEnabled(logrus.TraceLevel) {
+		if err := traceOSSClient(client); err != nil {
+			logrus.WithError(err).Warn("trace oss requests")
+		}
+	}
 
 	bucket, err := client.Bucket(bucketName)
 	if err != nil {
@@ -57,9 +91,27 @@ func NewOSSBackend(cfg *config.OSS, forcePush bool) (*OSSBackend, error) {
 		objectPrefix: objectPrefix,
 		bucket:       bucket,
 		forcePush:    forcePush,
+		uploads:      map[string]oss.InitiateMultipartUploadResult{},
 	}, nil
 }
 
+// traceOSSClient wraps the transport of HTTP client built by OSS SDK with
+// the tracing transport, so the requests behave the same with tracing. The
+// SDK doesn't expose its client, which is read from the unexported field of
+// the SDK version in go.mod.
+func traceOSSClient(client *oss.Client) error {
+	field := reflect.ValueOf(client.Conn).Elem().FieldByName("client")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(&http.Client{}) {
+		return fmt.Errorf("http client not found in oss sdk")
+	}
+	httpClient := *(**http.Client)(unsafe.Pointer(field.UnsafeAddr()))
+	if httpClient == nil {
+		return fmt.Errorf("http client of oss sdk is not initialized")
+	}
+	httpClient.Transport = remote.NewTracingTransport(httpClient.Transport)
+	return nil
+}
+
 // Ported from https://github.com/aliyun/aliyun-oss-go-sdk/blob/v2.2.6/oss/utils.go#L259
 func splitFileByPartSize(blobSize, chunkSize int64) ([]oss.FileChunk, error) {
 	if chunkSize <= 0 {
@@ -110,13 +162,16 @@ func (b *OSSBackend) push(ctx context.Context, ra content.ReaderAt, desc ocispec
 	if err != nil {
 		return errors.Wrap(err, "initiate multipart upload")
 	}
+	untrack := b.trackUpload(imur)
+	defer untrack()
+
 	partsChan := make(chan oss.UploadPart, len(chunks))
 
 	g := new(errgroup.Group)
 	for _, chunk := range chunks {
 		ck := chunk
 		g.Go(func() error {
-			return remote.WithRetry(func() error {
+			return remote.WithRetryUpload("upload part to oss", ck.Size, func() error {
 				p, err := b.bucket.UploadPart(imur, io.NewSectionReader(ra, ck.Offset, ck.Size), ck.Size, ck.Number)
 				if err != nil {
 					return errors.Wrap(err, "upload part")
@@ -148,17 +203,150 @@ func (b *OSSBackend) push(ctx context.Context, ra content.ReaderAt, desc ocispec
 }
 
 func (b *OSSBackend) Push(ctx context.Context, ra content.ReaderAt, desc ocispec.Descriptor) error {
-	return remote.WithRetry(func() error {
+	return remote.WithRetryUpload("push blob to oss", desc.Size, func() error {
 		return b.push(ctx, ra, desc)
 	})
 }
 
+// PushBytes uploads the small blob `data` by a single put object request
+// instead of multipart upload.
+func (b *OSSBackend) PushBytes(ctx context.Context, data []byte, desc ocispec.Descriptor) error {
+	return remote.WithRetryUpload("push small blob to oss", desc.Size, func() error {
+		blobObjectKey := b.objectPrefix + desc.Digest.Hex()
+		if exist, err := b.bucket.IsObjectExist(blobObjectKey); err != nil {
+			return errors.Wrap(err, "check object existence")
+		} else if exist && !b.forcePush {
+			return nil
+		}
+		if err := b.bucket.PutObject(blobObjectKey, bytes.NewReader(data)); err != nil {
+			return errors.Wrap(err, "put object")
+		}
+		return nil
+	})
+}
+
+// The part size of stream push, parts are buffered in memory for retrying.
+const streamPartSize int64 = 32 * 1024 * 1024
+
+func (b *OSSBackend) trackUpload(imur oss.InitiateMultipartUploadResult) func() {
+	b.uploadsMutex.Lock()
+	b.uploads[imur.UploadID] = imur
+	b.uploadsMutex.Unlock()
+	return func() {
+		b.uploadsMutex.Lock()
+		delete(b.uploads, imur.UploadID)
+		b.uploadsMutex.Unlock()
+	}
+}
+
+// PushStream uploads blob to a temporary object by multipart upload, then
+// copies it to the object named by the digest calculated on the fly.
+func (b *OSSBackend) PushStream(ctx context.Context, reader io.Reader) (digest.Digest, int64, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", 0, errors.Wrap(err, "generate temporary object key")
+	}
+	tempKey := b.objectPrefix + "uploading-" + hex.EncodeToString(random)
+	imur, err := b.bucket.InitiateMultipartUpload(tempKey)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "initiate multipart upload")
+	}
+	untrack := b.trackUpload(imur)
+	defer untrack()
+
+	digester := digest.Canonical.Digester()
+	reader = io.TeeReader(reader, digester.Hash())
+	buf := make([]byte, streamPartSize)
+	parts := []oss.UploadPart{}
+	var size int64
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			_ = b.bucket.AbortMultipartUpload(imur)
+			return "", 0, errors.Wrap(err, "read blob")
+		}
+		if n == 0 && number > 1 {
+			break
+		}
+		if err := remote.WithRetryUpload("upload part to oss", int64(n), func() error {
+			part, err := b.bucket.UploadPart(imur, bytes.NewReader(buf[:n]), int64(n), number)
+			if err != nil {
+				return errors.Wrap(err, "upload part")
+			}
+			parts = append(parts, part)
+			return nil
+		}); err != nil {
+			_ = b.bucket.AbortMultipartUpload(imur)
+			return "", 0, err
+		}
+		size += int64(n)
+		if n < len(buf) {
+			break
+		}
+	}
+	if _, err := b.bucket.CompleteMultipartUpload(imur, parts); err != nil {
+		return "", 0, errors.Wrap(err, "complete multipart upload")
+	}
+	defer func() {
+		if err := b.bucket.DeleteObject(tempKey); err != nil {
+			logrus.WithError(err).Warnf("delete temporary object %s", tempKey)
+		}
+	}()
+
+	blobDigest := digester.Digest()
+	blobObjectKey := b.objectPrefix + blobDigest.Hex()
+	if exist, err := b.bucket.IsObjectExist(blobObjectKey); err != nil {
+		return "", 0, errors.Wrap(err, "check object existence")
+	} else if exist && !b.forcePush {
+		return blobDigest, size, nil
+	}
+	if err := b.bucket.CopyFile(b.bucket.BucketName, tempKey, blobObjectKey, remote.ChunkSize); err != nil {
+		return "", 0, errors.Wrap(err, "copy temporary object")
+	}
+
+	return blobDigest, size, nil
+}
+
 func (b *OSSBackend) Pull(blobDigest digest.Digest) (io.ReadCloser, error) {
 	blobID := blobDigest.Hex()
 	blobObjectKey := b.objectPrefix + blobID
 	return b.bucket.GetObject(blobObjectKey)
 }
 
+func (b *OSSBackend) Stat(ctx context.Context, blobDigest digest.Digest) (int64, error) {
+	blobObjectKey := b.objectPrefix + blobDigest.Hex()
+	meta, err := b.bucket.GetObjectDetailedMeta(blobObjectKey)
+	if err != nil {
+		var serviceErr oss.ServiceError
+		if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+			return 0, errors.Wrapf(errdefs.ErrNotFound, "object %s", blobObjectKey)
+		}
+		return 0, errors.Wrapf(err, "get meta of object %s", blobObjectKey)
+	}
+	size, err := strconv.ParseInt(meta.Get(oss.HTTPHeaderContentLength), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse size of object %s", blobObjectKey)
+	}
+	return size, nil
+}
+
+// Abort aborts all multipart uploads in progress, so that the uploaded
+// parts are not left in bucket.
+func (b *OSSBackend) Abort() error {
+	b.uploadsMutex.Lock()
+	defer b.uploadsMutex.Unlock()
+
+	var errs []error
+	for uploadID, imur := range b.uploads {
+		if err := b.bucket.AbortMultipartUpload(imur); err != nil {
+			errs = append(errs, errors.Wrapf(err, "abort multipart upload %s of %s", uploadID, imur.Key))
+		}
+		delete(b.uploads, uploadID)
+	}
+
+	return goerrors.Join(errs...)
+}
+
 func (b *OSSBackend) External() bool {
 	return true
 }
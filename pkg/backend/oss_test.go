@@ -0,0 +1,50 @@
+package backend
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceOSSClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/slow") {
+			time.Sleep(2 * time.Second)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := logrus.StandardLogger()
+	output, level := logger.Out, logger.GetLevel()
+	defer func() {
+		logger.SetOutput(output)
+		logger.SetLevel(level)
+	}()
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.TraceLevel)
+
+	// The response header timeout of SDK is 1s.
+	client, err := oss.New(server.URL, "id", "secret", oss.Timeout(1, 1))
+	require.NoError(t, err)
+	require.NoError(t, traceOSSClient(client))
+	bucket, err := client.Bucket("nydus")
+	require.NoError(t, err)
+
+	_, err = bucket.GetObjectMeta("blob")
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "http request")
+	require.Contains(t, buf.String(), "/nydus/blob")
+
+	// The transport of SDK is kept with tracing.
+	_, err = bucket.GetObjectMeta("slow")
+	require.ErrorContains(t, err, "timeout")
+}
@@ -0,0 +1,197 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// The actions of plugin, passed as the last argument of plugin command.
+// Each action reads a request of PluginRequest in one line of JSON from
+// stdin, and exits with non-zero code on failure with the message in
+// stderr, like the credential helpers of docker.
+const (
+	// PluginActionPush reads the blob content of request size following
+	// the request line, and stores it.
+	PluginActionPush = "push"
+	// PluginActionPull writes the blob content to stdout.
+	PluginActionPull = "pull"
+	// PluginActionStat writes a PluginStatResponse in JSON to stdout.
+	PluginActionStat = "stat"
+)
+
+// PluginRequest is the request of plugin action.
+type PluginRequest struct {
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size,omitempty"`
+	MediaType string        `json:"media_type,omitempty"`
+}
+
+// PluginStatResponse is the response of stat action.
+type PluginStatResponse struct {
+	Exists bool  `json:"exists"`
+	Size   int64 `json:"size"`
+}
+
+// maxPluginStderr is the bytes of plugin stderr kept for error.
+const maxPluginStderr = 4096
+
+// Plugin pushes and pulls the blobs by exec plugin, so the blobs can be
+// stored in proprietary storage without forking, the blobs are referenced
+// by blob ids annotation as the other external backends.
+type Plugin struct {
+	command string
+	args    []string
+}
+
+func NewPluginBackend(cfg *config.Backend) (*Plugin, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("plugin `command` field is required")
+	}
+	return &Plugin{
+		command: cfg.Command,
+		args:    cfg.Args,
+	}, nil
+}
+
+// limitedBuffer keeps the first bytes written to it up to limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (p *Plugin) cmd(ctx context.Context, action string, req PluginRequest, stdin io.Reader) (*exec.Cmd, *limitedBuffer, error) {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "marshal plugin request")
+	}
+	readers := []io.Reader{bytes.NewReader(append(line, '\n'))}
+	if stdin != nil {
+		readers = append(readers, stdin)
+	}
+	args := append(append([]string{}, p.args...), action)
+	cmd := exec.CommandContext(ctx, p.command, args...)
+	stderr := &limitedBuffer{limit: maxPluginStderr}
+	cmd.Stdin = io.MultiReader(readers...)
+	cmd.Stderr = stderr
+	return cmd, stderr, nil
+}
+
+func (p *Plugin) run(ctx context.Context, action string, req PluginRequest, stdin io.Reader) ([]byte, error) {
+	cmd, stderr, err := p.cmd(ctx, action, req, stdin)
+	if err != nil {
+		return nil, err
+	}
+	stdout := bytes.Buffer{}
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "run plugin %s %s: %s", p.command, action, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *Plugin) Push(ctx context.Context, ra content.ReaderAt, desc ocispec.Descriptor) error {
+	req := PluginRequest{Digest: desc.Digest, Size: ra.Size(), MediaType: desc.MediaType}
+	if _, err := p.run(ctx, PluginActionPush, req, content.NewReader(ra)); err != nil {
+		return errors.Wrap(err, "push blob")
+	}
+	return nil
+}
+
+// pluginReader reads the blob from stdout of plugin, the failure of
+// plugin is returned instead of EOF.
+type pluginReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr *limitedBuffer
+	waited bool
+	err    error
+}
+
+func (r *pluginReader) wait() error {
+	if !r.waited {
+		r.waited = true
+		if err := r.cmd.Wait(); err != nil {
+			r.err = errors.Wrapf(err, "run plugin %s %s: %s", r.cmd.Path, PluginActionPull, strings.TrimSpace(r.stderr.String()))
+		}
+	}
+	return r.err
+}
+
+func (r *pluginReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *pluginReader) Close() error {
+	r.ReadCloser.Close()
+	if r.cmd.Process != nil && !r.waited {
+		// The plugin is killed if the blob isn't read to the end.
+		_ = r.cmd.Process.Kill()
+		_ = r.wait()
+		return nil
+	}
+	return nil
+}
+
+func (p *Plugin) Pull(blobDigest digest.Digest) (io.ReadCloser, error) {
+	cmd, stderr, err := p.cmd(context.Background(), PluginActionPull, PluginRequest{Digest: blobDigest}, nil)
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "create stdout pipe of plugin")
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "start plugin %s", p.command)
+	}
+	return &pluginReader{ReadCloser: stdout, cmd: cmd, stderr: stderr}, nil
+}
+
+func (p *Plugin) Stat(ctx context.Context, blobDigest digest.Digest) (int64, error) {
+	output, err := p.run(ctx, PluginActionStat, PluginRequest{Digest: blobDigest}, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "stat blob")
+	}
+	resp := PluginStatResponse{}
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return 0, errors.Wrap(err, "unmarshal plugin stat response")
+	}
+	if !resp.Exists {
+		return 0, errors.Wrapf(errdefs.ErrNotFound, "blob %s", blobDigest)
+	}
+	return resp.Size, nil
+}
+
+func (p *Plugin) External() bool {
+	return true
+}
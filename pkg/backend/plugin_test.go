@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// bytesReaderAt is the content.ReaderAt of bytes.
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (r bytesReaderAt) Close() error {
+	return nil
+}
+
+// pluginScript stores the blobs in dir of first argument, keyed by the
+// digest in request.
+const pluginScript = `#!/bin/sh
+dir=$1
+read -r req
+id=$(echo "$req" | sed 's/.*"digest":"sha256:\([0-9a-f]*\)".*/\1/')
+case "$2" in
+push)
+	cat > "$dir/$id"
+	;;
+pull)
+	if [ ! -f "$dir/$id" ]; then
+		echo "blob $id not found" >&2
+		exit 1
+	fi
+	cat "$dir/$id"
+	;;
+stat)
+	if [ -f "$dir/$id" ]; then
+		echo "{\"exists\": true, \"size\": $(wc -c < "$dir/$id")}"
+	else
+		echo '{"exists": false}'
+	fi
+	;;
+*)
+	echo "unknown action $2" >&2
+	exit 1
+	;;
+esac
+`
+
+func TestPluginBackend(t *testing.T) {
+	plugin := filepath.Join(t.TempDir(), "plugin")
+	require.NoError(t, os.WriteFile(plugin, []byte(pluginScript), 0755))
+	storage := t.TempDir()
+
+	_, err := NewPluginBackend(&config.Backend{Type: config.BackendTypePlugin})
+	require.Error(t, err)
+	be, err := NewPluginBackend(&config.Backend{Type: config.BackendTypePlugin, Command: plugin, Args: []string{storage}})
+	require.NoError(t, err)
+	require.True(t, be.External())
+
+	ctx := context.Background()
+	data := []byte("blob content")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	_, err = be.Stat(ctx, desc.Digest)
+	require.True(t, errdefs.IsNotFound(err))
+
+	require.NoError(t, be.Push(ctx, bytesReaderAt{bytes.NewReader(data)}, desc))
+	size, err := be.Stat(ctx, desc.Digest)
+	require.NoError(t, err)
+	require.Equal(t, desc.Size, size)
+
+	reader, err := be.Pull(desc.Digest)
+	require.NoError(t, err)
+	pulled, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+	require.Equal(t, data, pulled)
+
+	reader, err = be.Pull(digest.FromString("missing"))
+	require.NoError(t, err)
+	_, err = io.ReadAll(reader)
+	require.ErrorContains(t, err, "not found")
+	require.NoError(t, reader.Close())
+}
@@ -2,7 +2,9 @@ package backend
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"path/filepath"
 
 	"github.com/containerd/containerd/content"
 	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
@@ -12,13 +14,15 @@ import (
 )
 
 type Registry struct {
-	remote *remote.Remote
+	remote    *remote.Remote
+	hostsFunc remote.HostsFunc
+	// checkpointDir keeps the checkpoints of resumable uploads.
+	checkpointDir string
 }
 
 func (r *Registry) push(ctx context.Context, ra content.ReaderAt, desc ocispec.Descriptor) error {
 	if err := r.remote.Push(ctx, desc, true, io.NewSectionReader(ra, 0, ra.Size())); err != nil {
-		if remote.RetryWithHTTP(err) {
-			r.remote.MaybeWithHTTP(err)
+		if r.remote.MaybeWithHTTP(err) {
 			if err := r.remote.Push(ctx, desc, true, io.NewSectionReader(ra, 0, ra.Size())); err != nil {
 				return errors.Wrap(err, "push blob")
 			}
@@ -30,21 +34,63 @@ func (r *Registry) push(ctx context.Context, ra content.ReaderAt, desc ocispec.D
 }
 
 func (r *Registry) Push(ctx context.Context, ra content.ReaderAt, desc ocispec.Descriptor) error {
-	return remote.WithRetry(func() error {
+	if desc.Size >= remote.ChunkedUploadThreshold {
+		// A failed attempt resumes from the last acknowledged chunk, so at
+		// most a chunk is uploaded again.
+		checkpointPath := filepath.Join(r.checkpointDir, fmt.Sprintf("upload-%s.json", desc.Digest.Encoded()))
+		return remote.WithRetryUpload("push blob to registry by chunks", remote.StreamChunkSize, func() error {
+			return r.remote.PushChunked(ctx, r.hostsFunc, ra, desc, checkpointPath)
+		})
+	}
+	return remote.WithRetryUpload("push blob to registry", desc.Size, func() error {
 		return r.push(ctx, ra, desc)
 	})
 }
 
+// PushBytes pushes the small blob `data` by a monolithic upload.
+func (r *Registry) PushBytes(ctx context.Context, data []byte, desc ocispec.Descriptor) error {
+	return remote.WithRetryUpload("push small blob to registry", desc.Size, func() error {
+		if err := r.remote.PushBytes(ctx, r.hostsFunc, data, desc); err != nil {
+			return errors.Wrap(err, "push small blob")
+		}
+		return nil
+	})
+}
+
+// PushStream pushes blob by chunked upload without retry, as the consumed
+// stream can't be read again.
+func (r *Registry) PushStream(ctx context.Context, reader io.Reader) (digest.Digest, int64, error) {
+	blobDigest, size, err := r.remote.PushStream(ctx, r.hostsFunc, reader)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "push blob stream")
+	}
+	return blobDigest, size, nil
+}
+
 func (r *Registry) Pull(blobDigest digest.Digest) (io.ReadCloser, error) {
-	panic("not implemented")
+	reader, err := r.remote.Pull(context.Background(), ocispec.Descriptor{Digest: blobDigest}, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull blob")
+	}
+	return reader, nil
+}
+
+func (r *Registry) Stat(ctx context.Context, blobDigest digest.Digest) (int64, error) {
+	size, err := r.remote.Stat(ctx, r.hostsFunc, blobDigest)
+	if err != nil {
+		return 0, errors.Wrap(err, "stat blob")
+	}
+	return size, nil
 }
 
 func (r *Registry) External() bool {
 	return false
 }
 
-func NewRegistryBackend(remote *remote.Remote) (*Registry, error) {
+func NewRegistryBackend(remote *remote.Remote, hostsFunc remote.HostsFunc, checkpointDir string) (*Registry, error) {
 	return &Registry{
-		remote: remote,
+		remote:        remote,
+		hostsFunc:     hostsFunc,
+		checkpointDir: checkpointDir,
 	}, nil
 }
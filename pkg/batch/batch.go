@@ -0,0 +1,324 @@
+// Package batch runs the commit jobs described in a batch file with
+// bounded parallelism, and aggregates their results into a report.
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v2"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// namePattern is the pattern of job names, which are used in the names of
+// log files.
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Job is a commit job in batch file.
+type Job struct {
+	Name string
+	// Options are the options of commit command keyed by flag names, in
+	// the format of --options-from.
+	Options map[string]interface{}
+}
+
+// File is the batch file, e.g.
+//
+//	jobs:
+//	  - name: web-0
+//	    container: docker://<id>
+//	    target: registry.example.com/web:{{.Date}}
+//	    with-path: [/data]
+type File struct {
+	Jobs []map[string]interface{} `yaml:"jobs"`
+}
+
+// ParseJobs parses the jobs in batch file `data` in JSON or YAML, the
+// job without name is named by its index, e.g. `job-0`.
+func ParseJobs(data []byte) ([]Job, error) {
+	file := File{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrap(err, "parse batch file")
+	}
+	if len(file.Jobs) == 0 {
+		return nil, fmt.Errorf("no job in batch file")
+	}
+
+	jobs := []Job{}
+	names := map[string]bool{}
+	for idx, options := range file.Jobs {
+		job := Job{Name: fmt.Sprintf("job-%d", idx), Options: map[string]interface{}{}}
+		for key, value := range options {
+			if key == "name" {
+				job.Name = fmt.Sprint(value)
+				continue
+			}
+			job.Options[key] = value
+		}
+		if !namePattern.MatchString(job.Name) {
+			return nil, fmt.Errorf("invalid job name %q, must match %s", job.Name, namePattern)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("duplicated job name %s", job.Name)
+		}
+		names[job.Name] = true
+		if _, err := job.options(); err != nil {
+			return nil, errors.Wrapf(err, "job %s", job.Name)
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// options returns the options document of job for --options-from.
+func (job *Job) options() ([]byte, error) {
+	data, err := yaml.Marshal(job.Options)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal options")
+	}
+	if _, err := config.ParseOptions(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Runner runs `job` with its output written to `output`.
+type Runner func(ctx context.Context, job Job, output io.Writer) error
+
+// CommandRunner runs each job by the commit command of nydus-cli
+// `executable` in a child process, with `args` (the global flags)
+// followed by `commit --options-from -` and envs `env`, so the jobs don't
+// share the process-wide state, e.g. the logger.
+func CommandRunner(executable string, args, env []string) Runner {
+	return func(ctx context.Context, job Job, output io.Writer) error {
+		options, err := job.options()
+		if err != nil {
+			return err
+		}
+		cmdArgs := append(append([]string{}, args...), "commit", "--options-from", "-")
+		cmd := exec.CommandContext(ctx, executable, cmdArgs...)
+		cmd.Env = env
+		cmd.Stdin = bytes.NewReader(options)
+		cmd.Stdout = output
+		cmd.Stderr = output
+		return cmd.Run()
+	}
+}
+
+// Option is the option of Run.
+type Option struct {
+	// Parallelism bounds the count of concurrent jobs, 0 means no limit.
+	Parallelism int
+	// FailFast skips the jobs not started yet once a job failed, the jobs
+	// are all run by default.
+	FailFast bool
+	// LogDir is the directory where the output of each job is written to
+	// `<name>.log`, the output is written to Output with the job name
+	// prefixed to each line if empty.
+	LogDir string
+	Output io.Writer
+}
+
+// Result is the result of a job.
+type Result struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// ExitCode is the exit code of failed commit command, which tells the
+	// class of failure, see workflow.ExitCodes.
+	ExitCode int           `json:"exit_code,omitempty"`
+	Log      string        `json:"log,omitempty"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// Report is the results of jobs in the order of batch file.
+type Report struct {
+	Total   int      `json:"total"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Results []Result `json:"results"`
+}
+
+// Err returns an error summarizing the failed jobs, or nil if all passed.
+func (r *Report) Err() error {
+	if r.Passed == r.Total {
+		return nil
+	}
+	failures := []string{}
+	for _, result := range r.Results {
+		if !result.Passed && !result.Skipped {
+			failures = append(failures, result.Name)
+		}
+	}
+	return fmt.Errorf("%d of %d jobs failed (%s), %d skipped", r.Failed, r.Total, strings.Join(failures, ", "), r.Skipped)
+}
+
+// Print writes the results of jobs to `writer` in a line per job.
+func (r *Report) Print(writer io.Writer) {
+	for _, result := range r.Results {
+		status := "PASS"
+		if result.Skipped {
+			status = "SKIP"
+		} else if !result.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s", status, result.Name, result.Elapsed.Round(time.Millisecond))
+		if result.Error != "" {
+			fmt.Fprintf(writer, "\t%s", result.Error)
+		}
+		fmt.Fprintln(writer)
+	}
+	fmt.Fprintf(writer, "total: %d, passed: %d, failed: %d, skipped: %d\n", r.Total, r.Passed, r.Failed, r.Skipped)
+}
+
+// WriteJSON writes the report in JSON to `writer`.
+func (r *Report) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// lineWriter writes the lines to the underlying writer with a prefix, and
+// remembers the last non-empty line.
+type lineWriter struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	prefix string
+	buf    []byte
+	last   string
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx < 0 {
+			break
+		}
+		if err := w.writeLine(w.buf[:idx]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) writeLine(line []byte) error {
+	if trimmed := strings.TrimSpace(string(line)); trimmed != "" {
+		w.last = trimmed
+	}
+	if w.writer == nil {
+		return nil
+	}
+	// The lines of concurrent jobs are not interleaved.
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err := fmt.Fprintf(w.writer, "%s%s\n", w.prefix, line)
+	return err
+}
+
+// flush writes the last line without trailing newline.
+func (w *lineWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Run runs `jobs` by `run`, a failed job doesn't stop the others unless
+// FailFast is set, the results are returned in report.
+func Run(ctx context.Context, jobs []Job, run Runner, opt Option) *Report {
+	report := &Report{
+		Total:   len(jobs),
+		Results: make([]Result, len(jobs)),
+	}
+	// The running jobs are not canceled by FailFast.
+	var failed atomic.Bool
+	mutex := sync.Mutex{}
+	eg := errgroup.Group{}
+	if opt.Parallelism > 0 {
+		eg.SetLimit(opt.Parallelism)
+	}
+	for idx := range jobs {
+		idx := idx
+		eg.Go(func() error {
+			job := jobs[idx]
+			result := &report.Results[idx]
+			result.Name = job.Name
+			if ctx.Err() != nil || (opt.FailFast && failed.Load()) {
+				result.Skipped = true
+				return nil
+			}
+
+			start := time.Now()
+			err := runJob(ctx, job, run, opt, &mutex, result)
+			result.Elapsed = time.Since(start)
+			if err != nil {
+				result.Error = err.Error()
+				var exitErr *exec.ExitError
+				if errors.As(err, &exitErr) {
+					result.ExitCode = exitErr.ExitCode()
+				}
+				failed.Store(true)
+				return nil
+			}
+			result.Passed = true
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	for _, result := range report.Results {
+		switch {
+		case result.Skipped:
+			report.Skipped++
+		case result.Passed:
+			report.Passed++
+		default:
+			report.Failed++
+		}
+	}
+	return report
+}
+
+func runJob(ctx context.Context, job Job, run Runner, opt Option, mutex *sync.Mutex, result *Result) error {
+	output := &lineWriter{mutex: mutex, writer: opt.Output, prefix: fmt.Sprintf("[%s] ", job.Name)}
+	if opt.LogDir != "" {
+		result.Log = filepath.Join(opt.LogDir, job.Name+".log")
+		file, err := os.OpenFile(result.Log, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return errors.Wrap(err, "create log file")
+		}
+		defer file.Close()
+		output.writer = file
+		output.prefix = ""
+		output.mutex = &sync.Mutex{}
+	}
+
+	err := run(ctx, job, output)
+	_ = output.flush()
+	if err != nil && output.last != "" {
+		// The error of commit is in the last line of output.
+		return fmt.Errorf("%w: %s", err, output.last)
+	}
+	return err
+}
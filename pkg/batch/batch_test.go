@@ -0,0 +1,108 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const batchFile = `
+jobs:
+  - name: web-0
+    container: docker://web-0
+    target: [localhost:5000/web:0]
+  - container: docker://db-0
+    target: localhost:5000/db:0
+    pause-container: true
+`
+
+func TestParseJobs(t *testing.T) {
+	jobs, err := ParseJobs([]byte(batchFile))
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, "web-0", jobs[0].Name)
+	require.Equal(t, "job-1", jobs[1].Name)
+	require.Equal(t, map[string]interface{}{
+		"container":       "docker://db-0",
+		"target":          "localhost:5000/db:0",
+		"pause-container": true,
+	}, jobs[1].Options)
+
+	for _, data := range []string{
+		``,
+		`jobs: []`,
+		`unknown: 1`,
+		`jobs: [{name: a/b}]`,
+		`jobs: [{name: a}, {name: a}]`,
+		`jobs: [{target: {ref: a}}]`,
+	} {
+		_, err := ParseJobs([]byte(data))
+		require.Error(t, err, data)
+	}
+}
+
+func TestRun(t *testing.T) {
+	jobs, err := ParseJobs([]byte(`jobs: [{name: a}, {name: b}, {name: c}]`))
+	require.NoError(t, err)
+	run := func(ctx context.Context, job Job, output io.Writer) error {
+		fmt.Fprintf(output, "committing %s\n", job.Name)
+		if job.Name == "b" {
+			fmt.Fprint(output, "level=error msg=\"container not found\"")
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}
+
+	output := bytes.Buffer{}
+	report := Run(context.Background(), jobs, run, Option{Parallelism: 2, Output: &output})
+	require.Equal(t, 3, report.Total)
+	require.Equal(t, 2, report.Passed)
+	require.Equal(t, 1, report.Failed)
+	require.False(t, report.Results[1].Passed)
+	require.Equal(t, `exit status 1: level=error msg="container not found"`, report.Results[1].Error)
+	require.ErrorContains(t, report.Err(), "1 of 3 jobs failed (b)")
+	require.Contains(t, output.String(), "[a] committing a\n")
+	require.Contains(t, output.String(), "[b] level=error")
+
+	report = Run(context.Background(), jobs[1:], run, Option{Parallelism: 1, FailFast: true})
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, 1, report.Skipped)
+	require.True(t, report.Results[1].Skipped)
+
+	logDir := t.TempDir()
+	report = Run(context.Background(), jobs[:1], run, Option{LogDir: logDir})
+	require.NoError(t, report.Err())
+	require.Equal(t, filepath.Join(logDir, "a.log"), report.Results[0].Log)
+	log, err := os.ReadFile(report.Results[0].Log)
+	require.NoError(t, err)
+	require.Equal(t, "committing a\n", string(log))
+
+	printed := bytes.Buffer{}
+	report.Print(&printed)
+	require.True(t, strings.HasPrefix(printed.String(), "PASS\ta\t"))
+}
+
+func TestCommandRunner(t *testing.T) {
+	executable := filepath.Join(t.TempDir(), "nydus-cli")
+	require.NoError(t, os.WriteFile(executable, []byte("#!/bin/sh\necho \"$@\"\ncat\nexit 14\n"), 0755))
+	jobs, err := ParseJobs([]byte(`jobs: [{name: a, container: docker://a}]`))
+	require.NoError(t, err)
+
+	output := bytes.Buffer{}
+	err = CommandRunner(executable, []string{"--log-level", "debug"}, nil)(context.Background(), jobs[0], &output)
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, "--log-level debug commit --options-from -\ncontainer: docker://a\n", output.String())
+
+	report := Run(context.Background(), jobs, CommandRunner(executable, nil, nil), Option{})
+	require.Equal(t, 14, report.Results[0].ExitCode)
+	require.Equal(t, "exit status 14: container: docker://a", report.Results[0].Error)
+}
@@ -0,0 +1,298 @@
+// Package builder locates the nydus-image binary used to build blobs and
+// bootstraps, checks its version against the compatibility matrix, and
+// downloads the pinned release if it's not installed.
+package builder
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/semver"
+	"golang.org/x/sys/unix"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/compat"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// binaryName is the name of builder in PATH and in release tarball.
+const binaryName = "nydus-image"
+
+// Discover returns the absolute path of builder `path`, which is looked up
+// in PATH if it's a name. If it's not found and a release is pinned by
+// `release`, the release is downloaded into `dir` and reused by the later
+// runs by `client`, see ReleaseClient. The builder older than the minimum
+// version in compatibility matrix is refused.
+func Discover(ctx context.Context, path string, release config.Builder, dir string, client *http.Client) (string, error) {
+	found, err := exec.LookPath(path)
+	if err != nil {
+		if release.Version == "" {
+			return "", errors.Wrapf(err, "builder %s not found, install it or pin a release to download in builder config", path)
+		}
+		logrus.WithError(err).Infof("builder %s not found, using release %s", path, release.Version)
+		if found, err = Download(ctx, release, dir, client); err != nil {
+			return "", errors.Wrapf(err, "download builder %s", release.Version)
+		}
+	}
+	if found, err = filepath.Abs(found); err != nil {
+		return "", errors.Wrap(err, "get absolute path of builder")
+	}
+
+	version, err := compat.BinaryVersion(ctx, found)
+	if err != nil {
+		logrus.WithError(err).Warnf("unknown version of builder %s, skip checking it", found)
+		return found, nil
+	}
+	if minVersion := compat.MinVersion(compat.ComponentBuilder); minVersion != "" && semver.Compare(version, minVersion) < 0 {
+		return "", fmt.Errorf("builder %s is %s, but >= %s is required", found, version, minVersion)
+	}
+	logrus.Infof("using builder %s %s", found, version)
+
+	return found, nil
+}
+
+// releaseURL returns the URL of release tarball for architecture `arch`.
+func releaseURL(release config.Builder, arch string) string {
+	url := release.URL
+	if url == "" {
+		url = config.DefaultBuilderURL
+	}
+	return strings.NewReplacer("{version}", release.Version, "{arch}", arch).Replace(url)
+}
+
+// ReleaseClient returns the client downloading the release pinned in `cfg`
+// through the proxy of distribution config, with the TLS config of the
+// release host in registries config as the remotes.
+func ReleaseClient(cfg *config.Config) (*http.Client, error) {
+	proxy, err := remote.NewProxyFunc(cfg.Distribution.Proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid distribution config")
+	}
+	u, err := url.Parse(releaseURL(cfg.Builder, runtime.GOARCH))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse release url")
+	}
+	var tlsConfig *tls.Config
+	if registry, ok := cfg.Registries[u.Host]; ok {
+		if tlsConfig, err = remote.RegistryTLSConfig(registry.CA, registry.Cert, registry.Key, registry.Insecure); err != nil {
+			return nil, errors.Wrapf(err, "load tls config of registry %s", u.Host)
+		}
+	}
+	return remote.NewClient(tlsConfig, proxy), nil
+}
+
+// Download downloads the release tarball into `dir` by `client`, verifies
+// its checksum and extracts the builder. The builder already extracted is reused if
+// `dir` is private to the current user and the checksum of builder still
+// matches the one recorded on extracting, otherwise it's downloaded again.
+func Download(ctx context.Context, release config.Builder, dir string, client *http.Client) (string, error) {
+	if err := privateDir(dir); err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, fmt.Sprintf("%s-%s", binaryName, release.Version))
+	if _, err := os.Lstat(target); err == nil {
+		err := verifyCached(target)
+		if err == nil {
+			return target, nil
+		}
+		logrus.WithError(err).Warnf("download builder again for invalid cached builder %s", target)
+	}
+
+	releaseURL := releaseURL(release, runtime.GOARCH)
+	logrus.Infof("downloading builder from %s", releaseURL)
+	tarball, err := os.CreateTemp(dir, ".release-")
+	if err != nil {
+		return "", errors.Wrap(err, "create release tarball file")
+	}
+	defer os.Remove(tarball.Name())
+	defer tarball.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "create request")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "request release")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request release: unexpected status %s", resp.Status)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tarball, hash), resp.Body); err != nil {
+		return "", errors.Wrap(err, "read release")
+	}
+	if checksum := hex.EncodeToString(hash.Sum(nil)); checksum != release.SHA256 {
+		return "", fmt.Errorf("release checksum mismatch: %s != %s", checksum, release.SHA256)
+	}
+
+	if _, err := tarball.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "seek release tarball")
+	}
+	if err := extract(tarball, target); err != nil {
+		return "", errors.Wrap(err, "extract builder")
+	}
+
+	return target, nil
+}
+
+// privateDir creates `dir` if it doesn't exist, and checks it's a directory
+// owned by the current user and not accessible by others, as the builder
+// cached in it is executed by the current user (often root) without
+// downloading again. The check is done on the opened directory, which is
+// not a symlink.
+func privateDir(dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return errors.Wrap(err, "create parent of builder dir")
+	}
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "create builder dir")
+	}
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open builder dir %s", dir)
+	}
+	defer unix.Close(fd)
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return errors.Wrapf(err, "stat builder dir %s", dir)
+	}
+	if int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("builder dir %s is owned by uid %d instead of %d, remove it or change the workdir", dir, stat.Uid, os.Geteuid())
+	}
+	if stat.Mode&0077 != 0 {
+		return fmt.Errorf("builder dir %s is accessible by others with mode %o, remove it or change the workdir", dir, stat.Mode&0777)
+	}
+	return nil
+}
+
+// checksumPath returns the path of file recording the checksum of
+// builder `target`.
+func checksumPath(target string) string {
+	return target + ".sha256"
+}
+
+// fileChecksum returns the SHA-256 of regular file `path`, which is not
+// followed if it's a symlink.
+func fileChecksum(path string) (string, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", errors.Wrapf(err, "open %s", path)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", errors.Wrapf(err, "stat %s", path)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", errors.Wrapf(err, "read %s", path)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyCached checks the checksum of cached builder `target` against the
+// one recorded on extracting.
+func verifyCached(target string) error {
+	expected, err := os.ReadFile(checksumPath(target))
+	if err != nil {
+		return errors.Wrap(err, "read checksum of builder")
+	}
+	checksum, err := fileChecksum(target)
+	if err != nil {
+		return errors.Wrap(err, "calculate checksum of builder")
+	}
+	if checksum != strings.TrimSpace(string(expected)) {
+		return fmt.Errorf("builder checksum mismatch: %s != %s", checksum, strings.TrimSpace(string(expected)))
+	}
+	return nil
+}
+
+// writeFile writes `data` to a temp file renamed to `target` at last.
+func writeFile(target string, data []byte, mode os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+"-")
+	if err != nil {
+		return errors.Wrapf(err, "create temp file of %s", target)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return errors.Wrapf(err, "write %s", target)
+	}
+	if err := temp.Chmod(mode); err != nil {
+		temp.Close()
+		return errors.Wrapf(err, "chmod %s", target)
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrapf(err, "close %s", target)
+	}
+	return os.Rename(temp.Name(), target)
+}
+
+// extract extracts the builder in release tarball `reader` to `target`
+// and records its checksum, the builder is written to a temp file renamed
+// at last, so the concurrent runs never see a partial builder.
+func extract(reader io.Reader, target string) error {
+	gzReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return errors.Wrap(err, "decompress release tarball")
+	}
+	defer gzReader.Close()
+
+	tr := tar.NewReader(gzReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("not found %s in release tarball", binaryName)
+		}
+		if err != nil {
+			return errors.Wrap(err, "read release tarball")
+		}
+		if hdr.Typeflag != tar.TypeReg || filepath.Base(hdr.Name) != binaryName {
+			continue
+		}
+
+		temp, err := os.CreateTemp(filepath.Dir(target), "."+binaryName+"-")
+		if err != nil {
+			return errors.Wrap(err, "create temp builder")
+		}
+		defer os.Remove(temp.Name())
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(temp, hash), tr); err != nil {
+			temp.Close()
+			return errors.Wrap(err, "write builder")
+		}
+		if err := temp.Chmod(0755); err != nil {
+			temp.Close()
+			return errors.Wrap(err, "chmod builder")
+		}
+		if err := temp.Close(); err != nil {
+			return errors.Wrap(err, "close builder")
+		}
+		// The checksum is recorded first, a builder renamed without its
+		// checksum is never reused.
+		if err := writeFile(checksumPath(target), []byte(hex.EncodeToString(hash.Sum(nil))+"\n"), 0600); err != nil {
+			return errors.Wrap(err, "write checksum of builder")
+		}
+		return os.Rename(temp.Name(), target)
+	}
+}
@@ -0,0 +1,144 @@
+package builder
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func releaseTarball(t *testing.T, version string) []byte {
+	script := []byte("#!/bin/sh\necho \"Version: " + version + "\"\n")
+	buf := bytes.Buffer{}
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "nydus-static/", Typeflag: tar.TypeDir, Mode: 0755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "nydus-static/nydus-image", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(script))}))
+	_, err := tw.Write(script)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestReleaseURL(t *testing.T) {
+	require.Equal(t,
+		"https://github.com/dragonflyoss/nydus/releases/download/v2.2.4/nydus-static-v2.2.4-linux-arm64.tgz",
+		releaseURL(config.Builder{Version: "v2.2.4"}, "arm64"))
+	require.Equal(t,
+		"https://mirror.example.com/nydus/v2.2.4/amd64.tgz",
+		releaseURL(config.Builder{Version: "v2.2.4", URL: "https://mirror.example.com/nydus/{version}/{arch}.tgz"}, "amd64"))
+}
+
+func TestDiscover(t *testing.T) {
+	tarballs := map[string][]byte{
+		"v2.2.4": releaseTarball(t, "v2.2.4"),
+		"v2.0.0": releaseTarball(t, "v2.0.0"),
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		tarball, ok := tarballs[filepath.Base(req.URL.Path)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(tarball)
+	}))
+	defer server.Close()
+	release := func(version string) config.Builder {
+		checksum := sha256.Sum256(tarballs[version])
+		return config.Builder{
+			Version: version,
+			URL:     server.URL + "/{arch}/{version}",
+			SHA256:  hex.EncodeToString(checksum[:]),
+		}
+	}
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "builder")
+
+	_, err := Discover(ctx, "nydus-image-not-found", config.Builder{}, dir, http.DefaultClient)
+	require.Error(t, err)
+
+	path, err := Discover(ctx, "nydus-image-not-found", release("v2.2.4"), dir, http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "nydus-image-v2.2.4"), path)
+	require.Equal(t, 1, requests)
+
+	// The downloaded builder is reused.
+	_, err = Discover(ctx, "nydus-image-not-found", release("v2.2.4"), dir, http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, 1, requests)
+
+	// The tampered builder is downloaded again.
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho \"Version: v2.2.4\"\nid\n"), 0755))
+	_, err = Discover(ctx, "nydus-image-not-found", release("v2.2.4"), dir, http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, 2, requests)
+	require.NoError(t, verifyCached(path))
+
+	// The builder dir accessible by others or not a directory is refused.
+	shared := filepath.Join(t.TempDir(), "shared")
+	require.NoError(t, os.Mkdir(shared, 0777))
+	require.NoError(t, os.Chmod(shared, 0777))
+	_, err = Discover(ctx, "nydus-image-not-found", release("v2.2.4"), shared, http.DefaultClient)
+	require.ErrorContains(t, err, "accessible by others")
+	link := filepath.Join(t.TempDir(), "link")
+	require.NoError(t, os.Symlink(dir, link))
+	_, err = Discover(ctx, "nydus-image-not-found", release("v2.2.4"), link, http.DefaultClient)
+	require.ErrorContains(t, err, "open builder dir")
+	require.Equal(t, 2, requests)
+
+	// The builder in PATH is preferred.
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	found, err := Discover(ctx, "nydus-image-v2.2.4", config.Builder{}, t.TempDir(), http.DefaultClient)
+	require.NoError(t, err)
+	require.Equal(t, path, found)
+
+	mismatched := release("v2.2.4")
+	mismatched.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	_, err = Discover(ctx, "nydus-image-not-found", mismatched, filepath.Join(t.TempDir(), "builder"), http.DefaultClient)
+	require.ErrorContains(t, err, "checksum mismatch")
+
+	_, err = Discover(ctx, "nydus-image-not-found", release("v2.0.0"), dir, http.DefaultClient)
+	require.ErrorContains(t, err, ">= v2.1.0 is required")
+}
+
+func TestReleaseClient(t *testing.T) {
+	tarball := releaseTarball(t, "v2.2.4")
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = req.Host
+		_, _ = w.Write(tarball)
+	}))
+	defer proxy.Close()
+	checksum := sha256.Sum256(tarball)
+	cfg := &config.Config{Builder: config.Builder{
+		Version: "v2.2.4",
+		URL:     "http://builder.example.com/{arch}/{version}",
+		SHA256:  hex.EncodeToString(checksum[:]),
+	}}
+	cfg.Distribution.Proxy = proxy.URL
+
+	// The release is downloaded through the proxy of distribution config.
+	client, err := ReleaseClient(cfg)
+	require.NoError(t, err)
+	_, err = Download(context.Background(), cfg.Builder, filepath.Join(t.TempDir(), "builder"), client)
+	require.NoError(t, err)
+	require.Equal(t, "builder.example.com", proxied)
+
+	cfg.Registries = map[string]config.Registry{"builder.example.com": {CA: filepath.Join(t.TempDir(), "ca.pem")}}
+	_, err = ReleaseClient(cfg)
+	require.ErrorContains(t, err, "load tls config of registry builder.example.com")
+}
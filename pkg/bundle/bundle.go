@@ -0,0 +1,167 @@
+// Package bundle gathers the debugging info of a failed commit into a
+// tarball for support tickets.
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// maxFileSize limits the size of each file copied from work dir, the
+// larger ones (e.g. blobs) are only listed.
+const maxFileSize = 4 * 1024 * 1024
+
+// Option describes what is gathered into bundle.
+type Option struct {
+	Version string
+	// WorkDir is the work dir kept by a failed commit, optional.
+	WorkDir string
+	// Inspect is the raw inspect output of the committed container, optional.
+	Inspect []byte
+}
+
+// collected decides if a file in work dir is copied into bundle, only the
+// logs and metadata are collected.
+func collected(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".json")
+}
+
+type writer struct {
+	tw  *tar.Writer
+	now time.Time
+}
+
+func (w *writer) add(name string, data []byte) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  w.now,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return errors.Wrapf(err, "write header of %s", name)
+	}
+	_, err := w.tw.Write(data)
+	return errors.Wrapf(err, "write %s", name)
+}
+
+func environment(version string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "version: %s\n", version)
+	fmt.Fprintf(&buf, "go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err == nil {
+		fmt.Fprintf(&buf, "kernel: %s %s\n", unix.ByteSliceToString(uname.Release[:]), unix.ByteSliceToString(uname.Version[:]))
+	}
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(&buf, "hostname: %s\n", hostname)
+	fmt.Fprintf(&buf, "pod name: %s\n", os.Getenv("ALIPAY_POD_NAME"))
+	fmt.Fprintf(&buf, "time: %s\n", time.Now().Format(time.RFC3339))
+	return buf.Bytes()
+}
+
+// addWorkDir lists all files in work dir and copies the collected ones.
+func (w *writer) addWorkDir(workDir string) error {
+	var listing bytes.Buffer
+	collectedFiles := []string{}
+	if err := filepath.WalkDir(workDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(&listing, "%s\terror: %s\n", path, err)
+			return nil
+		}
+		rel, err := filepath.Rel(workDir, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			fmt.Fprintf(&listing, "%s\terror: %s\n", rel, err)
+			return nil
+		}
+		fmt.Fprintf(&listing, "%s\t%s\t%d\t%s\n", info.Mode(), info.ModTime().Format(time.RFC3339), info.Size(), rel)
+		if info.Mode().IsRegular() && info.Size() <= maxFileSize && collected(entry.Name()) {
+			collectedFiles = append(collectedFiles, rel)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "walk %s", workDir)
+	}
+
+	if err := w.add("workdir/files.txt", listing.Bytes()); err != nil {
+		return err
+	}
+	sort.Strings(collectedFiles)
+	for _, rel := range collectedFiles {
+		data, err := os.ReadFile(filepath.Join(workDir, rel))
+		if err != nil {
+			return errors.Wrapf(err, "read %s", rel)
+		}
+		if err := w.add(filepath.Join("workdir", rel), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write writes the bundle as a gzip tarball to `dest`.
+func Write(dest io.Writer, opt Option) error {
+	gw := gzip.NewWriter(dest)
+	w := &writer{tw: tar.NewWriter(gw), now: time.Now()}
+
+	if err := w.add("environment.txt", environment(opt.Version)); err != nil {
+		return err
+	}
+	if opt.WorkDir != "" {
+		if err := w.addWorkDir(opt.WorkDir); err != nil {
+			return err
+		}
+	}
+	if opt.Inspect != nil {
+		if err := w.add("inspect-raw.json", opt.Inspect); err != nil {
+			return err
+		}
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return errors.Wrap(err, "close tar writer")
+	}
+	return errors.Wrap(gw.Close(), "close gzip writer")
+}
+
+// LatestWorkDir returns the most recently modified work dir created under
+// `baseDir`.
+func LatestWorkDir(baseDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(baseDir, "nydus-cli-*"))
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest, latestTime = match, info.ModTime()
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no kept work dir found in %s", baseDir)
+	}
+	return latest, nil
+}
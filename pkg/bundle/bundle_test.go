@@ -0,0 +1,56 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	baseDir := t.TempDir()
+	workDir := filepath.Join(baseDir, "nydus-cli-123")
+	require.NoError(t, os.Mkdir(workDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "nydus-cli.log"), []byte("log"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "inspect.json"), []byte("{}"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "blob-upper"), []byte("blob"), 0600))
+
+	latest, err := LatestWorkDir(baseDir)
+	require.NoError(t, err)
+	require.Equal(t, workDir, latest)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, Option{Version: "v1", WorkDir: workDir, Inspect: []byte(`{"Id":"abc"}`)}))
+
+	gr, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(data)
+	}
+
+	require.Contains(t, files["environment.txt"], "version: v1")
+	require.Equal(t, "log", files["workdir/nydus-cli.log"])
+	require.Equal(t, "{}", files["workdir/inspect.json"])
+	require.Equal(t, `{"Id":"abc"}`, files["inspect-raw.json"])
+	// Blobs are only listed.
+	require.NotContains(t, files, "workdir/blob-upper")
+	require.True(t, strings.Contains(files["workdir/files.txt"], "blob-upper"))
+
+	_, err = LatestWorkDir(t.TempDir())
+	require.Error(t, err)
+}
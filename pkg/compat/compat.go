@@ -0,0 +1,214 @@
+// Package compat encodes the known compatibility between the builder,
+// the fs version of bootstrap, nydusd and the annotations read by
+// snapshotter, to refuse the combinations producing images which are
+// pushed but can't be mounted.
+package compat
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+const (
+	ComponentBuilder = "builder"
+	ComponentNydusd  = "nydusd"
+)
+
+type Level string
+
+const (
+	// LevelWarn is a combination which works but degrades, e.g. some
+	// features are not available at runtime.
+	LevelWarn Level = "warn"
+	// LevelRefuse is a combination producing images which can't be built
+	// or mounted.
+	LevelRefuse Level = "refuse"
+)
+
+// Rule requires the minimum version of a component for fs version.
+type Rule struct {
+	Component string
+	// FsVersion the rule applies to, "" for all fs versions.
+	FsVersion  string
+	MinVersion string
+	Level      Level
+	Reason     string
+}
+
+// Matrix is the known compatibility table, keep it sorted by component
+// and fs version.
+var Matrix = []Rule{
+	{
+		Component:  ComponentBuilder,
+		MinVersion: "v2.1.0",
+		Level:      LevelRefuse,
+		Reason:     "tar stream conversion used by commit is not supported",
+	},
+	{
+		Component:  ComponentBuilder,
+		FsVersion:  "6",
+		MinVersion: "v2.2.0",
+		Level:      LevelRefuse,
+		Reason:     "merging RAFS v6 bootstraps with external blobs is not supported",
+	},
+	{
+		Component:  ComponentNydusd,
+		MinVersion: "v2.1.0",
+		Level:      LevelWarn,
+		Reason:     "blobs in OSS are only fetched by whole instead of by chunks",
+	},
+	{
+		Component:  ComponentNydusd,
+		FsVersion:  "6",
+		MinVersion: "v2.0.0",
+		Level:      LevelRefuse,
+		Reason:     "RAFS v6 bootstrap can't be mounted",
+	},
+}
+
+// Versions are the versions of components taking part in committing and
+// mounting an image, an empty version is unknown and not checked.
+type Versions struct {
+	Builder string
+	Nydusd  string
+	// FsVersion is the fs version of committed bootstrap.
+	FsVersion string
+	// BaseAnnotations are the annotations of base bootstrap layer, which
+	// are read by snapshotter.
+	BaseAnnotations map[string]string
+	// BaseBuilder is the version of builder converting base image, from
+	// the manifest annotation written by converters.
+	BaseBuilder string
+}
+
+// Issue is a known bad combination found in versions.
+type Issue struct {
+	Level   Level
+	Message string
+	// Expected points to the versions expected to be used instead.
+	Expected string
+}
+
+func (issue Issue) String() string {
+	return fmt.Sprintf("%s, expected %s", issue.Message, issue.Expected)
+}
+
+// Check returns the issues of versions found by matrix.
+func Check(versions Versions) []Issue {
+	issues := []Issue{}
+
+	for _, rule := range Matrix {
+		if rule.FsVersion != "" && rule.FsVersion != versions.FsVersion {
+			continue
+		}
+		version := versions.Builder
+		if rule.Component == ComponentNydusd {
+			version = versions.Nydusd
+		}
+		if version == "" || semver.Compare(version, rule.MinVersion) >= 0 {
+			continue
+		}
+		issues = append(issues, Issue{
+			Level:    rule.Level,
+			Message:  fmt.Sprintf("%s %s with fs version %s: %s", rule.Component, version, versions.FsVersion, rule.Reason),
+			Expected: fmt.Sprintf("%s >= %s", rule.Component, rule.MinVersion),
+		})
+	}
+
+	if versions.Builder != "" && semver.IsValid(versions.BaseBuilder) && semver.Compare(versions.Builder, versions.BaseBuilder) < 0 {
+		issues = append(issues, Issue{
+			Level:    LevelWarn,
+			Message:  fmt.Sprintf("builder %s is older than builder %s converting base image, the features of base bootstrap may be not supported", versions.Builder, versions.BaseBuilder),
+			Expected: fmt.Sprintf("%s >= %s", ComponentBuilder, versions.BaseBuilder),
+		})
+	}
+
+	if versions.BaseAnnotations != nil {
+		baseFsVersion, ok := versions.BaseAnnotations[utils.LayerAnnotationNydusFsVersion]
+		if !ok {
+			// Written by the converters before fs version annotation.
+			baseFsVersion, ok = versions.BaseAnnotations[utils.LayerAnnotationNydusRAFSVersion]
+		}
+		switch {
+		case !ok:
+			issues = append(issues, Issue{
+				Level:    LevelWarn,
+				Message:  "base bootstrap layer has no fs version annotation, snapshotter treats it as RAFS v5",
+				Expected: fmt.Sprintf("base image converted with annotation %s", utils.LayerAnnotationNydusFsVersion),
+			})
+		case baseFsVersion != versions.FsVersion:
+			issues = append(issues, Issue{
+				Level:    LevelRefuse,
+				Message:  fmt.Sprintf("base bootstrap is fs version %s, but committed bootstrap is fs version %s", baseFsVersion, versions.FsVersion),
+				Expected: fmt.Sprintf("base image of fs version %s", versions.FsVersion),
+			})
+		}
+	}
+
+	return issues
+}
+
+// MinVersion returns the minimum version of `component` refused by
+// matrix for all fs versions, or "" if there is none.
+func MinVersion(component string) string {
+	minVersion := ""
+	for _, rule := range Matrix {
+		if rule.Component != component || rule.FsVersion != "" || rule.Level != LevelRefuse {
+			continue
+		}
+		if minVersion == "" || semver.Compare(rule.MinVersion, minVersion) > 0 {
+			minVersion = rule.MinVersion
+		}
+	}
+	return minVersion
+}
+
+// Err returns the refused issues as an error, or nil if there is none.
+func Err(issues []Issue) error {
+	refused := []string{}
+	for _, issue := range issues {
+		if issue.Level == LevelRefuse {
+			refused = append(refused, issue.String())
+		}
+	}
+	if len(refused) == 0 {
+		return nil
+	}
+	return fmt.Errorf("incompatible versions: %s", strings.Join(refused, "; "))
+}
+
+var versionPattern = regexp.MustCompile(`v?(\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?)`)
+
+// ParseVersion returns the first semantic version in output of `--version`,
+// e.g. "v2.2.4" of "Version: v2.2.4".
+func ParseVersion(output string) (string, error) {
+	matched := versionPattern.FindStringSubmatch(output)
+	if matched == nil {
+		return "", fmt.Errorf("no version found in %q", strings.TrimSpace(output))
+	}
+	version := "v" + matched[1]
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("invalid version %s", version)
+	}
+	return version, nil
+}
+
+// BinaryVersion returns the version of binary `path` by `--version`.
+func BinaryVersion(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "run %s --version: %s", path, strings.TrimSpace(string(output)))
+	}
+	return ParseVersion(string(output))
+}
@@ -0,0 +1,68 @@
+package compat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+func TestParseVersion(t *testing.T) {
+	version, err := ParseVersion("Version: \tv2.2.4\nGit Commit: \t0123456789abcdef\nBuild Time: \t2023-12-01T00:00:00Z\n")
+	require.NoError(t, err)
+	require.Equal(t, "v2.2.4", version)
+
+	version, err = ParseVersion("nydusd 2.3.0-rc.1")
+	require.NoError(t, err)
+	require.Equal(t, "v2.3.0-rc.1", version)
+
+	_, err = ParseVersion("unknown")
+	require.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	issues := Check(Versions{
+		Builder:   "v2.2.4",
+		Nydusd:    "v2.2.4",
+		FsVersion: "5",
+		BaseAnnotations: map[string]string{
+			utils.LayerAnnotationNydusFsVersion: "5",
+		},
+	})
+	require.Empty(t, issues)
+	require.NoError(t, Err(issues))
+
+	// The unknown versions are not checked.
+	require.Empty(t, Check(Versions{FsVersion: "6"}))
+
+	issues = Check(Versions{
+		Builder:   "v2.0.1",
+		Nydusd:    "v2.0.0",
+		FsVersion: "5",
+		BaseAnnotations: map[string]string{
+			utils.LayerAnnotationNydusRAFSVersion: "6",
+		},
+	})
+	require.Len(t, issues, 3)
+	require.Equal(t, LevelWarn, issues[1].Level)
+	require.EqualError(t, Err(issues), "incompatible versions: "+
+		"builder v2.0.1 with fs version 5: tar stream conversion used by commit is not supported, expected builder >= v2.1.0; "+
+		"base bootstrap is fs version 6, but committed bootstrap is fs version 5, expected base image of fs version 5")
+
+	issues = Check(Versions{FsVersion: "5", BaseAnnotations: map[string]string{}})
+	require.Len(t, issues, 1)
+	require.Equal(t, LevelWarn, issues[0].Level)
+	require.NoError(t, Err(issues))
+
+	issues = Check(Versions{Builder: "v2.2.0", FsVersion: "5", BaseBuilder: "v2.2.4"})
+	require.Len(t, issues, 1)
+	require.Equal(t, LevelWarn, issues[0].Level)
+	require.Equal(t, "builder >= v2.2.4", issues[0].Expected)
+	require.Empty(t, Check(Versions{Builder: "v2.2.4", FsVersion: "5", BaseBuilder: "v2.2.4"}))
+}
+
+func TestMinVersion(t *testing.T) {
+	require.Equal(t, "v2.1.0", MinVersion(ComponentBuilder))
+	require.Equal(t, "", MinVersion(ComponentNydusd))
+}
@@ -0,0 +1,30 @@
+# bash completion of nydus-cli, load it by:
+#   source <(nydus-cli completion bash)
+
+_nydus_cli_completion() {
+  local cur prev words cword request
+  COMPREPLY=()
+  # The container ids like docker://<id> are kept in one word with
+  # bash-completion.
+  if declare -F _init_completion >/dev/null 2>&1; then
+    _init_completion -n "=:" || return
+  else
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    words=("${COMP_WORDS[@]}")
+    cword=$COMP_CWORD
+  fi
+  words=("${words[@]:0:$cword}")
+  if [[ "$cur" == "-"* ]]; then
+    request="SHELL=bash ${words[*]} ${cur} --generate-bash-completion"
+  else
+    request="SHELL=bash ${words[*]} --generate-bash-completion"
+  fi
+  local IFS=$'\n'
+  COMPREPLY=($(compgen -W "$(eval "${request}" 2>/dev/null)" -- "${cur}"))
+  if declare -F __ltrim_colon_completions >/dev/null 2>&1; then
+    __ltrim_colon_completions "$cur"
+  fi
+  return 0
+}
+
+complete -o bashdefault -o default -F _nydus_cli_completion nydus-cli
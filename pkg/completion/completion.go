@@ -0,0 +1,69 @@
+// Package completion generates the shell completion scripts of nydus-cli,
+// and completes the values of flags dynamically, e.g. the running
+// containers for `--container`.
+package completion
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+var (
+	//go:embed bash.sh
+	bashScript string
+	//go:embed zsh.sh
+	zshScript string
+	//go:embed fish.sh
+	fishScript string
+)
+
+// Shells are the shells supported by Script.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Script returns the completion script of `shell`, which requests the
+// candidates from nydus-cli by the `--generate-bash-completion` flag.
+func Script(shell string) (string, error) {
+	switch shell {
+	case "bash":
+		return bashScript, nil
+	case "zsh":
+		return zshScript, nil
+	case "fish":
+		return fishScript, nil
+	default:
+		return "", fmt.Errorf("unsupported shell %s, must be one of %s", shell, strings.Join(Shells, ", "))
+	}
+}
+
+// CompletingFlag checks whether the value of flag `name` is being
+// completed in `args`, i.e. the flag is the last argument before the
+// completion flag appended by the scripts.
+func CompletingFlag(args []string, name string) bool {
+	if len(args) < 2 || args[len(args)-1] != "--generate-bash-completion" {
+		return false
+	}
+	last := args[len(args)-2]
+	return last == "--"+name || last == "-"+name
+}
+
+// PrintContainers writes `containers` as the candidates of `shell`, which
+// is `$SHELL` set by the scripts as urfave/cli does: the ids described by
+// their names and images in zsh and fish, and the ids only in bash.
+func PrintContainers(writer io.Writer, shell string, containers []container.Summary) {
+	for _, container := range containers {
+		description := fmt.Sprintf("%s (%s)", container.Name, container.Image)
+		switch {
+		case strings.HasSuffix(shell, "zsh"):
+			// The colons in candidates of zsh are escaped.
+			fmt.Fprintf(writer, "%s:%s\n", strings.ReplaceAll(container.ID, ":", `\:`), description)
+		case strings.HasSuffix(shell, "fish"):
+			fmt.Fprintf(writer, "%s\t%s\n", container.ID, description)
+		default:
+			fmt.Fprintln(writer, container.ID)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package completion
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+func TestScript(t *testing.T) {
+	for _, shell := range Shells {
+		script, err := Script(shell)
+		require.NoError(t, err)
+		require.Contains(t, script, "--generate-bash-completion")
+	}
+	_, err := Script("ksh")
+	require.Error(t, err)
+}
+
+func TestCompletingFlag(t *testing.T) {
+	require.True(t, CompletingFlag([]string{"nydus-cli", "commit", "--container", "--generate-bash-completion"}, "container"))
+	require.False(t, CompletingFlag([]string{"nydus-cli", "commit", "--target", "--generate-bash-completion"}, "container"))
+	require.False(t, CompletingFlag([]string{"nydus-cli", "commit", "--container"}, "container"))
+	require.False(t, CompletingFlag([]string{"--generate-bash-completion"}, "container"))
+}
+
+func TestPrintContainers(t *testing.T) {
+	containers := []container.Summary{
+		{ID: "docker://c1", Name: "web", Image: "nginx:latest_nydus_v2"},
+		{ID: "pouch://c2", Name: "app", Image: "app:v1"},
+	}
+	for shell, expected := range map[string]string{
+		"/bin/bash":    "docker://c1\npouch://c2\n",
+		"":             "docker://c1\npouch://c2\n",
+		"/usr/bin/zsh": "docker\\://c1:web (nginx:latest_nydus_v2)\npouch\\://c2:app (app:v1)\n",
+		"fish":         "docker://c1\tweb (nginx:latest_nydus_v2)\npouch://c2\tapp (app:v1)\n",
+	} {
+		var buf bytes.Buffer
+		PrintContainers(&buf, shell, containers)
+		require.Equal(t, expected, buf.String(), shell)
+	}
+}
@@ -0,0 +1,14 @@
+# fish completion of nydus-cli, load it by:
+#   nydus-cli completion fish | source
+
+function __nydus_cli_completion
+    set -l args (commandline -opc)
+    set -l cur (commandline -ct)
+    if string match -q -- '-*' $cur
+        env SHELL=fish $args $cur --generate-bash-completion 2>/dev/null
+    else
+        env SHELL=fish $args --generate-bash-completion 2>/dev/null
+    end
+end
+
+complete -c nydus-cli -f -a '(__nydus_cli_completion)'
@@ -0,0 +1,22 @@
+#compdef nydus-cli
+# zsh completion of nydus-cli, load it by:
+#   source <(nydus-cli completion zsh)
+
+_nydus_cli_completion() {
+  local -a opts
+  local cur
+  cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(SHELL=zsh ${words[@]:0:#words[@]-1} ${cur} --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(SHELL=zsh ${words[@]:0:#words[@]-1} --generate-bash-completion 2>/dev/null)}")
+  fi
+
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+}
+
+compdef _nydus_cli_completion nydus-cli
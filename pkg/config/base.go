@@ -2,11 +2,24 @@ package config
 
 type Base struct {
 	WorkDir string
-	Builder string
-	Runtime Runtime
+	// WorkDirEncryption is one of "", "require" and "ephemeral".
+	WorkDirEncryption     string
+	WorkDirEncryptionSize uint64
+	// WorkDirQuota limits the size of files written into work dir, 0
+	// means unlimited.
+	WorkDirQuota uint64
+	Builder      string
+	// BuilderLogLevel overrides the log level of builder if set.
+	BuilderLogLevel string
+	Runtime         Runtime
 }
 
 type Runtime struct {
 	PouchAddr  string
 	DockerAddr string
+	// ContainerdAddr is the containerd under the engines, whose task API
+	// pauses containers in container.PauseTask mode.
+	ContainerdAddr string
+	// InspectPaths are from RuntimeConfig of config file.
+	InspectPaths map[string]InspectPaths
 }
@@ -1,17 +1,70 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	"github.com/yalp/jsonpath"
 	"gopkg.in/yaml.v2"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/compat"
+	"github.com/nydusaccelerator/nydus-cli/pkg/scan"
 )
 
 type Config struct {
 	// From config file
 	Distribution Distribution `yaml:"distribution"`
 	OSS          OSS          `yaml:"oss"`
+	Backend      Backend      `yaml:"backend"`
+	// Routing routes the blobs between registry and external backend.
+	Routing  Routing  `yaml:"routing"`
+	Artifact Artifact `yaml:"artifact"`
+	// Mirrors maps registry host to the endpoints of its mirrors, which
+	// are tried in order before the registry.
+	Mirrors map[string][]string `yaml:"mirrors"`
+	// MirrorPush pushes images to the mirrors too.
+	MirrorPush bool `yaml:"mirror_push"`
+	// Registries are the configs of registries keyed by host.
+	Registries map[string]Registry `yaml:"registries"`
+	// CredentialProviders obtain the credentials of cloud registries.
+	CredentialProviders CredentialProviders `yaml:"credential_providers"`
+	// Identity attests the committed images.
+	Identity Identity `yaml:"identity"`
+	// Compat is checked against the compatibility matrix before commit.
+	Compat Compat `yaml:"compat"`
+	// Annotations limits the annotations of committed images.
+	Annotations Annotations `yaml:"annotations"`
+	// Metrics of commits are written for SLO tracking.
+	Metrics Metrics `yaml:"metrics"`
+	// Naming maps the references of OCI images to nydus images.
+	Naming Naming `yaml:"naming"`
+	// Bootstrap compresses the bootstrap layer of committed images.
+	Bootstrap Bootstrap `yaml:"bootstrap"`
+	// Mounts deduplicates the blobs of mount paths across commits.
+	Mounts Mounts `yaml:"mounts"`
+	// Push configures how the committed blobs are pushed.
+	Push Push `yaml:"push"`
+	// Builder is the release of builder downloaded if it's not found.
+	Builder Builder `yaml:"builder"`
+	// Webhooks are notified of the start and result of commits.
+	Webhooks []Webhook `yaml:"webhooks"`
+	// Publisher publishes the events of commits to a message broker.
+	Publisher Publisher `yaml:"publisher"`
+	// Hooks are the executables on host run around commits.
+	Hooks Hooks `yaml:"hooks"`
+	// Scan scans the committed layers before they are pushed, and the
+	// pushed images.
+	Scan Scan `yaml:"scan"`
+	// Secrets fetches the credentials referenced in config at runtime.
+	Secrets Secrets `yaml:"secrets"`
+	// Runtime configures how the containers are inspected.
+	Runtime RuntimeConfig `yaml:"runtime"`
 
 	// From CLI flags
 	Base Base
@@ -21,32 +74,1163 @@ type OSS struct {
 	Endpoint        string `yaml:"endpoint"`
 	AccessKeyID     string `yaml:"access_key_id"`
 	AccessKeySecret string `yaml:"access_key_secret"`
-	BucketName      string `yaml:"bucket_name"`
-	ObjectPrefix    string `yaml:"object_prefix"`
+	// AccessKeyIDFile and AccessKeySecretFile are the paths of files
+	// containing the access key, e.g. mounted from secrets.
+	AccessKeyIDFile     string `yaml:"access_key_id_file"`
+	AccessKeySecretFile string `yaml:"access_key_secret_file"`
+	BucketName          string `yaml:"bucket_name"`
+	ObjectPrefix        string `yaml:"object_prefix"`
+	// Proxy of requests to OSS endpoint, e.g. "http://proxy.example.com:3128",
+	// HTTP_PROXY/HTTPS_PROXY environment variables are used if not set.
+	Proxy string `yaml:"proxy"`
+}
+
+const (
+	BackendTypeRegistry = "registry"
+	BackendTypeOSS      = "oss"
+	BackendTypePlugin   = "plugin"
+)
+
+// Backend selects where the blobs are pushed to besides registry and OSS.
+type Backend struct {
+	// Type is "plugin" to push blobs by exec plugin, otherwise the blobs are
+	// pushed to OSS if configured, or to registry.
+	Type string `yaml:"type"`
+	// Command of plugin, which is run with Args followed by the action.
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+}
+
+// BackendType returns the type of backend, one of "registry", "oss" and
+// "plugin".
+func (c *Config) BackendType() string {
+	switch {
+	case c.Backend.Type == BackendTypePlugin:
+		return BackendTypePlugin
+	case c.OSS.Endpoint != "":
+		return BackendTypeOSS
+	default:
+		return BackendTypeRegistry
+	}
+}
+
+// Validate checks the type of backend and the command of plugin.
+func (b *Backend) Validate(oss *OSS) error {
+	switch b.Type {
+	case "":
+	case BackendTypePlugin:
+		if b.Command == "" {
+			return fmt.Errorf("command is required by plugin backend")
+		}
+		if oss.Endpoint != "" {
+			return fmt.Errorf("plugin backend and oss are mutually exclusive")
+		}
+	default:
+		return fmt.Errorf("invalid type %s, only %s is allowed", b.Type, BackendTypePlugin)
+	}
+	return nil
+}
+
+// ExternalBackendType returns the type of external backend configured,
+// "oss" or "plugin", or "" if there is none.
+func (c *Config) ExternalBackendType() string {
+	if backendType := c.BackendType(); backendType != BackendTypeRegistry {
+		return backendType
+	}
+	return ""
+}
+
+// BackendExternal routes blobs to the external backend configured.
+const BackendExternal = "external"
+
+// Routing routes the data blobs by size, e.g. the large blobs to OSS and
+// the small blobs to registry, the bootstrap is always in registry.
+type Routing struct {
+	// Rules are matched in order, the blobs matching no rule are pushed
+	// to the backend of config.
+	Rules []RoutingRule `yaml:"rules"`
+}
+
+// RoutingRule routes the blobs of size in [MinSize, MaxSize) to Backend.
+type RoutingRule struct {
+	// MinSize and MaxSize are e.g. "64MiB", not bounded if empty.
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+	// Backend is "registry" or "external".
+	Backend string `yaml:"backend"`
+}
+
+func parseOptionalBytes(size string) (uint64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	return humanize.ParseBytes(size)
+}
+
+// match checks whether the blob of `size` matches the rule.
+func (r *RoutingRule) match(size int64) (bool, error) {
+	minSize, err := parseOptionalBytes(r.MinSize)
+	if err != nil {
+		return false, errors.Wrap(err, "parse min_size")
+	}
+	maxSize, err := parseOptionalBytes(r.MaxSize)
+	if err != nil {
+		return false, errors.Wrap(err, "parse max_size")
+	}
+	if size < 0 {
+		return false, nil
+	}
+	return uint64(size) >= minSize && (r.MaxSize == "" || uint64(size) < maxSize), nil
+}
+
+// Validate checks the rules route to the backends configured.
+func (r *Routing) Validate(cfg *Config) error {
+	for idx := range r.Rules {
+		rule := &r.Rules[idx]
+		if _, err := rule.match(0); err != nil {
+			return errors.Wrapf(err, "rule %d", idx)
+		}
+		switch rule.Backend {
+		case BackendTypeRegistry:
+		case BackendExternal:
+			if cfg.ExternalBackendType() == "" {
+				return fmt.Errorf("rule %d routes to external backend, but neither oss nor plugin backend is configured", idx)
+			}
+		default:
+			return fmt.Errorf("rule %d: invalid backend %s, must be %s or %s", idx, rule.Backend, BackendTypeRegistry, BackendExternal)
+		}
+	}
+	return nil
+}
+
+// BlobBackendType returns the type of backend which the blob of `size` is
+// pushed to, one of "registry", "oss" and "plugin".
+func (c *Config) BlobBackendType(size int64) string {
+	for idx := range c.Routing.Rules {
+		rule := &c.Routing.Rules[idx]
+		// The rules are validated on parsing config.
+		if matched, _ := rule.match(size); !matched {
+			continue
+		}
+		if rule.Backend == BackendExternal {
+			return c.ExternalBackendType()
+		}
+		return BackendTypeRegistry
+	}
+	return c.BackendType()
+}
+
+// Artifact controls the permission of blobs and bootstraps created in
+// work dir, they contain full container data so are private by default.
+type Artifact struct {
+	// Octal file mode, default is "0600".
+	FileMode string `yaml:"file_mode"`
+	// Octal directory mode, default is "0700".
+	DirMode string `yaml:"dir_mode"`
+	// Owner of the created artifacts, keep process owner if not set.
+	UID *int `yaml:"uid"`
+	GID *int `yaml:"gid"`
+}
+
+func parseMode(mode, defaultMode string) (os.FileMode, error) {
+	if mode == "" {
+		mode = defaultMode
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid mode %s", mode)
+	}
+	if parsed&^0777 != 0 {
+		return 0, fmt.Errorf("invalid mode %s, only permission bits are allowed", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+// Modes returns the parsed file and directory mode of artifacts.
+func (a *Artifact) Modes() (os.FileMode, os.FileMode, error) {
+	fileMode, err := parseMode(a.FileMode, "0600")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parse file_mode")
+	}
+	dirMode, err := parseMode(a.DirMode, "0700")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "parse dir_mode")
+	}
+	return fileMode, dirMode, nil
 }
 
 type Distribution struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// PasswordFile is the path of file containing the password.
+	PasswordFile string `yaml:"password_file"`
+	// DockerConfig is the directory of docker `config.json` whose auths,
+	// credsStore and credHelpers are used if username and password aren't
+	// set, `$DOCKER_CONFIG` or `~/.docker` by default.
+	DockerConfig string `yaml:"docker_config"`
+	// Proxy of requests to registries and mirrors, HTTP_PROXY/HTTPS_PROXY
+	// environment variables are used if not set.
+	Proxy string `yaml:"proxy"`
+	// Token is used instead of username and password by TokenType.
+	Token string `yaml:"token"`
+	// TokenFile is the path of file containing the token, which is read
+	// again when the token is rejected, so the token rotated in file is
+	// used during long pushes.
+	TokenFile string `yaml:"token_file"`
+	// TokenType is "bearer" (the default) sending the token to registries
+	// as is, or "identity" exchanging the token as OAuth2 refresh token for
+	// the access tokens at the auth server of registry.
+	TokenType string `yaml:"token_type"`
 }
 
-func Parse(c *cli.Context, configPath string) (*Config, error) {
-	bytes, err := os.ReadFile(configPath)
+const (
+	TokenTypeBearer   = "bearer"
+	TokenTypeIdentity = "identity"
+)
+
+// Validate checks the type of token and that the token is exclusive with
+// username and password.
+func (d *Distribution) Validate() error {
+	switch d.TokenType {
+	case "", TokenTypeBearer, TokenTypeIdentity:
+	default:
+		return fmt.Errorf("invalid token_type %s, must be %s or %s", d.TokenType, TokenTypeBearer, TokenTypeIdentity)
+	}
+	if d.Token != "" && (d.Username != "" || d.Password != "") {
+		return fmt.Errorf("token and username/password are mutually exclusive")
+	}
+	return nil
+}
+
+// Registry is the config of requests to a registry host.
+type Registry struct {
+	// CA is the path of CA bundle verifying the registry besides system
+	// CAs, the registry not configured with TLS is verified by system CAs
+	// unless insecure is "true".
+	CA string `yaml:"ca"`
+	// Cert and Key are the paths of client certificate and its key for
+	// mutual TLS.
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// Insecure is one of "true" requesting by plain HTTP, "false" requesting
+	// by HTTPS only, and "auto" negotiating the scheme, default is "auto".
+	Insecure string `yaml:"insecure"`
+	// Harbor integrates the API of registry which is Harbor.
+	Harbor Harbor `yaml:"harbor"`
+}
+
+// Harbor checks the targets in Harbor registry before committing, the
+// projects of targets must exist and the credentials must be permitted to
+// push them.
+type Harbor struct {
+	Enabled bool `yaml:"enabled"`
+	// CreateProject creates the projects of targets not existing, which
+	// requires the credentials permitted to create projects.
+	CreateProject bool `yaml:"create_project"`
+	// Public makes the created projects public.
+	Public bool `yaml:"public"`
+}
+
+// CredentialProviders obtain the short-lived credentials of the cloud
+// registries they serve from the identity of node, which are used instead
+// of docker config and refreshed before expired.
+type CredentialProviders struct {
+	ECR ECR `yaml:"ecr"`
+	ACR ACR `yaml:"acr"`
+	GCR GCR `yaml:"gcr"`
+}
+
+// ECR obtains the credentials of AWS ECR registries, i.e.
+// `<account>.dkr.ecr.<region>.amazonaws.com`, by GetAuthorizationToken in
+// the region of registry.
+type ECR struct {
+	Enabled bool `yaml:"enabled"`
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials of
+	// AWS, from env AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN or the role of EC2 instance if not set.
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// SecretAccessKeyFile is the path of file containing the secret key.
+	SecretAccessKeyFile string `yaml:"secret_access_key_file"`
+	SessionToken        string `yaml:"session_token"`
+	// Endpoint of ECR API, `https://api.ecr.<region>.amazonaws.com` of the
+	// registry by default.
+	Endpoint string `yaml:"endpoint"`
+	// MetadataEndpoint is the instance metadata service of EC2, default is
+	// "http://169.254.169.254".
+	MetadataEndpoint string `yaml:"metadata_endpoint"`
+}
+
+// ACR obtains the temporary credentials of Alibaba Cloud ACR registries,
+// both `registry[-intl][-vpc].<region>.aliyuncs.com` of personal edition
+// and `<instance>-registry[-vpc].<region>.cr.aliyuncs.com` of enterprise
+// edition, by GetAuthorizationToken in the region of registry.
+type ACR struct {
+	Enabled bool `yaml:"enabled"`
+	// AccessKeyID, AccessKeySecret and SecurityToken are the credentials of
+	// Alibaba Cloud, from env ALIBABA_CLOUD_ACCESS_KEY_ID,
+	// ALIBABA_CLOUD_ACCESS_KEY_SECRET and ALIBABA_CLOUD_SECURITY_TOKEN or
+	// the RAM role of ECS instance if not set.
+	AccessKeyID     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+	// AccessKeySecretFile is the path of file containing the secret.
+	AccessKeySecretFile string `yaml:"access_key_secret_file"`
+	SecurityToken       string `yaml:"security_token"`
+	// RAMRole is the RAM role of ECS instance, the role attached to the
+	// instance if not set.
+	RAMRole string `yaml:"ram_role"`
+	// Endpoint of ACR API, `https://cr.<region>.aliyuncs.com` of the
+	// registry by default.
+	Endpoint string `yaml:"endpoint"`
+	// MetadataEndpoint is the metadata service of ECS, default is
+	// "http://100.100.100.200".
+	MetadataEndpoint string `yaml:"metadata_endpoint"`
+	// InstanceIDs are the ids of enterprise instances keyed by registry
+	// host, looked up by the instance name in host if not set.
+	InstanceIDs map[string]string `yaml:"instance_ids"`
+}
+
+// GCR obtains the OAuth2 access tokens of Google Container Registry and
+// Artifact Registry, i.e. `[<region>.]gcr.io` and `<location>-docker.pkg.dev`.
+type GCR struct {
+	Enabled bool `yaml:"enabled"`
+	// Key is the service account key in json, the application default
+	// credentials are used if not set: the file of env
+	// GOOGLE_APPLICATION_CREDENTIALS, the well-known file of gcloud, then
+	// the service account of GCE instance or GKE workload identity.
+	Key string `yaml:"key"`
+	// KeyFile is the path of service account key file.
+	KeyFile string `yaml:"key_file"`
+	// MetadataEndpoint is the metadata server of GCE, default is
+	// "http://metadata.google.internal".
+	MetadataEndpoint string `yaml:"metadata_endpoint"`
+}
+
+// Identity configures where the identity of node attesting committed
+// images is obtained from.
+type Identity struct {
+	// Source is one of "", "spiffe" and "aliyun", the images are not
+	// attested if empty.
+	Source string `yaml:"source"`
+	SPIFFE SPIFFE `yaml:"spiffe"`
+	Aliyun Aliyun `yaml:"aliyun"`
+}
+
+// SPIFFE locates the Workload API serving the X.509-SVID and trust bundle.
+type SPIFFE struct {
+	// Socket is the address of Workload API, e.g.
+	// "unix:///run/spire/sockets/agent.sock", default is the one in env
+	// SPIFFE_ENDPOINT_SOCKET.
+	Socket string `yaml:"socket"`
+}
+
+// Aliyun is the instance metadata service of ECS.
+type Aliyun struct {
+	// Endpoint of metadata service, default is "http://100.100.100.200".
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Compat is the environment checked by the compatibility matrix before
+// committing.
+type Compat struct {
+	// NydusdVersion is the version of nydusd mounting the committed images,
+	// e.g. "v2.2.4", it's not checked if not set.
+	NydusdVersion string `yaml:"nydusd_version"`
+}
+
+// DefaultAnnotationMaxSize is the label size limit of containerd, the
+// annotations of layers are passed to snapshotter as labels.
+const DefaultAnnotationMaxSize = 4096
+
+// minAnnotationMaxSize leaves room for the summary of commit history.
+const minAnnotationMaxSize = 1024
+
+// The keys of annotations on bootstrap layer written by commit.
+const (
+	// AnnotationCommitBlobs lists the blobs committed on top of base image.
+	AnnotationCommitBlobs = "containerd.io/snapshot/nydus-commit-blobs"
+	// AnnotationBlobIDs lists the blobs in external backend.
+	AnnotationBlobIDs = "containerd.io/snapshot/nydus-blob-ids"
+	// AnnotationBlobIDsFull is the descriptor of all blob ids, set only if
+	// the blob ids annotation is truncated to the limit.
+	AnnotationBlobIDsFull = "io.nydus.cli.blob-ids-full"
+	// AnnotationCommitHistory is the summary of commits in the chain of
+	// committed image.
+	AnnotationCommitHistory = "io.nydus.cli.commit-history"
+)
+
+const (
+	// AnnotationProfileNydusCLI writes all annotations of nydus-cli, it's
+	// the default profile.
+	AnnotationProfileNydusCLI = "nydus-cli"
+	// AnnotationProfileNydusify writes only the annotations known by
+	// nydusify and acceld, so the committed images interoperate with them.
+	AnnotationProfileNydusify = "nydusify"
+)
+
+// annotationPattern is the characters allowed in annotation key.
+var annotationPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]*$`)
+
+// AnnotationKeys are the keys of annotations on bootstrap layer, the
+// annotation of empty key isn't written.
+type AnnotationKeys struct {
+	CommitBlobs   string `yaml:"commit_blobs"`
+	BlobIDs       string `yaml:"blob_ids"`
+	BlobIDsFull   string `yaml:"blob_ids_full"`
+	CommitHistory string `yaml:"commit_history"`
+}
+
+var annotationProfiles = map[string]AnnotationKeys{
+	AnnotationProfileNydusCLI: {
+		CommitBlobs:   AnnotationCommitBlobs,
+		BlobIDs:       AnnotationBlobIDs,
+		BlobIDsFull:   AnnotationBlobIDsFull,
+		CommitHistory: AnnotationCommitHistory,
+	},
+	// The history is derived from commit blobs without the time of
+	// commits then, the blob ids are never truncated.
+	AnnotationProfileNydusify: {
+		CommitBlobs: AnnotationCommitBlobs,
+		BlobIDs:     AnnotationBlobIDs,
+	},
+}
+
+// Annotations limits the size of annotations written by commit, and
+// names them.
+type Annotations struct {
+	// MaxSize is the maximum bytes of key and value of an annotation,
+	// e.g. "4KiB", default is DefaultAnnotationMaxSize.
+	MaxSize string `yaml:"max_size"`
+	// Profile is the convention of annotation keys, one of "nydus-cli"
+	// (default) and "nydusify".
+	Profile string `yaml:"profile"`
+	// Keys override the keys of profile if set.
+	Keys AnnotationKeys `yaml:"keys"`
+}
+
+// ResolvedKeys returns the keys of profile overridden by Keys.
+func (a *Annotations) ResolvedKeys() (AnnotationKeys, error) {
+	profile := a.Profile
+	if profile == "" {
+		profile = AnnotationProfileNydusCLI
+	}
+	keys, ok := annotationProfiles[profile]
+	if !ok {
+		return AnnotationKeys{}, fmt.Errorf("invalid profile %s, must be %s or %s", profile, AnnotationProfileNydusCLI, AnnotationProfileNydusify)
+	}
+	for _, override := range []struct {
+		key   *string
+		value string
+	}{
+		{&keys.CommitBlobs, a.Keys.CommitBlobs},
+		{&keys.BlobIDs, a.Keys.BlobIDs},
+		{&keys.BlobIDsFull, a.Keys.BlobIDsFull},
+		{&keys.CommitHistory, a.Keys.CommitHistory},
+	} {
+		if override.value == "" {
+			continue
+		}
+		if !annotationPattern.MatchString(override.value) {
+			return AnnotationKeys{}, fmt.Errorf("invalid annotation key %s", override.value)
+		}
+		*override.key = override.value
+	}
+	return keys, nil
+}
+
+// Limit returns the parsed maximum size of an annotation.
+func (a *Annotations) Limit() (int, error) {
+	if a.MaxSize == "" {
+		return DefaultAnnotationMaxSize, nil
+	}
+	size, err := humanize.ParseBytes(a.MaxSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse max_size")
+	}
+	if size < minAnnotationMaxSize {
+		return 0, fmt.Errorf("max_size %s is less than %d bytes", a.MaxSize, minAnnotationMaxSize)
+	}
+	return int(size), nil
+}
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// Bootstrap compresses the bootstrap layer of committed images, zstd
+// reduces the push and pull time of bootstrap for images of many files,
+// and caches the bootstraps of base images.
+type Bootstrap struct {
+	// Compression is one of "gzip" (default) and "zstd".
+	Compression string `yaml:"compression"`
+	// CompressionLevel is in [-2, 9] for gzip, where -2 is huffman only,
+	// or in [1, 22] for zstd, 0 means the default level.
+	CompressionLevel int `yaml:"compression_level"`
+	// CacheDir keeps the pulled base bootstraps keyed by the digest of
+	// bootstrap layer across runs, bootstraps aren't cached if empty.
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// Mounts keeps an index from the fingerprint of mount path to the blob
+// committed from it across runs, so an unchanged mount path references
+// the blob pushed before instead of being packed and pushed again.
+type Mounts struct {
+	// DedupDir keeps the index and the bootstraps of indexed blobs, mount
+	// blobs aren't deduplicated if empty.
+	DedupDir string `yaml:"dedup_dir"`
+}
+
+// DefaultSmallBlobSize is the size under which the blobs are pushed from
+// memory if not configured.
+const DefaultSmallBlobSize = 1024 * 1024
+
+// Push configures how the committed blobs are pushed to backends.
+type Push struct {
+	// SmallBlobSize is e.g. "1MiB" (the default), the blobs smaller than
+	// it are read into memory once and pushed to each backend by a single
+	// request if the backend supports, "0" disables it.
+	SmallBlobSize string `yaml:"small_blob_size"`
+}
+
+// SmallBlobThreshold returns the parsed small blob size.
+func (p *Push) SmallBlobThreshold() (int64, error) {
+	if p.SmallBlobSize == "" {
+		return DefaultSmallBlobSize, nil
+	}
+	size, err := humanize.ParseBytes(p.SmallBlobSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse small_blob_size")
+	}
+	if size > uint64(DefaultSmallBlobSize)*64 {
+		return 0, fmt.Errorf("small_blob_size %s is larger than 64MiB", p.SmallBlobSize)
+	}
+	return int64(size), nil
+}
+
+// Validate checks the compression and its level.
+func (b *Bootstrap) Validate() error {
+	switch b.Compression {
+	case "", CompressionGzip:
+		if b.CompressionLevel < -2 || b.CompressionLevel > 9 {
+			return fmt.Errorf("compression_level %d of gzip is not in [-2, 9]", b.CompressionLevel)
+		}
+	case CompressionZstd:
+		if b.CompressionLevel < 0 || b.CompressionLevel > 22 {
+			return fmt.Errorf("compression_level %d of zstd is not in [1, 22]", b.CompressionLevel)
+		}
+	default:
+		return fmt.Errorf("invalid compression %s, must be %s or %s", b.Compression, CompressionGzip, CompressionZstd)
+	}
+	return nil
+}
+
+// DefaultBuilderURL is the static release of nydus on GitHub.
+const DefaultBuilderURL = "https://github.com/dragonflyoss/nydus/releases/download/{version}/nydus-static-{version}-linux-{arch}.tgz"
+
+// sha256Pattern is a checksum in hex.
+var sha256Pattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// builderLogLevels are the log levels of builder.
+var builderLogLevels = map[string]bool{
+	"trace": true,
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+}
+
+// Builder pins the release of builder, which is downloaded into work dir
+// if the builder is not found, and passes extra arguments to builder.
+type Builder struct {
+	// Version of release, e.g. "v2.2.4", the builder isn't downloaded if
+	// empty.
+	Version string `yaml:"version"`
+	// URL of release tarball, in which "{version}" and "{arch}" are
+	// expanded, default is DefaultBuilderURL.
+	URL string `yaml:"url"`
+	// SHA256 checksum of release tarball in hex, required with Version.
+	SHA256 string `yaml:"sha256"`
+	// Args are appended to the builder invocations, the argument prefixed
+	// with "create:" or "merge:" is only appended to the subcommand, e.g.
+	// "create:--chunk-size=0x100000".
+	Args []string `yaml:"args"`
+}
+
+// Validate checks the release is pinned by checksum.
+func (b *Builder) Validate() error {
+	if b.Version == "" {
+		return nil
+	}
+	if _, err := compat.ParseVersion(b.Version); err != nil {
+		return errors.Wrap(err, "parse version")
+	}
+	if !sha256Pattern.MatchString(b.SHA256) {
+		return fmt.Errorf("invalid sha256 %q, must be 64 hex characters", b.SHA256)
+	}
+	return nil
+}
+
+// Naming maps the references between OCI images and nydus images, the
+// rules are applied to the normalized references with tag, e.g.
+// "docker.io/library/nginx:latest".
+type Naming struct {
+	// Suffix is appended to the tag of OCI image if no rule matches,
+	// default is "_nydus_v2".
+	Suffix string `yaml:"suffix"`
+	// Rules rewrite OCI references to nydus references, the first matched
+	// rule is applied.
+	Rules []RewriteRule `yaml:"rules"`
+	// ReverseRules rewrite nydus references to OCI references, the
+	// references matching any of them are nydus references too.
+	ReverseRules []RewriteRule `yaml:"reverse_rules"`
+}
+
+// RewriteRule rewrites the reference matching regexp `Source` to template
+// `Target`, in which `$1` or `${name}` are expanded to the submatches.
+type RewriteRule struct {
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// Metrics are accumulated in file across commits.
+type Metrics struct {
+	// File in Prometheus text format, e.g. in the directory of node
+	// exporter textfile collector, metrics are not written if empty.
+	File string `yaml:"file"`
+	SLO  SLO    `yaml:"slo"`
+}
+
+// SLO is the objective of commits, a commit is good if it succeeded
+// within the latency.
+type SLO struct {
+	// Objective is the target ratio of good commits, e.g. 0.99.
+	Objective float64 `yaml:"objective"`
+	// Latency is the duration good commits finish within, e.g. "10m",
+	// not limited if empty.
+	Latency string `yaml:"latency"`
+}
+
+// LatencyDuration returns the parsed latency, 0 if not limited.
+func (s *SLO) LatencyDuration() (time.Duration, error) {
+	if s.Latency == "" {
+		return 0, nil
+	}
+	latency, err := time.ParseDuration(s.Latency)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse latency")
+	}
+	if latency <= 0 {
+		return 0, fmt.Errorf("latency %s is not positive", s.Latency)
+	}
+	return latency, nil
+}
+
+// Webhook receives the events of commits in json by HTTP POST.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Headers are added to the requests, e.g. the authorization.
+	Headers map[string]string `yaml:"headers"`
+	// Secret signs the payload by HMAC-SHA256 if set, the signature is in
+	// header X-Nydus-Signature-256 as `sha256=<hex>`.
+	Secret string `yaml:"secret"`
+	// SecretFile is the path of file containing the secret.
+	SecretFile string `yaml:"secret_file"`
+	// Events are the types of events sent, e.g. "commit.succeeded", all
+	// events are sent if empty.
+	Events []string `yaml:"events"`
+	// Timeout of each delivery attempt, e.g. "10s".
+	Timeout string `yaml:"timeout"`
+}
+
+// Publisher publishes the events of commits to NATS or Kafka.
+type Publisher struct {
+	// Type is one of "nats" and "kafka", events are not published if
+	// empty.
+	Type  string `yaml:"type"`
+	NATS  NATS   `yaml:"nats"`
+	Kafka Kafka  `yaml:"kafka"`
+	// Events are the types of events published, all events if empty.
+	Events []string `yaml:"events"`
+	// Timeout of each publish attempt, e.g. "10s".
+	Timeout string `yaml:"timeout"`
+}
+
+// NATS is the server which events are published to, the subject of each
+// event is `<subject>.<event type>`, e.g. "nydus.commit.succeeded".
+type NATS struct {
+	// URL is in format `nats://host:port` or `tls://host:port`.
+	URL      string `yaml:"url"`
+	Subject  string `yaml:"subject"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+	// PasswordFile and TokenFile are the paths of files containing the
+	// password and token.
+	PasswordFile string `yaml:"password_file"`
+	TokenFile    string `yaml:"token_file"`
+}
+
+// Kafka is the topic which events are produced to through a Kafka REST
+// proxy, keyed by the committed container.
+type Kafka struct {
+	// RESTProxy is the URL of REST proxy, e.g. "http://kafka-rest:8082".
+	RESTProxy string `yaml:"rest_proxy"`
+	Topic     string `yaml:"topic"`
+	// Headers are added to the requests, e.g. the authorization.
+	Headers map[string]string `yaml:"headers"`
+}
+
+// Hooks are the executables on host run with the event of commit in json
+// on stdin, the same payload as webhooks. Each hook is skipped if empty.
+type Hooks struct {
+	// PreCommit runs before commit, and the commit is aborted if it fails.
+	PreCommit string `yaml:"pre_commit"`
+	// PostPush runs after the committed images are pushed.
+	PostPush string `yaml:"post_push"`
+	// OnFailure runs after the commit failed.
+	OnFailure string `yaml:"on_failure"`
+	// Timeout of each hook, default is "5m".
+	Timeout string `yaml:"timeout"`
+}
+
+// DefaultHookTimeout is the timeout of each hook if not configured.
+const DefaultHookTimeout = 5 * time.Minute
+
+// TimeoutDuration returns the parsed timeout of each hook.
+func (h *Hooks) TimeoutDuration() (time.Duration, error) {
+	if h.Timeout == "" {
+		return DefaultHookTimeout, nil
+	}
+	timeout, err := time.ParseDuration(h.Timeout)
 	if err != nil {
-		return nil, errors.Wrapf(err, "load config: %s", configPath)
+		return 0, errors.Wrap(err, "parse timeout")
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout %s is not positive", h.Timeout)
+	}
+	return timeout, nil
+}
+
+// Scan scans the committed layers before they are pushed, and the pushed
+// images.
+type Scan struct {
+	Secrets         SecretScan `yaml:"secrets"`
+	Vulnerabilities VulnScan   `yaml:"vulnerabilities"`
+}
+
+const (
+	SecretScannerBuiltin = "builtin"
+	SecretScannerExec    = "exec"
+)
+
+// SecretScan scans the tar streams of committed layers for secrets while
+// they are packed, the findings are warned or block the push by severity.
+type SecretScan struct {
+	// Scanner is "builtin" scanning by the regexps of AWS keys, private
+	// keys and tokens, or "exec" scanning by Command, secrets aren't
+	// scanned if empty.
+	Scanner string `yaml:"scanner"`
+	// Command of exec scanner, run with Args for each layer, see
+	// scan.ExecScanner.
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	// Actions are "ignore", "warn" or "block" keyed by severity ("low",
+	// "medium", "high" and "critical"), the high and critical findings
+	// block the push and the others are warned if not set.
+	Actions map[string]string `yaml:"actions"`
+	// MaxFileSize skips the larger files in builtin scanner, e.g. "10MiB"
+	// (default).
+	MaxFileSize string `yaml:"max_file_size"`
+}
+
+// MaxFileSizeBytes returns the parsed max file size, 0 means the default.
+func (s *SecretScan) MaxFileSizeBytes() (int64, error) {
+	size, err := parseOptionalBytes(s.MaxFileSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse max_file_size")
+	}
+	return int64(size), nil
+}
+
+// Validate checks the scanner and its actions.
+func (s *SecretScan) Validate() error {
+	switch s.Scanner {
+	case "", SecretScannerBuiltin:
+	case SecretScannerExec:
+		if s.Command == "" {
+			return fmt.Errorf("command is required by exec scanner")
+		}
+	default:
+		return fmt.Errorf("invalid scanner %s, must be %s or %s", s.Scanner, SecretScannerBuiltin, SecretScannerExec)
+	}
+	if _, err := scan.ParsePolicy(s.Actions); err != nil {
+		return errors.Wrap(err, "parse actions")
+	}
+	if _, err := s.MaxFileSizeBytes(); err != nil {
+		return err
+	}
+	return nil
+}
+
+const (
+	VulnScannerTrivy = "trivy"
+	VulnScannerExec  = "exec"
+)
+
+// DefaultVulnScanTimeout is the timeout of scanning each image if not
+// configured.
+const DefaultVulnScanTimeout = 10 * time.Minute
+
+// VulnScan scans the images for vulnerabilities after they are pushed,
+// the images exceeding the threshold fail the commit.
+type VulnScan struct {
+	// Scanner is "trivy" scanning by the Trivy server, or "exec" scanning
+	// by Command, images aren't scanned if empty.
+	Scanner string `yaml:"scanner"`
+	Trivy   Trivy  `yaml:"trivy"`
+	// Command of exec scanner, run with Args followed by the reference of
+	// image, see scan.ExecImageScanner.
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	// Threshold is the lowest severity ("low", "medium", "high" and
+	// "critical") failing the commit, the vulnerabilities are only
+	// logged if empty.
+	Threshold string `yaml:"threshold"`
+	// DeleteTag deletes the pushed manifests exceeding the threshold, so
+	// they can't be pulled.
+	DeleteTag bool `yaml:"delete_tag"`
+	// Timeout of scanning each image, default is "10m".
+	Timeout string `yaml:"timeout"`
+}
+
+// Trivy is the Trivy server scanned by trivy CLI in client mode.
+type Trivy struct {
+	// Server is the URL of Trivy server, e.g. "http://trivy:4954".
+	Server string `yaml:"server"`
+	// Token authenticates to the server if set.
+	Token string `yaml:"token"`
+	// TokenFile is the path of file containing the token.
+	TokenFile string `yaml:"token_file"`
+	// Path of trivy binary, default is "trivy" in PATH.
+	Path string `yaml:"path"`
+}
+
+// TimeoutDuration returns the parsed timeout of scanning each image.
+func (v *VulnScan) TimeoutDuration() (time.Duration, error) {
+	if v.Timeout == "" {
+		return DefaultVulnScanTimeout, nil
+	}
+	timeout, err := time.ParseDuration(v.Timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse timeout")
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("timeout %s is not positive", v.Timeout)
+	}
+	return timeout, nil
+}
+
+// Validate checks the scanner and its threshold.
+func (v *VulnScan) Validate() error {
+	switch v.Scanner {
+	case "":
+		return nil
+	case VulnScannerTrivy:
+		if v.Trivy.Server == "" {
+			return fmt.Errorf("trivy.server is required by trivy scanner")
+		}
+	case VulnScannerExec:
+		if v.Command == "" {
+			return fmt.Errorf("command is required by exec scanner")
+		}
+	default:
+		return fmt.Errorf("invalid scanner %s, must be %s or %s", v.Scanner, VulnScannerTrivy, VulnScannerExec)
 	}
+	if v.Threshold != "" {
+		if _, err := scan.ParseSeverity(v.Threshold); err != nil {
+			return errors.Wrap(err, "parse threshold")
+		}
+	} else if v.DeleteTag {
+		return fmt.Errorf("delete_tag requires threshold")
+	}
+	if _, err := v.TimeoutDuration(); err != nil {
+		return err
+	}
+	return nil
+}
+
+const (
+	SecretsProviderVault = "vault"
+
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+// DefaultVaultTokenFile is the service account token of Kubernetes pod.
+const DefaultVaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// Secrets fetches the credentials in format `secret:<path>#<field>` from
+// a secrets provider at runtime, e.g.
+// `secret:kv/data/nydus/oss#access_key_secret`, so they are never stored
+// on disk.
+type Secrets struct {
+	// Provider is "vault", the credentials are not fetched if empty.
+	Provider string `yaml:"provider"`
+	Vault    Vault  `yaml:"vault"`
+}
+
+// Vault is the HashiCorp Vault server, the secrets of both KV version 1
+// and 2 engines are supported.
+type Vault struct {
+	// Address is the URL of server, e.g. "https://vault.example.com:8200".
+	Address string `yaml:"address"`
+	// Namespace of Vault Enterprise.
+	Namespace string `yaml:"namespace"`
+	// CA is the path of CA bundle verifying the server besides system CAs.
+	CA string `yaml:"ca"`
+	// Auth is the method to login, "approle" or "kubernetes".
+	Auth string `yaml:"auth"`
+	// AuthMount is the mount path of auth method, default is the method.
+	AuthMount string `yaml:"auth_mount"`
+	// RoleID and SecretID (or SecretIDFile) login by "approle".
+	RoleID       string `yaml:"role_id"`
+	SecretID     string `yaml:"secret_id"`
+	SecretIDFile string `yaml:"secret_id_file"`
+	// Role and the service account token in TokenFile login by
+	// "kubernetes", TokenFile is DefaultVaultTokenFile by default.
+	Role      string `yaml:"role"`
+	TokenFile string `yaml:"token_file"`
+}
+
+// Validate checks the provider and its required settings.
+func (s *Secrets) Validate() error {
+	switch s.Provider {
+	case "":
+		return nil
+	case SecretsProviderVault:
+	default:
+		return fmt.Errorf("invalid provider %s, must be %s", s.Provider, SecretsProviderVault)
+	}
+	vault := s.Vault
+	if vault.Address == "" {
+		return fmt.Errorf("vault.address is required")
+	}
+	switch vault.Auth {
+	case VaultAuthAppRole:
+		if vault.RoleID == "" {
+			return fmt.Errorf("vault.role_id is required by approle auth")
+		}
+		if (vault.SecretID == "") == (vault.SecretIDFile == "") {
+			return fmt.Errorf("one of vault.secret_id and vault.secret_id_file is required by approle auth")
+		}
+	case VaultAuthKubernetes:
+		if vault.Role == "" {
+			return fmt.Errorf("vault.role is required by kubernetes auth")
+		}
+	default:
+		return fmt.Errorf("invalid vault.auth %s, must be %s or %s", vault.Auth, VaultAuthAppRole, VaultAuthKubernetes)
+	}
+	return nil
+}
+
+// RuntimeConfig configures how the containers are inspected.
+type RuntimeConfig struct {
+	// InspectPaths override the json paths read from the inspect output of
+	// containers keyed by engine type (docker or pouch), for the modified
+	// or older engine builds with different inspect schemas.
+	InspectPaths map[string]InspectPaths `yaml:"inspect_paths"`
+}
+
+// InspectPaths are the candidate json paths of each field in the inspect
+// output of container tried in order, the built-in ones are used for the
+// fields not set.
+type InspectPaths struct {
+	Image []string `yaml:"image"`
+	Pid   []string `yaml:"pid"`
+	// UpperDir is required by LowerDirs and MergedDir. The lower dirs are
+	// in a string separated by ":" or a string array, or looked up from
+	// the overlay mounted at merged dir if LowerDirs isn't set.
+	UpperDir  []string `yaml:"upper_dir"`
+	LowerDirs []string `yaml:"lower_dirs"`
+	MergedDir []string `yaml:"merged_dir"`
+}
+
+func (r *RuntimeConfig) Validate() error {
+	for engine, paths := range r.InspectPaths {
+		if engine != "docker" && engine != "pouch" {
+			return fmt.Errorf("invalid engine %s in inspect_paths, must be docker or pouch", engine)
+		}
+		for key, candidates := range map[string][]string{
+			"image":      paths.Image,
+			"pid":        paths.Pid,
+			"upper_dir":  paths.UpperDir,
+			"lower_dirs": paths.LowerDirs,
+			"merged_dir": paths.MergedDir,
+		} {
+			for _, path := range candidates {
+				if _, err := jsonpath.Prepare(path); err != nil {
+					return errors.Wrapf(err, "invalid inspect_paths.%s.%s %s", engine, key, path)
+				}
+			}
+		}
+		if len(paths.UpperDir) == 0 && (len(paths.LowerDirs) > 0 || len(paths.MergedDir) > 0) {
+			return fmt.Errorf("inspect_paths.%s.upper_dir is required by lower_dirs and merged_dir", engine)
+		}
+		if len(paths.UpperDir) > 0 && len(paths.LowerDirs) == 0 && len(paths.MergedDir) == 0 {
+			return fmt.Errorf("inspect_paths.%s.upper_dir requires lower_dirs or merged_dir", engine)
+		}
+	}
+	return nil
+}
+
+// Validate checks the configs from config file and environment variables.
+func (c *Config) Validate() error {
+	if err := c.Backend.Validate(&c.OSS); err != nil {
+		return errors.Wrap(err, "invalid backend config")
+	}
+	if err := c.Distribution.Validate(); err != nil {
+		return errors.Wrap(err, "invalid distribution config")
+	}
+	if err := c.Routing.Validate(c); err != nil {
+		return errors.Wrap(err, "invalid routing config")
+	}
+	if _, _, err := c.Artifact.Modes(); err != nil {
+		return errors.Wrap(err, "invalid artifact config")
+	}
+	if _, err := c.Annotations.Limit(); err != nil {
+		return errors.Wrap(err, "invalid annotations config")
+	}
+	if _, err := c.Annotations.ResolvedKeys(); err != nil {
+		return errors.Wrap(err, "invalid annotations config")
+	}
+	if err := c.Bootstrap.Validate(); err != nil {
+		return errors.Wrap(err, "invalid bootstrap config")
+	}
+	if _, err := c.Push.SmallBlobThreshold(); err != nil {
+		return errors.Wrap(err, "invalid push config")
+	}
+	if err := c.Builder.Validate(); err != nil {
+		return errors.Wrap(err, "invalid builder config")
+	}
+	if _, err := c.Hooks.TimeoutDuration(); err != nil {
+		return errors.Wrap(err, "invalid hooks config")
+	}
+	if _, err := c.Metrics.SLO.LatencyDuration(); err != nil {
+		return errors.Wrap(err, "invalid metrics config")
+	}
+	if c.Metrics.SLO.Objective < 0 || c.Metrics.SLO.Objective > 1 {
+		return fmt.Errorf("invalid metrics config: objective %v is not in [0, 1]", c.Metrics.SLO.Objective)
+	}
+	if c.Compat.NydusdVersion != "" {
+		if _, err := compat.ParseVersion(c.Compat.NydusdVersion); err != nil {
+			return errors.Wrap(err, "invalid compat config")
+		}
+	}
+	if err := c.Scan.Secrets.Validate(); err != nil {
+		return errors.Wrap(err, "invalid scan.secrets config")
+	}
+	if err := c.Scan.Vulnerabilities.Validate(); err != nil {
+		return errors.Wrap(err, "invalid scan.vulnerabilities config")
+	}
+	if err := c.Secrets.Validate(); err != nil {
+		return errors.Wrap(err, "invalid secrets config")
+	}
+	if err := c.Runtime.Validate(); err != nil {
+		return errors.Wrap(err, "invalid runtime config")
+	}
+
+	return nil
+}
 
+// Parse loads config file `configPath` overridden by the environment
+// variables prefixed with EnvPrefix, the config file is optional if all
+// configs are from environment variables.
+func Parse(c *cli.Context, configPath string) (*Config, error) {
 	var cfg Config
-	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
-		return nil, errors.Wrapf(err, "parse config: %s", configPath)
+	if configPath != "" {
+		bytes, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load config: %s", configPath)
+		}
+		if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "parse config: %s", configPath)
+		}
+	}
+	if err := applyEnv(&cfg, os.LookupEnv); err != nil {
+		return nil, errors.Wrap(err, "parse config from env")
+	}
+	if err := expandEnv(&cfg, os.LookupEnv, false); err != nil {
+		return nil, errors.Wrap(err, "expand config")
+	}
+	if err := readSecretFiles(&cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(c.Context, &cfg); err != nil {
+		return nil, errors.Wrap(err, "resolve secrets")
+	}
+
+	var err error
+	if cfg.Compat.NydusdVersion != "" {
+		// Normalized, e.g. "2.2.4" to "v2.2.4".
+		if cfg.Compat.NydusdVersion, err = compat.ParseVersion(cfg.Compat.NydusdVersion); err != nil {
+			return nil, errors.Wrap(err, "invalid compat config")
+		}
 	}
 
 	cfg.Base.WorkDir = c.String("workdir")
+	cfg.Base.WorkDirEncryption = c.String("workdir-encryption")
+	cfg.Base.WorkDirEncryptionSize, err = humanize.ParseBytes(c.String("workdir-encryption-size"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse workdir-encryption-size")
+	}
+	cfg.Base.WorkDirQuota, err = humanize.ParseBytes(c.String("workdir-quota"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse workdir-quota")
+	}
 	cfg.Base.Builder = c.String("builder")
-	cfg.Base.Runtime = Runtime{
-		PouchAddr:  c.String("pouch.addr"),
-		DockerAddr: c.String("docker.addr"),
+	cfg.Base.BuilderLogLevel = c.String("builder-log-level")
+	cfg.Builder.Args = append(cfg.Builder.Args, c.StringSlice("builder-arg")...)
+	if cfg.Base.BuilderLogLevel != "" && !builderLogLevels[cfg.Base.BuilderLogLevel] {
+		return nil, fmt.Errorf("invalid builder-log-level %s, must be one of trace, debug, info, warn and error", cfg.Base.BuilderLogLevel)
 	}
+	cfg.Base.Runtime = runtimeOf(c, &cfg.Runtime)
 
 	return &cfg, nil
 }
+
+// ParseRuntime loads the runtime config of the commands only taking the
+// runtime flags, from the flags and the runtime section of config file
+// `configPath` which is optional.
+func ParseRuntime(c *cli.Context, configPath string) (*Runtime, error) {
+	var cfg Config
+	if configPath != "" {
+		bytes, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load config: %s", configPath)
+		}
+		if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+			return nil, errors.Wrapf(err, "parse config: %s", configPath)
+		}
+	}
+	if err := cfg.Runtime.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid runtime config")
+	}
+	runtime := runtimeOf(c, &cfg.Runtime)
+	return &runtime, nil
+}
+
+// runtimeOf returns the runtime config from the runtime flags and
+// `runtimeConfig` of config file.
+func runtimeOf(c *cli.Context, runtimeConfig *RuntimeConfig) Runtime {
+	return Runtime{
+		PouchAddr:      c.String("pouch.addr"),
+		DockerAddr:     c.String("docker.addr"),
+		ContainerdAddr: c.String("containerd.addr"),
+		InspectPaths:   runtimeConfig.InspectPaths,
+	}
+}
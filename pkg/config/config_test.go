@@ -0,0 +1,155 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotationKeys(t *testing.T) {
+	keys, err := (&Annotations{}).ResolvedKeys()
+	require.NoError(t, err)
+	require.Equal(t, AnnotationKeys{
+		CommitBlobs:   AnnotationCommitBlobs,
+		BlobIDs:       AnnotationBlobIDs,
+		BlobIDsFull:   AnnotationBlobIDsFull,
+		CommitHistory: AnnotationCommitHistory,
+	}, keys)
+
+	keys, err = (&Annotations{Profile: AnnotationProfileNydusify}).ResolvedKeys()
+	require.NoError(t, err)
+	require.Empty(t, keys.CommitHistory)
+	require.Empty(t, keys.BlobIDsFull)
+	require.Equal(t, AnnotationCommitBlobs, keys.CommitBlobs)
+
+	keys, err = (&Annotations{Keys: AnnotationKeys{CommitBlobs: "example.com/commit-blobs"}}).ResolvedKeys()
+	require.NoError(t, err)
+	require.Equal(t, "example.com/commit-blobs", keys.CommitBlobs)
+	require.Equal(t, AnnotationBlobIDs, keys.BlobIDs)
+
+	_, err = (&Annotations{Profile: "unknown"}).ResolvedKeys()
+	require.Error(t, err)
+	_, err = (&Annotations{Keys: AnnotationKeys{BlobIDs: "invalid key"}}).ResolvedKeys()
+	require.Error(t, err)
+}
+
+func TestBootstrapValidate(t *testing.T) {
+	require.NoError(t, (&Bootstrap{}).Validate())
+	require.NoError(t, (&Bootstrap{Compression: CompressionGzip, CompressionLevel: 9}).Validate())
+	require.NoError(t, (&Bootstrap{Compression: CompressionZstd, CompressionLevel: 19}).Validate())
+
+	require.Error(t, (&Bootstrap{Compression: CompressionGzip, CompressionLevel: 10}).Validate())
+	require.Error(t, (&Bootstrap{Compression: CompressionZstd, CompressionLevel: 23}).Validate())
+	require.Error(t, (&Bootstrap{Compression: "lz4"}).Validate())
+}
+
+func TestSmallBlobThreshold(t *testing.T) {
+	threshold, err := (&Push{}).SmallBlobThreshold()
+	require.NoError(t, err)
+	require.Equal(t, int64(DefaultSmallBlobSize), threshold)
+	threshold, err = (&Push{SmallBlobSize: "64KiB"}).SmallBlobThreshold()
+	require.NoError(t, err)
+	require.Equal(t, int64(64*1024), threshold)
+	threshold, err = (&Push{SmallBlobSize: "0"}).SmallBlobThreshold()
+	require.NoError(t, err)
+	require.Zero(t, threshold)
+
+	_, err = (&Push{SmallBlobSize: "1GiB"}).SmallBlobThreshold()
+	require.Error(t, err)
+	_, err = (&Push{SmallBlobSize: "small"}).SmallBlobThreshold()
+	require.Error(t, err)
+}
+
+func TestDistributionValidate(t *testing.T) {
+	require.NoError(t, (&Distribution{}).Validate())
+	require.NoError(t, (&Distribution{Token: "token"}).Validate())
+	require.NoError(t, (&Distribution{Token: "token", TokenType: TokenTypeIdentity}).Validate())
+
+	require.Error(t, (&Distribution{Token: "token", TokenType: "basic"}).Validate())
+	require.Error(t, (&Distribution{Token: "token", Username: "user", Password: "password"}).Validate())
+}
+
+func TestBuilderValidate(t *testing.T) {
+	require.NoError(t, (&Builder{}).Validate())
+	require.NoError(t, (&Builder{Version: "v2.2.4", SHA256: strings.Repeat("a", 64)}).Validate())
+
+	require.Error(t, (&Builder{Version: "v2.2.4"}).Validate())
+	require.Error(t, (&Builder{Version: "latest", SHA256: strings.Repeat("a", 64)}).Validate())
+}
+
+func TestRuntimeValidate(t *testing.T) {
+	require.NoError(t, (&RuntimeConfig{}).Validate())
+	require.NoError(t, (&RuntimeConfig{InspectPaths: map[string]InspectPaths{
+		"docker": {Image: []string{"$.Config.Image"}, Pid: []string{"$.State.Pid"}},
+		"pouch":  {UpperDir: []string{"$.Storage.Upper"}, LowerDirs: []string{"$.Storage.Lowers"}},
+	}}).Validate())
+
+	require.EqualError(t, (&RuntimeConfig{InspectPaths: map[string]InspectPaths{
+		"containerd": {Pid: []string{"$.State.Pid"}},
+	}}).Validate(), "invalid engine containerd in inspect_paths, must be docker or pouch")
+	require.ErrorContains(t, (&RuntimeConfig{InspectPaths: map[string]InspectPaths{
+		"docker": {Pid: []string{"State.Pid"}},
+	}}).Validate(), "invalid inspect_paths.docker.pid State.Pid")
+	require.EqualError(t, (&RuntimeConfig{InspectPaths: map[string]InspectPaths{
+		"docker": {MergedDir: []string{"$.Storage.Merged"}},
+	}}).Validate(), "inspect_paths.docker.upper_dir is required by lower_dirs and merged_dir")
+	require.EqualError(t, (&RuntimeConfig{InspectPaths: map[string]InspectPaths{
+		"docker": {UpperDir: []string{"$.Storage.Upper"}},
+	}}).Validate(), "inspect_paths.docker.upper_dir requires lower_dirs or merged_dir")
+}
+
+func TestBackendValidate(t *testing.T) {
+	require.NoError(t, (&Backend{}).Validate(&OSS{}))
+	require.NoError(t, (&Backend{Type: BackendTypePlugin, Command: "/usr/bin/blob-plugin"}).Validate(&OSS{}))
+
+	require.Error(t, (&Backend{Type: BackendTypePlugin}).Validate(&OSS{}))
+	require.Error(t, (&Backend{Type: BackendTypePlugin, Command: "/usr/bin/blob-plugin"}).Validate(&OSS{Endpoint: "oss-cn-hangzhou.aliyuncs.com"}))
+	require.Error(t, (&Backend{Type: "s3"}).Validate(&OSS{}))
+
+	cfg := Config{}
+	require.Equal(t, BackendTypeRegistry, cfg.BackendType())
+	cfg.OSS.Endpoint = "oss-cn-hangzhou.aliyuncs.com"
+	require.Equal(t, BackendTypeOSS, cfg.BackendType())
+	cfg = Config{Backend: Backend{Type: BackendTypePlugin}}
+	require.Equal(t, BackendTypePlugin, cfg.BackendType())
+}
+
+func TestSecretScanValidate(t *testing.T) {
+	require.NoError(t, (&SecretScan{}).Validate())
+	require.NoError(t, (&SecretScan{Scanner: SecretScannerBuiltin, Actions: map[string]string{"medium": "block"}, MaxFileSize: "1MiB"}).Validate())
+	require.NoError(t, (&SecretScan{Scanner: SecretScannerExec, Command: "/usr/bin/secret-scanner"}).Validate())
+
+	require.EqualError(t, (&SecretScan{Scanner: SecretScannerExec}).Validate(), "command is required by exec scanner")
+	require.EqualError(t, (&SecretScan{Scanner: "gitleaks"}).Validate(), "invalid scanner gitleaks, must be builtin or exec")
+	require.EqualError(t, (&SecretScan{Scanner: SecretScannerBuiltin, Actions: map[string]string{"high": "fail"}}).Validate(),
+		"parse actions: severity high: invalid action fail, must be one of ignore, warn, block")
+	require.Error(t, (&SecretScan{Scanner: SecretScannerBuiltin, MaxFileSize: "large"}).Validate())
+}
+
+func TestRouting(t *testing.T) {
+	cfg := Config{
+		OSS: OSS{Endpoint: "oss-cn-hangzhou.aliyuncs.com"},
+		Routing: Routing{Rules: []RoutingRule{
+			{MaxSize: "1MiB", Backend: BackendTypeRegistry},
+			{MinSize: "64MiB", Backend: BackendExternal},
+			{Backend: BackendTypeRegistry},
+		}},
+	}
+	require.NoError(t, cfg.Routing.Validate(&cfg))
+	require.Equal(t, BackendTypeRegistry, cfg.BlobBackendType(1024))
+	require.Equal(t, BackendTypeRegistry, cfg.BlobBackendType(32<<20))
+	require.Equal(t, BackendTypeOSS, cfg.BlobBackendType(64<<20))
+
+	cfg.Routing.Rules = []RoutingRule{{MaxSize: "1MiB", Backend: BackendTypeRegistry}}
+	require.Equal(t, BackendTypeOSS, cfg.BlobBackendType(2<<20))
+
+	cfg.Routing.Rules = []RoutingRule{{MinSize: "big", Backend: BackendTypeRegistry}}
+	require.Error(t, cfg.Routing.Validate(&cfg))
+	cfg.Routing.Rules = []RoutingRule{{Backend: "s3"}}
+	require.Error(t, cfg.Routing.Validate(&cfg))
+
+	cfg = Config{Routing: Routing{Rules: []RoutingRule{{Backend: BackendExternal}}}}
+	require.Error(t, cfg.Routing.Validate(&cfg))
+	require.Equal(t, "", cfg.ExternalBackendType())
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of environment variables overriding the config
+// file, the name of each is the path of yaml keys joined by "_" in upper
+// case, e.g. `NYDUS_CLI_OSS_ENDPOINT` for `oss.endpoint` and
+// `NYDUS_CLI_METRICS_SLO_OBJECTIVE` for `metrics.slo.objective`.
+const EnvPrefix = "NYDUS_CLI_"
+
+// applyEnv overrides the fields of `cfg` by the environment variables
+// looked up by `lookup`. The maps and lists (e.g. `NYDUS_CLI_MIRRORS`)
+// are set by a JSON or YAML document replacing the whole value.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	return applyEnvToStruct(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup)
+}
+
+func applyEnvToStruct(value reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	for idx := 0; idx < value.NumField(); idx++ {
+		key, _, _ := strings.Cut(value.Type().Field(idx).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + strings.ToUpper(key)
+		field := value.Field(idx)
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvToStruct(field, name+"_", lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		env, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setField(field, env); err != nil {
+			return errors.Wrapf(err, "invalid env %s", name)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, env string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(env)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(env)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(env, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setField(elem.Elem(), env); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.Map, reflect.Slice:
+		parsed := reflect.New(field.Type())
+		if err := yaml.Unmarshal([]byte(env), parsed.Interface()); err != nil {
+			return err
+		}
+		field.Set(parsed.Elem())
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
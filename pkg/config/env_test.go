@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEnv(t *testing.T) {
+	cfg := Config{
+		OSS: OSS{Endpoint: "oss.example.com", BucketName: "bucket"},
+	}
+	envs := map[string]string{
+		"NYDUS_CLI_OSS_ENDPOINT":             "oss-internal.example.com",
+		"NYDUS_CLI_OSS_ACCESS_KEY_ID":        "id",
+		"NYDUS_CLI_DISTRIBUTION_PASSWORD":    "password",
+		"NYDUS_CLI_ARTIFACT_UID":             "1000",
+		"NYDUS_CLI_MIRROR_PUSH":              "true",
+		"NYDUS_CLI_METRICS_SLO_OBJECTIVE":    "0.99",
+		"NYDUS_CLI_MIRRORS":                  `{"docker.io": ["https://mirror.example.com"]}`,
+		"NYDUS_CLI_REGISTRIES":               "registry.example.com:\n  insecure: 'true'\n",
+		"NYDUS_CLI_NAMING_REVERSE_RULES":     `[{"source": "^(.+)-nydus$", "target": "$1"}]`,
+		"NYDUS_CLI_IDENTITY_ALIYUN_ENDPOINT": "http://metadata.example.com",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := envs[name]
+		return value, ok
+	}
+	require.NoError(t, applyEnv(&cfg, lookup))
+
+	require.Equal(t, "oss-internal.example.com", cfg.OSS.Endpoint)
+	require.Equal(t, "id", cfg.OSS.AccessKeyID)
+	// Kept from config file.
+	require.Equal(t, "bucket", cfg.OSS.BucketName)
+	require.Equal(t, "password", cfg.Distribution.Password)
+	require.Equal(t, 1000, *cfg.Artifact.UID)
+	require.Nil(t, cfg.Artifact.GID)
+	require.True(t, cfg.MirrorPush)
+	require.Equal(t, 0.99, cfg.Metrics.SLO.Objective)
+	require.Equal(t, map[string][]string{"docker.io": {"https://mirror.example.com"}}, cfg.Mirrors)
+	require.Equal(t, "true", cfg.Registries["registry.example.com"].Insecure)
+	require.Equal(t, []RewriteRule{{Source: "^(.+)-nydus$", Target: "$1"}}, cfg.Naming.ReverseRules)
+	require.Equal(t, "http://metadata.example.com", cfg.Identity.Aliyun.Endpoint)
+
+	for name, value := range map[string]string{
+		"NYDUS_CLI_MIRROR_PUSH":           "yes please",
+		"NYDUS_CLI_ARTIFACT_GID":          "root",
+		"NYDUS_CLI_METRICS_SLO_OBJECTIVE": "high",
+		"NYDUS_CLI_MIRRORS":               "[",
+	} {
+		err := applyEnv(&Config{}, func(key string) (string, bool) {
+			return value, key == name
+		})
+		require.Error(t, err, name)
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// variablePattern matches the references of environment variables in
+// config values, e.g. `${OSS_ACCESS_KEY_SECRET}`, and the escaped `$${`.
+// Only the braced names starting with a letter or "_" are expanded, so
+// `$1` and `${1}` in the targets of naming rules are kept.
+var variablePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv expands the references of environment variables looked up by
+// `lookup` in all string values of `cfg`, including the items of lists
+// and maps. A variable not set is an error, or kept as is if `keepUnset`.
+func expandEnv(cfg *Config, lookup func(string) (string, bool), keepUnset bool) error {
+	return expandValue(reflect.ValueOf(cfg).Elem(), "", func(key, value string) (string, error) {
+		var err error
+		expanded := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+			if match == "$${" {
+				return "${"
+			}
+			name := match[2 : len(match)-1]
+			env, ok := lookup(name)
+			if !ok {
+				if !keepUnset && err == nil {
+					err = fmt.Errorf("%s: env %s is not set", key, name)
+				}
+				return match
+			}
+			return env
+		})
+		return expanded, err
+	})
+}
+
+// expandValue replaces the string values in `value` at keys path `key` by
+// `expand` recursively.
+func expandValue(value reflect.Value, key string, expand func(key, value string) (string, error)) error {
+	switch value.Kind() {
+	case reflect.String:
+		expanded, err := expand(key, value.String())
+		if err != nil {
+			return err
+		}
+		value.SetString(expanded)
+	case reflect.Ptr:
+		if !value.IsNil() {
+			return expandValue(value.Elem(), key, expand)
+		}
+	case reflect.Struct:
+		for idx := 0; idx < value.NumField(); idx++ {
+			name, _, _ := strings.Cut(value.Type().Field(idx).Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			if key != "" {
+				name = key + "." + name
+			}
+			if err := expandValue(value.Field(idx), name, expand); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		for idx := 0; idx < value.Len(); idx++ {
+			if err := expandValue(value.Index(idx), fmt.Sprintf("%s[%d]", key, idx), expand); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// The map values aren't addressable, so they are expanded in copies.
+		iter := value.MapRange()
+		for iter.Next() {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			if err := expandValue(elem, fmt.Sprintf("%s.%v", key, iter.Key()), expand); err != nil {
+				return err
+			}
+			value.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+// credential is a credential in config, which can be read from the file
+// of `path` instead if supported.
+type credential struct {
+	key   string
+	value *string
+	path  string
+}
+
+// credentials returns the credentials of `cfg`, the key of each file is
+// the key of credential with "_file".
+func credentials(cfg *Config) []credential {
+	creds := []credential{
+		{"distribution.username", &cfg.Distribution.Username, ""},
+		{"distribution.password", &cfg.Distribution.Password, cfg.Distribution.PasswordFile},
+		{"distribution.token", &cfg.Distribution.Token, cfg.Distribution.TokenFile},
+		{"credential_providers.ecr.secret_access_key", &cfg.CredentialProviders.ECR.SecretAccessKey, cfg.CredentialProviders.ECR.SecretAccessKeyFile},
+		{"credential_providers.acr.access_key_secret", &cfg.CredentialProviders.ACR.AccessKeySecret, cfg.CredentialProviders.ACR.AccessKeySecretFile},
+		{"credential_providers.gcr.key", &cfg.CredentialProviders.GCR.Key, cfg.CredentialProviders.GCR.KeyFile},
+		{"oss.access_key_id", &cfg.OSS.AccessKeyID, cfg.OSS.AccessKeyIDFile},
+		{"oss.access_key_secret", &cfg.OSS.AccessKeySecret, cfg.OSS.AccessKeySecretFile},
+		{"publisher.nats.password", &cfg.Publisher.NATS.Password, cfg.Publisher.NATS.PasswordFile},
+		{"publisher.nats.token", &cfg.Publisher.NATS.Token, cfg.Publisher.NATS.TokenFile},
+		{"scan.vulnerabilities.trivy.token", &cfg.Scan.Vulnerabilities.Trivy.Token, cfg.Scan.Vulnerabilities.Trivy.TokenFile},
+	}
+	for idx := range cfg.Webhooks {
+		webhook := &cfg.Webhooks[idx]
+		creds = append(creds, credential{fmt.Sprintf("webhooks[%d].secret", idx), &webhook.Secret, webhook.SecretFile})
+	}
+	return creds
+}
+
+// readSecretFiles sets the credentials of `cfg` configured by files to
+// the content of files, without the trailing newline which is usually
+// added by editors and `echo`.
+func readSecretFiles(cfg *Config) error {
+	for _, file := range credentials(cfg) {
+		if file.path == "" {
+			continue
+		}
+		if *file.value != "" {
+			return fmt.Errorf("%s and %s_file are mutually exclusive", file.key, file.key)
+		}
+		content, err := os.ReadFile(file.path)
+		if err != nil {
+			return errors.Wrapf(err, "read %s_file", file.key)
+		}
+		*file.value = strings.TrimRight(string(content), "\r\n")
+	}
+	return nil
+}
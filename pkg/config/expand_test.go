@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnv(t *testing.T) {
+	envs := map[string]string{
+		"OSS_SECRET": "secret",
+		"HOST":       "registry.example.com",
+		"TOKEN":      "token",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := envs[name]
+		return value, ok
+	}
+	cfg := Config{
+		OSS: OSS{AccessKeySecret: "${OSS_SECRET}", Endpoint: "$${HOST}"},
+		Mirrors: map[string][]string{
+			"docker.io": {"https://${HOST}/mirror"},
+		},
+		Registries: map[string]Registry{
+			"registry.example.com": {CA: "/etc/${HOST}/ca.pem"},
+		},
+		Naming: Naming{Rules: []RewriteRule{{Source: "^(.+)$", Target: "${HOST}/$1:${1}"}}},
+		Webhooks: []Webhook{{
+			URL:     "https://hooks.example.com",
+			Headers: map[string]string{"Authorization": "Bearer ${TOKEN}"},
+		}},
+	}
+	require.NoError(t, expandEnv(&cfg, lookup, false))
+	require.Equal(t, "secret", cfg.OSS.AccessKeySecret)
+	require.Equal(t, "${HOST}", cfg.OSS.Endpoint)
+	require.Equal(t, []string{"https://registry.example.com/mirror"}, cfg.Mirrors["docker.io"])
+	require.Equal(t, "/etc/registry.example.com/ca.pem", cfg.Registries["registry.example.com"].CA)
+	// The groups of regexp are kept.
+	require.Equal(t, "registry.example.com/$1:${1}", cfg.Naming.Rules[0].Target)
+	require.Equal(t, "Bearer token", cfg.Webhooks[0].Headers["Authorization"])
+
+	cfg = Config{Webhooks: []Webhook{{Secret: "${MISSING}"}}}
+	require.EqualError(t, expandEnv(&cfg, lookup, false), "webhooks[0].secret: env MISSING is not set")
+	require.NoError(t, expandEnv(&cfg, lookup, true))
+	require.Equal(t, "${MISSING}", cfg.Webhooks[0].Secret)
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "oss")
+	require.NoError(t, os.WriteFile(secretPath, []byte("secret\n"), 0600))
+
+	cfg := Config{
+		OSS:      OSS{AccessKeyID: "id", AccessKeySecretFile: secretPath},
+		Webhooks: []Webhook{{SecretFile: secretPath}},
+	}
+	require.NoError(t, readSecretFiles(&cfg))
+	require.Equal(t, "id", cfg.OSS.AccessKeyID)
+	require.Equal(t, "secret", cfg.OSS.AccessKeySecret)
+	require.Equal(t, "secret", cfg.Webhooks[0].Secret)
+
+	cfg = Config{Distribution: Distribution{Password: "password", PasswordFile: secretPath}}
+	require.EqualError(t, readSecretFiles(&cfg), "distribution.password and distribution.password_file are mutually exclusive")
+
+	cfg = Config{Publisher: Publisher{NATS: NATS{TokenFile: filepath.Join(dir, "missing")}}}
+	require.ErrorContains(t, readSecretFiles(&cfg), "read publisher.nats.token_file")
+}
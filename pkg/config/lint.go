@@ -0,0 +1,262 @@
+package config
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Template is the commented config file with all settings, generated by
+// `config init`.
+//
+//go:embed template.yml
+var Template string
+
+// The severities of problems found by Lint, only errors fail validation.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Problem is a problem of config found by Lint.
+type Problem struct {
+	Severity string
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+}
+
+// placeholderPattern matches the variables left unexpanded in values by
+// templating, e.g. `${OSS_ACCESS_KEY}`, `$OSS_ACCESS_KEY` and
+// `{{ .Values.oss.accessKey }}`.
+var placeholderPattern = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}|^\$[A-Z_][A-Z0-9_]*$|\{\{.*\}\}`)
+
+// Lint strictly checks config file content `data` overridden by the
+// environment variables looked up by `lookup`. Besides the validation of
+// Parse, it reports the unknown keys ignored by Parse (e.g. misspelled
+// ones), the required settings missing for the configured backends and
+// hooks, and the suspicious credentials.
+func Lint(data []byte, lookup func(string) (string, bool)) []Problem {
+	problems := []Problem{}
+	errorf := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+	}
+	warnf := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Severity: SeverityWarning, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			errorf("parse config: %s", err)
+			return problems
+		}
+		// The other fields are still parsed.
+		for _, message := range typeErr.Errors {
+			errorf("%s", message)
+		}
+	}
+	if err := applyEnv(&cfg, lookup); err != nil {
+		errorf("parse config from env: %s", err)
+	}
+	// The variables not set are kept to be warned as unexpanded.
+	if err := expandEnv(&cfg, lookup, true); err != nil {
+		errorf("expand config: %s", err)
+	}
+	lintSecretFiles(&cfg, errorf, warnf)
+	if err := cfg.Validate(); err != nil {
+		errorf("%s", err)
+	}
+
+	lintRequired(&cfg, errorf, warnf)
+	lintCredentials(&cfg, errorf, warnf)
+	return problems
+}
+
+// reportFunc reports a problem found by Lint.
+type reportFunc func(format string, args ...interface{})
+
+// setting is the value of config key.
+type setting struct {
+	key   string
+	value string
+}
+
+// lintRequired checks the settings required by the configured backends,
+// TLS, notifications and hooks.
+func lintRequired(cfg *Config, errorf, warnf reportFunc) {
+	oss := cfg.OSS
+	// The access key is set by either the value or its file, which may
+	// not be readable here.
+	accessKeyID := oss.AccessKeyID + oss.AccessKeyIDFile
+	accessKeySecret := oss.AccessKeySecret + oss.AccessKeySecretFile
+	if oss.Endpoint != "" || accessKeyID != "" || accessKeySecret != "" || oss.BucketName != "" {
+		for _, field := range []setting{
+			{"endpoint", oss.Endpoint},
+			{"access_key_id", accessKeyID},
+			{"access_key_secret", accessKeySecret},
+			{"bucket_name", oss.BucketName},
+		} {
+			if field.value == "" {
+				errorf("oss.%s is required by oss backend", field.key)
+			}
+		}
+	}
+
+	lintFile(cfg.Distribution.DockerConfig, "distribution.docker_config", warnf)
+	hosts := []string{}
+	for host := range cfg.Registries {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		registry := cfg.Registries[host]
+		if (registry.Cert == "") != (registry.Key == "") {
+			errorf("registries.%s: cert and key must be set together", host)
+		}
+		for _, path := range []string{registry.CA, registry.Cert, registry.Key} {
+			lintFile(path, fmt.Sprintf("registries.%s", host), warnf)
+		}
+	}
+
+	if socket := cfg.Identity.SPIFFE.Socket; cfg.Identity.Source == "spiffe" && strings.HasPrefix(socket, "unix://") {
+		lintFile(strings.TrimPrefix(socket, "unix://"), "identity.spiffe.socket", warnf)
+	}
+
+	for idx, webhook := range cfg.Webhooks {
+		if webhook.URL == "" {
+			errorf("webhooks[%d].url is required", idx)
+		}
+	}
+	switch cfg.Publisher.Type {
+	case "":
+	case "nats":
+		if cfg.Publisher.NATS.URL == "" {
+			errorf("publisher.nats.url is required by nats publisher")
+		}
+	case "kafka":
+		if cfg.Publisher.Kafka.RESTProxy == "" {
+			errorf("publisher.kafka.rest_proxy is required by kafka publisher")
+		}
+		if cfg.Publisher.Kafka.Topic == "" {
+			errorf("publisher.kafka.topic is required by kafka publisher")
+		}
+	default:
+		errorf("publisher.type %s is invalid, must be one of nats and kafka", cfg.Publisher.Type)
+	}
+
+	if cfg.Secrets.Provider == SecretsProviderVault {
+		vault := cfg.Secrets.Vault
+		for _, path := range []string{vault.CA, vault.SecretIDFile, vault.TokenFile} {
+			lintFile(path, "secrets.vault", warnf)
+		}
+	}
+	for _, cred := range credentials(cfg) {
+		if _, _, ok, err := ParseSecretRef(*cred.value); err != nil {
+			errorf("%s: %s", cred.key, err)
+		} else if ok && cfg.Secrets.Provider == "" {
+			errorf("%s references a secret, but secrets.provider is not set", cred.key)
+		}
+	}
+
+	for _, hook := range []setting{
+		{"pre_commit", cfg.Hooks.PreCommit},
+		{"post_push", cfg.Hooks.PostPush},
+		{"on_failure", cfg.Hooks.OnFailure},
+	} {
+		if hook.value == "" {
+			continue
+		}
+		info, err := os.Stat(hook.value)
+		if err != nil {
+			warnf("hooks.%s: %s", hook.key, err)
+		} else if info.IsDir() || info.Mode()&0111 == 0 {
+			warnf("hooks.%s: %s is not executable", hook.key, hook.value)
+		}
+	}
+}
+
+// lintFile warns about the missing file `path` of `key`, the config may be
+// validated on another node, so it's not an error.
+func lintFile(path, key string, warnf reportFunc) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		warnf("%s: %s", key, err)
+	}
+}
+
+// lintSecretFiles reads the credentials configured by files, the files
+// not readable are warned only like lintFile.
+func lintSecretFiles(cfg *Config, errorf, warnf reportFunc) {
+	for _, file := range credentials(cfg) {
+		if file.path == "" {
+			continue
+		}
+		if *file.value != "" {
+			errorf("%s and %s_file are mutually exclusive", file.key, file.key)
+			continue
+		}
+		content, err := os.ReadFile(file.path)
+		if err != nil {
+			warnf("%s_file: %s", file.key, err)
+			continue
+		}
+		*file.value = strings.TrimRight(string(content), "\r\n")
+	}
+}
+
+// lintCredentials checks the credentials are complete, and have no
+// surrounding spaces or unexpanded variables, which are common mistakes
+// of templating config files.
+func lintCredentials(cfg *Config, errorf, warnf reportFunc) {
+	if (cfg.Distribution.Username == "") != (cfg.Distribution.Password == "" && cfg.Distribution.PasswordFile == "") {
+		errorf("distribution: username and password must be set together")
+	}
+	if (cfg.Publisher.NATS.Username == "") != (cfg.Publisher.NATS.Password == "" && cfg.Publisher.NATS.PasswordFile == "") {
+		errorf("publisher.nats: username and password must be set together")
+	}
+
+	credentials := []setting{
+		{"distribution.username", cfg.Distribution.Username},
+		{"distribution.password", cfg.Distribution.Password},
+		{"distribution.token", cfg.Distribution.Token},
+		{"credential_providers.ecr.secret_access_key", cfg.CredentialProviders.ECR.SecretAccessKey},
+		{"credential_providers.ecr.session_token", cfg.CredentialProviders.ECR.SessionToken},
+		{"credential_providers.acr.access_key_secret", cfg.CredentialProviders.ACR.AccessKeySecret},
+		{"credential_providers.acr.security_token", cfg.CredentialProviders.ACR.SecurityToken},
+		{"credential_providers.gcr.key", cfg.CredentialProviders.GCR.Key},
+		{"oss.access_key_id", cfg.OSS.AccessKeyID},
+		{"oss.access_key_secret", cfg.OSS.AccessKeySecret},
+		{"publisher.nats.password", cfg.Publisher.NATS.Password},
+		{"publisher.nats.token", cfg.Publisher.NATS.Token},
+		{"scan.vulnerabilities.trivy.token", cfg.Scan.Vulnerabilities.Trivy.Token},
+	}
+	for idx, webhook := range cfg.Webhooks {
+		credentials = append(credentials, setting{fmt.Sprintf("webhooks[%d].secret", idx), webhook.Secret})
+	}
+	for _, credential := range credentials {
+		if credential.value == "" {
+			continue
+		}
+		if strings.TrimSpace(credential.value) != credential.value {
+			errorf("%s has leading or trailing spaces", credential.key)
+		}
+		if placeholderPattern.MatchString(credential.value) {
+			warnf("%s looks like an unexpanded variable", credential.key)
+		}
+	}
+
+	if strings.HasPrefix(cfg.OSS.Endpoint, "http://") && cfg.OSS.AccessKeySecret != "" {
+		warnf("oss.endpoint is plain HTTP, the requests signed by access key are not encrypted")
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+// commentedSetting matches the commented settings of template, the comments
+// of prose start with "# ".
+var commentedSetting = regexp.MustCompile(`^#[^ ]`)
+
+func TestTemplate(t *testing.T) {
+	// The template without settings is a valid config.
+	require.Empty(t, Lint([]byte(Template), noEnv))
+
+	lines := strings.Split(Template, "\n")
+	for idx, line := range lines {
+		if commentedSetting.MatchString(line) {
+			lines[idx] = line[1:]
+		}
+	}
+	uncommented := strings.Join(lines, "\n")
+	var cfg Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(uncommented), &cfg))
+
+	// All settings are in template.
+	var doc map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(uncommented), &doc))
+	for _, field := range []string{
+		"distribution", "oss", "backend", "routing", "artifact", "mirrors", "mirror_push", "registries",
+		"identity", "compat", "annotations", "metrics", "naming", "bootstrap", "builder",
+		"webhooks", "publisher", "hooks", "scan", "secrets", "runtime",
+	} {
+		require.Contains(t, doc, field)
+	}
+	var keys []string
+	collectKeys(&keys, reflect.TypeOf(Config{}))
+	require.NotEmpty(t, keys)
+	for _, key := range keys {
+		require.Contains(t, uncommented, key+":", "setting %s is not in template", key)
+	}
+}
+
+// collectKeys appends the yaml keys of the fields of struct type `typ`,
+// recursively into the fields of struct.
+func collectKeys(keys *[]string, typ reflect.Type) {
+	for idx := 0; idx < typ.NumField(); idx++ {
+		key, _, _ := strings.Cut(typ.Field(idx).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		*keys = append(*keys, key)
+		if fieldType := typ.Field(idx).Type; fieldType.Kind() == reflect.Struct {
+			collectKeys(keys, fieldType)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	problems := Lint([]byte(`
+oss:
+  endpont: oss-cn-hangzhou.aliyuncs.com
+  access_key_id: " AKID"
+  access_key_secret: ${OSS_SECRET}
+distribution:
+  username: admin
+registries:
+  registry.example.com:
+    cert: /nonexistent/client.pem
+publisher:
+  type: kafka
+  kafka:
+    topic: nydus
+bootstrap:
+  compression: lz4
+`), noEnv)
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	require.Equal(t, []string{
+		"error: line 3: field endpont not found in type config.OSS",
+		"error: invalid bootstrap config: invalid compression lz4, must be gzip or zstd",
+		"error: oss.endpoint is required by oss backend",
+		"error: oss.bucket_name is required by oss backend",
+		"error: registries.registry.example.com: cert and key must be set together",
+		"warning: registries.registry.example.com: stat /nonexistent/client.pem: no such file or directory",
+		"error: publisher.kafka.rest_proxy is required by kafka publisher",
+		"error: distribution: username and password must be set together",
+		"error: oss.access_key_id has leading or trailing spaces",
+		"warning: oss.access_key_secret looks like an unexpanded variable",
+	}, messages)
+
+	// The settings from env are checked too.
+	problems = Lint([]byte("oss:\n  endpoint: http://oss.example.com\n  bucket_name: nydus\n"), func(name string) (string, bool) {
+		return map[string]string{
+			"NYDUS_CLI_OSS_ACCESS_KEY_ID":     "id",
+			"NYDUS_CLI_OSS_ACCESS_KEY_SECRET": "secret",
+		}[name], strings.HasPrefix(name, "NYDUS_CLI_OSS_ACCESS_KEY")
+	})
+	require.Equal(t, []Problem{{
+		Severity: SeverityWarning,
+		Message:  "oss.endpoint is plain HTTP, the requests signed by access key are not encrypted",
+	}}, problems)
+
+	// The credentials in missing files are warned only.
+	problems = Lint([]byte(`
+oss:
+  endpoint: https://oss.example.com
+  bucket_name: nydus
+  access_key_id: ${OSS_ID}
+  access_key_secret_file: /nonexistent/oss
+`), func(name string) (string, bool) {
+		return "id", name == "OSS_ID"
+	})
+	require.Equal(t, []Problem{{
+		Severity: SeverityWarning,
+		Message:  "oss.access_key_secret_file: open /nonexistent/oss: no such file or directory",
+	}}, problems)
+
+	problems = Lint([]byte("oss: [\n"), noEnv)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0].Message, "parse config")
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ParseOptions parses a JSON or YAML document of command options, keyed by
+// flag names, e.g. `{"container": "docker://xxx", "target": ["ref"]}`. It
+// returns the values of each flag, a list value sets the flag repeatedly.
+func ParseOptions(data []byte) (map[string][]string, error) {
+	var doc map[string]interface{}
+	// JSON is a subset of YAML.
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "parse options document")
+	}
+
+	options := map[string][]string{}
+	for name, value := range doc {
+		values, err := optionValues(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "option %s", name)
+		}
+		options[name] = values
+	}
+
+	return options, nil
+}
+
+func optionValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return []string{}, nil
+	case string, bool, int, int64, uint64, float64:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if _, ok := item.([]interface{}); ok || item == nil {
+				return nil, fmt.Errorf("list item must be a scalar")
+			}
+			itemValues, err := optionValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+// OptionNames returns the sorted option names of `options`.
+func OptionNames(options map[string][]string) []string {
+	names := []string{}
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	options, err := ParseOptions([]byte(`{"container": "docker://abc", "target": ["a:nydus", "b=oci"], "pause-container": true, "maximum-times": 5, "with-path": null}`))
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"container":       {"docker://abc"},
+		"target":          {"a:nydus", "b=oci"},
+		"pause-container": {"true"},
+		"maximum-times":   {"5"},
+		"with-path":       {},
+	}, options)
+	require.Equal(t, []string{"container", "maximum-times", "pause-container", "target", "with-path"}, OptionNames(options))
+
+	options, err = ParseOptions([]byte("container: docker://abc\nwith-path:\n  - /data\n  - '!/data/cache'\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"/data", "!/data/cache"}, options["with-path"])
+
+	_, err = ParseOptions([]byte(`{"target": {"ref": "a"}}`))
+	require.Error(t, err)
+	_, err = ParseOptions([]byte(`{"target": [["a"]]}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SecretRefPrefix is the prefix of credentials fetched from the secrets
+// provider, e.g. `secret:kv/data/nydus/oss#access_key_secret`.
+const SecretRefPrefix = "secret:"
+
+// SecretProvider fetches secrets at runtime, e.g. from HashiCorp Vault.
+type SecretProvider interface {
+	// Secret returns the value of `field` in the secret at `path`.
+	Secret(ctx context.Context, path, field string) (string, error)
+}
+
+// NewSecretProvider returns the provider configured by `cfg`, or nil if
+// not configured.
+func NewSecretProvider(cfg *Secrets) (SecretProvider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case SecretsProviderVault:
+		return NewVault(&cfg.Vault)
+	default:
+		return nil, fmt.Errorf("invalid provider %s", cfg.Provider)
+	}
+}
+
+// ParseSecretRef parses the reference `secret:<path>#<field>` of secret,
+// `ok` is false if `value` isn't a reference.
+func ParseSecretRef(value string) (path, field string, ok bool, err error) {
+	ref, ok := strings.CutPrefix(value, SecretRefPrefix)
+	if !ok {
+		return "", "", false, nil
+	}
+	path, field, found := strings.Cut(ref, "#")
+	if !found || path == "" || field == "" {
+		return "", "", true, fmt.Errorf("invalid secret reference %s, must be in format %s<path>#<field>", value, SecretRefPrefix)
+	}
+	return strings.Trim(path, "/"), field, true, nil
+}
+
+// resolveSecrets replaces the credentials of `cfg` referencing secrets by
+// the secrets fetched from the configured provider, which is only
+// created if any credential is a reference.
+func resolveSecrets(ctx context.Context, cfg *Config) error {
+	var provider SecretProvider
+	for _, cred := range credentials(cfg) {
+		path, field, ok, err := ParseSecretRef(*cred.value)
+		if err != nil {
+			return errors.Wrap(err, cred.key)
+		}
+		if !ok {
+			continue
+		}
+		if cfg.Secrets.Provider == "" {
+			return fmt.Errorf("%s references a secret, but secrets.provider is not set", cred.key)
+		}
+		if provider == nil {
+			if provider, err = NewSecretProvider(&cfg.Secrets); err != nil {
+				return errors.Wrap(err, "create secrets provider")
+			}
+		}
+		value, err := provider.Secret(ctx, path, field)
+		if err != nil {
+			return errors.Wrapf(err, "fetch secret of %s", cred.key)
+		}
+		*cred.value = value
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// vaultTimeout bounds each request to Vault.
+const vaultTimeout = 30 * time.Second
+
+// VaultProvider fetches secrets from HashiCorp Vault, it logins on the
+// first fetch and caches the secrets by path in memory.
+type VaultProvider struct {
+	cfg     *Vault
+	address *url.URL
+	client  *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	secrets map[string]map[string]interface{}
+}
+
+// NewVault returns the provider of Vault server `cfg`.
+func NewVault(cfg *Vault) (*VaultProvider, error) {
+	address, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse address")
+	}
+	if address.Scheme != "http" && address.Scheme != "https" {
+		return nil, fmt.Errorf("invalid address %s, must be http or https", cfg.Address)
+	}
+	tlsConfig, err := remote.LoadTLSConfig(cfg.CA, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "load tls config")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &VaultProvider{
+		cfg:     cfg,
+		address: address,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   vaultTimeout,
+		},
+		secrets: map[string]map[string]interface{}{},
+	}, nil
+}
+
+// vaultResponse is the response of Vault API.
+type vaultResponse struct {
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// request sends request of `method` to API `path` with json `body`.
+func (v *VaultProvider) request(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := v.address.JoinPath("v1", path)
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.cfg.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "read response")
+	}
+	var result vaultResponse
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &result); err != nil && resp.StatusCode == http.StatusOK {
+			return nil, errors.Wrap(err, "parse response")
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.Join(result.Errors, "; "))
+		}
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return &result, nil
+}
+
+// login obtains the token of configured auth method.
+func (v *VaultProvider) login(ctx context.Context) error {
+	mount := v.cfg.AuthMount
+	if mount == "" {
+		mount = v.cfg.Auth
+	}
+	var body map[string]string
+	switch v.cfg.Auth {
+	case VaultAuthAppRole:
+		secretID := v.cfg.SecretID
+		if v.cfg.SecretIDFile != "" {
+			// Read on login, as it may be rotated by an agent.
+			data, err := os.ReadFile(v.cfg.SecretIDFile)
+			if err != nil {
+				return errors.Wrap(err, "read secret id")
+			}
+			secretID = strings.TrimRight(string(data), "\r\n")
+		}
+		body = map[string]string{"role_id": v.cfg.RoleID, "secret_id": secretID}
+	case VaultAuthKubernetes:
+		tokenFile := v.cfg.TokenFile
+		if tokenFile == "" {
+			tokenFile = DefaultVaultTokenFile
+		}
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return errors.Wrap(err, "read service account token")
+		}
+		body = map[string]string{"role": v.cfg.Role, "jwt": strings.TrimSpace(string(data))}
+	default:
+		return fmt.Errorf("invalid auth %s", v.cfg.Auth)
+	}
+
+	resp, err := v.request(ctx, http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", body)
+	if err != nil {
+		return errors.Wrapf(err, "login by %s", v.cfg.Auth)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("login by %s: no token in response", v.cfg.Auth)
+	}
+	v.token = resp.Auth.ClientToken
+	return nil
+}
+
+// Secret returns the value of `field` in the secret at `path`, e.g.
+// "kv/data/nydus/oss" of KV version 2 engine or "secret/nydus/oss" of
+// version 1.
+func (v *VaultProvider) Secret(ctx context.Context, path, field string) (string, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	data, ok := v.secrets[path]
+	if !ok {
+		if v.token == "" {
+			if err := v.login(ctx); err != nil {
+				return "", err
+			}
+		}
+		resp, err := v.request(ctx, http.MethodGet, path, nil)
+		if err != nil {
+			return "", errors.Wrapf(err, "read secret %s", path)
+		}
+		data = resp.Data
+		// The fields of KV version 2 are nested with the metadata.
+		if nested, ok := data["data"].(map[string]interface{}); ok {
+			if _, ok := data["metadata"]; ok {
+				data = nested
+			}
+		}
+		v.secrets[path] = data
+	}
+
+	value, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("field %s not found in secret %s", field, path)
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("field %s of secret %s is not a string", field, path)
+	}
+	return str, nil
+}
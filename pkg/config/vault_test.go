@@ -0,0 +1,171 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves the approle and kubernetes logins, and the KV secrets
+// of `secrets` keyed by path.
+func fakeVault(t *testing.T, secrets map[string]interface{}) (*httptest.Server, *int) {
+	reads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+			if login["role_id"] != "role" || login["secret_id"] != "secret-id" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["invalid role or secret ID"]}`))
+				return
+			}
+		case "/v1/auth/k8s/login":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+			if login["role"] != "nydus-cli" || login["jwt"] != "jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		default:
+			require.Equal(t, http.MethodGet, r.Method)
+			if r.Header.Get("X-Vault-Token") != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors": ["permission denied"]}`))
+				return
+			}
+			require.Equal(t, "team", r.Header.Get("X-Vault-Namespace"))
+			secret, ok := secrets[r.URL.Path[len("/v1/"):]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors": []}`))
+				return
+			}
+			reads++
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": secret})
+			return
+		}
+		w.Write([]byte(`{"auth": {"client_token": "token"}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &reads
+}
+
+func TestVault(t *testing.T) {
+	server, reads := fakeVault(t, map[string]interface{}{
+		"kv/data/nydus/oss": map[string]interface{}{
+			"data":     map[string]interface{}{"access_key_id": "id", "access_key_secret": "secret", "port": 1},
+			"metadata": map[string]interface{}{"version": 1},
+		},
+		"secret/nydus/registry": map[string]interface{}{"password": "password"},
+	})
+	ctx := context.Background()
+
+	vault, err := NewVault(&Vault{
+		Address:   server.URL,
+		Namespace: "team",
+		Auth:      VaultAuthAppRole,
+		RoleID:    "role",
+		SecretID:  "secret-id",
+	})
+	require.NoError(t, err)
+	value, err := vault.Secret(ctx, "kv/data/nydus/oss", "access_key_secret")
+	require.NoError(t, err)
+	require.Equal(t, "secret", value)
+	value, err = vault.Secret(ctx, "kv/data/nydus/oss", "access_key_id")
+	require.NoError(t, err)
+	require.Equal(t, "id", value)
+	// KV version 1.
+	value, err = vault.Secret(ctx, "secret/nydus/registry", "password")
+	require.NoError(t, err)
+	require.Equal(t, "password", value)
+	// The secrets are cached.
+	require.Equal(t, 2, *reads)
+
+	_, err = vault.Secret(ctx, "kv/data/nydus/oss", "missing")
+	require.EqualError(t, err, "field missing not found in secret kv/data/nydus/oss")
+	_, err = vault.Secret(ctx, "kv/data/nydus/oss", "port")
+	require.EqualError(t, err, "field port of secret kv/data/nydus/oss is not a string")
+	_, err = vault.Secret(ctx, "kv/data/missing", "password")
+	require.EqualError(t, err, "read secret kv/data/missing: unexpected status 404 Not Found")
+
+	vault, err = NewVault(&Vault{
+		Address:  server.URL,
+		Auth:     VaultAuthAppRole,
+		RoleID:   "role",
+		SecretID: "wrong",
+	})
+	require.NoError(t, err)
+	_, err = vault.Secret(ctx, "kv/data/nydus/oss", "access_key_secret")
+	require.EqualError(t, err, "login by approle: unexpected status 400 Bad Request: invalid role or secret ID")
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("jwt\n"), 0600))
+	vault, err = NewVault(&Vault{
+		Address:   server.URL,
+		Namespace: "team",
+		Auth:      VaultAuthKubernetes,
+		AuthMount: "k8s",
+		Role:      "nydus-cli",
+		TokenFile: tokenFile,
+	})
+	require.NoError(t, err)
+	value, err = vault.Secret(ctx, "secret/nydus/registry", "password")
+	require.NoError(t, err)
+	require.Equal(t, "password", value)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	server, _ := fakeVault(t, map[string]interface{}{
+		"secret/nydus/registry": map[string]interface{}{"username": "admin", "password": "password"},
+	})
+	cfg := Config{
+		Distribution: Distribution{
+			Username: "secret:secret/nydus/registry#username",
+			Password: "secret:/secret/nydus/registry#password",
+		},
+		OSS: OSS{AccessKeyID: "id"},
+		Secrets: Secrets{
+			Provider: SecretsProviderVault,
+			Vault: Vault{
+				Address:   server.URL,
+				Namespace: "team",
+				Auth:      VaultAuthAppRole,
+				RoleID:    "role",
+				SecretID:  "secret-id",
+			},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+	require.NoError(t, resolveSecrets(context.Background(), &cfg))
+	require.Equal(t, "admin", cfg.Distribution.Username)
+	require.Equal(t, "password", cfg.Distribution.Password)
+	require.Equal(t, "id", cfg.OSS.AccessKeyID)
+
+	cfg = Config{OSS: OSS{AccessKeySecret: "secret:kv/nydus"}}
+	require.EqualError(t, resolveSecrets(context.Background(), &cfg), "oss.access_key_secret: invalid secret reference secret:kv/nydus, must be in format secret:<path>#<field>")
+	cfg = Config{OSS: OSS{AccessKeySecret: "secret:kv/nydus#secret"}}
+	require.EqualError(t, resolveSecrets(context.Background(), &cfg), "oss.access_key_secret references a secret, but secrets.provider is not set")
+
+	// The provider isn't created without references.
+	cfg = Config{Secrets: Secrets{Provider: SecretsProviderVault, Vault: Vault{Address: "ftp://vault"}}}
+	require.NoError(t, resolveSecrets(context.Background(), &cfg))
+}
+
+func TestSecretsValidate(t *testing.T) {
+	for _, secrets := range []Secrets{
+		{Provider: "kms"},
+		{Provider: SecretsProviderVault, Vault: Vault{Auth: VaultAuthKubernetes, Role: "nydus-cli"}},
+		{Provider: SecretsProviderVault, Vault: Vault{Address: "https://vault", Auth: "token"}},
+		{Provider: SecretsProviderVault, Vault: Vault{Address: "https://vault", Auth: VaultAuthAppRole, RoleID: "role"}},
+		{Provider: SecretsProviderVault, Vault: Vault{Address: "https://vault", Auth: VaultAuthKubernetes}},
+	} {
+		require.Error(t, secrets.Validate(), secrets)
+	}
+	require.NoError(t, (&Secrets{}).Validate())
+}
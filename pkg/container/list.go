@@ -0,0 +1,111 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/docker/engine-api/types"
+	"github.com/pkg/errors"
+)
+
+// labelContainerImage is the image of container in pod spec, which is the
+// reference pulled while `Image` may be the image id.
+const labelContainerImage = "io.kubernetes.container.image"
+
+// Summary is a running container listed by engine.
+type Summary struct {
+	// ID is the container id with engine type, e.g. `docker://<id>`.
+	ID    string
+	Name  string
+	Image string
+	// Nydus is set if Image is a nydus image.
+	Nydus bool
+	// Created is the unix time of creation.
+	Created int64
+}
+
+// summaries returns the summaries of `containers` listed by `engineType`.
+func (m *Manager) summaries(engineType EngineType, containers []types.Container) []Summary {
+	result := make([]Summary, 0, len(containers))
+	for _, container := range containers {
+		image := container.Labels[labelContainerImage]
+		if image == "" {
+			image = container.Image
+		}
+		name := container.Labels[labelContainerName]
+		if name == "" && len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		// The image id or a malformed reference is not nydus.
+		isNydus, _ := m.naming.IsNydusRef(image)
+		result = append(result, Summary{
+			ID:      fmt.Sprintf("%s://%s", engineType, container.ID),
+			Name:    name,
+			Image:   image,
+			Nydus:   isNydus,
+			Created: container.Created,
+		})
+	}
+	return result
+}
+
+// List returns the running containers of the engines whose socket exists,
+// the latest created first. The sandboxes of pods are excluded as they
+// have nothing to commit.
+func (m *Manager) List(ctx context.Context) ([]Summary, error) {
+	result := []Summary{}
+	for _, engineType := range engineTypes {
+		available, err := m.engineAvailable(engineType)
+		if err != nil {
+			return nil, err
+		}
+		if !available {
+			continue
+		}
+		containers, err := m.listEngine(ctx, engineType)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, m.summaries(engineType, containers)...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Created > result[j].Created
+	})
+	return result, nil
+}
+
+// engineTypes are the engines listed by List.
+var engineTypes = []EngineType{EngineDocker, EnginePouch}
+
+// engineAvailable checks whether the socket of `engineType` exists.
+func (m *Manager) engineAvailable(engineType EngineType) (bool, error) {
+	addr, err := m.getEngineAddr(engineType)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(addr)
+	return err == nil, nil
+}
+
+// listEngine returns the running containers of `engineType` except the
+// sandboxes of pods.
+func (m *Manager) listEngine(ctx context.Context, engineType EngineType) ([]types.Container, error) {
+	_, _, client, err := m.createClient(ctx, fmt.Sprintf("%s://", engineType))
+	if err != nil {
+		return nil, errors.Wrap(err, "create client")
+	}
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{Limit: -1})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list containers of %s", engineType)
+	}
+	apps := []types.Container{}
+	for _, container := range containers {
+		if container.Labels[labelContainerType] != containerTypeSandbox {
+			apps = append(apps, container)
+		}
+	}
+	return apps, nil
+}
@@ -0,0 +1,26 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+)
+
+func TestSummaries(t *testing.T) {
+	m := &Manager{naming: distribution.DefaultNaming}
+	require.Equal(t, []Summary{
+		{ID: "docker://c1", Name: "web", Image: "nginx:latest_nydus_v2", Nydus: true, Created: 1},
+		{ID: "docker://c2", Name: "app", Image: "registry.example.com/app:v1", Created: 2},
+		{ID: "docker://c3", Image: "sha256:0123"},
+	}, m.summaries(EngineDocker, []types.Container{
+		{ID: "c1", Names: []string{"/web"}, Image: "nginx:latest_nydus_v2", Created: 1},
+		{ID: "c2", Names: []string{"/k8s_app_app-0_default"}, Image: "sha256:4567", Created: 2, Labels: map[string]string{
+			labelContainerName:  "app",
+			labelContainerImage: "registry.example.com/app:v1",
+		}},
+		{ID: "c3", Image: "sha256:0123"},
+	}))
+}
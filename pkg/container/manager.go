@@ -17,16 +17,26 @@ import (
 	"github.com/yalp/jsonpath"
 )
 
+// ErrNotFound is returned if the container is not found by engine.
+var ErrNotFound = errors.New("container not found")
+
 type InspectResult struct {
+	// LowerDirs are in the format of overlay `lowerdir=` option, the
+	// data-only lower dirs (e.g. of composefs) follow "::".
 	LowerDirs string
 	UpperDir  string
 	Image     string
-	Mounts    []Mount
-	Pid       int
+	// Nydus is set if Image is a nydus image, otherwise it's an OCI image
+	// which the base is converted from on commit.
+	Nydus  bool
+	Mounts []Mount
+	Pid    int
 }
 
 type Manager struct {
 	cfg *config.Runtime
+	// Recognizes the nydus images of containers.
+	naming *distribution.Naming
 }
 
 type EngineType string
@@ -54,40 +64,37 @@ func parseID(containerID string) (EngineType, string, error) {
 	return "", "", fmt.Errorf("invalid container id format: %s", containerID)
 }
 
-// parseDir returns dir path in struct data by json path.
-func parseDir(data interface{}, path string) (string, error) {
-	ret, err := jsonpath.Read(data, path)
-	if err != nil {
-		return "", errors.Wrapf(err, "find json path '%s'", path)
-	}
-
-	dir, ok := ret.(string)
-	if !ok {
-		return "", fmt.Errorf("invalid json path value %s", path)
-	}
-
+// checkDir checks dir reported by engine exists.
+func checkDir(dir string) error {
 	if dir == "" {
-		return "", fmt.Errorf("invalid dir string")
+		return fmt.Errorf("invalid dir string")
 	}
 
 	info, err := os.Stat(dir)
 	if err != nil {
-		return "", errors.Wrapf(err, "stat path %s", dir)
+		return errors.Wrapf(err, "stat path %s", dir)
 	}
 
 	if !info.IsDir() {
-		return "", errors.Wrapf(err, "path %s is not a directory", dir)
+		return fmt.Errorf("path %s is not a directory", dir)
 	}
 
-	return dir, nil
+	return nil
 }
 
 func NewManager(cfg *config.Runtime) (*Manager, error) {
 	return &Manager{
-		cfg: cfg,
+		cfg:    cfg,
+		naming: distribution.DefaultNaming,
 	}, nil
 }
 
+// WithNaming recognizes the nydus images of containers by `naming`.
+func (m *Manager) WithNaming(naming *distribution.Naming) *Manager {
+	m.naming = naming
+	return m
+}
+
 func (m *Manager) getEngineAddr(engineType EngineType) (string, error) {
 	switch engineType {
 	case EngineDocker:
@@ -118,24 +125,6 @@ func (m *Manager) createClient(ctx context.Context, containerIDWithType string)
 	return engineType, containerID, client, nil
 }
 
-func (m *Manager) Pause(ctx context.Context, containerIDWithType string) error {
-	_, containerID, client, err := m.createClient(ctx, containerIDWithType)
-	if err != nil {
-		return errors.Wrapf(err, "create client")
-	}
-
-	return client.ContainerPause(ctx, containerID)
-}
-
-func (m *Manager) UnPause(ctx context.Context, containerIDWithType string) error {
-	_, containerID, client, err := m.createClient(ctx, containerIDWithType)
-	if err != nil {
-		return errors.Wrapf(err, "create client")
-	}
-
-	return client.ContainerUnpause(ctx, containerID)
-}
-
 func (m *Manager) inspectImage(ctx context.Context, data interface{}, jsonPath string) (string, error) {
 	_image, err := jsonpath.Read(data, jsonPath)
 	if err != nil {
@@ -157,68 +146,160 @@ func (m *Manager) inspectImage(ctx context.Context, data interface{}, jsonPath s
 	return image, nil
 }
 
-func (m *Manager) Inspect(ctx context.Context, containerIDWithType string) (*InspectResult, error) {
+// isNotFound checks whether `err` of engine is caused by the missing
+// container.
+func isNotFound(err error) bool {
+	return client.IsErrContainerNotFound(err)
+}
+
+func (m *Manager) inspectRaw(ctx context.Context, containerIDWithType string) (EngineType, []byte, error) {
 	engineType, containerID, client, err := m.createClient(ctx, containerIDWithType)
 	if err != nil {
-		return nil, errors.Wrapf(err, "create client")
+		return "", nil, errors.Wrapf(err, "create client")
 	}
 
 	_, bytes, err := client.ContainerInspectWithRaw(ctx, containerID, false)
 	if err != nil {
-		return nil, errors.Wrapf(err, "inspect container")
+		if isNotFound(err) {
+			return "", nil, errors.Wrapf(ErrNotFound, "inspect container %s", containerID)
+		}
+		return "", nil, errors.Wrapf(err, "inspect container")
 	}
 
-	var data interface{}
+	return engineType, bytes, nil
+}
+
+// InspectRaw returns the raw inspect output of container, the values of
+// `Config.Env` are redacted as they may contain secrets.
+func (m *Manager) InspectRaw(ctx context.Context, containerIDWithType string) ([]byte, error) {
+	_, bytes, err := m.inspectRaw(ctx, containerIDWithType)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
 	if err := json.Unmarshal(bytes, &data); err != nil {
 		return nil, errors.Wrapf(err, "unmarshal json")
 	}
-
-	lowerDirs := ""
-	if engineType != EngineDocker {
-		_lowerDirs, err := jsonpath.Read(data, "$.GraphDriver.Data.LowerDir")
-		if err != nil {
-			return nil, errors.Wrapf(err, "find json path '$.GraphDriver.Data.LowerDir'")
-		}
-		var ok bool
-		lowerDirs, ok = _lowerDirs.(string)
-		if !ok {
-			return nil, fmt.Errorf("invalid lower dir string")
-		}
-	} else {
-		mergedDir, err := jsonpath.Read(data, "$.GraphDriver.Data.MergedDir")
-		if err != nil {
-			return nil, errors.Wrapf(err, "find json path '$.GraphDriver.Data.MergedDir'")
+	if config, ok := data["Config"].(map[string]interface{}); ok {
+		if envs, ok := config["Env"].([]interface{}); ok {
+			for idx, env := range envs {
+				if str, ok := env.(string); ok {
+					envs[idx] = strings.SplitN(str, "=", 2)[0] + "=<redacted>"
+				}
+			}
 		}
-		_lowerDirs, err := GetLowerDirs(mergedDir.(string))
-		if err != nil {
-			return nil, errors.Wrapf(err, "get lower dirs for docker")
-		}
-		lowerDirs = strings.Join(_lowerDirs, ",")
 	}
 
-	logrus.Info("container lower dirs: ", lowerDirs)
+	return json.MarshalIndent(data, "", "  ")
+}
+
+// The built-in json paths of image and pid in inspect output, see
+// config.InspectPaths.
+var (
+	defaultImagePaths = []string{`$.Config.Labels["io.kubernetes.container.image"]`, "$.Config.Image"}
+	defaultPidPaths   = []string{"$.State.Pid"}
+)
+
+// inspectPaths returns the json paths configured for `engineType`, the
+// fields not set are empty.
+func (m *Manager) inspectPaths(engineType EngineType) config.InspectPaths {
+	return m.cfg.InspectPaths[string(engineType)]
+}
+
+// inspectPid returns the pid of container process from inspect output.
+func (m *Manager) inspectPid(engineType EngineType, data interface{}) (int, error) {
+	pidPaths := defaultPidPaths
+	if paths := m.inspectPaths(engineType); len(paths.Pid) > 0 {
+		pidPaths = paths.Pid
+	}
+	pidPath, _pid, ok := readFirst(data, pidPaths)
+	if !ok {
+		return 0, fmt.Errorf("find json path '%s'", strings.Join(pidPaths, "', '"))
+	}
+	pid, ok := _pid.(float64)
+	if !ok {
+		return 0, fmt.Errorf("value of %s is %T, not a number", pidPath, _pid)
+	}
+	return int(pid), nil
+}
+
+// inspectDirs returns the lower dirs and the upper dir of container from
+// inspect output by `overrides` and the known schemas, or from the
+// mountinfo of process `pid` if the engine reports no or stale dirs.
+func inspectDirs(engineType EngineType, data interface{}, pid int, overrides ...dirsSchema) (string, string, error) {
+	lowerDirs, upperDir, err := resolveDirs(engineType, data, overrides...)
+	if err != nil {
+		err = errors.Wrap(err, "resolve overlay dirs")
+	} else if err = checkDir(upperDir); err != nil {
+		err = errors.Wrap(err, "check upper dir")
+	}
+	if err == nil || pid <= 0 {
+		return lowerDirs, upperDir, err
+	}
+
+	lowerDirs, upperDir, fallbackErr := GetRootfsDirs(pid)
+	if fallbackErr == nil {
+		fallbackErr = checkDir(upperDir)
+	}
+	if fallbackErr != nil {
+		return "", "", fmt.Errorf("%w, fall back to mountinfo of pid %d: %s", err, pid, fallbackErr)
+	}
+	logrus.WithError(err).Warnf("resolved overlay dirs from mountinfo of pid %d", pid)
+	return lowerDirs, upperDir, nil
+}
+
+func (m *Manager) Inspect(ctx context.Context, containerIDWithType string) (*InspectResult, error) {
+	engineType, bytes, err := m.inspectRaw(ctx, containerIDWithType)
+	if err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal json")
+	}
 
-	upperDir, err := parseDir(data, "$.GraphDriver.Data.UpperDir")
+	paths := m.inspectPaths(engineType)
+	pid, err := m.inspectPid(engineType, data)
 	if err != nil {
-		return nil, errors.Wrapf(err, "parse upper dir")
+		return nil, err
 	}
 
-	jsonPath := "$.Config.Labels[\"io.kubernetes.container.image\"]"
-	image, err := m.inspectImage(ctx, data, jsonPath)
+	var overrides []dirsSchema
+	if len(paths.UpperDir) > 0 {
+		overrides = append(overrides, dirsSchema{
+			name:      fmt.Sprintf("runtime.inspect_paths.%s", engineType),
+			lowerDirs: paths.LowerDirs,
+			mergedDir: paths.MergedDir,
+			upperDir:  paths.UpperDir,
+		})
+	}
+	lowerDirs, upperDir, err := inspectDirs(engineType, data, pid, overrides...)
 	if err != nil {
-		jsonPath = "$.Config.Image"
-		logrus.Warnf("failed to inspect image: %s, retry json path '%s'", err.Error(), jsonPath)
-		image, err = m.inspectImage(ctx, data, jsonPath)
-		if err != nil {
-			return nil, errors.Wrapf(err, "inspect container image name")
+		return nil, err
+	}
+	logrus.Info("container lower dirs: ", lowerDirs)
+
+	imagePaths := defaultImagePaths
+	if len(paths.Image) > 0 {
+		imagePaths = paths.Image
+	}
+	var image string
+	for idx, jsonPath := range imagePaths {
+		if image, err = m.inspectImage(ctx, data, jsonPath); err == nil {
+			break
+		}
+		if idx+1 < len(imagePaths) {
+			logrus.Warnf("failed to inspect image: %s, retry json path '%s'", err.Error(), imagePaths[idx+1])
 		}
 	}
-	hasNydusSuffix, err := distribution.HasNydusSuffix(image)
 	if err != nil {
-		return nil, errors.Wrapf(err, "check nydus image name '%s'", image)
+		return nil, errors.Wrapf(err, "inspect container image name")
 	}
-	if !hasNydusSuffix {
-		return nil, fmt.Errorf("invalid nydus image name '%s'", image)
+	isNydus, err := m.naming.IsNydusRef(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "check nydus image name '%s'", image)
 	}
 
 	_mounts, err := jsonpath.Read(data, "$.Mounts")
@@ -234,16 +315,11 @@ func (m *Manager) Inspect(ctx context.Context, containerIDWithType string) (*Ins
 		})
 	}
 
-	_pid, err := jsonpath.Read(data, "$.State.Pid")
-	if err != nil {
-		return nil, errors.Wrapf(err, "find json path '%s'", "$.State.Pid")
-	}
-	pid := int(_pid.(float64))
-
 	return &InspectResult{
 		LowerDirs: lowerDirs,
 		UpperDir:  upperDir,
 		Image:     image,
+		Nydus:     isNydus,
 		Mounts:    mounts,
 		Pid:       pid,
 	}, nil
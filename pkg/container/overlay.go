@@ -1,40 +1,143 @@
 package container
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/containerd/containerd/mount"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+)
+
+// The overlay options listing lower dirs, `lowerdir=` lists all of them
+// separated by ":" (data-only ones follow "::"), while `lowerdir+=` and
+// `datadir+=` of new mount API append one each, which is used by
+// composefs and EROFS backed snapshotters.
+const (
+	optLowerDir    = "lowerdir="
+	optLowerDirAdd = "lowerdir+="
+	optDataDirAdd  = "datadir+="
+	optUpperDir    = "upperdir="
 )
 
-// findOverlayLowerdirs returns the index of lowerdir in mount's options and
-// all the lowerdir target.
-func findOverlayLowerdirs(opts []string) (int, []string) {
-	var (
-		idx    = -1
-		prefix = "lowerdir="
-	)
-
-	for i, opt := range opts {
-		if strings.HasPrefix(opt, prefix) {
-			idx = i
-			break
+// findOverlayLowerdirs returns the lower dirs in mount's options, the
+// data-only lower dirs are returned separately.
+func findOverlayLowerdirs(opts []string) ([]string, []string) {
+	var lowerDirs, dataDirs []string
+	for _, opt := range opts {
+		switch {
+		case strings.HasPrefix(opt, optLowerDir):
+			dirs := strings.Split(opt[len(optLowerDir):], "::")
+			lowerDirs = append(lowerDirs, strings.Split(dirs[0], ":")...)
+			dataDirs = append(dataDirs, dirs[1:]...)
+		case strings.HasPrefix(opt, optLowerDirAdd):
+			lowerDirs = append(lowerDirs, opt[len(optLowerDirAdd):])
+		case strings.HasPrefix(opt, optDataDirAdd):
+			dataDirs = append(dataDirs, opt[len(optDataDirAdd):])
 		}
 	}
+	return lowerDirs, dataDirs
+}
 
-	if idx == -1 {
-		return -1, nil
+// GetLowerDirs returns the lower dirs of overlay mounted at `mountpoint`,
+// in the format of `lowerdir=` option, see diff.JoinLowerDirs.
+func GetLowerDirs(mountpoint string) (string, error) {
+	info, err := mount.Lookup(mountpoint)
+	if err != nil {
+		return "", fmt.Errorf("lookup mount info for %s", mountpoint)
+	}
+	if info.FSType != "overlay" {
+		return "", fmt.Errorf("%s is mounted as %s, not overlay", mountpoint, info.FSType)
 	}
-	return idx, strings.Split(opts[idx][len(prefix):], ":")
+
+	lowerDirs, dataDirs := findOverlayLowerdirs(strings.Split(info.VFSOptions, ","))
+
+	return diff.JoinLowerDirs(lowerDirs, dataDirs), nil
 }
 
-func GetLowerDirs(mountpoint string) ([]string, error) {
-	info, err := mount.Lookup(mountpoint)
+// procRoot is replaced in tests.
+var procRoot = "/proc"
+
+// GetRootfsDirs returns the lower dirs in the format of `lowerdir=` option
+// and the upper dir of the overlay mounted as the rootfs of process `pid`,
+// from the mountinfo of its own mount namespace. It's the fallback if the
+// engine reports no or stale dirs, e.g. the container is restarted under
+// another graph driver.
+func GetRootfsDirs(pid int) (string, string, error) {
+	file, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "mountinfo"))
 	if err != nil {
-		return nil, fmt.Errorf("lookup mount info for %s", mountpoint)
+		return "", "", err
 	}
+	defer file.Close()
+	return parseRootfsDirs(file)
+}
 
-	_, lowerDirs := findOverlayLowerdirs(strings.Split(info.VFSOptions, ","))
+// parseRootfsDirs parses the dirs of the overlay mounted at "/" in
+// mountinfo `r`, the last one wins if it's mounted over.
+func parseRootfsDirs(r io.Reader) (string, string, error) {
+	var vfsOptions string
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// The fields are separated by " - " into the mount and the super
+		// block ones, see proc(5).
+		mountFields, superFields, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, super := strings.Fields(mountFields), strings.Fields(superFields)
+		if len(fields) < 5 || len(super) < 3 {
+			continue
+		}
+		if unescapeMountInfo(fields[4]) != "/" || super[0] != "overlay" {
+			continue
+		}
+		vfsOptions, found = super[2], true
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if !found {
+		return "", "", fmt.Errorf("not found overlay mounted at /")
+	}
 
-	return lowerDirs, nil
+	opts := strings.Split(vfsOptions, ",")
+	for idx := range opts {
+		opts[idx] = unescapeMountInfo(opts[idx])
+	}
+	lowerDirs, dataDirs := findOverlayLowerdirs(opts)
+	if len(lowerDirs) == 0 {
+		return "", "", fmt.Errorf("not found lower dirs in overlay options %s", vfsOptions)
+	}
+	for _, opt := range opts {
+		if strings.HasPrefix(opt, optUpperDir) {
+			return diff.JoinLowerDirs(lowerDirs, dataDirs), opt[len(optUpperDir):], nil
+		}
+	}
+	return "", "", fmt.Errorf("not found upper dir in overlay options %s, the rootfs is read-only", vfsOptions)
+}
+
+// unescapeMountInfo decodes the octal escapes (e.g. `\040` for space) of
+// the fields in mountinfo.
+func unescapeMountInfo(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+	var b strings.Builder
+	for idx := 0; idx < len(field); idx++ {
+		if field[idx] == '\\' && idx+4 <= len(field) {
+			if code, err := strconv.ParseUint(field[idx+1:idx+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				idx += 3
+				continue
+			}
+		}
+		b.WriteByte(field[idx])
+	}
+	return b.String()
 }
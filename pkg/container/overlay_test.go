@@ -0,0 +1,88 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+)
+
+func TestFindOverlayLowerdirs(t *testing.T) {
+	for _, tc := range []struct {
+		opts  string
+		lower []string
+		data  []string
+	}{
+		{"rw,lowerdir=/l1:/l2,upperdir=/upper,workdir=/work", []string{"/l1", "/l2"}, nil},
+		// composefs
+		{"ro,lowerdir=/composefs::/objects,redirect_dir=on,metacopy=on", []string{"/composefs"}, []string{"/objects"}},
+		// new mount API
+		{"rw,lowerdir+=/l1,lowerdir+=/l2,datadir+=/d1,datadir+=/d2,upperdir=/upper", []string{"/l1", "/l2"}, []string{"/d1", "/d2"}},
+		{"rw,upperdir=/upper", nil, nil},
+	} {
+		lower, data := findOverlayLowerdirs(strings.Split(tc.opts, ","))
+		require.Equal(t, tc.lower, lower, tc.opts)
+		require.Equal(t, tc.data, data, tc.opts)
+	}
+}
+
+func TestParseRootfsDirs(t *testing.T) {
+	mountInfo := `1196 1004 0:120 / / rw,relatime master:380 - overlay overlay rw,lowerdir=/var/lib/docker/overlay2/l/A:/var/lib/docker/overlay2/l/B,upperdir=/var/lib/docker/overlay2/c1/diff,workdir=/var/lib/docker/overlay2/c1/work
+1197 1196 0:123 / /proc rw,nosuid,nodev,noexec,relatime - proc proc rw
+1215 1196 259:1 /var/lib/docker/containers/c1/hosts /etc/hosts rw,relatime - ext4 /dev/nvme0n1p1 rw
+`
+	lower, upper, err := parseRootfsDirs(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+	require.Equal(t, "/var/lib/docker/overlay2/l/A:/var/lib/docker/overlay2/l/B", lower)
+	require.Equal(t, "/var/lib/docker/overlay2/c1/diff", upper)
+
+	// The escaped paths and the new mount API.
+	mountInfo = `1196 1004 0:120 / / rw,relatime shared:1 master:380 - overlay overlay rw,lowerdir+=/data/my\040layers/l1,lowerdir+=/data/l\0542,datadir+=/data/objects,upperdir=/data/upper\040dir,workdir=/data/work
+`
+	lower, upper, err = parseRootfsDirs(strings.NewReader(mountInfo))
+	require.NoError(t, err)
+	require.Equal(t, diff.JoinLowerDirs([]string{"/data/my layers/l1", "/data/l,2"}, []string{"/data/objects"}), lower)
+	require.Equal(t, "/data/upper dir", upper)
+
+	_, _, err = parseRootfsDirs(strings.NewReader("1196 1004 259:1 / / rw,relatime - ext4 /dev/nvme0n1p1 rw\n"))
+	require.EqualError(t, err, "not found overlay mounted at /")
+	_, _, err = parseRootfsDirs(strings.NewReader("1196 1004 0:120 / / ro,relatime - overlay overlay ro,lowerdir=/l1:/l2\n"))
+	require.EqualError(t, err, "not found upper dir in overlay options ro,lowerdir=/l1:/l2, the rootfs is read-only")
+}
+
+func TestInspectDirs(t *testing.T) {
+	procRoot = t.TempDir()
+	defer func() {
+		procRoot = "/proc"
+	}()
+	upper := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(procRoot, "42"), 0755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(procRoot, "42", "mountinfo"),
+		[]byte(fmt.Sprintf("1196 1004 0:120 / / rw,relatime - overlay overlay rw,lowerdir=/l1:/l2,upperdir=%s,workdir=/work\n", upper)),
+		0644,
+	))
+
+	// The reported upper dir is stale.
+	data := parseJSON(t, `{"GraphDriver": {"Data": {"LowerDir": "/a:/b", "UpperDir": "/gone"}}}`)
+	lower, upperDir, err := inspectDirs(EngineDocker, data, 42)
+	require.NoError(t, err)
+	require.Equal(t, "/l1:/l2", lower)
+	require.Equal(t, upper, upperDir)
+
+	// No dirs are reported.
+	lower, upperDir, err = inspectDirs(EngineDocker, parseJSON(t, `{"GraphDriver": {"Name": "vfs"}}`), 42)
+	require.NoError(t, err)
+	require.Equal(t, "/l1:/l2", lower)
+	require.Equal(t, upper, upperDir)
+
+	_, _, err = inspectDirs(EngineDocker, data, 0)
+	require.EqualError(t, err, "check upper dir: stat path /gone: stat /gone: no such file or directory")
+	_, _, err = inspectDirs(EngineDocker, data, 43)
+	require.ErrorContains(t, err, "check upper dir: stat path /gone: stat /gone: no such file or directory, fall back to mountinfo of pid 43: open ")
+}
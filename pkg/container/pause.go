@@ -0,0 +1,274 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tasks "github.com/containerd/containerd/api/services/tasks/v1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// PauseMode is how the container is paused during commit.
+type PauseMode string
+
+const (
+	// PauseEngine pauses by the pause API of docker or pouch.
+	PauseEngine PauseMode = "engine"
+	// PauseCgroup freezes the cgroup of container directly, so the engine
+	// still reports the container running, e.g. to its health checks.
+	PauseCgroup PauseMode = "cgroup"
+	// PauseTask pauses the task of container by the task API of
+	// containerd under the engine.
+	PauseTask PauseMode = "task"
+)
+
+// ParsePauseMode parses `mode`, the empty mode is PauseEngine.
+func ParsePauseMode(mode string) (PauseMode, error) {
+	switch PauseMode(mode) {
+	case "":
+		return PauseEngine, nil
+	case PauseEngine, PauseCgroup, PauseTask:
+		return PauseMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid pause mode %s, must be one of engine, cgroup, task", mode)
+	}
+}
+
+var (
+	// cgroupRoot is where the cgroup filesystems are mounted.
+	cgroupRoot = "/sys/fs/cgroup"
+	// freezeTimeout is the timeout of waiting for the cgroup to be frozen.
+	freezeTimeout = 10 * time.Second
+	// freezeInterval is the interval of checking the freezer state.
+	freezeInterval = 10 * time.Millisecond
+)
+
+// containerdNamespaces are the namespaces of containerd where the engines
+// create the tasks of containers.
+var containerdNamespaces = map[EngineType]string{
+	EngineDocker: "moby",
+	EnginePouch:  "default",
+}
+
+// containerdNamespaceHeader is the grpc metadata of containerd namespace.
+const containerdNamespaceHeader = "containerd-namespace"
+
+// Pause pauses the container in `mode`, the empty mode is PauseEngine.
+func (m *Manager) Pause(ctx context.Context, containerIDWithType string, mode PauseMode) error {
+	switch mode {
+	case PauseEngine, "":
+		_, containerID, client, err := m.createClient(ctx, containerIDWithType)
+		if err != nil {
+			return errors.Wrapf(err, "create client")
+		}
+		return client.ContainerPause(ctx, containerID)
+	case PauseCgroup:
+		freezer, err := m.freezer(ctx, containerIDWithType)
+		if err != nil {
+			return err
+		}
+		return freezer.freeze(ctx)
+	case PauseTask:
+		return m.taskPause(ctx, containerIDWithType, true)
+	default:
+		return fmt.Errorf("invalid pause mode %s", mode)
+	}
+}
+
+// UnPause resumes the container paused in `mode`.
+func (m *Manager) UnPause(ctx context.Context, containerIDWithType string, mode PauseMode) error {
+	switch mode {
+	case PauseEngine, "":
+		_, containerID, client, err := m.createClient(ctx, containerIDWithType)
+		if err != nil {
+			return errors.Wrapf(err, "create client")
+		}
+		return client.ContainerUnpause(ctx, containerID)
+	case PauseCgroup:
+		freezer, err := m.freezer(ctx, containerIDWithType)
+		if err != nil {
+			return err
+		}
+		return freezer.thaw()
+	case PauseTask:
+		return m.taskPause(ctx, containerIDWithType, false)
+	default:
+		return fmt.Errorf("invalid pause mode %s", mode)
+	}
+}
+
+// taskPause pauses the task of container by containerd if `pause` is set,
+// otherwise resumes it. The task has the same id as container.
+func (m *Manager) taskPause(ctx context.Context, containerIDWithType string, pause bool) error {
+	engineType, containerID, err := parseID(containerIDWithType)
+	if err != nil {
+		return errors.Wrap(err, "parse container id")
+	}
+	namespace, ok := containerdNamespaces[engineType]
+	if !ok {
+		return fmt.Errorf("invalid engine type: %s", engineType)
+	}
+
+	conn, err := grpc.DialContext(ctx, "unix://"+m.cfg.ContainerdAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return errors.Wrapf(err, "connect to containerd on %s", m.cfg.ContainerdAddr)
+	}
+	defer conn.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, containerdNamespaceHeader, namespace)
+	client := tasks.NewTasksClient(conn)
+	if pause {
+		_, err = client.Pause(ctx, &tasks.PauseTaskRequest{ContainerID: containerID})
+	} else {
+		_, err = client.Resume(ctx, &tasks.ResumeTaskRequest{ContainerID: containerID})
+	}
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return errors.Wrapf(ErrNotFound, "task %s in containerd namespace %s", containerID, namespace)
+		}
+		return errors.Wrapf(err, "containerd task of %s in namespace %s", containerID, namespace)
+	}
+	return nil
+}
+
+// freezer is the freezer of a cgroup v1 or v2.
+type freezer struct {
+	// dir is the cgroup dir.
+	dir string
+	v2  bool
+}
+
+// freezer returns the freezer of the cgroup of container process.
+func (m *Manager) freezer(ctx context.Context, containerIDWithType string) (*freezer, error) {
+	engineType, bytes, err := m.inspectRaw(ctx, containerIDWithType)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(bytes, &data); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal json")
+	}
+	pid, err := m.inspectPid(engineType, data)
+	if err != nil {
+		return nil, err
+	}
+	if pid <= 0 {
+		return nil, fmt.Errorf("container %s is not running", containerIDWithType)
+	}
+
+	file, err := os.Open(filepath.Join(procRoot, fmt.Sprint(pid), "cgroup"))
+	if err != nil {
+		return nil, errors.Wrap(err, "open cgroup of container process")
+	}
+	defer file.Close()
+	return parseFreezer(file)
+}
+
+// parseFreezer finds the freezer in /proc/<pid>/cgroup, the freezer
+// controller of cgroup v1 is preferred in hybrid mode.
+func parseFreezer(r io.Reader) (*freezer, error) {
+	var unified string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[0] == "0" && fields[1] == "" {
+			unified = fields[2]
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			if controller == "freezer" {
+				return &freezer{dir: filepath.Join(cgroupRoot, "freezer", fields[2])}, nil
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read cgroup")
+	}
+	if unified == "" {
+		return nil, fmt.Errorf("not found freezer in cgroup")
+	}
+	return &freezer{dir: filepath.Join(cgroupRoot, unified), v2: true}, nil
+}
+
+// freeze freezes the cgroup and waits until all processes are frozen, the
+// cgroup is thawed if failed.
+func (f *freezer) freeze(ctx context.Context) error {
+	timer := time.NewTimer(freezeTimeout)
+	defer timer.Stop()
+	for {
+		frozen, err := f.tryFreeze()
+		if err != nil {
+			return f.abort(errors.Wrapf(err, "freeze cgroup %s", f.dir))
+		}
+		if frozen {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return f.abort(errors.Wrapf(ctx.Err(), "freeze cgroup %s", f.dir))
+		case <-timer.C:
+			return f.abort(fmt.Errorf("freeze cgroup %s: not frozen in %s", f.dir, freezeTimeout))
+		case <-time.After(freezeInterval):
+		}
+	}
+}
+
+// tryFreeze requests the freeze and checks whether the cgroup is frozen.
+// The freezer of cgroup v1 may stay FREEZING if the processes are in
+// uninterruptible sleep, so FROZEN is requested again on each check.
+func (f *freezer) tryFreeze() (bool, error) {
+	if f.v2 {
+		if err := os.WriteFile(filepath.Join(f.dir, "cgroup.freeze"), []byte("1"), 0644); err != nil {
+			return false, err
+		}
+		events, err := os.ReadFile(filepath.Join(f.dir, "cgroup.events"))
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen 1" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if err := os.WriteFile(filepath.Join(f.dir, "freezer.state"), []byte("FROZEN"), 0644); err != nil {
+		return false, err
+	}
+	state, err := os.ReadFile(filepath.Join(f.dir, "freezer.state"))
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(state)) == "FROZEN", nil
+}
+
+func (f *freezer) abort(err error) error {
+	if thawErr := f.thaw(); thawErr != nil {
+		return fmt.Errorf("%w, thaw: %s", err, thawErr)
+	}
+	return err
+}
+
+// thaw thaws the cgroup.
+func (f *freezer) thaw() error {
+	if f.v2 {
+		return errors.Wrapf(os.WriteFile(filepath.Join(f.dir, "cgroup.freeze"), []byte("0"), 0644), "thaw cgroup %s", f.dir)
+	}
+	return errors.Wrapf(os.WriteFile(filepath.Join(f.dir, "freezer.state"), []byte("THAWED"), 0644), "thaw cgroup %s", f.dir)
+}
@@ -0,0 +1,89 @@
+package container
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePauseMode(t *testing.T) {
+	mode, err := ParsePauseMode("")
+	require.NoError(t, err)
+	require.Equal(t, PauseEngine, mode)
+
+	mode, err = ParsePauseMode("cgroup")
+	require.NoError(t, err)
+	require.Equal(t, PauseCgroup, mode)
+
+	_, err = ParsePauseMode("sigstop")
+	require.EqualError(t, err, "invalid pause mode sigstop, must be one of engine, cgroup, task")
+}
+
+func TestParseFreezer(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = "/cgroup"
+	defer func() {
+		cgroupRoot = root
+	}()
+
+	freezer, err := parseFreezer(strings.NewReader("0::/system.slice/docker-c1.scope\n"))
+	require.NoError(t, err)
+	require.Equal(t, "/cgroup/system.slice/docker-c1.scope", freezer.dir)
+	require.True(t, freezer.v2)
+
+	// Hybrid mode.
+	freezer, err = parseFreezer(strings.NewReader(`12:cpu,cpuacct:/docker/c1
+7:freezer:/docker/c1
+0::/docker/c1
+`))
+	require.NoError(t, err)
+	require.Equal(t, "/cgroup/freezer/docker/c1", freezer.dir)
+	require.False(t, freezer.v2)
+
+	_, err = parseFreezer(strings.NewReader("12:cpu,cpuacct:/docker/c1\n"))
+	require.EqualError(t, err, "not found freezer in cgroup")
+}
+
+func TestFreezer(t *testing.T) {
+	interval := freezeInterval
+	timeout := freezeTimeout
+	freezeInterval = time.Millisecond
+	freezeTimeout = 50 * time.Millisecond
+	defer func() {
+		freezeInterval = interval
+		freezeTimeout = timeout
+	}()
+
+	// The file of cgroup v1 reads what is written.
+	dir := t.TempDir()
+	freezer := &freezer{dir: dir}
+	require.NoError(t, freezer.freeze(context.Background()))
+	state, err := os.ReadFile(filepath.Join(dir, "freezer.state"))
+	require.NoError(t, err)
+	require.Equal(t, "FROZEN", string(state))
+	require.NoError(t, freezer.thaw())
+	state, err = os.ReadFile(filepath.Join(dir, "freezer.state"))
+	require.NoError(t, err)
+	require.Equal(t, "THAWED", string(state))
+
+	// The cgroup v2 is thawed if not frozen in time.
+	dir = t.TempDir()
+	freezer.dir, freezer.v2 = dir, true
+	events := filepath.Join(dir, "cgroup.events")
+	require.NoError(t, os.WriteFile(events, []byte("populated 1\nfrozen 0\n"), 0644))
+	require.EqualError(t, freezer.freeze(context.Background()), "freeze cgroup "+dir+": not frozen in 50ms")
+	state, err = os.ReadFile(filepath.Join(dir, "cgroup.freeze"))
+	require.NoError(t, err)
+	require.Equal(t, "0", string(state))
+
+	require.NoError(t, os.WriteFile(events, []byte("populated 1\nfrozen 1\n"), 0644))
+	require.NoError(t, freezer.freeze(context.Background()))
+	state, err = os.ReadFile(filepath.Join(dir, "cgroup.freeze"))
+	require.NoError(t, err)
+	require.Equal(t, "1", string(state))
+}
@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/filters"
+	"github.com/pkg/errors"
+)
+
+// The labels of containers created by kubelet (dockershim or cri-dockerd),
+// pouch keeps the same labels for the containers created by CRI.
+const (
+	labelPodNamespace  = "io.kubernetes.pod.namespace"
+	labelPodName       = "io.kubernetes.pod.name"
+	labelContainerName = "io.kubernetes.container.name"
+	labelContainerType = "io.kubernetes.docker.type"
+)
+
+// containerTypeSandbox is the type of pause container holding namespaces
+// of pod, which has nothing to commit.
+const containerTypeSandbox = "podsandbox"
+
+// PodContainer is a container of kubernetes pod.
+type PodContainer struct {
+	// ID is the container id with engine type, e.g. `docker://<id>`.
+	ID string
+	// Name is the name of container in pod spec.
+	Name string
+}
+
+// ParsePod returns the engine type, namespace and name of pod in format
+// `[<engine>://]<namespace>/<name>`, the engine is docker by default.
+func ParsePod(pod string) (EngineType, string, string, error) {
+	engineType, namespacedName, err := parseID(pod)
+	if err != nil {
+		return "", "", "", err
+	}
+	if engineType == EngineUnknown {
+		engineType = EngineDocker
+	}
+	namespace, name, ok := strings.Cut(namespacedName, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", "", fmt.Errorf("invalid pod %s, expected format is [<engine>://]<namespace>/<name>", pod)
+	}
+	return engineType, namespace, name, nil
+}
+
+// podContainers returns the app containers of pod in `containers` sorted
+// by name, the sandbox is excluded. Only the latest one is kept if there
+// are several instances of a container.
+func podContainers(engineType EngineType, containers []types.Container) []PodContainer {
+	latest := map[string]types.Container{}
+	for _, container := range containers {
+		name := container.Labels[labelContainerName]
+		if name == "" || container.Labels[labelContainerType] == containerTypeSandbox {
+			continue
+		}
+		if prev, ok := latest[name]; ok && prev.Created >= container.Created {
+			continue
+		}
+		latest[name] = container
+	}
+
+	result := make([]PodContainer, 0, len(latest))
+	for name, container := range latest {
+		result = append(result, PodContainer{
+			ID:   fmt.Sprintf("%s://%s", engineType, container.ID),
+			Name: name,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// PodContainers resolves the running app containers of `pod` by the labels
+// of kubernetes, see ParsePod for the format of `pod`.
+func (m *Manager) PodContainers(ctx context.Context, pod string) ([]PodContainer, error) {
+	engineType, namespace, name, err := ParsePod(pod)
+	if err != nil {
+		return nil, err
+	}
+	_, _, client, err := m.createClient(ctx, fmt.Sprintf("%s://%s/%s", engineType, namespace, name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "create client")
+	}
+
+	filter := filters.NewArgs()
+	filter.Add("label", labelPodNamespace+"="+namespace)
+	filter.Add("label", labelPodName+"="+name)
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{
+		Limit:  -1,
+		Filter: filter,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "list containers of pod %s/%s", namespace, name)
+	}
+
+	result := podContainers(engineType, containers)
+	if len(result) == 0 {
+		return nil, errors.Wrapf(ErrNotFound, "no running container in pod %s/%s", namespace, name)
+	}
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package container
+
+import (
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePod(t *testing.T) {
+	engineType, namespace, name, err := ParsePod("default/app-0")
+	require.NoError(t, err)
+	require.Equal(t, EngineDocker, engineType)
+	require.Equal(t, "default", namespace)
+	require.Equal(t, "app-0", name)
+
+	engineType, _, _, err = ParsePod("pouch://default/app-0")
+	require.NoError(t, err)
+	require.Equal(t, EnginePouch, engineType)
+
+	for _, pod := range []string{"app-0", "default/", "/app-0", "default/app/0"} {
+		_, _, _, err := ParsePod(pod)
+		require.Error(t, err, pod)
+	}
+}
+
+func TestPodContainers(t *testing.T) {
+	labels := func(name, containerType string) map[string]string {
+		return map[string]string{
+			labelPodNamespace:  "default",
+			labelPodName:       "app-0",
+			labelContainerName: name,
+			labelContainerType: containerType,
+		}
+	}
+	require.Equal(t, []PodContainer{
+		{ID: "pouch://c3", Name: "app"},
+		{ID: "pouch://c2", Name: "sidecar"},
+	}, podContainers(EnginePouch, []types.Container{
+		{ID: "c0", Labels: labels("POD", containerTypeSandbox)},
+		{ID: "c1", Labels: labels("app", "container"), Created: 1},
+		{ID: "c2", Labels: labels("sidecar", "container"), Created: 2},
+		{ID: "c3", Labels: labels("app", "container"), Created: 3},
+		{ID: "c4", Labels: map[string]string{}},
+	}))
+	require.Empty(t, podContainers(EngineDocker, nil))
+}
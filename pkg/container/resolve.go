@@ -0,0 +1,89 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/engine-api/types"
+	"github.com/pkg/errors"
+)
+
+// fullIDPattern matches the full id of container, which is used as is.
+var fullIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Resolve resolves the container `containerIDWithType` referenced by full
+// id, name or unique id prefix like docker CLI, to its full id with engine
+// type, e.g. `docker://<id>`. The engine type is optional, all engines
+// whose socket exists are searched without it.
+func (m *Manager) Resolve(ctx context.Context, containerIDWithType string) (string, error) {
+	engineType, ref, err := parseID(containerIDWithType)
+	if err != nil {
+		return "", errors.Wrap(err, "parse container id")
+	}
+	if ref == "" {
+		return "", fmt.Errorf("empty container id or name")
+	}
+	if engineType != EngineUnknown && fullIDPattern.MatchString(ref) {
+		return containerIDWithType, nil
+	}
+
+	engines := []EngineType{engineType}
+	if engineType == EngineUnknown {
+		engines = nil
+		for _, engineType := range engineTypes {
+			available, err := m.engineAvailable(engineType)
+			if err != nil {
+				return "", err
+			}
+			if available {
+				engines = append(engines, engineType)
+			}
+		}
+	}
+	listed := map[EngineType][]types.Container{}
+	for _, engineType := range engines {
+		if listed[engineType], err = m.listEngine(ctx, engineType); err != nil {
+			return "", err
+		}
+	}
+
+	return matchContainer(ref, engines, listed)
+}
+
+// matchContainer finds the container `ref` in the containers `listed` by
+// `engines` in the precedence of docker CLI: full id, name, then unique id
+// prefix.
+func matchContainer(ref string, engines []EngineType, listed map[EngineType][]types.Container) (string, error) {
+	var byName, byPrefix []string
+	for _, engineType := range engines {
+		for _, container := range listed[engineType] {
+			id := fmt.Sprintf("%s://%s", engineType, container.ID)
+			if container.ID == ref {
+				return id, nil
+			}
+			for _, name := range container.Names {
+				if strings.TrimPrefix(name, "/") == strings.TrimPrefix(ref, "/") {
+					byName = append(byName, id)
+					break
+				}
+			}
+			if strings.HasPrefix(container.ID, ref) {
+				byPrefix = append(byPrefix, id)
+			}
+		}
+	}
+
+	for _, matched := range [][]string{byName, byPrefix} {
+		switch len(matched) {
+		case 0:
+			continue
+		case 1:
+			return matched[0], nil
+		default:
+			return "", fmt.Errorf("container %s is ambiguous, matches %s", ref, strings.Join(matched, ", "))
+		}
+	}
+	return "", errors.Wrapf(ErrNotFound, "no running container matches %s", ref)
+}
@@ -0,0 +1,48 @@
+package container
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/engine-api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchContainer(t *testing.T) {
+	engines := []EngineType{EngineDocker, EnginePouch}
+	listed := map[EngineType][]types.Container{
+		EngineDocker: {
+			{ID: "abc123", Names: []string{"/web"}},
+			{ID: "abd456", Names: []string{"/abc"}},
+		},
+		EnginePouch: {
+			{ID: "ef7890", Names: []string{"/db"}},
+			{ID: "ef1234", Names: []string{"/web"}},
+		},
+	}
+
+	for ref, expected := range map[string]string{
+		"abc123": "docker://abc123",
+		"abc":    "docker://abd456",
+		"/db":    "pouch://ef7890",
+		"abd":    "docker://abd456",
+		"ef7":    "pouch://ef7890",
+	} {
+		id, err := matchContainer(ref, engines, listed)
+		require.NoError(t, err, ref)
+		require.Equal(t, expected, id, ref)
+	}
+
+	_, err := matchContainer("web", engines, listed)
+	require.EqualError(t, err, "container web is ambiguous, matches docker://abc123, pouch://ef1234")
+	_, err = matchContainer("ab", engines, listed)
+	require.EqualError(t, err, "container ab is ambiguous, matches docker://abc123, docker://abd456")
+
+	id, err := matchContainer("web", engines[:1], listed)
+	require.NoError(t, err)
+	require.Equal(t, "docker://abc123", id)
+
+	_, err = matchContainer("cache", engines, listed)
+	require.True(t, errors.Is(err, ErrNotFound))
+	require.EqualError(t, err, "no running container matches cache: container not found")
+}
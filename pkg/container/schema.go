@@ -0,0 +1,182 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/yalp/jsonpath"
+)
+
+// dirsSchema describes where an engine reports the overlay dirs of
+// container rootfs in inspect output. Each field lists the candidate json
+// paths tried in order.
+type dirsSchema struct {
+	name string
+	// Lower dirs in a string separated by ":" or a string array.
+	lowerDirs []string
+	// Merged dir of which lower dirs are looked up from mount info, used
+	// only if lowerDirs is empty.
+	mergedDir []string
+	upperDir  []string
+}
+
+// dirsSchemas lists the known schemas of each engine, the newer ones go
+// first, and the first one fully resolved wins.
+var dirsSchemas = map[EngineType][]dirsSchema{
+	EngineDocker: {
+		{
+			name:      "docker-overlay2-mountinfo",
+			mergedDir: []string{"$.GraphDriver.Data.MergedDir"},
+			upperDir:  []string{"$.GraphDriver.Data.UpperDir"},
+		},
+		{
+			name:      "docker-overlay2",
+			lowerDirs: []string{"$.GraphDriver.Data.LowerDir"},
+			upperDir:  []string{"$.GraphDriver.Data.UpperDir"},
+		},
+	},
+	EnginePouch: {
+		{
+			name:      "pouch-graphdriver",
+			lowerDirs: []string{"$.GraphDriver.Data.LowerDir", "$.GraphDriver.Data.LowerDirs"},
+			upperDir:  []string{"$.GraphDriver.Data.UpperDir"},
+		},
+		{
+			name:      "pouch-snapshotter",
+			lowerDirs: []string{"$.Snapshotter.Data.LowerDir", "$.Snapshotter.Data.LowerDirs"},
+			upperDir:  []string{"$.Snapshotter.Data.UpperDir"},
+		},
+		{
+			name:      "pouch-snapshotter-mountinfo",
+			mergedDir: []string{"$.Snapshotter.Data.MergedDir"},
+			upperDir:  []string{"$.Snapshotter.Data.UpperDir"},
+		},
+	},
+}
+
+// lookupLowerDirs is replaced in tests.
+var lookupLowerDirs = GetLowerDirs
+
+func readFirst(data interface{}, paths []string) (string, interface{}, bool) {
+	for _, path := range paths {
+		value, err := jsonpath.Read(data, path)
+		if err == nil && value != nil {
+			return path, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func toString(path string, value interface{}) (string, error) {
+	str, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("value of %s is %T, not a string", path, value)
+	}
+	return str, nil
+}
+
+func toLowerDirs(path string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		dirs := []string{}
+		for _, dir := range v {
+			str, ok := dir.(string)
+			if !ok {
+				return "", fmt.Errorf("item of %s is %T, not a string", path, dir)
+			}
+			dirs = append(dirs, str)
+		}
+		return strings.Join(dirs, ":"), nil
+	default:
+		return "", fmt.Errorf("value of %s is %T, neither a string nor an array", path, value)
+	}
+}
+
+// resolve returns lower dirs separated by ":" and upper dir.
+func (s *dirsSchema) resolve(data interface{}) (string, string, error) {
+	upperPath, value, ok := readFirst(data, s.upperDir)
+	if !ok {
+		return "", "", fmt.Errorf("not found upper dir in %s", strings.Join(s.upperDir, ", "))
+	}
+	upperDir, err := toString(upperPath, value)
+	if err != nil {
+		return "", "", err
+	}
+
+	var lowerDirs string
+	if len(s.lowerDirs) > 0 {
+		lowerPath, value, ok := readFirst(data, s.lowerDirs)
+		if !ok {
+			return "", "", fmt.Errorf("not found lower dirs in %s", strings.Join(s.lowerDirs, ", "))
+		}
+		if lowerDirs, err = toLowerDirs(lowerPath, value); err != nil {
+			return "", "", err
+		}
+	} else {
+		mergedPath, value, ok := readFirst(data, s.mergedDir)
+		if !ok {
+			return "", "", fmt.Errorf("not found merged dir in %s", strings.Join(s.mergedDir, ", "))
+		}
+		mergedDir, err := toString(mergedPath, value)
+		if err != nil {
+			return "", "", err
+		}
+		if lowerDirs, err = lookupLowerDirs(mergedDir); err != nil {
+			return "", "", errors.Wrapf(err, "get lower dirs of merged dir %s", mergedDir)
+		}
+	}
+	if lowerDirs == "" {
+		return "", "", fmt.Errorf("empty lower dirs")
+	}
+
+	return lowerDirs, upperDir, nil
+}
+
+// dataKeys lists the keys of storage data in inspect output for error
+// messages.
+func dataKeys(data interface{}) []string {
+	keys := []string{}
+	for _, path := range []string{"$.GraphDriver.Data", "$.Snapshotter.Data"} {
+		value, err := jsonpath.Read(data, path)
+		if err != nil {
+			continue
+		}
+		if m, ok := value.(map[string]interface{}); ok {
+			prefix := strings.TrimPrefix(path, "$.")
+			for key := range m {
+				keys = append(keys, prefix+"."+key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// resolveDirs resolves the overlay dirs from inspect output by the
+// `overrides` configured, then the known schemas of engine.
+func resolveDirs(engineType EngineType, data interface{}, overrides ...dirsSchema) (string, string, error) {
+	schemas, ok := dirsSchemas[engineType]
+	if !ok {
+		return "", "", fmt.Errorf("no dirs schema for engine %s", engineType)
+	}
+	schemas = append(append([]dirsSchema{}, overrides...), schemas...)
+
+	failures := []string{}
+	for idx := range schemas {
+		schema := schemas[idx]
+		lowerDirs, upperDir, err := schema.resolve(data)
+		if err == nil {
+			return lowerDirs, upperDir, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", schema.name, err))
+	}
+
+	return "", "", fmt.Errorf(
+		"unsupported storage data of %s, found fields [%s], tried schemas: %s",
+		engineType, strings.Join(dataKeys(data), ", "), strings.Join(failures, "; "),
+	)
+}
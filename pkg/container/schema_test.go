@@ -0,0 +1,62 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseJSON(t *testing.T, raw string) interface{} {
+	var data interface{}
+	require.NoError(t, json.Unmarshal([]byte(raw), &data))
+	return data
+}
+
+func TestResolveDirs(t *testing.T) {
+	lookupLowerDirs = func(mergedDir string) (string, error) {
+		if mergedDir == "/merged" {
+			return "/l1:/l2", nil
+		}
+		return "", fmt.Errorf("lookup mount info for %s", mergedDir)
+	}
+	defer func() {
+		lookupLowerDirs = GetLowerDirs
+	}()
+
+	for _, tc := range []struct {
+		engine EngineType
+		raw    string
+		lower  string
+	}{
+		{EngineDocker, `{"GraphDriver": {"Data": {"MergedDir": "/merged", "UpperDir": "/upper"}}}`, "/l1:/l2"},
+		// Fall back to LowerDir if mount info is unavailable.
+		{EngineDocker, `{"GraphDriver": {"Data": {"MergedDir": "/gone", "LowerDir": "/a:/b", "UpperDir": "/upper"}}}`, "/a:/b"},
+		{EnginePouch, `{"GraphDriver": {"Data": {"LowerDir": "/a:/b", "UpperDir": "/upper"}}}`, "/a:/b"},
+		{EnginePouch, `{"GraphDriver": {"Data": {"LowerDirs": ["/a", "/b"], "UpperDir": "/upper"}}}`, "/a:/b"},
+		{EnginePouch, `{"Snapshotter": {"Data": {"LowerDir": "/a", "UpperDir": "/upper"}}}`, "/a"},
+		{EnginePouch, `{"Snapshotter": {"Data": {"MergedDir": "/merged", "UpperDir": "/upper"}}}`, "/l1:/l2"},
+	} {
+		lower, upper, err := resolveDirs(tc.engine, parseJSON(t, tc.raw))
+		require.NoError(t, err, tc.raw)
+		require.Equal(t, tc.lower, lower, tc.raw)
+		require.Equal(t, "/upper", upper, tc.raw)
+	}
+
+	// The configured paths take precedence over the known schemas.
+	override := dirsSchema{name: "runtime.inspect_paths.docker", lowerDirs: []string{"$.Storage.Lowers"}, upperDir: []string{"$.Storage.Upper"}}
+	lower, upper, err := resolveDirs(EngineDocker, parseJSON(t, `{"Storage": {"Lowers": ["/c", "/d"], "Upper": "/custom"}, "GraphDriver": {"Data": {"LowerDir": "/a:/b", "UpperDir": "/upper"}}}`), override)
+	require.NoError(t, err)
+	require.Equal(t, "/c:/d", lower)
+	require.Equal(t, "/custom", upper)
+	_, _, err = resolveDirs(EngineDocker, parseJSON(t, `{}`), override)
+	require.ErrorContains(t, err, "runtime.inspect_paths.docker: not found upper dir in $.Storage.Upper")
+
+	_, _, err = resolveDirs(EnginePouch, parseJSON(t, `{"GraphDriver": {"Data": {"Lower": "/a", "UpperDir": "/upper"}}}`))
+	require.ErrorContains(t, err, "found fields [GraphDriver.Data.Lower, GraphDriver.Data.UpperDir]")
+	require.ErrorContains(t, err, "pouch-graphdriver: not found lower dirs")
+
+	_, _, err = resolveDirs(EngineUnknown, parseJSON(t, `{}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,211 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+var (
+	// acrPersonalHost matches the registry of ACR personal edition, the
+	// submatch is the region.
+	acrPersonalHost = regexp.MustCompile(`^registry(?:-intl)?(?:-vpc)?\.([a-z0-9-]+)\.aliyuncs\.com$`)
+	// acrEnterpriseHost matches the registry of ACR enterprise edition,
+	// the submatches are the instance name and the region.
+	acrEnterpriseHost = regexp.MustCompile(`^([a-z0-9-]+?)-registry(?:-vpc)?\.([a-z0-9-]+)\.cr\.aliyuncs\.com$`)
+)
+
+// acrProvider obtains the temporary credentials of ACR registry, valid for
+// an hour, by the keys of RAM user or role.
+type acrProvider struct {
+	endpoint    string
+	instanceIDs map[string]string
+	client      *http.Client
+	keys        *aliyunKeysSource
+	cache       cache
+}
+
+func newACRProvider(cfg *config.ACR) *acrProvider {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &acrProvider{
+		endpoint:    strings.TrimSuffix(cfg.Endpoint, "/"),
+		instanceIDs: cfg.InstanceIDs,
+		client:      client,
+		keys:        newAliyunKeysSource(cfg.AccessKeyID, cfg.AccessKeySecret, cfg.SecurityToken, cfg.RAMRole, cfg.MetadataEndpoint, client),
+	}
+}
+
+func (p *acrProvider) Match(host string) bool {
+	return acrPersonalHost.MatchString(host) || acrEnterpriseHost.MatchString(host)
+}
+
+func (p *acrProvider) regionEndpoint(region string) string {
+	if p.endpoint != "" {
+		return p.endpoint
+	}
+	return fmt.Sprintf("https://cr.%s.aliyuncs.com", region)
+}
+
+func (p *acrProvider) Credentials(ctx context.Context, host string) (string, string, error) {
+	creds, err := p.cache.get(host, func() (credentials, error) {
+		keys, err := p.keys.get(ctx)
+		if err != nil {
+			return credentials{}, err
+		}
+		if match := acrEnterpriseHost.FindStringSubmatch(host); match != nil {
+			return p.enterpriseToken(ctx, keys, host, match[1], match[2])
+		}
+		if match := acrPersonalHost.FindStringSubmatch(host); match != nil {
+			return p.personalToken(ctx, keys, match[1])
+		}
+		return credentials{}, fmt.Errorf("not an ACR registry")
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "get authorization token of %s", host)
+	}
+	return creds.username, creds.password, nil
+}
+
+func (p *acrProvider) do(req *http.Request, result interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return errors.Wrap(err, "read response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("%s: %s", apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return errors.Wrap(json.Unmarshal(data, result), "unmarshal response")
+}
+
+// personalToken gets the token of personal edition by the ROA API.
+func (p *acrProvider) personalToken(ctx context.Context, keys *aliyunKeys, region string) (credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.regionEndpoint(region)+"/tokens", nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("X-Acs-Version", "2016-06-07")
+	req.Header.Set("X-Acs-Region-Id", region)
+	signROA(req, keys, time.Now())
+
+	var result struct {
+		Data struct {
+			AuthorizationToken string `json:"authorizationToken"`
+			TempUserName       string `json:"tempUserName"`
+			// ExpireDate is the epoch time in milliseconds.
+			ExpireDate int64 `json:"expireDate"`
+		} `json:"data"`
+	}
+	if err := p.do(req, &result); err != nil {
+		return credentials{}, err
+	}
+	if result.Data.AuthorizationToken == "" {
+		return credentials{}, fmt.Errorf("no authorization token")
+	}
+	return credentials{
+		username: result.Data.TempUserName,
+		password: result.Data.AuthorizationToken,
+		expires:  time.UnixMilli(result.Data.ExpireDate),
+	}, nil
+}
+
+// rpcResult is the common part of the results of RPC API.
+type rpcResult struct {
+	IsSuccess bool   `json:"IsSuccess"`
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+}
+
+func (r *rpcResult) err() error {
+	if r.IsSuccess {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", r.Code, r.Message)
+}
+
+func (p *acrProvider) rpc(ctx context.Context, keys *aliyunKeys, region string, params url.Values, result interface{}) error {
+	params.Set("Format", "JSON")
+	params.Set("Version", "2018-12-01")
+	params.Set("RegionId", region)
+	signRPC(params, keys, time.Now())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.regionEndpoint(region)+"/?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	return p.do(req, result)
+}
+
+// instanceID returns the id of enterprise instance serving `host`.
+func (p *acrProvider) instanceID(ctx context.Context, keys *aliyunKeys, host, name, region string) (string, error) {
+	if id := p.instanceIDs[host]; id != "" {
+		return id, nil
+	}
+	var result struct {
+		rpcResult
+		Instances []struct {
+			InstanceID   string `json:"InstanceId"`
+			InstanceName string `json:"InstanceName"`
+		} `json:"Instances"`
+	}
+	params := url.Values{"Action": {"ListInstance"}, "InstanceName": {name}, "PageSize": {"100"}}
+	if err := p.rpc(ctx, keys, region, params, &result); err != nil {
+		return "", errors.Wrap(err, "list instances")
+	}
+	if err := result.err(); err != nil {
+		return "", errors.Wrap(err, "list instances")
+	}
+	for _, instance := range result.Instances {
+		if instance.InstanceName == name {
+			return instance.InstanceID, nil
+		}
+	}
+	return "", fmt.Errorf("no instance named %s in %s, set its id in instance_ids", name, region)
+}
+
+// enterpriseToken gets the token of enterprise edition by the RPC API.
+func (p *acrProvider) enterpriseToken(ctx context.Context, keys *aliyunKeys, host, name, region string) (credentials, error) {
+	id, err := p.instanceID(ctx, keys, host, name, region)
+	if err != nil {
+		return credentials{}, err
+	}
+	var result struct {
+		rpcResult
+		AuthorizationToken string `json:"AuthorizationToken"`
+		TempUsername       string `json:"TempUsername"`
+		// ExpireTime is the epoch time in milliseconds.
+		ExpireTime int64 `json:"ExpireTime"`
+	}
+	params := url.Values{"Action": {"GetAuthorizationToken"}, "InstanceId": {id}}
+	if err := p.rpc(ctx, keys, region, params, &result); err != nil {
+		return credentials{}, err
+	}
+	if err := result.err(); err != nil {
+		return credentials{}, err
+	}
+	return credentials{
+		username: result.TempUsername,
+		password: result.AuthorizationToken,
+		expires:  time.UnixMilli(result.ExpireTime),
+	}, nil
+}
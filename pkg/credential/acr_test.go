@@ -0,0 +1,157 @@
+package credential
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestSignRPC(t *testing.T) {
+	// The example in the signature document of Alibaba Cloud RPC API.
+	params := url.Values{
+		"Action":         {"DescribeRegions"},
+		"Format":         {"XML"},
+		"Version":        {"2014-05-26"},
+		"SignatureNonce": {"3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf"},
+	}
+	keys := &aliyunKeys{AccessKeyID: "testid", AccessKeySecret: "testsecret"}
+	signRPC(params, keys, time.Date(2016, 2, 23, 12, 46, 24, 0, time.UTC))
+	require.Equal(t, "OLeaidS1JvxuMvnyHOwuJ+uX5qY=", params.Get("Signature"))
+}
+
+func TestACRMatch(t *testing.T) {
+	p := newACRProvider(&config.ACR{AccessKeyID: "id"})
+	require.True(t, p.Match("registry.cn-hangzhou.aliyuncs.com"))
+	require.True(t, p.Match("registry-vpc.cn-hangzhou.aliyuncs.com"))
+	require.True(t, p.Match("registry-intl.ap-southeast-1.aliyuncs.com"))
+	require.True(t, p.Match("my-team-registry.cn-shanghai.cr.aliyuncs.com"))
+	require.True(t, p.Match("my-team-registry-vpc.cn-shanghai.cr.aliyuncs.com"))
+	require.False(t, p.Match("oss-cn-hangzhou.aliyuncs.com"))
+	require.False(t, p.Match("registry.cn-hangzhou.aliyuncs.com.evil.io"))
+}
+
+// newACRServer serves the ACR APIs with the tokens expiring in `ttl`, and
+// the metadata service with the STS keys of RAM role.
+func newACRServer(t *testing.T, ttl time.Duration, calls *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		fmt.Fprint(w, "ecs-token")
+	})
+	mux.HandleFunc("/latest/meta-data/ram/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "ecs-token", r.Header.Get("X-Aliyun-Ecs-Metadata-Token"))
+		role := strings.TrimPrefix(r.URL.Path, "/latest/meta-data/ram/security-credentials/")
+		if role == "" {
+			fmt.Fprint(w, "node-role")
+			return
+		}
+		require.Equal(t, "node-role", role)
+		fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"STS.id","AccessKeySecret":"secret","SecurityToken":"sts-token","Expiration":"%s"}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	})
+	expireTime := func() int64 {
+		return time.Now().Add(ttl).UnixMilli()
+	}
+	// Personal edition by ROA API.
+	mux.HandleFunc("/tokens", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "2016-06-07", r.Header.Get("X-Acs-Version"))
+		require.Equal(t, "cn-hangzhou", r.Header.Get("X-Acs-Region-Id"))
+		require.Equal(t, "sts-token", r.Header.Get("X-Acs-Security-Token"))
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "acs STS.id:"))
+		n := atomic.AddInt32(calls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"authorizationToken": fmt.Sprintf("personal-%d", n),
+			"tempUserName":       "cr_temp_user",
+			"expireDate":         expireTime(),
+		}})
+	})
+	// Enterprise edition by RPC API.
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		require.Equal(t, "STS.id", query.Get("AccessKeyId"))
+		require.Equal(t, "sts-token", query.Get("SecurityToken"))
+		require.Equal(t, "cn-shanghai", query.Get("RegionId"))
+		require.NotEmpty(t, query.Get("Signature"))
+		switch query.Get("Action") {
+		case "ListInstance":
+			require.Equal(t, "my-team", query.Get("InstanceName"))
+			fmt.Fprint(w, `{"IsSuccess":true,"Instances":[{"InstanceId":"cri-myteam","InstanceName":"my-team"}]}`)
+		case "GetAuthorizationToken":
+			if query.Get("InstanceId") != "cri-myteam" {
+				fmt.Fprint(w, `{"IsSuccess":false,"Code":"INSTANCE_NOT_EXIST","Message":"instance not exist"}`)
+				return
+			}
+			n := atomic.AddInt32(calls, 1)
+			fmt.Fprintf(w, `{"IsSuccess":true,"AuthorizationToken":"enterprise-%d","TempUsername":"cr_temp_user","ExpireTime":%d}`, n, expireTime())
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"Code":"InvalidAction","Message":"invalid action"}`)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestACRCredentials(t *testing.T) {
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "")
+
+	var calls int32
+	server := newACRServer(t, time.Hour, &calls)
+	providers, err := NewProviders(&config.CredentialProviders{ACR: config.ACR{
+		Enabled:          true,
+		Endpoint:         server.URL,
+		MetadataEndpoint: server.URL,
+	}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	username, password, ok, err := providers.Credentials(ctx, "registry-vpc.cn-hangzhou.aliyuncs.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "cr_temp_user", username)
+	require.Equal(t, "personal-1", password)
+
+	username, password, ok, err = providers.Credentials(ctx, "my-team-registry.cn-shanghai.cr.aliyuncs.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "cr_temp_user", username)
+	require.Equal(t, "enterprise-2", password)
+
+	// Cached until about to expire.
+	_, password, _, err = providers.Credentials(ctx, "registry-vpc.cn-hangzhou.aliyuncs.com")
+	require.NoError(t, err)
+	require.Equal(t, "personal-1", password)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// The configured instance id is used without lookup.
+	p := newACRProvider(&config.ACR{
+		Endpoint:         server.URL,
+		MetadataEndpoint: server.URL,
+		InstanceIDs:      map[string]string{"other-registry.cn-shanghai.cr.aliyuncs.com": "cri-other"},
+	})
+	_, _, err = p.Credentials(ctx, "other-registry.cn-shanghai.cr.aliyuncs.com")
+	require.ErrorContains(t, err, "INSTANCE_NOT_EXIST")
+
+	// Refreshed once the token is within the refresh margin of expiry.
+	var expiringCalls int32
+	expiring := newACRServer(t, refreshMargin/2, &expiringCalls)
+	p = newACRProvider(&config.ACR{Endpoint: expiring.URL, MetadataEndpoint: expiring.URL})
+	_, password, err = p.Credentials(ctx, "registry.cn-hangzhou.aliyuncs.com")
+	require.NoError(t, err)
+	require.Equal(t, "personal-1", password)
+	_, password, err = p.Credentials(ctx, "registry.cn-hangzhou.aliyuncs.com")
+	require.NoError(t, err)
+	require.Equal(t, "personal-2", password)
+}
@@ -0,0 +1,235 @@
+package credential
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultAliyunMetadataEndpoint = "http://100.100.100.200"
+
+// aliyunKeys are the credentials of Alibaba Cloud signing requests.
+type aliyunKeys struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecurityToken   string `json:"SecurityToken"`
+	// Expiration of the keys of RAM role, zero for static keys.
+	Expiration time.Time `json:"Expiration"`
+}
+
+// aliyunKeysSource returns the configured keys, the keys in env, or the
+// STS keys of ECS instance RAM role from metadata service in order.
+type aliyunKeysSource struct {
+	static   *aliyunKeys
+	role     string
+	endpoint string
+	client   *http.Client
+
+	mutex  sync.Mutex
+	cached *aliyunKeys
+}
+
+func newAliyunKeysSource(accessKeyID, accessKeySecret, securityToken, role, metadataEndpoint string, client *http.Client) *aliyunKeysSource {
+	source := &aliyunKeysSource{role: role, endpoint: metadataEndpoint, client: client}
+	if source.endpoint == "" {
+		source.endpoint = defaultAliyunMetadataEndpoint
+	}
+	source.endpoint = strings.TrimSuffix(source.endpoint, "/")
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
+		accessKeySecret = os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+		securityToken = os.Getenv("ALIBABA_CLOUD_SECURITY_TOKEN")
+	}
+	if accessKeyID != "" {
+		source.static = &aliyunKeys{
+			AccessKeyID:     accessKeyID,
+			AccessKeySecret: accessKeySecret,
+			SecurityToken:   securityToken,
+		}
+	}
+	return source
+}
+
+func (s *aliyunKeysSource) get(ctx context.Context) (*aliyunKeys, error) {
+	if s.static != nil {
+		return s.static, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.cached != nil && time.Now().Add(refreshMargin).Before(s.cached.Expiration) {
+		return s.cached, nil
+	}
+	keys, err := s.ramRole(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get keys of RAM role")
+	}
+	s.cached = keys
+	return keys, nil
+}
+
+// metadata sends request to the metadata service of ECS.
+func (s *aliyunKeysSource) metadata(ctx context.Context, method, path string, header http.Header) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s: %s", resp.Status, path, strings.TrimSpace(string(data)))
+	}
+	return string(data), nil
+}
+
+func (s *aliyunKeysSource) ramRole(ctx context.Context) (*aliyunKeys, error) {
+	// The metadata service in hardened mode requires the token.
+	token, err := s.metadata(ctx, http.MethodPut, "/latest/api/token", http.Header{
+		"X-Aliyun-Ecs-Metadata-Token-Ttl-Seconds": {"21600"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get metadata token")
+	}
+	header := http.Header{"X-Aliyun-Ecs-Metadata-Token": {token}}
+	role := s.role
+	if role == "" {
+		roles, err := s.metadata(ctx, http.MethodGet, "/latest/meta-data/ram/security-credentials/", header)
+		if err != nil {
+			return nil, errors.Wrap(err, "get RAM role of instance")
+		}
+		role = strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+		if role == "" {
+			return nil, fmt.Errorf("no RAM role attached to instance")
+		}
+	}
+	data, err := s.metadata(ctx, http.MethodGet, "/latest/meta-data/ram/security-credentials/"+url.PathEscape(role), header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get keys of role %s", role)
+	}
+	var keys aliyunKeys
+	if err := json.Unmarshal([]byte(data), &keys); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal keys of role %s", role)
+	}
+	if keys.AccessKeyID == "" {
+		return nil, fmt.Errorf("no keys of role %s", role)
+	}
+	return &keys, nil
+}
+
+func hmacSHA1(key, data string) string {
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// percentEncode encodes by RFC 3986 as required by the signature.
+func percentEncode(value string) string {
+	encoded := url.QueryEscape(value)
+	encoded = strings.ReplaceAll(encoded, "+", "%20")
+	encoded = strings.ReplaceAll(encoded, "*", "%2A")
+	return strings.ReplaceAll(encoded, "%7E", "~")
+}
+
+func signatureNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// signRPC adds the common parameters and the signature of RPC style API to
+// `params` of a GET request.
+func signRPC(params url.Values, keys *aliyunKeys, now time.Time) {
+	params.Set("AccessKeyId", keys.AccessKeyID)
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("Timestamp", now.UTC().Format("2006-01-02T15:04:05Z"))
+	if params.Get("SignatureNonce") == "" {
+		params.Set("SignatureNonce", signatureNonce())
+	}
+	if keys.SecurityToken != "" {
+		params.Set("SecurityToken", keys.SecurityToken)
+	}
+	params.Del("Signature")
+
+	keyNames := make([]string, 0, len(params))
+	for key := range params {
+		keyNames = append(keyNames, key)
+	}
+	sort.Strings(keyNames)
+	pairs := make([]string, 0, len(keyNames))
+	for _, key := range keyNames {
+		pairs = append(pairs, percentEncode(key)+"="+percentEncode(params.Get(key)))
+	}
+	stringToSign := "GET&%2F&" + percentEncode(strings.Join(pairs, "&"))
+	params.Set("Signature", hmacSHA1(keys.AccessKeySecret+"&", stringToSign))
+}
+
+// signROA signs the ROA style API request `req` without body.
+func signROA(req *http.Request, keys *aliyunKeys, now time.Time) {
+	req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Acs-Signature-Method", "HMAC-SHA1")
+	req.Header.Set("X-Acs-Signature-Version", "1.0")
+	if req.Header.Get("X-Acs-Signature-Nonce") == "" {
+		req.Header.Set("X-Acs-Signature-Nonce", signatureNonce())
+	}
+	if keys.SecurityToken != "" {
+		req.Header.Set("X-Acs-Security-Token", keys.SecurityToken)
+	}
+
+	acsHeaders := []string{}
+	for key := range req.Header {
+		if lower := strings.ToLower(key); strings.HasPrefix(lower, "x-acs-") {
+			acsHeaders = append(acsHeaders, lower)
+		}
+	}
+	sort.Strings(acsHeaders)
+	var stringToSign strings.Builder
+	for _, key := range []string{"Accept", "Content-MD5", "Content-Type", "Date"} {
+		stringToSign.WriteString(req.Header.Get(key) + "\n")
+	}
+	for _, key := range acsHeaders {
+		stringToSign.WriteString(key + ":" + req.Header.Get(key) + "\n")
+	}
+	resource := req.URL.Path
+	if req.URL.RawQuery != "" {
+		query := req.URL.Query()
+		keyNames := make([]string, 0, len(query))
+		for key := range query {
+			keyNames = append(keyNames, key)
+		}
+		sort.Strings(keyNames)
+		pairs := make([]string, 0, len(keyNames))
+		for _, key := range keyNames {
+			pairs = append(pairs, key+"="+query.Get(key))
+		}
+		resource += "?" + strings.Join(pairs, "&")
+	}
+	stringToSign.WriteString(resource)
+
+	req.Header.Set("Authorization", fmt.Sprintf("acs %s:%s", keys.AccessKeyID, hmacSHA1(keys.AccessKeySecret, stringToSign.String())))
+}
@@ -0,0 +1,204 @@
+package credential
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultAWSMetadataEndpoint = "http://169.254.169.254"
+
+// awsKeys are the credentials of AWS signing requests.
+type awsKeys struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+	// Expiration of the keys of instance role, zero for static keys.
+	Expiration time.Time `json:"Expiration"`
+}
+
+// awsKeysSource returns the configured keys, the keys in env, or the keys
+// of EC2 instance role from IMDSv2 in order.
+type awsKeysSource struct {
+	static   *awsKeys
+	endpoint string
+	client   *http.Client
+
+	mutex sync.Mutex
+	role  *awsKeys
+}
+
+func newAWSKeysSource(accessKeyID, secretAccessKey, sessionToken, metadataEndpoint string, client *http.Client) *awsKeysSource {
+	source := &awsKeysSource{endpoint: metadataEndpoint, client: client}
+	if source.endpoint == "" {
+		source.endpoint = defaultAWSMetadataEndpoint
+	}
+	source.endpoint = strings.TrimSuffix(source.endpoint, "/")
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKeyID != "" {
+		source.static = &awsKeys{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+			SessionToken:    sessionToken,
+		}
+	}
+	return source
+}
+
+func (s *awsKeysSource) get(ctx context.Context) (*awsKeys, error) {
+	if s.static != nil {
+		return s.static, nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.role != nil && time.Now().Add(refreshMargin).Before(s.role.Expiration) {
+		return s.role, nil
+	}
+	keys, err := s.instanceRole(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get keys of instance role")
+	}
+	s.role = keys
+	return keys, nil
+}
+
+// metadata sends request to the instance metadata service.
+func (s *awsKeysSource) metadata(ctx context.Context, method, path string, header http.Header) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s: %s", resp.Status, path, strings.TrimSpace(string(data)))
+	}
+	return string(data), nil
+}
+
+func (s *awsKeysSource) instanceRole(ctx context.Context) (*awsKeys, error) {
+	token, err := s.metadata(ctx, http.MethodPut, "/latest/api/token", http.Header{
+		"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get metadata token")
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	roles, err := s.metadata(ctx, http.MethodGet, "/latest/meta-data/iam/security-credentials/", header)
+	if err != nil {
+		return nil, errors.Wrap(err, "get instance role")
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return nil, fmt.Errorf("no role attached to instance")
+	}
+	data, err := s.metadata(ctx, http.MethodGet, "/latest/meta-data/iam/security-credentials/"+url.PathEscape(role), header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get keys of role %s", role)
+	}
+	var keys awsKeys
+	if err := json.Unmarshal([]byte(data), &keys); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal keys of role %s", role)
+	}
+	if keys.AccessKeyID == "" {
+		return nil, fmt.Errorf("no keys of role %s", role)
+	}
+	return &keys, nil
+}
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	amzAlgorithm  = "AWS4-HMAC-SHA256"
+)
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signV4 signs request `req` with body `payload` for `service` in `region`
+// by AWS Signature Version 4, all headers of request are signed.
+func signV4(req *http.Request, payload []byte, keys *awsKeys, region, service string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if keys.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", keys.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for idx, value := range values {
+			trimmed[idx] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(key)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, query, canonicalHeaders.String(), signedHeaders, sha256Hex(payload),
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{amzAlgorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+keys.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		amzAlgorithm, keys.AccessKeyID, scope, signedHeaders, signature))
+}
@@ -0,0 +1,96 @@
+// Package credential obtains the short-lived credentials of cloud
+// registries from the identity of node, so the images are pushed to them
+// without docker login out of band.
+package credential
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// refreshMargin is the time before expiry the cached credentials are
+// refreshed, so they never expire during the requests authorized by them.
+const refreshMargin = 10 * time.Minute
+
+// Provider obtains the credentials of the registries it serves.
+type Provider interface {
+	// Match checks whether registry `host` is served by the provider.
+	Match(host string) bool
+	// Credentials returns the username and password of registry `host`.
+	Credentials(ctx context.Context, host string) (string, string, error)
+}
+
+// Providers are the configured providers, the first one matching registry
+// provides its credentials.
+type Providers []Provider
+
+// NewProviders returns the providers enabled by `cfg`.
+func NewProviders(cfg *config.CredentialProviders) (Providers, error) {
+	providers := Providers{}
+	if cfg.ECR.Enabled {
+		providers = append(providers, newECRProvider(&cfg.ECR))
+	}
+	if cfg.ACR.Enabled {
+		providers = append(providers, newACRProvider(&cfg.ACR))
+	}
+	if cfg.GCR.Enabled {
+		provider, err := newGCRProvider(&cfg.GCR)
+		if err != nil {
+			return nil, errors.Wrap(err, "gcr")
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// Credentials returns the credentials of registry `host` from the first
+// provider matching it, `ok` is false if no provider matches.
+func (providers Providers) Credentials(ctx context.Context, host string) (username, password string, ok bool, err error) {
+	for _, provider := range providers {
+		if provider.Match(host) {
+			username, password, err = provider.Credentials(ctx, host)
+			return username, password, true, err
+		}
+	}
+	return "", "", false, nil
+}
+
+type credentials struct {
+	username string
+	password string
+	expires  time.Time
+}
+
+// cache keeps the credentials until they are about to expire, the fetches
+// of the same key are serialized so a refresh is done only once.
+type cache struct {
+	mutex   sync.Mutex
+	entries map[string]credentials
+	now     func() time.Time
+}
+
+func (c *cache) get(key string, fetch func() (credentials, error)) (credentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	if cached, ok := c.entries[key]; ok && now().Add(refreshMargin).Before(cached.expires) {
+		return cached, nil
+	}
+	fetched, err := fetch()
+	if err != nil {
+		return credentials{}, err
+	}
+	if c.entries == nil {
+		c.entries = map[string]credentials{}
+	}
+	c.entries[key] = fetched
+	return fetched, nil
+}
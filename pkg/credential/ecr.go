@@ -0,0 +1,137 @@
+package credential
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// ecrHost matches the registry of ECR, the submatches are the account,
+// the fips suffix, the region and the domain of partition.
+var ecrHost = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.(amazonaws\.com(?:\.cn)?)$`)
+
+// ecrProvider obtains the credentials of ECR registry by the authorization
+// token of its account and region, valid for 12 hours.
+type ecrProvider struct {
+	endpoint string
+	client   *http.Client
+	keys     *awsKeysSource
+	cache    cache
+}
+
+func newECRProvider(cfg *config.ECR) *ecrProvider {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return &ecrProvider{
+		endpoint: strings.TrimSuffix(cfg.Endpoint, "/"),
+		client:   client,
+		keys:     newAWSKeysSource(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken, cfg.MetadataEndpoint, client),
+	}
+}
+
+func (p *ecrProvider) Match(host string) bool {
+	return ecrHost.MatchString(host)
+}
+
+func (p *ecrProvider) Credentials(ctx context.Context, host string) (string, string, error) {
+	match := ecrHost.FindStringSubmatch(host)
+	if match == nil {
+		return "", "", fmt.Errorf("%s is not an ECR registry", host)
+	}
+	account, fips, region, domain := match[1], match[2], match[3], match[4]
+	creds, err := p.cache.get(host, func() (credentials, error) {
+		endpoint := p.endpoint
+		if endpoint == "" && fips != "" {
+			endpoint = fmt.Sprintf("https://ecr-fips.%s.%s", region, domain)
+		} else if endpoint == "" {
+			endpoint = fmt.Sprintf("https://api.ecr.%s.%s", region, domain)
+		}
+		return p.authorizationToken(ctx, endpoint, account, region)
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "get authorization token of %s", host)
+	}
+	return creds.username, creds.password, nil
+}
+
+type ecrAuthorizationData struct {
+	AuthorizationToken string `json:"authorizationToken"`
+	// ExpiresAt is the epoch time in seconds.
+	ExpiresAt float64 `json:"expiresAt"`
+}
+
+type ecrError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (p *ecrProvider) authorizationToken(ctx context.Context, endpoint, account, region string) (credentials, error) {
+	keys, err := p.keys.get(ctx)
+	if err != nil {
+		return credentials{}, err
+	}
+	payload, err := json.Marshal(map[string][]string{"registryIds": {account}})
+	if err != nil {
+		return credentials{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signV4(req, payload, keys, region, "ecr", time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return credentials{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return credentials{}, errors.Wrap(err, "read response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr ecrError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Type != "" {
+			return credentials{}, fmt.Errorf("%s: %s", apiErr.Type, apiErr.Message)
+		}
+		return credentials{}, fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		AuthorizationData []ecrAuthorizationData `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return credentials{}, errors.Wrap(err, "unmarshal response")
+	}
+	if len(result.AuthorizationData) == 0 {
+		return credentials{}, fmt.Errorf("no authorization data")
+	}
+	authData := result.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(authData.AuthorizationToken)
+	if err != nil {
+		return credentials{}, errors.Wrap(err, "decode authorization token")
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return credentials{}, fmt.Errorf("invalid authorization token")
+	}
+	sec, frac := math.Modf(authData.ExpiresAt)
+	return credentials{
+		username: username,
+		password: password,
+		expires:  time.Unix(int64(sec), int64(frac*1e9)),
+	}, nil
+}
@@ -0,0 +1,146 @@
+package credential
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestSignV4(t *testing.T) {
+	// The get-vanilla case of AWS Signature Version 4 test suite.
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	keys := &awsKeys{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	signV4(req, nil, keys, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestECRMatch(t *testing.T) {
+	p := newECRProvider(&config.ECR{AccessKeyID: "id"})
+	require.True(t, p.Match("123456789012.dkr.ecr.us-west-2.amazonaws.com"))
+	require.True(t, p.Match("123456789012.dkr.ecr-fips.us-east-1.amazonaws.com"))
+	require.True(t, p.Match("123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn"))
+	require.False(t, p.Match("public.ecr.aws"))
+	require.False(t, p.Match("123456789012.dkr.ecr.us-west-2.amazonaws.com.evil.io"))
+	require.False(t, p.Match("docker.io"))
+}
+
+// newECRServer serves GetAuthorizationToken with the tokens expiring in
+// `ttl`, and IMDSv2 with the keys of instance role.
+func newECRServer(t *testing.T, ttl time.Duration, calls *int32) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "imds-token")
+	})
+	mux.HandleFunc("/latest/meta-data/iam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		role := strings.TrimPrefix(r.URL.Path, "/latest/meta-data/iam/security-credentials/")
+		if role == "" {
+			fmt.Fprint(w, "node-role\n")
+			return
+		}
+		require.Equal(t, "node-role", role)
+		fmt.Fprintf(w, `{"Code":"Success","AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session","Expiration":"%s"}`,
+			time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", r.Header.Get("X-Amz-Target"))
+		require.Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+		require.Contains(t, r.Header.Get("Authorization"), "Credential=ASIA/")
+		require.Contains(t, r.Header.Get("Authorization"), "/us-west-2/ecr/aws4_request")
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"registryIds":["123456789012"]}`, string(body))
+
+		n := atomic.AddInt32(calls, 1)
+		token := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("AWS:password-%d", n)))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authorizationData": []map[string]interface{}{{
+				"authorizationToken": token,
+				"expiresAt":          float64(time.Now().Add(ttl).Unix()),
+				"proxyEndpoint":      "https://123456789012.dkr.ecr.us-west-2.amazonaws.com",
+			}},
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestECRCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	host := "123456789012.dkr.ecr.us-west-2.amazonaws.com"
+
+	var calls int32
+	server := newECRServer(t, 12*time.Hour, &calls)
+	providers, err := NewProviders(&config.CredentialProviders{ECR: config.ECR{
+		Enabled:          true,
+		Endpoint:         server.URL,
+		MetadataEndpoint: server.URL,
+	}})
+	require.NoError(t, err)
+
+	username, password, ok, err := providers.Credentials(context.Background(), host)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "AWS", username)
+	require.Equal(t, "password-1", password)
+
+	// Cached until about to expire.
+	_, password, _, err = providers.Credentials(context.Background(), host)
+	require.NoError(t, err)
+	require.Equal(t, "password-1", password)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, _, ok, err = providers.Credentials(context.Background(), "docker.io")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Refreshed once the token is within the refresh margin of expiry.
+	var expiringCalls int32
+	expiring := newECRServer(t, refreshMargin/2, &expiringCalls)
+	p := newECRProvider(&config.ECR{Endpoint: expiring.URL, MetadataEndpoint: expiring.URL})
+	_, password, err = p.Credentials(context.Background(), host)
+	require.NoError(t, err)
+	require.Equal(t, "password-1", password)
+	_, password, err = p.Credentials(context.Background(), host)
+	require.NoError(t, err)
+	require.Equal(t, "password-2", password)
+}
+
+func TestECRError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type":"UnrecognizedClientException","message":"The security token included in the request is invalid."}`)
+	}))
+	defer server.Close()
+
+	p := newECRProvider(&config.ECR{Endpoint: server.URL, AccessKeyID: "id", SecretAccessKey: "secret"})
+	_, _, err := p.Credentials(context.Background(), "123456789012.dkr.ecr.us-west-2.amazonaws.com")
+	require.ErrorContains(t, err, "UnrecognizedClientException")
+}
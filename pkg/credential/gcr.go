@@ -0,0 +1,263 @@
+package credential
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+const (
+	defaultGCRMetadataEndpoint = "http://metadata.google.internal"
+	defaultGoogleTokenURL      = "https://oauth2.googleapis.com/token"
+	googleScope                = "https://www.googleapis.com/auth/cloud-platform"
+	// gcrUsername is the username of registry authorized by access token.
+	gcrUsername = "oauth2accesstoken"
+)
+
+// gcrHost matches the registries of Container Registry and Artifact
+// Registry.
+var gcrHost = regexp.MustCompile(`^(?:[a-z0-9-]+\.)?gcr\.io$|^[a-z0-9-]+-docker\.pkg\.dev$`)
+
+// googleKey is the json of application default credentials.
+type googleKey struct {
+	Type string `json:"type"`
+	// Of service account.
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	// Of authorized user, i.e. `gcloud auth application-default login`.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// gcrProvider obtains the OAuth2 access token of Google Cloud, valid for
+// an hour, all registries share the same token.
+type gcrProvider struct {
+	key      string
+	endpoint string
+	client   *http.Client
+	cache    cache
+}
+
+func newGCRProvider(cfg *config.GCR) (*gcrProvider, error) {
+	if cfg.Key != "" {
+		var key googleKey
+		if err := json.Unmarshal([]byte(cfg.Key), &key); err != nil {
+			return nil, errors.Wrap(err, "invalid key")
+		}
+	}
+	endpoint := cfg.MetadataEndpoint
+	if endpoint == "" && os.Getenv("GCE_METADATA_HOST") != "" {
+		endpoint = "http://" + os.Getenv("GCE_METADATA_HOST")
+	} else if endpoint == "" {
+		endpoint = defaultGCRMetadataEndpoint
+	}
+	return &gcrProvider{
+		key:      cfg.Key,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *gcrProvider) Match(host string) bool {
+	return gcrHost.MatchString(host)
+}
+
+func (p *gcrProvider) Credentials(ctx context.Context, host string) (string, string, error) {
+	creds, err := p.cache.get("", func() (credentials, error) {
+		return p.accessToken(ctx)
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "get access token of %s", host)
+	}
+	return creds.username, creds.password, nil
+}
+
+// defaultKey returns the application default credentials in file, empty
+// if there is none.
+func defaultKey() (string, error) {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		content, err := os.ReadFile(path)
+		return string(content), errors.Wrap(err, "read GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "application_default_credentials.json"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), errors.Wrap(err, "read application default credentials")
+}
+
+func (p *gcrProvider) accessToken(ctx context.Context) (credentials, error) {
+	content := p.key
+	if content == "" {
+		var err error
+		if content, err = defaultKey(); err != nil {
+			return credentials{}, err
+		}
+	}
+	if content == "" {
+		return p.metadataToken(ctx)
+	}
+
+	var key googleKey
+	if err := json.Unmarshal([]byte(content), &key); err != nil {
+		return credentials{}, errors.Wrap(err, "unmarshal credentials")
+	}
+	tokenURL := key.TokenURI
+	if tokenURL == "" {
+		tokenURL = defaultGoogleTokenURL
+	}
+	switch key.Type {
+	case "service_account":
+		assertion, err := key.assertion(tokenURL, time.Now())
+		if err != nil {
+			return credentials{}, err
+		}
+		return p.exchange(ctx, tokenURL, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	case "authorized_user":
+		return p.exchange(ctx, tokenURL, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {key.ClientID},
+			"client_secret": {key.ClientSecret},
+			"refresh_token": {key.RefreshToken},
+		})
+	default:
+		return credentials{}, fmt.Errorf("unsupported credentials type %q", key.Type)
+	}
+}
+
+func base64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// assertion returns the JWT signed by the key of service account, which
+// is exchanged for access token.
+func (key *googleKey) assertion(audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("no private key of service account")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "parse private key of service account")
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key of service account is not RSA")
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": key.PrivateKeyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": googleScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64URL(header) + "." + base64URL(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "sign assertion")
+	}
+	return signingInput + "." + base64URL(signature), nil
+}
+
+type googleToken struct {
+	AccessToken string `json:"access_token"`
+	// ExpiresIn is the lifetime of token in seconds.
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (p *gcrProvider) token(req *http.Request) (credentials, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return credentials{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return credentials{}, errors.Wrap(err, "read response")
+	}
+	var token googleToken
+	if err := json.Unmarshal(data, &token); err != nil || resp.StatusCode != http.StatusOK {
+		if token.Error != "" {
+			return credentials{}, fmt.Errorf("%s: %s", token.Error, token.ErrorDescription)
+		}
+		return credentials{}, fmt.Errorf("unexpected status %s from %s: %s", resp.Status, req.URL.Redacted(), strings.TrimSpace(string(data)))
+	}
+	if token.AccessToken == "" {
+		return credentials{}, fmt.Errorf("no access token from %s", req.URL.Redacted())
+	}
+	return credentials{
+		username: gcrUsername,
+		password: token.AccessToken,
+		expires:  time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// exchange exchanges the grant for access token.
+func (p *gcrProvider) exchange(ctx context.Context, tokenURL string, grant url.Values) (credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(grant.Encode()))
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return p.token(req)
+}
+
+// metadataToken gets the access token of the service account of GCE
+// instance, or of the Kubernetes service account mapped by GKE workload
+// identity.
+func (p *gcrProvider) metadataToken(ctx context.Context) (credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	creds, err := p.token(req)
+	return creds, errors.Wrap(err, "get token from metadata server")
+}
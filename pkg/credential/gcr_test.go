@@ -0,0 +1,141 @@
+package credential
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestGCRMatch(t *testing.T) {
+	p, err := newGCRProvider(&config.GCR{})
+	require.NoError(t, err)
+	require.True(t, p.Match("gcr.io"))
+	require.True(t, p.Match("eu.gcr.io"))
+	require.True(t, p.Match("us-central1-docker.pkg.dev"))
+	require.False(t, p.Match("gcr.io.evil.io"))
+	require.False(t, p.Match("docker.io"))
+
+	_, err = newGCRProvider(&config.GCR{Key: "{"})
+	require.ErrorContains(t, err, "invalid key")
+}
+
+// newGoogleServer serves the token endpoint for the service account key
+// of `publicKey` and the authorized user, and the metadata server.
+func newGoogleServer(t *testing.T, publicKey *rsa.PublicKey) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.PostForm.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			parts := strings.Split(r.PostForm.Get("assertion"), ".")
+			require.Len(t, parts, 3)
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			require.NoError(t, err)
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			require.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+			payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+			require.NoError(t, err)
+			var claims map[string]interface{}
+			require.NoError(t, json.Unmarshal(payload, &claims))
+			require.Equal(t, "pusher@project.iam.gserviceaccount.com", claims["iss"])
+			require.Equal(t, server.URL+"/token", claims["aud"])
+			require.Equal(t, googleScope, claims["scope"])
+			fmt.Fprint(w, `{"access_token":"service-account-token","expires_in":3599,"token_type":"Bearer"}`)
+		case "refresh_token":
+			if r.PostForm.Get("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Bad Request"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"user-token","expires_in":3599,"token_type":"Bearer"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"metadata-token","expires_in":3599,"token_type":"Bearer"}`)
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGCRCredentials(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	server := newGoogleServer(t, &privateKey.PublicKey)
+
+	dir := t.TempDir()
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("CLOUDSDK_CONFIG", dir)
+	ctx := context.Background()
+	host := "us-docker.pkg.dev"
+
+	// Service account key configured.
+	key, err := json.Marshal(googleKey{
+		Type:         "service_account",
+		ClientEmail:  "pusher@project.iam.gserviceaccount.com",
+		PrivateKeyID: "key-id",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:     server.URL + "/token",
+	})
+	require.NoError(t, err)
+	providers, err := NewProviders(&config.CredentialProviders{GCR: config.GCR{Enabled: true, Key: string(key)}})
+	require.NoError(t, err)
+	username, password, ok, err := providers.Credentials(ctx, host)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "oauth2accesstoken", username)
+	require.Equal(t, "service-account-token", password)
+
+	// Authorized user in the well-known file of gcloud.
+	userKey := fmt.Sprintf(`{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"refresh","token_uri":"%s/token"}`, server.URL)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "application_default_credentials.json"), []byte(userKey), 0600))
+	p, err := newGCRProvider(&config.GCR{MetadataEndpoint: server.URL})
+	require.NoError(t, err)
+	_, password, err = p.Credentials(ctx, host)
+	require.NoError(t, err)
+	require.Equal(t, "user-token", password)
+
+	// GOOGLE_APPLICATION_CREDENTIALS takes precedence over gcloud.
+	badUserKey := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(badUserKey, []byte(strings.Replace(userKey, `"refresh"`, `"revoked"`, 1)), 0600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", badUserKey)
+	p, err = newGCRProvider(&config.GCR{MetadataEndpoint: server.URL})
+	require.NoError(t, err)
+	_, _, err = p.Credentials(ctx, host)
+	require.ErrorContains(t, err, "invalid_grant")
+
+	// The metadata server without any key file.
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	require.NoError(t, os.Remove(filepath.Join(dir, "application_default_credentials.json")))
+	p, err = newGCRProvider(&config.GCR{MetadataEndpoint: server.URL})
+	require.NoError(t, err)
+	_, password, err = p.Credentials(ctx, "gcr.io")
+	require.NoError(t, err)
+	require.Equal(t, "metadata-token", password)
+}
@@ -33,6 +33,8 @@ import (
 	"github.com/containerd/containerd/pkg/userns"
 	"github.com/containerd/continuity/fs"
 	"github.com/containerd/log"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
 )
 
 var bufPool = &sync.Pool{
@@ -134,6 +136,14 @@ const (
 	userXattrPrefix = "user."
 )
 
+// preservedXattrs are the extended attributes recorded for each changed
+// file, file capabilities and POSIX ACLs are lost without them.
+var preservedXattrs = []string{
+	"security.capability",
+	"system.posix_acl_access",
+	"system.posix_acl_default",
+}
+
 // Apply applies a tar stream of an OCI style diff tar.
 // See https://github.com/opencontainers/image-spec/blob/main/layer.md#applying-changesets
 func Apply(ctx context.Context, root string, r io.Reader, opts ...ApplyOpt) (int64, error) {
@@ -501,6 +511,7 @@ func mkparent(ctx context.Context, path, root string, parents []string) error {
 // See also https://github.com/opencontainers/image-spec/blob/main/layer.md for details
 // about OCI layers
 type ChangeWriter struct {
+	w                 io.Writer
 	tw                *tar.Writer
 	source            string
 	modTimeUpperBound *time.Time
@@ -508,6 +519,7 @@ type ChangeWriter struct {
 	inodeSrc          map[uint64]string
 	inodeRefs         map[uint64][]string
 	addedDirs         map[string]struct{}
+	socketFunc        func(name string) error
 }
 
 // ChangeWriterOpt can be specified in NewChangeWriter.
@@ -527,11 +539,20 @@ func WithWhiteoutTime(tm time.Time) ChangeWriterOpt {
 	}
 }
 
+// WithSocketFunc calls fn on each socket, which can't be archived and is
+// ignored, the error of fn aborts the writing.
+func WithSocketFunc(fn func(name string) error) ChangeWriterOpt {
+	return func(cw *ChangeWriter) {
+		cw.socketFunc = fn
+	}
+}
+
 // NewChangeWriter returns ChangeWriter that writes tar stream of the source directory
 // to the privided writer. Change information (add/modify/delete/unmodified) for each
 // file needs to be passed through HandleChange method.
 func NewChangeWriter(w io.Writer, source string, opts ...ChangeWriterOpt) *ChangeWriter {
 	cw := &ChangeWriter{
+		w:         w,
 		tw:        tar.NewWriter(w),
 		source:    source,
 		whiteoutT: time.Now(), // can be overridden with WithWhiteoutTime(time.Time) ChangeWriterOpt .
@@ -579,6 +600,9 @@ func (cw *ChangeWriter) HandleChange(k fs.ChangeKind, p string, f os.FileInfo, e
 
 		switch {
 		case f.Mode()&os.ModeSocket != 0:
+			if cw.socketFunc != nil {
+				return cw.socketFunc(p)
+			}
 			return nil // ignore sockets
 		case f.Mode()&os.ModeSymlink != 0:
 			if link, err = os.Readlink(source); err != nil {
@@ -645,21 +669,21 @@ func (cw *ChangeWriter) HandleChange(k fs.ChangeKind, p string, f os.FileInfo, e
 			return nil
 		}
 
-		if capability, err := getxattr(source, "security.capability"); err != nil {
-			return fmt.Errorf("failed to get capabilities xattr: %w", err)
-		} else if len(capability) > 0 {
-			if hdr.PAXRecords == nil {
-				hdr.PAXRecords = map[string]string{}
+		for _, key := range preservedXattrs {
+			value, err := getxattr(source, key)
+			if err != nil {
+				return fmt.Errorf("failed to get %s xattr: %w", key, err)
+			} else if len(value) > 0 {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = map[string]string{}
+				}
+				hdr.PAXRecords[paxSchilyXattr+key] = string(value)
 			}
-			hdr.PAXRecords[paxSchilyXattr+"security.capability"] = string(capability)
 		}
 
 		if err := cw.includeParents(hdr); err != nil {
 			return err
 		}
-		if err := cw.tw.WriteHeader(hdr); err != nil {
-			return fmt.Errorf("failed to write file header: %w", err)
-		}
 
 		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
 			file, err := open(source)
@@ -668,14 +692,31 @@ func (cw *ChangeWriter) HandleChange(k fs.ChangeKind, p string, f os.FileInfo, e
 			}
 			defer file.Close()
 
-			// HACK (imeoer): disply file path in error message.
-			n, err := copyBuffered(context.TODO(), cw.tw, file)
+			// The holes of sparse file are kept instead of archived as
+			// zeros.
+			segments, sparse, err := tarstream.DataSegments(file, hdr.Size)
 			if err != nil {
-				return fmt.Errorf("failed to copy file %s: %w", p, err)
+				return fmt.Errorf("failed to find holes of file %s: %w", p, err)
 			}
-			if n != hdr.Size {
-				return fmt.Errorf("short write copying file: %s", p)
+			if sparse {
+				if err := tarstream.WriteSparse(cw.tw, cw.w, hdr, segments, tarstream.SegmentsReader(file, segments)); err != nil {
+					return fmt.Errorf("failed to write sparse file %s: %w", p, err)
+				}
+			} else {
+				if err := cw.tw.WriteHeader(hdr); err != nil {
+					return fmt.Errorf("failed to write file header: %w", err)
+				}
+				// HACK (imeoer): disply file path in error message.
+				n, err := copyBuffered(context.TODO(), cw.tw, file)
+				if err != nil {
+					return fmt.Errorf("failed to copy file %s: %w", p, err)
+				}
+				if n != hdr.Size {
+					return fmt.Errorf("short write copying file: %s", p)
+				}
 			}
+		} else if err := cw.tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write file header: %w", err)
 		}
 
 		if additionalLinks != nil {
@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func readHeaders(t *testing.T, r io.Reader) map[string]*tar.Header {
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[hdr.Name] = hdr
+	}
+	return headers
+}
+
+func TestChangeWriterHardlink(t *testing.T) {
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "foo"), []byte("foo"), 0644))
+	require.NoError(t, os.Link(filepath.Join(source, "foo"), filepath.Join(source, "bar")))
+
+	var buf bytes.Buffer
+	cw := NewChangeWriter(&buf, source)
+	for _, name := range []string{"/foo", "/bar"} {
+		fi, err := os.Lstat(filepath.Join(source, name))
+		require.NoError(t, err)
+		require.NoError(t, cw.HandleChange(fs.ChangeKindAdd, name, fi, nil))
+	}
+	require.NoError(t, cw.Close())
+
+	headers := readHeaders(t, &buf)
+	require.Equal(t, byte(tar.TypeReg), headers["foo"].Typeflag)
+	require.Equal(t, byte(tar.TypeLink), headers["bar"].Typeflag)
+	require.Equal(t, "foo", headers["bar"].Linkname)
+}
+
+func TestChangeWriterXattrs(t *testing.T) {
+	source := t.TempDir()
+	path := filepath.Join(source, "ping")
+	require.NoError(t, os.WriteFile(path, []byte("ping"), 0755))
+
+	// cap_net_raw+ep in vfs_cap_data v2 layout.
+	capability := []byte{0x01, 0x00, 0x00, 0x02, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if err := unix.Lsetxattr(path, "security.capability", capability, 0); err != nil {
+		t.Skipf("set security.capability: %s", err)
+	}
+	// Access ACL granting user 1000 read permission.
+	acl := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x07, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x02, 0x00, 0x04, 0x00, 0xe8, 0x03, 0x00, 0x00,
+		0x04, 0x00, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x10, 0x00, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff,
+		0x20, 0x00, 0x05, 0x00, 0xff, 0xff, 0xff, 0xff,
+	}
+	if err := unix.Lsetxattr(path, "system.posix_acl_access", acl, 0); err != nil {
+		t.Skipf("set system.posix_acl_access: %s", err)
+	}
+
+	var buf bytes.Buffer
+	cw := NewChangeWriter(&buf, source)
+	fi, err := os.Lstat(path)
+	require.NoError(t, err)
+	require.NoError(t, cw.HandleChange(fs.ChangeKindAdd, "/ping", fi, nil))
+	require.NoError(t, cw.Close())
+
+	hdr := readHeaders(t, &buf)["ping"]
+	require.NotNil(t, hdr)
+	require.Equal(t, string(capability), hdr.PAXRecords[paxSchilyXattr+"security.capability"])
+	require.Equal(t, string(acl), hdr.PAXRecords[paxSchilyXattr+"system.posix_acl_access"])
+}
+
+func TestChangeWriterSparse(t *testing.T) {
+	const size = 64 * 1024 * 1024
+	source := t.TempDir()
+	path := filepath.Join(source, "disk.img")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, file.Truncate(size))
+	_, err = file.WriteAt([]byte("nydus"), size/2)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	var buf bytes.Buffer
+	cw := NewChangeWriter(&buf, source)
+	fi, err := os.Lstat(path)
+	require.NoError(t, err)
+	require.NoError(t, cw.HandleChange(fs.ChangeKindAdd, "/disk.img", fi, nil))
+	require.NoError(t, cw.Close())
+
+	var st unix.Stat_t
+	require.NoError(t, unix.Stat(path, &st))
+	if st.Blocks*512 >= size {
+		t.Skip("filesystem doesn't support holes")
+	}
+	// The holes are not archived.
+	require.Less(t, buf.Len(), 1024*1024)
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	require.Equal(t, "disk.img", hdr.Name)
+	require.Equal(t, int64(size), hdr.Size)
+	data, err := io.ReadAll(tr)
+	require.NoError(t, err)
+	require.Len(t, data, size)
+	require.Equal(t, "nydus", string(data[size/2:size/2+5]))
+}
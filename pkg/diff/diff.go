@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/containerd/containerd/mount"
+	"github.com/containerd/continuity/fs"
 	"github.com/moby/buildkit/util/overlay"
 	"github.com/pkg/errors"
 
@@ -21,54 +22,64 @@ func overlaySupportIndex() bool {
 	return false
 }
 
-// Ported from github.com/moby/buildkit/util/overlay/overlay_linux.go
-// Modified overlayfs temp mount handle.
-//
-// WriteUpperdir writes a layer tar archive into the specified writer, based on
-// the diff information stored in the upperdir.
-func writeUpperdir(ctx context.Context, appendMount func(path string), withPaths []string, withoutPaths []string, w io.Writer, upperdir string, lower []mount.Mount) error {
-	emptyLower, err := os.MkdirTemp("", "buildkit") // empty directory used for the lower of diff view
-	if err != nil {
-		return errors.Wrapf(err, "failed to create temp dir")
+// SplitLowerDirs splits `lowerDirs` in the format of overlay `lowerdir=`
+// option into lower dirs and data-only lower dirs which follow "::", see
+// https://docs.kernel.org/filesystems/overlayfs.html#data-only-lower-layers.
+func SplitLowerDirs(lowerDirs string) ([]string, []string) {
+	dirs := strings.Split(lowerDirs, "::")
+	if dirs[0] == "" {
+		return nil, dirs[1:]
 	}
-	defer os.Remove(emptyLower)
+	return strings.Split(dirs[0], ":"), dirs[1:]
+}
 
-	options := []string{
-		fmt.Sprintf("lowerdir=%s", strings.Join([]string{upperdir, emptyLower}, ":")),
-	}
-	if overlaySupportIndex() {
-		options = append(options, "index=off")
-	}
-	upperView := []mount.Mount{
-		{
-			Type:    "overlay",
-			Source:  "overlay",
-			Options: options,
-		},
-	}
+// JoinLowerDirs is the reverse of SplitLowerDirs.
+func JoinLowerDirs(lowerDirs, dataDirs []string) string {
+	return strings.Join(append([]string{strings.Join(lowerDirs, ":")}, dataDirs...), "::")
+}
 
-	return mount.WithTempMount(ctx, lower, func(lowerRoot string) error {
-		return mount.WithTempMount(ctx, upperView, func(upperViewRoot string) error {
-			cw := archive.NewChangeWriter(&cancellableWriter{ctx, w}, upperViewRoot)
-			if err := Changes(ctx, appendMount, withPaths, withoutPaths, cw.HandleChange, upperdir, upperViewRoot, lowerRoot); err != nil {
-				if err2 := cw.Close(); err2 != nil {
-					return errors.Wrapf(err, "failed to record upperdir changes (close error: %v)", err2)
-				}
-				return errors.Wrapf(err, "failed to record upperdir changes")
+// Diff writes the changes of `upperDir` against `lowerDirs` into `writer`
+// as a layer tar archive, the upper dir is walked by `workers` as Changes.
+func Diff(ctx context.Context, workers int, appendMount func(path string, metacopy bool), withPaths []string, withoutPaths []string, writer io.Writer, lowerDirs, upperDir string, opts ...archive.ChangeWriterOpt) error {
+	err := withDiffView(ctx, lowerDirs, upperDir, func(upperDir, upperViewRoot, lowerRoot string) error {
+		cw := archive.NewChangeWriter(&cancellableWriter{ctx, writer}, upperViewRoot, opts...)
+		if err := Changes(ctx, workers, appendMount, withPaths, withoutPaths, cw.HandleChange, upperDir, upperViewRoot, lowerRoot); err != nil {
+			if err2 := cw.Close(); err2 != nil {
+				return errors.Wrapf(err, "failed to record upperdir changes (close error: %v)", err2)
 			}
-			return cw.Close()
-		})
+			return errors.Wrapf(err, "failed to record upperdir changes")
+		}
+		return cw.Close()
 	})
+	return errors.Wrap(err, "write diff")
 }
 
-func Diff(ctx context.Context, appendMount func(path string), withPaths []string, withoutPaths []string, writer io.Writer, lowerDirs, upperDir string) error {
+// Walk calls `changeFn` with the changes of `upperDir` against `lowerDirs`
+// as Diff does without archiving them, the paths unsupported by the differ
+// are passed to `appendMount` instead.
+func Walk(ctx context.Context, appendMount func(path string, metacopy bool), withoutPaths []string, lowerDirs, upperDir string, changeFn fs.ChangeFunc) error {
+	err := withDiffView(ctx, lowerDirs, upperDir, func(upperDir, upperViewRoot, lowerRoot string) error {
+		return Changes(ctx, 1, appendMount, nil, withoutPaths, changeFn, upperDir, upperViewRoot, lowerRoot)
+	})
+	return errors.Wrap(err, "walk diff")
+}
+
+// Ported from github.com/moby/buildkit/util/overlay/overlay_linux.go
+// Modified overlayfs temp mount handle.
+//
+// withDiffView mounts `lowerDirs` and the view of `upperDir` without
+// whiteouts temporarily, and calls `fn` with the real upper dir and the
+// roots of both mounts.
+func withDiffView(ctx context.Context, lowerDirs, upperDir string, fn func(upperDir, upperViewRoot, lowerRoot string) error) error {
 	emptyLower, err := os.MkdirTemp("", "nydus-cli-diff")
 	if err != nil {
 		return errors.Wrapf(err, "create temp dir")
 	}
 	defer os.Remove(emptyLower)
 
-	lowerDirs += fmt.Sprintf(":%s", emptyLower)
+	// The data-only lower dirs must be the bottommost.
+	dirs, dataDirs := SplitLowerDirs(lowerDirs)
+	lowerDirs = JoinLowerDirs(append(dirs, emptyLower), dataDirs)
 
 	options := []string{
 		fmt.Sprintf("lowerdir=%s", lowerDirs),
@@ -102,10 +113,37 @@ func Diff(ctx context.Context, appendMount func(path string), withPaths []string
 	if err != nil {
 		return errors.Wrap(err, "get upper dir")
 	}
+	// The metacopy files in lower dirs get data from the data-only lower
+	// dirs by redirect, the options are unknown to GetUpperdir so they are
+	// appended after it.
+	if len(dataDirs) > 0 {
+		lower[0].Options = append(lower[0].Options, "metacopy=on", "redirect_dir=follow")
+	}
+
+	// The empty directory used for the lower of upper view.
+	emptyUpperLower, err := os.MkdirTemp("", "buildkit")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temp dir")
+	}
+	defer os.Remove(emptyUpperLower)
 
-	if err = writeUpperdir(ctx, appendMount, withPaths, withoutPaths, &cancellableWriter{ctx, writer}, upperDir, lower); err != nil {
-		return errors.Wrap(err, "write diff")
+	options = []string{
+		fmt.Sprintf("lowerdir=%s", strings.Join([]string{upperDir, emptyUpperLower}, ":")),
+	}
+	if overlaySupportIndex() {
+		options = append(options, "index=off")
+	}
+	upperView := []mount.Mount{
+		{
+			Type:    "overlay",
+			Source:  "overlay",
+			Options: options,
+		},
 	}
 
-	return nil
+	return mount.WithTempMount(ctx, lower, func(lowerRoot string) error {
+		return mount.WithTempMount(ctx, upperView, func(upperViewRoot string) error {
+			return fn(upperDir, upperViewRoot, lowerRoot)
+		})
+	})
 }
@@ -0,0 +1,27 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLowerDirs(t *testing.T) {
+	for _, tc := range []struct {
+		lowerDirs string
+		dirs      []string
+		dataDirs  []string
+	}{
+		{"/l1:/l2", []string{"/l1", "/l2"}, []string{}},
+		{"/l1::/d1::/d2", []string{"/l1"}, []string{"/d1", "/d2"}},
+		{"", nil, []string{}},
+	} {
+		dirs, dataDirs := SplitLowerDirs(tc.lowerDirs)
+		require.Equal(t, tc.dirs, dirs, tc.lowerDirs)
+		require.Equal(t, tc.dataDirs, dataDirs, tc.lowerDirs)
+		require.Equal(t, tc.lowerDirs, JoinLowerDirs(dirs, dataDirs))
+	}
+
+	dirs, dataDirs := SplitLowerDirs("/l1::/d1")
+	require.Equal(t, "/l1:/empty::/d1", JoinLowerDirs(append(dirs, "/empty"), dataDirs))
+}
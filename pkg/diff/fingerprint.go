@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Fingerprint returns the digest of the metadata of files in `dirs`, i.e.
+// the path, mode, size, owner, modification and change time of each file,
+// and the target of each symlink. The fingerprint changes once any file is
+// changed, so the unchanged upper dir is detected without reading the data
+// of files. A missing dir has an empty fingerprint.
+func Fingerprint(dirs ...string) (digest.Digest, error) {
+	digester := digest.SHA256.Digester()
+	hash := digester.Hash()
+	for _, dir := range dirs {
+		fmt.Fprintf(hash, "dir %s\n", dir)
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%q %o %d %d\n", rel, info.Mode(), info.Size(), info.ModTime().UnixNano())
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				fmt.Fprintf(hash, "\t%d:%d %d %d.%d\n", stat.Uid, stat.Gid, stat.Rdev, stat.Ctim.Sec, stat.Ctim.Nsec)
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hash, "\t-> %q\n", target)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "walk %s", dir)
+		}
+	}
+	return digester.Digest(), nil
+}
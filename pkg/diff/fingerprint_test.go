@@ -0,0 +1,42 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, []byte("foo"), 0644))
+	require.NoError(t, os.Symlink("file", filepath.Join(dir, "link")))
+
+	fingerprint, err := Fingerprint(dir)
+	require.NoError(t, err)
+	unchanged, err := Fingerprint(dir)
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, unchanged)
+
+	// The content is changed in place with the same size and mtime.
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(file, []byte("bar"), 0644))
+	require.NoError(t, os.Chtimes(file, info.ModTime(), info.ModTime()))
+	changed, err := Fingerprint(dir)
+	require.NoError(t, err)
+	require.NotEqual(t, fingerprint, changed)
+
+	require.NoError(t, os.Chmod(file, 0600))
+	chmoded, err := Fingerprint(dir)
+	require.NoError(t, err)
+	require.NotEqual(t, changed, chmoded)
+
+	missing, err := Fingerprint(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	other, err := Fingerprint(filepath.Join(dir, "other"))
+	require.NoError(t, err)
+	require.NotEqual(t, missing, other)
+}
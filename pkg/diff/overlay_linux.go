@@ -121,9 +121,34 @@ func (w *cancellableWriter) Write(p []byte) (int, error) {
 // Changes is continuty's `fs.Change`-like method but leverages overlayfs's
 // "upperdir" for computing the diff. "upperdirView" is overlayfs mounted view of
 // the upperdir that doesn't contain whiteouts. This is used for computing
-// changes under opaque directories.
-func Changes(ctx context.Context, appendMount func(path string), withPaths []string, withoutPaths []string, changeFn fs.ChangeFunc, upperdir, upperdirView, base string) error {
-	err := filepath.Walk(upperdir, func(path string, f os.FileInfo, err error) error {
+// changes under opaque directories. The top-level entries of upperdir are
+// walked by `workers` concurrently if it's more than 1, see parallelChanges.
+// The paths unsupported by the differ are passed to `appendMount` to be
+// committed from container, `metacopy` is set for the metacopy files and
+// unset for the redirect dirs.
+func Changes(ctx context.Context, workers int, appendMount func(path string, metacopy bool), withPaths []string, withoutPaths []string, changeFn fs.ChangeFunc, upperdir, upperdirView, base string) error {
+	var err error
+	if workers > 1 {
+		err = parallelChanges(ctx, workers, appendMount, withoutPaths, changeFn, upperdir, upperdirView, base)
+	} else {
+		err = filepath.Walk(upperdir, changesWalkFunc(ctx, appendMount, withoutPaths, changeFn, upperdir, upperdirView, base))
+	}
+	if err != nil {
+		return err
+	}
+	// Remove lower files, these files will be re-added on committing mount process.
+	for _, withPath := range withPaths {
+		if err := changeFn(fs.ChangeKindDelete, withPath, nil, nil); err != nil {
+			return errors.Wrapf(err, "handle deleted with path: %s", withPath)
+		}
+	}
+	return nil
+}
+
+// changesWalkFunc returns the function walking upperdir by filepath.Walk
+// for Changes.
+func changesWalkFunc(ctx context.Context, appendMount func(path string, metacopy bool), withoutPaths []string, changeFn fs.ChangeFunc, upperdir, upperdirView, base string) filepath.WalkFunc {
+	return func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -160,7 +185,21 @@ func Changes(ctx context.Context, appendMount func(path string), withPaths []str
 				"[need append] redirect_dir is used but it's not supported in overlayfs differ: %s",
 				filepath.Join(upperdir, path),
 			)
-			appendMount(path)
+			appendMount(path, false)
+			return nil
+		}
+
+		// Check metacopy
+		if metacopy, err := checkMetacopy(upperdir, path, f); err != nil {
+			return err
+		} else if metacopy {
+			// Only the metadata of file is copied up into upperdir, the data
+			// is still in lowerdirs, so commit the file from container.
+			logrus.Warnf(
+				"[need append] metacopy is used but it's not supported in overlayfs differ: %s",
+				filepath.Join(upperdir, path),
+			)
+			appendMount(path, true)
 			return nil
 		}
 
@@ -179,7 +218,7 @@ func Changes(ctx context.Context, appendMount func(path string), withPaths []str
 			kind = fs.ChangeKindDelete
 			// Leave f set to the FileInfo for the whiteout device in case the caller wants it, e.g.
 			// the merge code uses it to hardlink in the whiteout device to merged snapshots
-		} else if baseF, err := os.Lstat(filepath.Join(base, path)); err == nil {
+		} else if baseF, err := lstatBase(base, path); err == nil {
 			// File exists in the base layer. Thus this is modified.
 			kind = fs.ChangeKindModify
 			// Avoid including directory that hasn't been modified. If /foo/bar/baz is modified,
@@ -219,17 +258,27 @@ func Changes(ctx context.Context, appendMount func(path string), withPaths []str
 			}
 		}
 		return nil
-	})
-	if err != nil {
-		return err
 	}
-	// Remove lower files, these files will be re-added on committing mount process.
-	for _, withPath := range withPaths {
-		if err := changeFn(fs.ChangeKindDelete, withPath, nil, nil); err != nil {
-			return errors.Wrapf(err, "handle deleted with path: %s", withPath)
+}
+
+// lstatBase stats `path` in base dir without following symlinks in any
+// component of it. A parent component being a symlink in base is shadowed
+// by the real directory in upperdir, so the path is treated as not existing
+// instead of resolving the symlink, which may point out of base.
+func lstatBase(base, path string) (os.FileInfo, error) {
+	parent := base
+	parts := strings.Split(strings.Trim(filepath.Clean(path), "/"), "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		f, err := os.Lstat(parent)
+		if err != nil {
+			return nil, err
+		}
+		if !f.IsDir() {
+			return nil, &os.PathError{Op: "lstat", Path: filepath.Join(base, path), Err: unix.ENOENT}
 		}
 	}
-	return err
+	return os.Lstat(filepath.Join(base, path))
 }
 
 // checkDelete checks if the specified file is a whiteout
@@ -242,7 +291,7 @@ func checkDelete(upperdir string, path string, base string, f os.FileInfo) (dele
 			}
 			if maj == 0 && min == 0 {
 				// This file is a whiteout (char 0/0) that indicates this is deleted from the base
-				if _, err := os.Lstat(filepath.Join(base, path)); err != nil {
+				if _, err := lstatBase(base, path); err != nil {
 					if !os.IsNotExist(err) {
 						return false, false, errors.Wrapf(err, "failed to lstat")
 					}
@@ -266,7 +315,7 @@ func checkOpaque(upperdir string, path string, base string, f os.FileInfo) (isOp
 				return false, errors.Wrapf(err, "failed to retrieve %s attr", oKey)
 			} else if len(opaque) == 1 && opaque[0] == 'y' {
 				// This is an opaque whiteout directory.
-				if _, err := os.Lstat(filepath.Join(base, path)); err != nil {
+				if _, err := lstatBase(base, path); err != nil {
 					if !os.IsNotExist(err) {
 						return false, errors.Wrapf(err, "failed to lstat")
 					}
@@ -293,6 +342,22 @@ func checkRedirect(upperdir string, path string, f os.FileInfo) (bool, error) {
 	return false, nil
 }
 
+// checkMetacopy checks if the specified file is a metacopy file, whose data
+// is in lowerdirs, see https://docs.kernel.org/filesystems/overlayfs.html#metadata-only-copy-up.
+func checkMetacopy(upperdir string, path string, f os.FileInfo) (bool, error) {
+	if f.Mode().IsRegular() {
+		for _, mKey := range []string{"trusted.overlay.metacopy", "user.overlay.metacopy"} {
+			_, err := sysx.LGetxattr(filepath.Join(upperdir, path), mKey)
+			if err == nil {
+				return true, nil
+			} else if err != unix.ENODATA {
+				return false, errors.Wrapf(err, "failed to retrieve %s attr", mKey)
+			}
+		}
+	}
+	return false, nil
+}
+
 // sameDirent performs continity-compatible comparison of files and directories.
 // https://github.com/containerd/continuity/blob/v0.1.0/fs/path.go#L91-L133
 // This will only do a slow content comparison of two files if they have all the
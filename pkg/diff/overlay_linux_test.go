@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff/archive"
+)
+
+func TestLstatBaseSymlinkEscape(t *testing.T) {
+	base := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "dir", "file"), []byte("file"), 0644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(base, "abs")))
+	require.NoError(t, os.Symlink("../../../../"+outside, filepath.Join(base, "rel")))
+
+	f, err := lstatBase(base, "/dir/file")
+	require.NoError(t, err)
+	require.Equal(t, "file", f.Name())
+
+	// The link itself is in base.
+	f, err = lstatBase(base, "/abs")
+	require.NoError(t, err)
+	require.NotZero(t, f.Mode()&os.ModeSymlink)
+
+	// Files behind the links are out of base.
+	for _, path := range []string{"/abs/secret", "/rel/secret", "/dir/file/secret"} {
+		_, err = lstatBase(base, path)
+		require.True(t, os.IsNotExist(err), path)
+	}
+}
+
+func TestCheckMetacopy(t *testing.T) {
+	upper := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(upper, "copied"), []byte("data"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(upper, "metacopy"), nil, 0644))
+	if err := unix.Lsetxattr(filepath.Join(upper, "metacopy"), "user.overlay.metacopy", nil, 0); err != nil {
+		t.Skipf("user xattr is unsupported: %s", err)
+	}
+
+	for path, expected := range map[string]bool{"/copied": false, "/metacopy": true} {
+		f, err := os.Lstat(filepath.Join(upper, path))
+		require.NoError(t, err)
+		metacopy, err := checkMetacopy(upper, path, f)
+		require.NoError(t, err)
+		require.Equal(t, expected, metacopy, path)
+	}
+
+	// The metacopy files are appended instead of changes.
+	for _, workers := range []int{1, 4} {
+		var appended, changes []string
+		require.NoError(t, Changes(context.Background(), workers, func(path string, metacopy bool) {
+			require.True(t, metacopy)
+			appended = append(appended, path)
+		}, nil, nil, func(kind fs.ChangeKind, path string, f os.FileInfo, err error) error {
+			require.NoError(t, err)
+			changes = append(changes, kind.String()+" "+path)
+			return nil
+		}, upper, upper, t.TempDir()))
+		require.Equal(t, []string{"/metacopy"}, appended)
+		require.Equal(t, []string{"add /copied"}, changes)
+	}
+}
+
+func TestParallelChanges(t *testing.T) {
+	base := t.TempDir()
+	upper := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(base, "same"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "modified"), []byte("base"), 0644))
+	for idx := 0; idx < 4; idx++ {
+		dir := filepath.Join(upper, fmt.Sprintf("dir-%d", idx), "sub")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		for file := 0; file < 1100; file++ {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d", file)), nil, 0644))
+		}
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(upper, "modified"), []byte("upper"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(upper, "added"), nil, 0644))
+
+	changes := func(workers int, withoutPaths []string) []string {
+		var changes []string
+		require.NoError(t, Changes(context.Background(), workers, func(path string, metacopy bool) {}, []string{"/with"}, withoutPaths,
+			func(kind fs.ChangeKind, path string, f os.FileInfo, err error) error {
+				require.NoError(t, err)
+				changes = append(changes, kind.String()+" "+path)
+				return nil
+			}, upper, upper, base))
+		return changes
+	}
+	serial := changes(1, []string{"/dir-2"})
+	require.Len(t, serial, 2+3*1102+1)
+	require.Equal(t, serial, changes(4, []string{"/dir-2"}))
+	require.Equal(t, serial, changes(100, []string{"/dir-2"}))
+	require.Equal(t, "delete /with", serial[len(serial)-1])
+
+	// The walk stops on the error of changeFn.
+	failed := errors.New("failed")
+	var count int
+	err := Changes(context.Background(), 4, func(path string, metacopy bool) {}, nil, nil,
+		func(kind fs.ChangeKind, path string, f os.FileInfo, err error) error {
+			if count++; count == 100 {
+				return failed
+			}
+			return nil
+		}, upper, upper, base)
+	require.ErrorIs(t, err, failed)
+	require.Equal(t, 100, count)
+}
+
+// BenchmarkChanges compares the serial and parallel walks of an upper dir
+// with many small files, by walk only and by archiving the changes. Only
+// the walk is parallel, the archiving reads the files serially.
+func BenchmarkChanges(b *testing.B) {
+	base := b.TempDir()
+	upper := b.TempDir()
+	data := make([]byte, 4096)
+	for idx := 0; idx < 16; idx++ {
+		for sub := 0; sub < 8; sub++ {
+			dir := filepath.Join(fmt.Sprintf("dir-%d", idx), fmt.Sprintf("sub-%d", sub))
+			require.NoError(b, os.MkdirAll(filepath.Join(upper, dir), 0755))
+			require.NoError(b, os.MkdirAll(filepath.Join(base, dir), 0755))
+			for file := 0; file < 64; file++ {
+				require.NoError(b, os.WriteFile(filepath.Join(upper, dir, fmt.Sprintf("file-%d", file)), data, 0644))
+			}
+		}
+	}
+
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("walk/workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, Changes(context.Background(), workers, func(path string, metacopy bool) {}, nil, nil,
+					func(kind fs.ChangeKind, path string, f os.FileInfo, err error) error {
+						return err
+					}, upper, upper, base))
+			}
+		})
+		b.Run(fmt.Sprintf("archive/workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				cw := archive.NewChangeWriter(io.Discard, upper)
+				require.NoError(b, Changes(context.Background(), workers, func(path string, metacopy bool) {}, nil, nil, cw.HandleChange, upper, upper, base))
+				require.NoError(b, cw.Close())
+			}
+		})
+	}
+}
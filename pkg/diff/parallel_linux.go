@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/containerd/continuity/fs"
+)
+
+// shardBuffer is the count of changes a shard of parallel walk buffers
+// ahead of the shards before it.
+const shardBuffer = 1024
+
+// shardEvent is a change found by a shard, or a path to append as mount
+// if `mount` is set.
+type shardEvent struct {
+	kind     fs.ChangeKind
+	path     string
+	info     os.FileInfo
+	mount    bool
+	metacopy bool
+}
+
+// shard walks a top-level entry of upperdir.
+type shard struct {
+	events chan shardEvent
+	done   chan error
+}
+
+// parallelChanges walks the top-level entries of `upperdir` as Changes by
+// `workers` concurrently, each entry is a shard. The changes and appended
+// mounts of shards are passed to `changeFn` and `appendMount` in the
+// order of entries, which is the order of serial walk, so the archived
+// diff is the same. The shards are started in order, the one being
+// consumed is always started, and the later ones run ahead until their
+// buffers are full.
+func parallelChanges(ctx context.Context, workers int, appendMount func(path string, metacopy bool), withoutPaths []string, changeFn fs.ChangeFunc, upperdir, upperdirView, base string) error {
+	entries, err := os.ReadDir(upperdir)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := make([]shard, len(entries))
+	for idx := range shards {
+		shards[idx] = shard{events: make(chan shardEvent, shardBuffer), done: make(chan error, 1)}
+	}
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for idx := range shards {
+			select {
+			case indexes <- idx:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for worker := 0; worker < workers && worker < len(shards); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				shards[idx].done <- walkShard(ctx, shards[idx].events, withoutPaths, filepath.Join(upperdir, entries[idx].Name()), upperdir, upperdirView, base)
+				close(shards[idx].events)
+			}
+		}()
+	}
+
+	for idx := range shards {
+		for event := range shards[idx].events {
+			if event.mount {
+				appendMount(event.path, event.metacopy)
+			} else if err := changeFn(event.kind, event.path, event.info, nil); err != nil {
+				// Stops the workers blocked by sending events.
+				cancel()
+				return err
+			}
+		}
+		if err := <-shards[idx].done; err != nil {
+			cancel()
+			return err
+		}
+	}
+	return nil
+}
+
+// walkShard walks `root` in `upperdir` and sends its changes to `events`.
+func walkShard(ctx context.Context, events chan<- shardEvent, withoutPaths []string, root, upperdir, upperdirView, base string) error {
+	send := func(event shardEvent) error {
+		select {
+		case events <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	appendMount := func(path string, metacopy bool) {
+		// The walk stops on the cancelled context anyway.
+		_ = send(shardEvent{path: path, mount: true, metacopy: metacopy})
+	}
+	changeFn := func(kind fs.ChangeKind, path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return send(shardEvent{kind: kind, path: path, info: f})
+	}
+	return filepath.Walk(root, changesWalkFunc(ctx, appendMount, withoutPaths, changeFn, upperdir, upperdirView, base))
+}
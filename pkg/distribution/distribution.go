@@ -6,50 +6,189 @@ package distribution
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/reference/docker"
 	"github.com/containerd/containerd/remotes"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
 	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
-var NydusRefSuffix = "_nydus_v2"
+// DefaultNydusRefSuffix is appended to the tag of OCI image to name its
+// nydus image by default.
+const DefaultNydusRefSuffix = "_nydus_v2"
+
+// DefaultNaming names nydus images by DefaultNydusRefSuffix only.
+var DefaultNaming = &Naming{Suffix: DefaultNydusRefSuffix}
+
+// suffixPattern is the characters allowed in tag.
+var suffixPattern = regexp.MustCompile(`^[\w.-]+$`)
 
 type Distribution struct {
 	resolverFunc func(bool) remotes.Resolver
 }
 
-// AppendNydusSuffix appends nydus suffix to the image `ref` and return nydus image.
-func AppendNydusSuffix(ref string) (string, error) {
-	named, err := docker.ParseDockerRef(ref)
+// RewriteRule rewrites the normalized reference matching regexp `source`
+// to template `target`, in which `$1` or `${name}` are expanded to the
+// submatches, e.g. `^(.+)/app:(.+)$` to `$1/app-nydus:$2`.
+type RewriteRule struct {
+	source *regexp.Regexp
+	target string
+}
+
+func newRewriteRules(rules []config.RewriteRule) ([]RewriteRule, error) {
+	compiled := []RewriteRule{}
+	for _, rule := range rules {
+		source, err := regexp.Compile(rule.Source)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rule source %s", rule.Source)
+		}
+		if rule.Target == "" {
+			return nil, fmt.Errorf("empty target of rule %s", rule.Source)
+		}
+		compiled = append(compiled, RewriteRule{source: source, target: rule.Target})
+	}
+	return compiled, nil
+}
+
+// rewrite returns the reference rewritten by the first rule matching
+// `ref`, or false if no rule matches.
+func rewrite(rules []RewriteRule, ref string) (string, bool, error) {
+	for _, rule := range rules {
+		matched := rule.source.FindStringSubmatchIndex(ref)
+		if matched == nil {
+			continue
+		}
+		rewritten := string(rule.source.ExpandString(nil, rule.target, ref, matched))
+		named, err := docker.ParseDockerRef(rewritten)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "invalid reference %s rewritten from %s by rule %s", rewritten, ref, rule.source)
+		}
+		return docker.TagNameOnly(named).String(), true, nil
+	}
+	return "", false, nil
+}
+
+// Naming maps the references between OCI images and nydus images.
+type Naming struct {
+	// Suffix is appended to the tag of OCI image if no rule matches.
+	Suffix string
+	// Rules rewrite OCI references to nydus references.
+	Rules []RewriteRule
+	// ReverseRules rewrite nydus references to OCI references, the
+	// references matching any of them are nydus references too.
+	ReverseRules []RewriteRule
+}
+
+// NewNaming returns the naming of config, the suffix defaults to
+// DefaultNydusRefSuffix.
+func NewNaming(cfg *config.Naming) (*Naming, error) {
+	naming := &Naming{Suffix: cfg.Suffix}
+	if naming.Suffix == "" {
+		naming.Suffix = DefaultNydusRefSuffix
+	}
+	if !suffixPattern.MatchString(naming.Suffix) {
+		return nil, fmt.Errorf("invalid suffix %s, only letters, digits, '_', '.' and '-' are allowed", naming.Suffix)
+	}
+	var err error
+	if naming.Rules, err = newRewriteRules(cfg.Rules); err != nil {
+		return nil, err
+	}
+	if naming.ReverseRules, err = newRewriteRules(cfg.ReverseRules); err != nil {
+		return nil, err
+	}
+	return naming, nil
+}
+
+// normalize returns the normalized reference of `ref` with tag and the
+// digest of `ref` if addressed by digest, the returned reference is empty
+// if `ref` is addressed only by digest, e.g. `nginx@sha256:...`.
+func normalize(ref string) (string, digest.Digest, error) {
+	named, err := docker.ParseNormalizedNamed(ref)
 	if err != nil {
-		return "", errors.Wrapf(err, "invalid image reference: %s", ref)
+		return "", "", errors.Wrapf(err, "invalid image reference: %s", ref)
 	}
-	if _, ok := named.(docker.Digested); ok {
-		return "", fmt.Errorf("unsupported digested image reference: %s", ref)
+	digested, ok := named.(docker.Digested)
+	if !ok {
+		return docker.TagNameOnly(named).String(), "", nil
 	}
-	named = docker.TagNameOnly(named)
-	if strings.HasSuffix(named.String(), NydusRefSuffix) {
+	if tagged, ok := named.(docker.Tagged); ok {
+		return named.Name() + ":" + tagged.Tag(), digested.Digest(), nil
+	}
+	return "", digested.Digest(), nil
+}
+
+// NydusRef returns the nydus image of OCI image `ref` by the first
+// matched rule, or by appending suffix if no rule matches. The `ref`
+// which is already a nydus image is returned as is.
+func (n *Naming) NydusRef(ref string) (string, error) {
+	normalized, dgst, err := normalize(ref)
+	if err != nil {
+		return "", err
+	}
+	// The nydus image is pushed by tag, which is unknown from digest.
+	if dgst != "" {
+		return "", fmt.Errorf("unsupported digested image reference, a tag is required: %s", ref)
+	}
+	rewritten, ok, err := rewrite(n.Rules, normalized)
+	if err != nil || ok {
+		return rewritten, err
+	}
+	isNydus, err := n.IsNydusRef(ref)
+	if err != nil {
+		return "", err
+	}
+	if isNydus {
 		return ref, nil
 	}
-	target := named.String() + NydusRefSuffix
-	return target, nil
+	return normalized + n.Suffix, nil
 }
 
-// HasNydusSuffix checks weather if the image `ref` has `_nydus_v2` suffix.
-func HasNydusSuffix(ref string) (bool, error) {
-	named, err := docker.ParseDockerRef(ref)
+// IsNydusRef checks whether the image `ref` is a nydus image, which has
+// the suffix or matches any reverse rule. The digest of `ref` is ignored,
+// and the image addressed only by digest can't be told by name, it's
+// taken as nydus image and checked once pulled.
+func (n *Naming) IsNydusRef(ref string) (bool, error) {
+	normalized, _, err := normalize(ref)
 	if err != nil {
-		return false, errors.Wrapf(err, "invalid image reference: %s", ref)
+		return false, err
 	}
-	if _, ok := named.(docker.Digested); ok {
-		return false, fmt.Errorf("unsupported digested image reference: %s", ref)
+	if normalized == "" {
+		return true, nil
 	}
-	named = docker.TagNameOnly(named)
-	return strings.HasSuffix(named.String(), NydusRefSuffix), nil
+	if strings.HasSuffix(normalized, n.Suffix) {
+		return true, nil
+	}
+	for _, rule := range n.ReverseRules {
+		if rule.source.MatchString(normalized) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OCIRef returns the OCI image which the nydus image `ref` is converted
+// from, by the first matched reverse rule, or by trimming suffix if no
+// rule matches. The digest of `ref` is dropped since it addresses the
+// nydus image, so the image addressed only by digest has no OCI image.
+func (n *Naming) OCIRef(ref string) (string, error) {
+	normalized, _, err := normalize(ref)
+	if err != nil {
+		return "", err
+	}
+	if normalized == "" {
+		return "", fmt.Errorf("unknown oci image of digested image reference without tag: %s", ref)
+	}
+	rewritten, ok, err := rewrite(n.ReverseRules, normalized)
+	if err != nil || ok {
+		return rewritten, err
+	}
+	return strings.TrimSuffix(normalized, n.Suffix), nil
 }
 
 // New creates Distribution by distribution username, password.
@@ -59,9 +198,15 @@ func New(username, password string) (*Distribution, error) {
 			return username, password, nil
 		})
 	}
+	return NewWithResolver(resolverFunc), nil
+}
+
+// NewWithResolver creates Distribution requesting by the resolvers created
+// by `resolverFunc`.
+func NewWithResolver(resolverFunc func(bool) remotes.Resolver) *Distribution {
 	return &Distribution{
 		resolverFunc: resolverFunc,
-	}, nil
+	}
 }
 
 // IsImageExists checks if the image `ref` is exists in distribution.
@@ -84,9 +229,9 @@ func (d *Distribution) IsImageExists(ctx context.Context, ref string) (bool, err
 
 // IsNydusImageExists checks if the associated nydus image of `ref` is exists in distribution.
 func (d *Distribution) IsNydusImageExists(ctx context.Context, ref string) (bool, error) {
-	nydusRef, err := AppendNydusSuffix(ref)
+	nydusRef, err := DefaultNaming.NydusRef(ref)
 	if err != nil {
-		return false, errors.Wrap(err, "append nydus suffix")
+		return false, errors.Wrap(err, "name nydus image")
 	}
 
 	return d.IsImageExists(ctx, nydusRef)
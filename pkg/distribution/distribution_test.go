@@ -0,0 +1,106 @@
+package distribution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestDefaultNaming(t *testing.T) {
+	ref, err := DefaultNaming.NydusRef("nginx")
+	require.NoError(t, err)
+	require.Equal(t, "docker.io/library/nginx:latest_nydus_v2", ref)
+
+	ref, err = DefaultNaming.NydusRef("localhost:5000/nginx:latest_nydus_v2")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:latest_nydus_v2", ref)
+
+	isNydus, err := DefaultNaming.IsNydusRef("localhost:5000/nginx:latest")
+	require.NoError(t, err)
+	require.False(t, isNydus)
+
+	ref, err = DefaultNaming.OCIRef("localhost:5000/nginx:latest_nydus_v2")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:latest", ref)
+
+	_, err = DefaultNaming.NydusRef("nginx@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac")
+	require.Error(t, err)
+}
+
+func TestDigestedNaming(t *testing.T) {
+	const dgst = "sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"
+
+	isNydus, err := DefaultNaming.IsNydusRef("localhost:5000/nginx:latest_nydus_v2@" + dgst)
+	require.NoError(t, err)
+	require.True(t, isNydus)
+	isNydus, err = DefaultNaming.IsNydusRef("localhost:5000/nginx:latest@" + dgst)
+	require.NoError(t, err)
+	require.False(t, isNydus)
+	// Told once pulled.
+	isNydus, err = DefaultNaming.IsNydusRef("localhost:5000/nginx@" + dgst)
+	require.NoError(t, err)
+	require.True(t, isNydus)
+
+	ref, err := DefaultNaming.OCIRef("localhost:5000/nginx:latest_nydus_v2@" + dgst)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:latest", ref)
+	_, err = DefaultNaming.OCIRef("localhost:5000/nginx@" + dgst)
+	require.Error(t, err)
+
+	_, err = DefaultNaming.NydusRef("localhost:5000/nginx:latest@" + dgst)
+	require.Error(t, err)
+}
+
+func TestNamingRules(t *testing.T) {
+	naming, err := NewNaming(&config.Naming{
+		Suffix: "-nydus",
+		Rules: []config.RewriteRule{
+			{Source: `^registry\.example\.com/apps/(?P<name>[^:]+):(.+)$`, Target: "registry.example.com/nydus/${name}:$2"},
+		},
+		ReverseRules: []config.RewriteRule{
+			{Source: `^registry\.example\.com/nydus/([^:]+):(.+)$`, Target: "registry.example.com/apps/$1:$2"},
+		},
+	})
+	require.NoError(t, err)
+
+	ref, err := naming.NydusRef("registry.example.com/apps/web:v1")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/nydus/web:v1", ref)
+	ref, err = naming.NydusRef("registry.example.com/other/web:v1")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/other/web:v1-nydus", ref)
+	// The nydus image is kept as is.
+	ref, err = naming.NydusRef("registry.example.com/nydus/web:v1")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/nydus/web:v1", ref)
+
+	isNydus, err := naming.IsNydusRef("registry.example.com/nydus/web:v1")
+	require.NoError(t, err)
+	require.True(t, isNydus)
+	isNydus, err = naming.IsNydusRef("registry.example.com/other/web:v1_nydus_v2")
+	require.NoError(t, err)
+	require.False(t, isNydus)
+
+	ref, err = naming.OCIRef("registry.example.com/nydus/web:v1")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/apps/web:v1", ref)
+	ref, err = naming.OCIRef("registry.example.com/other/web:v1-nydus")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/other/web:v1", ref)
+}
+
+func TestInvalidNaming(t *testing.T) {
+	_, err := NewNaming(&config.Naming{Suffix: ":nydus"})
+	require.Error(t, err)
+	_, err = NewNaming(&config.Naming{Rules: []config.RewriteRule{{Source: "(", Target: "x"}}})
+	require.Error(t, err)
+	_, err = NewNaming(&config.Naming{Rules: []config.RewriteRule{{Source: ".*"}}})
+	require.Error(t, err)
+
+	naming, err := NewNaming(&config.Naming{Rules: []config.RewriteRule{{Source: "^(.+)$", Target: "$1:invalid:tag"}}})
+	require.NoError(t, err)
+	_, err = naming.NydusRef("nginx")
+	require.Error(t, err)
+}
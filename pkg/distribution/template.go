@@ -0,0 +1,183 @@
+package distribution
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/containerd/containerd/reference/docker"
+	"github.com/pkg/errors"
+)
+
+// RefTemplateData are the variables expanded in the template of target
+// reference, e.g. `registry.example.com/app:{{.BaseTag}}-{{.Sequence}}`.
+type RefTemplateData struct {
+	// ContainerID is the id of committed container without engine type.
+	ContainerID string
+	// Date of commit in UTC, e.g. "20060102".
+	Date string
+	// Timestamp of commit in UTC to the second, e.g. "20060102150405",
+	// it differs between the scheduled runs of a watching commit.
+	Timestamp string
+	// Sequence is the count of commits in the chain of committed image,
+	// including the current one.
+	Sequence int
+	// BaseTag is the tag of OCI image which the base image of container
+	// is converted from.
+	BaseTag string
+	// ContainerName is the name of container in kubernetes pod, it's empty
+	// if the container isn't committed by pod.
+	ContainerName string
+}
+
+// IsRefTemplate checks whether `ref` contains template actions.
+func IsRefTemplate(ref string) bool {
+	return strings.Contains(ref, "{{")
+}
+
+// RefTemplateFields returns the names of RefTemplateData fields used by
+// reference template `ref`.
+func RefTemplateFields(ref string) (map[string]bool, error) {
+	tmpl, err := ParseRefTemplate(ref)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]bool{}
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			fields[n.Ident[0]] = true
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		}
+	}
+	walk(tmpl.Tree.Root)
+	return fields, nil
+}
+
+// ParseRefTemplate parses reference template `ref`.
+func ParseRefTemplate(ref string) (*template.Template, error) {
+	tmpl, err := template.New("ref").Option("missingkey=error").Parse(ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid reference template %s", ref)
+	}
+	return tmpl, nil
+}
+
+// ExpandRef expands the variables in reference template `ref` by `data`,
+// the expanded reference must be valid.
+func ExpandRef(ref string, data RefTemplateData) (string, error) {
+	if !IsRefTemplate(ref) {
+		return ref, nil
+	}
+	tmpl, err := ParseRefTemplate(ref)
+	if err != nil {
+		return "", err
+	}
+	var expanded strings.Builder
+	if err := tmpl.Execute(&expanded, data); err != nil {
+		return "", errors.Wrapf(err, "expand reference template %s", ref)
+	}
+	if _, err := docker.ParseDockerRef(expanded.String()); err != nil {
+		return "", errors.Wrapf(err, "invalid reference %s expanded from %s", expanded.String(), ref)
+	}
+	return expanded.String(), nil
+}
+
+// Tag returns the tag of image `ref`, "latest" if not tagged.
+func Tag(ref string) (string, error) {
+	named, err := docker.ParseDockerRef(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image reference: %s", ref)
+	}
+	tagged, ok := docker.TagNameOnly(named).(docker.Tagged)
+	if !ok {
+		return "", fmt.Errorf("no tag in image reference: %s", ref)
+	}
+	return tagged.Tag(), nil
+}
+
+// fieldPatterns are the patterns of RefTemplateData fields of known
+// format, so the tags of other formats, e.g. nydus tags with a suffix,
+// are not matched.
+var fieldPatterns = map[string]string{
+	"Sequence":  `\d+`,
+	"Date":      `\d{8}`,
+	"Timestamp": `\d{14}`,
+}
+
+// actionPattern returns the pattern of the text expanded from template
+// action `node`, which is specific if it's a field of known format alone.
+func actionPattern(node parse.Node) string {
+	if action, ok := node.(*parse.ActionNode); ok && len(action.Pipe.Decl) == 0 && len(action.Pipe.Cmds) == 1 {
+		if args := action.Pipe.Cmds[0].Args; len(args) == 1 {
+			if field, ok := args[0].(*parse.FieldNode); ok && len(field.Ident) == 1 {
+				if pattern, ok := fieldPatterns[field.Ident[0]]; ok {
+					return pattern
+				}
+			}
+		}
+	}
+	return `[\w.-]+`
+}
+
+// TagPattern returns the repository of reference template `ref` and the
+// pattern matching the tags expanded from it, the template actions must
+// be only in the tag.
+func TagPattern(ref string) (string, *regexp.Regexp, error) {
+	prefix, _, _ := strings.Cut(ref, "{{")
+	idx := strings.LastIndex(prefix, ":")
+	if idx == -1 || strings.Contains(prefix[idx:], "/") {
+		return "", nil, fmt.Errorf("template actions must be in the tag of reference template %s", ref)
+	}
+	tmpl, err := ParseRefTemplate(ref[idx+1:])
+	if err != nil {
+		return "", nil, err
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if text, ok := node.(*parse.TextNode); ok {
+			pattern.WriteString(regexp.QuoteMeta(string(text.Text)))
+		} else {
+			pattern.WriteString(actionPattern(node))
+		}
+	}
+	pattern.WriteString("$")
+
+	return ref[:idx], regexp.MustCompile(pattern.String()), nil
+}
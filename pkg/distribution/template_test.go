@@ -0,0 +1,108 @@
+package distribution
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandRef(t *testing.T) {
+	data := RefTemplateData{
+		ContainerID:   "4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac",
+		Date:          "20240102",
+		Timestamp:     "20240102150405",
+		Sequence:      3,
+		BaseTag:       "v1",
+		ContainerName: "sidecar",
+	}
+
+	ref, err := ExpandRef("localhost:5000/nginx:{{.BaseTag}}-{{.Date}}-{{.Sequence}}", data)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:v1-20240102-3", ref)
+
+	ref, err = ExpandRef("localhost:5000/nginx:{{.Timestamp}}", data)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:20240102150405", ref)
+
+	ref, err = ExpandRef(`localhost:5000/snapshots:{{printf "%.12s" .ContainerID}}`, data)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/snapshots:4c0fdaa8b634", ref)
+
+	ref, err = ExpandRef("localhost:5000/nginx:{{.BaseTag}}-{{.ContainerName}}", data)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:v1-sidecar", ref)
+
+	ref, err = ExpandRef("localhost:5000/nginx:latest", data)
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:latest", ref)
+
+	_, err = ExpandRef("localhost:5000/nginx:{{.Unknown}}", data)
+	require.Error(t, err)
+	_, err = ExpandRef("localhost:5000/nginx:{{.BaseTag", data)
+	require.Error(t, err)
+	// The expanded tag is invalid.
+	_, err = ExpandRef("localhost:5000/nginx:{{.ContainerID}}{{.ContainerID}}{{.ContainerID}}", data)
+	require.Error(t, err)
+	_, err = ExpandRef("localhost:5000/nginx:{{.BaseTag}}", RefTemplateData{})
+	require.Error(t, err)
+}
+
+func TestTag(t *testing.T) {
+	tag, err := Tag("nginx")
+	require.NoError(t, err)
+	require.Equal(t, "latest", tag)
+
+	tag, err = Tag("localhost:5000/nginx:v1")
+	require.NoError(t, err)
+	require.Equal(t, "v1", tag)
+
+	_, err = Tag("nginx@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac")
+	require.Error(t, err)
+}
+
+func TestTagPattern(t *testing.T) {
+	repo, pattern, err := TagPattern("localhost:5000/nginx:{{.BaseTag}}-{{.Date}}.{{.Sequence}}")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx", repo)
+	require.True(t, pattern.MatchString("v1-20240102.3"))
+	require.False(t, pattern.MatchString("v1-20240102-3"))
+	require.False(t, pattern.MatchString("latest"))
+	require.False(t, pattern.MatchString("v1-2024010-3"))
+
+	// The nydus tags of the same template are not matched.
+	_, pattern, err = TagPattern("localhost:5000/nginx:v{{.Sequence}}")
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("v3"))
+	require.False(t, pattern.MatchString("v3_nydus_v2"))
+	_, pattern, err = TagPattern("localhost:5000/nginx:{{.Timestamp}}")
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("20240102150405"))
+	require.False(t, pattern.MatchString("20240102"))
+
+	_, pattern, err = TagPattern(`nginx:snapshot-{{printf "%.12s" .ContainerID}}`)
+	require.NoError(t, err)
+	require.True(t, pattern.MatchString("snapshot-4c0fdaa8b634"))
+	require.False(t, pattern.MatchString("4c0fdaa8b634"))
+
+	for _, ref := range []string{
+		"localhost:5000/{{.ContainerID}}:latest",
+		"localhost:5000/nginx-{{.ContainerID}}",
+		"{{.ContainerID}}",
+	} {
+		_, _, err = TagPattern(ref)
+		require.Error(t, err, ref)
+	}
+}
+
+func TestRefTemplateFields(t *testing.T) {
+	fields, err := RefTemplateFields(`localhost:5000/nginx:{{.BaseTag}}-{{printf "%.12s" .ContainerID}}{{if .ContainerName}}-{{.ContainerName}}{{end}}`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"BaseTag": true, "ContainerID": true, "ContainerName": true}, fields)
+
+	fields, err = RefTemplateFields("localhost:5000/nginx:latest")
+	require.NoError(t, err)
+	require.Empty(t, fields)
+
+	_, err = RefTemplateFields("localhost:5000/nginx:{{.Date")
+	require.Error(t, err)
+}
@@ -0,0 +1,167 @@
+package doctor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/compat"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nsenter"
+	"github.com/nydusaccelerator/nydus-cli/pkg/workdir"
+)
+
+// checkTimeout bounds each check which talks to other processes.
+const checkTimeout = 10 * time.Second
+
+// Root checks that the current user is root, which is required to read
+// the upper dirs of containers and to enter their namespaces.
+func Root() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("requires root, current euid is %d", os.Geteuid())
+	}
+	return nil
+}
+
+// Filesystem checks that filesystem `name` is supported by kernel.
+func Filesystem(name string) error {
+	file, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return errors.Wrap(err, "read supported filesystems")
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == name {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read supported filesystems")
+	}
+	return fmt.Errorf("filesystem %s is not supported by kernel", name)
+}
+
+// engineVersion is the response of `/version` of docker and pouch.
+type engineVersion struct {
+	Version    string `json:"Version"`
+	APIVersion string `json:"ApiVersion"`
+}
+
+// Engine checks that the docker or pouch daemon listens on unix socket
+// `addr`, and returns its version.
+func Engine(ctx context.Context, addr string) (string, error) {
+	if _, err := os.Stat(addr); err != nil {
+		return "", errors.Wrap(err, "stat socket")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", addr)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://engine/version", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "connect %s", addr)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", errors.Wrap(err, "read version")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get version: unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var version engineVersion
+	if err := json.Unmarshal(body, &version); err != nil {
+		return "", errors.Wrap(err, "parse version")
+	}
+	return fmt.Sprintf("version %s, api %s", version.Version, version.APIVersion), nil
+}
+
+// Nsenter checks that the mount namespace of other processes can be
+// entered, which is required to commit the paths of --with-path, by
+// running `true` in the mount namespace of init.
+func Nsenter(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	config := &nsenter.Config{
+		Mount:  true,
+		Target: 1,
+	}
+	if stderr, err := config.ExecuteContext(ctx, io.Discard, "true"); err != nil {
+		if stderr = strings.TrimSpace(stderr); stderr != "" {
+			err = fmt.Errorf("%w: %s", err, stderr)
+		}
+		return errors.Wrap(err, "enter mount namespace of pid 1")
+	}
+	return nil
+}
+
+// Binary checks that binary `path` runs, and returns its resolved path
+// and version.
+func Binary(ctx context.Context, path string) (string, error) {
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", err
+	}
+	version, err := compat.BinaryVersion(ctx, resolved)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s", resolved, version), nil
+}
+
+// Fuse checks that the fuse device exists, which is required by nydusd.
+func Fuse() error {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		return errors.Wrap(err, "check fuse device")
+	}
+	return nil
+}
+
+// WorkDir checks that work dir `dir` is writable and has at least
+// `minFree` bytes available, and returns the available size.
+func WorkDir(dir string, minFree uint64) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "create work dir")
+	}
+	file, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		return "", errors.Wrap(err, "write work dir")
+	}
+	file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		return "", errors.Wrap(err, "remove test file")
+	}
+
+	available, err := workdir.Available(dir)
+	if err != nil {
+		return "", err
+	}
+	if available < minFree {
+		return "", fmt.Errorf("%s available in %s, less than %s", humanize.IBytes(available), dir, humanize.IBytes(minFree))
+	}
+	return fmt.Sprintf("%s available", humanize.IBytes(available)), nil
+}
@@ -0,0 +1,117 @@
+// Package doctor checks the environment of node before commits, e.g. the
+// builder, the container engines, the kernel and the work dir, since most
+// failures of commits in the field are environmental. The checks and the
+// report are shared by the doctor and selftest commands.
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Check is a named check of environment, Run returns the detail of passed
+// check, e.g. the version of binary.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// Result is the result of a check, or of a commit case of selftest.
+type Result struct {
+	Name    string        `json:"name"`
+	Passed  bool          `json:"passed"`
+	Detail  string        `json:"detail,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// NewResult returns the result of check `name` started at `start`.
+func NewResult(name string, start time.Time, detail string, err error) Result {
+	result := Result{
+		Name:    name,
+		Passed:  err == nil,
+		Detail:  detail,
+		Elapsed: time.Since(start),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Report is the results of checks, it's passed only if all checks passed.
+type Report struct {
+	Passed  bool     `json:"passed"`
+	Results []Result `json:"results"`
+}
+
+// Add appends `results` to report.
+func (r *Report) Add(results ...Result) {
+	r.Results = append(r.Results, results...)
+	r.Passed = true
+	for _, result := range r.Results {
+		if !result.Passed {
+			r.Passed = false
+		}
+	}
+}
+
+// Run runs `checks` in order, each check runs even if the previous ones
+// failed, so all problems are reported at once.
+func (r *Report) Run(ctx context.Context, checks ...Check) {
+	for _, check := range checks {
+		start := time.Now()
+		detail, err := check.Run(ctx)
+		r.Add(NewResult(check.Name, start, detail, err))
+	}
+}
+
+// Err returns the failures in report as an error, or nil if passed.
+func (r *Report) Err() error {
+	if r.Passed {
+		return nil
+	}
+	failures := []string{}
+	for _, result := range r.Results {
+		if !result.Passed {
+			failures = append(failures, fmt.Sprintf("%s: %s", result.Name, result.Error))
+		}
+	}
+	if len(failures) == 0 {
+		return fmt.Errorf("no check run")
+	}
+	return fmt.Errorf("%d of %d checks failed: %s", len(failures), len(r.Results), strings.Join(failures, "; "))
+}
+
+// Print writes report in human readable lines.
+func (r *Report) Print(writer io.Writer) {
+	for _, result := range r.Results {
+		status := "PASS"
+		message := result.Detail
+		if !result.Passed {
+			status = "FAIL"
+			message = result.Error
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s", status, result.Name, result.Elapsed.Round(time.Millisecond))
+		if message != "" {
+			fmt.Fprintf(writer, "\t%s", message)
+		}
+		fmt.Fprintln(writer)
+	}
+	if r.Passed {
+		fmt.Fprintln(writer, "all checks passed")
+	} else {
+		fmt.Fprintln(writer, "some checks failed")
+	}
+}
+
+// WriteJSON writes report in json.
+func (r *Report) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
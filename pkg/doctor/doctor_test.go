@@ -0,0 +1,88 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	report := &Report{}
+	require.ErrorContains(t, report.Err(), "no check run")
+
+	report.Run(context.Background(),
+		Check{Name: "builder", Run: func(ctx context.Context) (string, error) {
+			return "nydus-image v2.2.4", nil
+		}},
+		Check{Name: "overlayfs", Run: func(ctx context.Context) (string, error) {
+			return "", fmt.Errorf("not supported")
+		}},
+		Check{Name: "nsenter", Run: func(ctx context.Context) (string, error) {
+			return "", fmt.Errorf("permission denied")
+		}},
+	)
+	require.False(t, report.Passed)
+	require.Len(t, report.Results, 3)
+	require.EqualError(t, report.Err(), "2 of 3 checks failed: overlayfs: not supported; nsenter: permission denied")
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	require.Contains(t, buf.String(), "nydus-image v2.2.4")
+	require.Contains(t, buf.String(), "FAIL\tnsenter")
+	require.Contains(t, buf.String(), "some checks failed")
+
+	passed := &Report{}
+	passed.Add(report.Results[0])
+	require.NoError(t, passed.Err())
+}
+
+func TestEngine(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", addr)
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"Version":"24.0.7","ApiVersion":"1.43"}`)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	detail, err := Engine(context.Background(), addr)
+	require.NoError(t, err)
+	require.Equal(t, "version 24.0.7, api 1.43", detail)
+
+	_, err = Engine(context.Background(), filepath.Join(t.TempDir(), "pouchd.sock"))
+	require.ErrorContains(t, err, "stat socket")
+}
+
+func TestWorkDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workdir")
+	detail, err := WorkDir(dir, 0)
+	require.NoError(t, err)
+	require.Contains(t, detail, "available")
+
+	_, err = WorkDir(dir, math.MaxUint64)
+	require.ErrorContains(t, err, "less than")
+}
+
+func TestBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nydus-image")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho 'Version: v2.2.4'\n"), 0755))
+	detail, err := Binary(context.Background(), path)
+	require.NoError(t, err)
+	require.Equal(t, path+" v2.2.4", detail)
+
+	_, err = Binary(context.Background(), filepath.Join(t.TempDir(), "nydusd"))
+	require.Error(t, err)
+}
@@ -0,0 +1,280 @@
+// Package harbor integrates the API of Harbor registries, the projects of
+// target repositories are created if they don't exist and the permissions
+// of credentials are checked before committing, instead of failing on the
+// push of manifest at last.
+package harbor
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrProjectNotFound is returned if the project doesn't exist and isn't
+	// created.
+	ErrProjectNotFound = errors.New("harbor project not found")
+	// ErrPermissionDenied is returned if the credentials aren't permitted
+	// to push the repository.
+	ErrPermissionDenied = errors.New("harbor permission denied")
+)
+
+// Client requests the Harbor at `host` with the credentials of registry,
+// e.g. a robot account.
+type Client struct {
+	client   *http.Client
+	scheme   string
+	host     string
+	username string
+	password string
+}
+
+func NewClient(client *http.Client, scheme, host, username, password string) *Client {
+	return &Client{
+		client:   client,
+		scheme:   scheme,
+		host:     host,
+		username: username,
+		password: password,
+	}
+}
+
+func (c *Client) url(path string) string {
+	return fmt.Sprintf("%s://%s%s", c.scheme, c.host, path)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, header http.Header) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return c.client.Do(req)
+}
+
+func unexpected(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status %s from %s %s: %s", resp.Status, resp.Request.Method, resp.Request.URL.Redacted(), strings.TrimSpace(string(data)))
+}
+
+// ProjectExists checks whether project `name` exists, the project not
+// readable by the credentials is regarded as existing, as the robot
+// accounts of project may not be permitted to read it.
+func (c *Client) ProjectExists(ctx context.Context, name string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/v2.0/projects/"+url.PathEscape(name), nil, http.Header{
+		"X-Is-Resource-Name": {"true"},
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusForbidden:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, unexpected(resp)
+	}
+}
+
+// CreateProject creates project `name`, the project created concurrently
+// is fine.
+func (c *Client) CreateProject(ctx context.Context, name string, public bool) error {
+	resp, err := c.do(ctx, http.MethodPost, "/api/v2.0/projects", map[string]interface{}{
+		"project_name": name,
+		"metadata":     map[string]string{"public": fmt.Sprint(public)},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusConflict:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.Wrap(ErrPermissionDenied, unexpected(resp).Error())
+	default:
+		return unexpected(resp)
+	}
+}
+
+// EnsureProject creates project `name` if it doesn't exist and `create`
+// is set, or returns ErrProjectNotFound. `created` is set if it's created.
+func (c *Client) EnsureProject(ctx context.Context, name string, create, public bool) (created bool, err error) {
+	exists, err := c.ProjectExists(ctx, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "get project %s", name)
+	}
+	if exists {
+		return false, nil
+	}
+	if !create {
+		return false, errors.Wrapf(ErrProjectNotFound, "%s in %s", name, c.host)
+	}
+	if err := c.CreateProject(ctx, name, public); err != nil {
+		return false, errors.Wrapf(err, "create project %s", name)
+	}
+	return true, nil
+}
+
+// challenge parses the bearer challenge of WWW-Authenticate header, nil
+// if it's not bearer.
+func challenge(header string) map[string]string {
+	scheme, rest, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "bearer") {
+		return nil
+	}
+	params := map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+		}
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return params
+}
+
+// GrantedActions returns the actions of `actions` on `repository` which
+// the credentials are granted, by the access of the token issued by the
+// token service of registry for them.
+func (c *Client) GrantedActions(ctx context.Context, repository string, actions ...string) ([]string, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/v2/", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return actions, nil
+	}
+	params := challenge(resp.Header.Get("WWW-Authenticate"))
+	if resp.StatusCode != http.StatusUnauthorized || params == nil || params["realm"] == "" {
+		return nil, fmt.Errorf("no bearer challenge from %s, status %s", c.host, resp.Status)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return nil, errors.Wrap(err, "parse realm")
+	}
+	query := realm.Query()
+	query.Set("service", params["service"])
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", repository, strings.Join(actions, ",")))
+	realm.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err = c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "get token")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.Wrapf(ErrPermissionDenied, "credentials of %s rejected", c.username)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrap(unexpected(resp), "get token")
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, errors.Wrap(err, "decode token")
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	// The token is read for the granted access only, it's verified by the
+	// registry when used.
+	parts := strings.Split(token.Token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("token is not JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode token payload")
+	}
+	var claims struct {
+		Access []struct {
+			Type    string   `json:"type"`
+			Name    string   `json:"name"`
+			Actions []string `json:"actions"`
+		} `json:"access"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.Wrap(err, "unmarshal token payload")
+	}
+	granted := []string{}
+	for _, access := range claims.Access {
+		if access.Type == "repository" && access.Name == repository {
+			granted = append(granted, access.Actions...)
+		}
+	}
+	return granted, nil
+}
+
+// CheckPush checks whether the credentials are permitted to pull and push
+// `repository`, or returns ErrPermissionDenied with the granted actions.
+func (c *Client) CheckPush(ctx context.Context, repository string) error {
+	required := []string{"pull", "push"}
+	granted, err := c.GrantedActions(ctx, repository, required...)
+	if err != nil {
+		return errors.Wrapf(err, "check permissions of %s", repository)
+	}
+	has := map[string]bool{}
+	for _, action := range granted {
+		has[action] = true
+	}
+	for _, action := range required {
+		if !has[action] && !has["*"] {
+			username := c.username
+			if username == "" {
+				username = "anonymous"
+			}
+			return errors.Wrapf(ErrPermissionDenied, "%s is not permitted to %s %s/%s, granted [%s]",
+				username, action, c.host, repository, strings.Join(granted, ","))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package harbor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHarbor serves the projects API and the token service granting the
+// actions in `grants` keyed by username and repository.
+type fakeHarbor struct {
+	projects map[string]bool
+	// Users permitted to create projects.
+	admins map[string]bool
+	grants map[string]map[string][]string
+}
+
+func (h *fakeHarbor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	username, _, _ := r.BasicAuth()
+	switch {
+	case r.URL.Path == "/v2/":
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/service/token",service="harbor-registry"`, r.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.URL.Path == "/service/token":
+		grants, ok := h.grants[username]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scope := strings.Split(r.URL.Query().Get("scope"), ":")
+		if r.URL.Query().Get("service") != "harbor-registry" || len(scope) != 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		access := []map[string]interface{}{}
+		if actions := grants[scope[1]]; len(actions) > 0 {
+			access = append(access, map[string]interface{}{"type": "repository", "name": scope[1], "actions": actions})
+		}
+		payload, _ := json.Marshal(map[string]interface{}{"iss": "harbor-token-issuer", "access": access})
+		json.NewEncoder(w).Encode(map[string]string{
+			"token": "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl",
+		})
+	case r.URL.Path == "/api/v2.0/projects" && r.Method == http.MethodPost:
+		if !h.admins[username] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":[{"code":"FORBIDDEN","message":"forbidden"}]}`)
+			return
+		}
+		var project struct {
+			Name     string            `json:"project_name"`
+			Metadata map[string]string `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&project); err != nil || project.Metadata["public"] != "false" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if h.projects[project.Name] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		h.projects[project.Name] = true
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(r.URL.Path, "/api/v2.0/projects/") && r.Method == http.MethodGet:
+		if r.Header.Get("X-Is-Resource-Name") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !h.projects[strings.TrimPrefix(r.URL.Path, "/api/v2.0/projects/")] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"project_id":1}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeHarbor(t *testing.T) (*fakeHarbor, string) {
+	h := &fakeHarbor{
+		projects: map[string]bool{"apps": true},
+		admins:   map[string]bool{"admin": true},
+		grants: map[string]map[string][]string{
+			"admin":          {"apps/web": {"pull", "push", "delete"}, "new/web": {"*"}},
+			"robot$apps+ci":  {"apps/web": {"pull", "push"}},
+			"robot$apps+ro":  {"apps/web": {"pull"}},
+			"robot$other+ci": {},
+		},
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return h, strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestChallenge(t *testing.T) {
+	require.Equal(t, map[string]string{
+		"realm":   "https://harbor.example.com/service/token",
+		"service": "harbor-registry",
+		"scope":   "repository:apps/web:pull,push",
+	}, challenge(`Bearer realm="https://harbor.example.com/service/token",service="harbor-registry",scope="repository:apps/web:pull,push"`))
+	require.Equal(t, map[string]string{"realm": "https://auth.example.com/token", "service": "registry"},
+		challenge(`bearer realm="https://auth.example.com/token", service=registry`))
+	require.Nil(t, challenge(`Basic realm="harbor"`))
+}
+
+func TestEnsureProject(t *testing.T) {
+	h, host := newFakeHarbor(t)
+	ctx := context.Background()
+
+	client := NewClient(http.DefaultClient, "http", host, "robot$apps+ci", "secret")
+	created, err := client.EnsureProject(ctx, "apps", false, false)
+	require.NoError(t, err)
+	require.False(t, created)
+
+	_, err = client.EnsureProject(ctx, "new", false, false)
+	require.True(t, errors.Is(err, ErrProjectNotFound))
+	_, err = client.EnsureProject(ctx, "new", true, false)
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.False(t, h.projects["new"])
+
+	client = NewClient(http.DefaultClient, "http", host, "admin", "secret")
+	created, err = client.EnsureProject(ctx, "new", true, false)
+	require.NoError(t, err)
+	require.True(t, created)
+	require.True(t, h.projects["new"])
+}
+
+func TestCheckPush(t *testing.T) {
+	_, host := newFakeHarbor(t)
+	ctx := context.Background()
+
+	require.NoError(t, NewClient(http.DefaultClient, "http", host, "robot$apps+ci", "secret").CheckPush(ctx, "apps/web"))
+	require.NoError(t, NewClient(http.DefaultClient, "http", host, "admin", "secret").CheckPush(ctx, "new/web"))
+
+	err := NewClient(http.DefaultClient, "http", host, "robot$apps+ro", "secret").CheckPush(ctx, "apps/web")
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.Contains(t, err.Error(), "robot$apps+ro is not permitted to push "+host+"/apps/web, granted [pull]")
+
+	err = NewClient(http.DefaultClient, "http", host, "robot$other+ci", "secret").CheckPush(ctx, "apps/web")
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+
+	err = NewClient(http.DefaultClient, "http", host, "unknown", "secret").CheckPush(ctx, "apps/web")
+	require.True(t, errors.Is(err, ErrPermissionDenied))
+	require.Contains(t, err.Error(), "credentials of unknown rejected")
+}
@@ -0,0 +1,195 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/nsenter"
+)
+
+// Container is an overlay mounted rootfs with a process in its own mount
+// namespace, inspected by a fake docker daemon, so that it can be
+// committed as a docker container.
+type Container struct {
+	WorkDir string
+	// Image is reported as the image of container.
+	Image string
+	// MountDestination is the path in container where the dir added by
+	// AddFilesToMount is bind mounted.
+	MountDestination string
+
+	lower  string
+	upper  string
+	work   string
+	merged string
+	mount  string
+
+	cmd    *exec.Cmd
+	server *http.Server
+}
+
+func addFiles(baseDir string, files map[string][]byte) error {
+	for name, data := range files {
+		fileName := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fileName, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start mounts the rootfs with `lowerFiles` in its lower dir, starts the
+// container process and bind mounts the mount dir into it.
+func (c *Container) Start(ctx context.Context, lowerFiles map[string][]byte) error {
+	c.lower = filepath.Join(c.WorkDir, "lower")
+	c.upper = filepath.Join(c.WorkDir, "upper")
+	c.work = filepath.Join(c.WorkDir, "work")
+	c.merged = filepath.Join(c.WorkDir, "merged")
+	c.mount = filepath.Join(c.WorkDir, "mount")
+	for _, dir := range []string{c.lower, c.upper, c.work, c.merged, c.mount} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "create %s", dir)
+		}
+	}
+	if err := addFiles(c.lower, lowerFiles); err != nil {
+		return errors.Wrap(err, "add lower files")
+	}
+
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", c.lower, c.upper, c.work)
+	if err := unix.Mount("overlay", c.merged, "overlay", 0, data); err != nil {
+		return errors.Wrap(err, "mount overlay")
+	}
+
+	c.cmd = exec.Command("sleep", "infinity")
+	c.cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS,
+	}
+	if err := c.cmd.Start(); err != nil {
+		return errors.Wrap(err, "start container process")
+	}
+
+	// The mount destination is created in host since the process shares
+	// the root with host.
+	if err := os.MkdirAll(c.MountDestination, 0755); err != nil {
+		return errors.Wrap(err, "create mount destination")
+	}
+	config := &nsenter.Config{
+		Mount:  true,
+		Target: c.cmd.Process.Pid,
+	}
+	// Keep the mounts in container from propagating to host.
+	if _, err := config.ExecuteContext(ctx, io.Discard, "mount", "--make-rprivate", "/"); err != nil {
+		return errors.Wrap(err, "make container mounts private")
+	}
+	if _, err := config.ExecuteContext(ctx, io.Discard, "mount", "--bind", c.mount, c.MountDestination); err != nil {
+		return errors.Wrap(err, "bind mount in container")
+	}
+
+	return nil
+}
+
+// AddFilesToUpper writes `files` into the rootfs of container.
+func (c *Container) AddFilesToUpper(files map[string][]byte) error {
+	return addFiles(c.merged, files)
+}
+
+// RemoveFromUpper removes `name` from the rootfs of container, a lower
+// file is hidden by whiteout in upper dir.
+func (c *Container) RemoveFromUpper(name string) error {
+	return os.RemoveAll(filepath.Join(c.merged, name))
+}
+
+// AddFilesToMount writes `files` into the dir mounted at MountDestination.
+func (c *Container) AddFilesToMount(files map[string][]byte) error {
+	return addFiles(c.mount, files)
+}
+
+func (c *Container) inspect() map[string]interface{} {
+	return map[string]interface{}{
+		"GraphDriver": map[string]interface{}{
+			"Name": "overlay2",
+			"Data": map[string]string{
+				"LowerDir": c.lower,
+				"UpperDir": c.upper,
+				"WorkDir":  c.work,
+			},
+		},
+		"Config": map[string]interface{}{
+			"Image":  c.Image,
+			"Labels": map[string]string{},
+		},
+		"Mounts": []map[string]string{
+			{
+				"Source":      c.mount,
+				"Destination": c.MountDestination,
+			},
+		},
+		"State": map[string]interface{}{
+			"Running": true,
+			"Pid":     c.cmd.Process.Pid,
+		},
+	}
+}
+
+// Serve serves the inspect api of docker on unix socket, the returned
+// address is used as docker address of commit.
+func (c *Container) Serve() (string, error) {
+	addr := filepath.Join(c.WorkDir, "dockerd.sock")
+	listener, err := net.Listen("unix", addr)
+	if err != nil {
+		return "", errors.Wrap(err, "listen docker socket")
+	}
+
+	c.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Docker client may request with api version prefix.
+			if !strings.HasSuffix(req.URL.Path, "/json") || !strings.Contains(req.URL.Path, "/containers/") {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(c.inspect())
+		}),
+	}
+	go func() {
+		_ = c.server.Serve(listener)
+	}()
+
+	return addr, nil
+}
+
+// Destroy stops the container process and daemon, and umounts the rootfs.
+func (c *Container) Destroy() error {
+	errs := []error{}
+	if c.server != nil {
+		if err := c.server.Close(); err != nil {
+			errs = append(errs, errors.Wrap(err, "stop docker daemon"))
+		}
+	}
+	if c.cmd != nil && c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+		_ = c.cmd.Wait()
+	}
+	if c.merged != "" {
+		if err := unix.Unmount(c.merged, unix.MNT_DETACH); err != nil && err != unix.EINVAL {
+			errs = append(errs, errors.Wrap(err, "umount rootfs"))
+		}
+	}
+	return goerrors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Shell runs `command` by sh with the output to stdout and stderr, it's
+// used to drive the external tools, e.g. docker and nydusify.
+func Shell(ctx context.Context, command string) error {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "run %q", command)
+	}
+	return nil
+}
+
+// BuildDockerImage builds image `name` from `base` with `files` added by
+// docker in context dir `contextDir`, and pushes it.
+func BuildDockerImage(ctx context.Context, contextDir, base, name string, files map[string][]byte) error {
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		return errors.Wrap(err, "create context dir")
+	}
+	if err := addFiles(contextDir, files); err != nil {
+		return errors.Wrap(err, "add files")
+	}
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dockerfile := []string{"FROM " + base}
+	for _, name := range names {
+		dockerfile = append(dockerfile, fmt.Sprintf("ADD %s /%s", name, name))
+	}
+	if err := os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(strings.Join(dockerfile, "\n")), 0644); err != nil {
+		return errors.Wrap(err, "write Dockerfile")
+	}
+
+	if err := Shell(ctx, fmt.Sprintf("docker build -t %s %s", name, contextDir)); err != nil {
+		return err
+	}
+	return Shell(ctx, "docker push "+name)
+}
@@ -0,0 +1,414 @@
+// Package harness runs commit end to end against local services: an
+// in-memory registry, an in-memory OSS and overlay mounted containers
+// inspected by a fake docker daemon, then mounts each committed image by
+// nydusd and checks its files. It needs root to mount.
+package harness
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	"github.com/nydusaccelerator/nydus-cli/pkg/doctor"
+	nydusdPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/nydusd"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+	"github.com/nydusaccelerator/nydus-cli/pkg/workflow"
+)
+
+const (
+	BackendRegistry = "registry"
+	BackendOSS      = "oss"
+)
+
+const (
+	ossBucket       = "selftest"
+	ossObjectPrefix = "blobs/"
+)
+
+type Config struct {
+	// WorkDir holds the files of containers and commits, removed after run.
+	WorkDir string
+	// Containers is the count of containers committed in parallel.
+	Containers int
+	// Backend is where the blobs are pushed, one of "registry" and "oss".
+	Backend string
+	// Builder and Nydusd are the paths of nydus-image and nydusd binary,
+	// found in PATH if not set.
+	Builder string
+	Nydusd  string
+	// Output receives the output of nydusd, discarded if nil.
+	Output io.Writer
+}
+
+// env holds the services shared by all cases.
+type env struct {
+	cfg      Config
+	registry *Registry
+	oss      *OSS
+}
+
+// Run commits `cfg.Containers` containers in parallel and verifies the
+// committed images, the failures of all checks and cases are returned as
+// an error.
+func Run(ctx context.Context, cfg Config) error {
+	return Qualify(ctx, cfg).Err()
+}
+
+// Qualify runs the preflight checks of node, then commits `cfg.Containers`
+// containers in parallel and verifies the committed images if all checks
+// passed. The report lists the checks, the setup of local services with
+// the backend, and then the cases.
+func Qualify(ctx context.Context, cfg Config) *doctor.Report {
+	report := &doctor.Report{}
+
+	start := time.Now()
+	if err := validate(&cfg); err != nil {
+		report.Add(doctor.NewResult("config", start, "", err))
+		return report
+	}
+	report.Run(ctx, preflightChecks(cfg)...)
+	if !report.Passed {
+		return report
+	}
+
+	start = time.Now()
+	cases, err := runCases(ctx, cfg)
+	report.Add(doctor.NewResult("setup", start, "backend "+cfg.Backend, err))
+	report.Add(cases...)
+
+	return report
+}
+
+func validate(cfg *Config) error {
+	if cfg.Containers <= 0 {
+		return fmt.Errorf("invalid container count %d", cfg.Containers)
+	}
+	if cfg.Backend != BackendRegistry && cfg.Backend != BackendOSS {
+		return fmt.Errorf("invalid backend %s, should be one of %s and %s", cfg.Backend, BackendRegistry, BackendOSS)
+	}
+	if cfg.Builder == "" {
+		cfg.Builder = "nydus-image"
+	}
+	if cfg.Nydusd == "" {
+		cfg.Nydusd = "nydusd"
+	}
+	if cfg.Output == nil {
+		cfg.Output = io.Discard
+	}
+	workDir, err := filepath.Abs(cfg.WorkDir)
+	if err != nil {
+		return errors.Wrap(err, "get absolute work dir")
+	}
+	cfg.WorkDir = workDir
+	return nil
+}
+
+// runCases starts the local services and runs the commit cases in
+// parallel, the error is returned only if the services failed to start.
+func runCases(ctx context.Context, cfg Config) ([]doctor.Result, error) {
+	var err error
+	cfg.WorkDir, err = os.MkdirTemp(cfg.WorkDir, "selftest-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(cfg.WorkDir)
+
+	e := &env{cfg: cfg}
+	if e.registry, err = NewRegistry(); err != nil {
+		return nil, err
+	}
+	defer e.registry.Close()
+	if cfg.Backend == BackendOSS {
+		if e.oss, err = NewOSS(); err != nil {
+			return nil, err
+		}
+		defer e.oss.Close()
+	}
+
+	results := make([]doctor.Result, cfg.Containers)
+	wg := sync.WaitGroup{}
+	for idx := 0; idx < cfg.Containers; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			name := fmt.Sprintf("c%d", idx)
+			start := time.Now()
+			err := e.runCase(ctx, name)
+			results[idx] = doctor.NewResult("container "+name, start, "", err)
+			if err != nil {
+				logrus.WithError(err).Errorf("selftest container %s failed", name)
+				return
+			}
+			logrus.Infof("selftest container %s passed", name)
+		}(idx)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+func (e *env) runCase(ctx context.Context, name string) error {
+	caseDir := filepath.Join(e.cfg.WorkDir, name)
+	repo := "selftest/" + name
+	mountDestination := filepath.Join(caseDir, "dir-mount")
+
+	lowerFiles := map[string][]byte{
+		"dir-lower/file-1": []byte("lower-1-" + name),
+		"dir-lower/file-2": []byte("lower-2-" + name),
+	}
+	upperFiles := map[string][]byte{
+		"dir-upper/file-1": []byte("upper-1-" + name),
+		"dir-upper/file-2": []byte("upper-2-" + name),
+	}
+	removedFile := "dir-lower/file-2"
+	mountFiles := map[string][]byte{
+		"file-3": []byte("mount-3-" + name),
+	}
+
+	expected := map[string][]byte{}
+	for _, files := range []map[string][]byte{lowerFiles, upperFiles} {
+		for name, data := range files {
+			expected[name] = data
+		}
+	}
+	delete(expected, removedFile)
+	for name, data := range mountFiles {
+		expected[filepath.Join(strings.TrimPrefix(mountDestination, "/"), name)] = data
+	}
+
+	base, err := buildBase(ctx, filepath.Join(caseDir, "base"), e.cfg.Builder, lowerFiles, e.oss != nil)
+	if err != nil {
+		return errors.Wrap(err, "build base image")
+	}
+	base.push(e.registry, repo, "base"+distribution.DefaultNydusRefSuffix, e.oss, ossBucket, ossObjectPrefix)
+
+	container := Container{
+		WorkDir:          filepath.Join(caseDir, "container"),
+		Image:            fmt.Sprintf("%s/%s:base%s", e.registry.Host(), repo, distribution.DefaultNydusRefSuffix),
+		MountDestination: mountDestination,
+	}
+	defer func() {
+		if err := container.Destroy(); err != nil {
+			logrus.WithError(err).Warnf("destroy container %s", name)
+		}
+	}()
+	if err := container.Start(ctx, lowerFiles); err != nil {
+		return errors.Wrap(err, "start container")
+	}
+	if err := container.AddFilesToUpper(upperFiles); err != nil {
+		return errors.Wrap(err, "add upper files")
+	}
+	if err := container.RemoveFromUpper(removedFile); err != nil {
+		return errors.Wrap(err, "remove lower file")
+	}
+	if err := container.AddFilesToMount(mountFiles); err != nil {
+		return errors.Wrap(err, "add mount files")
+	}
+	dockerAddr, err := container.Serve()
+	if err != nil {
+		return errors.Wrap(err, "serve container")
+	}
+
+	if err := e.commit(ctx, caseDir, dockerAddr, name, repo, mountDestination); err != nil {
+		return errors.Wrap(err, "commit")
+	}
+
+	if err := e.verify(ctx, caseDir, repo, "committed"+distribution.DefaultNydusRefSuffix, expected); err != nil {
+		return errors.Wrap(err, "verify committed image")
+	}
+
+	return nil
+}
+
+func (e *env) commit(ctx context.Context, caseDir, dockerAddr, name, repo, mountDestination string) error {
+	cfg := &config.Config{
+		Base: config.Base{
+			WorkDir: filepath.Join(caseDir, "commit"),
+			Builder: e.cfg.Builder,
+			Runtime: config.Runtime{
+				DockerAddr: dockerAddr,
+			},
+		},
+	}
+	if e.oss != nil {
+		cfg.OSS = config.OSS{
+			Endpoint:     e.oss.Endpoint(),
+			BucketName:   ossBucket,
+			ObjectPrefix: ossObjectPrefix,
+		}
+	}
+
+	wf, err := workflow.NewWorkflow(cfg)
+	if err != nil {
+		return errors.Wrap(err, "create workflow")
+	}
+	defer func() {
+		if err := wf.Destory(); err != nil {
+			logrus.WithError(err).Warnf("destroy workflow of %s", name)
+		}
+	}()
+
+	return wf.Commit(ctx, workflow.CommitOption{
+		ContainerIDWithType: "docker://" + name,
+		Targets: []workflow.Target{{
+			Ref:    fmt.Sprintf("%s/%s:committed", e.registry.Host(), repo),
+			Format: workflow.FormatNydus,
+		}},
+		WithPaths:    []string{mountDestination},
+		MaximumTimes: 10,
+	})
+}
+
+// blobIDs returns the ids of blobs referenced by the manifest of image.
+func blobIDs(manifest ocispec.Manifest, bootstrapDesc ocispec.Descriptor) ([]string, error) {
+	if value, ok := bootstrapDesc.Annotations[utils.LayerAnnotationNydusBlobIDs]; ok {
+		ids := []string{}
+		if err := json.Unmarshal([]byte(value), &ids); err != nil {
+			return nil, errors.Wrap(err, "unmarshal blob ids")
+		}
+		return ids, nil
+	}
+	ids := []string{}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == utils.MediaTypeNydusBlob {
+			ids = append(ids, layer.Digest.Hex())
+		}
+	}
+	return ids, nil
+}
+
+// verify mounts image `repo:tag` by nydusd and compares its regular files
+// with `expected`.
+func (e *env) verify(ctx context.Context, caseDir, repo, tag string, expected map[string][]byte) error {
+	manifestBytes, ok := e.registry.Manifest(repo, tag)
+	if !ok {
+		return fmt.Errorf("not found manifest %s:%s", repo, tag)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return errors.Wrap(err, "unmarshal manifest")
+	}
+	bootstrapDesc := parser.FindNydusBootstrapDesc(&manifest)
+	if bootstrapDesc == nil {
+		return fmt.Errorf("not found nydus bootstrap layer")
+	}
+	bootstrapGz, ok := e.registry.Blob(bootstrapDesc.Digest)
+	if !ok {
+		return fmt.Errorf("not found bootstrap layer %s", bootstrapDesc.Digest)
+	}
+
+	verifyDir := filepath.Join(caseDir, "verify")
+	blobDir := filepath.Join(verifyDir, "blobs")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return errors.Wrap(err, "create blob dir")
+	}
+	bootstrap := filepath.Join(verifyDir, "bootstrap")
+	if err := utils.UnpackFile(bytes.NewReader(bootstrapGz), utils.BootstrapFileNameInLayer, bootstrap); err != nil {
+		return errors.Wrap(err, "unpack bootstrap")
+	}
+
+	ids, err := blobIDs(manifest, *bootstrapDesc)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		var data []byte
+		var ok bool
+		if e.oss != nil {
+			data, ok = e.oss.Object(ossBucket, ossObjectPrefix+id)
+		} else {
+			data, ok = e.registry.Blob(digest.NewDigestFromEncoded(digest.SHA256, id))
+		}
+		if !ok {
+			return fmt.Errorf("not found blob %s", id)
+		}
+		if err := os.WriteFile(filepath.Join(blobDir, id), data, 0644); err != nil {
+			return errors.Wrapf(err, "write blob %s", id)
+		}
+	}
+
+	nydusd := nydusdPkg.Daemon{
+		Path:      e.cfg.Nydusd,
+		Bootstrap: bootstrap,
+		BlobDir:   blobDir,
+		MountPath: filepath.Join(verifyDir, "mnt"),
+		WorkDir:   verifyDir,
+	}
+	if err := nydusd.Mount(ctx, e.cfg.Output); err != nil {
+		return errors.Wrap(err, "mount by nydusd")
+	}
+	defer func() {
+		if err := nydusd.Umount(); err != nil {
+			logrus.WithError(err).Warnf("umount %s", nydusd.MountPath)
+		}
+	}()
+
+	actual, err := readFiles(nydusd.MountPath)
+	if err != nil {
+		return errors.Wrap(err, "read mounted files")
+	}
+	return compareFiles(expected, actual)
+}
+
+// readFiles reads the regular files under `root` keyed by relative path.
+func readFiles(root string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = data
+		return nil
+	})
+	return files, err
+}
+
+func compareFiles(expected, actual map[string][]byte) error {
+	diffs := []string{}
+	for name, data := range expected {
+		got, ok := actual[name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("missing %s", name))
+		} else if !bytes.Equal(got, data) {
+			diffs = append(diffs, fmt.Sprintf("mismatched %s: %q != %q", name, got, data))
+		}
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected %s", name))
+		}
+	}
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		return fmt.Errorf("files differ: %s", strings.Join(diffs, ", "))
+	}
+	return nil
+}
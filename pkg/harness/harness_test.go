@@ -0,0 +1,161 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+type readerAt struct {
+	*bytes.Reader
+}
+
+func (r readerAt) Close() error {
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	registry, err := NewRegistry()
+	require.NoError(t, err)
+	defer registry.Close()
+
+	remoter, err := remote.New(registry.Host()+"/selftest/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return remote.NewResolver(true, true, nil)
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	blob := []byte("blob")
+	blobDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+	require.NoError(t, remoter.Push(ctx, blobDesc, true, bytes.NewReader(blob)))
+	data, ok := registry.Blob(blobDesc.Digest)
+	require.True(t, ok)
+	require.Equal(t, blob, data)
+
+	manifest := []byte(`{"schemaVersion":2}`)
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	require.NoError(t, remoter.Push(ctx, manifestDesc, false, bytes.NewReader(manifest)))
+	resolved, err := remoter.Resolve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, manifestDesc.Digest, resolved.Digest)
+	reader, err := remoter.Pull(ctx, *resolved, true)
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, manifest, data)
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return remote.NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	chunked := bytes.Repeat([]byte("nydus"), int(remote.StreamChunkSize)/5+1)
+	chunkedDesc := ocispec.Descriptor{Digest: digest.FromBytes(chunked), Size: int64(len(chunked))}
+	err = remoter.PushChunked(ctx, hostsFunc, bytes.NewReader(chunked), chunkedDesc, filepath.Join(t.TempDir(), "upload.json"))
+	require.NoError(t, err)
+	data, ok = registry.Blob(chunkedDesc.Digest)
+	require.True(t, ok)
+	require.Equal(t, chunked, data)
+
+	size, err := remoter.Stat(ctx, hostsFunc, chunkedDesc.Digest)
+	require.NoError(t, err)
+	require.Equal(t, chunkedDesc.Size, size)
+	_, err = remoter.Stat(ctx, hostsFunc, digest.FromString("missing"))
+	require.True(t, errdefs.IsNotFound(err))
+}
+
+func TestOSS(t *testing.T) {
+	oss, err := NewOSS()
+	require.NoError(t, err)
+	defer oss.Close()
+
+	be, err := backend.NewOSSBackend(&config.OSS{
+		Endpoint:     oss.Endpoint(),
+		BucketName:   ossBucket,
+		ObjectPrefix: ossObjectPrefix,
+	}, false)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	blob := bytes.Repeat([]byte("nydus"), 1024)
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	require.NoError(t, be.Push(ctx, readerAt{bytes.NewReader(blob)}, desc))
+	data, ok := oss.Object(ossBucket, ossObjectPrefix+desc.Digest.Hex())
+	require.True(t, ok)
+	require.Equal(t, blob, data)
+
+	streamed := bytes.Repeat([]byte("stream"), 1024)
+	dgst, size, err := be.PushStream(ctx, bytes.NewReader(streamed))
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(streamed), dgst)
+	require.Equal(t, int64(len(streamed)), size)
+
+	reader, err := be.Pull(dgst)
+	require.NoError(t, err)
+	data, err = io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	require.Equal(t, streamed, data)
+
+	size, err = be.Stat(ctx, dgst)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(streamed)), size)
+	_, err = be.Stat(ctx, digest.FromString("missing"))
+	require.True(t, errdefs.IsNotFound(err))
+
+	// Only the blobs are left, the temporary object is removed.
+	oss.mutex.Lock()
+	require.Len(t, oss.objects, 2)
+	require.Empty(t, oss.uploads)
+	oss.mutex.Unlock()
+}
+
+func TestCompareFiles(t *testing.T) {
+	expected := map[string][]byte{
+		"dir/file-1": []byte("1"),
+		"dir/file-2": []byte("2"),
+	}
+	require.NoError(t, compareFiles(expected, map[string][]byte{
+		"dir/file-1": []byte("1"),
+		"dir/file-2": []byte("2"),
+	}))
+	err := compareFiles(expected, map[string][]byte{
+		"dir/file-1": []byte("x"),
+		"dir/file-3": []byte("3"),
+	})
+	require.EqualError(t, err, `files differ: mismatched dir/file-1: "x" != "1", missing dir/file-2, unexpected dir/file-3`)
+}
+
+func TestQualifyInvalidConfig(t *testing.T) {
+	report := Qualify(context.Background(), Config{
+		WorkDir:    t.TempDir(),
+		Containers: 1,
+		Backend:    "s3",
+	})
+	require.False(t, report.Passed)
+	require.Len(t, report.Results, 1)
+	require.Equal(t, "config", report.Results[0].Name)
+	require.ErrorContains(t, report.Err(), "config: invalid backend s3")
+}
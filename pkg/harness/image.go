@@ -0,0 +1,210 @@
+package harness
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+// writeTar writes `files` keyed by relative path into a tar stream, with
+// the parent directories of them.
+func writeTar(writer io.Writer, files map[string][]byte) error {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(writer)
+	dirs := map[string]bool{}
+	for _, name := range names {
+		parts := strings.Split(name, "/")
+		for idx := 1; idx < len(parts); idx++ {
+			dir := strings.Join(parts[:idx], "/")
+			if dirs[dir] {
+				continue
+			}
+			dirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     dir + "/",
+				Mode:     0755,
+				Typeflag: tar.TypeDir,
+			}); err != nil {
+				return err
+			}
+		}
+		data := files[name]
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// baseImage is a nydus image built from files to be the image of
+// container.
+type baseImage struct {
+	blob        []byte
+	bootstrapGz []byte
+	manifest    []byte
+	config      []byte
+}
+
+// buildBase packs `files` into a nydus blob and merges its bootstrap by
+// builder, the blob is referenced by layer if `external` is false, or by
+// the bootstrap annotation as images with external backend.
+func buildBase(ctx context.Context, workDir, builder string, files map[string][]byte, external bool) (*baseImage, error) {
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "prepare work dir")
+	}
+
+	var blob bytes.Buffer
+	tarWc, err := converter.Pack(ctx, &blob, converter.PackOption{
+		WorkDir:     workDir,
+		FsVersion:   "5",
+		Compressor:  "lz4_block",
+		BuilderPath: builder,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize pack to blob")
+	}
+	if err := writeTar(tarWc, files); err != nil {
+		tarWc.Close()
+		return nil, errors.Wrap(err, "write files")
+	}
+	if err := tarWc.Close(); err != nil {
+		return nil, errors.Wrap(err, "pack blob")
+	}
+	blobDigest := digest.FromBytes(blob.Bytes())
+
+	blobPath := filepath.Join(workDir, "blob-base")
+	if err := os.WriteFile(blobPath, blob.Bytes(), 0644); err != nil {
+		return nil, errors.Wrap(err, "write blob")
+	}
+	ra, err := local.OpenReader(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open blob")
+	}
+	defer ra.Close()
+
+	var bootstrapTar bytes.Buffer
+	if _, err := converter.Merge(ctx, []converter.Layer{{
+		Digest:   blobDigest,
+		ReaderAt: ra,
+	}}, &bootstrapTar, converter.MergeOption{
+		WorkDir:     workDir,
+		FsVersion:   "5",
+		WithTar:     true,
+		BuilderPath: builder,
+	}); err != nil {
+		return nil, errors.Wrap(err, "merge bootstrap")
+	}
+
+	var bootstrapGz bytes.Buffer
+	gzWriter := gzip.NewWriter(&bootstrapGz)
+	if _, err := gzWriter.Write(bootstrapTar.Bytes()); err != nil {
+		return nil, errors.Wrap(err, "compress bootstrap")
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, errors.Wrap(err, "compress bootstrap")
+	}
+
+	blobDesc := ocispec.Descriptor{
+		MediaType: utils.MediaTypeNydusBlob,
+		Digest:    blobDigest,
+		Size:      int64(blob.Len()),
+		Annotations: map[string]string{
+			utils.LayerAnnotationNydusBlob: "true",
+		},
+	}
+	bootstrapDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayerGzip,
+		Digest:    digest.FromBytes(bootstrapGz.Bytes()),
+		Size:      int64(bootstrapGz.Len()),
+		Annotations: map[string]string{
+			converter.LayerAnnotationFSVersion:      "5",
+			converter.LayerAnnotationNydusBootstrap: "true",
+		},
+	}
+	bootstrapDiffID := digest.FromBytes(bootstrapTar.Bytes())
+
+	config := ocispec.Image{
+		OS:           "linux",
+		Architecture: "amd64",
+		RootFS:       ocispec.RootFS{Type: "layers"},
+	}
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	}
+	if external {
+		blobIDs, err := json.Marshal([]string{blobDigest.Hex()})
+		if err != nil {
+			return nil, errors.Wrap(err, "marshal blob ids")
+		}
+		bootstrapDesc.Annotations[utils.LayerAnnotationNydusBlobIDs] = string(blobIDs)
+		config.RootFS.DiffIDs = []digest.Digest{bootstrapDiffID}
+		manifest.Layers = []ocispec.Descriptor{bootstrapDesc}
+	} else {
+		config.RootFS.DiffIDs = []digest.Digest{blobDigest, bootstrapDiffID}
+		manifest.Layers = []ocispec.Descriptor{blobDesc, bootstrapDesc}
+	}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal config")
+	}
+	manifest.Config = ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configBytes),
+		Size:      int64(len(configBytes)),
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal manifest")
+	}
+
+	return &baseImage{
+		blob:        blob.Bytes(),
+		bootstrapGz: bootstrapGz.Bytes(),
+		manifest:    manifestBytes,
+		config:      configBytes,
+	}, nil
+}
+
+// push stores the image into registry as `repo:tag`, and the blob into
+// object storage if it's set.
+func (image *baseImage) push(registry *Registry, repo, tag string, oss *OSS, bucket, prefix string) {
+	registry.PutBlob(image.config)
+	registry.PutBlob(image.bootstrapGz)
+	if oss != nil {
+		oss.PutBlob(bucket, prefix, image.blob)
+	} else {
+		registry.PutBlob(image.blob)
+	}
+	registry.PutManifest(repo, tag, ocispec.MediaTypeImageManifest, image.manifest)
+}
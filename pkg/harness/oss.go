@@ -0,0 +1,241 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// OSS is an in-memory object storage serving the subset of OSS API used by
+// oss backend, buckets are addressed by path since endpoint is an IP.
+type OSS struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	// Parts of multipart uploads keyed by upload id and part number.
+	uploads map[string]map[int][]byte
+	nextID  int
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewOSS starts an object storage listening on a random local port.
+func NewOSS() (*OSS, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen oss")
+	}
+	oss := &OSS{
+		objects:  map[string][]byte{},
+		uploads:  map[string]map[int][]byte{},
+		listener: listener,
+	}
+	oss.server = &http.Server{Handler: oss}
+	go func() {
+		_ = oss.server.Serve(listener)
+	}()
+	return oss, nil
+}
+
+// Endpoint returns the endpoint used in oss config.
+func (o *OSS) Endpoint() string {
+	return "http://" + o.listener.Addr().String()
+}
+
+func (o *OSS) Close() error {
+	return o.server.Close()
+}
+
+// PutObject stores object `key` of `bucket`.
+func (o *OSS) PutObject(bucket, key string, data []byte) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.objects[bucket+"/"+key] = data
+}
+
+// Object returns object `key` of `bucket`.
+func (o *OSS) Object(bucket, key string) ([]byte, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	data, ok := o.objects[bucket+"/"+key]
+	return data, ok
+}
+
+// PutBlob stores blob `data` in `bucket` named by its digest with prefix.
+func (o *OSS) PutBlob(bucket, prefix string, data []byte) digest.Digest {
+	dgst := digest.FromBytes(data)
+	o.PutObject(bucket, prefix+dgst.Hex(), data)
+	return dgst
+}
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+type copyResult struct {
+	XMLName xml.Name `xml:"CopyObjectResult"`
+	ETag    string   `xml:"ETag"`
+}
+
+type copyPartResult struct {
+	XMLName xml.Name `xml:"CopyPartResult"`
+	ETag    string   `xml:"ETag"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func etag(data []byte) string {
+	return fmt.Sprintf("%q", digest.FromBytes(data).Hex())
+}
+
+// copySource returns the data referenced by header `x-oss-copy-source`
+// in the form of `/bucket/key`, and the range of it if requested.
+func (o *OSS) copySource(req *http.Request) ([]byte, bool) {
+	source, err := url.PathUnescape(req.Header.Get("X-Oss-Copy-Source"))
+	if err != nil {
+		return nil, false
+	}
+	data, ok := o.objects[strings.TrimPrefix(source, "/")]
+	if !ok {
+		return nil, false
+	}
+	if value := req.Header.Get("X-Oss-Copy-Source-Range"); value != "" {
+		var start, end int
+		if _, err := fmt.Sscanf(value, "bytes=%d-%d", &start, &end); err != nil || end >= len(data) || start > end {
+			return nil, false
+		}
+		data = data[start : end+1]
+	}
+	return data, true
+}
+
+func (o *OSS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	name := bucket + "/" + key
+	query := req.URL.Query()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	switch req.Method {
+	case http.MethodHead, http.MethodGet:
+		data, ok := o.objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("ETag", etag(data))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodPost:
+		if _, ok := query["uploads"]; ok {
+			o.nextID++
+			uploadID := fmt.Sprint(o.nextID)
+			o.uploads[uploadID] = map[int][]byte{}
+			writeXML(w, initiateResult{Bucket: bucket, Key: key, UploadID: uploadID})
+			return
+		}
+		parts, ok := o.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		numbers := []int{}
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var buf bytes.Buffer
+		for _, number := range numbers {
+			buf.Write(parts[number])
+		}
+		o.objects[name] = buf.Bytes()
+		delete(o.uploads, query.Get("uploadId"))
+		writeXML(w, completeResult{Bucket: bucket, Key: key, ETag: etag(buf.Bytes())})
+	case http.MethodPut:
+		var data []byte
+		copied := req.Header.Get("X-Oss-Copy-Source") != ""
+		if copied {
+			var ok bool
+			if data, ok = o.copySource(req); !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+		} else {
+			var err error
+			if data, err = io.ReadAll(req.Body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if uploadID := query.Get("uploadId"); uploadID != "" {
+			parts, ok := o.uploads[uploadID]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			number, err := strconv.Atoi(query.Get("partNumber"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			parts[number] = data
+			w.Header().Set("ETag", etag(data))
+			if copied {
+				writeXML(w, copyPartResult{ETag: etag(data)})
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		o.objects[name] = data
+		w.Header().Set("ETag", etag(data))
+		if copied {
+			writeXML(w, copyResult{ETag: etag(data)})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if uploadID := query.Get("uploadId"); uploadID != "" {
+			delete(o.uploads, uploadID)
+		} else {
+			delete(o.objects, name)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
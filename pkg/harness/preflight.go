@@ -0,0 +1,51 @@
+package harness
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/compat"
+	"github.com/nydusaccelerator/nydus-cli/pkg/doctor"
+)
+
+// preflightChecks returns the checks of node by `cfg`, the commit cases
+// run only if all checks passed.
+func preflightChecks(cfg Config) []doctor.Check {
+	return []doctor.Check{
+		{Name: "root", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Root()
+		}},
+		{Name: "overlayfs", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Filesystem("overlay")
+		}},
+		{Name: "fuse", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Fuse()
+		}},
+		{Name: "builder", Run: func(ctx context.Context) (string, error) {
+			return doctor.Binary(ctx, cfg.Builder)
+		}},
+		{Name: "nydusd", Run: func(ctx context.Context) (string, error) {
+			return doctor.Binary(ctx, cfg.Nydusd)
+		}},
+		{Name: "compat", Run: func(ctx context.Context) (string, error) {
+			return "", checkCompat(ctx, cfg)
+		}},
+		{Name: "workdir", Run: func(ctx context.Context) (string, error) {
+			return doctor.WorkDir(cfg.WorkDir, 0)
+		}},
+	}
+}
+
+// checkCompat checks builder and nydusd against the compatibility matrix.
+func checkCompat(ctx context.Context, cfg Config) error {
+	versions := compat.Versions{FsVersion: "5"}
+	var err error
+	if versions.Builder, err = compat.BinaryVersion(ctx, cfg.Builder); err != nil {
+		return errors.Wrap(err, "get builder version")
+	}
+	if versions.Nydusd, err = compat.BinaryVersion(ctx, cfg.Nydusd); err != nil {
+		return errors.Wrap(err, "get nydusd version")
+	}
+	return compat.Err(compat.Check(versions))
+}
@@ -0,0 +1,219 @@
+package harness
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Registry is an in-memory registry serving the distribution API used by
+// commit, blobs are shared by all repositories.
+type Registry struct {
+	mutex     sync.Mutex
+	blobs     map[digest.Digest][]byte
+	manifests map[string]manifest
+	uploads   map[string]*bytes.Buffer
+	nextID    int
+
+	listener net.Listener
+	server   *http.Server
+}
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+// NewRegistry starts a registry listening on a random local port.
+func NewRegistry() (*Registry, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listen registry")
+	}
+	registry := &Registry{
+		blobs:     map[digest.Digest][]byte{},
+		manifests: map[string]manifest{},
+		uploads:   map[string]*bytes.Buffer{},
+		listener:  listener,
+	}
+	registry.server = &http.Server{Handler: registry}
+	go func() {
+		_ = registry.server.Serve(listener)
+	}()
+	return registry, nil
+}
+
+// Host returns the `host:port` of registry.
+func (r *Registry) Host() string {
+	return r.listener.Addr().String()
+}
+
+func (r *Registry) Close() error {
+	return r.server.Close()
+}
+
+// PutBlob stores blob `data` and returns its digest.
+func (r *Registry) PutBlob(data []byte) digest.Digest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	dgst := digest.FromBytes(data)
+	r.blobs[dgst] = data
+	return dgst
+}
+
+// Blob returns the blob of `dgst`.
+func (r *Registry) Blob(dgst digest.Digest) ([]byte, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, ok := r.blobs[dgst]
+	return data, ok
+}
+
+// PutManifest stores manifest `data` of repository `repo` by `reference`
+// (tag or digest) and its digest.
+func (r *Registry) PutManifest(repo, reference, mediaType string, data []byte) digest.Digest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	dgst := digest.FromBytes(data)
+	m := manifest{mediaType: mediaType, data: data}
+	r.manifests[repo+":"+reference] = m
+	r.manifests[repo+":"+dgst.String()] = m
+	return dgst
+}
+
+// Manifest returns the manifest of `repo` by `reference`.
+func (r *Registry) Manifest(repo, reference string) ([]byte, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m, ok := r.manifests[repo+":"+reference]
+	return m.data, ok
+}
+
+// splitPath splits `/v2/<repo>/<kind>/<rest>` of the request path.
+func splitPath(path string) (string, string, string, bool) {
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, kind := range []string{"blobs/uploads", "blobs", "manifests"} {
+		if idx := strings.LastIndex(path, "/"+kind+"/"); idx > 0 {
+			return path[:idx], kind, path[idx+len(kind)+2:], true
+		}
+	}
+	return "", "", "", false
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/v2/" || req.URL.Path == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	repo, kind, rest, ok := splitPath(req.URL.Path)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch kind {
+	case "blobs":
+		data, ok := r.blobs[digest.Digest(rest)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Docker-Content-Digest", rest)
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case "blobs/uploads":
+		r.serveUpload(w, req, repo, rest)
+	case "manifests":
+		if req.Method == http.MethodPut {
+			data, err := io.ReadAll(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			dgst := digest.FromBytes(data)
+			m := manifest{mediaType: req.Header.Get("Content-Type"), data: data}
+			r.manifests[repo+":"+rest] = m
+			r.manifests[repo+":"+dgst.String()] = m
+			w.Header().Set("Docker-Content-Digest", dgst.String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		m, ok := r.manifests[repo+":"+rest]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.data)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.data).String())
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(m.data)
+		}
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	location := func(id string) string {
+		return fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id)
+	}
+	if req.Method == http.MethodPost {
+		r.nextID++
+		id := fmt.Sprint(r.nextID)
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", location(id))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	upload, ok := r.uploads[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if req.Method == http.MethodPatch || req.Method == http.MethodPut {
+		if _, err := io.Copy(upload, req.Body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Method == http.MethodPut {
+		dgst := digest.Digest(req.URL.Query().Get("digest"))
+		if dgst != digest.FromBytes(upload.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[dgst] = upload.Bytes()
+		delete(r.uploads, id)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	end := upload.Len() - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Location", location(id))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+	if req.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
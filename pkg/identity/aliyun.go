@@ -0,0 +1,90 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+const defaultAliyunEndpoint = "http://100.100.100.200"
+
+// aliyunProvider obtains the instance identity of ECS from the metadata
+// service, the identity is signed by Aliyun with subject as audience.
+type aliyunProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newAliyunProvider(cfg *config.Aliyun) *aliyunProvider {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = defaultAliyunEndpoint
+	}
+	return &aliyunProvider{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *aliyunProvider) get(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s from %s", resp.Status, path)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (p *aliyunProvider) Identity(ctx context.Context, subject digest.Digest) (*Identity, error) {
+	document, err := p.get(ctx, "/latest/dynamic/instance-identity/document")
+	if err != nil {
+		return nil, errors.Wrap(err, "get instance identity document")
+	}
+	var parsed struct {
+		InstanceID string `json:"instance-id"`
+	}
+	if err := json.Unmarshal([]byte(document), &parsed); err != nil {
+		return nil, errors.Wrap(err, "unmarshal instance identity document")
+	}
+	if parsed.InstanceID == "" {
+		return nil, fmt.Errorf("no instance id in instance identity document")
+	}
+
+	// The signed document includes audience, which binds it to subject.
+	now := time.Now().Truncate(time.Second)
+	audience := url.QueryEscape(string(payload(subject, now)))
+	signature, err := p.get(ctx, "/latest/dynamic/instance-identity/pkcs7?audience="+audience)
+	if err != nil {
+		return nil, errors.Wrap(err, "get instance identity signature")
+	}
+
+	return &Identity{
+		Source:    SourceAliyun,
+		ID:        parsed.InstanceID,
+		Subject:   subject,
+		Time:      now,
+		Document:  document,
+		Signature: signature,
+	}, nil
+}
@@ -0,0 +1,117 @@
+// Package identity obtains the identity of node committing images from a
+// SPIFFE workload or the cloud instance it runs on, the identity attests
+// the committed image and is verified without long-lived keys distributed
+// to nodes.
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// AnnotationIdentity is the manifest annotation of the identity which
+// attests the committed image, the value is Identity in json.
+const AnnotationIdentity = "io.nydus.cli.identity"
+
+const (
+	SourceSPIFFE = "spiffe"
+	SourceAliyun = "aliyun"
+)
+
+// Identity is the identity of node attesting the subject, i.e. the digest
+// of committed bootstrap layer.
+type Identity struct {
+	Source string `json:"source"`
+	// ID is the identity of node, e.g. the SPIFFE ID or the instance id.
+	ID      string        `json:"id"`
+	Subject digest.Digest `json:"subject"`
+	Time    time.Time     `json:"time"`
+	// Certificates are the PEM encoded certificates of SPIFFE X.509-SVID,
+	// leaf first.
+	Certificates string `json:"certificates,omitempty"`
+	// Document is the signed instance identity document of cloud.
+	Document string `json:"document,omitempty"`
+	// Signature is the base64 encoded signature of payload, signed by
+	// the SVID key or by the cloud.
+	Signature string `json:"signature"`
+}
+
+// Provider obtains the identity attesting subject.
+type Provider interface {
+	Identity(ctx context.Context, subject digest.Digest) (*Identity, error)
+}
+
+// NewProvider returns the provider configured by `cfg`, or nil if no
+// identity source is configured.
+func NewProvider(cfg *config.Identity) (Provider, error) {
+	switch cfg.Source {
+	case "":
+		return nil, nil
+	case SourceSPIFFE:
+		return newSPIFFEProvider(&cfg.SPIFFE)
+	case SourceAliyun:
+		return newAliyunProvider(&cfg.Aliyun), nil
+	default:
+		return nil, fmt.Errorf("invalid identity source: %s", cfg.Source)
+	}
+}
+
+// payload is the data signed for identity.
+func payload(subject digest.Digest, t time.Time) []byte {
+	return []byte(fmt.Sprintf("%s\n%s", subject, t.UTC().Format(time.RFC3339)))
+}
+
+// Parse returns the identity in manifest annotations, or nil if there is
+// no identity.
+func Parse(annotations map[string]string) (*Identity, error) {
+	value, ok := annotations[AnnotationIdentity]
+	if !ok {
+		return nil, nil
+	}
+	var identity Identity
+	if err := json.Unmarshal([]byte(value), &identity); err != nil {
+		return nil, errors.Wrap(err, "unmarshal identity")
+	}
+	return &identity, nil
+}
+
+// Annotate adds identity into manifest annotations.
+func Annotate(annotations map[string]string, identity *Identity) (map[string]string, error) {
+	value, err := json.Marshal(identity)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal identity")
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationIdentity] = string(value)
+	return annotations, nil
+}
+
+// Verifiable reports whether the identity of `source` can be verified by
+// Verify. The identity of cloud instance can't be verified offline, so it's
+// only logged for audit instead of attesting the committed image.
+func Verifiable(source string) bool {
+	return source == SourceSPIFFE
+}
+
+// Verify verifies that identity attests `subject` by the trust bundle of
+// SPIFFE in file `bundle`, the identities not Verifiable are rejected.
+func Verify(identity *Identity, subject digest.Digest, bundle string) error {
+	if identity.Subject != subject {
+		return fmt.Errorf("identity attests %s instead of %s", identity.Subject, subject)
+	}
+	switch identity.Source {
+	case SourceSPIFFE:
+		return verifySPIFFE(identity, bundle)
+	default:
+		return fmt.Errorf("verifying identity of source %s is not supported", identity.Source)
+	}
+}
@@ -0,0 +1,187 @@
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// workloadAPI serves the X.509-SVID by SPIFFE Workload API.
+type workloadAPI struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	svid *workload.X509SVID
+}
+
+func (api *workloadAPI) FetchX509SVID(_ *workload.X509SVIDRequest, stream workload.SpiffeWorkloadAPI_FetchX509SVIDServer) error {
+	if err := stream.Send(&workload.X509SVIDResponse{Svids: []*workload.X509SVID{api.svid}}); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// newSVID returns an X.509-SVID of `id` served by Workload API, and writes
+// the trust bundle issuing it into `dir`.
+func newSVID(t *testing.T, dir, id string) (*workload.X509SVID, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "spire"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	uri, err := url.Parse(id)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		URIs:         []*url.URL{uri},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	bundle := filepath.Join(dir, "bundle.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600))
+	return &workload.X509SVID{
+		SpiffeId:    id,
+		X509Svid:    der,
+		X509SvidKey: keyDer,
+		Bundle:      caDer,
+	}, bundle
+}
+
+// serveWorkloadAPI serves `svid` by Workload API on a unix socket in `dir`,
+// and returns its address.
+func serveWorkloadAPI(t *testing.T, dir string, svid *workload.X509SVID) string {
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, &workloadAPI{svid: svid})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return "unix://" + socket
+}
+
+func TestSPIFFE(t *testing.T) {
+	dir := t.TempDir()
+	svid, bundle := newSVID(t, dir, "spiffe://example.org/nydus-cli")
+	socket := serveWorkloadAPI(t, dir, svid)
+	provider, err := NewProvider(&config.Identity{Source: SourceSPIFFE, SPIFFE: config.SPIFFE{Socket: socket}})
+	require.NoError(t, err)
+
+	subject := digest.FromString("bootstrap")
+	identity, err := provider.Identity(context.Background(), subject)
+	require.NoError(t, err)
+	require.Equal(t, "spiffe://example.org/nydus-cli", identity.ID)
+
+	annotations, err := Annotate(nil, identity)
+	require.NoError(t, err)
+	parsed, err := Parse(annotations)
+	require.NoError(t, err)
+	require.NoError(t, Verify(parsed, subject, bundle))
+
+	// The identity attests another image.
+	require.Error(t, Verify(parsed, digest.FromString("other"), bundle))
+
+	// The subject is tampered.
+	tampered := *parsed
+	tampered.Subject = digest.FromString("other")
+	require.ErrorContains(t, Verify(&tampered, tampered.Subject, bundle), "verify signature")
+
+	// The svid is issued by an untrusted authority.
+	_, untrusted := newSVID(t, t.TempDir(), "spiffe://example.org/nydus-cli")
+	require.ErrorContains(t, Verify(parsed, subject, untrusted), "verify svid")
+
+	// The Workload API is not serving.
+	provider, err = NewProvider(&config.Identity{Source: SourceSPIFFE, SPIFFE: config.SPIFFE{Socket: "unix://" + filepath.Join(dir, "missing.sock")}})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = provider.Identity(ctx, subject)
+	require.ErrorContains(t, err, "fetch svid")
+
+	t.Setenv("SPIFFE_ENDPOINT_SOCKET", "")
+	_, err = NewProvider(&config.Identity{Source: SourceSPIFFE})
+	require.Error(t, err)
+	_, err = NewProvider(&config.Identity{Source: SourceSPIFFE, SPIFFE: config.SPIFFE{Socket: "/run/spire/agent.sock"}})
+	require.Error(t, err)
+}
+
+func TestAliyun(t *testing.T) {
+	var audience string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/latest/dynamic/instance-identity/document":
+			_, _ = w.Write([]byte(`{"instance-id":"i-test","region-id":"cn-hangzhou"}`))
+		case "/latest/dynamic/instance-identity/pkcs7":
+			audience = req.URL.Query().Get("audience")
+			_, _ = w.Write([]byte("signature"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewProvider(&config.Identity{Source: SourceAliyun, Aliyun: config.Aliyun{Endpoint: server.URL}})
+	require.NoError(t, err)
+	subject := digest.FromString("bootstrap")
+	identity, err := provider.Identity(context.Background(), subject)
+	require.NoError(t, err)
+	require.Equal(t, "i-test", identity.ID)
+	require.Equal(t, "signature", identity.Signature)
+	require.Equal(t, string(payload(subject, identity.Time)), audience)
+
+	require.False(t, Verifiable(identity.Source))
+	require.ErrorContains(t, Verify(identity, subject, ""), "not supported")
+}
+
+func TestNoIdentity(t *testing.T) {
+	provider, err := NewProvider(&config.Identity{})
+	require.NoError(t, err)
+	require.Nil(t, provider)
+
+	parsed, err := Parse(map[string]string{})
+	require.NoError(t, err)
+	require.Nil(t, parsed)
+
+	_, err = NewProvider(&config.Identity{Source: "gcp"})
+	require.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package identity
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+const spiffeFetchTimeout = 10 * time.Second
+
+// spiffeProvider signs with the X.509-SVID fetched from the SPIFFE Workload
+// API, e.g. the socket of SPIRE agent.
+type spiffeProvider struct {
+	socket string
+}
+
+func newSPIFFEProvider(cfg *config.SPIFFE) (*spiffeProvider, error) {
+	socket := cfg.Socket
+	if socket == "" {
+		var ok bool
+		if socket, ok = workloadapi.GetDefaultAddress(); !ok {
+			return nil, fmt.Errorf("spiffe `socket` field is required unless env SPIFFE_ENDPOINT_SOCKET is set")
+		}
+	}
+	if err := workloadapi.ValidateAddress(socket); err != nil {
+		return nil, errors.Wrapf(err, "invalid spiffe socket %s", socket)
+	}
+	return &spiffeProvider{socket: socket}, nil
+}
+
+func (p *spiffeProvider) Identity(ctx context.Context, subject digest.Digest) (*Identity, error) {
+	// Fetch on each call as the SVID is rotated by Workload API.
+	ctx, cancel := context.WithTimeout(ctx, spiffeFetchTimeout)
+	defer cancel()
+	x509Context, err := workloadapi.FetchX509Context(ctx, workloadapi.WithAddr(p.socket))
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch svid from spiffe workload api")
+	}
+	svid := x509Context.DefaultSVID()
+	bundle, err := x509Context.Bundles.GetX509BundleForTrustDomain(svid.ID.TrustDomain())
+	if err != nil {
+		return nil, errors.Wrap(err, "get trust bundle of svid")
+	}
+	roots := x509.NewCertPool()
+	for _, authority := range bundle.X509Authorities() {
+		roots.AddCert(authority)
+	}
+	certificates := []byte{}
+	for _, cert := range svid.Certificates {
+		certificates = append(certificates, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	identity := &Identity{
+		Source:       SourceSPIFFE,
+		Subject:      subject,
+		Time:         now,
+		Certificates: string(certificates),
+	}
+	// Verify before signing, an expired or untrusted svid is useless.
+	id, err := verifySVID(identity.Certificates, roots, now)
+	if err != nil {
+		return nil, err
+	}
+	identity.ID = id
+
+	signature, err := sign(svid.PrivateKey, payload(subject, now))
+	if err != nil {
+		return nil, errors.Wrap(err, "sign identity")
+	}
+	identity.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	return identity, nil
+}
+
+func sign(signer crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	hashed := sha256.Sum256(data)
+	return signer.Sign(rand.Reader, hashed[:], crypto.SHA256)
+}
+
+func verifySignature(cert *x509.Certificate, data, signature []byte) error {
+	hashed := sha256.Sum256(data)
+	switch key := cert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed[:], signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", key)
+	}
+	return nil
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certs, nil
+}
+
+// loadBundle returns the CA certificates of trust bundle in file `bundle`.
+func loadBundle(bundle string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "read trust bundle")
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in trust bundle %s", bundle)
+	}
+	return roots, nil
+}
+
+// verifySVID verifies the SVID chain against trust bundle `roots` at time
+// `t`, and returns its SPIFFE ID.
+func verifySVID(certificates string, roots *x509.CertPool, t time.Time) (string, error) {
+	certs, err := parseCertificates([]byte(certificates))
+	if err != nil {
+		return "", errors.Wrap(err, "parse svid")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := certs[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return "", errors.Wrap(err, "verify svid")
+	}
+	if len(leaf.URIs) != 1 || leaf.URIs[0].Scheme != "spiffe" {
+		return "", fmt.Errorf("svid has no spiffe id")
+	}
+
+	return leaf.URIs[0].String(), nil
+}
+
+func verifySPIFFE(identity *Identity, bundle string) error {
+	roots, err := loadBundle(bundle)
+	if err != nil {
+		return err
+	}
+	id, err := verifySVID(identity.Certificates, roots, identity.Time)
+	if err != nil {
+		return err
+	}
+	if id != identity.ID {
+		return fmt.Errorf("spiffe id %s mismatches svid %s", identity.ID, id)
+	}
+	certs, err := parseCertificates([]byte(identity.Certificates))
+	if err != nil {
+		return errors.Wrap(err, "parse svid")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(identity.Signature))
+	if err != nil {
+		return errors.Wrap(err, "decode signature")
+	}
+	if err := verifySignature(certs[0], payload(identity.Subject, identity.Time), signature); err != nil {
+		return errors.Wrap(err, "verify signature")
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Package layout reads and writes the images in an OCI image layout
+// directory, see
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md.
+package layout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	blobsDir  = "blobs"
+	indexFile = "index.json"
+)
+
+// Layout is an OCI image layout directory.
+type Layout struct {
+	dir string
+}
+
+// Create creates the layout in `dir` if not exists, the existing layout
+// is opened to add more images.
+func Create(dir string) (*Layout, error) {
+	if err := os.MkdirAll(filepath.Join(dir, blobsDir), 0755); err != nil {
+		return nil, errors.Wrap(err, "create blobs dir")
+	}
+	layout := &Layout{dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, ocispec.ImageLayoutFile)); err == nil {
+		return Open(dir)
+	}
+	data, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), data, 0644); err != nil {
+		return nil, errors.Wrap(err, "write oci-layout")
+	}
+	if err := layout.WriteIndex(&ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}, MediaType: ocispec.MediaTypeImageIndex}); err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
+
+// Open opens the layout in `dir`.
+func Open(dir string) (*Layout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ocispec.ImageLayoutFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci-layout")
+	}
+	var imageLayout ocispec.ImageLayout
+	if err := json.Unmarshal(data, &imageLayout); err != nil {
+		return nil, errors.Wrap(err, "unmarshal oci-layout")
+	}
+	if imageLayout.Version != ocispec.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version %s", imageLayout.Version)
+	}
+	return &Layout{dir: dir}, nil
+}
+
+// BlobPath returns the path of blob `dgst`.
+func (l *Layout) BlobPath(dgst digest.Digest) string {
+	return filepath.Join(l.dir, blobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// WriteBlob writes blob `dgst` from `reader`, which is skipped if the blob
+// exists. The blob is written to a temp file renamed once the digest is
+// verified, so the layout never has a partial blob. The size is checked
+// only if `size` isn't negative.
+func (l *Layout) WriteBlob(dgst digest.Digest, size int64, reader io.Reader) error {
+	if err := dgst.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid digest %s", dgst)
+	}
+	path := l.BlobPath(dgst)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "create blobs dir")
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+dgst.Encoded()+"-")
+	if err != nil {
+		return errors.Wrap(err, "create temp blob")
+	}
+	defer os.Remove(temp.Name())
+
+	digester := dgst.Algorithm().Digester()
+	written, err := io.Copy(io.MultiWriter(temp, digester.Hash()), reader)
+	if err != nil {
+		temp.Close()
+		return errors.Wrap(err, "write blob")
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "close temp blob")
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("size mismatch of blob %s: %d != %d", dgst, written, size)
+	}
+	if digester.Digest() != dgst {
+		return fmt.Errorf("digest mismatch: %s != %s", digester.Digest(), dgst)
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return errors.Wrap(err, "chmod blob")
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// ReadBlob reads the whole blob of `desc` and verifies its digest.
+func (l *Layout) ReadBlob(desc ocispec.Descriptor) ([]byte, error) {
+	data, err := os.ReadFile(l.BlobPath(desc.Digest))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read blob %s", desc.Digest)
+	}
+	if dgst := desc.Digest.Algorithm().FromBytes(data); dgst != desc.Digest {
+		return nil, fmt.Errorf("digest mismatch: %s != %s", dgst, desc.Digest)
+	}
+	return data, nil
+}
+
+// VerifyBlob checks the digest of blob `dgst` and returns its size.
+func (l *Layout) VerifyBlob(dgst digest.Digest) (int64, error) {
+	file, err := os.Open(l.BlobPath(dgst))
+	if err != nil {
+		return 0, errors.Wrapf(err, "open blob %s", dgst)
+	}
+	defer file.Close()
+	digester := dgst.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), file)
+	if err != nil {
+		return 0, errors.Wrapf(err, "read blob %s", dgst)
+	}
+	if digester.Digest() != dgst {
+		return 0, fmt.Errorf("digest mismatch: %s != %s", digester.Digest(), dgst)
+	}
+	return size, nil
+}
+
+// ReadIndex reads index.json of layout.
+func (l *Layout) ReadIndex() (*ocispec.Index, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, indexFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read index.json")
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal index.json")
+	}
+	return &index, nil
+}
+
+// WriteIndex writes index.json of layout.
+func (l *Layout) WriteIndex(index *ocispec.Index) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal index.json")
+	}
+	temp := filepath.Join(l.dir, "."+indexFile)
+	if err := os.WriteFile(temp, data, 0644); err != nil {
+		return errors.Wrap(err, "write index.json")
+	}
+	return os.Rename(temp, filepath.Join(l.dir, indexFile))
+}
+
+// AddManifest adds manifest `desc` into index.json named `name`, which
+// replaces the manifest of the same name.
+func (l *Layout) AddManifest(desc ocispec.Descriptor, name string) error {
+	index, err := l.ReadIndex()
+	if err != nil {
+		return err
+	}
+	desc.Annotations = map[string]string{ocispec.AnnotationRefName: name}
+	manifests := []ocispec.Descriptor{}
+	for _, manifest := range index.Manifests {
+		if manifest.Annotations[ocispec.AnnotationRefName] != name {
+			manifests = append(manifests, manifest)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+	return l.WriteIndex(index)
+}
+
+// FindManifest returns the manifest named `name` in index.json, or the
+// only manifest if `name` is empty.
+func (l *Layout) FindManifest(name string) (*ocispec.Descriptor, error) {
+	index, err := l.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(index.Manifests) != 1 {
+			names := []string{}
+			for _, manifest := range index.Manifests {
+				names = append(names, manifest.Annotations[ocispec.AnnotationRefName])
+			}
+			return nil, fmt.Errorf("layout has %d manifests %v, the name is required", len(index.Manifests), names)
+		}
+		return &index.Manifests[0], nil
+	}
+	for idx := range index.Manifests {
+		if index.Manifests[idx].Annotations[ocispec.AnnotationRefName] == name {
+			return &index.Manifests[idx], nil
+		}
+	}
+	return nil, fmt.Errorf("not found manifest %s in layout", name)
+}
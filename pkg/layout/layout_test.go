@@ -0,0 +1,76 @@
+package layout
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlob(t *testing.T) {
+	layout, err := Create(t.TempDir())
+	require.NoError(t, err)
+
+	data := []byte("nydus blob")
+	dgst := digest.FromBytes(data)
+	require.NoError(t, layout.WriteBlob(dgst, int64(len(data)), bytes.NewReader(data)))
+	// The existing blob is skipped.
+	require.NoError(t, layout.WriteBlob(dgst, int64(len(data)), bytes.NewReader(nil)))
+
+	read, err := layout.ReadBlob(ocispec.Descriptor{Digest: dgst})
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+	size, err := layout.VerifyBlob(dgst)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	other := []byte("other blob")
+	require.Error(t, layout.WriteBlob(digest.FromString("mismatch"), -1, bytes.NewReader(other)))
+	require.Error(t, layout.WriteBlob(digest.FromBytes(other), 1, bytes.NewReader(other)))
+	_, err = os.Stat(layout.BlobPath(digest.FromBytes(other)))
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, os.WriteFile(layout.BlobPath(dgst), other, 0644))
+	_, err = layout.VerifyBlob(dgst)
+	require.Error(t, err)
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	layout, err := Create(dir)
+	require.NoError(t, err)
+
+	_, err = layout.FindManifest("")
+	require.Error(t, err)
+
+	first := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("first")}
+	require.NoError(t, layout.AddManifest(first, "registry/repo:first"))
+	found, err := layout.FindManifest("")
+	require.NoError(t, err)
+	require.Equal(t, first.Digest, found.Digest)
+
+	second := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("second")}
+	layout, err = Create(dir)
+	require.NoError(t, err)
+	require.NoError(t, layout.AddManifest(second, "registry/repo:second"))
+	_, err = layout.FindManifest("")
+	require.Error(t, err)
+	found, err = layout.FindManifest("registry/repo:second")
+	require.NoError(t, err)
+	require.Equal(t, second.Digest, found.Digest)
+	_, err = layout.FindManifest("registry/repo:third")
+	require.Error(t, err)
+
+	// The manifest of the same name is replaced.
+	replaced := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("replaced")}
+	require.NoError(t, layout.AddManifest(replaced, "registry/repo:first"))
+	index, err := layout.ReadIndex()
+	require.NoError(t, err)
+	require.Len(t, index.Manifests, 2)
+	found, err = layout.FindManifest("registry/repo:first")
+	require.NoError(t, err)
+	require.Equal(t, replaced.Digest, found.Digest)
+}
@@ -0,0 +1,354 @@
+// Package metrics records the results and latency of commit stages and
+// commits in Prometheus text format. The metrics are accumulated in a
+// file across runs, which is exposed by the textfile collector of node
+// exporter, so the availability of one-shot commits can be tracked.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+const (
+	stageTotal      = "nydus_cli_stage_total"
+	stageDuration   = "nydus_cli_stage_duration_seconds"
+	commitTotal     = "nydus_cli_commit_total"
+	commitGoodTotal = "nydus_cli_commit_good_total"
+	commitDuration  = "nydus_cli_commit_duration_seconds"
+	commitSLO       = "nydus_cli_commit_slo"
+	commitObjective = "nydus_cli_commit_slo_objective"
+)
+
+// Buckets of duration histograms in seconds, commits of large containers
+// take up to tens of minutes.
+var Buckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+type family struct {
+	name string
+	typ  string
+	help string
+}
+
+// families are written in order, the derived gauges are not accumulated
+// but computed from the counters on writing.
+var families = []family{
+	{stageTotal, "counter", "Count of commit stages by result."},
+	{stageDuration, "histogram", "Duration of commit stages."},
+	{commitTotal, "counter", "Count of commits by result."},
+	{commitGoodTotal, "counter", "Count of commits succeeded within the latency objective."},
+	{commitDuration, "histogram", "Duration of commits."},
+	{commitSLO, "gauge", "Ratio of good commits to all commits."},
+	{commitObjective, "gauge", "Objective of the ratio of good commits."},
+}
+
+// Labels tag the metrics of a commit.
+type Labels struct {
+	// Registry is the hosts of target images, joined by "," if multiple.
+	Registry string
+	// Backend is the type of blob backend, e.g. "registry" or "oss".
+	Backend string
+}
+
+func (l Labels) pairs() []label {
+	return []label{{"registry", l.Registry}, {"backend", l.Backend}}
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type series struct {
+	name   string
+	labels []label
+	value  float64
+}
+
+func (s *series) key() string {
+	return s.name + formatLabels(s.labels)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := []string{}
+	for _, l := range labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l.name, labelEscaper.Replace(l.value)))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Recorder records the metrics of commits in memory until flushed into
+// file.
+type Recorder struct {
+	mutex sync.Mutex
+	path  string
+	// Objective is the target ratio of good commits, not written if 0.
+	objective float64
+	// Latency the good commits finish within, not limited if 0.
+	latency time.Duration
+	series  map[string]*series
+	// Keys of series in the order added, the buckets of a histogram are
+	// always added in order.
+	keys []string
+}
+
+// NewRecorder returns the recorder flushing metrics into file `path`.
+func NewRecorder(path string, objective float64, latency time.Duration) *Recorder {
+	return &Recorder{
+		path:      path,
+		objective: objective,
+		latency:   latency,
+		series:    map[string]*series{},
+	}
+}
+
+func (r *Recorder) add(name string, labels []label, value float64) {
+	s := &series{name: name, labels: labels}
+	if existing, ok := r.series[s.key()]; ok {
+		existing.value += value
+		return
+	}
+	s.value = value
+	r.series[s.key()] = s
+	r.keys = append(r.keys, s.key())
+}
+
+func (r *Recorder) observe(name string, labels []label, elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	for _, bucket := range Buckets {
+		value := 0.0
+		if seconds <= bucket {
+			value = 1
+		}
+		le := label{"le", formatFloat(bucket)}
+		r.add(name+"_bucket", append(append([]label{}, labels...), le), value)
+	}
+	r.add(name+"_bucket", append(append([]label{}, labels...), label{"le", "+Inf"}), 1)
+	r.add(name+"_sum", labels, seconds)
+	r.add(name+"_count", labels, 1)
+}
+
+func result(err error) string {
+	if err != nil {
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// ObserveStage records the result and duration of commit stage.
+func (r *Recorder) ObserveStage(labels Labels, stage string, elapsed time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stageLabels := append([]label{{"stage", stage}}, labels.pairs()...)
+	r.add(stageTotal, append(append([]label{}, stageLabels...), label{"result", result(err)}), 1)
+	r.observe(stageDuration, stageLabels, elapsed)
+}
+
+// ObserveCommit records the result and duration of commit, the commit is
+// good if it succeeded within the latency objective.
+func (r *Recorder) ObserveCommit(labels Labels, elapsed time.Duration, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.add(commitTotal, append(labels.pairs(), label{"result", result(err)}), 1)
+	good := 0.0
+	if err == nil && (r.latency == 0 || elapsed <= r.latency) {
+		good = 1
+	}
+	r.add(commitGoodTotal, labels.pairs(), good)
+	r.observe(commitDuration, labels.pairs(), elapsed)
+}
+
+// Flush adds the recorded metrics to the ones in file, and resets the
+// recorder. The file is locked against concurrent runs, and replaced
+// atomically so it's never collected half written.
+func (r *Recorder) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	lock, err := os.OpenFile(r.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open metrics lock file")
+	}
+	defer lock.Close()
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Wrap(err, "lock metrics file")
+	}
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	merged := NewRecorder(r.path, r.objective, r.latency)
+	file, err := os.Open(r.path)
+	if err == nil {
+		err = merged.read(file)
+		file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "read metrics file %s", r.path)
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "open metrics file")
+	}
+	for _, key := range r.keys {
+		s := r.series[key]
+		merged.add(s.name, s.labels, s.value)
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(r.path), ".nydus-cli-metrics-")
+	if err != nil {
+		return errors.Wrap(err, "create metrics file")
+	}
+	defer os.Remove(temp.Name())
+	if err := merged.Write(temp); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "write metrics file")
+	}
+	if err := temp.Chmod(0644); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "chmod metrics file")
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "close metrics file")
+	}
+	if err := os.Rename(temp.Name(), r.path); err != nil {
+		return errors.Wrap(err, "replace metrics file")
+	}
+
+	r.series = map[string]*series{}
+	r.keys = nil
+	return nil
+}
+
+// read adds the accumulated series in Prometheus text format written by
+// Write, the derived gauges are skipped.
+func (r *Recorder) read(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseSeries(line)
+		if err != nil {
+			return err
+		}
+		if s.name == commitSLO || s.name == commitObjective {
+			continue
+		}
+		r.add(s.name, s.labels, s.value)
+	}
+	return scanner.Err()
+}
+
+// parseSeries parses a line of series, e.g. `name{a="x",b="y"} 1`.
+func parseSeries(line string) (*series, error) {
+	idx := strings.LastIndexByte(line, ' ')
+	if idx < 0 {
+		return nil, fmt.Errorf("invalid series %q", line)
+	}
+	value, err := strconv.ParseFloat(line[idx+1:], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value of series %q", line)
+	}
+	s := &series{value: value}
+	key := line[:idx]
+	open := strings.IndexByte(key, '{')
+	if open < 0 {
+		s.name = key
+		return s, nil
+	}
+	s.name = key[:open]
+	rest := strings.TrimSuffix(key[open+1:], "}")
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid labels of series %q", line)
+		}
+		name := rest[:eq]
+		quoted, err := strconv.QuotedPrefix(rest[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels of series %q", line)
+		}
+		value, _ := strconv.Unquote(quoted)
+		s.labels = append(s.labels, label{name, value})
+		rest = strings.TrimPrefix(rest[eq+1+len(quoted):], ",")
+	}
+	return s, nil
+}
+
+// sloSeries computes the ratio of good commits from the counters.
+func (r *Recorder) sloSeries() []*series {
+	derived := []*series{}
+	for _, key := range r.keys {
+		good := r.series[key]
+		if good.name != commitGoodTotal {
+			continue
+		}
+		total := 0.0
+		for _, res := range []string{ResultSuccess, ResultFailure} {
+			s := series{name: commitTotal, labels: append(append([]label{}, good.labels...), label{"result", res})}
+			if counted, ok := r.series[s.key()]; ok {
+				total += counted.value
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		derived = append(derived, &series{name: commitSLO, labels: good.labels, value: good.value / total})
+	}
+	if r.objective > 0 {
+		derived = append(derived, &series{name: commitObjective, value: r.objective})
+	}
+	return derived
+}
+
+// Write writes the metrics in Prometheus text format.
+func (r *Recorder) Write(writer io.Writer) error {
+	all := []*series{}
+	for _, key := range r.keys {
+		all = append(all, r.series[key])
+	}
+	all = append(all, r.sloSeries()...)
+
+	buf := bufio.NewWriter(writer)
+	for _, f := range families {
+		names := map[string]bool{f.name: true}
+		if f.typ == "histogram" {
+			names = map[string]bool{f.name + "_bucket": true, f.name + "_sum": true, f.name + "_count": true}
+		}
+		written := false
+		for _, s := range all {
+			if !names[s.name] {
+				continue
+			}
+			if !written {
+				fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+				written = true
+			}
+			fmt.Fprintf(buf, "%s %s\n", s.key(), formatFloat(s.value))
+		}
+	}
+	return buf.Flush()
+}
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nydus-cli.prom")
+	labels := Labels{Registry: "registry.example.com", Backend: "oss"}
+
+	recorder := NewRecorder(path, 0.99, time.Minute)
+	recorder.ObserveStage(labels, "pack", 2*time.Second, nil)
+	recorder.ObserveCommit(labels, 3*time.Second, nil)
+	require.NoError(t, recorder.Flush())
+
+	// Accumulated by another run.
+	recorder = NewRecorder(path, 0.99, time.Minute)
+	recorder.ObserveStage(labels, "pack", 10*time.Second, fmt.Errorf("failed"))
+	recorder.ObserveCommit(labels, 10*time.Second, fmt.Errorf("failed"))
+	recorder.ObserveCommit(labels, 2*time.Minute, nil)
+	require.NoError(t, recorder.Flush())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	text := string(data)
+	for _, expected := range []string{
+		`nydus_cli_stage_total{stage="pack",registry="registry.example.com",backend="oss",result="success"} 1`,
+		`nydus_cli_stage_total{stage="pack",registry="registry.example.com",backend="oss",result="failure"} 1`,
+		`nydus_cli_stage_duration_seconds_bucket{stage="pack",registry="registry.example.com",backend="oss",le="5"} 1`,
+		`nydus_cli_stage_duration_seconds_bucket{stage="pack",registry="registry.example.com",backend="oss",le="15"} 2`,
+		`nydus_cli_stage_duration_seconds_count{stage="pack",registry="registry.example.com",backend="oss"} 2`,
+		`nydus_cli_stage_duration_seconds_sum{stage="pack",registry="registry.example.com",backend="oss"} 12`,
+		`nydus_cli_commit_total{registry="registry.example.com",backend="oss",result="success"} 2`,
+		`nydus_cli_commit_total{registry="registry.example.com",backend="oss",result="failure"} 1`,
+		// The slow commit succeeded but isn't good.
+		`nydus_cli_commit_good_total{registry="registry.example.com",backend="oss"} 1`,
+		`nydus_cli_commit_slo{registry="registry.example.com",backend="oss"} 0.3333333333333333`,
+		`nydus_cli_commit_slo_objective 0.99`,
+		"# TYPE nydus_cli_commit_duration_seconds histogram",
+	} {
+		require.Contains(t, text, expected+"\n")
+	}
+	require.Equal(t, 1, strings.Count(text, "nydus_cli_commit_slo{"))
+
+	// Nothing is added by flushing again.
+	require.NoError(t, recorder.Flush())
+	again, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, text, string(again))
+}
+
+func TestParseSeries(t *testing.T) {
+	s, err := parseSeries(`name{a="x,\"y\"",b="\\z"} 1.5`)
+	require.NoError(t, err)
+	require.Equal(t, "name", s.name)
+	require.Equal(t, []label{{"a", `x,"y"`}, {"b", `\z`}}, s.labels)
+	require.Equal(t, 1.5, s.value)
+	require.Equal(t, `name{a="x,\"y\"",b="\\z"}`, s.key())
+
+	s, err = parseSeries("name 2")
+	require.NoError(t, err)
+	require.Equal(t, "name", s.name)
+	require.Empty(t, s.labels)
+
+	_, err = parseSeries("name")
+	require.Error(t, err)
+	_, err = parseSeries(`name{a=x} 1`)
+	require.Error(t, err)
+
+	var buf bytes.Buffer
+	recorder := NewRecorder("", 0, 0)
+	recorder.add(commitTotal, []label{{"registry", "multi\nline"}}, 1)
+	require.NoError(t, recorder.Write(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	s, err = parseSeries(lines[2])
+	require.NoError(t, err)
+	require.Equal(t, []label{{"registry", "multi\nline"}}, s.labels)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package mountns runs the process in a private mount namespace, so the
+// mounts made by the process are never seen by host, and are released by
+// the kernel with the namespace once the process exits, even if killed.
+package mountns
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// envEntered marks the process run by Reexec.
+const envEntered = "_NYDUS_CLI_MOUNT_NS"
+
+// Reexec runs the command line of process again in a new mount namespace
+// with the stdio and environment inherited, and returns the exit code of
+// it. The child is killed if the process dies, and SIGTERM and SIGHUP are
+// forwarded to it. SIGINT isn't forwarded, the child in the same process
+// group gets it from terminal already.
+func Reexec() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, errors.Wrap(err, "get executable")
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envEntered+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNS,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	// The death signal is sent when the thread which started the child
+	// exits, not the process.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := cmd.Start(); err != nil {
+		return 0, errors.Wrap(err, "start in new mount namespace")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig != syscall.SIGINT {
+					_ = cmd.Process.Signal(sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		exitErr := &exec.ExitError{}
+		if errors.As(err, &exitErr) {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return 128 + int(status.Signal()), nil
+			}
+			return exitErr.ExitCode(), nil
+		}
+		return 0, errors.Wrap(err, "wait child in new mount namespace")
+	}
+	return 0, nil
+}
+
+// Enter reports whether the process is run by Reexec, if so the mounts in
+// the namespace are made slaves of host's, the mounts of host still
+// propagate into the namespace but not in reverse. It must be called
+// before any mount is made.
+func Enter() (bool, error) {
+	if os.Getenv(envEntered) == "" {
+		return false, nil
+	}
+	if err := unix.Mount("", "/", "", unix.MS_SLAVE|unix.MS_REC, ""); err != nil {
+		return true, errors.Wrap(err, "make mounts slave")
+	}
+	return true, nil
+}
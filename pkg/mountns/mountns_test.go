@@ -0,0 +1,55 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package mountns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestReexec(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("requires root")
+	}
+
+	// The test binary is run again by Reexec, which mounts a tmpfs at
+	// the target and exits with the count of files seen in it.
+	if target := os.Getenv("MOUNTNS_TEST_TARGET"); target != "" {
+		entered, err := Enter()
+		if !entered || err != nil {
+			os.Exit(100)
+		}
+		if err := unix.Mount("tmpfs", target, "tmpfs", 0, ""); err != nil {
+			os.Exit(101)
+		}
+		if err := os.WriteFile(filepath.Join(target, "file"), nil, 0644); err != nil {
+			os.Exit(102)
+		}
+		entries, _ := os.ReadDir(target)
+		os.Exit(len(entries))
+	}
+
+	entered, err := Enter()
+	require.NoError(t, err)
+	require.False(t, entered)
+
+	target := t.TempDir()
+	t.Setenv("MOUNTNS_TEST_TARGET", target)
+	code, err := Reexec()
+	require.NoError(t, err)
+	require.Equal(t, 1, code)
+
+	// Neither the mount nor the file written in it is seen by host.
+	mountInfo, err := os.ReadFile("/proc/self/mountinfo")
+	require.NoError(t, err)
+	require.NotContains(t, string(mountInfo), " "+target+" ")
+	entries, err := os.ReadDir(target)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The names of hooks, passed to hooks in env NYDUS_CLI_HOOK.
+const (
+	HookPreCommit = "pre_commit"
+	HookPostPush  = "post_push"
+	HookOnFailure = "on_failure"
+)
+
+// hookWaitDelay bounds the wait for the output of hook after it exited or
+// was killed, e.g. if its background children keep the output open.
+const hookWaitDelay = 10 * time.Second
+
+// RunHook runs executable `path` as hook `hook` with `event` in json on
+// stdin, the output of hook is logged. The hook is killed on `timeout`.
+func RunHook(ctx context.Context, hook, path string, timeout time.Duration, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshal event")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	logger := logrus.WithField("hook", hook)
+	output := logger.Writer()
+	defer output.Close()
+
+	logger.Infof("running hook %s", path)
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), "NYDUS_CLI_HOOK="+hook, "NYDUS_CLI_EVENT="+event.Type)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.WaitDelay = hookWaitDelay
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errors.Wrapf(err, "run hook %s: timed out after %s", hook, timeout)
+		}
+		return errors.Wrapf(err, "run hook %s", hook)
+	}
+	return nil
+}
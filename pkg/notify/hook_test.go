@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeHook(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+	return path
+}
+
+func TestRunHook(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	path := writeHook(t, `cat > `+out+`; echo "$NYDUS_CLI_HOOK $NYDUS_CLI_EVENT" >> `+out+".env\n")
+	event := &Event{Type: EventCommitSucceeded, Container: "docker://abc", Targets: []Target{{Ref: "localhost:5000/app:v1"}}}
+	require.NoError(t, RunHook(context.Background(), HookPostPush, path, time.Minute, event))
+
+	payload, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var got Event
+	require.NoError(t, json.Unmarshal(payload, &got))
+	require.Equal(t, *event, got)
+	env, err := os.ReadFile(out + ".env")
+	require.NoError(t, err)
+	require.Equal(t, "post_push commit.succeeded\n", string(env))
+}
+
+func TestRunHookFailure(t *testing.T) {
+	event := &Event{Type: EventCommitStarted}
+	path := writeHook(t, "echo 'maintenance window' >&2; exit 3\n")
+	require.ErrorContains(t, RunHook(context.Background(), HookPreCommit, path, time.Minute, event), "run hook pre_commit: exit status 3")
+
+	path = writeHook(t, "exec sleep 10\n")
+	start := time.Now()
+	require.ErrorContains(t, RunHook(context.Background(), HookPreCommit, path, 100*time.Millisecond, event), "timed out after 100ms")
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	require.Error(t, RunHook(context.Background(), HookPreCommit, filepath.Join(t.TempDir(), "missing"), time.Minute, event))
+}
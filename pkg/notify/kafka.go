@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+const kafkaContentType = "application/vnd.kafka.json.v2+json"
+
+// kafkaPublisher produces events by the v2 API of Kafka REST proxy, see
+// https://docs.confluent.io/platform/current/kafka-rest/api.html. The
+// events are keyed by container, so the events of a container are in
+// order.
+type kafkaPublisher struct {
+	cfg     *config.Kafka
+	events  []string
+	timeout time.Duration
+	url     string
+	client  *http.Client
+}
+
+func newKafkaPublisher(cfg *config.Kafka, events []string, timeout time.Duration) (*kafkaPublisher, error) {
+	u, err := url.Parse(cfg.RESTProxy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse rest proxy %s", cfg.RESTProxy)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid rest proxy %s, must be http or https", cfg.RESTProxy)
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("no topic specified")
+	}
+	return &kafkaPublisher{
+		cfg:     cfg,
+		events:  events,
+		timeout: timeout,
+		url:     strings.TrimSuffix(cfg.RESTProxy, "/") + "/topics/" + url.PathEscape(cfg.Topic),
+		client:  &http.Client{},
+	}, nil
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value *Event `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaOffsets is the response of producing, each offset has an error if
+// the record is rejected.
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (p *kafkaPublisher) Notify(ctx context.Context, event *Event) error {
+	if !accepts(p.events, event.Type) {
+		return nil
+	}
+	payload, err := json.Marshal(kafkaRecords{
+		Records: []kafkaRecord{{Key: event.Container, Value: event}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshal event")
+	}
+	return retry(ctx, func() (bool, error) {
+		return p.produce(ctx, payload)
+	})
+}
+
+// produce posts the records once, and returns whether the failure is worth
+// retrying.
+func (p *kafkaPublisher) produce(ctx context.Context, payload []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, errors.Wrap(err, "create request")
+	}
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, errors.Wrapf(err, "post %s", p.url)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return true, errors.Wrapf(err, "read response of %s", p.url)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("post %s: unexpected status %s: %s", p.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	var offsets kafkaOffsets
+	if err := json.Unmarshal(body, &offsets); err != nil {
+		return false, errors.Wrapf(err, "parse response of %s", p.url)
+	}
+	for _, offset := range offsets.Offsets {
+		if offset.ErrorCode != nil || offset.Error != "" {
+			// The errors of records are mostly transient, e.g. the leader
+			// of partition is not available.
+			return true, fmt.Errorf("produce to topic %s: %s", p.cfg.Topic, offset.Error)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+const defaultNATSSubject = "nydus"
+
+// natsPublisher publishes events by the text protocol of NATS core, see
+// https://docs.nats.io/reference/reference-protocols/nats-protocol. The
+// events are rare, so each one is published on a new connection.
+type natsPublisher struct {
+	cfg     *config.NATS
+	events  []string
+	timeout time.Duration
+	addr    string
+	// tls is set for `tls://` URLs, or if the server requires TLS.
+	tls     bool
+	subject string
+}
+
+func newNATSPublisher(cfg *config.NATS, events []string, timeout time.Duration) (*natsPublisher, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse url %s", cfg.URL)
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %s, expected format is nats://host:port or tls://host:port", cfg.URL)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := cfg.Subject
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+	if strings.ContainsAny(subject, " \t\r\n*>") {
+		return nil, fmt.Errorf("invalid subject %q", subject)
+	}
+	return &natsPublisher{
+		cfg:     cfg,
+		events:  events,
+		timeout: timeout,
+		addr:    addr,
+		tls:     u.Scheme == "tls",
+		subject: subject,
+	}, nil
+}
+
+// natsInfo is the INFO sent by server on connection.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// natsConnect is the CONNECT sent by client.
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+func (p *natsPublisher) Notify(ctx context.Context, event *Event) error {
+	if !accepts(p.events, event.Type) {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshal event")
+	}
+	subject := p.subject + "." + event.Type
+	return retry(ctx, func() (bool, error) {
+		return p.publish(ctx, subject, payload)
+	})
+}
+
+// publish publishes `payload` once, and returns whether the failure is
+// worth retrying. The PING after PUB is answered by PONG once the server
+// has processed the PUB, or by -ERR if it's rejected.
+func (p *natsPublisher) publish(ctx context.Context, subject string, payload []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return true, errors.Wrapf(err, "connect nats %s", p.addr)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return true, err
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return true, errors.Wrap(err, "read nats info")
+	}
+	var info natsInfo
+	infoJSON, ok := strings.CutPrefix(strings.TrimSpace(line), "INFO ")
+	if !ok {
+		return false, fmt.Errorf("unexpected nats greeting %q", strings.TrimSpace(line))
+	}
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		return false, errors.Wrap(err, "parse nats info")
+	}
+	rw := net.Conn(conn)
+	if p.tls || info.TLSRequired {
+		host, _, _ := net.SplitHostPort(p.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return true, errors.Wrap(err, "nats tls handshake")
+		}
+		rw = tlsConn
+		reader = bufio.NewReader(tlsConn)
+	}
+
+	connect, err := json.Marshal(natsConnect{
+		Name:  "nydus-cli",
+		Lang:  "go",
+		User:  p.cfg.Username,
+		Pass:  p.cfg.Password,
+		Token: p.cfg.Token,
+	})
+	if err != nil {
+		return false, err
+	}
+	request := fmt.Sprintf("CONNECT %s\r\nPUB %s %d\r\n%s\r\nPING\r\n", connect, subject, len(payload), payload)
+	if _, err := rw.Write([]byte(request)); err != nil {
+		return true, errors.Wrap(err, "write nats")
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return true, errors.Wrap(err, "read nats")
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return false, nil
+		case strings.HasPrefix(line, "-ERR"):
+			return false, fmt.Errorf("nats: %s", line)
+		case line == "PING":
+			if _, err := rw.Write([]byte("PONG\r\n")); err != nil {
+				return true, errors.Wrap(err, "write nats")
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package notify delivers the events of commits to downstream systems,
+// e.g. deploy pipelines and inventory databases, so they learn about the
+// committed images without polling registries.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// The types of events.
+const (
+	EventCommitStarted   = "commit.started"
+	EventCommitSucceeded = "commit.succeeded"
+	EventCommitFailed    = "commit.failed"
+)
+
+// EventTypes are all types of events.
+var EventTypes = []string{EventCommitStarted, EventCommitSucceeded, EventCommitFailed}
+
+// Target is an image which the container is committed to.
+type Target struct {
+	Ref    string `json:"ref"`
+	Format string `json:"format"`
+	// Digest is the manifest digest of pushed image, set only if the commit
+	// succeeded.
+	Digest digest.Digest `json:"digest,omitempty"`
+}
+
+// Event is the payload of notifications.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	// Container is the committed container with engine type, e.g.
+	// `docker://<id>`, and ContainerName is its name in pod if any.
+	Container     string `json:"container"`
+	ContainerName string `json:"container_name,omitempty"`
+	// Image is the image of container, set once the container is
+	// inspected.
+	Image   string   `json:"image,omitempty"`
+	Targets []Target `json:"targets"`
+	// DurationMs is the elapsed milliseconds of commit, and StagesMs are
+	// those of the finished stages, both are unset on start.
+	DurationMs int64            `json:"duration_ms,omitempty"`
+	StagesMs   map[string]int64 `json:"stages_ms,omitempty"`
+	// Error and ExitCode are set if the commit failed.
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// Notifier delivers events.
+type Notifier interface {
+	Notify(ctx context.Context, event *Event) error
+}
+
+// The types of publishers.
+const (
+	PublisherNATS  = "nats"
+	PublisherKafka = "kafka"
+)
+
+// NewPublisher returns the publisher configured by `cfg`, or nil if no
+// publisher is configured.
+func NewPublisher(cfg *config.Publisher) (Notifier, error) {
+	if cfg.Type == "" {
+		return nil, nil
+	}
+	if err := validateEvents(cfg.Events); err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Type {
+	case PublisherNATS:
+		return newNATSPublisher(&cfg.NATS, cfg.Events, timeout)
+	case PublisherKafka:
+		return newKafkaPublisher(&cfg.Kafka, cfg.Events, timeout)
+	default:
+		return nil, fmt.Errorf("invalid publisher type %s, must be one of %s and %s", cfg.Type, PublisherNATS, PublisherKafka)
+	}
+}
+
+// accepts checks whether `eventType` is one of `events`, all types are
+// accepted if `events` is empty.
+func accepts(events []string, eventType string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, event := range events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEvents checks that `events` are known types.
+func validateEvents(events []string) error {
+	for _, event := range events {
+		if !accepts(EventTypes, event) {
+			return fmt.Errorf("unknown event %s, must be one of %s", event, strings.Join(EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+const (
+	defaultTimeout = 10 * time.Second
+	attempts       = 3
+)
+
+// backoff is the delay before the n-th retry, replaced in tests.
+var backoff = func(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// parseTimeout parses the timeout of each delivery attempt, defaults to
+// 10s if empty.
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return defaultTimeout, nil
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse timeout")
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("timeout %s is not positive", timeout)
+	}
+	return duration, nil
+}
+
+// retry runs `deliver` until it succeeded, or it returned a failure not
+// worth retrying, or all attempts failed.
+func retry(ctx context.Context, deliver func() (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		retryable, err := deliver()
+		if err == nil || !retryable || attempt == attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// natsMessage is a message received by fakeNATS.
+type natsMessage struct {
+	connect string
+	subject string
+	payload []byte
+}
+
+// fakeNATS accepts connections and answers PING by PONG, or by -ERR if
+// `reject` is set.
+func fakeNATS(t *testing.T, reject bool) (string, <-chan natsMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan natsMessage, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"max_payload\":1048576}\r\n")
+				reader := bufio.NewReader(conn)
+				var message natsMessage
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch fields[0] {
+					case "CONNECT":
+						message.connect = strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+					case "PUB":
+						size, _ := strconv.Atoi(fields[2])
+						message.subject = fields[1]
+						message.payload = make([]byte, size+2)
+						io.ReadFull(reader, message.payload)
+						message.payload = message.payload[:size]
+					case "PING":
+						if reject {
+							fmt.Fprintf(conn, "-ERR 'Permissions Violation for Publish'\r\n")
+							return
+						}
+						messages <- message
+						fmt.Fprintf(conn, "PONG\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return "nats://" + listener.Addr().String(), messages
+}
+
+func TestNATSPublisher(t *testing.T) {
+	url, messages := fakeNATS(t, false)
+	publisher, err := NewPublisher(&config.Publisher{
+		Type:   PublisherNATS,
+		NATS:   config.NATS{URL: url, Subject: "fleet.nydus", Token: "token"},
+		Events: []string{EventCommitSucceeded, EventCommitFailed},
+	})
+	require.NoError(t, err)
+
+	event := &Event{Type: EventCommitSucceeded, Container: "docker://abc"}
+	require.NoError(t, publisher.Notify(context.Background(), event))
+	require.NoError(t, publisher.Notify(context.Background(), &Event{Type: EventCommitStarted}))
+
+	message := <-messages
+	require.Len(t, messages, 0)
+	require.Equal(t, "fleet.nydus.commit.succeeded", message.subject)
+	var connect natsConnect
+	require.NoError(t, json.Unmarshal([]byte(message.connect), &connect))
+	require.Equal(t, "token", connect.Token)
+	var got Event
+	require.NoError(t, json.Unmarshal(message.payload, &got))
+	require.Equal(t, *event, got)
+
+	url, _ = fakeNATS(t, true)
+	publisher, err = NewPublisher(&config.Publisher{Type: PublisherNATS, NATS: config.NATS{URL: url}})
+	require.NoError(t, err)
+	require.ErrorContains(t, publisher.Notify(context.Background(), event), "Permissions Violation")
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	defer noBackoff()()
+
+	var bodies [][]byte
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/topics/nydus-commits", r.URL.Path)
+		require.Equal(t, kafkaContentType, r.Header.Get("Content-Type"))
+		require.Equal(t, "Basic dXNlcjpwYXNz", r.Header.Get("Authorization"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, body)
+		if failures > 0 {
+			failures--
+			fmt.Fprint(w, `{"offsets":[{"partition":null,"offset":null,"error_code":50003,"error":"leader not available"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"offsets":[{"partition":0,"offset":42,"error_code":null,"error":null}]}`)
+	}))
+	defer server.Close()
+
+	publisher, err := NewPublisher(&config.Publisher{
+		Type: PublisherKafka,
+		Kafka: config.Kafka{
+			RESTProxy: server.URL + "/",
+			Topic:     "nydus-commits",
+			Headers:   map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+		},
+	})
+	require.NoError(t, err)
+	event := &Event{Type: EventCommitStarted, Container: "docker://abc"}
+	require.NoError(t, publisher.Notify(context.Background(), event))
+	require.Len(t, bodies, 2)
+
+	var records struct {
+		Records []struct {
+			Key   string `json:"key"`
+			Value Event  `json:"value"`
+		} `json:"records"`
+	}
+	require.NoError(t, json.Unmarshal(bodies[1], &records))
+	require.Len(t, records.Records, 1)
+	require.Equal(t, "docker://abc", records.Records[0].Key)
+	require.Equal(t, *event, records.Records[0].Value)
+}
+
+func TestNewPublisher(t *testing.T) {
+	publisher, err := NewPublisher(&config.Publisher{})
+	require.NoError(t, err)
+	require.Nil(t, publisher)
+
+	for _, cfg := range []config.Publisher{
+		{Type: "mqtt"},
+		{Type: PublisherNATS, NATS: config.NATS{URL: "http://localhost:4222"}},
+		{Type: PublisherNATS, NATS: config.NATS{URL: "nats://localhost", Subject: "nydus.>"}},
+		{Type: PublisherKafka, Kafka: config.Kafka{RESTProxy: "http://localhost:8082"}},
+		{Type: PublisherKafka, Kafka: config.Kafka{RESTProxy: "localhost:8082", Topic: "nydus"}},
+		{Type: PublisherKafka, Kafka: config.Kafka{RESTProxy: "http://localhost:8082", Topic: "nydus"}, Events: []string{"pushed"}},
+	} {
+		_, err := NewPublisher(&cfg)
+		require.Error(t, err, cfg)
+	}
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// The headers of webhook requests.
+const (
+	HeaderEvent     = "X-Nydus-Event"
+	HeaderSignature = "X-Nydus-Signature-256"
+)
+
+// Webhook posts events in json to an URL.
+type Webhook struct {
+	cfg     *config.Webhook
+	timeout time.Duration
+	client  *http.Client
+}
+
+// NewWebhook validates `cfg` and returns the webhook configured by it.
+func NewWebhook(cfg *config.Webhook) (*Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse url %s", cfg.URL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid url %s, must be http or https", cfg.URL)
+	}
+	if err := validateEvents(cfg.Events); err != nil {
+		return nil, err
+	}
+	timeout, err := parseTimeout(cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		cfg:     cfg,
+		timeout: timeout,
+		client:  &http.Client{},
+	}, nil
+}
+
+// Sign returns the signature of `payload` by `secret` in the format of
+// header X-Nydus-Signature-256.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts `event` if its type is accepted by the webhook, the server
+// errors and network failures are retried.
+func (w *Webhook) Notify(ctx context.Context, event *Event) error {
+	if !accepts(w.cfg.Events, event.Type) {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshal event")
+	}
+
+	return retry(ctx, func() (bool, error) {
+		return w.post(ctx, event.Type, payload)
+	})
+}
+
+// post sends the request once, and returns whether the failure is worth
+// retrying.
+func (w *Webhook) post(ctx context.Context, eventType string, payload []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, errors.Wrap(err, "create request")
+	}
+	for key, value := range w.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	if w.cfg.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(w.cfg.Secret, payload))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, errors.Wrapf(err, "post %s", w.cfg.URL)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("post %s: unexpected status %s", w.cfg.URL, resp.Status)
+	}
+	return false, nil
+}
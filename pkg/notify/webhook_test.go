@@ -0,0 +1,118 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newServer(t *testing.T, statuses ...int) (*httptest.Server, func() []received) {
+	var mutex sync.Mutex
+	requests := []received{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mutex.Lock()
+		requests = append(requests, received{header: r.Header, body: body})
+		status := http.StatusNoContent
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received{}, requests...)
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	server, requests := newServer(t)
+	webhook, err := NewWebhook(&config.Webhook{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+		Secret:  "secret",
+		Events:  []string{EventCommitSucceeded},
+	})
+	require.NoError(t, err)
+
+	event := &Event{
+		Type:      EventCommitSucceeded,
+		Container: "docker://abc",
+		Targets:   []Target{{Ref: "example.com/app:v1", Format: "nydus", Digest: "sha256:1234"}},
+	}
+	require.NoError(t, webhook.Notify(context.Background(), event))
+	// Not subscribed.
+	require.NoError(t, webhook.Notify(context.Background(), &Event{Type: EventCommitStarted}))
+
+	reqs := requests()
+	require.Len(t, reqs, 1)
+	require.Equal(t, "Bearer token", reqs[0].header.Get("Authorization"))
+	require.Equal(t, "application/json", reqs[0].header.Get("Content-Type"))
+	require.Equal(t, EventCommitSucceeded, reqs[0].header.Get(HeaderEvent))
+	require.Equal(t, Sign("secret", reqs[0].body), reqs[0].header.Get(HeaderSignature))
+
+	var got Event
+	require.NoError(t, json.Unmarshal(reqs[0].body, &got))
+	require.Equal(t, *event, got)
+}
+
+// noBackoff disables the delay of retries until the returned func is
+// called.
+func noBackoff() func() {
+	origin := backoff
+	backoff = func(int) time.Duration { return 0 }
+	return func() { backoff = origin }
+}
+
+func TestWebhookRetry(t *testing.T) {
+	defer noBackoff()()
+
+	server, requests := newServer(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	webhook, err := NewWebhook(&config.Webhook{URL: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, webhook.Notify(context.Background(), &Event{Type: EventCommitFailed}))
+	require.Len(t, requests(), 3)
+	// Not signed without secret.
+	require.Empty(t, requests()[0].header.Get(HeaderSignature))
+
+	server, requests = newServer(t, http.StatusBadRequest)
+	webhook, err = NewWebhook(&config.Webhook{URL: server.URL})
+	require.NoError(t, err)
+	require.ErrorContains(t, webhook.Notify(context.Background(), &Event{Type: EventCommitFailed}), "400 Bad Request")
+	require.Len(t, requests(), 1)
+}
+
+func TestNewWebhookInvalid(t *testing.T) {
+	for _, cfg := range []config.Webhook{
+		{URL: "ftp://example.com"},
+		{URL: "http://example.com", Events: []string{"commit.done"}},
+		{URL: "http://example.com", Timeout: "-1s"},
+		{URL: "http://example.com", Timeout: "ten"},
+	} {
+		_, err := NewWebhook(&cfg)
+		require.Error(t, err, cfg)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac secret
+	require.Equal(t, "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13", Sign("secret", []byte("{}")))
+}
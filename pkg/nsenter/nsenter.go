@@ -3,11 +3,17 @@ package nsenter
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os/exec"
 	"strconv"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
 )
 
 // Config is the nsenter configuration used to generate
@@ -35,6 +41,11 @@ type Config struct {
 	UTS                 bool   // Enter UTS namespace
 	UTSFile             string // UTS namespace location, default to /proc/PID/ns/uts
 	WorkingDirectory    string // Set the working directory, default to target process working directory
+
+	NoEnter          bool          // Run the program in the current namespaces without nsenter, e.g. if nsenter is blocked
+	Name             string        // Name of the program in logs and errors, default to the program
+	StallTimeout     time.Duration // Kill the program if it writes nothing to stdout for the duration, 0 disables it
+	ProgressInterval time.Duration // Log the bytes written to stdout by the program periodically, 0 disables it
 }
 
 // Execute executs the givne command with a default background context
@@ -42,47 +53,117 @@ func (c *Config) Execute(writer io.Writer, program string, args ...string) (stri
 	return c.ExecuteContext(context.Background(), writer, program, args...)
 }
 
+// ErrStalled is returned if the program writes nothing to stdout for
+// StallTimeout.
+var ErrStalled = errors.New("program stalled")
+
+var (
+	// watchInterval is the interval of checking the stall and logging the
+	// progress.
+	watchInterval = time.Second
+	// waitDelay is how long to wait for the stdout of program after it's
+	// killed or nsenter exits, as the program stuck in uninterruptible
+	// sleep, e.g. on a dead network mount, can't be killed.
+	waitDelay = 10 * time.Second
+)
+
+// stdout counts the bytes written into writer and the time of last write.
+type stdout struct {
+	writer io.Writer
+	n      int64
+	last   int64
+	// Stops the program if writer fails, as it may block on the pipe.
+	cancel context.CancelCauseFunc
+}
+
+func (s *stdout) Write(p []byte) (int, error) {
+	n, err := s.writer.Write(p)
+	atomic.AddInt64(&s.n, int64(n))
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+	if err != nil {
+		s.cancel(err)
+	}
+	return n, err
+}
+
 // ExecuteContext the given program using the given nsenter configuration and given context
-// and return stdout/stderr or an error if command has failed
+// and return stdout/stderr or an error if command has failed. The program is killed if the
+// context is done, or if it writes nothing for StallTimeout.
 func (c *Config) ExecuteContext(ctx context.Context, writer io.Writer, program string, args ...string) (string, error) {
-	cmd, err := c.buildCommand(ctx)
-	if err != nil {
-		return "", fmt.Errorf("Error while building command: %v", err)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var cmd *exec.Cmd
+	if c.NoEnter {
+		cmd = exec.CommandContext(ctx, program, args...)
+	} else {
+		var err error
+		if cmd, err = c.buildCommand(ctx); err != nil {
+			return "", fmt.Errorf("Error while building command: %v", err)
+		}
+		cmd.Args = append(cmd.Args, program)
+		cmd.Args = append(cmd.Args, args...)
 	}
 
 	// Prepare command
 	var srderr bytes.Buffer
-	rc, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", fmt.Errorf("Open stdout pipe: %v", err)
-	}
-	defer rc.Close()
-
+	out := &stdout{writer: writer, last: time.Now().UnixNano(), cancel: cancel}
+	cmd.Stdout = out
 	cmd.Stderr = &srderr
-	cmd.Args = append(cmd.Args, program)
-	cmd.Args = append(cmd.Args, args...)
+
+	// nsenter forks the program, so kill the process group to not leave
+	// the program running and holding stdout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
 
 	if err := cmd.Start(); err != nil {
 		return srderr.String(), err
 	}
 
-	// HACK: we can't wait rc.Close happen automatically when process
-	// exits, so must check process state and call rc.Close() by manually.
-	go func() {
-		for {
-			time.Sleep(time.Second * 1)
-			if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
-				rc.Close()
-				break
-			}
-		}
-	}()
+	done := make(chan struct{})
+	defer close(done)
+	if c.StallTimeout > 0 || c.ProgressInterval > 0 {
+		go c.watch(done, cancel, out, program)
+	}
 
-	if _, err := io.Copy(writer, rc); err != nil {
+	if err := cmd.Wait(); err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			return srderr.String(), cause
+		}
 		return srderr.String(), err
 	}
 
-	return srderr.String(), cmd.Wait()
+	return srderr.String(), nil
+}
+
+// watch cancels the program writing nothing for StallTimeout, and logs
+// the bytes streamed every ProgressInterval until done.
+func (c *Config) watch(done chan struct{}, cancel context.CancelCauseFunc, out *stdout, program string) {
+	name := c.Name
+	if name == "" {
+		name = program
+	}
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	logged := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if c.StallTimeout > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&out.last))) >= c.StallTimeout {
+				cancel(fmt.Errorf("%w: no output of %s in %s", ErrStalled, name, c.StallTimeout))
+				return
+			}
+			if c.ProgressInterval > 0 && now.Sub(logged) >= c.ProgressInterval {
+				logrus.Infof("%s: streamed %s", name, humanize.IBytes(uint64(atomic.LoadInt64(&out.n))))
+				logged = now
+			}
+		}
+	}
 }
 
 func (c *Config) buildCommand(ctx context.Context) (*exec.Cmd, error) {
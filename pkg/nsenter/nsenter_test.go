@@ -0,0 +1,78 @@
+package nsenter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// config enters the mount namespace of the test itself, which requires
+// root and nsenter.
+func config(t *testing.T) *Config {
+	if _, err := exec.LookPath("nsenter"); err != nil {
+		t.Skipf("nsenter not found: %s", err)
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("nsenter requires root")
+	}
+	return &Config{Mount: true, Target: os.Getpid()}
+}
+
+func TestExecuteContext(t *testing.T) {
+	c := config(t)
+	var stdout bytes.Buffer
+	stderr, err := c.ExecuteContext(context.Background(), &stdout, "sh", "-c", "echo out; echo err >&2")
+	require.NoError(t, err)
+	require.Equal(t, "out\n", stdout.String())
+	require.Equal(t, "err\n", stderr)
+}
+
+func TestExecuteContextStalled(t *testing.T) {
+	interval := watchInterval
+	watchInterval = 10 * time.Millisecond
+	defer func() {
+		watchInterval = interval
+	}()
+
+	c := config(t)
+	c.StallTimeout = 200 * time.Millisecond
+	start := time.Now()
+	var stdout bytes.Buffer
+	_, err := c.ExecuteContext(context.Background(), &stdout, "sh", "-c", "echo out; sleep 60")
+	require.True(t, errors.Is(err, ErrStalled))
+	require.EqualError(t, err, "program stalled: no output of sh in 200ms")
+	require.Equal(t, "out\n", stdout.String())
+	require.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestExecuteContextCanceled(t *testing.T) {
+	c := config(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.ExecuteContext(ctx, &bytes.Buffer{}, "sleep", "60")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Less(t, time.Since(start), 10*time.Second)
+}
+
+// failedWriter fails the write of program, which must be killed instead
+// of blocking on the pipe.
+type failedWriter struct{}
+
+func (failedWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("tar stream too large")
+}
+
+func TestExecuteContextWriteFailed(t *testing.T) {
+	c := config(t)
+	start := time.Now()
+	_, err := c.ExecuteContext(context.Background(), failedWriter{}, "yes")
+	require.EqualError(t, err, "tar stream too large")
+	require.Less(t, time.Since(start), 10*time.Second)
+}
@@ -0,0 +1,149 @@
+// Package nydusd runs nydusd mounting a bootstrap with the blobs in a local
+// dir.
+package nydusd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// nydusdReadyTimeout is the time to wait for nydusd becoming RUNNING.
+const nydusdReadyTimeout = 30 * time.Second
+
+// Daemon mounts a bootstrap with the blobs in a local dir.
+type Daemon struct {
+	Path      string
+	Bootstrap string
+	BlobDir   string
+	MountPath string
+	WorkDir   string
+
+	cmd *exec.Cmd
+}
+
+// config returns the nydusd config reading blobs from local dir, which is
+// used for both registry and oss images since nydusd addresses oss bucket
+// by virtual host that doesn't work on local endpoint.
+func (n *Daemon) config() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"device": map[string]interface{}{
+			"backend": map[string]interface{}{
+				"type": "localfs",
+				"config": map[string]string{
+					"dir": n.BlobDir,
+				},
+			},
+			"cache": map[string]interface{}{
+				"type": "",
+			},
+		},
+		"mode":            "direct",
+		"digest_validate": false,
+		"enable_xattr":    true,
+	})
+}
+
+func (n *Daemon) state(ctx context.Context, sock string) (string, error) {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+			},
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://unix/api/v1/daemon", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var info struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.State, nil
+}
+
+// Mount starts nydusd and waits until the mount is ready.
+func (n *Daemon) Mount(ctx context.Context, output io.Writer) error {
+	config, err := n.config()
+	if err != nil {
+		return errors.Wrap(err, "marshal nydusd config")
+	}
+	configPath := filepath.Join(n.WorkDir, "nydusd.json")
+	if err := os.WriteFile(configPath, config, 0600); err != nil {
+		return errors.Wrap(err, "write nydusd config")
+	}
+	if err := os.MkdirAll(n.MountPath, 0755); err != nil {
+		return errors.Wrap(err, "create mount path")
+	}
+
+	sock := filepath.Join(n.WorkDir, "nydusd.sock")
+	n.cmd = exec.Command(
+		n.Path,
+		"--config", configPath,
+		"--bootstrap", n.Bootstrap,
+		"--mountpoint", n.MountPath,
+		"--apisock", sock,
+		"--log-level", "error",
+	)
+	n.cmd.Stdout = output
+	n.cmd.Stderr = output
+	if err := n.cmd.Start(); err != nil {
+		return errors.Wrap(err, "start nydusd")
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- n.cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(nydusdReadyTimeout)
+	for {
+		select {
+		case err := <-exited:
+			n.cmd = nil
+			return fmt.Errorf("nydusd exited: %v", err)
+		case <-timeout:
+			return fmt.Errorf("timeout to wait nydusd ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if state, err := n.state(ctx, sock); err == nil && state == "RUNNING" {
+				return nil
+			}
+		}
+	}
+}
+
+// Umount umounts the mount path and stops nydusd.
+func (n *Daemon) Umount() error {
+	if n.cmd == nil {
+		return nil
+	}
+	err := unix.Unmount(n.MountPath, unix.MNT_DETACH)
+	_ = n.cmd.Process.Signal(unix.SIGTERM)
+	n.cmd = nil
+	if err != nil && err != unix.EINVAL {
+		return errors.Wrap(err, "umount nydusd")
+	}
+	return nil
+}
@@ -45,6 +45,8 @@ type Parsed struct {
 	Index *ocispec.Index
 	// The base image from which to generate nydus image.
 	NydusImage *Image
+	// The OCI image of the reference, only used to commit into OCI format.
+	OCIImage *Image
 }
 
 // New creates Nydus image parser instance.
@@ -57,12 +59,21 @@ func New(remote *remote.Remote, interestedArch string) (*Parser, error) {
 
 // Try to find the topmost layer in Nydus manifest, it should
 // be a Nydus bootstrap layer, see examples/manifest/manifest.json
+// bootstrapMediaTypes lists the media types of bootstrap layer, the
+// bootstrap layer may be uncompressed or compressed by gzip or zstd.
+var bootstrapMediaTypes = map[string]bool{
+	ocispec.MediaTypeImageLayer:            true,
+	ocispec.MediaTypeImageLayerGzip:        true,
+	ocispec.MediaTypeImageLayerZstd:        true,
+	images.MediaTypeDockerSchema2Layer:     true,
+	images.MediaTypeDockerSchema2LayerGzip: true,
+}
+
 func FindNydusBootstrapDesc(manifest *ocispec.Manifest) *ocispec.Descriptor {
 	layers := manifest.Layers
 	if len(layers) != 0 {
 		desc := &layers[len(layers)-1]
-		if (desc.MediaType == ocispec.MediaTypeImageLayerGzip ||
-			desc.MediaType == images.MediaTypeDockerSchema2LayerGzip) &&
+		if bootstrapMediaTypes[desc.MediaType] &&
 			desc.Annotations[utils.LayerAnnotationNydusBootstrap] == "true" {
 			return desc
 		}
@@ -190,6 +201,7 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 	}
 
 	var nydusDesc *ocispec.Descriptor
+	var ociDesc *ocispec.Descriptor
 	var onlyManifest *ocispec.Manifest
 	var ignoreArch bool
 
@@ -206,6 +218,8 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 		bootstrapDesc := FindNydusBootstrapDesc(onlyManifest)
 		if bootstrapDesc != nil {
 			nydusDesc = imageDesc
+		} else {
+			ociDesc = imageDesc
 		}
 		// For a single manifest image, we just ignore the arch, so that allowing
 		// to do a default conversion on a different arch's host, for example
@@ -227,6 +241,8 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 				if parser.matchImagePlatform(&desc) {
 					if utils.IsNydusPlatform(desc.Platform) {
 						nydusDesc = &desc
+					} else {
+						ociDesc = &desc
 					}
 				}
 			}
@@ -240,5 +256,12 @@ func (parser *Parser) Parse(ctx context.Context) (*Parsed, error) {
 		}
 	}
 
+	if ociDesc != nil {
+		parsed.OCIImage, err = parser.parseImage(ctx, ociDesc, onlyManifest, ignoreArch)
+		if err != nil {
+			return nil, errors.Wrap(err, "Parse OCI image")
+		}
+	}
+
 	return &parsed, nil
 }
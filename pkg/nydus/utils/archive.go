@@ -137,6 +137,8 @@ func PackTargzInfo(src, name string, compress bool) (digest.Digest, int64, error
 	return hash, <-chanSize, <-chanErr
 }
 
+// UnpackFile extracts file `source` in the tar stream of `reader` to `target`,
+// the stream may be uncompressed or compressed by gzip or zstd.
 func UnpackFile(reader io.Reader, source, target string) error {
 	rdr, err := compression.DecompressStream(reader)
 	if err != nil {
@@ -170,7 +172,7 @@ func UnpackFile(reader io.Reader, source, target string) error {
 	}
 
 	if !found {
-		return fmt.Errorf("not found file %s in targz", source)
+		return fmt.Errorf("not found file %s in layer", source)
 	}
 
 	return nil
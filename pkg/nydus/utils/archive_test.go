@@ -15,9 +15,14 @@
 package utils
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,3 +40,39 @@ func TestPackTargzInfo(t *testing.T) {
 	assert.Equal(t, "sha256:6cdd1b26d54d5852fbea95a81cbb25383975b70b4ffad9f9b6d25c7a434a51eb", digest.String())
 	assert.Equal(t, size, int64(315))
 }
+
+func TestUnpackFile(t *testing.T) {
+	var layer bytes.Buffer
+	tw := tar.NewWriter(&layer)
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: BootstrapFileNameInLayer, Mode: 0444, Size: 9, Typeflag: tar.TypeReg}))
+	_, err := tw.Write([]byte("bootstrap"))
+	assert.Nil(t, err)
+	assert.Nil(t, tw.Close())
+
+	var gzipLayer bytes.Buffer
+	gw := gzip.NewWriter(&gzipLayer)
+	_, err = gw.Write(layer.Bytes())
+	assert.Nil(t, err)
+	assert.Nil(t, gw.Close())
+
+	var zstdLayer bytes.Buffer
+	zw, err := zstd.NewWriter(&zstdLayer)
+	assert.Nil(t, err)
+	_, err = zw.Write(layer.Bytes())
+	assert.Nil(t, err)
+	assert.Nil(t, zw.Close())
+
+	for name, data := range map[string][]byte{
+		"uncompressed": layer.Bytes(),
+		"gzip":         gzipLayer.Bytes(),
+		"zstd":         zstdLayer.Bytes(),
+	} {
+		target := filepath.Join(t.TempDir(), "bootstrap")
+		assert.Nil(t, UnpackFile(bytes.NewReader(data), BootstrapFileNameInLayer, target), name)
+		unpacked, err := os.ReadFile(target)
+		assert.Nil(t, err)
+		assert.Equal(t, "bootstrap", string(unpacked), name)
+	}
+
+	assert.NotNil(t, UnpackFile(bytes.NewReader(layer.Bytes()), "not-found", filepath.Join(t.TempDir(), "bootstrap")))
+}
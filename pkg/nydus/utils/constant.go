@@ -26,6 +26,8 @@ const (
 	LayerAnnotationNydusBootstrapDigest = "containerd.io/snapshot/nydus-bootstrap-digest"
 	LayerAnnotationNydusFsVersion       = "containerd.io/snapshot/nydus-fs-version"
 	LayerAnnotationUncompressed         = "containerd.io/uncompressed"
+
+	ManifestAnnotationNydusBuilderVersion = "containerd.io/snapshot/nydus-builder-version"
 )
 
 var NydusAnnotations = []string{LayerAnnotationNydusBlob, LayerAnnotationNydusBootstrap, LayerAnnotationNydusRAFSVersion}
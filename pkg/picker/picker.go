@@ -0,0 +1,87 @@
+// Package picker prompts the operator to pick a container to commit on
+// terminal, for ad-hoc commits on a node without looking up the id.
+package picker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+// ErrNoContainer is returned if there is no container to pick.
+var ErrNoContainer = errors.New("no running container of nydus image")
+
+// IsTerminal checks whether `file` is a terminal.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// shortID shortens the id of container `id` like engines, keeping the
+// engine type, e.g. `docker://0123456789ab`.
+func shortID(id string) string {
+	prefix, containerID, ok := strings.Cut(id, "://")
+	if !ok {
+		prefix, containerID = "", id
+	} else {
+		prefix += "://"
+	}
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+	return prefix + containerID
+}
+
+// Pick writes the containers of nydus image in `containers` as a numbered
+// table to `out`, and reads the number of picked one from `in`, prompting
+// again on invalid input until EOF.
+func Pick(in io.Reader, out io.Writer, containers []container.Summary) (*container.Summary, error) {
+	candidates := []container.Summary{}
+	for _, container := range containers {
+		if container.Nydus {
+			candidates = append(candidates, container)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoContainer
+	}
+
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "#\tCONTAINER\tNAME\tIMAGE")
+	for idx, container := range candidates {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", idx+1, shortID(container.ID), container.Name, container.Image)
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprintf(out, "Select the container to commit [1-%d]: ", len(candidates))
+		line, err := reader.ReadString('\n')
+		if input := strings.TrimSpace(line); input != "" {
+			if number, parseErr := strconv.Atoi(input); parseErr == nil && number >= 1 && number <= len(candidates) {
+				return &candidates[number-1], nil
+			}
+			fmt.Fprintf(out, "invalid selection %q\n", input)
+		}
+		if err != nil {
+			if err == io.EOF {
+				fmt.Fprintln(out)
+				return nil, fmt.Errorf("no container selected")
+			}
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package picker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+func TestPick(t *testing.T) {
+	containers := []container.Summary{
+		{ID: "docker://0123456789abcdef", Name: "web", Image: "nginx:latest_nydus_v2", Nydus: true},
+		{ID: "docker://fedcba9876543210", Name: "db", Image: "mysql:8"},
+		{ID: "pouch://c2", Name: "app", Image: "app:v1_nydus_v2", Nydus: true},
+	}
+
+	var out bytes.Buffer
+	picked, err := Pick(strings.NewReader("3\nx\n2\n"), &out, containers)
+	require.NoError(t, err)
+	require.Equal(t, "pouch://c2", picked.ID)
+	require.Equal(t, `#  CONTAINER              NAME  IMAGE
+1  docker://0123456789ab  web   nginx:latest_nydus_v2
+2  pouch://c2             app   app:v1_nydus_v2
+Select the container to commit [1-2]: invalid selection "3"
+Select the container to commit [1-2]: invalid selection "x"
+Select the container to commit [1-2]: `, out.String())
+
+	// The input without newline.
+	picked, err = Pick(strings.NewReader("1"), &out, containers)
+	require.NoError(t, err)
+	require.Equal(t, "docker://0123456789abcdef", picked.ID)
+
+	_, err = Pick(strings.NewReader("\n"), &out, containers)
+	require.EqualError(t, err, "no container selected")
+
+	_, err = Pick(strings.NewReader("1\n"), &out, containers[1:2])
+	require.ErrorIs(t, err, ErrNoContainer)
+}
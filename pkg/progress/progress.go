@@ -0,0 +1,249 @@
+// Package progress reports the progress of packing and pushing blobs, as a
+// status line with transfer rate, percentage and ETA on terminal, or as
+// periodic log lines otherwise.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+)
+
+// Mode is how the progress is displayed.
+type Mode string
+
+const (
+	// ModeAuto displays the status line on terminal, or the log lines
+	// otherwise.
+	ModeAuto Mode = "auto"
+	// ModeBar redraws a status line in place.
+	ModeBar Mode = "bar"
+	// ModeLog logs the progress periodically.
+	ModeLog Mode = "log"
+	// ModeNone suppresses the progress.
+	ModeNone Mode = "none"
+)
+
+var (
+	// BarInterval is the interval of redrawing the status line.
+	BarInterval = 200 * time.Millisecond
+	// LogInterval is the interval of logging the progress.
+	LogInterval = 10 * time.Second
+)
+
+const barWidth = 20
+
+// ParseMode parses `mode`, the empty mode is ModeAuto.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "":
+		return ModeAuto, nil
+	case ModeAuto, ModeBar, ModeLog, ModeNone:
+		return Mode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid progress mode %s, must be one of auto, bar, log, none", mode)
+	}
+}
+
+// Resolve resolves ModeAuto by whether the output is a terminal.
+func (m Mode) Resolve(terminal bool) Mode {
+	if m != ModeAuto {
+		return m
+	}
+	if terminal {
+		return ModeBar
+	}
+	return ModeLog
+}
+
+// Enabled checks whether the progress is displayed in resolved mode `m`.
+func (m Mode) Enabled() bool {
+	return m == ModeBar || m == ModeLog
+}
+
+// Reporter counts the bytes transferred as an io.Writer, and displays the
+// progress against the estimated total until finished.
+type Reporter struct {
+	name string
+	// total is the estimated size, 0 if unknown.
+	total int64
+	n     int64
+	start time.Time
+	mode  Mode
+	// Set in ModeLog to stop logging.
+	stop chan struct{}
+	done sync.WaitGroup
+	once sync.Once
+}
+
+// New starts reporting the progress of `name` in `mode`, which must be
+// resolved already, the status line of ModeBar is drawn on `out`. The
+// `total` is the estimated size, 0 if unknown.
+func New(name string, total int64, mode Mode, out io.Writer) *Reporter {
+	r := &Reporter{
+		name:  name,
+		total: total,
+		start: time.Now(),
+		mode:  mode,
+	}
+	switch mode {
+	case ModeBar:
+		bar.add(r, out)
+	case ModeLog:
+		r.stop = make(chan struct{})
+		r.done.Add(1)
+		go r.log()
+	}
+	return r
+}
+
+func (r *Reporter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&r.n, int64(len(p)))
+	return len(p), nil
+}
+
+// Size returns the bytes transferred.
+func (r *Reporter) Size() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// Finish stops reporting, the summary is kept on terminal. It's safe to call Finish more than once.
+func (r *Reporter) Finish() {
+	r.once.Do(func() {
+		switch r.mode {
+		case ModeBar:
+			bar.remove(r)
+		case ModeLog:
+			close(r.stop)
+			r.done.Wait()
+		}
+	})
+}
+
+func (r *Reporter) log() {
+	defer r.done.Done()
+	ticker := time.NewTicker(LogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			logrus.Info(r.line(now, false))
+		}
+	}
+}
+
+// line formats the progress at `now`, with a bar if `withBar` is set, e.g.
+// `pack upper [=====>      ] 45% 12 MiB/26 MiB 3.2 MiB/s ETA 4s`. The
+// percentage and ETA are omitted if the total is unknown or already
+// exceeded, as it's only an estimation.
+func (r *Reporter) line(now time.Time, withBar bool) string {
+	n := r.Size()
+	elapsed := now.Sub(r.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(n) / elapsed.Seconds()
+	}
+
+	parts := []string{r.name}
+	if r.total > 0 && n <= r.total {
+		ratio := float64(n) / float64(r.total)
+		if withBar {
+			filled := int(ratio * barWidth)
+			arrow := ""
+			if filled < barWidth {
+				arrow = ">"
+			}
+			parts = append(parts, "["+strings.Repeat("=", filled)+arrow+strings.Repeat(" ", barWidth-filled-len(arrow))+"]")
+		}
+		parts = append(parts, fmt.Sprintf("%d%%", int(ratio*100)), humanize.IBytes(uint64(n))+"/"+humanize.IBytes(uint64(r.total)))
+		parts = append(parts, humanize.IBytes(uint64(rate))+"/s")
+		if rate > 0 {
+			eta := time.Duration(float64(r.total-n) / rate * float64(time.Second))
+			parts = append(parts, "ETA "+eta.Round(time.Second).String())
+		}
+	} else {
+		parts = append(parts, humanize.IBytes(uint64(n)), humanize.IBytes(uint64(rate))+"/s")
+	}
+	return strings.Join(parts, " ")
+}
+
+// summary formats the bytes transferred and the average rate once
+// finished, e.g. `pack upper 26 MiB in 8s (3.2 MiB/s)`.
+func (r *Reporter) summary(now time.Time) string {
+	n := r.Size()
+	elapsed := now.Sub(r.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(n) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("%s %s in %s (%s/s)", r.name, humanize.IBytes(uint64(n)), elapsed.Round(time.Millisecond), humanize.IBytes(uint64(rate)))
+}
+
+// terminal draws the status line of the reporters in progress, the first
+// started one is shown with the count of others, as the packs and pushes
+// of blobs may be concurrent.
+type terminal struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	reporters []*Reporter
+	stop      chan struct{}
+}
+
+var bar = &terminal{}
+
+func (t *terminal) add(r *Reporter, out io.Writer) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.reporters = append(t.reporters, r)
+	if len(t.reporters) > 1 {
+		return
+	}
+	t.out = out
+	t.stop = make(chan struct{})
+	go t.draw(t.stop)
+}
+
+func (t *terminal) remove(r *Reporter) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for idx, reporter := range t.reporters {
+		if reporter == r {
+			t.reporters = append(t.reporters[:idx], t.reporters[idx+1:]...)
+			break
+		}
+	}
+	// Keep the summary of the finished one.
+	fmt.Fprintf(t.out, "\r%s\x1b[K\n", r.summary(time.Now()))
+	if len(t.reporters) == 0 {
+		close(t.stop)
+	}
+}
+
+func (t *terminal) draw(stop chan struct{}) {
+	ticker := time.NewTicker(BarInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			t.mutex.Lock()
+			if len(t.reporters) > 0 {
+				line := t.reporters[0].line(now, true)
+				if others := len(t.reporters) - 1; others > 0 {
+					line += fmt.Sprintf(" (+%d more)", others)
+				}
+				fmt.Fprintf(t.out, "\r%s\x1b[K", line)
+			}
+			t.mutex.Unlock()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode("")
+	require.NoError(t, err)
+	require.Equal(t, ModeAuto, mode)
+	require.Equal(t, ModeBar, mode.Resolve(true))
+	require.Equal(t, ModeLog, mode.Resolve(false))
+	require.True(t, mode.Resolve(false).Enabled())
+
+	mode, err = ParseMode("none")
+	require.NoError(t, err)
+	require.Equal(t, ModeNone, mode.Resolve(true))
+	require.False(t, mode.Enabled())
+	require.False(t, Mode("").Enabled())
+
+	_, err = ParseMode("quiet")
+	require.EqualError(t, err, "invalid progress mode quiet, must be one of auto, bar, log, none")
+}
+
+func TestLine(t *testing.T) {
+	start := time.Now()
+	r := &Reporter{name: "pack blob-upper", total: 4 << 20, start: start}
+	r.Write(make([]byte, 1<<20))
+	now := start.Add(time.Second)
+	require.Equal(t, "pack blob-upper [=====>              ] 25% 1.0 MiB/4.0 MiB 1.0 MiB/s ETA 3s", r.line(now, true))
+	require.Equal(t, "pack blob-upper 25% 1.0 MiB/4.0 MiB 1.0 MiB/s ETA 3s", r.line(now, false))
+
+	r.Write(make([]byte, 3<<20))
+	require.Equal(t, "pack blob-upper [====================] 100% 4.0 MiB/4.0 MiB 4.0 MiB/s ETA 0s", r.line(now, true))
+
+	// The estimated total is exceeded.
+	r.Write(make([]byte, 1<<20))
+	require.Equal(t, "pack blob-upper 5.0 MiB 5.0 MiB/s", r.line(now, true))
+	require.Equal(t, "pack blob-upper 5.0 MiB in 1s (5.0 MiB/s)", r.summary(now))
+
+	// The total is unknown.
+	r = &Reporter{name: "pack blob-mount-0", start: start}
+	r.Write(make([]byte, 2<<20))
+	require.Equal(t, "pack blob-mount-0 2.0 MiB 1.0 MiB/s", r.line(start.Add(2*time.Second), true))
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent draws.
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestBar(t *testing.T) {
+	interval := BarInterval
+	BarInterval = 10 * time.Millisecond
+	defer func() {
+		BarInterval = interval
+	}()
+
+	out := &syncBuffer{}
+	upper := New("pack blob-upper", 100, ModeBar, out)
+	mount := New("pack blob-mount-0", 0, ModeBar, out)
+	upper.Write(make([]byte, 50))
+	require.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "\rpack blob-upper [==========>         ] 50% 50 B/100 B")
+	}, time.Second, BarInterval)
+	require.Contains(t, out.String(), "(+1 more)\x1b[K")
+
+	upper.Finish()
+	upper.Finish()
+	require.Regexp(t, "\rpack blob-upper 50 B in .+\x1b\\[K\n$", out.String())
+	require.Eventually(t, func() bool {
+		return strings.HasSuffix(out.String(), "\x1b[K") && strings.Contains(out.String()[strings.LastIndex(out.String(), "\n"):], "\rpack blob-mount-0 0 B")
+	}, time.Second, BarInterval)
+	mount.Finish()
+	require.Empty(t, bar.reporters)
+}
+
+func TestNone(t *testing.T) {
+	out := &syncBuffer{}
+	r := New("push blob-upper", 100, ModeNone, out)
+	r.Write(make([]byte, 10))
+	require.Equal(t, int64(10), r.Size())
+	r.Finish()
+	require.Empty(t, out.String())
+}
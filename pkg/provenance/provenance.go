@@ -0,0 +1,157 @@
+// Package provenance makes the in-toto attestation of SLSA provenance
+// describing how an image is committed from a container, see
+// https://slsa.dev/spec/v1.0/provenance.
+package provenance
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MediaType is the media type of in-toto attestation, it's also the
+	// artifact type of attestation referring to the committed image.
+	MediaType = "application/vnd.in-toto+json"
+	// StatementType is the type of in-toto statement v1.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the type of SLSA provenance v1.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType is the type of builds committing images from containers.
+	BuildType = "https://github.com/nydusaccelerator/nydus-cli/commit/v1"
+	// BuilderID is the id of builder, i.e. nydus-cli.
+	BuilderID = "https://github.com/nydusaccelerator/nydus-cli"
+)
+
+// Commit describes a commit attested by provenance.
+type Commit struct {
+	// ContainerID is the id of committed container with engine type.
+	ContainerID string
+	// Targets are the references which the image is pushed to.
+	Targets []string
+	// Host is the hostname of node committing the container.
+	Host string
+	// BaseRef and BaseDigest are the image of container and the digest
+	// of its manifest.
+	BaseRef    string
+	BaseDigest digest.Digest
+	// Blobs are the digests of committed blobs, the mount blobs followed
+	// by the upper blob.
+	Blobs []digest.Digest
+	// Version of nydus-cli.
+	Version    string
+	StartedOn  time.Time
+	FinishedOn time.Time
+}
+
+// Subject is the artifact attested by statement.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Statement is the in-toto statement whose predicate is SLSA provenance.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Provenance is the SLSA provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string                 `json:"buildType"`
+	ExternalParameters   map[string]interface{} `json:"externalParameters"`
+	InternalParameters   map[string]interface{} `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor   `json:"resolvedDependencies,omitempty"`
+}
+
+type RunDetails struct {
+	Builder    Builder              `json:"builder"`
+	Metadata   Metadata             `json:"metadata"`
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type Metadata struct {
+	InvocationID string    `json:"invocationId,omitempty"`
+	StartedOn    time.Time `json:"startedOn"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// ResourceDescriptor describes an artifact consumed or produced by build.
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+func digestSet(dgst digest.Digest) map[string]string {
+	return map[string]string{dgst.Algorithm().String(): dgst.Encoded()}
+}
+
+// New returns the statement attesting that manifest `subject` of image
+// `name` is committed by `commit`.
+func New(name string, subject digest.Digest, commit Commit) Statement {
+	statement := Statement{
+		Type:          StatementType,
+		Subject:       []Subject{{Name: name, Digest: digestSet(subject)}},
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType: BuildType,
+				ExternalParameters: map[string]interface{}{
+					"container": commit.ContainerID,
+					"targets":   commit.Targets,
+				},
+				InternalParameters: map[string]interface{}{
+					"host": commit.Host,
+				},
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: BuilderID},
+				Metadata: Metadata{
+					InvocationID: commit.ContainerID,
+					StartedOn:    commit.StartedOn.UTC(),
+					FinishedOn:   commit.FinishedOn.UTC(),
+				},
+			},
+		},
+	}
+	if commit.Version != "" {
+		statement.Predicate.RunDetails.Builder.Version = map[string]string{"nydus-cli": commit.Version}
+	}
+	if commit.BaseDigest != "" {
+		statement.Predicate.BuildDefinition.ResolvedDependencies = []ResourceDescriptor{{
+			URI:    commit.BaseRef,
+			Digest: digestSet(commit.BaseDigest),
+		}}
+	}
+	for _, blob := range commit.Blobs {
+		statement.Predicate.RunDetails.Byproducts = append(statement.Predicate.RunDetails.Byproducts, ResourceDescriptor{
+			Name:   "blob",
+			Digest: digestSet(blob),
+		})
+	}
+	return statement
+}
+
+// Marshal returns the statement in json.
+func (statement Statement) Marshal() ([]byte, error) {
+	data, err := json.Marshal(statement)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal provenance statement")
+	}
+	return data, nil
+}
@@ -0,0 +1,54 @@
+package provenance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	subject := digest.FromString("manifest")
+	base := digest.FromString("base")
+	upper := digest.FromString("upper")
+	started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	statement := New("registry.example.com/app:v2", subject, Commit{
+		ContainerID: "docker://abc",
+		Targets:     []string{"registry.example.com/app:v2"},
+		Host:        "node-1",
+		BaseRef:     "registry.example.com/app:v1",
+		BaseDigest:  base,
+		Blobs:       []digest.Digest{upper},
+		Version:     "v1.0.0",
+		StartedOn:   started,
+		FinishedOn:  started.Add(time.Minute),
+	})
+	data, err := statement.Marshal()
+	require.NoError(t, err)
+
+	parsed := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	require.Equal(t, StatementType, parsed["_type"])
+	require.Equal(t, PredicateType, parsed["predicateType"])
+	require.Equal(t, subject.Encoded(), parsed["subject"].([]interface{})[0].(map[string]interface{})["digest"].(map[string]interface{})["sha256"])
+
+	predicate := parsed["predicate"].(map[string]interface{})
+	definition := predicate["buildDefinition"].(map[string]interface{})
+	require.Equal(t, BuildType, definition["buildType"])
+	require.Equal(t, "docker://abc", definition["externalParameters"].(map[string]interface{})["container"])
+	require.Equal(t, "node-1", definition["internalParameters"].(map[string]interface{})["host"])
+	dependency := definition["resolvedDependencies"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "registry.example.com/app:v1", dependency["uri"])
+	require.Equal(t, base.Encoded(), dependency["digest"].(map[string]interface{})["sha256"])
+
+	details := predicate["runDetails"].(map[string]interface{})
+	require.Equal(t, "2024-01-02T03:04:05Z", details["metadata"].(map[string]interface{})["startedOn"])
+	require.Len(t, details["byproducts"], 1)
+
+	statement = New("registry.example.com/app:v2", subject, Commit{})
+	require.Empty(t, statement.Predicate.BuildDefinition.ResolvedDependencies)
+	require.Nil(t, statement.Predicate.RunDetails.Builder.Version)
+}
@@ -0,0 +1,134 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+)
+
+// TokenFunc returns the bearer token of registry `host`.
+type TokenFunc = func(host string) (string, error)
+
+// bearerAuthorizer authorizes the requests by the bearer token of
+// TokenFunc as is, instead of exchanging credentials for tokens at the
+// auth server of registry. The token rejected by registry is fetched
+// again, so the token refreshed in the meantime, e.g. rotated in file, is
+// used by the retry.
+type bearerAuthorizer struct {
+	tokenFunc TokenFunc
+	mutex     sync.Mutex
+	// The tokens keyed by host.
+	tokens map[string]string
+}
+
+func newBearerAuthorizer(tokenFunc TokenFunc) *bearerAuthorizer {
+	return &bearerAuthorizer{
+		tokenFunc: tokenFunc,
+		tokens:    map[string]string{},
+	}
+}
+
+// token returns the token of `host`, which is fetched again by TokenFunc
+// if `refresh`.
+func (a *bearerAuthorizer) token(host string, refresh bool) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if token, ok := a.tokens[host]; ok && !refresh {
+		return token, nil
+	}
+	token, err := a.tokenFunc(host)
+	if err != nil {
+		return "", err
+	}
+	a.tokens[host] = token
+	return token, nil
+}
+
+func (a *bearerAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := a.token(req.URL.Host, false)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// AddResponses refreshes the token rejected by the last response, it fails
+// if the token isn't changed by refreshing.
+func (a *bearerAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	last := responses[len(responses)-1]
+	host := last.Request.URL.Host
+	rejected := strings.TrimPrefix(last.Request.Header.Get("Authorization"), "Bearer ")
+	token, err := a.token(host, true)
+	if err != nil {
+		return err
+	}
+	if token == "" || token == rejected {
+		return fmt.Errorf("%w: bearer token rejected by %s", docker.ErrInvalidAuthorization, host)
+	}
+	return nil
+}
+
+// refreshingAuthorizer is the authorizer created again once the token it
+// authorized a request with is rejected, e.g. expired during a long push,
+// as the docker authorizer caches the tokens of scopes until exit. The new
+// authorizer fetches the tokens by the credentials again.
+type refreshingAuthorizer struct {
+	newAuthorizer func() docker.Authorizer
+	mutex         sync.Mutex
+	authorizer    docker.Authorizer
+}
+
+func newRefreshingAuthorizer(newAuthorizer func() docker.Authorizer) *refreshingAuthorizer {
+	return &refreshingAuthorizer{
+		newAuthorizer: newAuthorizer,
+		authorizer:    newAuthorizer(),
+	}
+}
+
+func (a *refreshingAuthorizer) current() docker.Authorizer {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.authorizer
+}
+
+func (a *refreshingAuthorizer) Authorize(ctx context.Context, req *http.Request) error {
+	return a.current().Authorize(ctx, req)
+}
+
+// AddResponses creates the authorizer again if the last response rejects
+// the first token of the request, the responses before are dropped so the
+// new authorizer doesn't take the request as retried with invalid token.
+// The token rejected again fails as the docker authorizer does.
+func (a *refreshingAuthorizer) AddResponses(ctx context.Context, responses []*http.Response) error {
+	rejected := 0
+	for _, resp := range responses {
+		if isBearer(resp.Request.Header.Get("Authorization")) {
+			rejected++
+		}
+	}
+	last := responses[len(responses)-1]
+	if rejected != 1 || !isBearer(last.Request.Header.Get("Authorization")) {
+		return a.current().AddResponses(ctx, responses)
+	}
+
+	authorizer := a.current()
+	a.mutex.Lock()
+	// The concurrent requests rejected by the same token refresh once.
+	if a.authorizer == authorizer {
+		a.authorizer = a.newAuthorizer()
+	}
+	authorizer = a.authorizer
+	a.mutex.Unlock()
+	return authorizer.AddResponses(ctx, responses[len(responses)-1:])
+}
+
+func isBearer(authorization string) bool {
+	return strings.HasPrefix(strings.ToLower(authorization), "bearer ")
+}
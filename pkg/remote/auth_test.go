@@ -0,0 +1,118 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenRegistry accepts the blob requests authorized by `valid` token, and
+// issues a new valid token from its auth server on each request.
+type tokenRegistry struct {
+	mutex  sync.Mutex
+	valid  string
+	issued int
+	realm  string
+}
+
+func (r *tokenRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if req.URL.Path == "/token" {
+		r.issued++
+		r.valid = fmt.Sprintf("token-%d", r.issued)
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": r.valid})
+		return
+	}
+	if r.valid == "" || req.Header.Get("Authorization") != "Bearer "+r.valid {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="test",error="invalid_token"`, r.realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Length", "5")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *tokenRegistry) expire() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.valid = ""
+}
+
+func newTokenRemote(t *testing.T, registry *tokenRegistry, tokenFunc TokenFunc) (*Remote, HostsFunc) {
+	server := httptest.NewServer(registry)
+	t.Cleanup(server.Close)
+	registry.realm = server.URL + "/token"
+	hosts := NewRegistryHostsWithOptions(RegistryOptions{
+		Insecure:  true,
+		PlainHTTP: true,
+		CredFunc: func(string) (string, string, error) {
+			return "", "", nil
+		},
+		TokenFunc: tokenFunc,
+	})
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return hosts
+	}
+	remoter, err := New(strings.TrimPrefix(server.URL, "http://")+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolverWithHosts(hosts)
+	})
+	require.NoError(t, err)
+	return remoter, hostsFunc
+}
+
+func TestBearerAuthorizer(t *testing.T) {
+	registry := &tokenRegistry{valid: "rotated"}
+	var mutex sync.Mutex
+	token, fetched := "initial", 0
+	remoter, hostsFunc := newTokenRemote(t, registry, func(host string) (string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetched++
+		return token, nil
+	})
+
+	// The token not refreshed is rejected.
+	_, err := remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.ErrorIs(t, err, docker.ErrInvalidAuthorization)
+
+	// The rotated token is fetched once rejected.
+	mutex.Lock()
+	token = "rotated"
+	mutex.Unlock()
+	size, err := remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), size)
+	fetched = 0
+	_, err = remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.NoError(t, err)
+	require.Zero(t, fetched)
+}
+
+func TestRefreshingAuthorizer(t *testing.T) {
+	registry := &tokenRegistry{}
+	remoter, hostsFunc := newTokenRemote(t, registry, nil)
+
+	_, err := remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.NoError(t, err)
+	require.Equal(t, 1, registry.issued)
+	_, err = remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.NoError(t, err)
+	require.Equal(t, 1, registry.issued)
+
+	// The expired token is fetched again instead of failing.
+	registry.expire()
+	_, err = remoter.Stat(context.Background(), hostsFunc, digest.FromString("blob"))
+	require.NoError(t, err)
+	require.Equal(t, 2, registry.issued)
+}
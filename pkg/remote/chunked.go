@@ -0,0 +1,285 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ChunkedUploadThreshold is the minimal blob size pushed by resumable
+// chunked upload, the smaller blobs are pushed in a single request.
+const ChunkedUploadThreshold = StreamChunkSize
+
+// uploadCheckpoint persists the progress of a chunked upload session, so
+// that the next attempt resumes from the last acknowledged offset.
+type uploadCheckpoint struct {
+	Digest   string `json:"digest"`
+	Location string `json:"location"`
+	Offset   int64  `json:"offset"`
+}
+
+func loadCheckpoint(path string) (*uploadCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var checkpoint uploadCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (c *uploadCheckpoint) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// parseRange parses the Range header `0-<end>` of upload status, and returns
+// the offset to resume from.
+func parseRange(value string) (int64, error) {
+	start, end, ok := strings.Cut(value, "-")
+	if !ok || start != "0" {
+		return 0, fmt.Errorf("invalid range %q", value)
+	}
+	last, err := strconv.ParseInt(end, 10, 64)
+	if err != nil || last < 0 {
+		return 0, fmt.Errorf("invalid range %q", value)
+	}
+	// Registry reports `0-0` for an empty upload, chunks are much larger
+	// than 1 byte so it never means 1 byte uploaded.
+	if last == 0 {
+		return 0, nil
+	}
+	return last + 1, nil
+}
+
+// resume queries the status of the upload session in checkpoint, and
+// returns the upload url and offset to resume from.
+func (sp *streamPusher) resume(ctx context.Context, checkpoint *uploadCheckpoint) (*url.URL, int64, error) {
+	uploadURL, err := url.Parse(checkpoint.Location)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "parse upload location")
+	}
+	return sp.status(ctx, uploadURL)
+}
+
+// status queries the upload session at `uploadURL`, and returns the upload
+// url and offset to continue from.
+func (sp *streamPusher) status(ctx context.Context, uploadURL *url.URL) (*url.URL, int64, error) {
+	resp, err := sp.do(ctx, http.MethodGet, uploadURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusNoContent); err != nil {
+		return nil, 0, err
+	}
+	offset, err := parseRange(resp.Header.Get("Range"))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.Header.Get("Location") != "" {
+		if uploadURL, err = sp.location(resp); err != nil {
+			return nil, 0, err
+		}
+	}
+	return uploadURL, offset, nil
+}
+
+func (sp *streamPusher) exists(ctx context.Context, desc ocispec.Descriptor) (bool, error) {
+	resp, err := sp.do(ctx, http.MethodHead, sp.url("blobs/"+desc.Digest.String()), nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// PushChunked pushes blob `desc` read from `ra` by chunked upload, the
+// progress is persisted in file `checkpointPath` after each acknowledged
+// chunk, so a failed push called again resumes from the last acknowledged
+// offset instead of restarting. The checkpoint is removed once pushed.
+func (remote *Remote) PushChunked(ctx context.Context, hostsFunc HostsFunc, ra io.ReaderAt, desc ocispec.Descriptor, checkpointPath string) error {
+	ctx, err := remote.withPushScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return err
+	}
+	exists, err := sp.exists(ctx, desc)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		if sp, err = remote.pushHost(hostsFunc); err != nil {
+			return err
+		}
+		exists, err = sp.exists(ctx, desc)
+	}
+	if err != nil {
+		return errors.Wrap(err, "check blob existence")
+	}
+	if exists {
+		return nil
+	}
+
+	var uploadURL *url.URL
+	var offset int64
+	checkpoint, err := loadCheckpoint(checkpointPath)
+	if err != nil {
+		logrus.WithError(err).Warnf("ignore invalid upload checkpoint %s", checkpointPath)
+	}
+	if checkpoint != nil && checkpoint.Digest == desc.Digest.String() {
+		uploadURL, offset, err = sp.resume(ctx, checkpoint)
+		if err != nil {
+			logrus.WithError(err).Warnf("restart upload of blob %s", desc.Digest)
+			uploadURL = nil
+		} else {
+			logrus.Infof("resume upload of blob %s from offset %d", desc.Digest, offset)
+		}
+	}
+	if uploadURL == nil {
+		if _, uploadURL, err = remote.startUpload(ctx, hostsFunc); err != nil {
+			return errors.Wrap(err, "start upload")
+		}
+		offset = 0
+	}
+
+	checkpoint = &uploadCheckpoint{Digest: desc.Digest.String()}
+	for offset < desc.Size {
+		size := desc.Size - offset
+		if size > StreamChunkSize {
+			size = StreamChunkSize
+		}
+		resp, err := sp.doChunk(ctx, uploadURL, io.NewSectionReader(ra, offset, size), offset, size)
+		if err != nil {
+			return errors.Wrapf(err, "upload chunk at %d", offset)
+		}
+		err = checkStatus(resp, http.StatusAccepted, http.StatusNoContent)
+		if err == nil {
+			uploadURL, err = sp.location(resp)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return errors.Wrapf(err, "upload chunk at %d", offset)
+		}
+		offset += size
+
+		checkpoint.Location = uploadURL.String()
+		checkpoint.Offset = offset
+		if err := checkpoint.save(checkpointPath); err != nil {
+			logrus.WithError(err).Warnf("save upload checkpoint %s", checkpointPath)
+		}
+	}
+
+	query := uploadURL.Query()
+	query.Set("digest", desc.Digest.String())
+	uploadURL.RawQuery = query.Encode()
+	resp, err := sp.do(ctx, http.MethodPut, uploadURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "commit upload")
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated, http.StatusNoContent, http.StatusOK); err != nil {
+		return errors.Wrap(err, "commit upload")
+	}
+
+	if err := os.Remove(checkpointPath); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Warnf("remove upload checkpoint %s", checkpointPath)
+	}
+
+	return nil
+}
+
+// doChunk uploads a chunk at `offset` with its range, so the registry
+// rejects the chunk not following the uploaded data.
+func (sp *streamPusher) doChunk(ctx context.Context, uploadURL *url.URL, body io.Reader, offset, size int64) (*http.Response, error) {
+	return sp.doRequest(ctx, http.MethodPatch, uploadURL, body, func(req *http.Request) {
+		req.ContentLength = size
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+size-1))
+	})
+}
+
+// PushBytes pushes the small blob `data` by a monolithic upload, which is
+// a single POST with the digest if the registry supports, otherwise the
+// blob is put to the upload session started by the POST.
+func (remote *Remote) PushBytes(ctx context.Context, hostsFunc HostsFunc, data []byte, desc ocispec.Descriptor) error {
+	ctx, err := remote.withPushScope(ctx)
+	if err != nil {
+		return err
+	}
+
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return err
+	}
+	// The request without body authorizes the following requests with
+	// body, which can't be replayed after authorizing.
+	exists, err := sp.exists(ctx, desc)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		if sp, err = remote.pushHost(hostsFunc); err != nil {
+			return err
+		}
+		exists, err = sp.exists(ctx, desc)
+	}
+	if err != nil {
+		return errors.Wrap(err, "check blob existence")
+	}
+	if exists {
+		return nil
+	}
+
+	uploadURL := sp.url("blobs/uploads/")
+	uploadURL.RawQuery = url.Values{"digest": []string{desc.Digest.String()}}.Encode()
+	resp, err := sp.do(ctx, http.MethodPost, uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "upload blob")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusCreated {
+		return nil
+	}
+	if err := checkStatus(resp, http.StatusAccepted); err != nil {
+		return errors.Wrap(err, "upload blob")
+	}
+
+	// The registry ignores the blob in POST without monolithic upload.
+	uploadURL, err = sp.location(resp)
+	if err != nil {
+		return errors.Wrap(err, "upload blob")
+	}
+	query := uploadURL.Query()
+	query.Set("digest", desc.Digest.String())
+	uploadURL.RawQuery = query.Encode()
+	putResp, err := sp.do(ctx, http.MethodPut, uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "commit upload")
+	}
+	defer putResp.Body.Close()
+	if err := checkStatus(putResp, http.StatusCreated, http.StatusNoContent, http.StatusOK); err != nil {
+		return errors.Wrap(err, "commit upload")
+	}
+	return nil
+}
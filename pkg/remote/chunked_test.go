@@ -0,0 +1,191 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// resumableRegistry accepts chunked blob uploads with ranges and reports
+// upload status, it fails the PATCH requests numbered in `failPatches`.
+// The POST with digest uploads the blob monolithically if `monolithic`.
+type resumableRegistry struct {
+	uploading   bytes.Buffer
+	patches     int
+	starts      int
+	puts        int
+	monolithic  bool
+	failPatches map[int]bool
+	blobs       map[digest.Digest][]byte
+}
+
+func (r *resumableRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	const location = "/v2/library/test/blobs/uploads/uuid"
+	switch {
+	case req.Method == http.MethodHead:
+		dgst := digest.Digest(strings.TrimPrefix(req.URL.Path, "/v2/library/test/blobs/"))
+		if _, ok := r.blobs[dgst]; ok {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case req.Method == http.MethodPost:
+		r.starts++
+		r.uploading.Reset()
+		if dgst := digest.Digest(req.URL.Query().Get("digest")); dgst != "" && r.monolithic {
+			data, _ := io.ReadAll(req.Body)
+			if dgst != digest.FromBytes(data) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[dgst] = data
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		_, _ = io.Copy(io.Discard, req.Body)
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodGet && req.URL.Path == location:
+		end := r.uploading.Len() - 1
+		if end < 0 {
+			end = 0
+		}
+		w.Header().Set("Range", fmt.Sprintf("0-%d", end))
+		w.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodPatch:
+		r.patches++
+		if r.failPatches[r.patches] {
+			_, _ = io.Copy(io.Discard, req.Body)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if req.Header.Get("Content-Range") != fmt.Sprintf("%d-%d", r.uploading.Len(), r.uploading.Len()+int(req.ContentLength)-1) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		_, _ = io.Copy(&r.uploading, req.Body)
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut:
+		r.puts++
+		_, _ = io.Copy(&r.uploading, req.Body)
+		dgst := digest.Digest(req.URL.Query().Get("digest"))
+		if dgst != digest.FromBytes(r.uploading.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[dgst] = append([]byte{}, r.uploading.Bytes()...)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPushChunked(t *testing.T) {
+	registry := &resumableRegistry{
+		failPatches: map[int]bool{2: true},
+		blobs:       map[digest.Digest][]byte{},
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("nydus"), int(StreamChunkSize)/5+1)
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	checkpointPath := filepath.Join(t.TempDir(), "upload.json")
+
+	// The second chunk fails, the first one is kept in checkpoint.
+	err = remoter.PushChunked(context.Background(), hostsFunc, bytes.NewReader(data), desc, checkpointPath)
+	require.Error(t, err)
+	checkpoint, err := loadCheckpoint(checkpointPath)
+	require.NoError(t, err)
+	require.Equal(t, StreamChunkSize, checkpoint.Offset)
+
+	// Resume from the second chunk.
+	err = remoter.PushChunked(context.Background(), hostsFunc, bytes.NewReader(data), desc, checkpointPath)
+	require.NoError(t, err)
+	require.Equal(t, 1, registry.starts)
+	require.Equal(t, 3, registry.patches)
+	require.Equal(t, data, registry.blobs[desc.Digest])
+	_, err = os.Stat(checkpointPath)
+	require.True(t, os.IsNotExist(err))
+
+	// Skip the existing blob.
+	err = remoter.PushChunked(context.Background(), hostsFunc, bytes.NewReader(data), desc, checkpointPath)
+	require.NoError(t, err)
+	require.Equal(t, 3, registry.patches)
+}
+
+func TestPushBytes(t *testing.T) {
+	registry := &resumableRegistry{blobs: map[digest.Digest][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+
+	// The blob is put to the upload session without monolithic upload.
+	data := []byte("small blob")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+	require.NoError(t, remoter.PushBytes(context.Background(), hostsFunc, data, desc))
+	require.Equal(t, data, registry.blobs[desc.Digest])
+	require.Equal(t, 1, registry.starts)
+	require.Equal(t, 1, registry.puts)
+
+	// Skip the existing blob.
+	require.NoError(t, remoter.PushBytes(context.Background(), hostsFunc, data, desc))
+	require.Equal(t, 1, registry.starts)
+
+	// The blob is pushed by a single POST with monolithic upload.
+	registry.monolithic = true
+	other := []byte("other small blob")
+	desc = ocispec.Descriptor{Digest: digest.FromBytes(other), Size: int64(len(other))}
+	require.NoError(t, remoter.PushBytes(context.Background(), hostsFunc, other, desc))
+	require.Equal(t, other, registry.blobs[desc.Digest])
+	require.Equal(t, 2, registry.starts)
+	require.Equal(t, 1, registry.puts)
+}
+
+func TestParseRange(t *testing.T) {
+	offset, err := parseRange("0-0")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+	offset, err = parseRange("0-1023")
+	require.NoError(t, err)
+	require.Equal(t, int64(1024), offset)
+	_, err = parseRange("1-1023")
+	require.Error(t, err)
+	_, err = parseRange("bytes=0-1023")
+	require.Error(t, err)
+}
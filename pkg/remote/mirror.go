@@ -0,0 +1,187 @@
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	goerrors "errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/sirupsen/logrus"
+)
+
+// Mirrors configures the mirrors of registries, the mirrors of a registry
+// are tried in order before the registry itself. A mirror is skipped for
+// the rest of the process once it fails with a connection error or 5xx.
+type Mirrors struct {
+	// Endpoints maps registry host, e.g. `docker.io`, to the endpoints of
+	// its mirrors, endpoint without scheme uses https.
+	Endpoints map[string][]string
+	// Push pushes to the mirrors too, otherwise mirrors are only used by
+	// pulls.
+	Push bool
+	// TLSConfigs are the TLS configs keyed by mirror host, the mirrors not
+	// in it are verified by system CAs.
+	TLSConfigs map[string]*tls.Config
+	// Proxy of requests to mirrors, the proxy from environment is used if
+	// nil.
+	Proxy ProxyFunc
+
+	mutex sync.Mutex
+	down  map[string]bool
+	// failures counts the mirrors marked down.
+	failures int
+}
+
+type mirror struct {
+	scheme string
+	host   string
+	path   string
+}
+
+func parseMirror(endpoint string) (*mirror, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %s", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("empty host")
+	}
+	return &mirror{
+		scheme: parsed.Scheme,
+		host:   parsed.Host,
+		path:   path.Join("/", parsed.Path, "v2"),
+	}, nil
+}
+
+// Validate checks the endpoints of all mirrors.
+func (m *Mirrors) Validate() error {
+	for host, endpoints := range m.Endpoints {
+		for _, endpoint := range endpoints {
+			if _, err := parseMirror(endpoint); err != nil {
+				return fmt.Errorf("invalid mirror %q of %s: %s", endpoint, host, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Failures returns the count of mirrors failed so far, a changed count
+// after a failed request means it may succeed on the next host.
+func (m *Mirrors) Failures() int {
+	if m == nil {
+		return 0
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.failures
+}
+
+func (m *Mirrors) isDown(host string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.down[host]
+}
+
+func (m *Mirrors) markDown(host string, reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.down[host] {
+		return
+	}
+	if m.down == nil {
+		m.down = map[string]bool{}
+	}
+	m.down[host] = true
+	m.failures++
+	logrus.Warnf("mirror %s is down: %s, failover to next host", host, reason)
+}
+
+// failoverTransport marks the mirror down on connection errors and 5xx.
+type failoverTransport struct {
+	mirrors *Mirrors
+	host    string
+	next    http.RoundTripper
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		if !goerrors.Is(err, context.Canceled) {
+			t.mirrors.markDown(t.host, err.Error())
+		}
+		return resp, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		t.mirrors.markDown(t.host, resp.Status)
+	}
+	return resp, nil
+}
+
+// RegistryHosts returns the registry hosts with the mirrors which are not
+// down placed before the hosts of `registryHosts`. The mirrors request by
+// their own clients with TLS configs of mirror hosts, which are created
+// once and shared by requests.
+func (m *Mirrors) RegistryHosts(registryHosts docker.RegistryHosts) docker.RegistryHosts {
+	if m == nil || len(m.Endpoints) == 0 {
+		return registryHosts
+	}
+	var mutex sync.Mutex
+	clients := map[string]*http.Client{}
+
+	return func(host string) ([]docker.RegistryHost, error) {
+		hosts, err := registryHosts(host)
+		if err != nil || len(hosts) == 0 {
+			return hosts, err
+		}
+
+		capabilities := docker.HostCapabilityPull | docker.HostCapabilityResolve
+		if m.Push {
+			capabilities |= docker.HostCapabilityPush
+		}
+		mirrorHosts := []docker.RegistryHost{}
+		for _, endpoint := range m.Endpoints[host] {
+			parsed, err := parseMirror(endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mirror %q of %s: %s", endpoint, host, err)
+			}
+			if m.isDown(parsed.host) {
+				continue
+			}
+
+			mutex.Lock()
+			client, ok := clients[parsed.host]
+			if !ok {
+				tlsConfig, ok := m.TLSConfigs[parsed.host]
+				if !ok {
+					tlsConfig = &tls.Config{}
+				}
+				client = newDefaultClient(tlsConfig, m.Proxy)
+				client.Transport = &failoverTransport{mirrors: m, host: parsed.host, next: client.Transport}
+				clients[parsed.host] = client
+			}
+			mutex.Unlock()
+
+			mirrorHost := hosts[0]
+			mirrorHost.Scheme = parsed.scheme
+			mirrorHost.Host = parsed.host
+			mirrorHost.Path = parsed.path
+			mirrorHost.Capabilities = capabilities
+			mirrorHost.Client = client
+			mirrorHosts = append(mirrorHosts, mirrorHost)
+		}
+
+		return append(mirrorHosts, hosts...), nil
+	}
+}
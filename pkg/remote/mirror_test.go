@@ -0,0 +1,168 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// manifestRegistry serves the manifests pushed to it.
+type manifestRegistry struct {
+	mutex     sync.Mutex
+	manifests map[string][]byte
+	requests  int
+}
+
+func (r *manifestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests++
+
+	if req.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	_, reference, ok := strings.Cut(req.URL.Path, "/manifests/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		r.manifests[reference] = data
+		r.manifests[digest.FromBytes(data).String()] = data
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodHead, http.MethodGet:
+		data, ok := r.manifests[reference]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	}
+}
+
+func TestParseMirror(t *testing.T) {
+	parsed, err := parseMirror("mirror.example.com")
+	require.NoError(t, err)
+	require.Equal(t, &mirror{scheme: "https", host: "mirror.example.com", path: "/v2"}, parsed)
+
+	parsed, err = parseMirror("http://127.0.0.1:5000/proxy")
+	require.NoError(t, err)
+	require.Equal(t, &mirror{scheme: "http", host: "127.0.0.1:5000", path: "/proxy/v2"}, parsed)
+
+	_, err = parseMirror("ftp://mirror.example.com")
+	require.Error(t, err)
+
+	mirrors := Mirrors{Endpoints: map[string][]string{"docker.io": {"https://"}}}
+	require.Error(t, mirrors.Validate())
+}
+
+func TestMirrorsFailover(t *testing.T) {
+	upstream := &manifestRegistry{manifests: map[string][]byte{}}
+	upstreamServer := httptest.NewServer(upstream)
+	defer upstreamServer.Close()
+	host := strings.TrimPrefix(upstreamServer.URL, "http://")
+
+	broken := &manifestRegistry{}
+	brokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		broken.mutex.Lock()
+		broken.requests++
+		broken.mutex.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer brokenServer.Close()
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableServer.Close()
+
+	mirrors := &Mirrors{
+		Endpoints: map[string][]string{
+			host: {unreachableServer.URL, brokenServer.URL},
+		},
+		Push: true,
+	}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolverWithMirrors(true, true, nil, mirrors)
+	})
+	require.NoError(t, err)
+	remoter.WithMirrors(mirrors)
+
+	// Both mirrors fail, the push goes to upstream.
+	manifest := []byte(`{"schemaVersion":2}`)
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	require.NoError(t, remoter.Push(context.Background(), desc, false, bytes.NewReader(manifest)))
+	require.Equal(t, manifest, upstream.manifests["latest"])
+	require.Equal(t, 2, mirrors.Failures())
+	brokenRequests := broken.requests
+
+	// The failed mirrors are skipped.
+	resolved, err := remoter.Resolve(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, desc.Digest, resolved.Digest)
+	require.Equal(t, brokenRequests, broken.requests)
+}
+
+func TestMirrorsTLS(t *testing.T) {
+	upstream := &manifestRegistry{manifests: map[string][]byte{}}
+	upstreamServer := httptest.NewServer(upstream)
+	defer upstreamServer.Close()
+	host := strings.TrimPrefix(upstreamServer.URL, "http://")
+
+	manifest := []byte(`{"schemaVersion":2}`)
+	mirror := &manifestRegistry{manifests: map[string][]byte{"latest": manifest}}
+	mirrorServer := httptest.NewTLSServer(mirror)
+	defer mirrorServer.Close()
+	mirrorHost := strings.TrimPrefix(mirrorServer.URL, "https://")
+	pool := x509.NewCertPool()
+	pool.AddCert(mirrorServer.Certificate())
+
+	resolve := func(tlsConfigs map[string]*tls.Config) (*Mirrors, error) {
+		mirrors := &Mirrors{
+			Endpoints:  map[string][]string{host: {mirrorServer.URL}},
+			TLSConfigs: tlsConfigs,
+		}
+		remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+			// The registry skips verifying, which isn't shared by mirrors.
+			return NewResolverWithMirrors(true, true, nil, mirrors)
+		})
+		require.NoError(t, err)
+		remoter.WithMirrors(mirrors)
+		_, err = remoter.Resolve(context.Background())
+		return mirrors, err
+	}
+
+	// The certificate of mirror is verified by system CAs, the resolve
+	// fails over to upstream not having the manifest.
+	mirrors, err := resolve(nil)
+	require.Error(t, err)
+	require.Equal(t, 1, mirrors.Failures())
+
+	mirrors, err = resolve(map[string]*tls.Config{mirrorHost: {RootCAs: pool}})
+	require.NoError(t, err)
+	require.Zero(t, mirrors.Failures())
+}
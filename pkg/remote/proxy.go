@@ -0,0 +1,62 @@
+package remote
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyFunc returns the proxy of request, or nil if requested directly.
+type ProxyFunc = func(req *http.Request) (*url.URL, error)
+
+// NewProxyFunc returns the proxy func honoring HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. If `proxy` is not empty, it's used for
+// both http and https requests instead of the ones in environment, but the
+// hosts in NO_PROXY are still requested directly.
+func NewProxyFunc(proxy string) (ProxyFunc, error) {
+	cfg := httpproxy.FromEnvironment()
+	if proxy != "" {
+		if err := validateProxy(proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %s", proxy, err)
+		}
+		cfg.HTTPProxy = proxy
+		cfg.HTTPSProxy = proxy
+	}
+	proxyFunc := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("no host")
+	}
+	return nil
+}
+
+// ProxyURL returns the proxy of requests to `endpoint` by `proxyFunc`, or
+// nil if requested directly. The endpoint without scheme is requested by
+// http.
+func ProxyURL(proxyFunc ProxyFunc, endpoint string) (*url.URL, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return proxyFunc(&http.Request{URL: u})
+}
@@ -0,0 +1,254 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/containerd/containerd/errdefs"
+	containerdReference "github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ReferrersTag returns the tag of index listing the referrers of `subject`
+// in registries not supporting referrers API, see
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md#referrers-tag-schema.
+func ReferrersTag(subject digest.Digest) string {
+	alg, encoded := subject.Algorithm().String(), subject.Encoded()
+	if len(alg) > 32 {
+		alg = alg[:32]
+	}
+	if len(encoded) > 64 {
+		encoded = encoded[:64]
+	}
+	return alg + "-" + encoded
+}
+
+// PushReferrer pushes manifest `data` of artifact `desc` referring to
+// manifest `subject`. If the registry doesn't support referrers API, the
+// artifact is added into the index tagged by ReferrersTag of subject, so
+// it's still discoverable. The ArtifactType and Annotations of `desc` are
+// kept in the index.
+func (remote *Remote) PushReferrer(ctx context.Context, hostsFunc HostsFunc, desc ocispec.Descriptor, data []byte, subject digest.Digest) error {
+	if err := remote.Push(ctx, desc, true, bytes.NewReader(data)); err != nil {
+		return errors.Wrap(err, "push referrer manifest")
+	}
+
+	supported, err := remote.SupportsReferrers(ctx, hostsFunc, subject)
+	if err != nil {
+		return errors.Wrap(err, "check referrers API")
+	}
+	if supported {
+		return nil
+	}
+	logrus.Debugf("referrers API is unsupported by %s, falling back to tag %s", reference.Domain(remote.parsed), ReferrersTag(subject))
+	return errors.Wrap(remote.addReferrerToTag(ctx, desc, subject), "add referrer to fallback tag")
+}
+
+// SupportsReferrers checks whether the registry supports referrers API by
+// listing the referrers of `subject`.
+func (remote *Remote) SupportsReferrers(ctx context.Context, hostsFunc HostsFunc, subject digest.Digest) (bool, error) {
+	refspec, err := containerdReference.Parse(remote.parsed.Name())
+	if err != nil {
+		return false, errors.Wrap(err, "parse reference")
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, false)
+	if err != nil {
+		return false, errors.Wrap(err, "set repository scope")
+	}
+
+	supported, err := remote.supportsReferrers(ctx, hostsFunc, subject)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		supported, err = remote.supportsReferrers(ctx, hostsFunc, subject)
+	}
+	return supported, err
+}
+
+func (remote *Remote) supportsReferrers(ctx context.Context, hostsFunc HostsFunc, subject digest.Digest) (bool, error) {
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return false, err
+	}
+	resp, err := sp.doRequest(ctx, http.MethodGet, sp.url("referrers/"+subject.String()), nil, func(req *http.Request) {
+		req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+	})
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	// The registries not supporting it respond 404 by spec, or others for
+	// the unknown route.
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// Referrers returns the artifacts of `artifactType` referring to manifest
+// `subject`, or all of them if `artifactType` is empty. They are listed by
+// referrers API of registry, or by the index tagged by ReferrersTag of
+// subject if the API is unsupported.
+func (remote *Remote) Referrers(ctx context.Context, hostsFunc HostsFunc, subject digest.Digest, artifactType string) ([]ocispec.Descriptor, error) {
+	refspec, err := containerdReference.Parse(remote.parsed.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse reference")
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "set repository scope")
+	}
+
+	manifests, supported, err := remote.listReferrers(ctx, hostsFunc, subject)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		manifests, supported, err = remote.listReferrers(ctx, hostsFunc, subject)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "list referrers")
+	}
+	if !supported {
+		tagRemote, err := remote.referrersTagRemote(subject)
+		if err != nil {
+			return nil, err
+		}
+		index, err := tagRemote.pullReferrersIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		manifests = index.Manifests
+	}
+
+	// The filter of artifact type is optional for registries.
+	referrers := []ocispec.Descriptor{}
+	for _, manifest := range manifests {
+		if artifactType == "" || manifest.ArtifactType == artifactType {
+			referrers = append(referrers, manifest)
+		}
+	}
+	return referrers, nil
+}
+
+// listReferrers lists the referrers of `subject` by referrers API, the
+// pages are followed by the Link header. It returns false if the API is
+// unsupported by registry.
+func (remote *Remote) listReferrers(ctx context.Context, hostsFunc HostsFunc, subject digest.Digest) ([]ocispec.Descriptor, bool, error) {
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return nil, false, err
+	}
+
+	manifests := []ocispec.Descriptor{}
+	for u := sp.url("referrers/" + subject.String()); u != nil; {
+		resp, err := sp.doRequest(ctx, http.MethodGet, u, nil, func(req *http.Request) {
+			req.Header.Set("Accept", ocispec.MediaTypeImageIndex)
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		// Same with supportsReferrers.
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, false, nil
+		}
+		index := ocispec.Index{}
+		err = json.NewDecoder(resp.Body).Decode(&index)
+		resp.Body.Close()
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "decode referrers from %s", u.Redacted())
+		}
+		manifests = append(manifests, index.Manifests...)
+
+		if u, err = nextLink(resp); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return manifests, true, nil
+}
+
+// referrersTagRemote returns the remote of ReferrersTag of `subject` in
+// the repository of remote.
+func (remote *Remote) referrersTagRemote(subject digest.Digest) (*Remote, error) {
+	tagged, err := reference.WithTag(reference.TrimNamed(remote.parsed), ReferrersTag(subject))
+	if err != nil {
+		return nil, errors.Wrap(err, "make referrers tag")
+	}
+	return &Remote{
+		Ref:           tagged.String(),
+		parsed:        tagged,
+		resolverFunc:  remote.resolverFunc,
+		mirrors:       remote.mirrors,
+		schemes:       remote.schemes,
+		retryWithHTTP: remote.retryWithHTTP,
+	}, nil
+}
+
+// pullReferrersIndex pulls the referrers index tagged by the remote, an
+// empty index is returned if not exists.
+func (remote *Remote) pullReferrersIndex(ctx context.Context) (*ocispec.Index, error) {
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+	}
+	indexDesc, err := remote.Resolve(ctx)
+	if errdefs.IsNotFound(err) {
+		return &index, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve referrers index")
+	}
+	rc, err := remote.Pull(ctx, *indexDesc, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull referrers index")
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read referrers index")
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "unmarshal referrers index")
+	}
+	return &index, nil
+}
+
+// addReferrerToTag adds artifact `desc` into the index tagged by the
+// referrers tag of `subject`, the index is created if not exists.
+func (remote *Remote) addReferrerToTag(ctx context.Context, desc ocispec.Descriptor, subject digest.Digest) error {
+	tagRemote, err := remote.referrersTagRemote(subject)
+	if err != nil {
+		return err
+	}
+	index, err := tagRemote.pullReferrersIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range index.Manifests {
+		if manifest.Digest == desc.Digest {
+			return nil
+		}
+	}
+	index.Manifests = append(index.Manifests, ocispec.Descriptor{
+		MediaType:    desc.MediaType,
+		Digest:       desc.Digest,
+		Size:         desc.Size,
+		ArtifactType: desc.ArtifactType,
+		Annotations:  desc.Annotations,
+	})
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "marshal referrers index")
+	}
+	newDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	return tagRemote.Push(ctx, newDesc, false, bytes.NewReader(data))
+}
@@ -0,0 +1,99 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferrersTag(t *testing.T) {
+	subject := digest.FromString("manifest")
+	require.Equal(t, "sha256-"+subject.Encoded(), ReferrersTag(subject))
+}
+
+func TestPushReferrer(t *testing.T) {
+	subject := digest.FromString("subject")
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	artifact := func(content string) (ocispec.Descriptor, []byte) {
+		data := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{},"layers":[],"annotations":{"content":"` + content + `"}}`)
+		return ocispec.Descriptor{
+			MediaType:    ocispec.MediaTypeImageManifest,
+			Digest:       digest.FromBytes(data),
+			Size:         int64(len(data)),
+			ArtifactType: "application/vnd.example+json",
+		}, data
+	}
+
+	for _, supported := range []bool{true, false} {
+		registry := &manifestRegistry{manifests: map[string][]byte{}}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if supported && strings.HasPrefix(req.URL.Path, "/v2/library/test/referrers/") {
+				w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+				_ = json.NewEncoder(w).Encode(ocispec.Index{MediaType: ocispec.MediaTypeImageIndex})
+				return
+			}
+			registry.ServeHTTP(w, req)
+		}))
+		manifests := registry.manifests
+		host := strings.TrimPrefix(server.URL, "http://")
+		remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+			return NewResolver(true, true, nil)
+		})
+		require.NoError(t, err)
+
+		desc1, data1 := artifact("a")
+		desc2, data2 := artifact("b")
+		for _, desc := range []ocispec.Descriptor{desc1, desc2, desc1} {
+			data := data1
+			if desc.Digest == desc2.Digest {
+				data = data2
+			}
+			require.NoError(t, remoter.PushReferrer(context.Background(), hostsFunc, desc, data, subject))
+		}
+		require.Contains(t, manifests, desc1.Digest.String())
+		require.Contains(t, manifests, desc2.Digest.String())
+
+		fallback, ok := manifests[ReferrersTag(subject)]
+		if supported {
+			require.False(t, ok)
+		} else {
+			require.True(t, ok)
+			index := ocispec.Index{}
+			require.NoError(t, json.Unmarshal(fallback, &index))
+			require.Len(t, index.Manifests, 2)
+			require.Equal(t, desc1.Digest, index.Manifests[0].Digest)
+			require.Equal(t, desc2.Digest, index.Manifests[1].Digest)
+			require.Equal(t, "application/vnd.example+json", index.Manifests[0].ArtifactType)
+		}
+
+		referrers, err := remoter.Referrers(context.Background(), hostsFunc, subject, "application/vnd.example+json")
+		require.NoError(t, err)
+		if supported {
+			// The referrers API of server always responds an empty index.
+			require.Empty(t, referrers)
+		} else {
+			require.Len(t, referrers, 2)
+			require.Equal(t, desc2.Digest, referrers[1].Digest)
+		}
+		referrers, err = remoter.Referrers(context.Background(), hostsFunc, subject, "application/vnd.other+json")
+		require.NoError(t, err)
+		require.Empty(t, referrers)
+		referrers, err = remoter.Referrers(context.Background(), hostsFunc, digest.FromString("other"), "")
+		require.NoError(t, err)
+		require.Empty(t, referrers)
+		server.Close()
+	}
+}
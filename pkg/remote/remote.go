@@ -30,6 +30,11 @@ type Remote struct {
 	// new resolver instance using resolverFunc for each request.
 	resolverFunc func(insecure bool) remotes.Resolver
 	pushed       sync.Map
+	// Set if the resolver uses mirrors, to push again on the next host
+	// after a mirror failed.
+	mirrors *Mirrors
+	// Set if the schemes of registry hosts are negotiated instead.
+	schemes *Schemes
 
 	retryWithHTTP bool
 }
@@ -48,7 +53,26 @@ func New(ref string, resolverFunc func(bool) remotes.Resolver) (*Remote, error)
 	}, nil
 }
 
-func (remote *Remote) MaybeWithHTTP(err error) {
+// WithMirrors sets the mirrors used by resolver of remote.
+func (remote *Remote) WithMirrors(mirrors *Mirrors) *Remote {
+	remote.mirrors = mirrors
+	return remote
+}
+
+// WithSchemes sets the schemes negotiated for registry hosts, the requests
+// are not retried with plain HTTP by guessing from errors then.
+func (remote *Remote) WithSchemes(schemes *Schemes) *Remote {
+	remote.schemes = schemes
+	return remote
+}
+
+// MaybeWithHTTP switches the requests to plain HTTP if `err` implies that
+// the registry serves plain HTTP, and returns whether it's switched so the
+// failed request should be retried.
+func (remote *Remote) MaybeWithHTTP(err error) bool {
+	if remote.schemes != nil || remote.retryWithHTTP || !RetryWithHTTP(err) {
+		return false
+	}
 	parsed, _ := reference.ParseNormalizedNamed(remote.Ref)
 	if parsed != nil {
 		host := reference.Domain(parsed)
@@ -56,16 +80,36 @@ func (remote *Remote) MaybeWithHTTP(err error) {
 		// implies that we can retry the request with plain HTTP.
 		if strings.Contains(err.Error(), fmt.Sprintf("/%s/", host)) {
 			remote.retryWithHTTP = true
+			return true
 		}
 	}
+	return false
 }
 
 func (remote *Remote) IsWithHTTP() bool {
 	return remote.retryWithHTTP
 }
 
-// Push pushes blob to registry
+// Push pushes blob to registry, the push failed on a mirror is retried on
+// the next host if `reader` is seekable.
 func (remote *Remote) Push(ctx context.Context, desc ocispec.Descriptor, byDigest bool, reader io.Reader) error {
+	for {
+		failures := remote.mirrors.Failures()
+		err := remote.push(ctx, desc, byDigest, reader)
+		if err == nil || remote.mirrors.Failures() == failures {
+			return err
+		}
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return err
+		}
+		if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+			return err
+		}
+	}
+}
+
+func (remote *Remote) push(ctx context.Context, desc ocispec.Descriptor, byDigest bool, reader io.Reader) error {
 	// Concurrently push blob with same digest using containerd
 	// docker remote client will cause error:
 	// `failed commit on ref: unexpected size x, expected y`
@@ -117,8 +161,7 @@ func (remote *Remote) Pull(ctx context.Context, desc ocispec.Descriptor, byDiges
 
 	reader, err := puller.Fetch(ctx, desc)
 	if err != nil {
-		if RetryWithHTTP(err) {
-			remote.MaybeWithHTTP(err)
+		if remote.MaybeWithHTTP(err) {
 			return remote.Pull(ctx, desc, byDigest)
 		}
 		return nil, err
@@ -134,8 +177,7 @@ func (remote *Remote) Resolve(ctx context.Context) (*ocispec.Descriptor, error)
 	// Create a new resolver instance for the request
 	_, desc, err := remote.resolverFunc(remote.retryWithHTTP).Resolve(ctx, ref)
 	if err != nil {
-		if RetryWithHTTP(err) {
-			remote.MaybeWithHTTP(err)
+		if remote.MaybeWithHTTP(err) {
 			return remote.Resolve(ctx)
 		}
 		return nil, err
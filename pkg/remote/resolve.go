@@ -19,21 +19,36 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	dockerconfig "github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/pkg/errors"
 )
 
 // We always use multipart upload for backend, and limit the
 // multipart chunk size to 500MB by default.
 const ChunkSize int64 = 500 * 1024 * 1024
 
-func newDefaultClient(skipTLSVerify bool) *http.Client {
+// NewClient returns the client of the requests out of registries, e.g.
+// the downloads of releases, which is the one of registries.
+func NewClient(tlsConfig *tls.Config, proxy ProxyFunc) *http.Client {
+	return newDefaultClient(tlsConfig, proxy)
+}
+
+// newDefaultClient returns the client requesting through `proxy`, or the
+// proxy from environment if `proxy` is nil.
+func newDefaultClient(tlsConfig *tls.Config, proxy ProxyFunc) *http.Client {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
 	return &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+		Transport: NewTracingTransport(&http.Transport{
+			Proxy: proxy,
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
@@ -45,10 +60,8 @@ func newDefaultClient(skipTLSVerify bool) *http.Client {
 			ExpectContinueTimeout: 5 * time.Second,
 			DisableKeepAlives:     true,
 			TLSNextProto:          make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipTLSVerify,
-			},
-		},
+			TLSClientConfig:       tlsConfig,
+		}),
 	}
 }
 
@@ -60,9 +73,15 @@ type CredentialFunc = func(string) (string, string, error)
 // CredentialFunc, insecure and error.
 type HostFunc = func(ref string) (CredentialFunc, bool, error)
 
-// NewDockerConfigCredFunc attempts to read docker auth config file `$DOCKER_CONFIG/config.json`
-// to communicate with remote registry, `$DOCKER_CONFIG` defaults to `~/.docker`.
-func NewDockerConfigCredFunc() CredentialFunc {
+// NewDockerConfigCredFunc attempts to read docker auth config file `config.json`
+// in `dir` to communicate with remote registry, `dir` defaults to `$DOCKER_CONFIG`
+// or `~/.docker` if empty. The credentials in the credsStore and credHelpers of
+// config, e.g. pass, osxkeychain and wincred, are resolved by their helpers and
+// cached by host, as each helper call execs a binary.
+func NewDockerConfigCredFunc(dir string) CredentialFunc {
+	var mutex sync.Mutex
+	cache := map[string]types.AuthConfig{}
+
 	return func(host string) (string, string, error) {
 		// The host of docker hub image will be converted to `registry-1.docker.io` in:
 		// github.com/containerd/containerd/remotes/docker/registry.go
@@ -71,30 +90,126 @@ func NewDockerConfigCredFunc() CredentialFunc {
 			host = "https://index.docker.io/v1/"
 		}
 
-		config := dockerconfig.LoadDefaultConfigFile(os.Stderr)
-		authConfig, err := config.GetAuthConfig(host)
-		if err != nil {
-			return "", "", err
+		mutex.Lock()
+		defer mutex.Unlock()
+		authConfig, ok := cache[host]
+		if !ok {
+			config, err := loadDockerConfig(dir)
+			if err != nil {
+				return "", "", errors.Wrap(err, "load docker config")
+			}
+			if authConfig, err = config.GetAuthConfig(host); err != nil {
+				return "", "", errors.Wrapf(err, "get credentials of %s from docker config", host)
+			}
+			cache[host] = authConfig
 		}
 
+		// The empty username makes the identity token used as refresh token.
+		if authConfig.IdentityToken != "" {
+			return "", authConfig.IdentityToken, nil
+		}
 		return authConfig.Username, authConfig.Password, nil
 	}
 }
 
+// loadDockerConfig loads the docker config in `dir`, or the default one if
+// `dir` is empty.
+func loadDockerConfig(dir string) (*configfile.ConfigFile, error) {
+	if dir == "" {
+		return dockerconfig.LoadDefaultConfigFile(os.Stderr), nil
+	}
+	return dockerconfig.Load(dir)
+}
+
+// NewRegistryHosts returns the registry hosts configuration used by
+// NewResolver.
+func NewRegistryHosts(insecure, plainHTTP bool, credFunc CredentialFunc) docker.RegistryHosts {
+	return NewRegistryHostsWithOptions(RegistryOptions{
+		Insecure:  insecure,
+		PlainHTTP: plainHTTP,
+		CredFunc:  credFunc,
+	})
+}
+
+// RegistryOptions configures the requests to registry hosts.
+type RegistryOptions struct {
+	// Insecure skips verifying the certificates of hosts not in TLSConfigs.
+	Insecure  bool
+	PlainHTTP bool
+	CredFunc  CredentialFunc
+	// Proxy of requests, the proxy from environment is used if nil.
+	Proxy ProxyFunc
+	// TLSConfigs are the TLS configs keyed by registry host, which are
+	// used instead of the insecure one.
+	TLSConfigs map[string]*tls.Config
+	// Schemes decides the scheme of hosts instead of PlainHTTP if set.
+	Schemes *Schemes
+	// TokenFunc returns the bearer token sent to hosts as is instead of
+	// the tokens exchanged for the credentials of CredFunc if set.
+	TokenFunc TokenFunc
+}
+
+// NewRegistryHostsWithOptions returns the registry hosts configured by
+// `opts`, the hosts of a registry are created once and shared by requests
+// so that the auth tokens are reused.
+func NewRegistryHostsWithOptions(opts RegistryOptions) docker.RegistryHosts {
+	var mutex sync.Mutex
+	registries := map[string]docker.RegistryHosts{}
+
+	return func(host string) ([]docker.RegistryHost, error) {
+		mutex.Lock()
+		registryHosts, ok := registries[host]
+		if !ok {
+			tlsConfig, ok := opts.TLSConfigs[host]
+			if !ok {
+				tlsConfig = &tls.Config{
+					InsecureSkipVerify: opts.Insecure,
+				}
+			}
+			var authorizer docker.Authorizer
+			if opts.TokenFunc != nil {
+				authorizer = newBearerAuthorizer(opts.TokenFunc)
+			} else {
+				authClient := newDefaultClient(tlsConfig, opts.Proxy)
+				authorizer = newRefreshingAuthorizer(func() docker.Authorizer {
+					return docker.NewDockerAuthorizer(
+						docker.WithAuthClient(authClient),
+						docker.WithAuthCreds(opts.CredFunc),
+					)
+				})
+			}
+			registryHosts = docker.ConfigureDefaultRegistries(
+				docker.WithAuthorizer(authorizer),
+				docker.WithClient(newDefaultClient(tlsConfig, opts.Proxy)),
+				docker.WithPlainHTTP(func(host string) (bool, error) {
+					if opts.Schemes != nil {
+						return opts.Schemes.PlainHTTP(host)
+					}
+					return opts.PlainHTTP, nil
+				}),
+				docker.WithChunkSize(ChunkSize),
+			)
+			registries[host] = registryHosts
+		}
+		mutex.Unlock()
+
+		return registryHosts(host)
+	}
+}
+
 func NewResolver(insecure, plainHTTP bool, credFunc CredentialFunc) remotes.Resolver {
-	registryHosts := docker.ConfigureDefaultRegistries(
-		docker.WithAuthorizer(docker.NewDockerAuthorizer(
-			docker.WithAuthClient(newDefaultClient(insecure)),
-			docker.WithAuthCreds(credFunc),
-		)),
-		docker.WithClient(newDefaultClient(insecure)),
-		docker.WithPlainHTTP(func(host string) (bool, error) {
-			return plainHTTP, nil
-		}),
-		docker.WithChunkSize(ChunkSize),
-	)
+	return NewResolverWithMirrors(insecure, plainHTTP, credFunc, nil)
+}
+
+// NewResolverWithMirrors returns the resolver trying `mirrors` before the
+// registry, `mirrors` may be nil.
+func NewResolverWithMirrors(insecure, plainHTTP bool, credFunc CredentialFunc, mirrors *Mirrors) remotes.Resolver {
+	return NewResolverWithHosts(mirrors.RegistryHosts(NewRegistryHosts(insecure, plainHTTP, credFunc)))
+}
 
+// NewResolverWithHosts returns the resolver requesting registry `hosts`.
+func NewResolverWithHosts(hosts docker.RegistryHosts) remotes.Resolver {
 	return docker.NewResolver(docker.ResolverOptions{
-		Hosts: registryHosts,
+		Hosts: hosts,
 	})
 }
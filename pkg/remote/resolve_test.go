@@ -0,0 +1,73 @@
+package remote
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerConfigCredFunc(t *testing.T) {
+	// The credential helper `docker-credential-fake` counts its calls.
+	binDir := t.TempDir()
+	calls := filepath.Join(binDir, "calls")
+	helper := `#!/bin/sh
+echo >> ` + calls + `
+read host
+if [ "$host" = "keychain.example.com" ]; then
+  echo '{"ServerURL": "keychain.example.com", "Username": "keychain", "Secret": "secret"}'
+elif [ "$host" = "token.example.com" ]; then
+  echo '{"ServerURL": "token.example.com", "Username": "<token>", "Secret": "identity-token"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi
+`
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-fake"), []byte(helper), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	configDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "aHViOnBhc3N3b3Jk"}
+  },
+  "credHelpers": {
+    "keychain.example.com": "fake",
+    "token.example.com": "fake",
+    "missing.example.com": "fake"
+  }
+}`), 0600))
+	credFunc := NewDockerConfigCredFunc(configDir)
+
+	username, password, err := credFunc("registry-1.docker.io")
+	require.NoError(t, err)
+	require.Equal(t, "hub", username)
+	require.Equal(t, "password", password)
+
+	for i := 0; i < 2; i++ {
+		username, password, err = credFunc("keychain.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "keychain", username)
+		require.Equal(t, "secret", password)
+	}
+	// The identity token is the refresh token.
+	username, password, err = credFunc("token.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "", username)
+	require.Equal(t, "identity-token", password)
+	// Not found in keychain is anonymous.
+	username, password, err = credFunc("missing.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "", username)
+	require.Equal(t, "", password)
+
+	// The helper is called once by host.
+	data, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	require.Len(t, data, 3)
+
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{`), 0600))
+	_, _, err = NewDockerConfigCredFunc(configDir)("registry.example.com")
+	require.ErrorContains(t, err, "load docker config")
+}
@@ -0,0 +1,127 @@
+package remote
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The insecure settings of registry host.
+const (
+	// InsecureTrue requests the registry by plain HTTP.
+	InsecureTrue = "true"
+	// InsecureFalse requests the registry by HTTPS only.
+	InsecureFalse = "false"
+	// InsecureAuto negotiates the scheme of registry by probing whether it
+	// serves TLS, it's the default.
+	InsecureAuto = "auto"
+)
+
+const probeTimeout = 10 * time.Second
+
+// Schemes decides whether the registry hosts are requested by plain HTTP
+// from their insecure settings, the scheme of host in auto is negotiated
+// once and cached for the run.
+type Schemes struct {
+	// Insecure maps registry host to its insecure setting, the hosts not
+	// in it are auto.
+	Insecure map[string]string
+	// Proxy of requests, the hosts requested by proxy are not probed and
+	// assumed to serve HTTPS in auto.
+	Proxy ProxyFunc
+
+	mutex      sync.Mutex
+	negotiated map[string]bool
+	// probe returns whether host serves plain HTTP, replaceable in tests.
+	probe func(host string) (bool, error)
+}
+
+// Validate checks the insecure settings.
+func (s *Schemes) Validate() error {
+	for host, insecure := range s.Insecure {
+		switch insecure {
+		case "", InsecureTrue, InsecureFalse, InsecureAuto:
+		default:
+			return fmt.Errorf("invalid insecure %q of %s, expected one of true, false and auto", insecure, host)
+		}
+	}
+	return nil
+}
+
+// PlainHTTP returns whether `host` is requested by plain HTTP.
+func (s *Schemes) PlainHTTP(host string) (bool, error) {
+	switch s.Insecure[host] {
+	case InsecureTrue:
+		return true, nil
+	case InsecureFalse:
+		return false, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if plainHTTP, ok := s.negotiated[host]; ok {
+		return plainHTTP, nil
+	}
+	if s.Proxy != nil {
+		proxyURL, err := ProxyURL(s.Proxy, "https://"+host)
+		if err == nil && proxyURL != nil {
+			return false, nil
+		}
+	}
+
+	probe := s.probe
+	if probe == nil {
+		probe = probeTLS
+	}
+	plainHTTP, err := probe(host)
+	if err != nil {
+		// Not cached, so the next request probes again.
+		logrus.WithError(err).Warnf("negotiate scheme of %s, fallback to https", host)
+		return false, nil
+	}
+	if s.negotiated == nil {
+		s.negotiated = map[string]bool{}
+	}
+	s.negotiated[host] = plainHTTP
+	if plainHTTP {
+		logrus.Infof("negotiated plain http for %s", host)
+	}
+	return plainHTTP, nil
+}
+
+// probeTLS returns whether `host` serves plain HTTP instead of TLS, by the
+// handshake error instead of error message.
+func probeTLS(host string) (bool, error) {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "443")
+	}
+	dialer := &net.Dialer{Timeout: probeTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		// Only probe whether TLS is served, the certificate is verified
+		// by the requests.
+		InsecureSkipVerify: true,
+	})
+	if err == nil {
+		conn.Close()
+		return false, nil
+	}
+
+	// The server responded with a non-TLS record.
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &recordErr) {
+		return true, nil
+	}
+	// Nothing listens on the default https port, the registry serves
+	// plain HTTP on the default http port.
+	if addr != host && errors.Is(err, syscall.ECONNREFUSED) {
+		return true, nil
+	}
+	return false, err
+}
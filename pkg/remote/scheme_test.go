@@ -0,0 +1,103 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeTLS(t *testing.T) {
+	plainServer := httptest.NewServer(http.NotFoundHandler())
+	defer plainServer.Close()
+	plainHTTP, err := probeTLS(strings.TrimPrefix(plainServer.URL, "http://"))
+	require.NoError(t, err)
+	require.True(t, plainHTTP)
+
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	plainHTTP, err = probeTLS(strings.TrimPrefix(tlsServer.URL, "https://"))
+	require.NoError(t, err)
+	require.False(t, plainHTTP)
+
+	// Nothing listens on the explicit port.
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+	_, err = probeTLS(strings.TrimPrefix(closedServer.URL, "http://"))
+	require.Error(t, err)
+}
+
+func TestSchemes(t *testing.T) {
+	probes := 0
+	schemes := &Schemes{
+		Insecure: map[string]string{
+			"http.example.com":  InsecureTrue,
+			"https.example.com": InsecureFalse,
+		},
+		probe: func(host string) (bool, error) {
+			probes++
+			return true, nil
+		},
+	}
+	require.NoError(t, schemes.Validate())
+
+	plainHTTP, err := schemes.PlainHTTP("http.example.com")
+	require.NoError(t, err)
+	require.True(t, plainHTTP)
+	plainHTTP, err = schemes.PlainHTTP("https.example.com")
+	require.NoError(t, err)
+	require.False(t, plainHTTP)
+	require.Equal(t, 0, probes)
+
+	// The negotiated scheme is cached.
+	for i := 0; i < 2; i++ {
+		plainHTTP, err = schemes.PlainHTTP("auto.example.com")
+		require.NoError(t, err)
+		require.True(t, plainHTTP)
+	}
+	require.Equal(t, 1, probes)
+
+	// The host requested by proxy is not probed.
+	schemes.Proxy, err = NewProxyFunc("http://proxy.example.com:3128")
+	require.NoError(t, err)
+	plainHTTP, err = schemes.PlainHTTP("proxied.example.com")
+	require.NoError(t, err)
+	require.False(t, plainHTTP)
+	require.Equal(t, 1, probes)
+
+	schemes.Insecure["invalid.example.com"] = "yes"
+	require.Error(t, schemes.Validate())
+}
+
+func TestNegotiatedScheme(t *testing.T) {
+	registry := &manifestRegistry{manifests: map[string][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	schemes := &Schemes{}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolverWithHosts(NewRegistryHostsWithOptions(RegistryOptions{
+			Schemes: schemes,
+		}))
+	})
+	require.NoError(t, err)
+	remoter.WithSchemes(schemes)
+
+	manifest := []byte(`{"schemaVersion":2}`)
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	require.NoError(t, remoter.Push(context.Background(), desc, false, bytes.NewReader(manifest)))
+	require.Equal(t, manifest, registry.manifests["latest"])
+	require.False(t, remoter.IsWithHTTP())
+}
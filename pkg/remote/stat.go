@@ -0,0 +1,72 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/containerd/containerd/errdefs"
+	containerdReference "github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Stat returns the size of blob `blobDigest` in registry without fetching
+// its content, the blob is looked up in all pull hosts in order, an error
+// wrapping errdefs.ErrNotFound is returned if none of them has the blob.
+func (remote *Remote) Stat(ctx context.Context, hostsFunc HostsFunc, blobDigest digest.Digest) (int64, error) {
+	refspec, err := containerdReference.Parse(remote.parsed.Name())
+	if err != nil {
+		return 0, errors.Wrap(err, "parse reference")
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, false)
+	if err != nil {
+		return 0, errors.Wrap(err, "set repository scope")
+	}
+
+	size, err := remote.stat(ctx, hostsFunc, blobDigest)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		size, err = remote.stat(ctx, hostsFunc, blobDigest)
+	}
+	return size, err
+}
+
+func (remote *Remote) stat(ctx context.Context, hostsFunc HostsFunc, blobDigest digest.Digest) (int64, error) {
+	hosts, err := hostsFunc(remote.retryWithHTTP)(reference.Domain(remote.parsed))
+	if err != nil {
+		return 0, errors.Wrap(err, "get registry hosts")
+	}
+
+	var firstErr error
+	for _, host := range hosts {
+		if !host.Capabilities.Has(docker.HostCapabilityPull) {
+			continue
+		}
+		sp := &streamPusher{host: host, repo: reference.Path(remote.parsed)}
+		resp, err := sp.do(ctx, http.MethodHead, sp.url("blobs/"+blobDigest.String()), nil)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return resp.ContentLength, nil
+		case http.StatusNotFound:
+			continue
+		default:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unexpected status %s from HEAD %s", resp.Status, resp.Request.URL.Redacted())
+			}
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	return 0, errors.Wrapf(errdefs.ErrNotFound, "blob %s in %s", blobDigest, remote.Ref)
+}
@@ -0,0 +1,75 @@
+package remote
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetryRecord accumulates the retries of an operation.
+type RetryRecord struct {
+	Retries int `json:"retries"`
+	// ReuploadedBytes is the size of data uploaded again due to failures.
+	ReuploadedBytes int64 `json:"reuploaded_bytes"`
+	// TimeLost is the time spent on the failed attempts and the waits
+	// between attempts.
+	TimeLost time.Duration `json:"time_lost"`
+}
+
+func (r *RetryRecord) add(other RetryRecord) {
+	r.Retries += other.Retries
+	r.ReuploadedBytes += other.ReuploadedBytes
+	r.TimeLost += other.TimeLost
+}
+
+// RetryStats accumulates the retries performed in a run by operation.
+type RetryStats struct {
+	mutex   sync.Mutex
+	records map[string]*RetryRecord
+}
+
+// Retries collects the retries of all operations in this process.
+var Retries = &RetryStats{}
+
+// Record records a retry of operation `op` which failed an attempt taking
+// `lost` time and uploading `reuploaded` bytes.
+func (s *RetryStats) Record(op string, lost time.Duration, reuploaded int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.records == nil {
+		s.records = map[string]*RetryRecord{}
+	}
+	record, ok := s.records[op]
+	if !ok {
+		record = &RetryRecord{}
+		s.records[op] = record
+	}
+	record.add(RetryRecord{Retries: 1, ReuploadedBytes: reuploaded, TimeLost: lost})
+}
+
+// RetrySummary is the snapshot of RetryStats.
+type RetrySummary struct {
+	Total RetryRecord            `json:"total"`
+	Ops   map[string]RetryRecord `json:"ops"`
+}
+
+// OpNames returns the sorted names of retried operations.
+func (s *RetrySummary) OpNames() []string {
+	names := []string{}
+	for name := range s.Ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *RetryStats) Summary() RetrySummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	summary := RetrySummary{Ops: map[string]RetryRecord{}}
+	for op, record := range s.records {
+		summary.Ops[op] = *record
+		summary.Total.add(*record)
+	}
+	return summary
+}
@@ -0,0 +1,23 @@
+package remote
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryStats(t *testing.T) {
+	stats := &RetryStats{}
+	summary := stats.Summary()
+	require.Equal(t, 0, summary.Total.Retries)
+
+	stats.Record("push blob to registry", time.Second, 100)
+	stats.Record("push blob to registry", 2*time.Second, 100)
+	stats.Record("commit upper", time.Second, 0)
+
+	summary = stats.Summary()
+	require.Equal(t, RetryRecord{Retries: 3, ReuploadedBytes: 200, TimeLost: 4 * time.Second}, summary.Total)
+	require.Equal(t, RetryRecord{Retries: 2, ReuploadedBytes: 200, TimeLost: 3 * time.Second}, summary.Ops["push blob to registry"])
+	require.Equal(t, []string{"commit upper", "push blob to registry"}, summary.OpNames())
+}
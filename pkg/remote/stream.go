@@ -0,0 +1,283 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	containerdReference "github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// HostsFunc returns the registry hosts configuration same with the one
+// used by the resolver from resolverFunc.
+type HostsFunc = func(plainHTTP bool) docker.RegistryHosts
+
+// StreamChunkSize is the size of each PATCH request of stream push.
+const StreamChunkSize int64 = 64 * 1024 * 1024
+
+// chunkAttempts is the number of attempts to upload a chunk of stream push.
+const chunkAttempts = 5
+
+// chunkBackoff is the delay before the n-th retry of a chunk, replaced in
+// tests.
+var chunkBackoff = func(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+type streamPusher struct {
+	host docker.RegistryHost
+	repo string
+}
+
+func (sp *streamPusher) do(ctx context.Context, method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	return sp.doRequest(ctx, method, u, body, nil)
+}
+
+// doRequest sends the request, `prepare` is called to modify the request
+// before sending if it's not nil.
+func (sp *streamPusher) doRequest(ctx context.Context, method string, u *url.URL, body io.Reader, prepare func(req *http.Request)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range sp.host.Header {
+			req.Header[key] = append(req.Header[key], values...)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		if prepare != nil {
+			prepare(req)
+		}
+		if sp.host.Authorizer != nil {
+			if err := sp.host.Authorizer.Authorize(ctx, req); err != nil {
+				return nil, errors.Wrap(err, "authorize request")
+			}
+		}
+
+		resp, err := sp.host.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && sp.host.Authorizer != nil {
+			err := sp.host.Authorizer.AddResponses(ctx, []*http.Response{resp})
+			// Only the request without body can be replayed after
+			// authorizing, the request with body is retried by caller with
+			// the token refreshed, e.g. the chunk of a long upload.
+			if body != nil {
+				if err != nil {
+					logrus.WithError(err).Warnf("refresh authorization of %s", u.Host)
+				}
+				return resp, nil
+			}
+			resp.Body.Close()
+			if err != nil {
+				return nil, errors.Wrap(err, "add auth responses")
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func checkStatus(resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status %s from %s %s: %s", resp.Status, resp.Request.Method, resp.Request.URL.Redacted(), strings.TrimSpace(string(body)))
+}
+
+func (sp *streamPusher) location(resp *http.Response) (*url.URL, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return nil, fmt.Errorf("no location in response of %s %s", resp.Request.Method, resp.Request.URL.Redacted())
+	}
+	return resp.Request.URL.Parse(location)
+}
+
+// withPushScope requests the push scope of repository for the token used by
+// the raw requests.
+func (remote *Remote) withPushScope(ctx context.Context) (context.Context, error) {
+	refspec, err := containerdReference.Parse(remote.parsed.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse reference")
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "set repository scope")
+	}
+	return ctx, nil
+}
+
+// PushStream pushes the blob read from `reader` to registry by chunked
+// upload, the digest and size of blob is calculated on the fly and used
+// to commit the upload.
+func (remote *Remote) PushStream(ctx context.Context, hostsFunc HostsFunc, reader io.Reader) (digest.Digest, int64, error) {
+	ctx, err := remote.withPushScope(ctx)
+	if err != nil {
+		return "", 0, err
+	}
+
+	sp, uploadURL, err := remote.startUpload(ctx, hostsFunc)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		sp, uploadURL, err = remote.startUpload(ctx, hostsFunc)
+	}
+	if err != nil {
+		return "", 0, errors.Wrap(err, "start upload")
+	}
+
+	digester := digest.Canonical.Digester()
+	tr := io.TeeReader(reader, digester.Hash())
+	// The chunk is buffered to be sent again if its upload failed, as the
+	// stream can't be read twice.
+	chunk := make([]byte, StreamChunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(tr, chunk)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return "", 0, errors.Wrap(err, "read blob")
+		}
+		if uploadURL, err = sp.pushChunk(ctx, uploadURL, chunk[:n], size); err != nil {
+			return "", 0, errors.Wrapf(err, "upload chunk at %d", size)
+		}
+		size += int64(n)
+	}
+
+	blobDigest := digester.Digest()
+	query := uploadURL.Query()
+	query.Set("digest", blobDigest.String())
+	uploadURL.RawQuery = query.Encode()
+	resp, err := sp.do(ctx, http.MethodPut, uploadURL, nil)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "commit upload")
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusCreated, http.StatusNoContent, http.StatusOK); err != nil {
+		return "", 0, errors.Wrap(err, "commit upload")
+	}
+
+	return blobDigest, size, nil
+}
+
+// pushChunk uploads `chunk` at `offset` of the upload session, the failed
+// upload is retried from the offset acknowledged by registry, so a chunk
+// partially received is not sent again from its start. It returns the
+// upload url of the next chunk.
+func (sp *streamPusher) pushChunk(ctx context.Context, uploadURL *url.URL, chunk []byte, offset int64) (*url.URL, error) {
+	var sent int64
+	for attempt := 1; ; attempt++ {
+		resp, err := sp.doChunk(ctx, uploadURL, bytes.NewReader(chunk[sent:]), offset+sent, int64(len(chunk))-sent)
+		retryable := true
+		if err == nil {
+			err = checkStatus(resp, http.StatusAccepted, http.StatusNoContent)
+			if err == nil {
+				var next *url.URL
+				next, err = sp.location(resp)
+				if err == nil {
+					resp.Body.Close()
+					return next, nil
+				}
+			}
+			retryable = retryableStatus(resp.StatusCode)
+			resp.Body.Close()
+		}
+		if !retryable || attempt == chunkAttempts || ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.WithError(err).Warnf("retry upload chunk at %d", offset+sent)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(chunkBackoff(attempt)):
+		}
+
+		statusURL, acked, statusErr := sp.status(ctx, uploadURL)
+		if statusErr != nil {
+			logrus.WithError(statusErr).Warnf("query upload status of %s", uploadURL.Redacted())
+			continue
+		}
+		if acked < offset || acked > offset+int64(len(chunk)) {
+			return nil, fmt.Errorf("registry acknowledged %d bytes, out of chunk at %d", acked, offset)
+		}
+		uploadURL, sent = statusURL, acked-offset
+		if sent == int64(len(chunk)) {
+			// The chunk was received but the response was lost.
+			return uploadURL, nil
+		}
+	}
+}
+
+// retryableStatus reports whether the failed chunk upload with response
+// status `code` is worth retrying, e.g. the token expired, or the registry
+// is unavailable for a moment.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusRequestedRangeNotSatisfiable, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= http.StatusInternalServerError
+}
+
+// pushHost returns the pusher to the first registry host capable to push.
+func (remote *Remote) pushHost(hostsFunc HostsFunc) (*streamPusher, error) {
+	hosts, err := hostsFunc(remote.retryWithHTTP)(reference.Domain(remote.parsed))
+	if err != nil {
+		return nil, errors.Wrap(err, "get registry hosts")
+	}
+	for _, host := range hosts {
+		if host.Capabilities.Has(docker.HostCapabilityPush) {
+			return &streamPusher{
+				host: host,
+				repo: reference.Path(remote.parsed),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no push host for %s", remote.Ref)
+}
+
+func (sp *streamPusher) url(path string) *url.URL {
+	return &url.URL{
+		Scheme: sp.host.Scheme,
+		Host:   sp.host.Host,
+		Path:   fmt.Sprintf("%s/%s/%s", sp.host.Path, sp.repo, path),
+	}
+}
+
+func (remote *Remote) startUpload(ctx context.Context, hostsFunc HostsFunc) (*streamPusher, *url.URL, error) {
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := sp.do(ctx, http.MethodPost, sp.url("blobs/uploads/"), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, http.StatusAccepted); err != nil {
+		return nil, nil, err
+	}
+	uploadURL, err := sp.location(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sp, uploadURL, nil
+}
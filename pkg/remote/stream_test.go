@@ -0,0 +1,112 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRegistry accepts chunked blob uploads of docker registry API.
+type fakeRegistry struct {
+	uploading bytes.Buffer
+	patches   int
+	blobs     map[digest.Digest][]byte
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/uploads/"):
+		w.Header().Set("Location", "/v2/library/test/blobs/uploads/uuid?state=0")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPatch:
+		r.patches++
+		_, _ = io.Copy(&r.uploading, req.Body)
+		w.Header().Set("Location", "/v2/library/test/blobs/uploads/uuid?state=1")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut:
+		dgst := digest.Digest(req.URL.Query().Get("digest"))
+		if dgst != digest.FromBytes(r.uploading.Bytes()) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[dgst] = r.uploading.Bytes()
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestPushStream(t *testing.T) {
+	registry := &fakeRegistry{blobs: map[digest.Digest][]byte{}}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("nydus"), int(StreamChunkSize)/5+1)
+	blobDigest, size, err := remoter.PushStream(context.Background(), hostsFunc, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(data), blobDigest)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, 2, registry.patches)
+	require.Equal(t, data, registry.blobs[blobDigest])
+}
+
+func TestPushStreamRetry(t *testing.T) {
+	chunkBackoff = func(int) time.Duration { return 0 }
+	registry := &resumableRegistry{
+		failPatches: map[int]bool{2: true},
+		blobs:       map[digest.Digest][]byte{},
+	}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	remoter, err := New(host+"/library/test:latest", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+
+	// The failed second chunk is sent again from the acknowledged offset.
+	data := bytes.Repeat([]byte("nydus"), int(StreamChunkSize)/5+1)
+	blobDigest, size, err := remoter.PushStream(context.Background(), hostsFunc, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, digest.FromBytes(data), blobDigest)
+	require.Equal(t, int64(len(data)), size)
+	require.Equal(t, 1, registry.starts)
+	require.Equal(t, 3, registry.patches)
+	require.Equal(t, data, registry.blobs[blobDigest])
+
+	// The chunk rejected for good fails the push.
+	registry.failPatches = map[int]bool{}
+	for idx := 4; idx < 4+chunkAttempts; idx++ {
+		registry.failPatches[idx] = true
+	}
+	_, _, err = remoter.PushStream(context.Background(), hostsFunc, bytes.NewReader([]byte("nydus")))
+	require.ErrorContains(t, err, "upload chunk at 0")
+	require.Equal(t, 3+chunkAttempts, registry.patches)
+}
@@ -0,0 +1,122 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	containerdReference "github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ListTags returns all tags in the repository of remote, the pages of tag
+// list are followed by the Link header.
+func (remote *Remote) ListTags(ctx context.Context, hostsFunc HostsFunc) ([]string, error) {
+	refspec, err := containerdReference.Parse(remote.parsed.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "parse reference")
+	}
+	ctx, err = docker.ContextWithRepositoryScope(ctx, refspec, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "set repository scope")
+	}
+
+	tags, err := remote.listTags(ctx, hostsFunc)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		tags, err = remote.listTags(ctx, hostsFunc)
+	}
+	return tags, err
+}
+
+func (remote *Remote) listTags(ctx context.Context, hostsFunc HostsFunc) ([]string, error) {
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for u := sp.url("tags/list"); u != nil; {
+		resp, err := sp.do(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkStatus(resp, http.StatusOK); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode tag list from %s", u.Redacted())
+		}
+		tags = append(tags, list.Tags...)
+
+		if u, err = nextLink(resp); err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the url of next page in the Link header of `resp`, nil
+// if it's the last page.
+func nextLink(resp *http.Response) (*url.URL, error) {
+	for _, link := range resp.Header.Values("Link") {
+		target, params, ok := strings.Cut(link, ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+		target = strings.Trim(strings.TrimSpace(target), "<>")
+		next, err := resp.Request.URL.Parse(target)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse next link %s", target)
+		}
+		return next, nil
+	}
+	return nil, nil
+}
+
+// DeleteManifest deletes manifest `manifestDigest` in the repository of
+// remote, all the tags pointing to it are deleted as well. The registry
+// must enable deletion, and the credential must be granted to delete.
+func (remote *Remote) DeleteManifest(ctx context.Context, hostsFunc HostsFunc, manifestDigest digest.Digest) error {
+	ctx, err := remote.withPushScope(ctx)
+	if err != nil {
+		return err
+	}
+	ctx = docker.WithScope(ctx, fmt.Sprintf("repository:%s:delete", reference.Path(remote.parsed)))
+
+	err = remote.deleteManifest(ctx, hostsFunc, manifestDigest)
+	if err != nil && remote.MaybeWithHTTP(err) {
+		err = remote.deleteManifest(ctx, hostsFunc, manifestDigest)
+	}
+	return err
+}
+
+func (remote *Remote) deleteManifest(ctx context.Context, hostsFunc HostsFunc, manifestDigest digest.Digest) error {
+	sp, err := remote.pushHost(hostsFunc)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sp.do(ctx, http.MethodDelete, sp.url("manifests/"+manifestDigest.String()), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Already deleted by someone else.
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return checkStatus(resp, http.StatusAccepted)
+}
@@ -0,0 +1,63 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTags(t *testing.T) {
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.Method == http.MethodGet && req.URL.Path == "/v2/library/test/tags/list":
+			tags := []string{"v1", "v2"}
+			if req.URL.Query().Get("last") == "v2" {
+				tags = []string{"v3"}
+			} else {
+				w.Header().Set("Link", `</v2/library/test/tags/list?n=2&last=v2>; rel="next"`)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "library/test", "tags": tags})
+		case req.Method == http.MethodDelete && strings.HasPrefix(req.URL.Path, "/v2/library/test/manifests/"):
+			deleted = append(deleted, strings.TrimPrefix(req.URL.Path, "/v2/library/test/manifests/"))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hostsFunc := func(plainHTTP bool) docker.RegistryHosts {
+		return NewRegistryHosts(true, true, func(string) (string, string, error) {
+			return "", "", nil
+		})
+	}
+	remoter, err := New(host+"/library/test", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+
+	tags, err := remoter.ListTags(context.Background(), hostsFunc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"v1", "v2", "v3"}, tags)
+
+	manifestDigest := digest.FromString("manifest")
+	require.NoError(t, remoter.DeleteManifest(context.Background(), hostsFunc, manifestDigest))
+	require.Equal(t, []string{manifestDigest.String()}, deleted)
+
+	remoter, err = New(host+"/library/unknown", func(plainHTTP bool) remotes.Resolver {
+		return NewResolver(true, plainHTTP, nil)
+	})
+	require.NoError(t, err)
+	_, err = remoter.ListTags(context.Background(), hostsFunc)
+	require.Error(t, err)
+}
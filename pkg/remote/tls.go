@@ -0,0 +1,59 @@
+package remote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// LoadTLSConfig returns the TLS config verifying the server by the CA bundle
+// in file `ca` besides the system CAs, and presenting client certificate in
+// file `cert` with key in file `key` for mutual TLS. Any of them may be
+// empty.
+func LoadTLSConfig(ca, cert, key string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if ca != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca")
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in ca %s", ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, fmt.Errorf("both cert and key are required for client certificate")
+		}
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// RegistryTLSConfig returns the TLS config of registry configured with the
+// files of LoadTLSConfig, or skipping verification if `insecure` is
+// InsecureTrue without them, nil for the default verified by system CAs.
+func RegistryTLSConfig(ca, cert, key, insecure string) (*tls.Config, error) {
+	if ca == "" && cert == "" && key == "" {
+		if insecure == InsecureTrue {
+			return &tls.Config{InsecureSkipVerify: true}, nil
+		}
+		return nil, nil
+	}
+	return LoadTLSConfig(ca, cert, key)
+}
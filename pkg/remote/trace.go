@@ -0,0 +1,73 @@
+package remote
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sensitiveParams are the substrings of query parameters carrying
+// credentials, e.g. the signature and security token of presigned OSS
+// urls, which are redacted in trace logs.
+var sensitiveParams = []string{"signature", "token", "accesskey", "credential", "secret", "password"}
+
+// tracingTransport logs the method, url, status, bytes and duration of
+// requests at trace level. The headers and bodies are never logged, so
+// the credentials in them are not leaked.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+// NewTracingTransport returns the round tripper logging the requests sent
+// by `next` if trace level is enabled.
+func NewTracingTransport(next http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{next: next}
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !logrus.IsLevelEnabled(logrus.TraceLevel) {
+		return t.next.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	fields := logrus.Fields{
+		"method":   req.Method,
+		"url":      redactURL(req.URL),
+		"sent":     req.ContentLength,
+		"duration": time.Since(start).Round(time.Millisecond),
+	}
+	if err != nil {
+		logrus.WithFields(fields).WithError(err).Trace("http request failed")
+		return resp, err
+	}
+	fields["status"] = resp.StatusCode
+	fields["received"] = resp.ContentLength
+	logrus.WithFields(fields).Trace("http request")
+	return resp, nil
+}
+
+// redactURL returns `u` in string with the password of user info and the
+// sensitive query parameters redacted.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	query := redacted.Query()
+	changed := false
+	for key := range query {
+		lower := strings.ToLower(key)
+		for _, sensitive := range sensitiveParams {
+			if strings.Contains(lower, sensitive) {
+				query.Set(key, "xxxxx")
+				changed = true
+				break
+			}
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.Redacted()
+}
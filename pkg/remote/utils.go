@@ -27,6 +27,12 @@ func isErrConnectionRefused(err error) bool {
 }
 
 func WithRetry(op func() error) error {
+	return WithRetryUpload("request", 0, op)
+}
+
+// WithRetryUpload retries upload operation `op` named `name`, which uploads
+// `size` bytes, and records the retries into Retries.
+func WithRetryUpload(name string, size int64, op func() error) error {
 	var err error
 	attempts := defaultRetryAttempts
 	for attempts > 0 {
@@ -38,9 +44,14 @@ func WithRetry(op func() error) error {
 			logrus.Warnf("Retry due to error: %s", err)
 			time.Sleep(defaultRetryInterval)
 		}
+		start := time.Now()
 		if err = op(); err == nil {
 			break
 		}
+		// The failed attempt and the wait before next attempt are lost.
+		if attempts > 0 && !RetryWithHTTP(err) {
+			Retries.Record(name, time.Since(start)+defaultRetryInterval, size)
+		}
 	}
 	return err
 }
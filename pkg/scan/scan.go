@@ -0,0 +1,208 @@
+// Package scan scans the tar streams of committed layers for secrets while
+// they are packed, so the findings can block the push of committed images,
+// and scans the pushed images for vulnerabilities.
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Severity is the severity of a finding.
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// severityRanks orders the severities from the lowest.
+var severityRanks = map[Severity]int{
+	SeverityLow:      0,
+	SeverityMedium:   1,
+	SeverityHigh:     2,
+	SeverityCritical: 3,
+}
+
+// ParseSeverity parses `severity`, one of low, medium, high and critical.
+func ParseSeverity(severity string) (Severity, error) {
+	if _, ok := severityRanks[Severity(severity)]; !ok {
+		return "", fmt.Errorf("invalid severity %s, must be one of low, medium, high, critical", severity)
+	}
+	return Severity(severity), nil
+}
+
+// Action is taken on the findings of a severity.
+type Action string
+
+const (
+	// ActionIgnore drops the findings.
+	ActionIgnore Action = "ignore"
+	// ActionWarn logs the findings.
+	ActionWarn Action = "warn"
+	// ActionBlock fails the commit, so the committed image isn't pushed.
+	ActionBlock Action = "block"
+)
+
+// ParseAction parses `action`, one of ignore, warn and block.
+func ParseAction(action string) (Action, error) {
+	switch Action(action) {
+	case ActionIgnore, ActionWarn, ActionBlock:
+		return Action(action), nil
+	default:
+		return "", fmt.Errorf("invalid action %s, must be one of ignore, warn, block", action)
+	}
+}
+
+// ErrSecretsFound is returned if the findings of blocking severity are
+// found.
+var ErrSecretsFound = errors.New("secrets found")
+
+// Policy is the actions keyed by severity, the high and critical findings
+// block the push and the others are warned if not set.
+type Policy map[Severity]Action
+
+// ParsePolicy parses the actions keyed by severity.
+func ParsePolicy(actions map[string]string) (Policy, error) {
+	policy := Policy{}
+	for severity, action := range actions {
+		parsedSeverity, err := ParseSeverity(severity)
+		if err != nil {
+			return nil, err
+		}
+		if policy[parsedSeverity], err = ParseAction(action); err != nil {
+			return nil, errors.Wrapf(err, "severity %s", severity)
+		}
+	}
+	return policy, nil
+}
+
+// Action returns the action on the findings of `severity`.
+func (p Policy) Action(severity Severity) Action {
+	if action, ok := p[severity]; ok {
+		return action
+	}
+	if severityRanks[severity] >= severityRanks[SeverityHigh] {
+		return ActionBlock
+	}
+	return ActionWarn
+}
+
+// Check applies the policy to the `findings` of layer `name`, the warned
+// ones are logged and ErrSecretsFound is returned with the blocking ones.
+func (p Policy) Check(name string, findings []Finding) error {
+	blocked := []string{}
+	for _, finding := range findings {
+		switch p.Action(finding.Severity) {
+		case ActionWarn:
+			logrus.Warnf("secret found in %s: %s", name, finding)
+		case ActionBlock:
+			logrus.Errorf("secret found in %s: %s", name, finding)
+			blocked = append(blocked, finding.String())
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+	sort.Strings(blocked)
+	return errors.Wrapf(ErrSecretsFound, "%d in %s: %s", len(blocked), name, strings.Join(blocked, "; "))
+}
+
+// Finding is a secret found in a file of layer.
+type Finding struct {
+	// Rule is the name of rule matched the secret, e.g. "aws-access-key-id".
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// Path of file in layer.
+	Path string `json:"path"`
+	// Line is the line number in file starting from 1, 0 if unknown.
+	Line int `json:"line,omitempty"`
+	// Match is the redacted secret, it's never logged in full.
+	Match string `json:"match,omitempty"`
+}
+
+func (f Finding) String() string {
+	location := f.Path
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+	}
+	if f.Match != "" {
+		return fmt.Sprintf("%s: %s (%s) %s", location, f.Rule, f.Severity, f.Match)
+	}
+	return fmt.Sprintf("%s: %s (%s)", location, f.Rule, f.Severity)
+}
+
+// redact keeps the first 4 characters of `secret`.
+func redact(secret string) string {
+	if len(secret) <= 4 {
+		return "****"
+	}
+	return secret[:4] + "****"
+}
+
+// Scanner scans the tar stream of a layer.
+type Scanner interface {
+	// Scan reads the tar stream of layer `name` from `r` and returns the
+	// findings, it may return before reading to the end.
+	Scan(ctx context.Context, name string, r io.Reader) ([]Finding, error)
+}
+
+// Writer scans the tar stream written to it by a Scanner in background.
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	// Set once done is closed.
+	findings []Finding
+	err      error
+}
+
+// NewWriter returns a Writer which scans the tar stream of layer `name`
+// by `scanner`, it must be closed by Close or CloseWithError to release
+// the scan goroutine.
+func NewWriter(ctx context.Context, scanner Scanner, name string) *Writer {
+	pr, pw := io.Pipe()
+	w := &Writer{
+		pw:   pw,
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		w.findings, w.err = scanner.Scan(ctx, name, pr)
+		if w.err != nil {
+			w.err = errors.Wrap(w.err, "scan layer")
+			pr.CloseWithError(w.err)
+			return
+		}
+		// The scanner may skip the rest of stream.
+		_, w.err = io.Copy(io.Discard, pr)
+	}()
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the written tar stream and returns the findings.
+func (w *Writer) Close() ([]Finding, error) {
+	w.pw.Close()
+	<-w.done
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w.findings, nil
+}
+
+// CloseWithError aborts the scan with `err`, it's a no-op if closed.
+func (w *Writer) CloseWithError(err error) {
+	w.pw.CloseWithError(err)
+	<-w.done
+}
@@ -0,0 +1,221 @@
+package scan
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Rule finds the secrets matching Pattern.
+type Rule struct {
+	Name     string
+	Severity Severity
+	Pattern  *regexp.Regexp
+}
+
+// BuiltinRules are the rules of RegexScanner by default.
+var BuiltinRules = []Rule{
+	{"private-key", SeverityCritical, regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)},
+	{"aws-secret-access-key", SeverityCritical, regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"aws-access-key-id", SeverityHigh, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github-token", SeverityHigh, regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"gitlab-token", SeverityHigh, regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20}\b`)},
+	{"slack-token", SeverityHigh, regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"jwt", SeverityMedium, regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{"generic-secret", SeverityLow, regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|passw(?:or)?d)["']?\s*[:=]\s*["'][^"'\s]{12,}["']`)},
+}
+
+// DefaultMaxFileSize is the size of largest file scanned by RegexScanner
+// by default.
+const DefaultMaxFileSize = 10 << 20
+
+// binaryPeekSize is the bytes of file checked for NUL, the binary files
+// are not scanned.
+const binaryPeekSize = 8000
+
+// RegexScanner scans the regular text files in layer by the regexps of
+// rules.
+type RegexScanner struct {
+	Rules []Rule
+	// MaxFileSize skips the larger files, e.g. databases and archives.
+	MaxFileSize int64
+}
+
+// NewRegexScanner returns a RegexScanner of BuiltinRules, the files larger
+// than `maxFileSize` are skipped, DefaultMaxFileSize if 0.
+func NewRegexScanner(maxFileSize int64) *RegexScanner {
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+	return &RegexScanner{
+		Rules:       BuiltinRules,
+		MaxFileSize: maxFileSize,
+	}
+}
+
+func (s *RegexScanner) Scan(ctx context.Context, name string, r io.Reader) ([]Finding, error) {
+	findings := []Finding{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return findings, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read tar header")
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || strings.HasPrefix(path.Base(hdr.Name), ".wh.") {
+			continue
+		}
+		if hdr.Size > s.MaxFileSize {
+			logrus.Debugf("skip scanning %s of size %d in %s", hdr.Name, hdr.Size, name)
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read content of %s", hdr.Name)
+		}
+		findings = append(findings, s.scanFile(hdr.Name, content)...)
+	}
+}
+
+// scanFile finds the secrets in `content` of file `name`.
+func (s *RegexScanner) scanFile(name string, content []byte) []Finding {
+	peek := content
+	if len(peek) > binaryPeekSize {
+		peek = peek[:binaryPeekSize]
+	}
+	if bytes.IndexByte(peek, 0) != -1 {
+		return nil
+	}
+
+	findings := []Finding{}
+	for _, rule := range s.Rules {
+		for _, loc := range rule.Pattern.FindAllIndex(content, -1) {
+			findings = append(findings, Finding{
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Path:     "/" + strings.TrimPrefix(name, "/"),
+				Line:     bytes.Count(content[:loc[0]], []byte("\n")) + 1,
+				Match:    redact(string(content[loc[0]:loc[1]])),
+			})
+		}
+	}
+	return findings
+}
+
+// maxLineSize is the maximum bytes of a line written by scanner.
+const maxLineSize = 1 << 20
+
+// maxScannerStderr is the bytes of scanner stderr kept for error.
+const maxScannerStderr = 4096
+
+// limitedBuffer keeps the first bytes written to it up to limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// ExecScanner scans by an executable, e.g. a wrapper of gitleaks or
+// trufflehog. The executable is run with the args for each layer, it reads
+// the tar stream of layer from stdin, and writes the findings in one line
+// of JSON each (see Finding) to stdout, and exits with non-zero code on
+// failure with the message in stderr. The name of layer is in env
+// NYDUS_CLI_LAYER.
+type ExecScanner struct {
+	command string
+	args    []string
+}
+
+func NewExecScanner(command string, args []string) *ExecScanner {
+	return &ExecScanner{
+		command: command,
+		args:    args,
+	}
+}
+
+func (s *ExecScanner) Scan(ctx context.Context, name string, r io.Reader) ([]Finding, error) {
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Env = append(os.Environ(), "NYDUS_CLI_LAYER="+name)
+	cmd.Stdin = r
+	output, err := runScanner(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	findings, err := decodeLines[Finding](output)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range findings {
+		if _, err := ParseSeverity(string(findings[idx].Severity)); err != nil {
+			return nil, errors.Wrapf(err, "finding %d", idx+1)
+		}
+		// The scanner may report the secret in full.
+		if findings[idx].Match != "" {
+			findings[idx].Match = redact(findings[idx].Match)
+		}
+	}
+	return findings, nil
+}
+
+// runScanner runs the scanner `cmd` and returns its stdout.
+func runScanner(cmd *exec.Cmd) ([]byte, error) {
+	stdout := bytes.Buffer{}
+	stderr := &limitedBuffer{limit: maxScannerStderr}
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "run scanner %s: %s", cmd.Args[0], strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// decodeLines decodes `output` of one JSON object per line, the empty
+// lines are skipped. The lines aren't in errors as they may contain the
+// secrets.
+func decodeLines[T any](output []byte) ([]T, error) {
+	decoded := []T{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, maxLineSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var value T
+		if err := json.Unmarshal(line, &value); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal line %d", lineNo)
+		}
+		decoded = append(decoded, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read output of scanner")
+	}
+	return decoded, nil
+}
@@ -0,0 +1,201 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVulnerabilities is returned if the vulnerabilities at or above the
+// threshold are found in the pushed images.
+var ErrVulnerabilities = errors.New("vulnerabilities found")
+
+// Vulnerability is a vulnerability found in a package of image.
+type Vulnerability struct {
+	// ID is e.g. "CVE-2023-38545".
+	ID       string   `json:"id"`
+	Severity Severity `json:"severity"`
+	Package  string   `json:"package,omitempty"`
+	Version  string   `json:"version,omitempty"`
+}
+
+func (v Vulnerability) String() string {
+	if v.Package == "" {
+		return fmt.Sprintf("%s (%s)", v.ID, v.Severity)
+	}
+	return fmt.Sprintf("%s (%s) in %s %s", v.ID, v.Severity, v.Package, v.Version)
+}
+
+// Image is a pushed image to scan.
+type Image struct {
+	// Ref is referenced by digest, e.g. "registry.example.com/app@sha256:...".
+	Ref string
+	// Username and Password are the credentials of registry, empty if
+	// anonymous.
+	Username string
+	Password string
+	// Token is the bearer token of registry used instead of username and
+	// password if not empty.
+	Token string
+}
+
+// ImageScanner scans the pushed images for vulnerabilities.
+type ImageScanner interface {
+	ScanImage(ctx context.Context, image Image) ([]Vulnerability, error)
+}
+
+// AtOrAbove returns the vulnerabilities in `vulns` at or above `threshold`.
+func AtOrAbove(vulns []Vulnerability, threshold Severity) []Vulnerability {
+	matched := []Vulnerability{}
+	for _, vuln := range vulns {
+		if severityRanks[vuln.Severity] >= severityRanks[threshold] {
+			matched = append(matched, vuln)
+		}
+	}
+	return matched
+}
+
+// Summary counts `vulns` by severity from the highest, e.g. "1 critical,
+// 3 high".
+func Summary(vulns []Vulnerability) string {
+	counts := map[Severity]int{}
+	for _, vuln := range vulns {
+		counts[vuln.Severity]++
+	}
+	parts := []string{}
+	for _, severity := range []Severity{SeverityCritical, SeverityHigh, SeverityMedium, SeverityLow} {
+		if counts[severity] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[severity], severity))
+		}
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// TrivyScanner scans by trivy CLI in client mode of a Trivy server, so the
+// vulnerability database is only kept on the server.
+type TrivyScanner struct {
+	path   string
+	server string
+	token  string
+}
+
+// NewTrivyScanner returns a TrivyScanner running trivy binary `path` ("trivy"
+// in PATH if empty) against server URL `server` authenticated by `token`.
+func NewTrivyScanner(path, server, token string) *TrivyScanner {
+	if path == "" {
+		path = "trivy"
+	}
+	return &TrivyScanner{
+		path:   path,
+		server: server,
+		token:  token,
+	}
+}
+
+// trivyReport is the part of JSON report of trivy used.
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+func (s *TrivyScanner) ScanImage(ctx context.Context, image Image) ([]Vulnerability, error) {
+	args := []string{"image", "--server", s.server, "--scanners", "vuln", "--format", "json", "--quiet"}
+	if s.token != "" {
+		args = append(args, "--token", s.token)
+	}
+	cmd := exec.CommandContext(ctx, s.path, append(args, image.Ref)...)
+	cmd.Env = os.Environ()
+	if image.Username != "" || image.Password != "" {
+		cmd.Env = append(cmd.Env, "TRIVY_USERNAME="+image.Username, "TRIVY_PASSWORD="+image.Password)
+	}
+	if image.Token != "" {
+		cmd.Env = append(cmd.Env, "TRIVY_REGISTRY_TOKEN="+image.Token)
+	}
+	output, err := runScanner(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	report := trivyReport{}
+	if err := json.Unmarshal(output, &report); err != nil {
+		return nil, errors.Wrap(err, "unmarshal trivy report")
+	}
+	vulns := []Vulnerability{}
+	for _, result := range report.Results {
+		for _, vuln := range result.Vulnerabilities {
+			// The severity unknown to trivy is taken as low.
+			severity, err := ParseSeverity(strings.ToLower(vuln.Severity))
+			if err != nil {
+				severity = SeverityLow
+			}
+			vulns = append(vulns, Vulnerability{
+				ID:       vuln.VulnerabilityID,
+				Severity: severity,
+				Package:  vuln.PkgName,
+				Version:  vuln.InstalledVersion,
+			})
+		}
+	}
+	return vulns, nil
+}
+
+// ExecImageScanner scans by an executable. The executable is run with the
+// args followed by the reference of image, and writes the vulnerabilities
+// in one line of JSON each (see Vulnerability) to stdout, and exits with
+// non-zero code on failure with the message in stderr. The credentials of
+// registry are in env NYDUS_CLI_REGISTRY_USERNAME and
+// NYDUS_CLI_REGISTRY_PASSWORD, or the bearer token in env
+// NYDUS_CLI_REGISTRY_TOKEN if any.
+type ExecImageScanner struct {
+	command string
+	args    []string
+}
+
+func NewExecImageScanner(command string, args []string) *ExecImageScanner {
+	return &ExecImageScanner{
+		command: command,
+		args:    args,
+	}
+}
+
+func (s *ExecImageScanner) ScanImage(ctx context.Context, image Image) ([]Vulnerability, error) {
+	args := append(append([]string{}, s.args...), image.Ref)
+	cmd := exec.CommandContext(ctx, s.command, args...)
+	cmd.Env = os.Environ()
+	if image.Username != "" || image.Password != "" {
+		cmd.Env = append(cmd.Env, "NYDUS_CLI_REGISTRY_USERNAME="+image.Username, "NYDUS_CLI_REGISTRY_PASSWORD="+image.Password)
+	}
+	if image.Token != "" {
+		cmd.Env = append(cmd.Env, "NYDUS_CLI_REGISTRY_TOKEN="+image.Token)
+	}
+	output, err := runScanner(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	vulns, err := decodeLines[Vulnerability](output)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range vulns {
+		if _, err := ParseSeverity(string(vulns[idx].Severity)); err != nil {
+			return nil, errors.Wrapf(err, "vulnerability %d", idx+1)
+		}
+	}
+	return vulns, nil
+}
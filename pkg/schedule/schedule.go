@@ -0,0 +1,191 @@
+// Package schedule parses the schedules of periodic commits, either a fixed
+// interval or a cron expression.
+package schedule
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule returns the time of next run after `t`.
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// interval runs every fixed duration.
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// macros are the shorthands of cron expressions.
+var macros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Parse parses `spec`, which is either a duration (e.g. `1h`) or a cron
+// expression of 5 fields `minute hour day-of-month month day-of-week` in
+// local time (e.g. `*/30 9-18 * * 1-5`), or one of @hourly, @daily,
+// @weekly and @monthly.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Minute {
+			return nil, fmt.Errorf("interval %s is shorter than 1m", spec)
+		}
+		return interval(d), nil
+	}
+	if expr, ok := macros[spec]; ok {
+		spec = expr
+	}
+	c, err := parseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	if c.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never matches", spec)
+	}
+	return c, nil
+}
+
+// Jitter returns a random duration in [0, max).
+func Jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// cron is a cron expression, each field is the bitmap of matched values.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set if the field is `*`, the day matches
+	// either day-of-month or day-of-week if both are restricted.
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day-of-month", 1, 31},
+	{"month", 1, 12},
+	{"day-of-week", 0, 7},
+}
+
+func parseCron(spec string) (*cron, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("invalid schedule %q, expected a duration or a cron expression of %d fields", spec, len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	for idx, part := range parts {
+		var err error
+		if bits[idx], err = parseField(part, fields[idx]); err != nil {
+			return nil, errors.Wrapf(err, "invalid %s field of schedule %q", fields[idx].name, spec)
+		}
+	}
+	// Both 0 and 7 are Sunday.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cron{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}, nil
+}
+
+// parseField parses a comma separated list of `*`, `n` and `a-b`, each
+// optionally followed by step `/s`.
+func parseField(spec string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepSpec)
+			}
+		}
+		start, end := f.min, f.max
+		if rangeSpec != "*" {
+			startSpec, endSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if start, err = strconv.Atoi(startSpec); err != nil {
+				return 0, fmt.Errorf("invalid value %q", startSpec)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endSpec); err != nil {
+					return 0, fmt.Errorf("invalid value %q", endSpec)
+				}
+			} else if hasStep {
+				end = f.max
+			}
+		}
+		if start < f.min || end > f.max || start > end {
+			return 0, fmt.Errorf("%q is out of range %d-%d", item, f.min, f.max)
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+func (c *cron) matchDay(t time.Time) bool {
+	domMatch := c.dom&(1<<t.Day()) != 0
+	dowMatch := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// maxSearchYears bounds the search of next run, e.g. `0 0 30 2 *` never
+// matches, and zero time is returned then.
+const maxSearchYears = 5
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
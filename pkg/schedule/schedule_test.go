@@ -0,0 +1,47 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 1, 31, 10, 20, 30, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"1h", now.Add(time.Hour)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-18/3 * * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Saturday, 2024-02-03.
+		{"30 2 * * 6", time.Date(2024, 2, 3, 2, 30, 0, 0, time.UTC)},
+		// Sunday, 2024-02-04.
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		// Either the 15th or Monday, 2024-02-05.
+		{"0 0 15 * 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+		{"5,10 10 31 1,3 *", time.Date(2024, 3, 31, 10, 5, 0, 0, time.UTC)},
+	} {
+		schedule, err := Parse(tc.spec)
+		require.NoError(t, err, tc.spec)
+		require.Equal(t, tc.next, schedule.Next(now), tc.spec)
+	}
+
+	for _, spec := range []string{"", "1s", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *"} {
+		_, err := Parse(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	require.Zero(t, Jitter(0))
+	for idx := 0; idx < 100; idx++ {
+		jitter := Jitter(time.Minute)
+		require.True(t, jitter >= 0 && jitter < time.Minute)
+	}
+}
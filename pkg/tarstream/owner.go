@@ -0,0 +1,190 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tarstream
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	paxSchilyXattr = "SCHILY.xattr."
+
+	// The POSIX ACLs in extended attributes, whose entries of named users
+	// and groups hold the ids, see include/uapi/linux/posix_acl_xattr.h.
+	aclXattrAccess     = "system.posix_acl_access"
+	aclXattrDefault    = "system.posix_acl_default"
+	aclXattrVersion    = 2
+	aclXattrHeaderSize = 4
+	aclXattrEntrySize  = 8
+	aclTagUser         = 0x02
+	aclTagGroup        = 0x08
+)
+
+// IDMapping maps `Size` ids starting from `HostID` on host to the ids
+// starting from `ContainerID`, same as the subuid style mapping of user
+// namespace.
+type IDMapping struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}
+
+// Ownership rewrites the owner of files in tar stream, `UID` and `GID`
+// take precedence over the id mappings.
+type Ownership struct {
+	UID     *int
+	GID     *int
+	UIDMaps []IDMapping
+	GIDMaps []IDMapping
+}
+
+// WithOwnership rewrites the owner of each entry by `ownership`.
+func WithOwnership(ownership Ownership) Option {
+	return func(o *Options) {
+		o.Ownership = &ownership
+	}
+}
+
+// ParseIDMapping parses mapping in format `container_id:host_id:size`.
+func ParseIDMapping(mapping string) (*IDMapping, error) {
+	parts := strings.Split(mapping, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid id mapping %s, expected container_id:host_id:size", mapping)
+	}
+
+	ids := make([]int, 0, 3)
+	for _, part := range parts {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid id mapping %s", mapping)
+		}
+		ids = append(ids, int(id))
+	}
+	if ids[2] == 0 {
+		return nil, fmt.Errorf("invalid id mapping %s, size must be positive", mapping)
+	}
+
+	return &IDMapping{
+		ContainerID: ids[0],
+		HostID:      ids[1],
+		Size:        ids[2],
+	}, nil
+}
+
+// ParseChown parses owner in format `uid[:gid]`.
+func ParseChown(owner string) (*int, *int, error) {
+	parts := strings.SplitN(owner, ":", 2)
+
+	uid, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid uid in %s", owner)
+	}
+	_uid := int(uid)
+	if len(parts) == 1 {
+		return &_uid, nil, nil
+	}
+
+	gid, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid gid in %s", owner)
+	}
+	_gid := int(gid)
+
+	return &_uid, &_gid, nil
+}
+
+func mapID(id int, mappings []IDMapping) int {
+	for _, mapping := range mappings {
+		if id >= mapping.HostID && id < mapping.HostID+mapping.Size {
+			return mapping.ContainerID + id - mapping.HostID
+		}
+	}
+	return id
+}
+
+func (ownership *Ownership) apply(hdr *tar.Header) {
+	if ownership.UID != nil {
+		hdr.Uid = *ownership.UID
+	} else {
+		hdr.Uid = mapID(hdr.Uid, ownership.UIDMaps)
+	}
+	if ownership.GID != nil {
+		hdr.Gid = *ownership.GID
+	} else {
+		hdr.Gid = mapID(hdr.Gid, ownership.GIDMaps)
+	}
+	// The names are resolved on host, they are meaningless after mapping.
+	hdr.Uname = ""
+	hdr.Gname = ""
+	ownership.applyACL(hdr, aclXattrAccess)
+	ownership.applyACL(hdr, aclXattrDefault)
+}
+
+// applyACL maps the ids in ACL xattr `name` of `hdr` as the owner, the
+// xattr is dropped if it can't be mapped.
+func (ownership *Ownership) applyACL(hdr *tar.Header, name string) {
+	key := paxSchilyXattr + name
+	value, inPAX := hdr.PAXRecords[key]
+	_, inXattrs := hdr.Xattrs[name] //nolint:staticcheck
+	if !inPAX && !inXattrs {
+		return
+	} else if !inPAX {
+		value = hdr.Xattrs[name] //nolint:staticcheck
+	}
+
+	mapped, err := ownership.mapACL(value)
+	if err != nil {
+		logrus.WithError(err).Warnf("drop %s of %s", name, hdr.Name)
+		delete(hdr.PAXRecords, key)
+		delete(hdr.Xattrs, name) //nolint:staticcheck
+		return
+	}
+	if inPAX {
+		hdr.PAXRecords[key] = mapped
+	}
+	if inXattrs {
+		hdr.Xattrs[name] = mapped //nolint:staticcheck
+	}
+}
+
+// mapACL returns ACL xattr `value` whose named users and groups are mapped
+// by the id mappings. The named users and groups can't be mapped if the
+// owner is overridden by `UID` and `GID`, which name the owner only.
+func (ownership *Ownership) mapACL(value string) (string, error) {
+	acl := []byte(value)
+	if len(acl) < aclXattrHeaderSize || (len(acl)-aclXattrHeaderSize)%aclXattrEntrySize != 0 {
+		return "", fmt.Errorf("invalid ACL size %d", len(acl))
+	}
+	if version := binary.LittleEndian.Uint32(acl); version != aclXattrVersion {
+		return "", fmt.Errorf("unsupported ACL version %d", version)
+	}
+	for offset := aclXattrHeaderSize; offset < len(acl); offset += aclXattrEntrySize {
+		entry := acl[offset : offset+aclXattrEntrySize]
+		id := int(binary.LittleEndian.Uint32(entry[4:]))
+		switch binary.LittleEndian.Uint16(entry) {
+		case aclTagUser:
+			if ownership.UID != nil {
+				return "", fmt.Errorf("named user %d is not mapped with overridden uid", id)
+			}
+			id = mapID(id, ownership.UIDMaps)
+		case aclTagGroup:
+			if ownership.GID != nil {
+				return "", fmt.Errorf("named group %d is not mapped with overridden gid", id)
+			}
+			id = mapID(id, ownership.GIDMaps)
+		default:
+			continue
+		}
+		binary.LittleEndian.PutUint32(entry[4:], uint32(id))
+	}
+	return string(acl), nil
+}
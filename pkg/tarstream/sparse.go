@@ -0,0 +1,296 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Segment is a range of data in a sparse file, the rest of file is holes.
+type Segment struct {
+	Offset int64
+	Length int64
+}
+
+// DataSegments returns the data segments of the first `size` bytes of
+// `file` by SEEK_DATA and SEEK_HOLE, `sparse` is false if the file has no
+// hole, or the filesystem doesn't report holes.
+func DataSegments(file *os.File, size int64) (segments []Segment, sparse bool, err error) {
+	fd := int(file.Fd())
+	var total int64
+	for offset := int64(0); offset < size; {
+		start, err := unix.Seek(fd, offset, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// No data after offset.
+			break
+		} else if err == unix.EINVAL {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, errors.Wrap(err, "seek data")
+		}
+		if start >= size {
+			break
+		}
+		end, err := unix.Seek(fd, start, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "seek hole")
+		}
+		if end > size {
+			end = size
+		}
+		segments = append(segments, Segment{Offset: start, Length: end - start})
+		total += end - start
+		offset = end
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, false, errors.Wrap(err, "seek start")
+	}
+	return segments, total < size, nil
+}
+
+// SegmentsReader returns the reader of the data of `segments` in `ra`.
+func SegmentsReader(ra io.ReaderAt, segments []Segment) io.Reader {
+	readers := []io.Reader{}
+	for _, segment := range segments {
+		readers = append(readers, io.NewSectionReader(ra, segment.Offset, segment.Length))
+	}
+	return io.MultiReader(readers...)
+}
+
+const (
+	blockSize = 512
+
+	// The fields of old GNU format header, see `struct oldgnu_header`
+	// of GNU tar.
+	chksumOffset     = 148
+	typeflagOffset   = 156
+	sparseOffset     = 386
+	sparseEntries    = 4
+	isExtendedOffset = 482
+	realSizeOffset   = 483
+	extendedEntries  = 21
+	extendedIsOffset = 504
+	sparseEntrySize  = 24
+	numericFieldSize = 12
+	maxOctalNumeric  = 1<<33 - 1
+)
+
+// paxBasicKeys are the PAX records encoded by the fields of GNU header.
+var paxBasicKeys = map[string]bool{
+	"path": true, "linkpath": true, "size": true, "uid": true, "gid": true,
+	"uname": true, "gname": true, "mtime": true, "atime": true, "ctime": true,
+}
+
+// isSparse reports whether entry `hdr` read by archive/tar is a sparse
+// file, whose holes are read as zeros.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSparse writes regular file `hdr` of `hdr.Size` bytes to tar stream
+// `w` of `tw` as a sparse file in old GNU format, the one written by `tar
+// --sparse`, the data of `segments` is read from `data` in order and the
+// holes between are not written. archive/tar reads sparse files but can't
+// write them, so the header is encoded as a regular file and patched.
+func WriteSparse(tw *tar.Writer, w io.Writer, hdr *tar.Header, segments []Segment, data io.Reader) error {
+	var stored int64
+	for _, segment := range segments {
+		stored += segment.Length
+	}
+	// The map ends at the real size as GNU tar does, some readers check
+	// it against the size of file.
+	if len(segments) == 0 || segments[len(segments)-1].Offset+segments[len(segments)-1].Length < hdr.Size {
+		segments = append(segments, Segment{Offset: hdr.Size})
+	}
+
+	var header bytes.Buffer
+	if records := sparseRecords(hdr); len(records) > 0 {
+		// The records are written by a PAX header of a placeholder entry,
+		// whose header is dropped, before the GNU header of file.
+		var pax bytes.Buffer
+		if err := tar.NewWriter(&pax).WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       "GNUSparseFile",
+			ModTime:    time.Unix(hdr.ModTime.Unix(), 0),
+			PAXRecords: records,
+			Format:     tar.FormatPAX,
+		}); err != nil {
+			return errors.Wrapf(err, "write PAX header of %s", hdr.Name)
+		}
+		header.Write(pax.Bytes()[:pax.Len()-blockSize])
+	}
+
+	gnuHdr := *hdr
+	gnuHdr.Typeflag = tar.TypeReg
+	gnuHdr.Size = stored
+	gnuHdr.PAXRecords = nil
+	gnuHdr.Xattrs = nil //nolint:staticcheck
+	gnuHdr.ModTime = time.Unix(hdr.ModTime.Unix(), 0)
+	gnuHdr.AccessTime = time.Time{}
+	gnuHdr.ChangeTime = time.Time{}
+	gnuHdr.Format = tar.FormatGNU
+	var gnu bytes.Buffer
+	if err := tar.NewWriter(&gnu).WriteHeader(&gnuHdr); err != nil {
+		return errors.Wrapf(err, "write GNU header of %s", hdr.Name)
+	}
+	blocks := gnu.Bytes()
+	block := blocks[len(blocks)-blockSize:]
+	block[typeflagOffset] = tar.TypeGNUSparse
+	for idx := 0; idx < sparseEntries && idx < len(segments); idx++ {
+		putSparseEntry(block[sparseOffset+idx*sparseEntrySize:], segments[idx])
+	}
+	if len(segments) > sparseEntries {
+		block[isExtendedOffset] = 1
+	}
+	putNumeric(block[realSizeOffset:realSizeOffset+numericFieldSize], hdr.Size)
+	setChecksum(block)
+	header.Write(blocks)
+
+	for idx := sparseEntries; idx < len(segments); idx += extendedEntries {
+		extended := make([]byte, blockSize)
+		for entry := 0; entry < extendedEntries && idx+entry < len(segments); entry++ {
+			putSparseEntry(extended[entry*sparseEntrySize:], segments[idx+entry])
+		}
+		if idx+extendedEntries < len(segments) {
+			extended[extendedIsOffset] = 1
+		}
+		header.Write(extended)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return errors.Wrapf(err, "flush before %s", hdr.Name)
+	}
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return errors.Wrapf(err, "write header %s", hdr.Name)
+	}
+	if n, err := io.CopyN(w, data, stored); err != nil {
+		return errors.Wrapf(err, "copy content of %s, %d of %d bytes copied", hdr.Name, n, stored)
+	}
+	if pad := (blockSize - stored%blockSize) % blockSize; pad > 0 {
+		if _, err := w.Write(make([]byte, pad)); err != nil {
+			return errors.Wrapf(err, "write padding of %s", hdr.Name)
+		}
+	}
+	return nil
+}
+
+// sparseRecords returns the PAX records of `hdr` not encoded by GNU header,
+// e.g. the extended attributes.
+func sparseRecords(hdr *tar.Header) map[string]string {
+	records := map[string]string{}
+	for key, value := range hdr.PAXRecords {
+		if paxBasicKeys[key] || strings.HasPrefix(key, "GNU.sparse.") {
+			continue
+		}
+		records[key] = value
+	}
+	for key, value := range hdr.Xattrs { //nolint:staticcheck
+		records["SCHILY.xattr."+key] = value
+	}
+	return records
+}
+
+func putSparseEntry(b []byte, segment Segment) {
+	putNumeric(b[:numericFieldSize], segment.Offset)
+	putNumeric(b[numericFieldSize:2*numericFieldSize], segment.Length)
+}
+
+// putNumeric formats `value` in octal, or in base-256 if too large, as
+// GNU tar does.
+func putNumeric(b []byte, value int64) {
+	if value <= maxOctalNumeric {
+		copy(b, fmt.Sprintf("%0*o\x00", len(b)-1, value))
+		return
+	}
+	for idx := len(b) - 1; idx > 0; idx-- {
+		b[idx] = byte(value)
+		value >>= 8
+	}
+	b[0] = 0x80
+}
+
+func setChecksum(block []byte) {
+	copy(block[chksumOffset:chksumOffset+8], "        ")
+	var sum int64
+	for _, c := range block {
+		sum += int64(c)
+	}
+	copy(block[chksumOffset:chksumOffset+8], fmt.Sprintf("%06o\x00 ", sum))
+}
+
+// writeSparse rewrites sparse file `hdr` read from `r`, the blocks of zeros
+// are taken as holes, the data is staged in a temporary file as the
+// header of sparse file comes before the data.
+func (rw *rewriter) writeSparse(hdr *tar.Header, r io.Reader) error {
+	hdr.Typeflag = tar.TypeReg
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			delete(hdr.PAXRecords, key)
+		}
+	}
+	rw.normalize(hdr)
+
+	staged, err := os.CreateTemp(rw.opts.TempDir, "sparse-")
+	if err != nil {
+		return errors.Wrap(err, "create staging file of sparse file")
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	segments := []Segment{}
+	buf := make([]byte, blockSize)
+	for offset := int64(0); offset < hdr.Size; {
+		size := int64(blockSize)
+		if size > hdr.Size-offset {
+			size = hdr.Size - offset
+		}
+		n, err := io.ReadFull(r, buf[:size])
+		if err != nil {
+			return errors.Wrapf(err, "read content of %s", hdr.Name)
+		}
+		if !isZeros(buf[:n]) {
+			if last := len(segments) - 1; last >= 0 && segments[last].Offset+segments[last].Length == offset {
+				segments[last].Length += int64(n)
+			} else {
+				segments = append(segments, Segment{Offset: offset, Length: int64(n)})
+			}
+			if _, err := staged.Write(buf[:n]); err != nil {
+				return errors.Wrapf(err, "stage content of %s", hdr.Name)
+			}
+		}
+		offset += int64(n)
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "seek staging file")
+	}
+
+	return WriteSparse(rw.tw, rw.w, hdr, segments, staged)
+}
+
+func isZeros(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// makeSparseFile creates a file of `size` bytes with `data` written at each
+// of `offsets`, the rest are holes.
+func makeSparseFile(t *testing.T, size int64, data []byte, offsets []int64) *os.File {
+	file, err := os.Create(filepath.Join(t.TempDir(), "sparse"))
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	require.NoError(t, file.Truncate(size))
+	for _, offset := range offsets {
+		_, err := file.WriteAt(data, offset)
+		require.NoError(t, err)
+	}
+	return file
+}
+
+func TestWriteSparse(t *testing.T) {
+	const size = 64 * 1024 * 1024
+	// More segments than the GNU header holds, the rest are in extended
+	// headers.
+	offsets := []int64{}
+	for offset := int64(1024 * 1024); offset < size; offset += 2 * 1024 * 1024 {
+		offsets = append(offsets, offset)
+	}
+	file := makeSparseFile(t, size, bytes.Repeat([]byte("nydus"), 1000), offsets)
+
+	segments, sparse, err := DataSegments(file, size)
+	require.NoError(t, err)
+	if !sparse {
+		t.Skip("filesystem doesn't report holes")
+	}
+	require.Len(t, segments, len(offsets))
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       "sparse",
+		Mode:       0644,
+		Size:       size,
+		ModTime:    time.Unix(1700000000, 0),
+		PAXRecords: map[string]string{"SCHILY.xattr.user.foo": "bar"},
+		Format:     tar.FormatPAX,
+	}
+	require.NoError(t, WriteSparse(tw, &buf, hdr, segments, SegmentsReader(file, segments)))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "next", Mode: 0644}))
+	require.NoError(t, tw.Close())
+	require.Less(t, buf.Len(), 1024*1024)
+
+	expected, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+	require.NoError(t, err)
+	entries := readTar(t, bytes.NewReader(buf.Bytes()))
+	require.Equal(t, []string{"sparse", "next"}, names(entries))
+	require.Equal(t, int64(size), entries[0].hdr.Size)
+	require.Equal(t, "bar", entries[0].hdr.PAXRecords["SCHILY.xattr.user.foo"])
+	require.True(t, bytes.Equal(expected, []byte(entries[0].data)))
+
+	// The holes are kept by rewriting.
+	var rewritten bytes.Buffer
+	stats := Stats{}
+	require.NoError(t, Rewrite(bytes.NewReader(buf.Bytes()), &rewritten, WithTempDir(t.TempDir()), WithStats(&stats)))
+	require.Less(t, rewritten.Len(), 1024*1024)
+	require.Equal(t, Stats{Entries: 2, Files: 2, Size: size}, stats)
+	entries = readTar(t, &rewritten)
+	require.Equal(t, []string{"sparse", "next"}, names(entries))
+	require.Equal(t, "bar", entries[0].hdr.PAXRecords["SCHILY.xattr.user.foo"])
+	require.True(t, bytes.Equal(expected, []byte(entries[0].data)))
+}
+
+func TestDataSegmentsDense(t *testing.T) {
+	file := makeSparseFile(t, 4096, bytes.Repeat([]byte("x"), 4096), []int64{0})
+	_, sparse, err := DataSegments(file, 4096)
+	require.NoError(t, err)
+	require.False(t, sparse)
+}
+
+func TestRewriteGNUTarSparse(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	const size = 8 * 1024 * 1024
+	file := makeSparseFile(t, size, []byte("nydus"), []int64{4 * 1024 * 1024})
+	if _, sparse, err := DataSegments(file, size); err != nil || !sparse {
+		t.Skip("filesystem doesn't report holes")
+	}
+	root := filepath.Dir(file.Name())
+
+	// The entries written by `tar --sparse` are of type 'S', they pass the
+	// validation of mounts.
+	out, err := exec.Command("tar", "--sparse", "--absolute-names", "-cf", "-", root).Output()
+	require.NoError(t, err)
+	var rewritten bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(out), &rewritten, WithTempDir(t.TempDir()), WithLimits(Limits{Root: root})))
+	require.Less(t, rewritten.Len(), 1024*1024)
+
+	expected, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+	require.NoError(t, err)
+	entries := readTar(t, &rewritten)
+	require.Len(t, entries, 2)
+	require.Equal(t, int64(size), entries[1].hdr.Size)
+	require.True(t, bytes.Equal(expected, []byte(entries[1].data)))
+}
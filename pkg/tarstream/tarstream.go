@@ -0,0 +1,381 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tarstream rewrites the tar streams produced by committing (upper
+// diff and mount copy) before they are packed into nydus blobs.
+package tarstream
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const whiteoutOpaqueDir = ".wh..wh..opq"
+
+type Options struct {
+	// OpaqueDirs hides the content of lower layers for these directories,
+	// an OCI opaque whiteout is emitted right after the header of each dir.
+	OpaqueDirs []string
+	// Ownership rewrites the owner of entries if set.
+	Ownership *Ownership
+	// SourceDateEpoch clamps the modification time of entries and drops
+	// access/change time to make the stream reproducible if set.
+	SourceDateEpoch *time.Time
+	// Limits validates the entries before rewriting if set.
+	Limits *Limits
+	// PathPrefix moves the entries under the directory if set.
+	PathPrefix string
+	// SpecialFiles is the policy of device nodes and FIFOs, they are kept
+	// if not set.
+	SpecialFiles SpecialFiles
+	// Stats counts the rewritten entries if set.
+	Stats *Stats
+	// TempDir is where the data of sparse files are staged, the default
+	// directory for temporary files if not set.
+	TempDir string
+}
+
+// Stats counts the entries of the rewritten stream, excluding the ones
+// emitted by rewriting, i.e. the opaque whiteouts and prefix directories.
+type Stats struct {
+	Entries int `json:"entries"`
+	// Files is the count of regular files.
+	Files int `json:"files"`
+	// Whiteouts is the count of removed files and directories.
+	Whiteouts int `json:"whiteouts"`
+	// Size is the total size of regular files.
+	Size int64 `json:"size"`
+}
+
+// add counts entry `hdr`.
+func (s *Stats) add(hdr *tar.Header) {
+	s.Entries++
+	if strings.HasPrefix(path.Base(hdr.Name), ".wh.") {
+		s.Whiteouts++
+	} else if hdr.Typeflag == tar.TypeReg {
+		s.Files++
+		s.Size += hdr.Size
+	}
+}
+
+// Add adds the counts of `other`.
+func (s *Stats) Add(other Stats) {
+	s.Entries += other.Entries
+	s.Files += other.Files
+	s.Whiteouts += other.Whiteouts
+	s.Size += other.Size
+}
+
+// SpecialFiles is the policy of special files (device nodes, FIFOs and
+// sockets) found while committing, as some registries and builders
+// reject them.
+type SpecialFiles string
+
+const (
+	// SpecialFilesKeep keeps the device nodes and FIFOs, the sockets
+	// can't be archived in tar and are always skipped.
+	SpecialFilesKeep SpecialFiles = "keep"
+	// SpecialFilesSkip skips the special files.
+	SpecialFilesSkip SpecialFiles = "skip"
+	// SpecialFilesFail fails on the first special file.
+	SpecialFilesFail SpecialFiles = "fail"
+)
+
+// ErrSpecialFile is returned on a special file with SpecialFilesFail.
+var ErrSpecialFile = errors.New("special file found")
+
+// ParseSpecialFiles parses `policy`, the empty policy is SpecialFilesKeep.
+func ParseSpecialFiles(policy string) (SpecialFiles, error) {
+	switch SpecialFiles(policy) {
+	case "":
+		return SpecialFilesKeep, nil
+	case SpecialFilesKeep, SpecialFilesSkip, SpecialFilesFail:
+		return SpecialFiles(policy), nil
+	default:
+		return "", fmt.Errorf("invalid special files policy %s, must be one of skip, keep, fail", policy)
+	}
+}
+
+// WithSpecialFiles applies `policy` to the device nodes and FIFOs of the
+// stream, the hard links to the skipped ones are skipped as well.
+func WithSpecialFiles(policy SpecialFiles) Option {
+	return func(o *Options) {
+		o.SpecialFiles = policy
+	}
+}
+
+func isSpecial(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeChar || hdr.Typeflag == tar.TypeBlock || hdr.Typeflag == tar.TypeFifo
+}
+
+type Option func(*Options)
+
+// WithOpaqueDir marks `dir` as opaque in the rewritten stream, so files
+// removed from the directory also disappear after the layer is merged.
+func WithOpaqueDir(dir string) Option {
+	return func(o *Options) {
+		o.OpaqueDirs = append(o.OpaqueDirs, dir)
+	}
+}
+
+func trimName(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// WithSourceDateEpoch normalizes the timestamps of entries following
+// https://reproducible-builds.org/specs/source-date-epoch.
+func WithSourceDateEpoch(epoch time.Time) Option {
+	return func(o *Options) {
+		o.SourceDateEpoch = &epoch
+	}
+}
+
+// WithPathPrefix moves the entries under directory `prefix`, the parent
+// directories of prefix are emitted first. The targets of hard links are
+// moved as well, but not the ones of symbolic links.
+func WithPathPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.PathPrefix = prefix
+	}
+}
+
+// WithStats counts the rewritten entries into `stats`, it's valid once the
+// rewriting is finished.
+func WithStats(stats *Stats) Option {
+	return func(o *Options) {
+		o.Stats = stats
+	}
+}
+
+// WithTempDir stages the data of sparse files in directory `dir`.
+func WithTempDir(dir string) Option {
+	return func(o *Options) {
+		o.TempDir = dir
+	}
+}
+
+// prefixDirs returns the headers of directory `prefix` and its parents.
+func prefixDirs(prefix string) []*tar.Header {
+	hdrs := []*tar.Header{}
+	dir := ""
+	for _, part := range strings.Split(trimName(prefix), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		hdrs = append(hdrs, &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     "/" + dir + "/",
+			Mode:     0755,
+			ModTime:  time.Now(),
+		})
+	}
+	return hdrs
+}
+
+// addPrefix moves `name` under `prefix`, the trailing slash is kept.
+func addPrefix(prefix, name string) string {
+	prefixed := "/" + path.Join(trimName(prefix), trimName(name))
+	if strings.HasSuffix(name, "/") {
+		prefixed += "/"
+	}
+	return prefixed
+}
+
+type rewriter struct {
+	opts   Options
+	opaque map[string]bool
+	w      io.Writer
+	tw     *tar.Writer
+}
+
+// normalize applies the options of ownership and time to `hdr`.
+func (rw *rewriter) normalize(hdr *tar.Header) {
+	if rw.opts.Ownership != nil {
+		rw.opts.Ownership.apply(hdr)
+	}
+	if epoch := rw.opts.SourceDateEpoch; epoch != nil {
+		if hdr.ModTime.After(*epoch) {
+			hdr.ModTime = *epoch
+		}
+		hdr.AccessTime = time.Time{}
+		hdr.ChangeTime = time.Time{}
+	}
+}
+
+func (rw *rewriter) writeHeader(hdr *tar.Header) error {
+	rw.normalize(hdr)
+	if err := rw.tw.WriteHeader(hdr); err != nil {
+		return errors.Wrapf(err, "write header %s", hdr.Name)
+	}
+
+	if hdr.Typeflag != tar.TypeDir {
+		return nil
+	}
+	name := trimName(hdr.Name)
+	if written, ok := rw.opaque[name]; !ok || written {
+		return nil
+	}
+	rw.opaque[name] = true
+
+	dir := strings.TrimSuffix(hdr.Name, "/")
+	opaqueHdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       dir + "/" + whiteoutOpaqueDir,
+		Mode:       0644,
+		ModTime:    hdr.ModTime,
+		AccessTime: hdr.AccessTime,
+		ChangeTime: hdr.ChangeTime,
+		Format:     hdr.Format,
+	}
+	if err := rw.tw.WriteHeader(opaqueHdr); err != nil {
+		return errors.Wrapf(err, "write opaque whiteout for %s", hdr.Name)
+	}
+
+	return nil
+}
+
+// Rewrite reads the tar stream from `r`, applies the options and writes
+// the rewritten tar stream to `w`.
+func Rewrite(r io.Reader, w io.Writer, opts ...Option) error {
+	rw := &rewriter{
+		opaque: map[string]bool{},
+		w:      w,
+		tw:     tar.NewWriter(w),
+	}
+	for _, opt := range opts {
+		opt(&rw.opts)
+	}
+	for _, dir := range rw.opts.OpaqueDirs {
+		rw.opaque[trimName(dir)] = false
+	}
+	var v *validator
+	if rw.opts.Limits != nil {
+		v = newValidator(*rw.opts.Limits)
+	}
+	prefix := rw.opts.PathPrefix
+	if trimName(prefix) == "" {
+		prefix = ""
+	}
+	for _, hdr := range prefixDirs(prefix) {
+		if err := rw.writeHeader(hdr); err != nil {
+			return err
+		}
+	}
+
+	skipped := map[string]bool{}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar header")
+		}
+		if v != nil {
+			if err := v.validate(hdr); err != nil {
+				return errors.Wrap(err, "validate tar entry")
+			}
+		}
+		if isSpecial(hdr) {
+			switch rw.opts.SpecialFiles {
+			case SpecialFilesSkip:
+				logrus.Debugf("skip special file %s", hdr.Name)
+				skipped[trimName(hdr.Name)] = true
+				continue
+			case SpecialFilesFail:
+				return fmt.Errorf("%w: %s of type %q", ErrSpecialFile, hdr.Name, hdr.Typeflag)
+			}
+		}
+		if hdr.Typeflag == tar.TypeLink && skipped[trimName(hdr.Linkname)] {
+			logrus.Debugf("skip hard link %s to special file", hdr.Name)
+			continue
+		}
+		if prefix != "" {
+			// The root is replaced by the prefix directory.
+			if trimName(hdr.Name) == "" {
+				continue
+			}
+			hdr.Name = addPrefix(prefix, hdr.Name)
+			if hdr.Typeflag == tar.TypeLink {
+				hdr.Linkname = addPrefix(prefix, hdr.Linkname)
+			}
+		}
+		if isSparse(hdr) {
+			// The holes of sparse file are kept instead of expanded to
+			// zeros by archive/tar.
+			if err := rw.writeSparse(hdr, tr); err != nil {
+				return err
+			}
+		} else {
+			if err := rw.writeHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(rw.tw, tr); err != nil {
+				return errors.Wrapf(err, "copy content of %s", hdr.Name)
+			}
+		}
+		if rw.opts.Stats != nil {
+			rw.opts.Stats.add(hdr)
+		}
+	}
+
+	return errors.Wrap(rw.tw.Close(), "close tar writer")
+}
+
+// Writer is a tar stream writer rewriting the written stream to the
+// underlying writer, see Rewrite.
+type Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// NewWriter returns a Writer which rewrites tar stream into `w`, it must
+// be closed by Close or CloseWithError to release the rewrite goroutine.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := Rewrite(pr, w, opts...)
+		if err != nil {
+			pr.CloseWithError(err)
+		} else {
+			// Drain the padding after end-of-archive marker.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		done <- err
+	}()
+	return &Writer{
+		pw:   pw,
+		done: done,
+	}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the written tar stream and waits for the rewriting.
+func (w *Writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
+
+// CloseWithError aborts the rewriting with `err`.
+func (w *Writer) CloseWithError(err error) error {
+	w.pw.CloseWithError(err)
+	<-w.done
+	return err
+}
@@ -0,0 +1,298 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tarstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	hdr  *tar.Header
+	data string
+}
+
+func makeTar(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		e.hdr.Size = int64(len(e.data))
+		require.NoError(t, tw.WriteHeader(e.hdr))
+		_, err := tw.Write([]byte(e.data))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func readTar(t *testing.T, r io.Reader) []entry {
+	entries := []entry{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		entries = append(entries, entry{hdr: hdr, data: string(data)})
+	}
+	return entries
+}
+
+func names(entries []entry) []string {
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.hdr.Name)
+	}
+	return names
+}
+
+func TestRewriteOpaqueDir(t *testing.T) {
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "/data/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: &tar.Header{Name: "/data/foo", Typeflag: tar.TypeReg, Mode: 0644}, data: "foo"},
+		{hdr: &tar.Header{Name: "/data/sub/", Typeflag: tar.TypeDir, Mode: 0755}},
+	})
+
+	var dst bytes.Buffer
+	w := NewWriter(&dst, WithOpaqueDir("/data"))
+	_, err := w.Write(src)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	entries := readTar(t, &dst)
+	require.Equal(t, []string{"/data/", "/data/.wh..wh..opq", "/data/foo", "/data/sub/"}, names(entries))
+	require.Equal(t, "foo", entries[2].data)
+}
+
+func TestWriterCloseWithError(t *testing.T) {
+	var dst bytes.Buffer
+	w := NewWriter(&dst)
+	_, err := w.Write([]byte("truncated"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.CloseWithError(io.ErrUnexpectedEOF), io.ErrUnexpectedEOF)
+}
+
+func TestRewriteOwnership(t *testing.T) {
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "mapped", Typeflag: tar.TypeReg, Uid: 100000, Gid: 101000, Uname: "host"}},
+		{hdr: &tar.Header{Name: "unmapped", Typeflag: tar.TypeReg, Uid: 42, Gid: 42}},
+	})
+
+	uidMap, err := ParseIDMapping("0:100000:65536")
+	require.NoError(t, err)
+	gidMap, err := ParseIDMapping("0:100000:65536")
+	require.NoError(t, err)
+
+	var dst bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithOwnership(Ownership{
+		UIDMaps: []IDMapping{*uidMap},
+		GIDMaps: []IDMapping{*gidMap},
+	})))
+	entries := readTar(t, &dst)
+	require.Equal(t, 0, entries[0].hdr.Uid)
+	require.Equal(t, 1000, entries[0].hdr.Gid)
+	require.Equal(t, "", entries[0].hdr.Uname)
+	require.Equal(t, 42, entries[1].hdr.Uid)
+
+	uid, gid, err := ParseChown("1000:2000")
+	require.NoError(t, err)
+	dst.Reset()
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithOwnership(Ownership{UID: uid, GID: gid})))
+	for _, e := range readTar(t, &dst) {
+		require.Equal(t, 1000, e.hdr.Uid)
+		require.Equal(t, 2000, e.hdr.Gid)
+	}
+
+	_, err = ParseIDMapping("0:100000")
+	require.Error(t, err)
+}
+
+// makeACL returns the ACL xattr of `entries` of tag, perm and id.
+func makeACL(entries [][3]uint32) string {
+	acl := binary.LittleEndian.AppendUint32(nil, aclXattrVersion)
+	for _, entry := range entries {
+		acl = binary.LittleEndian.AppendUint16(acl, uint16(entry[0]))
+		acl = binary.LittleEndian.AppendUint16(acl, uint16(entry[1]))
+		acl = binary.LittleEndian.AppendUint32(acl, entry[2])
+	}
+	return string(acl)
+}
+
+func TestRewriteACLOwnership(t *testing.T) {
+	const aclUserObj, aclGroupObj, aclMask, aclOther = 0x01, 0x04, 0x10, 0x20
+	const undefinedID = 0xffffffff
+	aclOf := func(user, group uint32) string {
+		return makeACL([][3]uint32{
+			{aclUserObj, 7, undefinedID},
+			{aclTagUser, 5, user},
+			{aclGroupObj, 5, undefinedID},
+			{aclTagGroup, 5, group},
+			{aclMask, 5, undefinedID},
+			{aclOther, 0, undefinedID},
+		})
+	}
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "dir", Typeflag: tar.TypeDir, Uid: 100000, Gid: 100000, PAXRecords: map[string]string{
+			"SCHILY.xattr." + aclXattrAccess:  aclOf(101000, 102000),
+			"SCHILY.xattr." + aclXattrDefault: aclOf(42, 101000),
+		}}},
+	})
+
+	uidMap, err := ParseIDMapping("0:100000:65536")
+	require.NoError(t, err)
+	var dst bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithOwnership(Ownership{
+		UIDMaps: []IDMapping{*uidMap},
+		GIDMaps: []IDMapping{*uidMap},
+	})))
+	hdr := readTar(t, &dst)[0].hdr
+	require.Equal(t, aclOf(1000, 2000), hdr.PAXRecords["SCHILY.xattr."+aclXattrAccess])
+	// The ids out of mappings are kept as the owner.
+	require.Equal(t, aclOf(42, 1000), hdr.PAXRecords["SCHILY.xattr."+aclXattrDefault])
+
+	// The named users can't be mapped to the overridden owner.
+	uid, _, err := ParseChown("1000")
+	require.NoError(t, err)
+	dst.Reset()
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithOwnership(Ownership{UID: uid})))
+	hdr = readTar(t, &dst)[0].hdr
+	require.NotContains(t, hdr.PAXRecords, "SCHILY.xattr."+aclXattrAccess)
+	require.NotContains(t, hdr.PAXRecords, "SCHILY.xattr."+aclXattrDefault)
+	require.Empty(t, hdr.Xattrs) //nolint:staticcheck
+
+	// The malformed ACL is dropped.
+	src = makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "file", Typeflag: tar.TypeReg, PAXRecords: map[string]string{
+			"SCHILY.xattr." + aclXattrAccess: "malformed",
+			"SCHILY.xattr.user.foo":          "bar",
+		}}},
+	})
+	dst.Reset()
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithOwnership(Ownership{UIDMaps: []IDMapping{*uidMap}})))
+	hdr = readTar(t, &dst)[0].hdr
+	require.NotContains(t, hdr.PAXRecords, "SCHILY.xattr."+aclXattrAccess)
+	require.Equal(t, "bar", hdr.PAXRecords["SCHILY.xattr.user.foo"])
+}
+
+func TestRewriteSourceDateEpoch(t *testing.T) {
+	epoch := time.Unix(1000, 0)
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "new", Typeflag: tar.TypeReg, ModTime: time.Unix(2000, 0), AccessTime: time.Unix(2000, 0), Format: tar.FormatPAX}},
+		{hdr: &tar.Header{Name: "old", Typeflag: tar.TypeReg, ModTime: time.Unix(500, 0)}},
+	})
+
+	var dst bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithSourceDateEpoch(epoch)))
+	entries := readTar(t, &dst)
+	require.True(t, epoch.Equal(entries[0].hdr.ModTime))
+	require.True(t, entries[0].hdr.AccessTime.IsZero())
+	require.True(t, time.Unix(500, 0).Equal(entries[1].hdr.ModTime))
+}
+
+func TestRewriteLimits(t *testing.T) {
+	rewrite := func(entries []entry, limits Limits) error {
+		var buf bytes.Buffer
+		return Rewrite(bytes.NewReader(makeTar(t, entries)), &buf, WithLimits(limits))
+	}
+
+	valid := []entry{
+		{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: "/data/", Mode: 0755}},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "/data/file", Mode: 0644}, data: "hello"},
+		{hdr: &tar.Header{Typeflag: tar.TypeLink, Name: "/data/link", Linkname: "/data/file"}},
+		{hdr: &tar.Header{Typeflag: tar.TypeSymlink, Name: "/data/symlink", Linkname: "/etc/passwd"}},
+	}
+	require.NoError(t, rewrite(valid, Limits{Root: "/data"}))
+	require.NoError(t, rewrite(valid, Limits{Root: "/data", MaxEntries: 4, MaxSize: 5}))
+	require.ErrorContains(t, rewrite(valid, Limits{Root: "/data", MaxEntries: 3}), "entries exceed")
+	require.ErrorContains(t, rewrite(valid, Limits{Root: "/data", MaxSize: 4}), "size of entries exceeds")
+
+	for _, e := range []entry{
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "/etc/passwd", Mode: 0644}},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "/data/../etc/passwd", Mode: 0644}},
+		{hdr: &tar.Header{Typeflag: tar.TypeReg, Name: "/database", Mode: 0644}},
+		{hdr: &tar.Header{Typeflag: tar.TypeLink, Name: "/data/link", Linkname: "/etc/passwd"}},
+		{hdr: &tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "/data/global"}},
+	} {
+		require.Error(t, rewrite([]entry{e}, Limits{Root: "/data"}), e.hdr.Name)
+	}
+}
+
+func TestRewritePathPrefix(t *testing.T) {
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: &tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: &tar.Header{Name: "etc/foo", Typeflag: tar.TypeReg, Mode: 0644}, data: "foo"},
+		{hdr: &tar.Header{Name: "etc/bar", Typeflag: tar.TypeLink, Linkname: "etc/foo"}},
+		{hdr: &tar.Header{Name: "etc/baz", Typeflag: tar.TypeSymlink, Linkname: "/etc/foo"}},
+	})
+
+	var dst bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithPathPrefix("/pod/sidecar")))
+	entries := readTar(t, &dst)
+	require.Equal(t, []string{"/pod/", "/pod/sidecar/", "/pod/sidecar/etc/", "/pod/sidecar/etc/foo", "/pod/sidecar/etc/bar", "/pod/sidecar/etc/baz"}, names(entries))
+	require.Equal(t, "foo", entries[3].data)
+	require.Equal(t, "/pod/sidecar/etc/foo", entries[4].hdr.Linkname)
+	require.Equal(t, "/etc/foo", entries[5].hdr.Linkname)
+
+	dst.Reset()
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithPathPrefix("/")))
+	require.Equal(t, []string{"/", "etc/", "etc/foo", "etc/bar", "etc/baz"}, names(readTar(t, &dst)))
+}
+
+func TestRewriteSpecialFiles(t *testing.T) {
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "/data/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: &tar.Header{Name: "/data/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3}},
+		{hdr: &tar.Header{Name: "/data/pipe", Typeflag: tar.TypeFifo, Mode: 0644}},
+		{hdr: &tar.Header{Name: "/data/pipe-link", Typeflag: tar.TypeLink, Linkname: "/data/pipe"}},
+		{hdr: &tar.Header{Name: "/data/file", Typeflag: tar.TypeReg, Mode: 0644}, data: "hello"},
+	})
+
+	var dst bytes.Buffer
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst))
+	require.Equal(t, []string{"/data/", "/data/null", "/data/pipe", "/data/pipe-link", "/data/file"}, names(readTar(t, &dst)))
+
+	dst.Reset()
+	require.NoError(t, Rewrite(bytes.NewReader(src), &dst, WithSpecialFiles(SpecialFilesSkip)))
+	require.Equal(t, []string{"/data/", "/data/file"}, names(readTar(t, &dst)))
+
+	dst.Reset()
+	err := Rewrite(bytes.NewReader(src), &dst, WithSpecialFiles(SpecialFilesFail))
+	require.ErrorIs(t, err, ErrSpecialFile)
+	require.EqualError(t, err, `special file found: /data/null of type '3'`)
+
+	policy, err := ParseSpecialFiles("")
+	require.NoError(t, err)
+	require.Equal(t, SpecialFilesKeep, policy)
+	_, err = ParseSpecialFiles("drop")
+	require.EqualError(t, err, "invalid special files policy drop, must be one of skip, keep, fail")
+}
+
+func TestRewriteStats(t *testing.T) {
+	src := makeTar(t, []entry{
+		{hdr: &tar.Header{Name: "/data/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{hdr: &tar.Header{Name: "/data/foo", Typeflag: tar.TypeReg, Mode: 0644}, data: "foo"},
+		{hdr: &tar.Header{Name: "/data/bar", Typeflag: tar.TypeReg, Mode: 0644}, data: "hello"},
+		{hdr: &tar.Header{Name: "/data/.wh.baz", Typeflag: tar.TypeReg, Mode: 0644}},
+		{hdr: &tar.Header{Name: "/data/pipe", Typeflag: tar.TypeFifo, Mode: 0644}},
+	})
+
+	// The opaque whiteout and skipped special files aren't counted.
+	stats := Stats{}
+	require.NoError(t, Rewrite(bytes.NewReader(src), io.Discard, WithOpaqueDir("/data"), WithSpecialFiles(SpecialFilesSkip), WithStats(&stats)))
+	require.Equal(t, Stats{Entries: 4, Files: 2, Whiteouts: 1, Size: 8}, stats)
+
+	stats.Add(Stats{Entries: 1, Files: 1, Size: 2})
+	require.Equal(t, Stats{Entries: 5, Files: 3, Whiteouts: 1, Size: 10}, stats)
+}
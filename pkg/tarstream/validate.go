@@ -0,0 +1,98 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package tarstream
+
+import (
+	"archive/tar"
+	"fmt"
+	"strings"
+)
+
+// Limits validates the tar stream produced by an untrusted source (e.g.
+// the tar executed in container), the rewriting fails on the first entry
+// violating them.
+type Limits struct {
+	// Root is the directory all entries and hardlink targets must be in.
+	Root string
+	// MaxEntries caps the count of entries, 0 means no limit.
+	MaxEntries int
+	// MaxSize caps the total size of entry contents, 0 means no limit.
+	MaxSize int64
+}
+
+// WithLimits validates each entry of the stream by `limits`.
+func WithLimits(limits Limits) Option {
+	return func(o *Options) {
+		o.Limits = &limits
+	}
+}
+
+var allowedTypes = map[byte]bool{
+	tar.TypeReg:     true,
+	tar.TypeRegA:    true, //nolint:staticcheck
+	tar.TypeLink:    true,
+	tar.TypeSymlink: true,
+	tar.TypeChar:    true,
+	tar.TypeBlock:   true,
+	tar.TypeDir:     true,
+	tar.TypeFifo:    true,
+	// Written by `tar --sparse`, the holes are kept by the rewriting.
+	tar.TypeGNUSparse: true,
+}
+
+type validator struct {
+	limits  Limits
+	root    string
+	entries int
+	size    int64
+}
+
+func newValidator(limits Limits) *validator {
+	return &validator{
+		limits: limits,
+		root:   trimName(limits.Root),
+	}
+}
+
+func (v *validator) checkPath(name string) error {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return fmt.Errorf("path %s contains ..", name)
+		}
+	}
+	trimmed := trimName(name)
+	if v.root == "" || trimmed == v.root || strings.HasPrefix(trimmed, v.root+"/") {
+		return nil
+	}
+	return fmt.Errorf("path %s is out of %s", name, v.limits.Root)
+}
+
+func (v *validator) validate(hdr *tar.Header) error {
+	if !allowedTypes[hdr.Typeflag] {
+		return fmt.Errorf("unsupported type %q of entry %s", hdr.Typeflag, hdr.Name)
+	}
+	if hdr.Size < 0 {
+		return fmt.Errorf("invalid size %d of entry %s", hdr.Size, hdr.Name)
+	}
+	if err := v.checkPath(hdr.Name); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		if err := v.checkPath(hdr.Linkname); err != nil {
+			return fmt.Errorf("invalid hardlink %s: %w", hdr.Name, err)
+		}
+	}
+
+	v.entries++
+	if v.limits.MaxEntries > 0 && v.entries > v.limits.MaxEntries {
+		return fmt.Errorf("entries exceed the limit %d", v.limits.MaxEntries)
+	}
+	v.size += hdr.Size
+	if v.limits.MaxSize > 0 && v.size > v.limits.MaxSize {
+		return fmt.Errorf("size of entries exceeds the limit %d", v.limits.MaxSize)
+	}
+
+	return nil
+}
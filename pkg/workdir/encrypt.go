@@ -0,0 +1,215 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package workdir guards the work dir where committed data is staged, it
+// keeps the data encrypted at rest and bounds the disk space used.
+package workdir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EncryptionNone keeps work dir as it is.
+	EncryptionNone = ""
+	// EncryptionRequire requires work dir to be on a dm-crypt device.
+	EncryptionRequire = "require"
+	// EncryptionEphemeral mounts work dir from a loop device encrypted by
+	// a random key, the key is never persisted so data is gone after run.
+	EncryptionEphemeral = "ephemeral"
+)
+
+func run(stdin []byte, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "execute %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// isCryptDevice checks if block device `name` (e.g. dm-0) or any of its
+// underlying devices is a dm-crypt target.
+func isCryptDevice(name string) (bool, error) {
+	sysDir := filepath.Join("/sys/class/block", name)
+	uuid, err := os.ReadFile(filepath.Join(sysDir, "dm", "uuid"))
+	if err == nil && strings.HasPrefix(string(uuid), "CRYPT-") {
+		return true, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "read dm uuid of %s", name)
+	}
+
+	slaves, err := os.ReadDir(filepath.Join(sysDir, "slaves"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "read slaves of %s", name)
+	}
+	for _, slave := range slaves {
+		if crypt, err := isCryptDevice(slave.Name()); err != nil || crypt {
+			return crypt, err
+		}
+	}
+
+	return false, nil
+}
+
+// VerifyEncrypted ensures that `dir` is on a filesystem backed by dm-crypt.
+func VerifyEncrypted(dir string) error {
+	info, err := mount.Lookup(dir)
+	if err != nil {
+		return errors.Wrapf(err, "lookup mount of %s", dir)
+	}
+
+	device, err := filepath.EvalSymlinks(info.Source)
+	if err != nil {
+		return fmt.Errorf("work dir %s is on %s (%s) which is not a block device", dir, info.Source, info.FSType)
+	}
+
+	crypt, err := isCryptDevice(filepath.Base(device))
+	if err != nil {
+		return errors.Wrapf(err, "check device %s", device)
+	}
+	if !crypt {
+		return fmt.Errorf("work dir %s is on %s which is not encrypted by dm-crypt", dir, device)
+	}
+
+	return nil
+}
+
+// Encrypted is an ephemeral encrypted filesystem mounted on work dir.
+type Encrypted struct {
+	dir       string
+	imagePath string
+	loopDev   string
+	cryptName string
+	mounted   bool
+}
+
+// SetupEncrypted creates a sparse image file with `size` bytes next to
+// `dir`, encrypts it with a random key via dm-crypt and mounts it on `dir`.
+func SetupEncrypted(dir string, size uint64) (*Encrypted, error) {
+	enc := &Encrypted{
+		dir:       dir,
+		imagePath: dir + ".img",
+	}
+
+	if err := enc.setup(size); err != nil {
+		if err2 := enc.Close(); err2 != nil {
+			logrus.WithError(err2).Warnf("clean up encrypted work dir")
+		}
+		return nil, err
+	}
+
+	return enc, nil
+}
+
+func (enc *Encrypted) setup(size uint64) error {
+	image, err := os.OpenFile(enc.imagePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.Wrap(err, "create image file")
+	}
+	defer image.Close()
+	if err := image.Truncate(int64(size)); err != nil {
+		return errors.Wrap(err, "truncate image file")
+	}
+
+	enc.loopDev, err = run(nil, "losetup", "--find", "--show", enc.imagePath)
+	if err != nil {
+		return errors.Wrap(err, "attach loop device")
+	}
+
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		return errors.Wrap(err, "generate key")
+	}
+	cryptName := filepath.Base(enc.dir)
+	if _, err := run(
+		key, "cryptsetup", "open", "--type", "plain", "--cipher", "aes-xts-plain64",
+		"--key-size", "512", "--key-file", "-", enc.loopDev, cryptName,
+	); err != nil {
+		return errors.Wrap(err, "open dm-crypt device")
+	}
+	enc.cryptName = cryptName
+
+	device := filepath.Join("/dev/mapper", enc.cryptName)
+	if _, err := run(nil, "mkfs.ext4", "-q", device); err != nil {
+		return errors.Wrap(err, "make filesystem")
+	}
+
+	if err := mountKeepingAttrs(mount.Mount{Type: "ext4", Source: device}, enc.dir); err != nil {
+		return err
+	}
+	enc.mounted = true
+
+	return nil
+}
+
+// mountKeepingAttrs mounts `m` on `dir` and applies the mode and owner of
+// `dir` to the mounted root, which is otherwise the root of the fresh
+// filesystem, 0755 and owned by root.
+func mountKeepingAttrs(m mount.Mount, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", dir)
+	}
+	if err := mount.All([]mount.Mount{m}, dir); err != nil {
+		return errors.Wrapf(err, "mount %s", m.Source)
+	}
+	if err := os.Chmod(dir, info.Mode().Perm()); err != nil {
+		mount.UnmountAll(dir, 0)
+		return errors.Wrapf(err, "chmod mounted %s", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dir, int(stat.Uid), int(stat.Gid)); err != nil {
+			mount.UnmountAll(dir, 0)
+			return errors.Wrapf(err, "chown mounted %s", dir)
+		}
+	}
+	return nil
+}
+
+// Close unmounts work dir and destroys the encrypted device, the data
+// written to work dir is unrecoverable after that.
+func (enc *Encrypted) Close() error {
+	if enc.mounted {
+		if err := mount.UnmountAll(enc.dir, 0); err != nil {
+			return errors.Wrapf(err, "unmount %s", enc.dir)
+		}
+		enc.mounted = false
+	}
+	if enc.cryptName != "" {
+		if _, err := run(nil, "cryptsetup", "close", enc.cryptName); err != nil {
+			return errors.Wrap(err, "close dm-crypt device")
+		}
+		enc.cryptName = ""
+	}
+	if enc.loopDev != "" {
+		if _, err := run(nil, "losetup", "--detach", enc.loopDev); err != nil {
+			return errors.Wrap(err, "detach loop device")
+		}
+		enc.loopDev = ""
+	}
+	if err := os.Remove(enc.imagePath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove image file")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package workdir
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/stretchr/testify/require"
+)
+
+func requireOwner(t *testing.T, path string, mode os.FileMode, uid, gid uint32) {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, mode, info.Mode().Perm())
+	stat := info.Sys().(*syscall.Stat_t)
+	require.Equal(t, uid, stat.Uid)
+	require.Equal(t, gid, stat.Gid)
+}
+
+func TestMountKeepingAttrs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("mount requires root")
+	}
+	dir := filepath.Join(t.TempDir(), "work")
+	require.NoError(t, os.Mkdir(dir, 0700))
+	require.NoError(t, os.Chmod(dir, 0750))
+	require.NoError(t, os.Lchown(dir, 1000, 1000))
+
+	// The root of fresh tmpfs is 1777 and owned by root.
+	require.NoError(t, mountKeepingAttrs(mount.Mount{Type: "tmpfs", Source: "tmpfs"}, dir))
+	defer mount.UnmountAll(dir, 0)
+	requireOwner(t, dir, 0750, 1000, 1000)
+}
+
+func TestSetupEncrypted(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("encrypted work dir requires root")
+	}
+	for _, tool := range []string{"losetup", "cryptsetup", "mkfs.ext4"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+	dir := filepath.Join(t.TempDir(), "work")
+	require.NoError(t, os.Mkdir(dir, 0700))
+	require.NoError(t, os.Lchown(dir, 1000, 1000))
+
+	enc, err := SetupEncrypted(dir, 16<<20)
+	require.NoError(t, err)
+	defer enc.Close()
+	requireOwner(t, dir, 0700, 1000, 1000)
+	require.NoError(t, VerifyEncrypted(dir))
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package workdir
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ErrQuotaExceeded is returned by the writers of Quota once the files
+// written into work dir exceed the limit.
+var ErrQuotaExceeded = errors.New("work dir quota exceeded")
+
+// Available returns the bytes available to unprivileged users on the
+// filesystem of `dir`.
+func Available(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, errors.Wrapf(err, "statfs %s", dir)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// Quota limits the total size of the files written into work dir, a
+// limit of 0 means unlimited. A nil Quota accounts nothing.
+type Quota struct {
+	limit uint64
+	mutex sync.Mutex
+	total uint64
+	// usage records the size written of each file, so a file rewritten
+	// by retry is not counted twice.
+	usage map[string]uint64
+}
+
+func NewQuota(limit uint64) *Quota {
+	return &Quota{
+		limit: limit,
+		usage: map[string]uint64{},
+	}
+}
+
+// Limit returns the limit of quota, 0 means unlimited.
+func (q *Quota) Limit() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.limit
+}
+
+// Set resets the usage of file `path` to `size`, it's called when the file
+// is (re)created or truncated.
+func (q *Quota) Set(path string, size uint64) {
+	if q == nil {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.total = q.total - q.usage[path] + size
+	q.usage[path] = size
+}
+
+func (q *Quota) add(path string, size uint64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.limit > 0 && q.total+size > q.limit {
+		return errors.Wrapf(
+			ErrQuotaExceeded, "writing %s would use %s of %s",
+			path, humanize.IBytes(q.total+size), humanize.IBytes(q.limit),
+		)
+	}
+	q.total += size
+	q.usage[path] += size
+	return nil
+}
+
+func (q *Quota) release(path string, size uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.total -= size
+	q.usage[path] -= size
+}
+
+// Writer wraps `w` which writes to file `path` to account the written
+// bytes, the write fails with ErrQuotaExceeded if the quota is exceeded.
+func (q *Quota) Writer(path string, w io.Writer) io.Writer {
+	if q == nil {
+		return w
+	}
+	q.Set(path, 0)
+	return &quotaWriter{quota: q, path: path, w: w}
+}
+
+type quotaWriter struct {
+	quota *Quota
+	path  string
+	w     io.Writer
+}
+
+func (qw *quotaWriter) Write(p []byte) (int, error) {
+	if err := qw.quota.add(qw.path, uint64(len(p))); err != nil {
+		return 0, err
+	}
+	n, err := qw.w.Write(p)
+	if n < len(p) {
+		qw.quota.release(qw.path, uint64(len(p)-n))
+	}
+	return n, err
+}
+
+// Check fails fast if `required` bytes can't fit in the space available on
+// the filesystem of `dir` or the quota.
+func (q *Quota) Check(dir string, required uint64) error {
+	available, err := Available(dir)
+	if err != nil {
+		return err
+	}
+	if required > available {
+		return fmt.Errorf(
+			"not enough space in work dir %s: estimated %s required but only %s available",
+			dir, humanize.IBytes(required), humanize.IBytes(available),
+		)
+	}
+	if limit := q.Limit(); limit > 0 && required > limit {
+		return fmt.Errorf(
+			"work dir quota too small: estimated %s required but quota is %s",
+			humanize.IBytes(required), humanize.IBytes(limit),
+		)
+	}
+	return nil
+}
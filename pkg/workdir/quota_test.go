@@ -0,0 +1,50 @@
+// Copyright 2023 Ant Group. All rights reserved.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package workdir
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuota(t *testing.T) {
+	quota := NewQuota(10)
+
+	var buf bytes.Buffer
+	w := quota.Writer("blob-upper", &buf)
+	_, err := w.Write([]byte("123456"))
+	require.NoError(t, err)
+
+	// Rewriting a file by retry resets its usage.
+	w = quota.Writer("blob-upper", &buf)
+	_, err = w.Write([]byte("123456"))
+	require.NoError(t, err)
+
+	w2 := quota.Writer("blob-mount-0", &buf)
+	_, err = w2.Write([]byte("1234"))
+	require.NoError(t, err)
+	_, err = w2.Write([]byte("5"))
+	require.True(t, errors.Is(err, ErrQuotaExceeded))
+
+	quota.Set("blob-upper", 1)
+	_, err = w2.Write([]byte("5"))
+	require.NoError(t, err)
+
+	var unlimited *Quota
+	require.Equal(t, &buf, unlimited.Writer("blob-upper", &buf))
+}
+
+func TestQuotaCheck(t *testing.T) {
+	dir := t.TempDir()
+	available, err := Available(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, NewQuota(0).Check(dir, 1))
+	require.Error(t, NewQuota(0).Check(dir, available+1<<40))
+	require.Error(t, NewQuota(1).Check(dir, 2))
+}
@@ -0,0 +1,91 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// mediaTypeEmptyJSON is the media type of the empty config `{}` of the
+// artifacts, whose type is set by the artifactType of manifest.
+const mediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+
+// emptyJSON is the config of artifacts described by emptyJSONDesc.
+var emptyJSON = []byte("{}")
+
+var emptyJSONDesc = ocispec.Descriptor{
+	MediaType: mediaTypeEmptyJSON,
+	Digest:    digest.FromBytes(emptyJSON),
+	Size:      int64(len(emptyJSON)),
+}
+
+// artifactManifest is the image manifest of an artifact, with the
+// artifactType field of image-spec v1.1.
+type artifactManifest struct {
+	ocispec.Manifest
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// newArtifactManifest returns the manifest in json of the artifact of
+// `artifactType` with the only layer `payload` referring to `subject`.
+func newArtifactManifest(artifactType string, payload, subject ocispec.Descriptor, annotations map[string]string) ([]byte, error) {
+	manifest := artifactManifest{
+		Manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    emptyJSONDesc,
+			Layers:    []ocispec.Descriptor{payload},
+			Subject: &ocispec.Descriptor{
+				MediaType: subject.MediaType,
+				Digest:    subject.Digest,
+				Size:      subject.Size,
+			},
+			Annotations: annotations,
+		},
+		ArtifactType: artifactType,
+	}
+	return json.Marshal(manifest)
+}
+
+// pushArtifact pushes `data` of `payload` whose media type is set, then
+// pushes the artifact of `artifactType` with the only layer `payload`
+// referring to `subject`, which is discoverable by referrers API of
+// registry or its fallback tag. The annotations of artifact are also kept
+// in the descriptor of its manifest returned.
+func (wf *Workflow) pushArtifact(
+	ctx context.Context, remoter *remote.Remote, artifactType string, payload ocispec.Descriptor, data []byte,
+	subject ocispec.Descriptor, annotations map[string]string,
+) (*ocispec.Descriptor, error) {
+	payload.Digest = digest.FromBytes(data)
+	payload.Size = int64(len(data))
+	if err := remoter.Push(ctx, payload, true, bytes.NewReader(data)); err != nil {
+		return nil, errors.Wrap(err, "push payload")
+	}
+
+	if err := remoter.Push(ctx, emptyJSONDesc, true, bytes.NewReader(emptyJSON)); err != nil {
+		return nil, errors.Wrap(err, "push config")
+	}
+
+	manifest, err := newArtifactManifest(artifactType, payload, subject, annotations)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal manifest")
+	}
+	manifestDesc := ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		Digest:       digest.FromBytes(manifest),
+		Size:         int64(len(manifest)),
+		ArtifactType: artifactType,
+		Annotations:  annotations,
+	}
+	if err := remoter.PushReferrer(ctx, wf.hostsFunc, manifestDesc, manifest, subject.Digest); err != nil {
+		return nil, errors.Wrap(err, "push manifest")
+	}
+	return &manifestDesc, nil
+}
@@ -0,0 +1,34 @@
+package workflow
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewArtifactManifest(t *testing.T) {
+	payload := ocispec.Descriptor{MediaType: MediaTypeCommitMetadata, Digest: digest.FromString("payload"), Size: 7}
+	subject := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Digest:      digest.FromString("subject"),
+		Size:        100,
+		Annotations: map[string]string{"key": "value"},
+	}
+	data, err := newArtifactManifest(MediaTypeCommitMetadata, payload, subject, map[string]string{ocispec.AnnotationCreated: "now"})
+	require.NoError(t, err)
+
+	manifest := artifactManifest{}
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	require.Equal(t, MediaTypeCommitMetadata, manifest.ArtifactType)
+	require.Equal(t, ocispec.MediaTypeImageManifest, manifest.MediaType)
+	require.Equal(t, "application/vnd.oci.empty.v1+json", manifest.Config.MediaType)
+	require.Equal(t, digest.FromString("{}"), manifest.Config.Digest)
+	require.Equal(t, int64(2), manifest.Config.Size)
+	require.Equal(t, []ocispec.Descriptor{payload}, manifest.Layers)
+	// Only the media type, digest and size of subject are referred.
+	require.Equal(t, &ocispec.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size}, manifest.Subject)
+	require.Equal(t, map[string]string{ocispec.AnnotationCreated: "now"}, manifest.Annotations)
+}
@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+const (
+	// mediaTypeBlobIDs is the artifact type of the referrer artifact
+	// holding all blob ids.
+	mediaTypeBlobIDs = "application/vnd.nydus.cli.blob-ids.v1+json"
+	// mediaTypeBlobIDsList is the blob of all blob ids.
+	mediaTypeBlobIDsList = "application/vnd.nydus.cli.blob-ids.list.v1+json"
+)
+
+// boundBlobIDs returns the annotation value of blob `ids` within `limit`
+// bytes of annotation `key` and value. If all ids don't fit and `fullKey`
+// is set, the returned full ids blob should be pushed with the image, and
+// only the latest ids which fit are kept in annotation, with the value of
+// annotation `fullKey` describing the full ids blob. Otherwise all ids are
+// kept in annotation regardless of limit.
+func boundBlobIDs(ids []string, key, fullKey string, limit int) (string, string, []byte, error) {
+	value, err := json.Marshal(ids)
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "marshal blob ids")
+	}
+	if len(key)+len(value) <= limit || fullKey == "" {
+		return string(value), "", nil, nil
+	}
+
+	fullDesc, err := json.Marshal(ocispec.Descriptor{
+		MediaType: mediaTypeBlobIDsList,
+		Digest:    digest.FromBytes(value),
+		Size:      int64(len(value)),
+	})
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "marshal full blob ids descriptor")
+	}
+	// The ids of the latest commits are at the end.
+	for kept := len(ids) - 1; kept >= 0; kept-- {
+		bounded, err := json.Marshal(ids[len(ids)-kept:])
+		if err != nil {
+			return "", "", nil, errors.Wrap(err, "marshal blob ids")
+		}
+		if len(key)+len(bounded) <= limit {
+			return string(bounded), string(fullDesc), value, nil
+		}
+	}
+	return "", "", nil, fmt.Errorf("blob ids exceed annotation limit %d", limit)
+}
+
+// parseBlobIDs returns the blob ids in annotations of bootstrap layer, and
+// the descriptor of full ids blob if the ids are truncated.
+func parseBlobIDs(annotations map[string]string, keys config.AnnotationKeys) ([]string, *ocispec.Descriptor, error) {
+	value, ok := annotations[keys.BlobIDs]
+	if !ok || keys.BlobIDs == "" {
+		return nil, nil, nil
+	}
+	ids := []string{}
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		return nil, nil, errors.Wrap(err, "unmarshal blob ids")
+	}
+	fullValue, ok := annotations[keys.BlobIDsFull]
+	if !ok || keys.BlobIDsFull == "" {
+		return ids, nil, nil
+	}
+	full := ocispec.Descriptor{}
+	if err := json.Unmarshal([]byte(fullValue), &full); err != nil {
+		return nil, nil, errors.Wrap(err, "unmarshal full blob ids descriptor")
+	}
+	return ids, &full, nil
+}
+
+// readBlobIDs returns all blob ids in annotations of bootstrap layer, the
+// full ids blob is read by `read` if truncated.
+func readBlobIDs(annotations map[string]string, keys config.AnnotationKeys, read func(ocispec.Descriptor) ([]byte, error)) ([]string, error) {
+	ids, full, err := parseBlobIDs(annotations, keys)
+	if err != nil || full == nil {
+		return ids, err
+	}
+	data, err := read(*full)
+	if err != nil {
+		return nil, errors.Wrap(err, "read full blob ids")
+	}
+	ids = []string{}
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrap(err, "unmarshal full blob ids")
+	}
+	return ids, nil
+}
+
+// pullBlobIDs returns all blob ids in annotations of bootstrap layer of
+// image in `remoter`, the full ids blob is pulled if truncated.
+func pullBlobIDs(ctx context.Context, remoter *remote.Remote, annotations map[string]string, keys config.AnnotationKeys) ([]string, error) {
+	return readBlobIDs(annotations, keys, func(desc ocispec.Descriptor) ([]byte, error) {
+		reader, err := remoter.Pull(ctx, desc, true)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(io.LimitReader(reader, desc.Size))
+		if err != nil {
+			return nil, err
+		}
+		if dgst := digest.FromBytes(data); dgst != desc.Digest {
+			return nil, fmt.Errorf("digest mismatch: %s != %s", dgst, desc.Digest)
+		}
+		return data, nil
+	})
+}
+
+// pushBlobIDs pushes the full blob ids to `remoter`, then pushes the blob
+// ids artifact referring to `subject`, which is discoverable by referrers
+// API of registry or its fallback tag.
+func (wf *Workflow) pushBlobIDs(ctx context.Context, remoter *remote.Remote, full []byte, subject ocispec.Descriptor) error {
+	manifestDesc, err := wf.pushArtifact(
+		ctx, remoter, mediaTypeBlobIDs, ocispec.Descriptor{MediaType: mediaTypeBlobIDsList}, full, subject, nil,
+	)
+	if err != nil {
+		return errors.Wrap(err, "push blob ids")
+	}
+	logrus.Infof("pushed blob ids artifact %s referring to %s", manifestDesc.Digest, subject.Digest)
+	return nil
+}
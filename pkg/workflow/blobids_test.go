@@ -0,0 +1,63 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestBoundBlobIDs(t *testing.T) {
+	keys, err := (&config.Annotations{}).ResolvedKeys()
+	require.NoError(t, err)
+
+	// Each commit of the chain adds a blob in external backend, until the
+	// blob ids are over the limit.
+	ids := []string{}
+	truncated := 0
+	for idx := 0; idx < 128; idx++ {
+		ids = append(ids, digest.FromString(fmt.Sprint(idx)).Encoded())
+		value, fullValue, full, err := boundBlobIDs(ids, keys.BlobIDs, keys.BlobIDsFull, 4096)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(keys.BlobIDs)+len(value), 4096)
+
+		annotations := map[string]string{keys.BlobIDs: value}
+		if full == nil {
+			require.Empty(t, fullValue)
+		} else {
+			truncated++
+			annotations[keys.BlobIDsFull] = fullValue
+			kept, fullDesc, err := parseBlobIDs(annotations, keys)
+			require.NoError(t, err)
+			require.Less(t, len(kept), len(ids))
+			// The ids of the latest commits are kept.
+			require.Equal(t, ids[len(ids)-len(kept):], kept)
+			require.Equal(t, mediaTypeBlobIDsList, fullDesc.MediaType)
+			require.Equal(t, digest.FromBytes(full), fullDesc.Digest)
+			require.Equal(t, int64(len(full)), fullDesc.Size)
+		}
+
+		all, err := readBlobIDs(annotations, keys, func(desc ocispec.Descriptor) ([]byte, error) {
+			require.Equal(t, digest.FromBytes(full), desc.Digest)
+			return full, nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, ids, all)
+	}
+	require.NotZero(t, truncated)
+
+	// The blob ids are never truncated without the key of full blob ids.
+	keys.BlobIDsFull = ""
+	value, fullValue, full, err := boundBlobIDs(ids, keys.BlobIDs, keys.BlobIDsFull, 4096)
+	require.NoError(t, err)
+	require.Empty(t, fullValue)
+	require.Nil(t, full)
+	all := []string{}
+	require.NoError(t, json.Unmarshal([]byte(value), &all))
+	require.Equal(t, ids, all)
+}
@@ -0,0 +1,144 @@
+package workflow
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+// bootstrapLayerFormat returns the file extension and media type of
+// bootstrap layer compressed as configured by `cfg`.
+func bootstrapLayerFormat(cfg config.Bootstrap) (string, string, error) {
+	switch cfg.Compression {
+	case "", config.CompressionGzip:
+		return ".gz", ocispec.MediaTypeImageLayerGzip, nil
+	case config.CompressionZstd:
+		return ".zst", ocispec.MediaTypeImageLayerZstd, nil
+	default:
+		return "", "", fmt.Errorf("unsupported bootstrap compression %s", cfg.Compression)
+	}
+}
+
+// compressBootstrap returns the writer compressing bootstrap tar to `w` as
+// configured by `cfg`, the level 0 is the default level of compression.
+func compressBootstrap(w io.Writer, cfg config.Bootstrap) (io.WriteCloser, error) {
+	switch cfg.Compression {
+	case "", config.CompressionGzip:
+		level := cfg.CompressionLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		writer, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, errors.Wrap(err, "create gzip writer")
+		}
+		return writer, nil
+	case config.CompressionZstd:
+		opts := []zstd.EOption{}
+		if cfg.CompressionLevel != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(cfg.CompressionLevel)))
+		}
+		writer, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "create zstd writer")
+		}
+		return writer, nil
+	default:
+		return nil, fmt.Errorf("unsupported bootstrap compression %s", cfg.Compression)
+	}
+}
+
+// bootstrapCachePath returns the path of bootstrap unpacked from layer
+// `dgst` in cache dir.
+func bootstrapCachePath(cacheDir string, dgst digest.Digest) (string, error) {
+	if err := dgst.Validate(); err != nil {
+		return "", errors.Wrapf(err, "invalid bootstrap layer digest %s", dgst)
+	}
+	return filepath.Join(cacheDir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// loadCachedBootstrap copies the cached bootstrap of layer `dgst` to
+// `target`, returns false if it's not cached. The modification time of
+// cached bootstrap is updated on hit, so the cache dir can be cleaned up
+// by the time bootstraps are last used.
+func (wf *Workflow) loadCachedBootstrap(dgst digest.Digest, target string) (bool, error) {
+	if wf.cfg.Bootstrap.CacheDir == "" {
+		return false, nil
+	}
+	path, err := bootstrapCachePath(wf.cfg.Bootstrap.CacheDir, dgst)
+	if err != nil {
+		return false, err
+	}
+	cached, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "open cached bootstrap")
+	}
+	defer cached.Close()
+
+	file, err := wf.createFile(target)
+	if err != nil {
+		return false, errors.Wrap(err, "create bootstrap file")
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, cached); err != nil {
+		return false, errors.Wrap(err, "copy cached bootstrap")
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logrus.WithError(err).Warnf("update modification time of cached bootstrap %s", path)
+	}
+	return true, nil
+}
+
+// cacheBootstrap copies the bootstrap `source` unpacked from layer `dgst`
+// into cache dir. The bootstrap is copied to a temp file renamed at last,
+// so the concurrent runs never see a partial bootstrap.
+func (wf *Workflow) cacheBootstrap(dgst digest.Digest, source string) error {
+	if wf.cfg.Bootstrap.CacheDir == "" {
+		return nil
+	}
+	path, err := bootstrapCachePath(wf.cfg.Bootstrap.CacheDir, dgst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "create bootstrap cache dir")
+	}
+
+	src, err := os.Open(source)
+	if err != nil {
+		return errors.Wrap(err, "open bootstrap")
+	}
+	defer src.Close()
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+dgst.Encoded()+"-")
+	if err != nil {
+		return errors.Wrap(err, "create temp cached bootstrap")
+	}
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, src); err != nil {
+		temp.Close()
+		return errors.Wrap(err, "copy bootstrap to cache")
+	}
+	if err := temp.Close(); err != nil {
+		return errors.Wrap(err, "close temp cached bootstrap")
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return errors.Wrap(err, "rename cached bootstrap")
+	}
+	return nil
+}
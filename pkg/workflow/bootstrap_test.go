@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestCompressBootstrap(t *testing.T) {
+	data := bytes.Repeat([]byte("bootstrap"), 1024)
+	for _, tc := range []struct {
+		cfg       config.Bootstrap
+		ext       string
+		mediaType string
+	}{
+		{config.Bootstrap{}, ".gz", ocispec.MediaTypeImageLayerGzip},
+		{config.Bootstrap{Compression: config.CompressionGzip, CompressionLevel: 1}, ".gz", ocispec.MediaTypeImageLayerGzip},
+		{config.Bootstrap{Compression: config.CompressionZstd}, ".zst", ocispec.MediaTypeImageLayerZstd},
+		{config.Bootstrap{Compression: config.CompressionZstd, CompressionLevel: 19}, ".zst", ocispec.MediaTypeImageLayerZstd},
+	} {
+		ext, mediaType, err := bootstrapLayerFormat(tc.cfg)
+		require.NoError(t, err)
+		require.Equal(t, tc.ext, ext)
+		require.Equal(t, tc.mediaType, mediaType)
+
+		buf := bytes.Buffer{}
+		writer, err := compressBootstrap(&buf, tc.cfg)
+		require.NoError(t, err)
+		_, err = writer.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		reader, err := compression.DecompressStream(&buf)
+		require.NoError(t, err)
+		decompressed, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, data, decompressed)
+	}
+
+	_, _, err := bootstrapLayerFormat(config.Bootstrap{Compression: "lz4"})
+	require.Error(t, err)
+}
+
+func TestBootstrapCache(t *testing.T) {
+	workDir := t.TempDir()
+	wf := &Workflow{
+		cfg:      &config.Config{Bootstrap: config.Bootstrap{CacheDir: t.TempDir()}},
+		workDir:  workDir,
+		fileMode: 0600,
+	}
+	dgst := digest.FromString("bootstrap layer")
+
+	target := filepath.Join(workDir, "bootstrap-base")
+	cached, err := wf.loadCachedBootstrap(dgst, target)
+	require.NoError(t, err)
+	require.False(t, cached)
+
+	require.NoError(t, os.WriteFile(target, []byte("bootstrap"), 0600))
+	require.NoError(t, wf.cacheBootstrap(dgst, target))
+
+	another := filepath.Join(workDir, "bootstrap-another")
+	cached, err = wf.loadCachedBootstrap(dgst, another)
+	require.NoError(t, err)
+	require.True(t, cached)
+	data, err := os.ReadFile(another)
+	require.NoError(t, err)
+	require.Equal(t, []byte("bootstrap"), data)
+
+	_, err = wf.loadCachedBootstrap(digest.Digest("sha256:../../etc/passwd"), another)
+	require.Error(t, err)
+
+	wf.cfg.Bootstrap.CacheDir = ""
+	cached, err = wf.loadCachedBootstrap(dgst, another)
+	require.NoError(t, err)
+	require.False(t, cached)
+}
@@ -0,0 +1,160 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// builderLogTail is the maximum lines and bytes of builder log included in
+// the error of failed builder.
+const (
+	builderLogTailLines = 20
+	builderLogTailSize  = 4096
+)
+
+// builderScript runs builder with the output captured to log file, the
+// log level and the extra arguments passed through. The builder is run by converter, which
+// logs its output by logrus along with the other builders run in parallel,
+// so the output of a failed builder is hard to find.
+const builderScript = `#!/bin/sh
+builder=%s
+log=%s
+level=%s
+case "$1" in
+--version|-V)
+	exec "$builder" "$@"
+	;;
+esac
+if [ -n "$level" ]; then
+	prev=
+	for arg in "$@"; do
+		shift
+		if [ "$prev" = "--log-level" ]; then
+			set -- "$@" "$level"
+		else
+			set -- "$@" "$arg"
+		fi
+		prev=$arg
+	done
+fi
+case "$1" in
+create)
+	set -- "$@"%s
+	;;
+merge)
+	set -- "$@"%s
+	;;
+esac
+exec "$builder" "$@" >>"$log" 2>&1
+`
+
+// builderCommands are the subcommands of builder run by converter, which
+// the extra arguments are appended to.
+var builderCommands = []string{"create", "merge"}
+
+// builderLog is the log file of a builder run.
+type builderLog struct {
+	path string
+}
+
+// builderArgs returns the extra arguments of each builder subcommand, the
+// arguments without subcommand prefix are appended to all subcommands.
+func builderArgs(args []string) map[string][]string {
+	commandArgs := map[string][]string{}
+	for _, arg := range args {
+		matched := false
+		for _, command := range builderCommands {
+			if strings.HasPrefix(arg, command+":") {
+				commandArgs[command] = append(commandArgs[command], strings.TrimPrefix(arg, command+":"))
+				matched = true
+			}
+		}
+		if !matched {
+			for _, command := range builderCommands {
+				commandArgs[command] = append(commandArgs[command], arg)
+			}
+		}
+	}
+	return commandArgs
+}
+
+// shellQuote quotes `s` as a single word of shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// builder returns the path of script running builder for `name`, whose
+// output is captured to a log file in work dir.
+func (wf *Workflow) builder(name string) (string, *builderLog, error) {
+	log := &builderLog{path: filepath.Join(wf.workDir, "builder-"+name+".log")}
+	file, err := wf.createFile(log.path)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create builder log file")
+	}
+	file.Close()
+
+	script := filepath.Join(wf.workDir, "builder-"+name+".sh")
+	quotedArgs := []interface{}{shellQuote(wf.cfg.Base.Builder), shellQuote(log.path), shellQuote(wf.cfg.Base.BuilderLogLevel)}
+	commandArgs := builderArgs(wf.cfg.Builder.Args)
+	for _, command := range builderCommands {
+		quoted := ""
+		for _, arg := range commandArgs[command] {
+			quoted += " " + shellQuote(arg)
+		}
+		quotedArgs = append(quotedArgs, quoted)
+	}
+	content := fmt.Sprintf(builderScript, quotedArgs...)
+	if err := os.WriteFile(script, []byte(content), 0700); err != nil {
+		return "", nil, errors.Wrap(err, "write builder script")
+	}
+	return script, log, nil
+}
+
+// tail returns the last lines of log, at most builderLogTailLines lines
+// and builderLogTailSize bytes.
+func (log *builderLog) tail() (string, error) {
+	file, err := os.Open(log.path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - builderLogTailSize
+	if offset < 0 {
+		offset = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, offset, info.Size()-offset))
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(string(bytes.TrimSpace(data)), "\n")
+	// The first line may be cut in the middle.
+	if offset > 0 && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > builderLogTailLines {
+		lines = lines[len(lines)-builderLogTailLines:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// wrap adds the tail of log to the error of builder.
+func (log *builderLog) wrap(err error) error {
+	if err == nil || log == nil {
+		return err
+	}
+	tail, tailErr := log.tail()
+	if tailErr != nil || tail == "" {
+		return withClass(err, ErrBuilder)
+	}
+	return withClass(fmt.Errorf("%w, tail of builder log %s:\n%s", err, log.path, tail), ErrBuilder)
+}
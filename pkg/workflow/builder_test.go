@@ -0,0 +1,78 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestBuilderLog(t *testing.T) {
+	workDir := t.TempDir()
+	fakeBuilder := filepath.Join(t.TempDir(), "nydus-image's")
+	require.NoError(t, os.WriteFile(fakeBuilder, []byte(`#!/bin/sh
+if [ "$1" = "--version" ]; then
+	echo "Version: v2.2.4"
+	exit 0
+fi
+echo "args: $*"
+echo "invalid bootstrap" >&2
+exit 1
+`), 0755))
+	wf := &Workflow{
+		cfg: &config.Config{
+			Base:    config.Base{Builder: fakeBuilder, BuilderLogLevel: "debug"},
+			Builder: config.Builder{Args: []string{"create:--chunk-size=0x100000", "merge:--ignored", "it's"}},
+		},
+		workDir:  workDir,
+		fileMode: 0600,
+	}
+
+	builder, log, err := wf.builder("blob-upper")
+	require.NoError(t, err)
+
+	output, err := exec.Command(builder, "--version").Output()
+	require.NoError(t, err)
+	require.Equal(t, "Version: v2.2.4\n", string(output))
+
+	output, err = exec.Command(builder, "create", "--log-level", "warn", "--blob", "blob").CombinedOutput()
+	require.Error(t, err)
+	require.Empty(t, output)
+
+	wrapped := log.wrap(err)
+	require.ErrorIs(t, wrapped, err)
+	require.ErrorIs(t, wrapped, ErrBuilder)
+	require.Equal(t, fmt.Sprintf("exit status 1, tail of builder log %s:\nargs: create --log-level debug --blob blob --chunk-size=0x100000 it's\ninvalid bootstrap", log.path), wrapped.Error())
+	require.Nil(t, log.wrap(nil))
+}
+
+func TestBuilderLogTail(t *testing.T) {
+	log := &builderLog{path: filepath.Join(t.TempDir(), "builder.log")}
+	lines := []string{}
+	for idx := 0; idx < 100; idx++ {
+		lines = append(lines, fmt.Sprintf("line %d", idx))
+	}
+	require.NoError(t, os.WriteFile(log.path, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+	tail, err := log.tail()
+	require.NoError(t, err)
+	require.Equal(t, strings.Join(lines[100-builderLogTailLines:], "\n"), tail)
+
+	require.NoError(t, os.WriteFile(log.path, []byte(strings.Repeat("x", builderLogTailSize*2)+"\nlast"), 0600))
+	tail, err = log.tail()
+	require.NoError(t, err)
+	require.Equal(t, "last", tail)
+}
+
+func TestBuilderArgs(t *testing.T) {
+	require.Equal(t, map[string][]string{
+		"create": {"--chunk-size", "0x100000", "--batch-size=0x200000"},
+		"merge":  {"--batch-size=0x200000", "--prefetch-policy=fs"},
+	}, builderArgs([]string{"create:--chunk-size", "create:0x100000", "--batch-size=0x200000", "merge:--prefetch-policy=fs"}))
+	require.Empty(t, builderArgs(nil))
+}
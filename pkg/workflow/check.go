@@ -0,0 +1,318 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/identity"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+const (
+	// CheckShallow only checks the existence and size of blobs.
+	CheckShallow = "shallow"
+	// CheckDeep fetches the content of blobs and digests it again.
+	CheckDeep = "deep"
+)
+
+type CheckOption struct {
+	Ref string
+	// Mode is one of CheckShallow and CheckDeep.
+	Mode string
+	// Parallelism is the maximum count of blobs verified concurrently,
+	// 0 means no limit.
+	Parallelism int
+	// TrustBundle is the path of SPIFFE trust bundle verifying the identity
+	// attesting the image, the identity is not verified if empty.
+	TrustBundle string
+}
+
+// BlobCheck is the verification result of a blob.
+type BlobCheck struct {
+	Digest digest.Digest `json:"digest"`
+	// Size is the expected size of blob, -1 if unknown, e.g. the blobs in
+	// external backend which are referenced only by ids.
+	Size    int64         `json:"size"`
+	Passed  bool          `json:"passed"`
+	Error   string        `json:"error,omitempty"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// IdentityCheck is the verification result of the identity attesting
+// image.
+type IdentityCheck struct {
+	Source string `json:"source,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+// CheckReport is the result of check, the image is intact only if all
+// blobs passed, and so is the identity if verified.
+type CheckReport struct {
+	Ref      string         `json:"ref"`
+	Mode     string         `json:"mode"`
+	Passed   bool           `json:"passed"`
+	Blobs    []BlobCheck    `json:"blobs"`
+	Identity *IdentityCheck `json:"identity,omitempty"`
+}
+
+// Err returns the blobs and identity failed the check as an error, or nil
+// if the image is intact.
+func (r *CheckReport) Err() error {
+	failures := []string{}
+	for _, blob := range r.Blobs {
+		if !blob.Passed {
+			failures = append(failures, fmt.Sprintf("%s: %s", blob.Digest, blob.Error))
+		}
+	}
+	if r.Identity != nil && !r.Identity.Passed {
+		failures = append(failures, fmt.Sprintf("identity: %s", r.Identity.Error))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("check %s failed: %s", r.Ref, strings.Join(failures, "; "))
+}
+
+// Print writes report in human readable lines.
+func (r *CheckReport) Print(writer io.Writer) {
+	for _, blob := range r.Blobs {
+		status := "PASS"
+		if !blob.Passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%s", status, blob.Digest, blob.Size, blob.Elapsed.Round(time.Millisecond))
+		if blob.Error != "" {
+			fmt.Fprintf(writer, "\t%s", blob.Error)
+		}
+		fmt.Fprintln(writer)
+	}
+	if r.Identity != nil {
+		if r.Identity.Passed {
+			fmt.Fprintf(writer, "PASS\tidentity\t%s\t%s\n", r.Identity.Source, r.Identity.ID)
+		} else {
+			fmt.Fprintf(writer, "FAIL\tidentity\t%s\t%s\t%s\n", r.Identity.Source, r.Identity.ID, r.Identity.Error)
+		}
+	}
+}
+
+// WriteJSON writes report in json.
+func (r *CheckReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// checkBlob is a blob to be verified in backend `be`.
+type checkBlob struct {
+	desc ocispec.Descriptor
+	be   backend.Backend
+}
+
+// Check verifies the blobs referenced by nydus image `opt.Ref` in parallel,
+// the returned report contains the results of all blobs instead of
+// stopping at the first failure.
+func (wf *Workflow) Check(ctx context.Context, opt CheckOption) (*CheckReport, error) {
+	if opt.Mode != CheckShallow && opt.Mode != CheckDeep {
+		return nil, fmt.Errorf("invalid check mode: %s", opt.Mode)
+	}
+
+	blobs, manifest, err := wf.checkBlobs(ctx, opt.Ref)
+	if err != nil {
+		return nil, classify(err)
+	}
+
+	report := &CheckReport{
+		Ref:   opt.Ref,
+		Mode:  opt.Mode,
+		Blobs: make([]BlobCheck, len(blobs)),
+	}
+	eg := errgroup.Group{}
+	if opt.Parallelism > 0 {
+		eg.SetLimit(opt.Parallelism)
+	}
+	for idx := range blobs {
+		idx := idx
+		eg.Go(func() error {
+			blob := blobs[idx]
+			start := time.Now()
+			var err error
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			} else if opt.Mode == CheckDeep {
+				err = verifyBlobContent(blob.be, blob.desc)
+			} else {
+				err = verifyBlobSize(ctx, blob.be, blob.desc)
+			}
+			result := BlobCheck{
+				Digest:  blob.desc.Digest,
+				Size:    blob.desc.Size,
+				Passed:  err == nil,
+				Elapsed: time.Since(start),
+			}
+			if err != nil {
+				result.Error = err.Error()
+				logrus.WithError(err).Warnf("check blob %s", blob.desc.Digest)
+			} else {
+				logrus.Debugf("checked blob %s", blob.desc.Digest)
+			}
+			report.Blobs[idx] = result
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	if opt.TrustBundle != "" {
+		report.Identity = verifyIdentity(manifest, opt.TrustBundle)
+	}
+
+	report.Passed = report.Err() == nil
+	return report, nil
+}
+
+// checkBlobs returns the blobs referenced by nydus image `ref`, including
+// the bootstrap layer.
+func (wf *Workflow) checkBlobs(ctx context.Context, ref string) ([]checkBlob, *ocispec.Manifest, error) {
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse nydus image")
+	}
+	if parsed.NydusImage == nil {
+		return nil, nil, withClass(fmt.Errorf("not a nydus image: %s", ref), ErrNotNydusImage)
+	}
+	manifest := parsed.NydusImage.Manifest
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&manifest)
+	if bootstrapDesc == nil {
+		return nil, nil, fmt.Errorf("not found nydus bootstrap layer")
+	}
+
+	// The layers are always in registry, even if the blobs are in external
+	// backend.
+	registry, err := backend.NewRegistryBackend(remoter, wf.hostsFunc, wf.workDir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "new registry backend")
+	}
+	blobs := []checkBlob{}
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, checkBlob{desc: layer, be: registry})
+	}
+
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := pullBlobIDs(ctx, remoter, bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return nil, nil, classify(errors.Wrap(err, "pull blob ids"))
+	}
+	if len(ids) == 0 {
+		return blobs, &manifest, nil
+	}
+	externalType := wf.cfg.ExternalBackendType()
+	if externalType == "" {
+		return nil, nil, fmt.Errorf("blobs of %s are in external backend, but it's not configured", ref)
+	}
+	be, err := wf.backendOfType(ref, externalType)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		blobDigest := digest.NewDigestFromEncoded(digest.SHA256, id)
+		if err := blobDigest.Validate(); err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid blob id %s", id)
+		}
+		blobs = append(blobs, checkBlob{
+			desc: ocispec.Descriptor{MediaType: utils.MediaTypeNydusBlob, Digest: blobDigest, Size: -1},
+			be:   be,
+		})
+	}
+
+	return blobs, &manifest, nil
+}
+
+// verifyIdentity verifies the identity attesting the bootstrap layer of
+// manifest by SPIFFE trust bundle in file `bundle`.
+func verifyIdentity(manifest *ocispec.Manifest, bundle string) *IdentityCheck {
+	result := &IdentityCheck{}
+	err := func() error {
+		attested, err := identity.Parse(manifest.Annotations)
+		if err != nil {
+			return err
+		}
+		if attested == nil {
+			return fmt.Errorf("image is not attested")
+		}
+		result.Source = attested.Source
+		result.ID = attested.ID
+		bootstrapDesc := parserPkg.FindNydusBootstrapDesc(manifest)
+		if bootstrapDesc == nil {
+			return fmt.Errorf("not found nydus bootstrap layer")
+		}
+		return identity.Verify(attested, bootstrapDesc.Digest, bundle)
+	}()
+	result.Passed = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// verifyBlobSize checks that blob exists with the expected size.
+func verifyBlobSize(ctx context.Context, be backend.Backend, desc ocispec.Descriptor) error {
+	stater, ok := be.(backend.Stater)
+	if !ok {
+		return fmt.Errorf("backend doesn't support stat")
+	}
+	size, err := stater.Stat(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if desc.Size >= 0 && size != desc.Size {
+		return fmt.Errorf("size mismatch: %d != %d", size, desc.Size)
+	}
+	return nil
+}
+
+// verifyBlobContent fetches the content of blob, and checks that it
+// matches the expected digest and size.
+func verifyBlobContent(be backend.Backend, desc ocispec.Descriptor) error {
+	reader, err := be.Pull(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	size, err := io.Copy(digester.Hash(), reader)
+	if err != nil {
+		return errors.Wrap(err, "read blob")
+	}
+	if desc.Size >= 0 && size != desc.Size {
+		return fmt.Errorf("size mismatch: %d != %d", size, desc.Size)
+	}
+	if digester.Digest() != desc.Digest {
+		return fmt.Errorf("digest mismatch: %s != %s", digester.Digest(), desc.Digest)
+	}
+	return nil
+}
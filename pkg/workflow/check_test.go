@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryBackend serves the blobs in memory.
+type memoryBackend struct {
+	blobs map[digest.Digest][]byte
+}
+
+func (b *memoryBackend) Push(ctx context.Context, ra content.ReaderAt, desc ocispec.Descriptor) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (b *memoryBackend) Pull(blobDigest digest.Digest) (io.ReadCloser, error) {
+	data, ok := b.blobs[blobDigest]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", blobDigest)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memoryBackend) Stat(ctx context.Context, blobDigest digest.Digest) (int64, error) {
+	data, ok := b.blobs[blobDigest]
+	if !ok {
+		return 0, fmt.Errorf("blob %s not found", blobDigest)
+	}
+	return int64(len(data)), nil
+}
+
+func (b *memoryBackend) External() bool {
+	return true
+}
+
+func TestVerifyBlob(t *testing.T) {
+	blob := []byte("blob")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	// The content is corrupted with same size.
+	corrupted := ocispec.Descriptor{Digest: digest.FromString("corrupted"), Size: int64(len(blob))}
+	be := &memoryBackend{blobs: map[digest.Digest][]byte{
+		desc.Digest:      blob,
+		corrupted.Digest: []byte("bolb"),
+	}}
+	ctx := context.Background()
+
+	require.NoError(t, verifyBlobSize(ctx, be, desc))
+	require.NoError(t, verifyBlobContent(be, desc))
+
+	require.NoError(t, verifyBlobSize(ctx, be, corrupted))
+	require.ErrorContains(t, verifyBlobContent(be, corrupted), "digest mismatch")
+
+	truncated := ocispec.Descriptor{Digest: desc.Digest, Size: desc.Size + 1}
+	require.EqualError(t, verifyBlobSize(ctx, be, truncated), "size mismatch: 4 != 5")
+	require.EqualError(t, verifyBlobContent(be, truncated), "size mismatch: 4 != 5")
+
+	// The size of blobs referenced by ids is unknown.
+	unknown := ocispec.Descriptor{Digest: desc.Digest, Size: -1}
+	require.NoError(t, verifyBlobSize(ctx, be, unknown))
+	require.NoError(t, verifyBlobContent(be, unknown))
+
+	missing := ocispec.Descriptor{Digest: digest.FromString("missing"), Size: 1}
+	require.Error(t, verifyBlobSize(ctx, be, missing))
+	require.Error(t, verifyBlobContent(be, missing))
+}
+
+func TestCheckReport(t *testing.T) {
+	report := &CheckReport{
+		Ref:  "example.com/test:latest",
+		Mode: CheckShallow,
+		Blobs: []BlobCheck{
+			{Digest: "sha256:aaa", Size: 1, Passed: true},
+			{Digest: "sha256:bbb", Size: -1, Error: "not found"},
+		},
+	}
+	require.EqualError(t, report.Err(), "check example.com/test:latest failed: sha256:bbb: not found")
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	require.Equal(t, "PASS\tsha256:aaa\t1\t0s\nFAIL\tsha256:bbb\t-1\t0s\tnot found\n", buf.String())
+
+	report.Blobs = report.Blobs[:1]
+	require.NoError(t, report.Err())
+}
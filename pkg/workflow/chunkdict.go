@@ -0,0 +1,98 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+const chunkDictBootstrapName = "bootstrap-chunk-dict"
+
+// chunkDict is the bootstrap used by builder to deduplicate the chunks
+// of committed blobs against.
+type chunkDict struct {
+	path string
+	// image is the nydus image of chunk dict, nil if the chunk dict is a
+	// local bootstrap file.
+	image *parserPkg.Image
+}
+
+// ParseChunkDict parses chunk dict option in format `bootstrap=<ref or path>`
+// and returns the ref or path.
+func ParseChunkDict(chunkDict string) (string, error) {
+	source := strings.TrimPrefix(chunkDict, "bootstrap=")
+	if source == chunkDict || source == "" {
+		return "", fmt.Errorf("invalid chunk dict %s, must be in format bootstrap=<ref or path>", chunkDict)
+	}
+	return source, nil
+}
+
+// prepareChunkDict uses `source` as the chunk dict bootstrap if it's a local
+// file, or pulls the bootstrap of nydus image `source` otherwise.
+func (wf *Workflow) prepareChunkDict(ctx context.Context, source string) (*chunkDict, error) {
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		path, err := filepath.Abs(source)
+		if err != nil {
+			return nil, errors.Wrap(err, "get absolute path")
+		}
+		return &chunkDict{path: path}, nil
+	}
+
+	parsed, _, err := wf.pullBootstrap(ctx, source, chunkDictBootstrapName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pull chunk dict bootstrap from %s", source)
+	}
+
+	return &chunkDict{
+		path:  filepath.Join(wf.workDir, chunkDictBootstrapName),
+		image: parsed.NydusImage,
+	}, nil
+}
+
+// dictBlobLayers returns the blob layers which are referenced by the merged
+// bootstrap only because of deduplicated chunks, they are not in base image
+// and need to be appended into lower layers of committed image.
+func (dict *chunkDict) dictBlobLayers(blobDigests []digest.Digest, base parserPkg.Image, committed []Blob) ([]ocispec.Descriptor, error) {
+	known := map[digest.Digest]bool{}
+	for _, layer := range base.Manifest.Layers {
+		known[layer.Digest] = true
+	}
+	for _, blob := range committed {
+		known[blob.Desc.Digest] = true
+	}
+
+	dictLayers := map[digest.Digest]ocispec.Descriptor{}
+	if dict.image != nil {
+		for _, layer := range dict.image.Manifest.Layers {
+			if layer.MediaType == utils.MediaTypeNydusBlob {
+				dictLayers[layer.Digest] = layer
+			}
+		}
+	}
+
+	layers := []ocispec.Descriptor{}
+	for _, blobDigest := range blobDigests {
+		if known[blobDigest] {
+			continue
+		}
+		layer, ok := dictLayers[blobDigest]
+		if !ok {
+			return nil, fmt.Errorf("not found blob %s referenced by chunk dict", blobDigest)
+		}
+		logrus.Infof("referenced blob %s from chunk dict", blobDigest)
+		layers = append(layers, layer)
+		known[blobDigest] = true
+	}
+
+	return layers, nil
+}
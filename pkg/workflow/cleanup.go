@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	goerrors "errors"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// cleanups records the resources held by an in-progress commit (paused
+// container, bind mounts, etc.), the remaining ones are released by
+// Workflow.Destory if the commit did not release them by itself.
+type cleanups struct {
+	mutex  sync.Mutex
+	nextID int
+	funcs  map[int]cleanup
+}
+
+type cleanup struct {
+	name string
+	fn   func() error
+}
+
+// add registers cleanup func `fn`, the returned func must be called
+// once the resource has been released normally.
+func (c *cleanups) add(name string, fn func() error) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.funcs == nil {
+		c.funcs = map[int]cleanup{}
+	}
+	id := c.nextID
+	c.nextID++
+	c.funcs[id] = cleanup{name: name, fn: fn}
+
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(c.funcs, id)
+	}
+}
+
+// run calls the remaining cleanup funcs in reverse order of registering,
+// all of them are called and every failure is returned.
+func (c *cleanups) run() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var errs []error
+	for id := c.nextID - 1; id >= 0; id-- {
+		cleanup, ok := c.funcs[id]
+		if !ok {
+			continue
+		}
+		logrus.Infof("cleaning up: %s", cleanup.name)
+		if err := cleanup.fn(); err != nil {
+			errs = append(errs, errors.Wrap(err, cleanup.name))
+		}
+		delete(c.funcs, id)
+	}
+
+	return goerrors.Join(errs...)
+}
@@ -0,0 +1,33 @@
+package workflow
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// cloneUpper clones the upper dir of container into work dir by reflink,
+// so the diff runs against the clone while the container keeps running.
+// The clone shares data extents with the upper dir, it takes seconds
+// regardless of the size of upper dir, but requires the work dir on the
+// same filesystem as upper dir supporting reflink, e.g. XFS or btrfs.
+// The owners, modes, xattrs (overlay opaque markers), whiteout devices and
+// hard links are preserved by `cp --archive`.
+func (wf *Workflow) cloneUpper(ctx context.Context, upperDir, name string) (string, error) {
+	target := filepath.Join(wf.workDir, name)
+	logrus.Infof("cloning upper dir to %s", target)
+	start := time.Now()
+
+	cmd := exec.CommandContext(ctx, "cp", "--archive", "--reflink=always", "--no-target-directory", upperDir, target)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", errors.Wrapf(err, "clone upper dir by reflink, the work dir must be on the same filesystem supporting reflink: %s", strings.TrimSpace(string(output)))
+	}
+
+	logrus.Infof("cloned upper dir, elapsed: %s", time.Since(start))
+	return target, nil
+}
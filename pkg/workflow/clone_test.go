@@ -0,0 +1,29 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneUpper(t *testing.T) {
+	upperDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(upperDir, "file"), []byte("data"), 0640))
+
+	wf := &Workflow{workDir: t.TempDir()}
+	cloned, err := wf.cloneUpper(context.Background(), upperDir, "upper-clone")
+	if err != nil {
+		// The filesystem of test doesn't support reflink.
+		require.ErrorContains(t, err, "reflink")
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(cloned, "file"))
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+	info, err := os.Stat(filepath.Join(cloned, "file"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
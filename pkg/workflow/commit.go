@@ -0,0 +1,884 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/compat"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	"github.com/nydusaccelerator/nydus-cli/pkg/notify"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// Names of the stages of commit pipeline.
+const (
+	StageInspect = "inspect"
+	StagePull    = "pull"
+	StageCheck   = "check"
+	// StagePack diffs the container and packs the changes into blobs, the
+	// diff is streamed into the builder so they are in one stage.
+	StagePack  = "pack"
+	StageMerge = "merge"
+	StagePush  = "push"
+	// StageScan scans the pushed images for vulnerabilities.
+	StageScan = "scan"
+)
+
+// CommitState is shared by the stages of commit pipeline, each stage fills
+// in the fields it produces for the later ones.
+type CommitState struct {
+	Option          CommitOption
+	NydusTargetRefs []string
+	StartedAt       time.Time
+
+	// Set by inspect stage.
+	Inspect *container.InspectResult
+	// SidecarInspects are in the order of Option.Sidecars.
+	SidecarInspects []*container.InspectResult
+
+	// Set by pull stage.
+	Base *parserPkg.Image
+	// BaseRef is the nydus base image, and BaseIndex is its index if it's
+	// a multi-platform image, both are unset if converted on the fly.
+	BaseRef         string
+	BaseIndex       *ocispec.Index
+	CommittedLayers int
+	// History are the records of commits in the chain of base image.
+	History []CommitRecord
+	// OCIBase is set only if there are OCI targets.
+	OCIBaseRef string
+	OCIBase    *parserPkg.Image
+
+	// BaseBlobs are the blobs converted from the layers of OCI image of
+	// container, set only if converted on the fly, see convertBase.
+	BaseBlobs []Blob
+
+	// Set by pack stage.
+	UpperBlob  *Blob
+	MountBlobs []Blob
+	// NoChanges is set by pack stage if the upper diff is empty and the
+	// mount blobs are the same as the last commit in the chain, then the
+	// image is not merged and pushed unless Option.AllowEmpty is set.
+	NoChanges bool
+
+	// Set by merge stage if there are nydus targets.
+	BlobDigests     []digest.Digest
+	BootstrapDiffID *digest.Digest
+
+	// Set by push stage, keyed by the pushed references.
+	ManifestDigests map[string]digest.Digest
+
+	// StageDurations are the elapsed time of the finished stages, set by
+	// TimingMiddleware.
+	StageDurations map[string]time.Duration
+	// Timings are the finer phases of stages, e.g. the pack and push of
+	// each blob, written in CommitSummary.
+	Timings Timings
+}
+
+// CommitPipeline returns the pipeline which Commit runs, callers may add
+// middlewares to it before running.
+func (wf *Workflow) CommitPipeline() *Pipeline {
+	return NewPipeline(
+		Stage{Name: StageInspect, Run: wf.inspectStage},
+		Stage{Name: StagePull, Run: wf.pullStage},
+		Stage{Name: StageCheck, Run: wf.checkStage},
+		Stage{Name: StagePack, Run: wf.packStage},
+		Stage{Name: StageMerge, Run: wf.mergeStage},
+		Stage{Name: StagePush, Run: wf.pushStage},
+		Stage{Name: StageScan, Run: wf.scanStage},
+	).Use(TimingMiddleware, wf.recordStage, wf.observeStage)
+}
+
+func (wf *Workflow) Commit(ctx context.Context, opt CommitOption) error {
+	defer wf.reportRetries()
+	start := time.Now()
+	state := &CommitState{Option: opt, StartedAt: start}
+	wf.notify(state, notify.EventCommitStarted, nil)
+	// The commit is aborted once the time budget is exceeded, the paused
+	// containers and the uploads are released by Destory.
+	commitCtx := ctx
+	if opt.TimeBudget > 0 {
+		var cancel context.CancelFunc
+		commitCtx, cancel = context.WithTimeout(ctx, opt.TimeBudget)
+		defer cancel()
+	}
+	err := wf.runHook(commitCtx, notify.HookPreCommit, state, notify.EventCommitStarted, nil)
+	if err == nil {
+		err = classify(wf.CommitPipeline().Run(commitCtx, state))
+	}
+	if err != nil && ctx.Err() == nil && errors.Is(commitCtx.Err(), context.DeadlineExceeded) {
+		err = withClass(errors.Wrapf(err, "exceeded time budget %s", opt.TimeBudget), ErrTimeBudget)
+	}
+	wf.observeCommit(state.Option, time.Since(start), err)
+	if err != nil {
+		// The failure hook runs even if the commit is interrupted.
+		if hookErr := wf.runHook(context.Background(), notify.HookOnFailure, state, notify.EventCommitFailed, err); hookErr != nil {
+			logrus.WithError(hookErr).Warn("failure hook failed")
+		}
+		wf.notify(state, notify.EventCommitFailed, err)
+	} else {
+		if hookErr := wf.runHook(ctx, notify.HookPostPush, state, notify.EventCommitSucceeded, nil); hookErr != nil {
+			logrus.WithError(hookErr).Warn("post-push hook failed")
+		}
+		wf.notify(state, notify.EventCommitSucceeded, nil)
+	}
+	if opt.Output != "" {
+		if writeErr := writeSummary(os.Stdout, opt.Output, state.summary(err)); writeErr != nil {
+			logrus.WithError(writeErr).Warn("write commit summary")
+		}
+	}
+	return err
+}
+
+// writeSummary writes `summary` to `writer` in `format`.
+func writeSummary(writer io.Writer, format string, summary *CommitSummary) error {
+	switch format {
+	case OutputText:
+		return summary.Print(writer)
+	case OutputJSON:
+		return summary.WriteJSON(writer)
+	default:
+		return fmt.Errorf("invalid output format %s", format)
+	}
+}
+
+// reportRetries logs the retries performed in this run and saves them in
+// work dir, to help tuning retry policy and spotting flaky backends.
+func (wf *Workflow) reportRetries() {
+	summary := remote.Retries.Summary()
+	wf.saveJSON(RetriesFileName, summary)
+	if summary.Total.Retries == 0 {
+		return
+	}
+	logrus.Warnf(
+		"retry summary: %d retries, re-uploaded: %s, time lost: %s",
+		summary.Total.Retries, humanize.IBytes(uint64(summary.Total.ReuploadedBytes)), summary.Total.TimeLost,
+	)
+	for _, name := range summary.OpNames() {
+		record := summary.Ops[name]
+		logrus.Warnf(
+			"\t%s: %d retries, re-uploaded: %s, time lost: %s",
+			name, record.Retries, humanize.IBytes(uint64(record.ReuploadedBytes)), record.TimeLost,
+		)
+	}
+}
+
+func (wf *Workflow) inspectStage(ctx context.Context, state *CommitState) error {
+	opt := state.Option
+
+	logrus.Infof("current envs:")
+	logrus.Infof("\thostname: %s", os.Getenv("HOSTNAME"))
+	logrus.Infof("\tpod name: %s", os.Getenv("ALIPAY_POD_NAME"))
+
+	if len(opt.Targets) == 0 {
+		return fmt.Errorf("no target specified")
+	}
+	// The templates of targets are expanded after pulling base image.
+	templated := false
+	for _, target := range opt.Targets {
+		if !distribution.IsRefTemplate(target.Ref) {
+			continue
+		}
+		templated = true
+		if _, err := distribution.ParseRefTemplate(target.Ref); err != nil {
+			return err
+		}
+		if opt.KeepLast > 0 {
+			if _, _, err := distribution.TagPattern(target.Ref); err != nil {
+				return errors.Wrap(err, "retain tags")
+			}
+		}
+	}
+	if opt.KeepLast > 0 && opt.PushByDigest {
+		return fmt.Errorf("--keep-last can't be used with --push-by-digest")
+	}
+	if opt.KeepLast > 0 && !templated {
+		logrus.Warnf("--keep-last takes effect only on templated targets")
+	}
+
+	var inspect *container.InspectResult
+	if err := state.Timings.Time("inspect", func() (err error) {
+		inspect, err = wf.cm.Inspect(ctx, opt.ContainerIDWithType)
+		return err
+	}); err != nil {
+		return errors.Wrap(err, "inspect container")
+	}
+	logrus.Infof("inspected container %s:", opt.ContainerIDWithType)
+	logrus.Infof("\timage: %s", inspect.Image)
+	logrus.Infof("\tpid: %d", inspect.Pid)
+	state.Inspect = inspect
+	wf.saveJSON(InspectFileName, inspect)
+	if !inspect.Nydus && !opt.ConvertBase {
+		return withClass(fmt.Errorf("invalid nydus image name '%s', --convert-base commits the container of OCI image", inspect.Image), ErrNotNydusImage)
+	}
+
+	if len(opt.Sidecars) > 0 && opt.CloneUpper {
+		return fmt.Errorf("--clone-upper can't be used with sidecars")
+	}
+	for _, sidecar := range opt.Sidecars {
+		var inspect *container.InspectResult
+		if err := state.Timings.Time("inspect sidecar "+sidecar.Name, func() (err error) {
+			inspect, err = wf.cm.Inspect(ctx, sidecar.ContainerIDWithType)
+			return err
+		}); err != nil {
+			return errors.Wrapf(err, "inspect sidecar %s", sidecar.Name)
+		}
+		logrus.Infof("inspected sidecar %s %s, committed under %s", sidecar.Name, sidecar.ContainerIDWithType, sidecar.PathPrefix)
+		state.SidecarInspects = append(state.SidecarInspects, inspect)
+	}
+
+	return nil
+}
+
+func (wf *Workflow) pullStage(ctx context.Context, state *CommitState) error {
+	if state.Inspect.Nydus {
+		if err := wf.pullBase(ctx, state, state.Inspect.Image); err != nil {
+			return err
+		}
+	} else if err := wf.convertBase(ctx, state); err != nil {
+		return errors.Wrap(err, "convert base image")
+	}
+
+	if err := wf.expandTargets(state); err != nil {
+		return err
+	}
+	opt := state.Option
+	var err error
+
+	if opt.ChunkDict != "" {
+		logrus.Infof("preparing chunk dict %s", opt.ChunkDict)
+		wf.chunkDict, err = wf.prepareChunkDict(ctx, opt.ChunkDict)
+		if err != nil {
+			return errors.Wrap(err, "prepare chunk dict")
+		}
+	}
+
+	if len(opt.targets(FormatOCI)) > 0 && state.OCIBase == nil {
+		logrus.Infof("pulling oci base image")
+		state.OCIBaseRef, state.OCIBase, err = wf.pullOCIBase(ctx, state.Inspect)
+		if err != nil {
+			return errors.Wrap(err, "pull oci base image")
+		}
+	}
+
+	return nil
+}
+
+// pullBase pulls the bootstrap and commit history of nydus base image
+// `ref`.
+func (wf *Workflow) pullBase(ctx context.Context, state *CommitState, ref string) error {
+	logrus.Infof("pulling base bootstrap")
+	start := time.Now()
+	parsed, committedLayers, err := wf.pullBootstrap(ctx, ref, "bootstrap-base")
+	state.Timings.Record("pull bootstrap", start, time.Since(start))
+	if err != nil {
+		return errors.Wrap(err, "pull base bootstrap")
+	}
+	logrus.Infof("pulled base bootstrap, elapsed: %s", time.Since(start))
+	image := parsed.NydusImage
+	state.Base = image
+	state.BaseRef = ref
+	state.BaseIndex = parsed.Index
+	state.CommittedLayers = committedLayers
+
+	if bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&image.Manifest); bootstrapDesc != nil {
+		keys, err := wf.cfg.Annotations.ResolvedKeys()
+		if err != nil {
+			return err
+		}
+		history, err := parseCommitHistory(bootstrapDesc.Annotations, keys)
+		if err != nil {
+			return errors.Wrap(err, "parse base commit history")
+		}
+		if state.History, err = wf.pullCommitRecords(ctx, ref, history); err != nil {
+			return errors.Wrap(err, "pull base commit history")
+		}
+	}
+
+	return nil
+}
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// expandTargets expands the templates of target references, see
+// distribution.RefTemplateData for the variables, then resolves the
+// references of nydus targets.
+func (wf *Workflow) expandTargets(state *CommitState) error {
+	now := timeNow().UTC()
+	data := distribution.RefTemplateData{
+		ContainerID:   state.Option.ContainerIDWithType,
+		Date:          now.Format("20060102"),
+		Timestamp:     now.Format("20060102150405"),
+		Sequence:      len(state.History) + 1,
+		ContainerName: state.Option.ContainerName,
+	}
+	if idx := strings.Index(data.ContainerID, "://"); idx != -1 {
+		data.ContainerID = data.ContainerID[idx+len("://"):]
+	}
+	// BaseTag is left empty if the base image is referenced only by
+	// digest, then the expanded reference using it is invalid.
+	if baseRef, err := wf.ociBaseRef(state.Inspect); err == nil {
+		data.BaseTag, _ = distribution.Tag(baseRef)
+	}
+
+	targets := make([]Target, 0, len(state.Option.Targets))
+	for _, target := range state.Option.Targets {
+		ref, err := distribution.ExpandRef(target.Ref, data)
+		if err != nil {
+			return err
+		}
+		expanded := Target{Ref: ref, Format: target.Format}
+		if ref != target.Ref {
+			logrus.Infof("expanded target %s to %s", target.Ref, ref)
+			expanded.Template = target.Ref
+		}
+		targets = append(targets, expanded)
+	}
+	state.Option.Targets = targets
+
+	nydusTargetRefs, err := state.Option.nydusTargetRefs(wf.naming)
+	if err != nil {
+		return err
+	}
+	state.NydusTargetRefs = nydusTargetRefs
+	return nil
+}
+
+func (wf *Workflow) checkStage(ctx context.Context, state *CommitState) error {
+	opt := state.Option
+
+	if err := checkMaximumTimes(opt.MaximumTimesPolicy, opt.MaximumTimes, state.CommittedLayers, state.History, timeNow()); err != nil {
+		return err
+	}
+
+	if err := wf.checkCompat(ctx, state.Base); err != nil {
+		return err
+	}
+
+	if err := wf.checkScanTargets(opt); err != nil {
+		return err
+	}
+
+	if err := wf.checkHarbor(ctx, state); err != nil {
+		return err
+	}
+
+	// No tag is overwritten by pushing by digest.
+	if !opt.Force && !opt.PushByDigest {
+		if err := wf.checkTargets(ctx, state); err != nil {
+			return err
+		}
+	}
+
+	if err := wf.checkSpace(ctx, opt, state.Inspect.Pid, state.Inspect.UpperDir); err != nil {
+		return errors.Wrap(err, "check work dir space, use --skip-space-check to skip it")
+	}
+
+	return nil
+}
+
+// ErrTargetExists is returned if a target image already exists and the
+// commit isn't forced to overwrite it.
+var ErrTargetExists = errors.New("target image already exists")
+
+// checkTargets refuses to overwrite the existing target images.
+func (wf *Workflow) checkTargets(ctx context.Context, state *CommitState) error {
+	refs := append([]string{}, state.NydusTargetRefs...)
+	for _, target := range state.Option.targets(FormatOCI) {
+		refs = append(refs, target.Ref)
+	}
+
+	d := distribution.NewWithResolver(wf.resolverFunc)
+	for _, ref := range refs {
+		exists, err := d.IsImageExists(ctx, ref)
+		if err != nil {
+			return errors.Wrapf(err, "check target %s", ref)
+		}
+		if exists {
+			return errors.Wrapf(ErrTargetExists, "%s, use --force to overwrite it", ref)
+		}
+	}
+	return nil
+}
+
+// checkCompat refuses the commit if the builder, nydusd or base image is
+// known to be incompatible with the committed bootstrap.
+func (wf *Workflow) checkCompat(ctx context.Context, base *parserPkg.Image) error {
+	versions := compat.Versions{
+		Nydusd:    wf.cfg.Compat.NydusdVersion,
+		FsVersion: fsVersion,
+	}
+	builderVersion, err := compat.BinaryVersion(ctx, wf.cfg.Base.Builder)
+	if err != nil {
+		logrus.WithError(err).Warn("unknown builder version, skip checking its compatibility")
+	} else {
+		versions.Builder = builderVersion
+	}
+	if bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&base.Manifest); bootstrapDesc != nil {
+		versions.BaseAnnotations = bootstrapDesc.Annotations
+	}
+	if version, ok := base.Manifest.Annotations[utils.ManifestAnnotationNydusBuilderVersion]; ok {
+		if versions.BaseBuilder, err = compat.ParseVersion(version); err != nil {
+			logrus.WithError(err).Warn("unknown builder version of base image, skip checking it")
+		}
+	}
+
+	issues := compat.Check(versions)
+	for _, issue := range issues {
+		if issue.Level == compat.LevelWarn {
+			logrus.Warnf("compatibility: %s", issue)
+		}
+	}
+	return compat.Err(issues)
+}
+
+func (wf *Workflow) packStage(ctx context.Context, state *CommitState) error {
+	opt := state.Option
+	inspect := state.Inspect
+
+	mountList := NewMountList()
+
+	upperDir := inspect.UpperDir
+	if opt.CloneUpper {
+		clone := func() error {
+			var err error
+			upperDir, err = wf.cloneUpper(ctx, inspect.UpperDir, "upper-clone")
+			return err
+		}
+		var err error
+		if opt.PauseContainer {
+			err = wf.pause(ctx, opt.ContainerIDWithType, opt.PauseMode, clone)
+		} else {
+			err = clone()
+		}
+		if err != nil {
+			return errors.Wrap(err, "clone upper dir")
+		}
+	}
+
+	var upperBlob *Blob
+	mountBlobs := make([]Blob, len(opt.WithPaths))
+	sidecarBlobs := make([]Blob, len(opt.Sidecars))
+	commit := func() error {
+		eg := opt.newGroup()
+		eg.Go(func() error {
+			var blob *Blob
+			if err := state.Timings.Time("pack blob-upper", func() error {
+				return withRetry("commit upper", func() error {
+					var err error
+					blob, err = wf.commitUpperByDiff(ctx, opt, mountList.Add, inspect.LowerDirs, upperDir, "blob-upper")
+					return err
+				}, 3)
+			}); err != nil {
+				return errors.Wrap(err, "commit upper")
+			}
+			logrus.Infof("pushing blob for upper")
+			start := time.Now()
+			if err := wf.pushBlobToTargets(ctx, state, "blob-upper", blob.Desc); err != nil {
+				return errors.Wrap(err, "push upper blob")
+			}
+			upperBlob = blob
+			logrus.Infof("pushed blob for upper, elapsed: %s", time.Since(start))
+			return nil
+		})
+
+		if len(opt.WithPaths) > 0 {
+			for idx := range opt.WithPaths {
+				func(idx int) {
+					eg.Go(func() error {
+						withPath := opt.WithPaths[idx]
+						name := fmt.Sprintf("blob-mount-%d", idx)
+						key, blob := wf.dedupMount(ctx, state, inspect, withPath, name)
+						if blob != nil {
+							mountBlobs[idx] = *blob
+							return nil
+						}
+						if err := state.Timings.Time("pack "+name, func() error {
+							return withRetry("commit mount", func() error {
+								var err error
+								blob, err = wf.commitMount(ctx, opt, inspect, withPath, name)
+								return err
+							}, 3)
+						}); err != nil {
+							return errors.Wrap(err, "commit mount")
+						}
+						logrus.Infof("pushing blob for mount")
+						start := time.Now()
+						if err := wf.pushBlobToTargets(ctx, state, name, blob.Desc); err != nil {
+							return errors.Wrap(err, "push mount blob")
+						}
+						mountBlobs[idx] = *blob
+						logrus.Infof("pushed blob for mount, elapsed: %s", time.Since(start))
+						wf.indexMount(key, blob)
+						return nil
+					})
+				}(idx)
+			}
+		}
+
+		for idx := range opt.Sidecars {
+			func(idx int) {
+				eg.Go(func() error {
+					sidecar := opt.Sidecars[idx]
+					inspect := state.SidecarInspects[idx]
+					name := fmt.Sprintf("blob-sidecar-%d", idx)
+					// The mounts of sidecars are not committed.
+					sidecarOpt := opt
+					sidecarOpt.WithPaths, sidecarOpt.WithoutPaths = nil, nil
+					sidecarOpt.pathPrefix = sidecar.PathPrefix
+					var blob *Blob
+					if err := state.Timings.Time("pack "+name, func() error {
+						return withRetry("commit sidecar", func() error {
+							var err error
+							blob, err = wf.commitUpperByDiff(ctx, sidecarOpt, func(string, bool) {}, inspect.LowerDirs, inspect.UpperDir, name)
+							return err
+						}, 3)
+					}); err != nil {
+						return errors.Wrapf(err, "commit sidecar %s", sidecar.Name)
+					}
+					logrus.Infof("pushing blob for sidecar %s", sidecar.Name)
+					start := time.Now()
+					if err := wf.pushBlobToTargets(ctx, state, name, blob.Desc); err != nil {
+						return errors.Wrapf(err, "push sidecar %s blob", sidecar.Name)
+					}
+					sidecarBlobs[idx] = *blob
+					logrus.Infof("pushed blob for sidecar %s, elapsed: %s", sidecar.Name, time.Since(start))
+					return nil
+				})
+			}(idx)
+		}
+
+		if err := eg.Wait(); err != nil {
+			return err
+		}
+		mountBlobs = append(mountBlobs, sidecarBlobs...)
+
+		appendedEg := opt.newGroup()
+		appendedMutex := sync.Mutex{}
+		if len(mountList.paths) > 0 {
+			logrus.Infof("need commit appened mount path: %s", strings.Join(mountList.paths, ", "))
+		}
+		for idx := range mountList.paths {
+			func(idx int) {
+				appendedEg.Go(func() error {
+					mountPath := mountList.paths[idx]
+					name := fmt.Sprintf("blob-appended-mount-%d", idx)
+					key, blob := wf.dedupMount(ctx, state, inspect, mountPath, name)
+					if blob != nil {
+						appendedMutex.Lock()
+						mountBlobs = append(mountBlobs, *blob)
+						appendedMutex.Unlock()
+						return nil
+					}
+					if err := state.Timings.Time("pack "+name, func() error {
+						return withRetry("commit appended mount", func() error {
+							var err error
+							blob, err = wf.commitMount(ctx, opt, inspect, mountPath, name)
+							return err
+						}, 3)
+					}); err != nil {
+						return errors.Wrap(err, "commit appended mount")
+					}
+					logrus.Infof("pushing blob for appended mount")
+					start := time.Now()
+					if err := wf.pushBlobToTargets(ctx, state, name, blob.Desc); err != nil {
+						return errors.Wrap(err, "push appended mount blob")
+					}
+					appendedMutex.Lock()
+					mountBlobs = append(mountBlobs, *blob)
+					appendedMutex.Unlock()
+					logrus.Infof("pushed blob for appended mount, elapsed: %s", time.Since(start))
+					wf.indexMount(key, blob)
+					return nil
+				})
+			}(idx)
+		}
+
+		// The metacopy files are committed into one blob, rather than a
+		// blob for each which may be too many layers.
+		if len(mountList.files) > 0 {
+			logrus.Infof("need commit appended files: %s", strings.Join(mountList.files, ", "))
+			appendedEg.Go(func() error {
+				name := "blob-appended-files"
+				var blob *Blob
+				if err := state.Timings.Time("pack "+name, func() error {
+					return withRetry("commit appended files", func() error {
+						var err error
+						blob, err = wf.commitFiles(ctx, opt, inspect, mountList.files, name)
+						return err
+					}, 3)
+				}); err != nil {
+					return errors.Wrap(err, "commit appended files")
+				}
+				logrus.Infof("pushing blob for appended files")
+				start := time.Now()
+				if err := wf.pushBlobToTargets(ctx, state, name, blob.Desc); err != nil {
+					return errors.Wrap(err, "push appended files blob")
+				}
+				appendedMutex.Lock()
+				mountBlobs = append(mountBlobs, *blob)
+				appendedMutex.Unlock()
+				logrus.Infof("pushed blob for appended files, elapsed: %s", time.Since(start))
+				return nil
+			})
+		}
+
+		return appendedEg.Wait()
+	}
+
+	// The clone is consistent already, the mounts are committed from the
+	// running container.
+	if opt.PauseContainer && !opt.CloneUpper {
+		pauseAll := commit
+		for idx := len(opt.Sidecars) - 1; idx >= 0; idx-- {
+			containerIDWithType, handle := opt.Sidecars[idx].ContainerIDWithType, pauseAll
+			pauseAll = func() error {
+				return wf.pause(ctx, containerIDWithType, opt.PauseMode, handle)
+			}
+		}
+		if err := wf.pause(ctx, opt.ContainerIDWithType, opt.PauseMode, pauseAll); err != nil {
+			return errors.Wrap(err, "pause container to commit")
+		}
+	} else {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+
+	state.UpperBlob = upperBlob
+	state.MountBlobs = mountBlobs
+	if unchanged(state) {
+		if opt.AllowEmpty {
+			logrus.Infof("no changes since the last commit, pushing image as empty commit is allowed")
+		} else {
+			state.NoChanges = true
+			logrus.Infof("no changes since the last commit, skipped pushing image")
+		}
+	}
+
+	return nil
+}
+
+// unchanged returns true if the upper diff of commit `state` is empty and
+// its mount blobs are the same as the last commit in the chain of base
+// image, regardless of order as the appended mounts are committed
+// concurrently. The commit converting base image on the fly is a change.
+func unchanged(state *CommitState) bool {
+	if state.UpperBlob.Stats.Entries > 0 || len(state.BaseBlobs) > 0 {
+		return false
+	}
+	var previous []digest.Digest
+	if len(state.History) > 0 {
+		// The last blob of record is the upper blob.
+		if blobs := state.History[len(state.History)-1].Blobs; len(blobs) > 0 {
+			previous = blobs[:len(blobs)-1]
+		}
+	}
+	if len(previous) != len(state.MountBlobs) {
+		return false
+	}
+	counts := map[digest.Digest]int{}
+	for _, blobDigest := range previous {
+		counts[blobDigest]++
+	}
+	for _, mountBlob := range state.MountBlobs {
+		if counts[mountBlob.Desc.Digest] == 0 {
+			return false
+		}
+		counts[mountBlob.Desc.Digest]--
+	}
+	return true
+}
+
+func (wf *Workflow) mergeStage(ctx context.Context, state *CommitState) error {
+	if len(state.NydusTargetRefs) == 0 || state.NoChanges {
+		return nil
+	}
+
+	logrus.Infof("merging base and upper bootstraps")
+	start := time.Now()
+	blobDigests, bootstrapDiffID, err := wf.mergeBootstrap(ctx, *state.UpperBlob, state.MountBlobs, "bootstrap-base", "bootstrap-merged.tar")
+	state.Timings.Record("merge", start, time.Since(start))
+	if err != nil {
+		return errors.Wrap(err, "merge bootstrap")
+	}
+	state.BlobDigests = blobDigests
+	state.BootstrapDiffID = bootstrapDiffID
+
+	return nil
+}
+
+func (wf *Workflow) pushStage(ctx context.Context, state *CommitState) error {
+	if state.NoChanges {
+		// The digest file is still written, so it never keeps the digests
+		// of a previous commit.
+		if opt := state.Option; opt.DigestFile != "" {
+			if err := writeDigestFile(opt.DigestFile, state, baseDigests(state), opt.appendDigestFile); err != nil {
+				return errors.Wrap(err, "write digest file")
+			}
+		}
+		return nil
+	}
+
+	record := CommitRecord{Time: timeNow().UTC()}
+	for _, mountBlob := range state.MountBlobs {
+		record.Blobs = append(record.Blobs, mountBlob.Desc.Digest)
+	}
+	record.Blobs = append(record.Blobs, state.UpperBlob.Desc.Digest)
+	records := append(append([]CommitRecord{}, state.History...), record)
+
+	opt := state.Option
+	for _, baseBlob := range state.BaseBlobs {
+		if err := state.Timings.Time("push "+baseBlob.Name, func() error {
+			for _, targetRef := range state.NydusTargetRefs {
+				if err := wf.pushBlob(ctx, baseBlob.Name, baseBlob.Desc, targetRef); err != nil {
+					return withClass(errors.Wrapf(err, "push converted base blob to %s", targetRef), ErrPush)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	manifestDigests := map[string]digest.Digest{}
+	state.ManifestDigests = manifestDigests
+	for _, targetRef := range state.NydusTargetRefs {
+		logrus.Infof("pushing committed image to %s", targetRef)
+		var manifestDesc *ocispec.Descriptor
+		if err := state.Timings.Time("push manifest "+targetRef, func() (err error) {
+			// The manifest is tagged by the index of base image if any.
+			manifestDesc, err = wf.pushManifest(ctx, state, targetRef, "bootstrap-merged.tar", records, opt.PushByDigest || state.BaseIndex != nil)
+			if err != nil {
+				return withClass(errors.Wrapf(err, "push manifest to %s", targetRef), ErrPush)
+			}
+			if state.BaseIndex != nil {
+				if manifestDesc, err = wf.pushIndex(ctx, state, *manifestDesc, targetRef, opt.PushByDigest); err != nil {
+					return withClass(errors.Wrapf(err, "push index to %s", targetRef), ErrPush)
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		logrus.Infof("pushed committed image to %s@%s", targetRef, manifestDesc.Digest)
+		manifestDigests[targetRef] = manifestDesc.Digest
+		if opt.Provenance {
+			if err := wf.pushProvenance(ctx, state, targetRef, *manifestDesc); err != nil {
+				return withClass(errors.Wrapf(err, "push provenance to %s", targetRef), ErrPush)
+			}
+		}
+		if opt.Metadata {
+			if err := wf.pushMetadata(ctx, state, targetRef, *manifestDesc); err != nil {
+				return withClass(errors.Wrapf(err, "push commit metadata to %s", targetRef), ErrPush)
+			}
+		}
+	}
+
+	if state.OCIBase != nil {
+		// Keep the same layer order with nydus image, mount layers are under upper layer.
+		ociLayers := []OCILayer{}
+		for _, mountBlob := range state.MountBlobs {
+			ociLayers = append(ociLayers, *mountBlob.OCILayer)
+		}
+		ociLayers = append(ociLayers, *state.UpperBlob.OCILayer)
+
+		for _, target := range opt.targets(FormatOCI) {
+			logrus.Infof("pushing committed oci image to %s", target.Ref)
+			start := time.Now()
+			manifestDesc, err := wf.pushOCIImage(ctx, state.OCIBaseRef, *state.OCIBase, ociLayers, target.Ref, opt.PushByDigest)
+			state.Timings.Record("push oci image "+target.Ref, start, time.Since(start))
+			if err != nil {
+				return withClass(errors.Wrapf(err, "push oci image to %s", target.Ref), ErrPush)
+			}
+			logrus.Infof("pushed committed oci image to %s@%s", target.Ref, manifestDesc.Digest)
+			manifestDigests[target.Ref] = manifestDesc.Digest
+			if opt.Provenance {
+				if err := wf.pushProvenance(ctx, state, target.Ref, *manifestDesc); err != nil {
+					return withClass(errors.Wrapf(err, "push provenance to %s", target.Ref), ErrPush)
+				}
+			}
+			if opt.Metadata {
+				if err := wf.pushMetadata(ctx, state, target.Ref, *manifestDesc); err != nil {
+					return withClass(errors.Wrapf(err, "push commit metadata to %s", target.Ref), ErrPush)
+				}
+			}
+		}
+	}
+
+	if opt.DigestFile != "" {
+		if err := writeDigestFile(opt.DigestFile, state, manifestDigests, opt.appendDigestFile); err != nil {
+			return errors.Wrap(err, "write digest file")
+		}
+	}
+
+	if opt.KeepLast > 0 {
+		wf.retainTags(ctx, state)
+	}
+
+	return nil
+}
+
+// baseDigests returns the manifest digests of base images as the ones of
+// targets, for the commit not pushed as nothing changed since the last
+// commit, which is the base image.
+func baseDigests(state *CommitState) map[string]digest.Digest {
+	digests := map[string]digest.Digest{}
+	for _, targetRef := range state.NydusTargetRefs {
+		digests[targetRef] = state.Base.Desc.Digest
+	}
+	if state.OCIBase != nil {
+		for _, target := range state.Option.targets(FormatOCI) {
+			digests[target.Ref] = state.OCIBase.Desc.Digest
+		}
+	}
+	return digests
+}
+
+// writeDigestFile writes the digests of manifests pushed to the targets
+// into `path`, one line per target in the order of targets, the lines are
+// appended to the existing file if `appendLines` is set.
+func writeDigestFile(path string, state *CommitState, manifestDigests map[string]digest.Digest, appendLines bool) error {
+	var content strings.Builder
+	nydusIdx := 0
+	for _, target := range state.Option.Targets {
+		ref := target.Ref
+		if target.Format == FormatNydus {
+			ref = state.NydusTargetRefs[nydusIdx]
+			nydusIdx++
+		}
+		manifestDigest, ok := manifestDigests[ref]
+		if !ok {
+			return fmt.Errorf("no manifest pushed to %s", ref)
+		}
+		content.WriteString(manifestDigest.String() + "\n")
+	}
+	if !appendLines {
+		return os.WriteFile(path, []byte(content.String()), 0644)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(content.String()); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
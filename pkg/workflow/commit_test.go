@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+func TestCheckTargets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/library/test/manifests/exists" {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromString("manifest").String())
+			w.Header().Set("Content-Length", "8")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	wf := &Workflow{cfg: &config.Config{}}
+	state := &CommitState{
+		Option:          CommitOption{Targets: []Target{{Ref: host + "/library/test:new", Format: FormatOCI}}},
+		NydusTargetRefs: []string{host + "/library/test:new_nydus_v2"},
+	}
+	require.NoError(t, wf.checkTargets(context.Background(), state))
+
+	state.Option.Targets = append(state.Option.Targets, Target{Ref: host + "/library/test:exists", Format: FormatOCI})
+	err := wf.checkTargets(context.Background(), state)
+	require.True(t, errors.Is(err, ErrTargetExists))
+	require.Contains(t, err.Error(), host+"/library/test:exists")
+}
+
+func TestWriteDigestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digest")
+	nydusDigest, ociDigest := digest.FromString("nydus"), digest.FromString("oci")
+	state := &CommitState{
+		Option: CommitOption{Targets: []Target{
+			{Ref: "localhost:5000/app:v1", Format: FormatOCI},
+			{Ref: "localhost:5000/app:v1", Format: FormatNydus},
+		}},
+		NydusTargetRefs: []string{"localhost:5000/app:v1_nydus_v2"},
+	}
+
+	manifestDigests := map[string]digest.Digest{
+		"localhost:5000/app:v1":          ociDigest,
+		"localhost:5000/app:v1_nydus_v2": nydusDigest,
+	}
+	require.NoError(t, writeDigestFile(path, state, manifestDigests, false))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, ociDigest.String()+"\n"+nydusDigest.String()+"\n", string(content))
+
+	require.NoError(t, writeDigestFile(path, state, manifestDigests, true))
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat(ociDigest.String()+"\n"+nydusDigest.String()+"\n", 2), string(content))
+
+	require.Error(t, writeDigestFile(path, state, map[string]digest.Digest{}, false))
+}
+
+func TestPushStageNoChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digest")
+	require.NoError(t, os.WriteFile(path, []byte(digest.FromString("previous").String()+"\n"), 0644))
+	nydusDigest, ociDigest := digest.FromString("nydus"), digest.FromString("oci")
+	state := &CommitState{
+		Option: CommitOption{
+			Targets: []Target{
+				{Ref: "localhost:5000/app:v2", Format: FormatOCI},
+				{Ref: "localhost:5000/app:v2", Format: FormatNydus},
+			},
+			DigestFile: path,
+		},
+		NydusTargetRefs: []string{"localhost:5000/app:v2_nydus_v2"},
+		Base:            &parserPkg.Image{Desc: ocispec.Descriptor{Digest: nydusDigest}},
+		OCIBase:         &parserPkg.Image{Desc: ocispec.Descriptor{Digest: ociDigest}},
+		NoChanges:       true,
+	}
+
+	// The digests of the last commit replace the ones of previous run.
+	wf := &Workflow{cfg: &config.Config{}}
+	require.NoError(t, wf.pushStage(context.Background(), state))
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, ociDigest.String()+"\n"+nydusDigest.String()+"\n", string(content))
+
+	// The following containers of pod append to the file.
+	state.Option.appendDigestFile = true
+	require.NoError(t, wf.pushStage(context.Background(), state))
+	content, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat(ociDigest.String()+"\n"+nydusDigest.String()+"\n", 2), string(content))
+}
+
+func TestOCIBaseRef(t *testing.T) {
+	wf := &Workflow{naming: distribution.DefaultNaming}
+
+	ref, err := wf.ociBaseRef(&container.InspectResult{Image: "localhost:5000/nginx:latest_nydus_v2", Nydus: true})
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:latest", ref)
+
+	// The container of OCI image is committed on top of converted base.
+	ref, err = wf.ociBaseRef(&container.InspectResult{Image: "localhost:5000/nginx@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac"})
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx@sha256:4c0fdaa8b6341bfdeca5f18f7837462c80cff90527ee35ef185571e1c327beac", ref)
+}
+
+func TestUnchanged(t *testing.T) {
+	mountA, mountB := digest.FromString("mount-a"), digest.FromString("mount-b")
+	mountBlob := func(blobDigest digest.Digest) Blob {
+		return Blob{Desc: ocispec.Descriptor{Digest: blobDigest}}
+	}
+	state := &CommitState{
+		UpperBlob:  &Blob{},
+		MountBlobs: []Blob{mountBlob(mountB), mountBlob(mountA)},
+		History: []CommitRecord{
+			{Blobs: []digest.Digest{digest.FromString("upper-1")}},
+			{Blobs: []digest.Digest{mountA, mountB, digest.FromString("upper-2")}},
+		},
+	}
+	require.True(t, unchanged(state))
+
+	state.UpperBlob.Stats.Entries = 1
+	require.False(t, unchanged(state))
+	state.UpperBlob.Stats.Entries = 0
+
+	state.MountBlobs = []Blob{mountBlob(mountA), mountBlob(mountA)}
+	require.False(t, unchanged(state))
+	state.MountBlobs = []Blob{mountBlob(mountA)}
+	require.False(t, unchanged(state))
+
+	// The first commit on base image without mounts.
+	state.MountBlobs, state.History = nil, nil
+	require.True(t, unchanged(state))
+	state.BaseBlobs = []Blob{{Name: "blob-base-0"}}
+	require.False(t, unchanged(state))
+}
+
+func TestPushStageOCIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cfg := &config.Config{}
+	cfg.Base.WorkDir = t.TempDir()
+	wf, err := NewWorkflow(cfg)
+	require.NoError(t, err)
+	defer wf.Destory()
+
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromString("layer"), Size: 5}
+	state := &CommitState{
+		Option:     CommitOption{Targets: []Target{{Ref: host + "/app:v1", Format: FormatOCI}}},
+		UpperBlob:  &Blob{OCILayer: &OCILayer{Name: "upper", Desc: layer}},
+		OCIBaseRef: host + "/base:v1",
+		OCIBase:    &parserPkg.Image{Manifest: ocispec.Manifest{Layers: []ocispec.Descriptor{layer}}},
+	}
+	// The failed push of OCI target is classed as the one of nydus target.
+	err = wf.pushStage(context.Background(), state)
+	require.ErrorIs(t, err, ErrPush)
+	require.Equal(t, 17, ExitCode(err))
+}
@@ -0,0 +1,394 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	nydusdPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/nydusd"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+type CompareOption struct {
+	// Source and Target are the references of nydus images compared, the
+	// changes are reported from Source to Target.
+	Source string
+	Target string
+	// Nydusd is the path of nydusd binary mounting the bootstraps.
+	Nydusd string
+}
+
+// FileDiff is a path differing between the compared images.
+type FileDiff struct {
+	Kind ChangeKind `json:"kind"`
+	Path string     `json:"path"`
+	// SourceSize and TargetSize are the sizes of regular file in each
+	// image, 0 for the others or if missing.
+	SourceSize int64 `json:"source_size"`
+	TargetSize int64 `json:"target_size"`
+	// Fields are the differing metadata of modified path, in mode, size,
+	// owner, mtime and link.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// CompareBlob is a blob referenced by only one of the compared images.
+type CompareBlob struct {
+	Digest digest.Digest `json:"digest"`
+	// Size is -1 if unknown, i.e. the blobs in external backend.
+	Size int64 `json:"size"`
+}
+
+// ImageSummary is the size of a compared image.
+type ImageSummary struct {
+	Ref      string `json:"ref"`
+	Files    int    `json:"files"`
+	FileSize int64  `json:"file_size"`
+	Blobs    int    `json:"blobs"`
+	// BlobSize excludes the blobs of unknown size.
+	BlobSize int64 `json:"blob_size"`
+}
+
+// CompareReport is the differences of files and blobs between two nydus
+// images.
+type CompareReport struct {
+	Source ImageSummary `json:"source"`
+	Target ImageSummary `json:"target"`
+	// Files are in the order of path.
+	Files        []FileDiff    `json:"files"`
+	AddedBlobs   []CompareBlob `json:"added_blobs"`
+	RemovedBlobs []CompareBlob `json:"removed_blobs"`
+}
+
+// Err returns an error if the files of compared images differ, the blobs
+// are not counted as rebase and flatten change them on purpose.
+func (r *CompareReport) Err() error {
+	if len(r.Files) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d files differ between %s and %s", len(r.Files), r.Source.Ref, r.Target.Ref)
+}
+
+// Print writes the differing files and blobs in lines, followed by the
+// size of both images and the delta.
+func (r *CompareReport) Print(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	for _, file := range r.Files {
+		switch file.Kind {
+		case ChangeAdded:
+			fmt.Fprintf(tw, "A\t%s\t%s\n", file.Path, humanize.Bytes(uint64(file.TargetSize)))
+		case ChangeDeleted:
+			fmt.Fprintf(tw, "D\t%s\t%s\n", file.Path, humanize.Bytes(uint64(file.SourceSize)))
+		default:
+			fmt.Fprintf(tw, "M\t%s\t%s -> %s\t%s\n", file.Path, humanize.Bytes(uint64(file.SourceSize)),
+				humanize.Bytes(uint64(file.TargetSize)), strings.Join(file.Fields, ","))
+		}
+	}
+	for _, blob := range r.AddedBlobs {
+		fmt.Fprintf(tw, "+\t%s\t%s\n", blob.Digest, blobSize(blob.Size))
+	}
+	for _, blob := range r.RemovedBlobs {
+		fmt.Fprintf(tw, "-\t%s\t%s\n", blob.Digest, blobSize(blob.Size))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, image := range []ImageSummary{r.Source, r.Target} {
+		fmt.Fprintf(writer, "%s: %d files, %s in files, %d blobs, %s in blobs\n",
+			image.Ref, image.Files, humanize.Bytes(uint64(image.FileSize)), image.Blobs, humanize.Bytes(uint64(image.BlobSize)))
+	}
+	_, err := fmt.Fprintf(writer, "delta: %s in files, %s in blobs\n",
+		sizeDelta(r.Target.FileSize-r.Source.FileSize), sizeDelta(r.Target.BlobSize-r.Source.BlobSize))
+	return err
+}
+
+// WriteJSON writes report in json.
+func (r *CompareReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func blobSize(size int64) string {
+	if size < 0 {
+		return "unknown"
+	}
+	return humanize.Bytes(uint64(size))
+}
+
+func sizeDelta(delta int64) string {
+	if delta < 0 {
+		return "-" + humanize.Bytes(uint64(-delta))
+	}
+	return "+" + humanize.Bytes(uint64(delta))
+}
+
+// fileEntry is the metadata of a path compared.
+type fileEntry struct {
+	mode    os.FileMode
+	size    int64
+	uid     uint32
+	gid     uint32
+	modTime time.Time
+	link    string
+}
+
+// compareImage is a compared image with its files mounted by nydusd.
+type compareImage struct {
+	summary ImageSummary
+	blobs   []CompareBlob
+	files   map[string]fileEntry
+}
+
+// Compare pulls the bootstraps of nydus images `opt.Source` and
+// `opt.Target`, and reports the differing files and blob sets. The
+// bootstraps are mounted by nydusd to read the metadata of files, so the
+// content of files is compared only by size and mtime.
+func (wf *Workflow) Compare(ctx context.Context, opt CompareOption) (*CompareReport, error) {
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return nil, err
+	}
+	source, err := wf.compareImage(ctx, opt.Nydusd, keys, opt.Source, "compare-source")
+	if err != nil {
+		return nil, errors.Wrapf(err, "read source image %s", opt.Source)
+	}
+	target, err := wf.compareImage(ctx, opt.Nydusd, keys, opt.Target, "compare-target")
+	if err != nil {
+		return nil, errors.Wrapf(err, "read target image %s", opt.Target)
+	}
+
+	report := &CompareReport{
+		Source: source.summary,
+		Target: target.summary,
+		Files:  compareFiles(source.files, target.files),
+	}
+	report.AddedBlobs, report.RemovedBlobs = compareBlobs(source.blobs, target.blobs)
+	return report, nil
+}
+
+// compareImage pulls the bootstrap of nydus image `ref` as `name`, and
+// reads its blobs and the metadata of files mounted by nydusd.
+func (wf *Workflow) compareImage(ctx context.Context, nydusd string, keys config.AnnotationKeys, ref, name string) (*compareImage, error) {
+	parsed, _, err := wf.pullBootstrap(ctx, ref, name)
+	if err != nil {
+		return nil, classify(err)
+	}
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&parsed.NydusImage.Manifest)
+	if bootstrapDesc == nil {
+		return nil, fmt.Errorf("not found nydus bootstrap layer")
+	}
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	ids, err := pullBlobIDs(ctx, remoter, bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return nil, classify(errors.Wrap(err, "pull blob ids"))
+	}
+	blobs, err := nydusBlobs(&parsed.NydusImage.Manifest, ids)
+	if err != nil {
+		return nil, err
+	}
+	image := &compareImage{
+		summary: ImageSummary{Ref: ref, Blobs: len(blobs)},
+		blobs:   blobs,
+	}
+	for _, blob := range blobs {
+		if blob.Size > 0 {
+			image.summary.BlobSize += blob.Size
+		}
+	}
+
+	// The blobs are never read as only the metadata of files in bootstrap
+	// is accessed, the empty placeholders satisfy the localfs backend.
+	dir := filepath.Join(wf.workDir, name+"-nydusd")
+	blobDir := filepath.Join(dir, "blobs")
+	if err := wf.createDir(blobDir); err != nil {
+		return nil, errors.Wrap(err, "create blob dir")
+	}
+	for _, blob := range blobs {
+		file, err := wf.createFile(filepath.Join(blobDir, blob.Digest.Encoded()))
+		if err != nil {
+			return nil, errors.Wrap(err, "create placeholder blob")
+		}
+		file.Close()
+	}
+	daemon := nydusdPkg.Daemon{
+		Path:      nydusd,
+		Bootstrap: filepath.Join(wf.workDir, name),
+		BlobDir:   blobDir,
+		MountPath: filepath.Join(dir, "mnt"),
+		WorkDir:   dir,
+	}
+	if err := daemon.Mount(ctx, os.Stderr); err != nil {
+		return nil, errors.Wrap(err, "mount bootstrap by nydusd")
+	}
+	defer func() {
+		if err := daemon.Umount(); err != nil {
+			logrus.WithError(err).Warnf("umount %s", daemon.MountPath)
+		}
+	}()
+
+	if image.files, err = readFileEntries(daemon.MountPath); err != nil {
+		return nil, errors.Wrap(err, "read mounted files")
+	}
+	for _, entry := range image.files {
+		image.summary.Files++
+		if entry.mode.IsRegular() {
+			image.summary.FileSize += entry.size
+		}
+	}
+	return image, nil
+}
+
+// nydusBlobs returns the blobs referenced by nydus image manifest, both
+// the blob layers and the blobs in external backend by blob `ids` of
+// bootstrap layer.
+func nydusBlobs(manifest *ocispec.Manifest, ids []string) ([]CompareBlob, error) {
+	blobs := []CompareBlob{}
+	seen := map[digest.Digest]bool{}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == utils.MediaTypeNydusBlob && !seen[layer.Digest] {
+			seen[layer.Digest] = true
+			blobs = append(blobs, CompareBlob{Digest: layer.Digest, Size: layer.Size})
+		}
+	}
+	for _, id := range ids {
+		blobDigest := digest.NewDigestFromEncoded(digest.SHA256, id)
+		if err := blobDigest.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid blob id %s", id)
+		}
+		if !seen[blobDigest] {
+			seen[blobDigest] = true
+			blobs = append(blobs, CompareBlob{Digest: blobDigest, Size: -1})
+		}
+	}
+	return blobs, nil
+}
+
+// readFileEntries reads the metadata of paths under `root` keyed by the
+// absolute path in image, the root itself is "/".
+func readFileEntries(root string) (map[string]fileEntry, error) {
+	entries := map[string]fileEntry{}
+	err := filepath.WalkDir(root, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		entry := fileEntry{
+			mode:    info.Mode(),
+			modTime: info.ModTime(),
+		}
+		if info.Mode().IsRegular() {
+			entry.size = info.Size()
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.uid, entry.gid = stat.Uid, stat.Gid
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		entries[path.Clean("/"+filepath.ToSlash(rel))] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// compareFiles returns the paths differing from `source` to `target` in
+// the order of path.
+func compareFiles(source, target map[string]fileEntry) []FileDiff {
+	diffs := []FileDiff{}
+	for name, sourceEntry := range source {
+		targetEntry, ok := target[name]
+		if !ok {
+			diffs = append(diffs, FileDiff{Kind: ChangeDeleted, Path: name, SourceSize: sourceEntry.size})
+			continue
+		}
+		fields := []string{}
+		if sourceEntry.mode != targetEntry.mode {
+			fields = append(fields, "mode")
+		}
+		if sourceEntry.size != targetEntry.size {
+			fields = append(fields, "size")
+		}
+		if sourceEntry.uid != targetEntry.uid || sourceEntry.gid != targetEntry.gid {
+			fields = append(fields, "owner")
+		}
+		// Directories are skipped as their mtime changes with the entries.
+		if !sourceEntry.mode.IsDir() && !sourceEntry.modTime.Equal(targetEntry.modTime) {
+			fields = append(fields, "mtime")
+		}
+		if sourceEntry.link != targetEntry.link {
+			fields = append(fields, "link")
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, FileDiff{
+				Kind:       ChangeModified,
+				Path:       name,
+				SourceSize: sourceEntry.size,
+				TargetSize: targetEntry.size,
+				Fields:     fields,
+			})
+		}
+	}
+	for name, targetEntry := range target {
+		if _, ok := source[name]; !ok {
+			diffs = append(diffs, FileDiff{Kind: ChangeAdded, Path: name, TargetSize: targetEntry.size})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+// compareBlobs returns the blobs only in `target` and the ones only in
+// `source`, in the order of each image.
+func compareBlobs(source, target []CompareBlob) ([]CompareBlob, []CompareBlob) {
+	inSource := map[digest.Digest]bool{}
+	for _, blob := range source {
+		inSource[blob.Digest] = true
+	}
+	inTarget := map[digest.Digest]bool{}
+	added := []CompareBlob{}
+	for _, blob := range target {
+		inTarget[blob.Digest] = true
+		if !inSource[blob.Digest] {
+			added = append(added, blob)
+		}
+	}
+	removed := []CompareBlob{}
+	for _, blob := range source {
+		if !inTarget[blob.Digest] {
+			removed = append(removed, blob)
+		}
+	}
+	return added, removed
+}
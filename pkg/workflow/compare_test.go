@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+)
+
+func TestCompareFiles(t *testing.T) {
+	now := time.Now()
+	source := map[string]fileEntry{
+		"/":        {mode: os.ModeDir | 0755, modTime: now},
+		"/app":     {mode: 0644, size: 10, modTime: now},
+		"/old":     {mode: 0644, size: 5, modTime: now},
+		"/link":    {mode: os.ModeSymlink | 0777, link: "app", modTime: now},
+		"/same":    {mode: 0600, size: 3, uid: 1000, modTime: now},
+		"/etc":     {mode: os.ModeDir | 0755, modTime: now},
+		"/etc/cfg": {mode: 0644, size: 1, modTime: now},
+	}
+	target := map[string]fileEntry{
+		"/":        {mode: os.ModeDir | 0755, modTime: now.Add(time.Second)},
+		"/app":     {mode: 0755, size: 20, modTime: now.Add(time.Second)},
+		"/new":     {mode: 0644, size: 7, modTime: now},
+		"/link":    {mode: os.ModeSymlink | 0777, link: "new", modTime: now},
+		"/same":    {mode: 0600, size: 3, uid: 1000, modTime: now},
+		"/etc":     {mode: os.ModeDir | 0755, modTime: now.Add(time.Second)},
+		"/etc/cfg": {mode: 0644, size: 1, gid: 10, modTime: now},
+	}
+
+	require.Equal(t, []FileDiff{
+		{Kind: ChangeModified, Path: "/app", SourceSize: 10, TargetSize: 20, Fields: []string{"mode", "size", "mtime"}},
+		{Kind: ChangeModified, Path: "/etc/cfg", SourceSize: 1, TargetSize: 1, Fields: []string{"owner"}},
+		{Kind: ChangeModified, Path: "/link", Fields: []string{"link"}},
+		{Kind: ChangeAdded, Path: "/new", TargetSize: 7},
+		{Kind: ChangeDeleted, Path: "/old", SourceSize: 5},
+	}, compareFiles(source, target))
+	require.Empty(t, compareFiles(source, source))
+}
+
+func TestCompareBlobs(t *testing.T) {
+	a := CompareBlob{Digest: digest.FromString("a"), Size: 1}
+	b := CompareBlob{Digest: digest.FromString("b"), Size: 2}
+	c := CompareBlob{Digest: digest.FromString("c"), Size: -1}
+
+	added, removed := compareBlobs([]CompareBlob{a, b}, []CompareBlob{b, c})
+	require.Equal(t, []CompareBlob{c}, added)
+	require.Equal(t, []CompareBlob{a}, removed)
+}
+
+func TestNydusBlobs(t *testing.T) {
+	keys, err := (&config.Annotations{}).ResolvedKeys()
+	require.NoError(t, err)
+
+	layer := ocispec.Descriptor{MediaType: utils.MediaTypeNydusBlob, Digest: digest.FromString("layer"), Size: 100}
+	external := digest.FromString("external")
+	manifest := &ocispec.Manifest{Layers: []ocispec.Descriptor{
+		layer,
+		{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    digest.FromString("bootstrap"),
+			Annotations: map[string]string{
+				utils.LayerAnnotationNydusBootstrap: "true",
+				keys.BlobIDs:                        `["` + layer.Digest.Encoded() + `","` + external.Encoded() + `"]`,
+			},
+		},
+	}}
+
+	ids, err := readBlobIDs(manifest.Layers[1].Annotations, keys, nil)
+	require.NoError(t, err)
+	blobs, err := nydusBlobs(manifest, ids)
+	require.NoError(t, err)
+	require.Equal(t, []CompareBlob{{Digest: layer.Digest, Size: 100}, {Digest: external, Size: -1}}, blobs)
+}
+
+func TestReadFileEntries(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "dir", "file"), []byte("hello"), 0644))
+	require.NoError(t, os.Symlink("dir/file", filepath.Join(root, "link")))
+
+	entries, err := readFileEntries(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	require.True(t, entries["/"].mode.IsDir())
+	require.Equal(t, int64(5), entries["/dir/file"].size)
+	require.Equal(t, "dir/file", entries["/link"].link)
+	require.Equal(t, int64(0), entries["/link"].size)
+}
+
+func TestCompareReport(t *testing.T) {
+	report := &CompareReport{
+		Source: ImageSummary{Ref: "app:v1", Files: 2, FileSize: 2000, Blobs: 1, BlobSize: 1000},
+		Target: ImageSummary{Ref: "app:v2", Files: 2, FileSize: 1000, Blobs: 1, BlobSize: 3000},
+		Files: []FileDiff{
+			{Kind: ChangeModified, Path: "/app", SourceSize: 2000, TargetSize: 1000, Fields: []string{"size"}},
+		},
+		AddedBlobs:   []CompareBlob{{Digest: digest.FromString("b"), Size: 3000}},
+		RemovedBlobs: []CompareBlob{{Digest: digest.FromString("a"), Size: -1}},
+	}
+	require.EqualError(t, report.Err(), "1 files differ between app:v1 and app:v2")
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Print(&buf))
+	require.Contains(t, buf.String(), "M  /app")
+	require.Contains(t, buf.String(), "2.0 kB -> 1.0 kB")
+	require.Contains(t, buf.String(), "-  "+digest.FromString("a").String()+"  unknown")
+	require.Contains(t, buf.String(), "delta: -1.0 kB in files, +2.0 kB in blobs\n")
+
+	report.Files = []FileDiff{}
+	require.NoError(t, report.Err())
+}
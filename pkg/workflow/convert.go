@@ -0,0 +1,190 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/archive/compression"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// convertBase prepares the nydus base image of container running an OCI
+// image. The nydus image named for the OCI image is used if it's already
+// converted in registry, otherwise the layers of OCI image are converted
+// to nydus blobs on the fly, which are pushed along with the committed
+// image.
+func (wf *Workflow) convertBase(ctx context.Context, state *CommitState) error {
+	ociRef := state.Inspect.Image
+	if nydusRef, err := wf.naming.NydusRef(ociRef); err != nil {
+		logrus.WithError(err).Warnf("skip looking up converted nydus image of %s", ociRef)
+	} else {
+		exists, err := distribution.NewWithResolver(wf.resolverFunc).IsImageExists(ctx, nydusRef)
+		if err != nil {
+			return errors.Wrapf(err, "check converted nydus image %s", nydusRef)
+		}
+		if exists {
+			logrus.Infof("found converted nydus image %s of base image", nydusRef)
+			return wf.pullBase(ctx, state, nydusRef)
+		}
+	}
+
+	remoter, err := wf.newRemote(ociRef)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return errors.Wrap(err, "parse oci image")
+	}
+	if parsed.OCIImage == nil {
+		return fmt.Errorf("not found oci image: %s", ociRef)
+	}
+	state.OCIBaseRef, state.OCIBase = ociRef, parsed.OCIImage
+
+	// The nydus base is needed only to merge bootstrap for nydus targets.
+	if len(state.Option.targets(FormatNydus)) == 0 {
+		state.Base = parsed.OCIImage
+		return nil
+	}
+
+	logrus.Infof("converting %d layers of base image %s", len(parsed.OCIImage.Manifest.Layers), ociRef)
+	start := time.Now()
+	base, blobs, err := wf.convertLayers(ctx, state.Option, remoter, *parsed.OCIImage, "bootstrap-base")
+	state.Timings.Record("convert base", start, time.Since(start))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("converted base image, elapsed: %s", time.Since(start))
+	state.Base = base
+	state.BaseBlobs = blobs
+
+	return nil
+}
+
+// convertLayers converts the layers of OCI image to nydus blobs in work
+// dir, and merges their bootstraps into `bootstrapName`. The returned
+// nydus image has the converted blobs as layers, and the manifest and
+// config of OCI image otherwise.
+func (wf *Workflow) convertLayers(ctx context.Context, opt CommitOption, remoter *remote.Remote, image parserPkg.Image, bootstrapName string) (*parserPkg.Image, []Blob, error) {
+	blobs := make([]Blob, len(image.Manifest.Layers))
+	eg := opt.newGroup()
+	for idx := range image.Manifest.Layers {
+		idx := idx
+		eg.Go(func() error {
+			name := fmt.Sprintf("blob-base-%d", idx)
+			desc, err := wf.convertLayer(ctx, remoter, image.Manifest.Layers[idx], name)
+			if err != nil {
+				return errors.Wrapf(err, "convert layer %s", image.Manifest.Layers[idx].Digest)
+			}
+			blobs[idx] = Blob{Name: name, Desc: *desc}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	layers := []converter.Layer{}
+	for _, blob := range blobs {
+		ra, err := local.OpenReader(filepath.Join(wf.workDir, blob.Name))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "open reader for converted blob")
+		}
+		defer ra.Close()
+		layers = append(layers, converter.Layer{
+			Digest:   blob.Desc.Digest,
+			ReaderAt: ra,
+		})
+	}
+
+	bootstrap, err := wf.createFile(filepath.Join(wf.workDir, bootstrapName))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create base bootstrap file")
+	}
+	defer bootstrap.Close()
+	builder, builderLog, err := wf.builder(bootstrapName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := converter.Merge(ctx, layers, bootstrap, converter.MergeOption{
+		WorkDir:     wf.workDir,
+		FsVersion:   fsVersion,
+		BuilderPath: builder,
+	}); err != nil {
+		return nil, nil, errors.Wrap(builderLog.wrap(err), "merge converted bootstraps")
+	}
+
+	manifest := image.Manifest
+	manifest.MediaType = ocispec.MediaTypeImageManifest
+	manifest.Layers = []ocispec.Descriptor{}
+	for _, blob := range blobs {
+		manifest.Layers = append(manifest.Layers, blob.Desc)
+	}
+	desc := image.Desc
+	desc.MediaType = ocispec.MediaTypeImageManifest
+
+	return &parserPkg.Image{Desc: desc, Manifest: manifest, Config: image.Config}, blobs, nil
+}
+
+// convertLayer converts the OCI layer `layer` to nydus blob `name` in work
+// dir.
+func (wf *Workflow) convertLayer(ctx context.Context, remoter *remote.Remote, layer ocispec.Descriptor, name string) (*ocispec.Descriptor, error) {
+	reader, err := remoter.Pull(ctx, layer, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull layer")
+	}
+	defer reader.Close()
+	// The pulled layer is compressed, whose size is known.
+	reporter := wf.newProgress("convert "+name, layer.Size)
+	defer reporter.Finish()
+	decompressed, err := compression.DecompressStream(io.TeeReader(reader, reporter))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress layer")
+	}
+	defer decompressed.Close()
+
+	blobPath := filepath.Join(wf.workDir, name)
+	blob, err := wf.createFile(blobPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create converted blob file")
+	}
+	defer blob.Close()
+
+	digester := digest.SHA256.Digester()
+	counter := Counter{}
+	packOpt, builderLog, err := wf.packOption(name)
+	if err != nil {
+		return nil, err
+	}
+	tarWc, err := converter.Pack(ctx, io.MultiWriter(wf.quota.Writer(blobPath, blob), digester.Hash(), &counter), packOpt)
+	if err != nil {
+		return nil, errors.Wrap(err, "initialize pack to blob")
+	}
+	if _, err := io.Copy(tarWc, decompressed); err != nil {
+		tarWc.Close()
+		return nil, errors.Wrap(builderLog.wrap(err), "pack layer to blob")
+	}
+	if err := tarWc.Close(); err != nil {
+		return nil, errors.Wrap(builderLog.wrap(err), "pack layer to blob")
+	}
+
+	logrus.Infof("converted layer %s to blob %s, size: %s", layer.Digest, digester.Digest(), humanize.Bytes(uint64(counter.Size())))
+	return blobDesc(digester.Digest(), counter.Size()), nil
+}
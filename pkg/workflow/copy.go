@@ -0,0 +1,263 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+type CopyOption struct {
+	Source string
+	Target string
+	// From is the workflow of source image, whose config has the backend
+	// of source blobs and the annotation keys of source image. The
+	// workflow itself is used if nil.
+	From *Workflow
+}
+
+// Copy copies nydus image `opt.Source` to `opt.Target`, including the
+// bootstrap and the blobs both in registry and in external backend. The
+// blobs are routed by the config of workflow as commit does, so they are
+// uploaded again if moved between registry and external backend, or the
+// source is in another external backend. Only the nydus manifest of the
+// source is copied, without the referrer artifacts other than the full
+// commit history and blob ids.
+func (wf *Workflow) Copy(ctx context.Context, opt CopyOption) error {
+	from := opt.From
+	if from == nil {
+		from = wf
+	}
+	start := time.Now()
+
+	sourceRemoter, err := from.newRemote(opt.Source)
+	if err != nil {
+		return errors.Wrap(err, "create source remote")
+	}
+	parser, err := parserPkg.New(sourceRemoter, "amd64")
+	if err != nil {
+		return errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return classify(errors.Wrap(err, "parse source image"))
+	}
+	if parsed.NydusImage == nil {
+		return withClass(fmt.Errorf("not a nydus image: %s", opt.Source), ErrNotNydusImage)
+	}
+	image := *parsed.NydusImage
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&image.Manifest)
+	if bootstrapDesc == nil {
+		return fmt.Errorf("not found nydus bootstrap layer")
+	}
+	if len(image.Config.RootFS.DiffIDs) == 0 {
+		return fmt.Errorf("not found bootstrap diff id in image config")
+	}
+
+	sourceKeys, err := from.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return errors.Wrap(err, "source annotations")
+	}
+	targetKeys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return err
+	}
+	ids, err := pullBlobIDs(ctx, sourceRemoter, bootstrapDesc.Annotations, sourceKeys)
+	if err != nil {
+		return classify(errors.Wrap(err, "pull blob ids"))
+	}
+	blobs, err := nydusBlobs(&image.Manifest, ids)
+	if err != nil {
+		return err
+	}
+	history, err := parseCommitHistory(bootstrapDesc.Annotations, sourceKeys)
+	if err != nil {
+		return errors.Wrap(err, "parse commit history")
+	}
+	records, err := from.pullCommitRecords(ctx, opt.Source, history)
+	if err != nil {
+		return classify(errors.Wrap(err, "pull commit history"))
+	}
+
+	blobLayers, blobIDs, err := wf.copyBlobs(ctx, from, opt, image.Manifest, blobs)
+	if err != nil {
+		return err
+	}
+
+	targetRemoter, err := wf.newRemote(opt.Target)
+	if err != nil {
+		return errors.Wrap(err, "create target remote")
+	}
+	reader, err := sourceRemoter.Pull(ctx, *bootstrapDesc, true)
+	if err != nil {
+		return classify(errors.Wrap(err, "pull bootstrap layer"))
+	}
+	defer reader.Close()
+	if err := targetRemoter.Push(ctx, *bootstrapDesc, true, reader); err != nil {
+		return withClass(classify(errors.Wrap(err, "push bootstrap layer")), ErrPush)
+	}
+
+	be, err := wf.backend(opt.Target)
+	if err != nil {
+		return err
+	}
+	limit, err := wf.cfg.Annotations.Limit()
+	if err != nil {
+		return err
+	}
+	newBootstrapDesc := *bootstrapDesc
+	newBootstrapDesc.Annotations = copyAnnotations(bootstrapDesc.Annotations, sourceKeys, targetKeys)
+	var fullBlobIDs []byte
+	if be.External() || (len(wf.cfg.Routing.Rules) > 0 && len(blobIDs) > 0) {
+		var value, fullValue string
+		value, fullValue, fullBlobIDs, err = boundBlobIDs(blobIDs, targetKeys.BlobIDs, targetKeys.BlobIDsFull, limit)
+		if err != nil {
+			return err
+		}
+		newBootstrapDesc.Annotations[targetKeys.BlobIDs] = value
+		if fullBlobIDs != nil {
+			newBootstrapDesc.Annotations[targetKeys.BlobIDsFull] = fullValue
+		}
+	}
+	var fullHistory []byte
+	if targetKeys.CommitHistory != "" && len(records) > 0 {
+		var value string
+		value, fullHistory, err = boundCommitHistory(records, targetKeys.CommitHistory, limit)
+		if err != nil {
+			return err
+		}
+		newBootstrapDesc.Annotations[targetKeys.CommitHistory] = value
+	}
+	warnAnnotations(newBootstrapDesc.Annotations, limit)
+
+	// The last diff id is of bootstrap layer.
+	imageConfig := image.Config
+	bootstrapDiffID := imageConfig.RootFS.DiffIDs[len(imageConfig.RootFS.DiffIDs)-1]
+	imageConfig.RootFS.DiffIDs = []digest.Digest{}
+	for _, layer := range blobLayers {
+		imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, layer.Digest)
+	}
+	imageConfig.RootFS.DiffIDs = append(imageConfig.RootFS.DiffIDs, bootstrapDiffID)
+	configBytes, configDesc, err := wf.makeDesc(ctx, imageConfig, image.Manifest.Config)
+	if err != nil {
+		return errors.Wrap(err, "make config desc")
+	}
+	if err := targetRemoter.Push(ctx, *configDesc, true, bytes.NewReader(configBytes)); err != nil {
+		return withClass(classify(errors.Wrap(err, "push image config")), ErrPush)
+	}
+
+	image.Manifest.Config = *configDesc
+	image.Manifest.Layers = append(blobLayers, newBootstrapDesc)
+	manifestBytes, manifestDesc, err := wf.makeDesc(ctx, image.Manifest, image.Desc)
+	if err != nil {
+		return errors.Wrap(err, "make manifest desc")
+	}
+	if err := targetRemoter.Push(ctx, *manifestDesc, false, bytes.NewReader(manifestBytes)); err != nil {
+		return withClass(classify(errors.Wrap(err, "push image manifest")), ErrPush)
+	}
+	if fullHistory != nil {
+		if err := wf.pushCommitHistory(ctx, targetRemoter, fullHistory, *manifestDesc); err != nil {
+			return withClass(classify(err), ErrPush)
+		}
+	}
+	if fullBlobIDs != nil {
+		if err := wf.pushBlobIDs(ctx, targetRemoter, fullBlobIDs, *manifestDesc); err != nil {
+			return withClass(classify(err), ErrPush)
+		}
+	}
+
+	logrus.Infof("copied %s to %s, blobs: %d, manifest: %s, elapsed: %s", opt.Source, opt.Target, len(blobs), manifestDesc.Digest, time.Since(start))
+	return nil
+}
+
+// copyBlobs copies the blobs of source image into the backends of target
+// routed by size, and returns the layers of blobs in registry and the ids
+// of blobs in external backend. The blobs in external backend shared by
+// source and target are kept as is without routing.
+func (wf *Workflow) copyBlobs(
+	ctx context.Context, from *Workflow, opt CopyOption, manifest ocispec.Manifest, blobs []CompareBlob,
+) ([]ocispec.Descriptor, []string, error) {
+	layers := map[digest.Digest]ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		layers[layer.Digest] = layer
+	}
+	sharedExternal := from == wf && wf.cfg.ExternalBackendType() != "" && len(wf.cfg.Routing.Rules) == 0
+
+	blobLayers := []ocispec.Descriptor{}
+	blobIDs := []string{}
+	for idx, blob := range blobs {
+		layer, inRegistry := layers[blob.Digest]
+		if !inRegistry && sharedExternal {
+			logrus.Infof("blob %s is shared in external backend", blob.Digest)
+			blobIDs = append(blobIDs, blob.Digest.Encoded())
+			continue
+		}
+
+		var source backend.Backend
+		var err error
+		if inRegistry {
+			source, err = from.backendOfType(opt.Source, config.BackendTypeRegistry)
+		} else {
+			externalType := from.cfg.ExternalBackendType()
+			if externalType == "" {
+				return nil, nil, fmt.Errorf("blob %s of %s is in external backend, but it's not configured", blob.Digest, opt.Source)
+			}
+			source, err = from.backendOfType(opt.Source, externalType)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := fmt.Sprintf("blob-copy-%d", idx)
+		size, err := wf.pullBlob(source, ocispec.Descriptor{Digest: blob.Digest, Size: blob.Size}, name)
+		if err != nil {
+			return nil, nil, classify(errors.Wrapf(err, "pull blob %s", blob.Digest))
+		}
+		desc := *blobDesc(blob.Digest, size)
+		if inRegistry {
+			desc = layer
+		}
+		if err := wf.pushBlob(ctx, name, desc, opt.Target); err != nil {
+			return nil, nil, withClass(classify(errors.Wrapf(err, "push blob %s", blob.Digest)), ErrPush)
+		}
+		if err := os.Remove(filepath.Join(wf.workDir, name)); err != nil {
+			logrus.WithError(err).Warnf("remove copied blob %s", name)
+		}
+		if wf.cfg.BlobBackendType(size) == config.BackendTypeRegistry {
+			blobLayers = append(blobLayers, desc)
+		} else {
+			blobIDs = append(blobIDs, blob.Digest.Encoded())
+		}
+	}
+	return blobLayers, blobIDs, nil
+}
+
+// copyAnnotations returns the annotations of bootstrap layer with the
+// commit blobs renamed from `sourceKeys` to `targetKeys`. The blob ids and
+// commit history are dropped, as they're rewritten for target.
+func copyAnnotations(annotations map[string]string, sourceKeys, targetKeys config.AnnotationKeys) map[string]string {
+	copied := map[string]string{}
+	for key, value := range annotations {
+		copied[key] = value
+	}
+	for _, key := range []string{sourceKeys.BlobIDs, sourceKeys.BlobIDsFull, sourceKeys.CommitHistory, sourceKeys.CommitBlobs} {
+		if key != "" {
+			delete(copied, key)
+		}
+	}
+	if value, ok := annotations[sourceKeys.CommitBlobs]; ok && sourceKeys.CommitBlobs != "" && targetKeys.CommitBlobs != "" {
+		copied[targetKeys.CommitBlobs] = value
+	}
+	return copied
+}
@@ -0,0 +1,39 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestCopyAnnotations(t *testing.T) {
+	sourceKeys := config.AnnotationKeys{
+		CommitBlobs:   config.AnnotationCommitBlobs,
+		BlobIDs:       config.AnnotationBlobIDs,
+		BlobIDsFull:   config.AnnotationBlobIDsFull,
+		CommitHistory: config.AnnotationCommitHistory,
+	}
+	annotations := map[string]string{
+		"containerd.io/snapshot/nydus-bootstrap": "true",
+		config.AnnotationCommitBlobs:             "sha256:aaa,sha256:bbb",
+		config.AnnotationBlobIDs:                 `["aaa"]`,
+		config.AnnotationBlobIDsFull:             `{"digest":"sha256:ccc"}`,
+		config.AnnotationCommitHistory:           `{"total":1}`,
+	}
+
+	copied := copyAnnotations(annotations, sourceKeys, sourceKeys)
+	require.Equal(t, map[string]string{
+		"containerd.io/snapshot/nydus-bootstrap": "true",
+		config.AnnotationCommitBlobs:             "sha256:aaa,sha256:bbb",
+	}, copied)
+	require.Len(t, annotations, 5)
+
+	targetKeys := config.AnnotationKeys{CommitBlobs: "example.com/commit-blobs", BlobIDs: "example.com/blob-ids"}
+	copied = copyAnnotations(annotations, sourceKeys, targetKeys)
+	require.Equal(t, map[string]string{
+		"containerd.io/snapshot/nydus-bootstrap": "true",
+		"example.com/commit-blobs":               "sha256:aaa,sha256:bbb",
+	}, copied)
+}
@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// LogFileName is the file in work dir which logs are captured to.
+	LogFileName = "nydus-cli.log"
+	// StagesFileName is the file in work dir which records the result of
+	// each commit stage.
+	StagesFileName = "stages.log"
+	// InspectFileName is the file in work dir which saves the inspected
+	// container.
+	InspectFileName = "inspect.json"
+	// RetriesFileName is the file in work dir which saves the retry summary.
+	RetriesFileName = "retries.json"
+)
+
+// KeepWorkDir makes Destory keep the work dir for debugging, the other
+// resources are still released.
+func (wf *Workflow) KeepWorkDir() {
+	wf.keepWorkDir = true
+}
+
+// WorkDir returns the work dir of this run.
+func (wf *Workflow) WorkDir() string {
+	return wf.workDir
+}
+
+type logHook struct {
+	mutex     sync.Mutex
+	file      *os.File
+	formatter logrus.Formatter
+}
+
+func (h *logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logHook) Fire(entry *logrus.Entry) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.file == nil {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.file.Write(line)
+	return err
+}
+
+func (h *logHook) close() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	err := h.file.Close()
+	h.file = nil
+	return err
+}
+
+// CaptureLogs copies the logs into work dir, so they are kept together with
+// the staged data if work dir is kept.
+func (wf *Workflow) CaptureLogs() error {
+	file, err := wf.createFile(filepath.Join(wf.workDir, LogFileName))
+	if err != nil {
+		return errors.Wrap(err, "create log file")
+	}
+	hook := &logHook{
+		file:      file,
+		formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true, TimestampFormat: time.RFC3339Nano},
+	}
+	logrus.AddHook(hook)
+	// Logrus can't remove a hook, it's disabled once closed.
+	wf.cleanups.add("close log file", hook.close)
+	return nil
+}
+
+// saveJSON saves `v` in work dir for debugging, it's best effort.
+func (wf *Workflow) saveJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err == nil {
+		var file *os.File
+		file, err = wf.createFile(filepath.Join(wf.workDir, name))
+		if err == nil {
+			_, err = file.Write(data)
+			file.Close()
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).Warnf("save %s", name)
+	}
+}
+
+// recordStage is the middleware which records the result of each stage
+// in work dir, it's best effort.
+func (wf *Workflow) recordStage(stage string, next StageFunc) StageFunc {
+	return func(ctx context.Context, state *CommitState) error {
+		start := time.Now()
+		err := next(ctx, state)
+
+		result := "ok"
+		if err != nil {
+			result = fmt.Sprintf("failed: %s", err)
+		}
+		line := fmt.Sprintf("%s %s %s, elapsed: %s\n", start.Format(time.RFC3339Nano), stage, result, time.Since(start))
+		file, openErr := os.OpenFile(filepath.Join(wf.workDir, StagesFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, wf.fileMode)
+		if openErr == nil {
+			_, openErr = file.WriteString(line)
+			file.Close()
+		}
+		if openErr != nil {
+			logrus.WithError(openErr).Warnf("record stage %s", stage)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,293 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+)
+
+// mountDedupKey is the content of mount path and the options deciding the
+// blob committed from it, whose digest keys the dedup index.
+type mountDedupKey struct {
+	Path            string               `json:"path"`
+	Fingerprint     digest.Digest        `json:"fingerprint"`
+	Ownership       *tarstream.Ownership `json:"ownership,omitempty"`
+	SourceDateEpoch *time.Time           `json:"source_date_epoch,omitempty"`
+	SpecialFiles    string               `json:"special_files,omitempty"`
+	NumericOwner    bool                 `json:"numeric_owner,omitempty"`
+	NoACLs          bool                 `json:"no_acls,omitempty"`
+	SELinux         bool                 `json:"selinux,omitempty"`
+	Unreadable      string               `json:"unreadable,omitempty"`
+	MaxEntries      int                  `json:"max_entries,omitempty"`
+	MaxSize         int64                `json:"max_size,omitempty"`
+	ChunkDict       string               `json:"chunk_dict,omitempty"`
+	BuilderVersion  string               `json:"builder_version,omitempty"`
+	BuilderArgs     []string             `json:"builder_args,omitempty"`
+}
+
+// mountDedupEntry is the blob committed from a mount path in dedup index.
+type mountDedupEntry struct {
+	Digest digest.Digest   `json:"digest"`
+	Size   int64           `json:"size"`
+	Stats  tarstream.Stats `json:"stats"`
+}
+
+// dedupMount returns the dedup key of mount path `sourceDir`, and the
+// blob indexed by it if the blob exists in the backends of all targets,
+// which is used by commit instead of packing the mount again. The key is
+// empty if dedup is disabled, i.e. without dedup dir or with OCI targets
+// whose layers are packed from the mount. The fingerprint is taken before
+// packing, so the files changed while packing are committed again by the
+// next commit. The dedup failures are logged and never fail the commit.
+func (wf *Workflow) dedupMount(ctx context.Context, state *CommitState, inspect *container.InspectResult, sourceDir, name string) (digest.Digest, *Blob) {
+	opt := state.Option
+	if wf.cfg.Mounts.DedupDir == "" || len(opt.targets(FormatOCI)) > 0 {
+		return "", nil
+	}
+	fingerprint, err := diff.Fingerprint(filepath.Join("/proc", strconv.Itoa(inspect.Pid), "root", sourceDir))
+	if err != nil {
+		logrus.WithError(err).Warnf("fingerprint mount %s, dedup disabled", sourceDir)
+		return "", nil
+	}
+	keyBytes, err := json.Marshal(mountDedupKey{
+		Path:            sourceDir,
+		Fingerprint:     fingerprint,
+		Ownership:       opt.Ownership,
+		SourceDateEpoch: opt.SourceDateEpoch,
+		SpecialFiles:    string(opt.SpecialFiles),
+		NumericOwner:    opt.MountNumericOwner,
+		NoACLs:          opt.MountNoACLs,
+		SELinux:         opt.MountSELinux,
+		Unreadable:      string(opt.MountUnreadable),
+		MaxEntries:      opt.MaxMountEntries,
+		MaxSize:         opt.MaxMountSize,
+		ChunkDict:       opt.ChunkDict,
+		BuilderVersion:  wf.cfg.Builder.Version,
+		BuilderArgs:     wf.cfg.Builder.Args,
+	})
+	if err != nil {
+		logrus.WithError(err).Warnf("marshal dedup key of mount %s, dedup disabled", sourceDir)
+		return "", nil
+	}
+	key := digest.FromBytes(keyBytes)
+
+	entry, err := wf.loadMountDedup(key)
+	if err != nil {
+		logrus.WithError(err).Warnf("load dedup index of mount %s", sourceDir)
+		return key, nil
+	}
+	if entry == nil {
+		return key, nil
+	}
+	for _, targetRef := range state.NydusTargetRefs {
+		if err := wf.statBlob(ctx, targetRef, entry.Digest, entry.Size); err != nil {
+			logrus.WithError(err).Infof("not reused blob %s of mount %s", entry.Digest, sourceDir)
+			return key, nil
+		}
+	}
+	if err := wf.copyMountTail(key, name); err != nil {
+		logrus.WithError(err).Warnf("copy bootstrap of blob %s of mount %s", entry.Digest, sourceDir)
+		return key, nil
+	}
+	logrus.Infof("reused blob %s of unchanged mount %s", entry.Digest, sourceDir)
+	return key, &Blob{Name: name, Desc: *blobDesc(entry.Digest, entry.Size), Stats: entry.Stats, Reused: true}
+}
+
+// statBlob checks blob `blobDigest` of `size` exists in the backend of
+// `targetRef` it's routed to.
+func (wf *Workflow) statBlob(ctx context.Context, targetRef string, blobDigest digest.Digest, size int64) error {
+	be, err := wf.backendOfType(targetRef, wf.cfg.BlobBackendType(size))
+	if err != nil {
+		return err
+	}
+	stater, ok := be.(backend.Stater)
+	if !ok {
+		return fmt.Errorf("backend of %s can't stat blob", targetRef)
+	}
+	actual, err := stater.Stat(ctx, blobDigest)
+	if err != nil {
+		return errors.Wrapf(err, "stat blob in %s", targetRef)
+	}
+	if actual != size {
+		return fmt.Errorf("size mismatch of blob in %s: %d != %d", targetRef, actual, size)
+	}
+	return nil
+}
+
+func mountDedupPaths(dedupDir string, key digest.Digest) (string, string) {
+	return filepath.Join(dedupDir, "index", key.Encoded()+".json"), filepath.Join(dedupDir, "bootstraps", key.Encoded())
+}
+
+// loadMountDedup returns the entry of `key` in dedup index, or nil if not
+// indexed.
+func (wf *Workflow) loadMountDedup(key digest.Digest) (*mountDedupEntry, error) {
+	indexPath, tailPath := mountDedupPaths(wf.cfg.Mounts.DedupDir, key)
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entry mountDedupEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal %s", indexPath)
+	}
+	if _, err := os.Stat(tailPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// copyMountTail copies the tail of blob indexed by `key`, which contains
+// the bootstrap, into work dir as blob `name` to be merged.
+func (wf *Workflow) copyMountTail(key digest.Digest, name string) error {
+	indexPath, tailPath := mountDedupPaths(wf.cfg.Mounts.DedupDir, key)
+	tail, err := os.Open(tailPath)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+	file, err := wf.createFile(filepath.Join(wf.workDir, name))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, tail); err != nil {
+		return errors.Wrap(err, "copy bootstrap of blob")
+	}
+	// The index and bootstrap are kept by the time they are last used, so
+	// the stale ones can be cleaned up by age.
+	now := time.Now()
+	for _, path := range []string{indexPath, tailPath} {
+		if err := os.Chtimes(path, now, now); err != nil {
+			logrus.WithError(err).Warnf("update modification time of %s", path)
+		}
+	}
+	return nil
+}
+
+// indexMount adds the mount `blob` packed and pushed into dedup index by
+// `key`, with the tail of blob read to unpack its bootstrap, which is all
+// merge needs from the blob. It does nothing if `key` is empty.
+func (wf *Workflow) indexMount(key digest.Digest, blob *Blob) {
+	if key == "" {
+		return
+	}
+	if err := wf.saveMountDedup(key, blob); err != nil {
+		logrus.WithError(err).Warnf("add blob %s into dedup index", blob.Desc.Digest)
+	}
+}
+
+func (wf *Workflow) saveMountDedup(key digest.Digest, blob *Blob) error {
+	ra, err := local.OpenReader(filepath.Join(wf.workDir, blob.Name))
+	if err != nil {
+		return errors.Wrap(err, "open reader for blob")
+	}
+	defer ra.Close()
+	recorder := &offsetRecorder{ReaderAt: ra, min: ra.Size()}
+	if _, err := converter.UnpackEntry(recorder, converter.EntryBootstrap, io.Discard); err != nil {
+		return errors.Wrap(err, "unpack bootstrap of blob")
+	}
+
+	indexPath, tailPath := mountDedupPaths(wf.cfg.Mounts.DedupDir, key)
+	if err := writeFileAtomic(tailPath, io.NewSectionReader(ra, recorder.min, ra.Size()-recorder.min)); err != nil {
+		return errors.Wrap(err, "write bootstrap of blob")
+	}
+	data, err := json.Marshal(mountDedupEntry{Digest: blob.Desc.Digest, Size: blob.Desc.Size, Stats: blob.Stats})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(indexPath, bytes.NewReader(data))
+}
+
+// offsetRecorder records the minimum offset read from ReaderAt.
+type offsetRecorder struct {
+	content.ReaderAt
+	mutex sync.Mutex
+	min   int64
+}
+
+func (r *offsetRecorder) ReadAt(p []byte, off int64) (int, error) {
+	r.mutex.Lock()
+	if off < r.min {
+		r.min = off
+	}
+	r.mutex.Unlock()
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// tailReaderAt reads a blob of `size` from the file containing only its
+// tail, which is enough to unpack the bootstrap of blob.
+type tailReaderAt struct {
+	content.ReaderAt
+	size int64
+}
+
+func (r *tailReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	offset := r.size - r.ReaderAt.Size()
+	if off < offset {
+		return 0, fmt.Errorf("read offset %d before the tail at %d", off, offset)
+	}
+	return r.ReaderAt.ReadAt(p, off-offset)
+}
+
+func (r *tailReaderAt) Size() int64 {
+	return r.size
+}
+
+// openBlob opens the file of `blob` in work dir to read, the reused blob
+// has only its tail in file.
+func (wf *Workflow) openBlob(blob Blob) (content.ReaderAt, error) {
+	ra, err := local.OpenReader(filepath.Join(wf.workDir, blob.Name))
+	if err != nil {
+		return nil, err
+	}
+	if blob.Reused {
+		return &tailReaderAt{ReaderAt: ra, size: blob.Desc.Size}, nil
+	}
+	return ra, nil
+}
+
+// writeFileAtomic writes `path` from `reader` by renaming a temp file, so
+// the concurrent runs never see a partial file.
+func writeFileAtomic(path string, reader io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := io.Copy(temp, reader); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,94 @@
+package workflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+)
+
+// nydusBlob returns a nydus blob of `data` and `bootstrap` with TOC, as
+// `data | tar_header | bootstrap | tar_header | toc_entry | tar_header`.
+func nydusBlob(t *testing.T, data, bootstrap []byte) []byte {
+	var blob bytes.Buffer
+	appendEntry := func(name string, content []byte) {
+		blob.Write(content)
+		var header bytes.Buffer
+		require.NoError(t, tar.NewWriter(&header).WriteHeader(&tar.Header{
+			Name: name, Typeflag: tar.TypeReg, Size: int64(len(content)), Mode: 0444,
+		}))
+		blob.Write(header.Bytes()[:512])
+	}
+	appendEntry(converter.EntryBlob, data)
+	offset := blob.Len()
+	appendEntry(converter.EntryBootstrap, bootstrap)
+
+	entry := converter.TOCEntry{
+		Flags:            uint32(converter.CompressorNone),
+		CompressedOffset: uint64(offset),
+		CompressedSize:   uint64(len(bootstrap)),
+		UncompressedSize: uint64(len(bootstrap)),
+	}
+	copy(entry.Name[:], converter.EntryBootstrap)
+	var toc bytes.Buffer
+	require.NoError(t, binary.Write(&toc, binary.LittleEndian, &entry))
+	// The entries are aligned to 128 bytes.
+	toc.Write(make([]byte, 128-toc.Len()))
+	appendEntry(converter.EntryTOC, toc.Bytes())
+	return blob.Bytes()
+}
+
+func TestMountDedup(t *testing.T) {
+	workDir := t.TempDir()
+	wf := &Workflow{
+		cfg:      &config.Config{Mounts: config.Mounts{DedupDir: t.TempDir()}},
+		workDir:  workDir,
+		fileMode: 0600,
+	}
+	bootstrap := []byte("bootstrap of mount")
+	data := nydusBlob(t, bytes.Repeat([]byte("chunk"), 1024), bootstrap)
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "blob-mount-0"), data, 0600))
+	blob := &Blob{
+		Name:  "blob-mount-0",
+		Desc:  *blobDesc(digest.FromBytes(data), int64(len(data))),
+		Stats: tarstream.Stats{Entries: 2, Files: 1, Size: 5120},
+	}
+	key := digest.FromString("mount key")
+
+	entry, err := wf.loadMountDedup(key)
+	require.NoError(t, err)
+	require.Nil(t, entry)
+
+	require.NoError(t, wf.saveMountDedup(key, blob))
+	entry, err = wf.loadMountDedup(key)
+	require.NoError(t, err)
+	require.Equal(t, &mountDedupEntry{Digest: blob.Desc.Digest, Size: blob.Desc.Size, Stats: blob.Stats}, entry)
+
+	// Only the tail of blob from bootstrap is kept.
+	require.NoError(t, wf.copyMountTail(key, "blob-mount-1"))
+	info, err := os.Stat(filepath.Join(workDir, "blob-mount-1"))
+	require.NoError(t, err)
+	require.Less(t, info.Size(), int64(len(data)))
+
+	reused := Blob{Name: "blob-mount-1", Desc: blob.Desc, Reused: true}
+	ra, err := wf.openBlob(reused)
+	require.NoError(t, err)
+	defer ra.Close()
+	require.Equal(t, int64(len(data)), ra.Size())
+	var unpacked bytes.Buffer
+	_, err = converter.UnpackEntry(ra, converter.EntryBootstrap, &unpacked)
+	require.NoError(t, err)
+	require.Equal(t, bootstrap, unpacked.Bytes())
+
+	_, err = ra.ReadAt(make([]byte, 1), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package workflow
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"text/tabwriter"
+
+	"github.com/containerd/continuity/fs"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+)
+
+// ChangeKind is the kind of a changed path.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeModified ChangeKind = "modified"
+	ChangeDeleted  ChangeKind = "deleted"
+)
+
+// changeSymbols are the symbols of change kinds in DiffReport.Print.
+var changeSymbols = map[ChangeKind]string{
+	ChangeAdded:    "A",
+	ChangeModified: "M",
+	ChangeDeleted:  "D",
+}
+
+// Change is a path in container captured by commit.
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	Path string     `json:"path"`
+	// Size of regular file, 0 for the others.
+	Size int64 `json:"size"`
+	// Mount is the path the change is copied from by commit, i.e. a mount
+	// path of DiffOption.WithPaths, or the path unsupported by differ and
+	// appended as a mount, empty for the changes of upper dir.
+	Mount string `json:"mount,omitempty"`
+}
+
+type DiffOption struct {
+	ContainerIDWithType string
+	// WithPaths are the mount paths listed, all the files in them are
+	// added as the mount replaces the whole directory.
+	WithPaths []string
+	// WithoutPaths are excluded from the changes of upper dir.
+	WithoutPaths []string
+	// MountStrategy is how the mount paths are copied, see MountStrategy.
+	MountStrategy MountStrategy
+}
+
+// DiffReport is the changes a commit of container would capture.
+type DiffReport struct {
+	Container string `json:"container"`
+	Image     string `json:"image"`
+	// Changes of upper dir in the order of path, followed by the ones of
+	// mount paths.
+	Changes []Change `json:"changes"`
+}
+
+// Print writes the changes in lines of kind symbol, path and size,
+// followed by a summary.
+func (r *DiffReport) Print(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	counts := map[ChangeKind]int{}
+	var size int64
+	for _, change := range r.Changes {
+		counts[change.Kind]++
+		size += change.Size
+		fmt.Fprintf(tw, "%s\t%s\t%s", changeSymbols[change.Kind], change.Path, humanize.Bytes(uint64(change.Size)))
+		if change.Mount != "" {
+			fmt.Fprintf(tw, "\tmount %s", change.Mount)
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(writer, "%d added, %d modified, %d deleted, %s in files\n",
+		counts[ChangeAdded], counts[ChangeModified], counts[ChangeDeleted], humanize.Bytes(uint64(size)))
+	return err
+}
+
+// WriteJSON writes report in json.
+func (r *DiffReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Diff lists the changes in container which a commit would capture
+// without committing them, the container is neither paused nor modified.
+func (wf *Workflow) Diff(ctx context.Context, opt DiffOption) (*DiffReport, error) {
+	inspect, err := wf.cm.Inspect(ctx, opt.ContainerIDWithType)
+	if err != nil {
+		return nil, errors.Wrap(err, "inspect container")
+	}
+	report := &DiffReport{
+		Container: opt.ContainerIDWithType,
+		Image:     inspect.Image,
+		Changes:   []Change{},
+	}
+
+	mountList := NewMountList()
+	if err := diff.Walk(ctx, mountList.Add, opt.WithoutPaths, inspect.LowerDirs, inspect.UpperDir, func(kind fs.ChangeKind, name string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		change := Change{Path: name}
+		switch kind {
+		case fs.ChangeKindAdd:
+			change.Kind = ChangeAdded
+		case fs.ChangeKindModify:
+			change.Kind = ChangeModified
+		case fs.ChangeKindDelete:
+			change.Kind = ChangeDeleted
+		default:
+			return nil
+		}
+		if change.Kind != ChangeDeleted && f != nil && f.Mode().IsRegular() {
+			change.Size = f.Size()
+		}
+		report.Changes = append(report.Changes, change)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "diff upper dir")
+	}
+	for _, appended := range append(mountList.paths, mountList.files...) {
+		report.Changes = append(report.Changes, Change{Kind: ChangeModified, Path: appended, Mount: appended})
+	}
+
+	if len(opt.WithPaths) == 0 {
+		return report, nil
+	}
+	strategy := wf.resolveMountStrategy(ctx, opt.MountStrategy, inspect.Pid)
+	for idx, withPath := range opt.WithPaths {
+		changes, err := wf.mountChanges(ctx, strategy, inspect, withPath, fmt.Sprintf("diff-mount-%d", idx))
+		if err != nil {
+			return nil, errors.Wrapf(err, "list mount %s", withPath)
+		}
+		report.Changes = append(report.Changes, changes...)
+	}
+	return report, nil
+}
+
+// mountChanges lists the entries of mount path `source` copied by
+// `strategy` as commitMount does, `name` is the work dir of copy from host
+// path.
+func (wf *Workflow) mountChanges(ctx context.Context, strategy MountStrategy, inspect *container.InspectResult, source, name string) ([]Change, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if strategy == MountHostPath {
+			err = wf.copyFromHost(ctx, inspect.Mounts, source, name, pw, copyOption{})
+		} else {
+			err = copyFromContainer(ctx, inspect.Pid, []string{source}, pw, copyOption{})
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	changes := []Change{}
+	tr := tar.NewReader(pr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			pr.CloseWithError(err)
+			if copyErr := <-done; copyErr != nil {
+				return nil, copyErr
+			}
+			return nil, errors.Wrap(err, "read tar header")
+		}
+		change := Change{Kind: ChangeAdded, Path: path.Clean("/" + hdr.Name), Mount: source}
+		if hdr.Typeflag == tar.TypeReg {
+			change.Size = hdr.Size
+		}
+		changes = append(changes, change)
+	}
+	// Drain the padding after end-of-archive marker.
+	_, _ = io.Copy(io.Discard, pr)
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,37 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffReport(t *testing.T) {
+	report := &DiffReport{
+		Container: "docker://abc",
+		Image:     "registry.example.com/app:v1_nydus_v2",
+		Changes: []Change{
+			{Kind: ChangeAdded, Path: "/etc/app.conf", Size: 2048},
+			{Kind: ChangeModified, Path: "/var/log", Size: 0},
+			{Kind: ChangeDeleted, Path: "/tmp/cache"},
+			{Kind: ChangeAdded, Path: "/data/db", Size: 1000, Mount: "/data"},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Print(&buf))
+	require.Equal(t, "A  /etc/app.conf  2.0 kB\n"+
+		"M  /var/log       0 B\n"+
+		"D  /tmp/cache     0 B\n"+
+		"A  /data/db       1.0 kB  mount /data\n"+
+		"2 added, 1 modified, 1 deleted, 3.0 kB in files\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, report.WriteJSON(&buf))
+	var decoded DiffReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, *report, decoded)
+	require.NotContains(t, buf.String(), `"mount": ""`)
+}
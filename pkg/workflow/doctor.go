@@ -0,0 +1,153 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/builder"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/doctor"
+)
+
+// DoctorOption is the option of Doctor.
+type DoctorOption struct {
+	// Targets are the references of images to commit, whose registries
+	// are checked for pull and push access.
+	Targets []string
+	// MinFree is the minimum available bytes of work dir.
+	MinFree uint64
+}
+
+// Doctor checks the environment of commits configured by `cfg`: the
+// builder, the container engines, the kernel, the work dir, and the
+// registries of targets and the external backend. All checks run even if
+// some failed, so all problems are reported at once.
+func Doctor(ctx context.Context, cfg *config.Config, opt DoctorOption) *doctor.Report {
+	report := &doctor.Report{}
+	report.Run(ctx,
+		doctor.Check{Name: "root", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Root()
+		}},
+		doctor.Check{Name: "overlayfs", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Filesystem("overlay")
+		}},
+		doctor.Check{Name: "nsenter", Run: func(ctx context.Context) (string, error) {
+			return "", doctor.Nsenter(ctx)
+		}},
+		doctor.Check{Name: "workdir", Run: func(ctx context.Context) (string, error) {
+			return doctor.WorkDir(cfg.Base.WorkDir, opt.MinFree)
+		}},
+		doctor.Check{Name: "builder", Run: func(ctx context.Context) (string, error) {
+			releaseClient, err := builder.ReleaseClient(cfg)
+			if err != nil {
+				return "", err
+			}
+			path, err := builder.Discover(ctx, cfg.Base.Builder, cfg.Builder, filepath.Join(cfg.Base.WorkDir, "builder"), releaseClient)
+			if err != nil {
+				return "", err
+			}
+			return doctor.Binary(ctx, path)
+		}},
+	)
+	report.Run(ctx, engineChecks(cfg.Base.Runtime)...)
+
+	wf, err := NewWorkflow(cfg)
+	if err != nil {
+		report.Run(ctx, doctor.Check{Name: "workflow", Run: func(ctx context.Context) (string, error) {
+			return "", err
+		}})
+		return report
+	}
+	defer func() {
+		if err := wf.Destory(); err != nil {
+			logrus.WithError(err).Warn("destroy workflow")
+		}
+	}()
+
+	for _, target := range opt.Targets {
+		target := target
+		report.Run(ctx, doctor.Check{Name: "registry " + target, Run: func(ctx context.Context) (string, error) {
+			return "", wf.checkRegistry(ctx, target)
+		}})
+	}
+	if backendType := cfg.ExternalBackendType(); backendType != "" {
+		report.Run(ctx, doctor.Check{Name: "backend " + backendType, Run: func(ctx context.Context) (string, error) {
+			return "", wf.checkBackend(ctx, backendType)
+		}})
+	}
+	return report
+}
+
+// engineChecks returns the checks of the container engines whose socket
+// exists, at least one engine is required.
+func engineChecks(runtime config.Runtime) []doctor.Check {
+	checks := []doctor.Check{}
+	for _, engine := range []struct {
+		name string
+		addr string
+	}{
+		{"docker", runtime.DockerAddr},
+		{"pouch", runtime.PouchAddr},
+	} {
+		engine := engine
+		if _, err := os.Stat(engine.addr); err != nil {
+			continue
+		}
+		checks = append(checks, doctor.Check{Name: engine.name, Run: func(ctx context.Context) (string, error) {
+			return doctor.Engine(ctx, engine.addr)
+		}})
+	}
+	if len(checks) == 0 {
+		checks = append(checks, doctor.Check{Name: "engine", Run: func(ctx context.Context) (string, error) {
+			return "", fmt.Errorf("neither docker socket %s nor pouch socket %s exists", runtime.DockerAddr, runtime.PouchAddr)
+		}})
+	}
+	return checks
+}
+
+// checkRegistry checks that the repository of `ref` can be pulled and
+// pushed, the tag of `ref` isn't required to exist.
+func (wf *Workflow) checkRegistry(ctx context.Context, ref string) error {
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	if _, err := remoter.Resolve(ctx); err != nil && !errdefs.IsNotFound(err) {
+		if !remoter.MaybeWithHTTP(err) {
+			return classify(errors.Wrap(err, "resolve"))
+		}
+		if _, err := remoter.Resolve(ctx); err != nil && !errdefs.IsNotFound(err) {
+			return classify(errors.Wrap(err, "resolve"))
+		}
+	}
+
+	// The config of artifacts is pushed, which is likely in registry
+	// already.
+	if err := remoter.Push(ctx, emptyJSONDesc, true, bytes.NewReader(emptyJSON)); err != nil {
+		return classify(errors.Wrap(err, "push"))
+	}
+	return nil
+}
+
+// checkBackend checks that the external backend of `backendType` is
+// reachable and authorized, by stating a blob which may not exist.
+func (wf *Workflow) checkBackend(ctx context.Context, backendType string) error {
+	be, err := wf.backendOfType("", backendType)
+	if err != nil {
+		return err
+	}
+	if stater, ok := be.(backend.Stater); ok {
+		if _, err := stater.Stat(ctx, emptyJSONDesc.Digest); err != nil && !errdefs.IsNotFound(err) {
+			return errors.Wrap(err, "stat blob")
+		}
+	}
+	return nil
+}
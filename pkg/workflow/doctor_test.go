@@ -0,0 +1,56 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestCheckRegistry(t *testing.T) {
+	var mutex sync.Mutex
+	uploaded := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/v2/denied/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case req.Method == http.MethodPost && req.URL.Path == "/v2/allowed/blobs/uploads/":
+			w.Header().Set("Location", "/v2/allowed/blobs/uploads/1")
+			w.WriteHeader(http.StatusAccepted)
+		case req.Method == http.MethodPut && req.URL.Path == "/v2/allowed/blobs/uploads/1":
+			mutex.Lock()
+			uploaded = req.URL.Query().Get("digest") == "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+			mutex.Unlock()
+			w.Header().Set("Docker-Content-Digest", req.URL.Query().Get("digest"))
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	wf := &Workflow{cfg: &config.Config{}}
+	require.NoError(t, wf.checkRegistry(context.Background(), host+"/allowed:latest"))
+	mutex.Lock()
+	require.True(t, uploaded)
+	mutex.Unlock()
+
+	err := wf.checkRegistry(context.Background(), host+"/denied:latest")
+	require.ErrorIs(t, err, ErrAuth)
+}
+
+func TestEngineChecks(t *testing.T) {
+	checks := engineChecks(config.Runtime{DockerAddr: "/nonexistent/docker.sock", PouchAddr: "/nonexistent/pouchd.sock"})
+	require.Len(t, checks, 1)
+	_, err := checks[0].Run(context.Background())
+	require.ErrorContains(t, err, "neither docker socket /nonexistent/docker.sock nor pouch socket /nonexistent/pouchd.sock exists")
+}
@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"net/http"
+
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/scan"
+	"github.com/nydusaccelerator/nydus-cli/pkg/workdir"
+)
+
+// The classes of failures, the errors returned by Commit and Check match
+// the class by errors.Is if known, so wrappers can branch on the class
+// instead of the message. An error may be in several classes, e.g. the
+// authentication failure while pushing is also ErrPush.
+var (
+	// ErrAuth is the authentication or authorization failure of registry.
+	ErrAuth = errors.New("registry authentication failed")
+	// ErrNotNydusImage is returned if the image of container or the base
+	// image is not a nydus image.
+	ErrNotNydusImage = errors.New("not a nydus image")
+	// ErrMaximumTimes is returned if the base image has been committed the
+	// maximum times.
+	ErrMaximumTimes = errors.New("reached maximum committed times")
+	// ErrBuilder is the failure of builder.
+	ErrBuilder = errors.New("builder failed")
+	// ErrPush is the failure of pushing blobs or manifests.
+	ErrPush = errors.New("push failed")
+	// ErrContainerNotFound is returned if the container is not found.
+	ErrContainerNotFound = container.ErrNotFound
+	// ErrTimeBudget is returned if the commit is aborted as it isn't
+	// finished in CommitOption.TimeBudget.
+	ErrTimeBudget = errors.New("time budget exceeded")
+	// ErrSecretsFound is returned if the secrets of blocking severity are
+	// found in the committed layers.
+	ErrSecretsFound = scan.ErrSecretsFound
+	// ErrVulnerabilities is returned if the vulnerabilities at or above the
+	// threshold are found in the pushed images.
+	ErrVulnerabilities = scan.ErrVulnerabilities
+)
+
+// ExitCodes are the exit codes of nydus-cli for the classes of failures in
+// the order of precedence, the others exit with 1.
+var ExitCodes = []struct {
+	Err  error
+	Code int
+}{
+	// The other failures are mostly caused by the abort.
+	{ErrTimeBudget, 18},
+	{ErrAuth, 10},
+	{ErrNotNydusImage, 11},
+	{ErrMaximumTimes, 12},
+	{ErrBuilder, 13},
+	{ErrContainerNotFound, 14},
+	{ErrTargetExists, 15},
+	{workdir.ErrQuotaExceeded, 16},
+	{ErrPush, 17},
+	{ErrSecretsFound, 19},
+	{ErrVulnerabilities, 20},
+}
+
+// ExitCode returns the exit code of `err`, see ExitCodes.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, exitCode := range ExitCodes {
+		if errors.Is(err, exitCode.Err) {
+			return exitCode.Code
+		}
+	}
+	return 1
+}
+
+// classified is an error in a class of failures, the message of error is
+// kept as is.
+type classified struct {
+	error
+	class error
+}
+
+func (e *classified) Unwrap() error {
+	return e.error
+}
+
+func (e *classified) Is(target error) bool {
+	return target == e.class
+}
+
+// withClass puts `err` in `class`, which is matched by errors.Is.
+func withClass(err, class error) error {
+	if err == nil || errors.Is(err, class) {
+		return err
+	}
+	return &classified{error: err, class: class}
+}
+
+// isAuthError checks whether `err` is caused by the rejected credentials
+// or the unauthorized requests of registry.
+func isAuthError(err error) bool {
+	if errors.Is(err, docker.ErrInvalidAuthorization) {
+		return true
+	}
+	var status remoteserrors.ErrUnexpectedStatus
+	if errors.As(err, &status) {
+		return status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// classify puts the registry errors of `err` in ErrAuth.
+func classify(err error) error {
+	if err != nil && isAuthError(err) {
+		return withClass(err, ErrAuth)
+	}
+	return err
+}
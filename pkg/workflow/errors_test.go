@@ -0,0 +1,49 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/containerd/containerd/remotes/docker"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+func TestExitCode(t *testing.T) {
+	require.Equal(t, 0, ExitCode(nil))
+	require.Equal(t, 1, ExitCode(fmt.Errorf("unknown")))
+
+	err := withClass(fmt.Errorf("reached maximum committed times %d", 400), ErrMaximumTimes)
+	require.Equal(t, "reached maximum committed times 400", err.Error())
+	wrapped := errors.Wrap(err, "stage check")
+	require.ErrorIs(t, wrapped, ErrMaximumTimes)
+	require.Equal(t, 12, ExitCode(wrapped))
+
+	require.Equal(t, 14, ExitCode(errors.Wrap(errors.Wrap(container.ErrNotFound, "inspect container"), "stage inspect")))
+	require.Equal(t, 15, ExitCode(errors.Wrap(ErrTargetExists, "localhost:5000/app:v1")))
+	require.Equal(t, 19, ExitCode(errors.Wrap(errors.Wrap(ErrSecretsFound, "check secrets"), "commit upper")))
+
+	// The authentication failure while pushing is in both classes.
+	status := remoteserrors.ErrUnexpectedStatus{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}
+	err = classify(withClass(errors.Wrap(status, "push manifest"), ErrPush))
+	require.ErrorIs(t, err, ErrPush)
+	require.ErrorIs(t, err, ErrAuth)
+	require.Equal(t, 10, ExitCode(err))
+
+	err = classify(withClass(errors.Wrap(remoteserrors.ErrUnexpectedStatus{StatusCode: http.StatusBadGateway}, "push blob"), ErrPush))
+	require.NotErrorIs(t, err, ErrAuth)
+	require.Equal(t, 17, ExitCode(err))
+
+	// The failures caused by the abort on time budget exceeded.
+	err = withClass(errors.Wrap(withClass(errors.Wrap(context.DeadlineExceeded, "push blob"), ErrPush), "exceeded time budget 10m0s"), ErrTimeBudget)
+	require.ErrorIs(t, err, ErrPush)
+	require.Equal(t, 18, ExitCode(err))
+
+	require.ErrorIs(t, classify(fmt.Errorf("resolve: %w", docker.ErrInvalidAuthorization)), ErrAuth)
+	require.Nil(t, classify(nil))
+}
@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/harbor"
+)
+
+// harborClient returns the client of Harbor at `host`, requested as the
+// registry with its credentials.
+func (wf *Workflow) harborClient(host string) (*harbor.Client, error) {
+	hosts, err := wf.hostsFunc(false)(host)
+	if err != nil {
+		return nil, errors.Wrap(err, "get registry hosts")
+	}
+	for _, registryHost := range hosts {
+		if registryHost.Host != host {
+			continue
+		}
+		username, password, err := wf.credFunc(host)
+		if err != nil {
+			return nil, errors.Wrap(err, "get credentials")
+		}
+		return harbor.NewClient(registryHost.Client, registryHost.Scheme, host, username, password), nil
+	}
+	return nil, fmt.Errorf("no registry host %s", host)
+}
+
+// checkHarbor checks the targets in the registries configured as Harbor,
+// the projects not existing are created if configured and the credentials
+// must be permitted to push the target repositories.
+func (wf *Workflow) checkHarbor(ctx context.Context, state *CommitState) error {
+	refs := append([]string{}, state.NydusTargetRefs...)
+	for _, target := range state.Option.targets(FormatOCI) {
+		refs = append(refs, target.Ref)
+	}
+
+	checked := map[string]bool{}
+	for _, ref := range refs {
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return errors.Wrapf(err, "parse target %s", ref)
+		}
+		host, repository := reference.Domain(named), reference.Path(named)
+		registry := wf.cfg.Registries[host]
+		if !registry.Harbor.Enabled || checked[named.Name()] {
+			continue
+		}
+		checked[named.Name()] = true
+
+		client, err := wf.harborClient(host)
+		if err != nil {
+			return errors.Wrapf(err, "check target %s in harbor", ref)
+		}
+		project, _, _ := strings.Cut(repository, "/")
+		created, err := client.EnsureProject(ctx, project, registry.Harbor.CreateProject, registry.Harbor.Public)
+		if errors.Is(err, harbor.ErrProjectNotFound) {
+			return errors.Wrapf(err, "create it or set registries.%s.harbor.create_project", host)
+		} else if err != nil {
+			return classifyHarbor(errors.Wrapf(err, "check target %s in harbor", ref))
+		}
+		if created {
+			logrus.Infof("created project %s in harbor %s", project, host)
+		}
+		if err := client.CheckPush(ctx, repository); err != nil {
+			return classifyHarbor(errors.Wrapf(err, "check target %s in harbor", ref))
+		}
+	}
+	return nil
+}
+
+// classifyHarbor puts the permission failures of Harbor in ErrAuth.
+func classifyHarbor(err error) error {
+	if errors.Is(err, harbor.ErrPermissionDenied) {
+		return withClass(err, ErrAuth)
+	}
+	return err
+}
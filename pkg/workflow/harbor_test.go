@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestCheckHarbor(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v2.0/projects/apps":
+			fmt.Fprint(w, `{"project_id":1}`)
+		case r.URL.Path == "/v2/":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/service/token",service="harbor-registry"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/service/token":
+			// The robot is only permitted to pull.
+			payload, _ := json.Marshal(map[string]interface{}{"access": []map[string]interface{}{
+				{"type": "repository", "name": "apps/web", "actions": []string{"pull"}},
+			}})
+			json.NewEncoder(w).Encode(map[string]string{"token": "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	wf := &Workflow{
+		cfg: &config.Config{
+			Distribution: config.Distribution{Username: "robot$apps+ci", Password: "secret"},
+			Registries:   map[string]config.Registry{host: {Harbor: config.Harbor{Enabled: true}}},
+		},
+		// The certificate of test server is verified by its CA.
+		tlsConfigs: map[string]*tls.Config{host: server.Client().Transport.(*http.Transport).TLSClientConfig},
+	}
+	state := &CommitState{
+		Option: CommitOption{Targets: []Target{
+			{Ref: "localhost:5000/apps/web:v1", Format: FormatOCI},
+		}},
+	}
+	// Registries not configured as Harbor are not checked.
+	require.NoError(t, wf.checkHarbor(context.Background(), state))
+
+	state.NydusTargetRefs = []string{host + "/apps/web:v1_nydus_v2"}
+	err := wf.checkHarbor(context.Background(), state)
+	require.True(t, errors.Is(err, ErrAuth))
+	require.Contains(t, err.Error(), "robot$apps+ci is not permitted to push")
+
+	state.NydusTargetRefs = []string{host + "/missing/web:v1_nydus_v2"}
+	err = wf.checkHarbor(context.Background(), state)
+	require.Contains(t, err.Error(), fmt.Sprintf("set registries.%s.harbor.create_project", host))
+}
@@ -0,0 +1,177 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+const (
+	// mediaTypeCommitHistory is the artifact type of the referrer artifact
+	// holding the full commit history.
+	mediaTypeCommitHistory = "application/vnd.nydus.cli.commit-history.v1+json"
+	// mediaTypeCommitRecords is the blob of full commit records.
+	mediaTypeCommitRecords = "application/vnd.nydus.cli.commit-history.records.v1+json"
+)
+
+// annotationWarnRatio is the ratio of limit from which an annotation is
+// warned to be approaching the limit.
+const annotationWarnRatio = 0.8
+
+// CommitRecord is a commit in the chain of committed image.
+type CommitRecord struct {
+	// Time of commit, zero if unknown, e.g. the commit before the history
+	// is recorded.
+	Time time.Time `json:"time"`
+	// Blobs committed, the mount blobs followed by the upper blob.
+	Blobs []digest.Digest `json:"blobs"`
+}
+
+// CommitHistory is the value of commit history annotation in json. It's
+// bounded by the annotation size limit, the oldest records are dropped
+// from annotation once over limit, and the full records are kept in a
+// blob referenced by `Full` instead.
+type CommitHistory struct {
+	// Total is the count of commits in the chain, including the ones only
+	// in full records.
+	Total int `json:"total"`
+	// Records are the latest commits, oldest first.
+	Records []CommitRecord `json:"records"`
+	// Full is the blob of all records in json, set only if Records are
+	// truncated. The blob is in the repository of image, and referenced
+	// by the commit history artifact whose subject is the image manifest.
+	Full *ocispec.Descriptor `json:"full,omitempty"`
+}
+
+// parseCommitHistory returns the commit history in annotations of
+// bootstrap layer, or nil if not committed. The history is derived from
+// commit blobs annotation if the image is committed before the history
+// is recorded, or without it by the profile of annotation `keys`.
+func parseCommitHistory(annotations map[string]string, keys config.AnnotationKeys) (*CommitHistory, error) {
+	if value, ok := annotations[keys.CommitHistory]; ok && keys.CommitHistory != "" {
+		history := CommitHistory{}
+		if err := json.Unmarshal([]byte(value), &history); err != nil {
+			return nil, errors.Wrap(err, "unmarshal commit history")
+		}
+		return &history, nil
+	}
+	value := annotations[keys.CommitBlobs]
+	if value == "" {
+		return nil, nil
+	}
+	record := CommitRecord{}
+	for _, blob := range strings.Split(value, ",") {
+		record.Blobs = append(record.Blobs, digest.Digest(blob))
+	}
+	return &CommitHistory{Total: 1, Records: []CommitRecord{record}}, nil
+}
+
+// pullCommitRecords returns all records of commit history in image `ref`,
+// the full records are pulled if truncated.
+func (wf *Workflow) pullCommitRecords(ctx context.Context, ref string, history *CommitHistory) ([]CommitRecord, error) {
+	if history == nil {
+		return nil, nil
+	}
+	if history.Full == nil {
+		return history.Records, nil
+	}
+
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	reader, err := remoter.Pull(ctx, *history.Full, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull full commit history")
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, history.Full.Size))
+	if err != nil {
+		return nil, errors.Wrap(err, "read full commit history")
+	}
+	if dgst := digest.FromBytes(data); dgst != history.Full.Digest {
+		return nil, fmt.Errorf("full commit history digest mismatch: %s != %s", dgst, history.Full.Digest)
+	}
+	records := []CommitRecord{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, errors.Wrap(err, "unmarshal full commit history")
+	}
+	return records, nil
+}
+
+// boundCommitHistory returns the annotation value of `records` within
+// `limit` bytes of annotation `key` and value. If all records don't fit, the returned
+// records blob should be pushed with the image, and only the latest
+// records which fit are kept in annotation.
+func boundCommitHistory(records []CommitRecord, key string, limit int) (string, []byte, error) {
+	history := CommitHistory{Total: len(records), Records: records}
+	value, err := json.Marshal(history)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "marshal commit history")
+	}
+	if len(key)+len(value) <= limit {
+		return string(value), nil, nil
+	}
+
+	full, err := json.Marshal(records)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "marshal full commit history")
+	}
+	history.Full = &ocispec.Descriptor{
+		MediaType: mediaTypeCommitRecords,
+		Digest:    digest.FromBytes(full),
+		Size:      int64(len(full)),
+	}
+	// Sizes are counted in bytes of encoded json rather than characters,
+	// the records are dropped as a whole, so it's never cut in the middle
+	// of a multi-byte character.
+	for kept := len(records); kept >= 0; kept-- {
+		history.Records = records[len(records)-kept:]
+		value, err = json.Marshal(history)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "marshal commit history")
+		}
+		if len(key)+len(value) <= limit {
+			return string(value), full, nil
+		}
+	}
+	return "", nil, fmt.Errorf("commit history summary exceeds annotation limit %d", limit)
+}
+
+// warnAnnotations warns the annotations approaching or over `limit`, which
+// may be refused by registries or snapshotter.
+func warnAnnotations(annotations map[string]string, limit int) {
+	for key, value := range annotations {
+		size := len(key) + len(value)
+		if size > limit {
+			logrus.Warnf("annotation %s is %d bytes, over the limit %d bytes", key, size, limit)
+		} else if float64(size) > float64(limit)*annotationWarnRatio {
+			logrus.Warnf("annotation %s is %d bytes, approaching the limit %d bytes", key, size, limit)
+		}
+	}
+}
+
+// pushCommitHistory pushes the full commit records to `remoter`, then
+// pushes the commit history artifact referring to `subject`, which is
+// discoverable by referrers API of registry or its fallback tag.
+func (wf *Workflow) pushCommitHistory(ctx context.Context, remoter *remote.Remote, full []byte, subject ocispec.Descriptor) error {
+	manifestDesc, err := wf.pushArtifact(
+		ctx, remoter, mediaTypeCommitHistory, ocispec.Descriptor{MediaType: mediaTypeCommitRecords}, full, subject, nil,
+	)
+	if err != nil {
+		return errors.Wrap(err, "push commit history")
+	}
+	logrus.Infof("pushed commit history artifact %s referring to %s", manifestDesc.Digest, subject.Digest)
+	return nil
+}
@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+)
+
+func TestParseCommitHistory(t *testing.T) {
+	keys, err := (&config.Annotations{}).ResolvedKeys()
+	require.NoError(t, err)
+
+	history, err := parseCommitHistory(map[string]string{}, keys)
+	require.NoError(t, err)
+	require.Nil(t, history)
+
+	upper := digest.FromString("upper")
+	mount := digest.FromString("mount")
+	history, err = parseCommitHistory(map[string]string{
+		config.AnnotationCommitBlobs: fmt.Sprintf("%s,%s", mount, upper),
+	}, keys)
+	require.NoError(t, err)
+	require.Equal(t, &CommitHistory{
+		Total:   1,
+		Records: []CommitRecord{{Blobs: []digest.Digest{mount, upper}}},
+	}, history)
+
+	_, err = parseCommitHistory(map[string]string{config.AnnotationCommitHistory: "{"}, keys)
+	require.Error(t, err)
+
+	// The history isn't written by nydusify profile.
+	keys, err = (&config.Annotations{Profile: config.AnnotationProfileNydusify}).ResolvedKeys()
+	require.NoError(t, err)
+	history, err = parseCommitHistory(map[string]string{
+		config.AnnotationCommitBlobs:   upper.String(),
+		config.AnnotationCommitHistory: "{",
+	}, keys)
+	require.NoError(t, err)
+	require.Equal(t, 1, history.Total)
+}
+
+func TestBoundCommitHistory(t *testing.T) {
+	keys, err := (&config.Annotations{}).ResolvedKeys()
+	require.NoError(t, err)
+
+	records := []CommitRecord{}
+	for idx := 0; idx < 64; idx++ {
+		records = append(records, CommitRecord{
+			Time:  time.Unix(int64(idx), 0).UTC(),
+			Blobs: []digest.Digest{digest.FromString(fmt.Sprint(idx))},
+		})
+	}
+
+	value, full, err := boundCommitHistory(records[:2], config.AnnotationCommitHistory, 4096)
+	require.NoError(t, err)
+	require.Nil(t, full)
+	history, err := parseCommitHistory(map[string]string{config.AnnotationCommitHistory: value}, keys)
+	require.NoError(t, err)
+	require.Equal(t, 2, history.Total)
+	require.Equal(t, records[:2], history.Records)
+	require.Nil(t, history.Full)
+
+	value, full, err = boundCommitHistory(records, config.AnnotationCommitHistory, 4096)
+	require.NoError(t, err)
+	require.LessOrEqual(t, len(config.AnnotationCommitHistory)+len(value), 4096)
+	history, err = parseCommitHistory(map[string]string{config.AnnotationCommitHistory: value}, keys)
+	require.NoError(t, err)
+	require.Equal(t, 64, history.Total)
+	require.NotEmpty(t, history.Records)
+	require.Less(t, len(history.Records), 64)
+	// The latest records are kept.
+	require.Equal(t, records[64-len(history.Records):], history.Records)
+	require.Equal(t, digest.FromBytes(full), history.Full.Digest)
+	require.Equal(t, int64(len(full)), history.Full.Size)
+	fullRecords := []CommitRecord{}
+	require.NoError(t, json.Unmarshal(full, &fullRecords))
+	require.Equal(t, records, fullRecords)
+
+	_, _, err = boundCommitHistory(records, config.AnnotationCommitHistory, 64)
+	require.Error(t, err)
+}
@@ -0,0 +1,138 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/images"
+	"github.com/docker/distribution/reference"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// updateIndex returns `index` in which the entry of manifest `baseDesc` is
+// replaced by the committed manifest `manifestDesc`, the platform and
+// annotations of entry and the other entries are kept as is.
+func updateIndex(index ocispec.Index, baseDesc, manifestDesc ocispec.Descriptor) (ocispec.Index, error) {
+	updated := index
+	updated.Manifests = append([]ocispec.Descriptor{}, index.Manifests...)
+	for idx, entry := range updated.Manifests {
+		if entry.Digest != baseDesc.Digest {
+			continue
+		}
+		entry.MediaType = manifestDesc.MediaType
+		entry.Digest = manifestDesc.Digest
+		entry.Size = manifestDesc.Size
+		updated.Manifests[idx] = entry
+		return updated, nil
+	}
+	return ocispec.Index{}, fmt.Errorf("not found base manifest %s in index", baseDesc.Digest)
+}
+
+// pushIndex pushes the index of base image to `targetRef`, in which the
+// entry of base manifest is replaced by the committed manifest pushed by
+// digest. The manifests of other platforms are copied along with their
+// configs and layers in case the target is in another repository.
+func (wf *Workflow) pushIndex(ctx context.Context, state *CommitState, manifestDesc ocispec.Descriptor, targetRef string, byDigest bool) (*ocispec.Descriptor, error) {
+	index, err := updateIndex(*state.BaseIndex, state.Base.Desc, manifestDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := wf.newRemote(targetRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "create target remote")
+	}
+	sameRepo, err := isSameRepository(state.BaseRef, targetRef)
+	if err != nil {
+		return nil, err
+	}
+	if !sameRepo {
+		source, err := wf.newRemote(state.BaseRef)
+		if err != nil {
+			return nil, errors.Wrap(err, "create source remote")
+		}
+		for _, entry := range index.Manifests {
+			if entry.Digest == manifestDesc.Digest {
+				continue
+			}
+			if err := copyManifest(ctx, source, target, entry); err != nil {
+				return nil, errors.Wrapf(err, "copy manifest %s of other platform", entry.Digest)
+			}
+		}
+	}
+
+	mediaType := index.MediaType
+	if mediaType == "" {
+		mediaType = ocispec.MediaTypeImageIndex
+	}
+	indexBytes, indexDesc, err := wf.makeDesc(ctx, index, ocispec.Descriptor{MediaType: mediaType})
+	if err != nil {
+		return nil, errors.Wrap(err, "make index desc")
+	}
+	if err := pushWithHTTPFallback(ctx, target, *indexDesc, byDigest, func() (io.Reader, error) {
+		return bytes.NewReader(indexBytes), nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "push image index")
+	}
+	logrus.Infof("pushed image index of %d platforms to %s", len(index.Manifests), targetRef)
+
+	return indexDesc, nil
+}
+
+// copyManifest copies the image manifest `desc` with its config and
+// layers from `source` to `target`, the existing ones are skipped by
+// push.
+func copyManifest(ctx context.Context, source, target *remote.Remote, desc ocispec.Descriptor) error {
+	reader, err := source.Pull(ctx, desc, true)
+	if err != nil {
+		return errors.Wrap(err, "pull manifest")
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, desc.Size))
+	reader.Close()
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest, images.MediaTypeDockerSchema2Manifest:
+		manifest := ocispec.Manifest{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return errors.Wrap(err, "unmarshal manifest")
+		}
+		for _, blob := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+			blob := blob
+			if err := pushWithHTTPFallback(ctx, target, blob, true, func() (io.Reader, error) {
+				return source.Pull(ctx, blob, true)
+			}); err != nil {
+				return errors.Wrapf(err, "copy blob %s", blob.Digest)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported media type %s", desc.MediaType)
+	}
+
+	return pushWithHTTPFallback(ctx, target, desc, true, func() (io.Reader, error) {
+		return bytes.NewReader(data), nil
+	})
+}
+
+// isSameRepository checks whether images `a` and `b` are in the same
+// repository.
+func isSameRepository(a, b string) (bool, error) {
+	namedA, err := reference.ParseNormalizedNamed(a)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid image reference: %s", a)
+	}
+	namedB, err := reference.ParseNormalizedNamed(b)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid image reference: %s", b)
+	}
+	return namedA.Name() == namedB.Name(), nil
+}
@@ -0,0 +1,58 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateIndex(t *testing.T) {
+	oci := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("oci"),
+		Size:      3,
+		Platform:  &ocispec.Platform{OS: "linux", Architecture: "amd64"},
+	}
+	nydus := ocispec.Descriptor{
+		MediaType:   ocispec.MediaTypeImageManifest,
+		Digest:      digest.FromString("nydus"),
+		Size:        5,
+		Platform:    &ocispec.Platform{OS: "linux", Architecture: "amd64", OSFeatures: []string{"nydus.remoteimage.v1"}},
+		Annotations: map[string]string{"key": "value"},
+	}
+	index := ocispec.Index{
+		MediaType:   ocispec.MediaTypeImageIndex,
+		Manifests:   []ocispec.Descriptor{oci, nydus},
+		Annotations: map[string]string{"org.opencontainers.image.source": "example"},
+	}
+	committed := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromString("committed"),
+		Size:      9,
+	}
+
+	updated, err := updateIndex(index, nydus, committed)
+	require.NoError(t, err)
+	require.Equal(t, index.Annotations, updated.Annotations)
+	require.Equal(t, oci, updated.Manifests[0])
+	require.Equal(t, committed.Digest, updated.Manifests[1].Digest)
+	require.Equal(t, committed.Size, updated.Manifests[1].Size)
+	require.Equal(t, nydus.Platform, updated.Manifests[1].Platform)
+	require.Equal(t, nydus.Annotations, updated.Manifests[1].Annotations)
+	// The base index is kept as is.
+	require.Equal(t, nydus.Digest, index.Manifests[1].Digest)
+
+	_, err = updateIndex(index, committed, committed)
+	require.Error(t, err)
+}
+
+func TestIsSameRepository(t *testing.T) {
+	same, err := isSameRepository("nginx:latest_nydus_v2", "docker.io/library/nginx:committed")
+	require.NoError(t, err)
+	require.True(t, same)
+	same, err = isSameRepository("nginx:latest_nydus_v2", "localhost:5000/nginx:committed")
+	require.NoError(t, err)
+	require.False(t, same)
+}
@@ -0,0 +1,287 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/layout"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+type SaveOption struct {
+	// Ref is the nydus image saved, which also names the image in layout.
+	Ref string
+	// Output is the dir of OCI image layout, the existing layout gets one
+	// more image.
+	Output string
+}
+
+type LoadOption struct {
+	// Input is the dir of OCI image layout.
+	Input string
+	// Name is the reference the image is saved by, it's optional if there
+	// is only one image in layout.
+	Name string
+	// Target is the reference the image is pushed to.
+	Target string
+}
+
+// Save writes nydus image `opt.Ref` into an OCI image layout, with the
+// manifest, config and layers as is, the blobs in external backend, the
+// full commit history and blob ids, so it's loaded into a registry by Load
+// later, e.g. for air-gapped transfer.
+func (wf *Workflow) Save(ctx context.Context, opt SaveOption) error {
+	remoter, err := wf.newRemote(opt.Ref)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return classify(errors.Wrap(err, "parse nydus image"))
+	}
+	if parsed.NydusImage == nil {
+		return withClass(fmt.Errorf("not a nydus image: %s", opt.Ref), ErrNotNydusImage)
+	}
+	image := parsed.NydusImage
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&image.Manifest)
+	if bootstrapDesc == nil {
+		return fmt.Errorf("not found nydus bootstrap layer")
+	}
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return err
+	}
+	history, err := parseCommitHistory(bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return errors.Wrap(err, "parse commit history")
+	}
+	_, fullBlobIDs, err := parseBlobIDs(bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return err
+	}
+
+	out, err := layout.Create(opt.Output)
+	if err != nil {
+		return errors.Wrap(err, "create image layout")
+	}
+	descs := append([]ocispec.Descriptor{image.Desc, image.Manifest.Config}, image.Manifest.Layers...)
+	if history != nil && history.Full != nil {
+		descs = append(descs, *history.Full)
+	}
+	if fullBlobIDs != nil {
+		descs = append(descs, *fullBlobIDs)
+	}
+	for _, desc := range descs {
+		if err := wf.saveBlob(out, desc, func() (io.ReadCloser, error) {
+			return remoter.Pull(ctx, desc, true)
+		}); err != nil {
+			return classify(err)
+		}
+	}
+
+	ids, err := readBlobIDs(bootstrapDesc.Annotations, keys, out.ReadBlob)
+	if err != nil {
+		return err
+	}
+	external, err := externalBlobs(&image.Manifest, ids)
+	if err != nil {
+		return err
+	}
+	if len(external) > 0 {
+		externalType := wf.cfg.ExternalBackendType()
+		if externalType == "" {
+			return fmt.Errorf("blobs of %s are in external backend, but it's not configured", opt.Ref)
+		}
+		be, err := wf.backendOfType(opt.Ref, externalType)
+		if err != nil {
+			return err
+		}
+		for _, blobDigest := range external {
+			blobDigest := blobDigest
+			if err := wf.saveBlob(out, ocispec.Descriptor{Digest: blobDigest, Size: -1}, func() (io.ReadCloser, error) {
+				return be.Pull(blobDigest)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := out.AddManifest(image.Desc, opt.Ref); err != nil {
+		return errors.Wrap(err, "add manifest to index")
+	}
+	logrus.Infof("saved %s into %s, manifest: %s, external blobs: %d", opt.Ref, opt.Output, image.Desc.Digest, len(external))
+	return nil
+}
+
+// saveBlob writes blob `desc` opened by `open` into layout `out`, which is
+// skipped if the blob exists in layout.
+func (wf *Workflow) saveBlob(out *layout.Layout, desc ocispec.Descriptor, open func() (io.ReadCloser, error)) error {
+	if _, err := os.Stat(out.BlobPath(desc.Digest)); err == nil {
+		logrus.Debugf("blob %s exists in layout", desc.Digest)
+		return nil
+	}
+	reader, err := open()
+	if err != nil {
+		return errors.Wrapf(err, "pull blob %s", desc.Digest)
+	}
+	defer reader.Close()
+
+	reporter := wf.newProgress("save "+desc.Digest.Encoded()[:12], desc.Size)
+	defer reporter.Finish()
+	if err := out.WriteBlob(desc.Digest, desc.Size, io.TeeReader(reader, reporter)); err != nil {
+		return errors.Wrapf(err, "save blob %s", desc.Digest)
+	}
+	return nil
+}
+
+// Load pushes the nydus image saved in OCI image layout by Save to
+// `opt.Target`. The blobs in external backend are pushed to the external
+// backend of config, which is required if there are such blobs.
+func (wf *Workflow) Load(ctx context.Context, opt LoadOption) error {
+	in, err := layout.Open(opt.Input)
+	if err != nil {
+		return errors.Wrap(err, "open image layout")
+	}
+	manifestDesc, err := in.FindManifest(opt.Name)
+	if err != nil {
+		return err
+	}
+	manifestBytes, err := in.ReadBlob(*manifestDesc)
+	if err != nil {
+		return errors.Wrap(err, "read manifest")
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return errors.Wrap(err, "unmarshal manifest")
+	}
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&manifest)
+	if bootstrapDesc == nil {
+		return withClass(fmt.Errorf("not a nydus image: %s", manifestDesc.Digest), ErrNotNydusImage)
+	}
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return err
+	}
+	history, err := parseCommitHistory(bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return errors.Wrap(err, "parse commit history")
+	}
+
+	ids, err := readBlobIDs(bootstrapDesc.Annotations, keys, in.ReadBlob)
+	if err != nil {
+		return err
+	}
+	external, err := externalBlobs(&manifest, ids)
+	if err != nil {
+		return err
+	}
+	if len(external) > 0 {
+		externalType := wf.cfg.ExternalBackendType()
+		if externalType == "" {
+			return fmt.Errorf("image has %d blobs in external backend, but it's not configured", len(external))
+		}
+		be, err := wf.backendOfType(opt.Target, externalType)
+		if err != nil {
+			return err
+		}
+		for _, blobDigest := range external {
+			size, err := in.VerifyBlob(blobDigest)
+			if err != nil {
+				return err
+			}
+			ra, err := local.OpenReader(in.BlobPath(blobDigest))
+			if err != nil {
+				return errors.Wrapf(err, "open blob %s", blobDigest)
+			}
+			reporter := wf.newProgress("load "+blobDigest.Encoded()[:12], size)
+			err = be.Push(ctx, &progressReaderAt{ReaderAt: ra, reporter: reporter}, *blobDesc(blobDigest, size))
+			reporter.Finish()
+			ra.Close()
+			if err != nil {
+				return withClass(errors.Wrapf(err, "push blob %s", blobDigest), ErrPush)
+			}
+		}
+	}
+
+	remoter, err := wf.newRemote(opt.Target)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := wf.loadBlob(ctx, in, remoter.Push, desc); err != nil {
+			return withClass(classify(err), ErrPush)
+		}
+	}
+	if err := remoter.Push(ctx, *manifestDesc, false, bytes.NewReader(manifestBytes)); err != nil {
+		return withClass(classify(errors.Wrap(err, "push manifest")), ErrPush)
+	}
+	if history != nil && history.Full != nil {
+		full, err := in.ReadBlob(*history.Full)
+		if err != nil {
+			return errors.Wrap(err, "read full commit history")
+		}
+		if err := wf.pushCommitHistory(ctx, remoter, full, *manifestDesc); err != nil {
+			return withClass(classify(err), ErrPush)
+		}
+	}
+	if _, fullBlobIDs, err := parseBlobIDs(bootstrapDesc.Annotations, keys); err != nil {
+		return err
+	} else if fullBlobIDs != nil {
+		full, err := in.ReadBlob(*fullBlobIDs)
+		if err != nil {
+			return errors.Wrap(err, "read full blob ids")
+		}
+		if err := wf.pushBlobIDs(ctx, remoter, full, *manifestDesc); err != nil {
+			return withClass(classify(err), ErrPush)
+		}
+	}
+	logrus.Infof("loaded %s from %s, manifest: %s, external blobs: %d", opt.Target, opt.Input, manifestDesc.Digest, len(external))
+	return nil
+}
+
+// loadBlob pushes blob `desc` in layout `in` by `push`.
+func (wf *Workflow) loadBlob(
+	ctx context.Context, in *layout.Layout, push func(context.Context, ocispec.Descriptor, bool, io.Reader) error, desc ocispec.Descriptor,
+) error {
+	file, err := os.Open(in.BlobPath(desc.Digest))
+	if err != nil {
+		return errors.Wrapf(err, "open blob %s", desc.Digest)
+	}
+	defer file.Close()
+	reporter := wf.newProgress("load "+desc.Digest.Encoded()[:12], desc.Size)
+	defer reporter.Finish()
+	if err := push(ctx, desc, true, io.TeeReader(file, reporter)); err != nil {
+		return errors.Wrapf(err, "push blob %s", desc.Digest)
+	}
+	return nil
+}
+
+// externalBlobs returns the blobs in external backend, i.e. the blob `ids`
+// of bootstrap layer not referenced by the layers of `manifest`.
+func externalBlobs(manifest *ocispec.Manifest, ids []string) ([]digest.Digest, error) {
+	blobs, err := nydusBlobs(manifest, ids)
+	if err != nil {
+		return nil, err
+	}
+	external := []digest.Digest{}
+	for _, blob := range blobs {
+		if blob.Size < 0 {
+			external = append(external, blob.Digest)
+		}
+	}
+	return external, nil
+}
@@ -0,0 +1,67 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaximumTimesPolicy is how the commits of base image are counted against
+// the maximum times.
+type MaximumTimesPolicy string
+
+const (
+	// MaximumTimesLayers counts the committed blobs in the commit blobs
+	// annotation of base image, including the mount blobs.
+	MaximumTimesLayers MaximumTimesPolicy = "layers"
+	// MaximumTimesChain counts the commits in the chain of base image by
+	// the commit history.
+	MaximumTimesChain MaximumTimesPolicy = "chain"
+	// MaximumTimesDaily counts the commits in the chain of base image made
+	// in the current UTC day, the commits of unknown time are not counted.
+	MaximumTimesDaily MaximumTimesPolicy = "daily"
+	// MaximumTimesDisabled never limits the commits.
+	MaximumTimesDisabled MaximumTimesPolicy = "disabled"
+)
+
+// ParseMaximumTimesPolicy parses `policy`, the empty policy is
+// MaximumTimesLayers.
+func ParseMaximumTimesPolicy(policy string) (MaximumTimesPolicy, error) {
+	switch MaximumTimesPolicy(policy) {
+	case "":
+		return MaximumTimesLayers, nil
+	case MaximumTimesLayers, MaximumTimesChain, MaximumTimesDaily, MaximumTimesDisabled:
+		return MaximumTimesPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid maximum times policy %s, must be one of layers, chain, daily, disabled", policy)
+	}
+}
+
+// checkMaximumTimes fails with ErrMaximumTimes if the commits of base
+// image counted by `policy` reach `maximum`. `committedLayers` and
+// `history` are of base image, `now` decides the day of daily policy.
+func checkMaximumTimes(policy MaximumTimesPolicy, maximum, committedLayers int, history []CommitRecord, now time.Time) error {
+	var count int
+	switch policy {
+	case MaximumTimesDisabled:
+		return nil
+	case MaximumTimesChain:
+		count = len(history)
+	case MaximumTimesDaily:
+		year, month, day := now.UTC().Date()
+		for _, record := range history {
+			if y, m, d := record.Time.UTC().Date(); !record.Time.IsZero() && y == year && m == month && d == day {
+				count++
+			}
+		}
+		if count >= maximum {
+			return withClass(fmt.Errorf("reached maximum committed times %d of the day", maximum), ErrMaximumTimes)
+		}
+		return nil
+	default:
+		count = committedLayers
+	}
+	if count >= maximum {
+		return withClass(fmt.Errorf("reached maximum committed times %d", maximum), ErrMaximumTimes)
+	}
+	return nil
+}
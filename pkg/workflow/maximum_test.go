@@ -0,0 +1,46 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaximumTimesPolicy(t *testing.T) {
+	policy, err := ParseMaximumTimesPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, MaximumTimesLayers, policy)
+
+	policy, err = ParseMaximumTimesPolicy("daily")
+	require.NoError(t, err)
+	require.Equal(t, MaximumTimesDaily, policy)
+
+	_, err = ParseMaximumTimesPolicy("weekly")
+	require.EqualError(t, err, "invalid maximum times policy weekly, must be one of layers, chain, daily, disabled")
+}
+
+func TestCheckMaximumTimes(t *testing.T) {
+	now := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	history := []CommitRecord{
+		{},
+		{Time: now.Add(-24 * time.Hour)},
+		{Time: now.Add(-7 * time.Hour)},
+		{Time: now.Add(-time.Hour)},
+	}
+
+	require.NoError(t, checkMaximumTimes(MaximumTimesLayers, 10, 9, history, now))
+	require.ErrorIs(t, checkMaximumTimes(MaximumTimesLayers, 10, 10, history, now), ErrMaximumTimes)
+	require.ErrorIs(t, checkMaximumTimes("", 10, 10, history, now), ErrMaximumTimes)
+
+	require.NoError(t, checkMaximumTimes(MaximumTimesChain, 5, 10, history, now))
+	require.ErrorIs(t, checkMaximumTimes(MaximumTimesChain, 4, 0, history, now), ErrMaximumTimes)
+
+	// Only the commits since 00:00 UTC are counted.
+	require.NoError(t, checkMaximumTimes(MaximumTimesDaily, 3, 10, history, now))
+	err := checkMaximumTimes(MaximumTimesDaily, 2, 0, history, now)
+	require.ErrorIs(t, err, ErrMaximumTimes)
+	require.EqualError(t, err, "reached maximum committed times 2 of the day")
+
+	require.NoError(t, checkMaximumTimes(MaximumTimesDisabled, 0, 10, history, now))
+}
@@ -0,0 +1,268 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+)
+
+// MediaTypeCommitMetadata is the artifact type of the referrer artifact
+// holding CommitMetadata, and the media type of its only layer.
+const MediaTypeCommitMetadata = "application/vnd.nydus.cli.commit-metadata.v1+json"
+
+// maxMetadataSize bounds the metadata pulled from registry.
+const maxMetadataSize = 1 << 20
+
+// CommitMetadata describes how an image is committed, it's pushed as an
+// artifact referring to each pushed manifest if CommitOption.Metadata is
+// set.
+type CommitMetadata struct {
+	// Container is the committed container, e.g. "docker://<id>".
+	Container string `json:"container"`
+	// Image is the image of container.
+	Image string `json:"image,omitempty"`
+	// BaseRef is the nydus base image committed on, empty if converted on
+	// the fly.
+	BaseRef    string          `json:"base_ref,omitempty"`
+	BaseDigest digest.Digest   `json:"base_digest,omitempty"`
+	Options    MetadataOptions `json:"options"`
+	// Layers are the committed blobs, the upper blob followed by the mount
+	// blobs.
+	Layers []LayerMetadata `json:"layers"`
+	// Total sums the stats of layers.
+	Total      tarstream.Stats `json:"total"`
+	Version    string          `json:"version"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+}
+
+// MetadataOptions are the options of commit affecting the committed
+// image.
+type MetadataOptions struct {
+	Targets            []string   `json:"targets"`
+	WithPaths          []string   `json:"with_paths,omitempty"`
+	WithoutPaths       []string   `json:"without_paths,omitempty"`
+	Sidecars           []string   `json:"sidecars,omitempty"`
+	PauseContainer     bool       `json:"pause_container,omitempty"`
+	PauseMode          string     `json:"pause_mode,omitempty"`
+	MaximumTimes       int        `json:"maximum_times,omitempty"`
+	MaximumTimesPolicy string     `json:"maximum_times_policy,omitempty"`
+	SourceDateEpoch    *time.Time `json:"source_date_epoch,omitempty"`
+	SpecialFiles       string     `json:"special_files,omitempty"`
+	MountStrategy      string     `json:"mount_strategy,omitempty"`
+	ChunkDict          string     `json:"chunk_dict,omitempty"`
+	ConvertBase        bool       `json:"convert_base,omitempty"`
+}
+
+// LayerMetadata is a committed blob with the stats of its files.
+type LayerMetadata struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+	// Size of blob.
+	Size  int64           `json:"size"`
+	Stats tarstream.Stats `json:"stats"`
+}
+
+// commitMetadata returns the metadata of commit in `state`.
+func commitMetadata(state *CommitState) CommitMetadata {
+	opt := state.Option
+	metadata := CommitMetadata{
+		Container: opt.ContainerIDWithType,
+		BaseRef:   state.BaseRef,
+		Options: MetadataOptions{
+			Targets:            append(append([]string{}, state.NydusTargetRefs...), targetRefs(opt.targets(FormatOCI))...),
+			WithPaths:          opt.WithPaths,
+			WithoutPaths:       opt.WithoutPaths,
+			PauseContainer:     opt.PauseContainer,
+			MaximumTimes:       opt.MaximumTimes,
+			MaximumTimesPolicy: string(opt.MaximumTimesPolicy),
+			SourceDateEpoch:    opt.SourceDateEpoch,
+			SpecialFiles:       string(opt.SpecialFiles),
+			MountStrategy:      string(opt.MountStrategy),
+			ChunkDict:          opt.ChunkDict,
+			ConvertBase:        opt.ConvertBase,
+		},
+		Layers:     []LayerMetadata{},
+		Version:    opt.Version,
+		StartedAt:  state.StartedAt,
+		FinishedAt: time.Now(),
+	}
+	if opt.PauseContainer {
+		metadata.Options.PauseMode = string(opt.PauseMode)
+	}
+	for _, sidecar := range opt.Sidecars {
+		metadata.Options.Sidecars = append(metadata.Options.Sidecars, sidecar.Name)
+	}
+	if state.Inspect != nil {
+		metadata.Image = state.Inspect.Image
+	}
+	if state.Base != nil {
+		metadata.BaseDigest = state.Base.Desc.Digest
+	}
+
+	blobs := state.MountBlobs
+	if state.UpperBlob != nil {
+		blobs = append([]Blob{*state.UpperBlob}, blobs...)
+	}
+	for _, blob := range blobs {
+		metadata.Layers = append(metadata.Layers, LayerMetadata{
+			Name:   blob.Name,
+			Digest: blob.Desc.Digest,
+			Size:   blob.Desc.Size,
+			Stats:  blob.Stats,
+		})
+		metadata.Total.Add(blob.Stats)
+	}
+	return metadata
+}
+
+// Print writes metadata in human readable lines, followed by a table of
+// layers.
+func (m *CommitMetadata) Print(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Container:\t%s\n", m.Container)
+	if m.Image != "" {
+		fmt.Fprintf(tw, "Image:\t%s\n", m.Image)
+	}
+	if m.BaseDigest != "" {
+		fmt.Fprintf(tw, "Base:\t%s@%s\n", m.BaseRef, m.BaseDigest)
+	}
+	fmt.Fprintf(tw, "Targets:\t%s\n", strings.Join(m.Options.Targets, ", "))
+	if len(m.Options.WithPaths) > 0 {
+		fmt.Fprintf(tw, "With paths:\t%s\n", strings.Join(m.Options.WithPaths, ", "))
+	}
+	if len(m.Options.WithoutPaths) > 0 {
+		fmt.Fprintf(tw, "Without paths:\t%s\n", strings.Join(m.Options.WithoutPaths, ", "))
+	}
+	if len(m.Options.Sidecars) > 0 {
+		fmt.Fprintf(tw, "Sidecars:\t%s\n", strings.Join(m.Options.Sidecars, ", "))
+	}
+	if m.Options.PauseContainer {
+		fmt.Fprintf(tw, "Paused:\t%s\n", m.Options.PauseMode)
+	}
+	if m.Options.SourceDateEpoch != nil {
+		fmt.Fprintf(tw, "Source date epoch:\t%s\n", m.Options.SourceDateEpoch.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Version:\t%s\n", m.Version)
+	fmt.Fprintf(tw, "Committed:\t%s (%s)\n", m.FinishedAt.Format(time.RFC3339), m.FinishedAt.Sub(m.StartedAt).Round(time.Millisecond))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(writer)
+	tw = tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LAYER\tDIGEST\tSIZE\tENTRIES\tFILES\tWHITEOUTS\tFILE SIZE")
+	for _, layer := range m.Layers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", layer.Name, layer.Digest, humanize.Bytes(uint64(layer.Size)),
+			layer.Stats.Entries, layer.Stats.Files, layer.Stats.Whiteouts, humanize.Bytes(uint64(layer.Stats.Size)))
+	}
+	fmt.Fprintf(tw, "total\t\t\t%d\t%d\t%d\t%s\n", m.Total.Entries, m.Total.Files, m.Total.Whiteouts, humanize.Bytes(uint64(m.Total.Size)))
+	return tw.Flush()
+}
+
+// WriteJSON writes metadata in json.
+func (m *CommitMetadata) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// pushMetadata pushes the commit metadata of manifest `subject` pushed to
+// `targetRef`, as an artifact referring to the manifest.
+func (wf *Workflow) pushMetadata(ctx context.Context, state *CommitState, targetRef string, subject ocispec.Descriptor) error {
+	remoter, err := wf.newRemote(targetRef)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+
+	metadata, err := json.Marshal(commitMetadata(state))
+	if err != nil {
+		return errors.Wrap(err, "marshal commit metadata")
+	}
+	manifestDesc, err := wf.pushArtifact(
+		ctx, remoter, MediaTypeCommitMetadata, ocispec.Descriptor{MediaType: MediaTypeCommitMetadata}, metadata, subject,
+		map[string]string{ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339)},
+	)
+	if err != nil {
+		return errors.Wrap(err, "push commit metadata")
+	}
+	logrus.Infof("pushed commit metadata %s referring to %s@%s", manifestDesc.Digest, targetRef, subject.Digest)
+	return nil
+}
+
+// Metadata returns the commit metadata of image `ref` pushed with
+// CommitOption.Metadata, the latest one if the manifest is pushed several
+// times.
+func (wf *Workflow) Metadata(ctx context.Context, ref string) (*CommitMetadata, error) {
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	subject, err := remoter.Resolve(ctx)
+	if err != nil {
+		return nil, classify(errors.Wrapf(err, "resolve %s", ref))
+	}
+	referrers, err := remoter.Referrers(ctx, wf.hostsFunc, subject.Digest, MediaTypeCommitMetadata)
+	if err != nil {
+		return nil, classify(errors.Wrapf(err, "get referrers of %s", ref))
+	}
+	if len(referrers) == 0 {
+		return nil, fmt.Errorf("no commit metadata referring to %s@%s", ref, subject.Digest)
+	}
+	// The timestamps in RFC 3339 of UTC are ordered as strings.
+	latest := referrers[0]
+	for _, referrer := range referrers[1:] {
+		if referrer.Annotations[ocispec.AnnotationCreated] >= latest.Annotations[ocispec.AnnotationCreated] {
+			latest = referrer
+		}
+	}
+
+	manifest := ocispec.Manifest{}
+	if err := pullJSON(ctx, remoter, latest, &manifest); err != nil {
+		return nil, errors.Wrap(err, "pull commit metadata manifest")
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != MediaTypeCommitMetadata {
+			continue
+		}
+		metadata := CommitMetadata{}
+		if err := pullJSON(ctx, remoter, layer, &metadata); err != nil {
+			return nil, errors.Wrap(err, "pull commit metadata")
+		}
+		return &metadata, nil
+	}
+	return nil, fmt.Errorf("no layer of %s in commit metadata artifact %s", MediaTypeCommitMetadata, latest.Digest)
+}
+
+// pullJSON pulls `desc` from `remoter` and unmarshals it into `value`.
+func pullJSON(ctx context.Context, remoter *remote.Remote, desc ocispec.Descriptor, value interface{}) error {
+	if desc.Size > maxMetadataSize {
+		return fmt.Errorf("size %d exceeds the limit %d", desc.Size, maxMetadataSize)
+	}
+	rc, err := remoter.Pull(ctx, desc, true)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxMetadataSize))
+	if err != nil {
+		return errors.Wrap(err, "read content")
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errors.Wrap(err, "unmarshal content")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+)
+
+func TestCommitMetadata(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	state := &CommitState{
+		Option: CommitOption{
+			ContainerIDWithType: "docker://abc",
+			Targets: []Target{
+				{Ref: "registry.example.com/app:v2", Format: FormatNydus},
+				{Ref: "registry.example.com/app:v2-oci", Format: FormatOCI},
+			},
+			WithPaths:      []string{"/data"},
+			PauseContainer: true,
+			PauseMode:      container.PauseCgroup,
+			SpecialFiles:   tarstream.SpecialFilesSkip,
+			Version:        "v1.0.0",
+		},
+		NydusTargetRefs: []string{"registry.example.com/app:v2_nydus_v2"},
+		StartedAt:       started,
+		Inspect:         &container.InspectResult{Image: "registry.example.com/app:v1_nydus_v2"},
+		BaseRef:         "registry.example.com/app:v1_nydus_v2",
+		Base:            &parserPkg.Image{Desc: ocispec.Descriptor{Digest: digest.FromString("base")}},
+		MountBlobs: []Blob{{
+			Name:  "blob-mount-0",
+			Desc:  ocispec.Descriptor{Digest: digest.FromString("mount"), Size: 2048},
+			Stats: tarstream.Stats{Entries: 3, Files: 2, Size: 1500},
+		}},
+		UpperBlob: &Blob{
+			Name:  "blob-upper",
+			Desc:  ocispec.Descriptor{Digest: digest.FromString("upper"), Size: 4096},
+			Stats: tarstream.Stats{Entries: 5, Files: 3, Whiteouts: 1, Size: 3000},
+		},
+	}
+
+	metadata := commitMetadata(state)
+	require.Equal(t, "docker://abc", metadata.Container)
+	require.Equal(t, "registry.example.com/app:v1_nydus_v2", metadata.Image)
+	require.Equal(t, digest.FromString("base"), metadata.BaseDigest)
+	require.Equal(t, []string{"registry.example.com/app:v2_nydus_v2", "registry.example.com/app:v2-oci"}, metadata.Options.Targets)
+	require.Equal(t, "cgroup", metadata.Options.PauseMode)
+	require.Equal(t, "skip", metadata.Options.SpecialFiles)
+	require.Equal(t, []LayerMetadata{
+		{Name: "blob-upper", Digest: digest.FromString("upper"), Size: 4096, Stats: state.UpperBlob.Stats},
+		{Name: "blob-mount-0", Digest: digest.FromString("mount"), Size: 2048, Stats: state.MountBlobs[0].Stats},
+	}, metadata.Layers)
+	require.Equal(t, tarstream.Stats{Entries: 8, Files: 5, Whiteouts: 1, Size: 4500}, metadata.Total)
+	require.Equal(t, "v1.0.0", metadata.Version)
+	require.False(t, metadata.FinishedAt.Before(started))
+
+	buf := bytes.Buffer{}
+	require.NoError(t, metadata.WriteJSON(&buf))
+	decoded := CommitMetadata{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, metadata.Layers, decoded.Layers)
+	require.Contains(t, buf.String(), `"files": 3`)
+
+	buf.Reset()
+	require.NoError(t, metadata.Print(&buf))
+	require.Contains(t, buf.String(), "Base:        registry.example.com/app:v1_nydus_v2@"+digest.FromString("base").String())
+	require.Contains(t, buf.String(), "Paused:      cgroup")
+	require.Regexp(t, `blob-upper\s+sha256:\w+\s+4.1 kB\s+5\s+3\s+1\s+3.0 kB`, buf.String())
+	require.Regexp(t, `total\s+8\s+5\s+1\s+4.5 kB`, buf.String())
+}
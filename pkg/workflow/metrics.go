@@ -0,0 +1,57 @@
+package workflow
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/metrics"
+)
+
+// metricLabels returns the labels of metrics of commit `opt`.
+func (wf *Workflow) metricLabels(opt CommitOption) metrics.Labels {
+	labels := metrics.Labels{Backend: wf.cfg.BackendType()}
+	hosts := map[string]bool{}
+	for _, target := range opt.Targets {
+		if named, err := reference.ParseNormalizedNamed(target.Ref); err == nil {
+			hosts[reference.Domain(named)] = true
+		}
+	}
+	registries := []string{}
+	for host := range hosts {
+		registries = append(registries, host)
+	}
+	sort.Strings(registries)
+	labels.Registry = strings.Join(registries, ",")
+	return labels
+}
+
+// observeStage is the middleware which records the result and latency of
+// each stage in metrics.
+func (wf *Workflow) observeStage(stage string, next StageFunc) StageFunc {
+	return func(ctx context.Context, state *CommitState) error {
+		if wf.metrics == nil {
+			return next(ctx, state)
+		}
+		start := time.Now()
+		err := next(ctx, state)
+		wf.metrics.ObserveStage(wf.metricLabels(state.Option), stage, time.Since(start), err)
+		return err
+	}
+}
+
+// observeCommit records the result and latency of commit in metrics, and
+// flushes them into file, it's best effort.
+func (wf *Workflow) observeCommit(opt CommitOption, elapsed time.Duration, err error) {
+	if wf.metrics == nil {
+		return
+	}
+	wf.metrics.ObserveCommit(wf.metricLabels(opt), elapsed, err)
+	if err := wf.metrics.Flush(); err != nil {
+		logrus.WithError(err).Warn("write metrics")
+	}
+}
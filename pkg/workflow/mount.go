@@ -0,0 +1,236 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/nsenter"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MountStrategy is how the mount paths of container are copied.
+type MountStrategy string
+
+const (
+	// MountAuto copies by nsenter, or from host paths if the mount
+	// namespace of container can't be entered.
+	MountAuto MountStrategy = "auto"
+	// MountNSEnter copies by tar executed in the mount namespace of
+	// container, which requires tar in container.
+	MountNSEnter MountStrategy = "nsenter"
+	// MountHostPath copies by tar on host from the host paths of the
+	// volumes the mount paths are in.
+	MountHostPath MountStrategy = "host"
+)
+
+// ParseMountStrategy parses `strategy`, the empty strategy is MountAuto.
+func ParseMountStrategy(strategy string) (MountStrategy, error) {
+	switch MountStrategy(strategy) {
+	case "":
+		return MountAuto, nil
+	case MountAuto, MountNSEnter, MountHostPath:
+		return MountStrategy(strategy), nil
+	default:
+		return "", fmt.Errorf("invalid mount strategy %s, must be one of auto, nsenter, host", strategy)
+	}
+}
+
+// UnreadablePolicy is how the files of mount paths which tar fails to read
+// are handled, tar always skips them by `--ignore-failed-read` to archive
+// the others.
+type UnreadablePolicy string
+
+const (
+	// UnreadableWarn commits the mount paths without the skipped files,
+	// which are logged as warnings.
+	UnreadableWarn UnreadablePolicy = "warn"
+	// UnreadableFail fails the commit if any file is skipped.
+	UnreadableFail UnreadablePolicy = "fail"
+)
+
+// ParseUnreadablePolicy parses `policy`, the empty policy is
+// UnreadableWarn.
+func ParseUnreadablePolicy(policy string) (UnreadablePolicy, error) {
+	switch UnreadablePolicy(policy) {
+	case "":
+		return UnreadableWarn, nil
+	case UnreadableWarn, UnreadableFail:
+		return UnreadablePolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid unreadable policy %s, must be one of warn, fail", policy)
+	}
+}
+
+// maxSkippedFiles is the count of skipped files listed in error.
+const maxSkippedFiles = 10
+
+// skippedFiles returns the files reported in `stderr` of tar as skipped
+// by `--ignore-failed-read`, e.g. `tar: /data/key: Warning: Cannot open:
+// Permission denied`.
+func skippedFiles(stderr string) []string {
+	skipped := []string{}
+	for _, line := range strings.Split(stderr, "\n") {
+		if path, _, ok := strings.Cut(strings.TrimPrefix(line, "tar: "), ": Warning: Cannot "); ok {
+			skipped = append(skipped, path)
+		}
+	}
+	return skipped
+}
+
+// checkSkipped fails on the files skipped by tar in `stderr` if
+// failOnUnreadable is set, otherwise they are only counted in warning.
+func checkSkipped(stderr string, opt copyOption) error {
+	skipped := skippedFiles(stderr)
+	if len(skipped) == 0 {
+		return nil
+	}
+	if !opt.failOnUnreadable {
+		logrus.Warnf("skipped %d unreadable files", len(skipped))
+		return nil
+	}
+	listed := skipped
+	if len(listed) > maxSkippedFiles {
+		listed = listed[:maxSkippedFiles]
+	}
+	return fmt.Errorf("skipped %d unreadable files: %s", len(skipped), strings.Join(listed, ", "))
+}
+
+// probeTimeout is the timeout of probing nsenter in MountAuto.
+var probeTimeout = 10 * time.Second
+
+// resolveMountStrategy resolves MountAuto by probing tar in the mount
+// namespace of container process `containerPid`, once for the commit.
+func (wf *Workflow) resolveMountStrategy(ctx context.Context, strategy MountStrategy, containerPid int) MountStrategy {
+	if strategy != MountAuto && strategy != "" {
+		return strategy
+	}
+	wf.mountStrategyOnce.Do(func() {
+		wf.mountStrategy = MountNSEnter
+		if err := probeNSEnter(ctx, containerPid); err != nil {
+			logrus.WithError(err).Warn("fall back to copy mount paths from host paths")
+			wf.mountStrategy = MountHostPath
+		}
+	})
+	return wf.mountStrategy
+}
+
+// probeNSEnter checks that tar can be executed in the mount namespace of
+// container process `containerPid`.
+func probeNSEnter(ctx context.Context, containerPid int) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	config := &nsenter.Config{
+		Mount:  true,
+		Target: containerPid,
+	}
+	if stderr, err := config.ExecuteContext(ctx, io.Discard, "tar", "--version"); err != nil {
+		if stderr = strings.TrimSpace(stderr); stderr != "" {
+			err = fmt.Errorf("%w: %s", err, stderr)
+		}
+		return errors.Wrapf(err, "execute tar in mount namespace of pid %d", containerPid)
+	}
+	return nil
+}
+
+// findContainerMount returns the mount of `mounts` which `targetPath` is
+// in, the innermost one if nested.
+func findContainerMount(mounts []container.Mount, targetPath string) *container.Mount {
+	var matched *container.Mount
+	for idx, mount := range mounts {
+		dest := filepath.Clean(mount.Destination)
+		if targetPath == dest || dest == "/" || strings.HasPrefix(targetPath, dest+"/") {
+			if matched == nil || len(matched.Destination) <= len(dest) {
+				matched = &mounts[idx]
+			}
+		}
+	}
+	return matched
+}
+
+// openHostPath opens the host path of mount path `targetPath` of container
+// as an O_PATH file, which is resolved in the source of the volume in
+// `containerMounts` which `targetPath` is in. The content of volume is
+// controlled by container, so the symlinks in it are resolved inside the
+// source, and the opened file is mounted by /proc/self/fd/<fd> instead of
+// the resolved path, which the container could swap for a symlink to a
+// host path before mounted.
+func openHostPath(containerMounts []container.Mount, targetPath string) (*os.File, error) {
+	if !filepath.IsAbs(targetPath) {
+		return nil, fmt.Errorf("not a absolute path: %s", targetPath)
+	}
+	targetPath = filepath.Clean(targetPath)
+
+	sourceMount := findContainerMount(containerMounts, targetPath)
+	if sourceMount == nil {
+		return nil, fmt.Errorf("not found mount path: %s", targetPath)
+	}
+	logrus.Infof("for target %s, container: %s -> %s", targetPath, sourceMount.Source, sourceMount.Destination)
+
+	hostBase, err := filepath.Rel(filepath.Clean(sourceMount.Destination), targetPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get rel path for %s", targetPath)
+	}
+	file, err := openInRoot(sourceMount.Source, hostBase)
+	if err != nil {
+		return nil, errors.Wrapf(err, "resolve host path for %s", targetPath)
+	}
+	return file, nil
+}
+
+// copyFromHost copies mount path `source` of container by tar on host. The
+// host path is resolved from the volume in `containerMounts` which
+// `source` is in, and bind mounted read-only at the same relative path
+// under work dir, so the entries are named as copyFromContainer.
+func (wf *Workflow) copyFromHost(ctx context.Context, containerMounts []container.Mount, source, name string, target io.Writer, opt copyOption) error {
+	hostFile, err := openHostPath(containerMounts, source)
+	if err != nil {
+		return errors.Wrap(err, "prepare host path")
+	}
+	defer hostFile.Close()
+	hostTarget := strings.TrimLeft(filepath.Clean(source), "/")
+	logrus.Infof("mount: %s -> %s", hostFile.Name(), hostTarget)
+
+	root := filepath.Join(wf.workDir, name+"-host")
+	_, release, err := wf.bindReadOnly(fmt.Sprintf("/proc/self/fd/%d", hostFile.Fd()), filepath.Join(name+"-host", hostTarget))
+	if err != nil {
+		return errors.Wrapf(err, "bind host path %s", hostFile.Name())
+	}
+	defer func() {
+		// The mount point is removed only if unmounted, not to remove the
+		// files of host path.
+		if err := release(); err != nil {
+			logrus.WithError(err).Warnf("release host path %s", hostFile.Name())
+			return
+		}
+		if err := os.RemoveAll(root); err != nil {
+			logrus.WithError(err).Warnf("remove %s", root)
+		}
+	}()
+
+	config := &nsenter.Config{
+		NoEnter:          true,
+		Name:             "tar " + source,
+		StallTimeout:     opt.stallTimeout,
+		ProgressInterval: copyProgressInterval,
+	}
+	args := append([]string{"-C", root}, tarArgs([]string{hostTarget}, opt)...)
+	stderr, err := config.ExecuteContext(ctx, target, "tar", args...)
+	if err != nil {
+		return errors.Wrapf(err, "execute tar: %s", strings.TrimSpace(stderr))
+	}
+	if err := checkSockets(stderr, opt); err != nil {
+		return err
+	}
+	if stderr != "" {
+		logrus.Warnf("from host path: %s", stderr)
+	}
+	return checkSkipped(stderr, opt)
+}
@@ -0,0 +1,242 @@
+package workflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseMountStrategy(t *testing.T) {
+	strategy, err := ParseMountStrategy("")
+	require.NoError(t, err)
+	require.Equal(t, MountAuto, strategy)
+
+	strategy, err = ParseMountStrategy("host")
+	require.NoError(t, err)
+	require.Equal(t, MountHostPath, strategy)
+
+	_, err = ParseMountStrategy("bind")
+	require.EqualError(t, err, "invalid mount strategy bind, must be one of auto, nsenter, host")
+}
+
+func TestResolveMountStrategy(t *testing.T) {
+	wf := &Workflow{}
+	require.Equal(t, MountNSEnter, wf.resolveMountStrategy(context.Background(), MountNSEnter, 0))
+	// The mount namespace of an invalid pid can't be entered.
+	require.Equal(t, MountHostPath, wf.resolveMountStrategy(context.Background(), MountAuto, -1))
+	require.Equal(t, MountHostPath, wf.resolveMountStrategy(context.Background(), "", 1))
+}
+
+func TestCopyFromHost(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("bind mount requires root")
+	}
+	volume := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(volume, "cache"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(volume, "cache", "file"), []byte("data"), 0644))
+
+	workDir := t.TempDir()
+	wf := &Workflow{cfg: &config.Config{}, workDir: workDir, dirMode: 0700}
+	mounts := []container.Mount{{Destination: "/data", Source: volume}}
+	var buf bytes.Buffer
+	require.NoError(t, wf.copyFromHost(context.Background(), mounts, "/data/cache", "blob-mount-0", &buf, copyOption{sortByName: true}))
+
+	names := []string{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Equal(t, []string{"data/cache/", "data/cache/file"}, names)
+
+	// The mount point is released and removed.
+	_, err := os.Stat(filepath.Join(workDir, "blob-mount-0-host"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(volume, "cache", "file"))
+	require.NoError(t, err)
+
+	err = wf.copyFromHost(context.Background(), mounts, "/etc", "blob-mount-1", &buf, copyOption{})
+	require.EqualError(t, err, "prepare host path: not found mount path: /etc")
+}
+
+func TestMountSparseFile(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	const size = 8 * 1024 * 1024
+	source := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.Mkdir(source, 0755))
+	file, err := os.Create(filepath.Join(source, "sparse"))
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, file.Truncate(size))
+	_, err = file.WriteAt([]byte("nydus"), 4*1024*1024)
+	require.NoError(t, err)
+	if _, sparse, err := tarstream.DataSegments(file, size); err != nil || !sparse {
+		t.Skip("filesystem doesn't report holes")
+	}
+
+	// The stream of mount is rewritten as commitMount does.
+	out, err := exec.Command("tar", tarArgs([]string{source}, copyOption{})...).Output()
+	require.NoError(t, err)
+	opt := &CommitOption{MaxMountEntries: 10, MaxMountSize: size}
+	stats := tarstream.Stats{}
+	var rewritten bytes.Buffer
+	require.NoError(t, tarstream.Rewrite(bytes.NewReader(out), &rewritten, opt.mountTarOptions(source, t.TempDir(), &stats)...))
+	require.Equal(t, int64(size), stats.Size)
+	require.Less(t, rewritten.Len(), 1024*1024)
+
+	expected, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+	require.NoError(t, err)
+	found := false
+	tr := tar.NewReader(bytes.NewReader(rewritten.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Name != filepath.Join(source, "sparse") {
+			continue
+		}
+		require.Equal(t, byte(tar.TypeGNUSparse), hdr.Typeflag)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(expected, data))
+		found = true
+	}
+	require.True(t, found)
+
+	// The old GNU sparse headers are accepted by the builder.
+	t.Run("builder", func(t *testing.T) {
+		builder, err := exec.LookPath("nydus-image")
+		if err != nil {
+			t.Skip("packing requires nydus-image")
+		}
+		var blob bytes.Buffer
+		pw, err := converter.Pack(context.Background(), &blob, converter.PackOption{
+			WorkDir:     t.TempDir(),
+			FsVersion:   fsVersion,
+			Compressor:  "lz4_block",
+			BuilderPath: builder,
+		})
+		require.NoError(t, err)
+		_, err = io.Copy(pw, &rewritten)
+		require.NoError(t, err)
+		require.NoError(t, pw.Close())
+		require.NotZero(t, blob.Len())
+	})
+}
+
+// fdPath returns the path of opened `file` by its fd.
+func fdPath(t *testing.T, file *os.File) string {
+	path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", file.Fd()))
+	require.NoError(t, err)
+	return path
+}
+
+func TestOpenHostPath(t *testing.T) {
+	source := t.TempDir()
+	for _, dir := range []string{"foo", "bar", "proc", "etc", "data/sub/foo"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(source, dir), 0755))
+	}
+	require.NoError(t, os.Symlink("/", filepath.Join(source, "root")))
+	require.NoError(t, os.Symlink("../../../../../etc", filepath.Join(source, "hostetc")))
+	require.NoError(t, os.Symlink("data/sub", filepath.Join(source, "sub")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(source, "loop")))
+
+	containerMounts := []container.Mount{{Source: source, Destination: "/guest/data"}}
+	for targetPath, hostPath := range map[string]string{
+		"/guest/data/foo":            filepath.Join(source, "foo"),
+		"/guest/data/bar":            filepath.Join(source, "bar"),
+		"/guest/data/root/proc":      filepath.Join(source, "proc"),
+		"/guest/data/hostetc":        filepath.Join(source, "etc"),
+		"/guest/data/sub/foo":        filepath.Join(source, "data/sub/foo"),
+		"/guest/data/../data/../etc": "",
+		"/guest/data/loop":           "",
+		"/guest/data/missing":        "",
+		"guest/data/foo":             "",
+		"/guest/database":            "",
+	} {
+		file, err := openHostPath(containerMounts, targetPath)
+		if hostPath == "" {
+			require.Error(t, err, targetPath)
+			continue
+		}
+		require.NoError(t, err, targetPath)
+		require.Equal(t, hostPath, fdPath(t, file), targetPath)
+		file.Close()
+	}
+}
+
+func TestOpenInRootSwap(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "dir", "data"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(outside, "data"), 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "alt")))
+
+	for name, open := range map[string]func(string, string) (*os.File, error){
+		"openat2": openInRoot,
+		"walk": func(root, unsafePath string) (*os.File, error) {
+			rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+			if err != nil {
+				return nil, err
+			}
+			defer unix.Close(rootFd)
+			return openInRootByWalk(rootFd, root, unsafePath)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// The container keeps swapping the directory for a symlink to
+			// the host path while the path is resolved and opened.
+			stop := make(chan struct{})
+			swapped := make(chan struct{})
+			go func() {
+				defer close(swapped)
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					_ = unix.Renameat2(unix.AT_FDCWD, filepath.Join(root, "dir"), unix.AT_FDCWD, filepath.Join(root, "alt"), unix.RENAME_EXCHANGE)
+				}
+			}()
+			// A path resolved in userspace and then opened by path escapes a
+			// few times in this many rounds, even on a single CPU.
+			opened := 0
+			for i := 0; i < 20000; i++ {
+				file, err := open(root, "dir/data")
+				if err != nil {
+					continue
+				}
+				opened++
+				path := fdPath(t, file)
+				file.Close()
+				require.True(t, strings.HasPrefix(path, root+"/"), path)
+			}
+			close(stop)
+			<-swapped
+			require.NotZero(t, opened)
+		})
+	}
+}
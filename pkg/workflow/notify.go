@@ -0,0 +1,91 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/notify"
+)
+
+// commitEvent returns the event of commit `state`, `err` is the failure of
+// commit if any.
+func commitEvent(state *CommitState, eventType string, err error) *notify.Event {
+	hostname, _ := os.Hostname()
+	event := &notify.Event{
+		Type:          eventType,
+		Time:          time.Now().UTC(),
+		Hostname:      hostname,
+		Container:     state.Option.ContainerIDWithType,
+		ContainerName: state.Option.ContainerName,
+		Targets:       []notify.Target{},
+	}
+	if state.Inspect != nil {
+		event.Image = state.Inspect.Image
+	}
+
+	// The references of nydus targets are known once the templates are
+	// expanded.
+	nydusRefs := state.NydusTargetRefs
+	for _, target := range state.Option.Targets {
+		ref := target.Ref
+		if target.Format == FormatNydus && len(nydusRefs) > 0 {
+			ref, nydusRefs = nydusRefs[0], nydusRefs[1:]
+		}
+		event.Targets = append(event.Targets, notify.Target{
+			Ref:    ref,
+			Format: string(target.Format),
+			Digest: state.ManifestDigests[ref],
+		})
+	}
+
+	if eventType == notify.EventCommitStarted {
+		return event
+	}
+	event.DurationMs = time.Since(state.StartedAt).Milliseconds()
+	event.StagesMs = map[string]int64{}
+	for stage, elapsed := range state.StageDurations {
+		event.StagesMs[stage] = elapsed.Milliseconds()
+	}
+	if err != nil {
+		event.Error = err.Error()
+		event.ExitCode = ExitCode(err)
+	}
+	return event
+}
+
+// notify delivers the event of commit `state` to the configured notifiers,
+// it's best effort and never fails the commit. The delivery isn't canceled
+// with commit, so the failure of interrupted commit is still notified.
+func (wf *Workflow) notify(state *CommitState, eventType string, err error) {
+	if len(wf.notifiers) == 0 {
+		return
+	}
+	event := commitEvent(state, eventType, err)
+	for _, notifier := range wf.notifiers {
+		if err := notifier.Notify(context.Background(), event); err != nil {
+			logrus.WithError(err).Warnf("notify event %s", eventType)
+		}
+	}
+}
+
+// runHook runs the configured executable of `hook` with the event of commit
+// `state`, it's skipped if not configured.
+func (wf *Workflow) runHook(ctx context.Context, hook string, state *CommitState, eventType string, err error) error {
+	path := map[string]string{
+		notify.HookPreCommit: wf.cfg.Hooks.PreCommit,
+		notify.HookPostPush:  wf.cfg.Hooks.PostPush,
+		notify.HookOnFailure: wf.cfg.Hooks.OnFailure,
+	}[hook]
+	if path == "" {
+		return nil
+	}
+	timeout, timeoutErr := wf.cfg.Hooks.TimeoutDuration()
+	if timeoutErr != nil {
+		return errors.Wrap(timeoutErr, "invalid hooks config")
+	}
+	return notify.RunHook(ctx, hook, path, timeout, commitEvent(state, eventType, err))
+}
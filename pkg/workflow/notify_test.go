@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/notify"
+)
+
+func TestCommitEvent(t *testing.T) {
+	state := &CommitState{
+		Option: CommitOption{
+			ContainerIDWithType: "docker://abc",
+			ContainerName:       "app",
+			Targets: []Target{
+				{Ref: "localhost:5000/app:v1", Format: FormatNydus},
+				{Ref: "localhost:5000/app:v1-oci", Format: FormatOCI},
+			},
+		},
+		StartedAt: time.Now().Add(-time.Minute),
+	}
+
+	event := commitEvent(state, notify.EventCommitStarted, nil)
+	require.Equal(t, "docker://abc", event.Container)
+	require.Equal(t, "app", event.ContainerName)
+	require.Empty(t, event.Image)
+	require.Equal(t, []notify.Target{
+		{Ref: "localhost:5000/app:v1", Format: FormatNydus},
+		{Ref: "localhost:5000/app:v1-oci", Format: FormatOCI},
+	}, event.Targets)
+	require.Zero(t, event.DurationMs)
+
+	state.Inspect = &container.InspectResult{Image: "localhost:5000/base:v1"}
+	state.NydusTargetRefs = []string{"localhost:5000/app:v1-nydus"}
+	state.ManifestDigests = map[string]digest.Digest{
+		"localhost:5000/app:v1-nydus": "sha256:1111",
+		"localhost:5000/app:v1-oci":   "sha256:2222",
+	}
+	state.StageDurations = map[string]time.Duration{StagePush: 1500 * time.Millisecond}
+	event = commitEvent(state, notify.EventCommitSucceeded, nil)
+	require.Equal(t, "localhost:5000/base:v1", event.Image)
+	require.Equal(t, []notify.Target{
+		{Ref: "localhost:5000/app:v1-nydus", Format: FormatNydus, Digest: "sha256:1111"},
+		{Ref: "localhost:5000/app:v1-oci", Format: FormatOCI, Digest: "sha256:2222"},
+	}, event.Targets)
+	require.GreaterOrEqual(t, event.DurationMs, time.Minute.Milliseconds())
+	require.Equal(t, map[string]int64{StagePush: 1500}, event.StagesMs)
+	require.Empty(t, event.Error)
+
+	err := errors.Wrap(withClass(errors.New("denied"), ErrPush), "stage push")
+	event = commitEvent(state, notify.EventCommitFailed, err)
+	require.Equal(t, "stage push: denied", event.Error)
+	require.Equal(t, 17, event.ExitCode)
+}
+
+func TestRunHook(t *testing.T) {
+	dir := t.TempDir()
+	hook := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\ncat > "+filepath.Join(dir, "$NYDUS_CLI_HOOK")+"\n"), 0755))
+
+	wf := &Workflow{cfg: &config.Config{Hooks: config.Hooks{OnFailure: hook}}}
+	state := &CommitState{Option: CommitOption{ContainerIDWithType: "docker://abc"}}
+	// Not configured.
+	require.NoError(t, wf.runHook(context.Background(), notify.HookPreCommit, state, notify.EventCommitStarted, nil))
+	require.NoFileExists(t, filepath.Join(dir, notify.HookPreCommit))
+
+	require.NoError(t, wf.runHook(context.Background(), notify.HookOnFailure, state, notify.EventCommitFailed, errors.New("failed")))
+	payload, err := os.ReadFile(filepath.Join(dir, notify.HookOnFailure))
+	require.NoError(t, err)
+	var event notify.Event
+	require.NoError(t, json.Unmarshal(payload, &event))
+	require.Equal(t, notify.EventCommitFailed, event.Type)
+	require.Equal(t, "docker://abc", event.Container)
+	require.Equal(t, "failed", event.Error)
+}
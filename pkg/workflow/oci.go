@@ -0,0 +1,254 @@
+package workflow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+	"github.com/nydusaccelerator/nydus-cli/pkg/workdir"
+)
+
+const (
+	FormatNydus = "nydus"
+	FormatOCI   = "oci"
+)
+
+// Target is a reference the committed image is pushed to.
+type Target struct {
+	Ref string
+	// Template is the reference template which Ref is expanded from, empty
+	// if Ref isn't templated.
+	Template string
+	// Format is one of `nydus` and `oci`.
+	Format string
+}
+
+// ParseTarget parses target in format `ref[=format]`, format defaults
+// to nydus.
+func ParseTarget(target string) (*Target, error) {
+	if idx := strings.LastIndex(target, "="); idx != -1 {
+		format := target[idx+1:]
+		if format != FormatNydus && format != FormatOCI {
+			return nil, fmt.Errorf("invalid format %s of target %s, must be nydus or oci", format, target)
+		}
+		return &Target{Ref: target[:idx], Format: format}, nil
+	}
+	return &Target{Ref: target, Format: FormatNydus}, nil
+}
+
+// OCILayer is the gzip compressed tar layer of a committed blob, it's
+// written along with the nydus blob from the same tar stream.
+type OCILayer struct {
+	Name   string
+	Desc   ocispec.Descriptor
+	DiffID digest.Digest
+}
+
+type ociLayerWriter struct {
+	name     string
+	file     *os.File
+	quota    *workdir.Quota
+	gw       *gzip.Writer
+	diffID   digest.Digester
+	digester digest.Digester
+	counter  Counter
+	w        io.Writer
+}
+
+func (wf *Workflow) newOCILayerWriter(name string) (*ociLayerWriter, error) {
+	path := filepath.Join(wf.workDir, name)
+	file, err := wf.createFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "create oci layer file")
+	}
+
+	lw := &ociLayerWriter{
+		name:     name,
+		file:     file,
+		quota:    wf.quota,
+		diffID:   digest.SHA256.Digester(),
+		digester: digest.SHA256.Digester(),
+	}
+	lw.gw = gzip.NewWriter(io.MultiWriter(wf.quota.Writer(path, file), lw.digester.Hash(), &lw.counter))
+	lw.w = io.MultiWriter(lw.gw, lw.diffID.Hash())
+
+	return lw, nil
+}
+
+func (lw *ociLayerWriter) Write(p []byte) (int, error) {
+	return lw.w.Write(p)
+}
+
+func (lw *ociLayerWriter) Close() (*OCILayer, error) {
+	defer lw.file.Close()
+	if err := lw.gw.Close(); err != nil {
+		return nil, errors.Wrap(err, "close gzip writer")
+	}
+	return &OCILayer{
+		Name: lw.name,
+		Desc: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Digest:    lw.digester.Digest(),
+			Size:      lw.counter.Size(),
+		},
+		DiffID: lw.diffID.Digest(),
+	}, nil
+}
+
+// abort closes and removes the OCI layer file of the failed commit, the
+// commit may be retried with a new layer writer.
+func (lw *ociLayerWriter) abort() {
+	if lw == nil {
+		return
+	}
+	lw.file.Close()
+	if err := os.Remove(lw.file.Name()); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Warnf("remove oci layer %s", lw.name)
+	}
+	lw.quota.Set(lw.file.Name(), 0)
+}
+
+// ociBaseRef returns the OCI image of container, which is the image of
+// container itself unless it's a nydus image.
+func (wf *Workflow) ociBaseRef(inspect *container.InspectResult) (string, error) {
+	if !inspect.Nydus {
+		return inspect.Image, nil
+	}
+	return wf.naming.OCIRef(inspect.Image)
+}
+
+// pullOCIBase pulls the manifest and config of the OCI image of container,
+// see ociBaseRef.
+func (wf *Workflow) pullOCIBase(ctx context.Context, inspect *container.InspectResult) (string, *parserPkg.Image, error) {
+	ref, err := wf.ociBaseRef(inspect)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "name oci image")
+	}
+
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return "", nil, errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "parse oci image")
+	}
+	if parsed.OCIImage == nil {
+		return "", nil, fmt.Errorf("not found oci image: %s", ref)
+	}
+
+	return ref, parsed.OCIImage, nil
+}
+
+func pushWithHTTPFallback(ctx context.Context, remoter *remote.Remote, desc ocispec.Descriptor, byDigest bool, reader func() (io.Reader, error)) error {
+	push := func() error {
+		r, err := reader()
+		if err != nil {
+			return err
+		}
+		if closer, ok := r.(io.Closer); ok {
+			defer closer.Close()
+		}
+		return remoter.Push(ctx, desc, byDigest, r)
+	}
+	if err := push(); err != nil {
+		if !remoter.MaybeWithHTTP(err) {
+			return err
+		}
+		return push()
+	}
+	return nil
+}
+
+// pushOCIImage pushes an OCI image made of the layers of OCI base image
+// and the committed layers to `targetRef`, it's pushed by digest without
+// tag if `byDigest` is set.
+func (wf *Workflow) pushOCIImage(ctx context.Context, baseRef string, base parserPkg.Image, layers []OCILayer, targetRef string, byDigest bool) (*ocispec.Descriptor, error) {
+	source, err := wf.newRemote(baseRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "create source remote")
+	}
+	target, err := wf.newRemote(targetRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "create target remote")
+	}
+
+	// Copy the layers of base image in case the target is in another repository.
+	for _, layer := range base.Manifest.Layers {
+		layer := layer
+		if err := pushWithHTTPFallback(ctx, target, layer, true, func() (io.Reader, error) {
+			return source.Pull(ctx, layer, true)
+		}); err != nil {
+			return nil, errors.Wrapf(err, "copy base layer %s", layer.Digest)
+		}
+	}
+
+	manifest := base.Manifest
+	config := base.Config
+	manifest.Layers = append([]ocispec.Descriptor{}, manifest.Layers...)
+	config.RootFS.DiffIDs = append([]digest.Digest{}, config.RootFS.DiffIDs...)
+	for _, layer := range layers {
+		layer := layer
+		if base.Desc.MediaType == images.MediaTypeDockerSchema2Manifest {
+			layer.Desc.MediaType = images.MediaTypeDockerSchema2LayerGzip
+		}
+		logrus.Infof("pushing oci layer %s", layer.Name)
+		if err := pushWithHTTPFallback(ctx, target, layer.Desc, true, func() (io.Reader, error) {
+			ra, err := local.OpenReader(filepath.Join(wf.workDir, layer.Name))
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{io.NewSectionReader(ra, 0, ra.Size()), ra}, nil
+		}); err != nil {
+			return nil, errors.Wrapf(err, "push oci layer %s", layer.Name)
+		}
+		manifest.Layers = append(manifest.Layers, layer.Desc)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.DiffID)
+	}
+
+	configBytes, configDesc, err := wf.makeDesc(ctx, config, manifest.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "make config desc")
+	}
+	if err := pushWithHTTPFallback(ctx, target, *configDesc, true, func() (io.Reader, error) {
+		return bytes.NewReader(configBytes), nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "push image config")
+	}
+
+	manifest.Config = *configDesc
+	manifestBytes, manifestDesc, err := wf.makeDesc(ctx, manifest, base.Desc)
+	if err != nil {
+		return nil, errors.Wrap(err, "make manifest desc")
+	}
+	manifestDesc.Platform = nil
+	if err := pushWithHTTPFallback(ctx, target, *manifestDesc, byDigest, func() (io.Reader, error) {
+		return bytes.NewReader(manifestBytes), nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "push image manifest")
+	}
+
+	return manifestDesc, nil
+}
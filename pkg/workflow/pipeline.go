@@ -0,0 +1,78 @@
+package workflow
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// StageFunc runs a stage of commit pipeline on the shared commit state.
+type StageFunc func(ctx context.Context, state *CommitState) error
+
+// Stage is a named step of commit pipeline.
+type Stage struct {
+	Name string
+	Run  StageFunc
+}
+
+// Middleware wraps the run of every stage, e.g. to time, trace, apply
+// policies or call hooks around it. It may skip the stage by not calling
+// `next`.
+type Middleware func(stage string, next StageFunc) StageFunc
+
+// Pipeline runs stages in order, each wrapped by the middlewares, and
+// stops at the first failed stage.
+type Pipeline struct {
+	stages      []Stage
+	middlewares []Middleware
+}
+
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// Use appends middlewares, the first one used is the outermost.
+func (p *Pipeline) Use(middlewares ...Middleware) *Pipeline {
+	p.middlewares = append(p.middlewares, middlewares...)
+	return p
+}
+
+// Stages returns the names of stages in order.
+func (p *Pipeline) Stages() []string {
+	names := []string{}
+	for _, stage := range p.stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+func (p *Pipeline) Run(ctx context.Context, state *CommitState) error {
+	for _, stage := range p.stages {
+		run := stage.Run
+		for idx := len(p.middlewares) - 1; idx >= 0; idx-- {
+			run = p.middlewares[idx](stage.Name, run)
+		}
+		if err := run(ctx, state); err != nil {
+			return errors.Wrapf(err, "stage %s", stage.Name)
+		}
+	}
+	return nil
+}
+
+// TimingMiddleware logs the elapsed time of each stage, and records it in
+// CommitState.StageDurations.
+func TimingMiddleware(stage string, next StageFunc) StageFunc {
+	return func(ctx context.Context, state *CommitState) error {
+		start := time.Now()
+		err := next(ctx, state)
+		elapsed := time.Since(start)
+		if state.StageDurations == nil {
+			state.StageDurations = map[string]time.Duration{}
+		}
+		state.StageDurations[stage] = elapsed
+		logrus.Debugf("stage %s finished, elapsed: %s", stage, elapsed)
+		return err
+	}
+}
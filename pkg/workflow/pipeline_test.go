@@ -0,0 +1,47 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	calls := []string{}
+	stage := func(name string, err error) Stage {
+		return Stage{Name: name, Run: func(ctx context.Context, state *CommitState) error {
+			calls = append(calls, name)
+			return err
+		}}
+	}
+	middleware := func(tag string) Middleware {
+		return func(stage string, next StageFunc) StageFunc {
+			return func(ctx context.Context, state *CommitState) error {
+				calls = append(calls, tag+">"+stage)
+				err := next(ctx, state)
+				calls = append(calls, tag+"<"+stage)
+				return err
+			}
+		}
+	}
+	skip := func(stage string, next StageFunc) StageFunc {
+		if stage == StagePull {
+			return func(ctx context.Context, state *CommitState) error { return nil }
+		}
+		return next
+	}
+
+	pipeline := NewPipeline(stage(StageInspect, nil), stage(StagePull, nil), stage(StagePack, fmt.Errorf("failed")), stage(StagePush, nil))
+	pipeline.Use(middleware("a"), skip)
+	require.Equal(t, []string{StageInspect, StagePull, StagePack, StagePush}, pipeline.Stages())
+
+	err := pipeline.Run(context.Background(), &CommitState{})
+	require.EqualError(t, err, "stage pack: failed")
+	require.Equal(t, []string{
+		"a>inspect", "inspect", "a<inspect",
+		"a>pull", "a<pull",
+		"a>pack", "pack", "a<pack",
+	}, calls)
+}
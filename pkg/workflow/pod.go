@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+// Sidecar is a container of pod committed into the image of another
+// container, its upper dir is committed under PathPrefix in a separate
+// blob.
+type Sidecar struct {
+	Name                string
+	ContainerIDWithType string
+	PathPrefix          string
+}
+
+// PodCommitOptions returns the options committing `containers` of a pod
+// from `opt`. Each container is committed into its own image, so the
+// targets must be templated with {{.ContainerName}} if there are several
+// containers, and the lines of DigestFile are in the order of containers.
+// If `combined` is the name of a container, the pod is committed into one
+// image based on the image of the container instead, the upper dirs of the
+// others are committed under `prefix/<name>`.
+func PodCommitOptions(opt CommitOption, containers []container.PodContainer, combined, prefix string) ([]CommitOption, error) {
+	if combined != "" {
+		opts := []CommitOption{}
+		for _, pc := range containers {
+			if pc.Name == combined {
+				combinedOpt := opt
+				combinedOpt.ContainerIDWithType = pc.ID
+				combinedOpt.ContainerName = pc.Name
+				opts = append(opts, combinedOpt)
+			}
+		}
+		if len(opts) == 0 {
+			return nil, fmt.Errorf("container %s not found in pod", combined)
+		}
+		for _, pc := range containers {
+			if pc.Name != combined {
+				opts[0].Sidecars = append(opts[0].Sidecars, Sidecar{
+					Name:                pc.Name,
+					ContainerIDWithType: pc.ID,
+					PathPrefix:          path.Join("/", prefix, pc.Name),
+				})
+			}
+		}
+		return opts, nil
+	}
+
+	if len(containers) > 1 {
+		for _, target := range opt.Targets {
+			if !strings.Contains(target.Ref, ".ContainerName") {
+				return nil, fmt.Errorf("target %s must be templated with {{.ContainerName}} to commit %d containers of pod", target.Ref, len(containers))
+			}
+		}
+	}
+	opts := []CommitOption{}
+	for idx, pc := range containers {
+		containerOpt := opt
+		containerOpt.ContainerIDWithType = pc.ID
+		containerOpt.ContainerName = pc.Name
+		containerOpt.appendDigestFile = idx > 0
+		opts = append(opts, containerOpt)
+	}
+	return opts, nil
+}
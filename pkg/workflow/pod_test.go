@@ -0,0 +1,44 @@
+package workflow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+)
+
+func TestPodCommitOptions(t *testing.T) {
+	containers := []container.PodContainer{
+		{ID: "docker://c1", Name: "app"},
+		{ID: "docker://c2", Name: "proxy"},
+	}
+	opt := CommitOption{Targets: []Target{{Ref: "example.com/app:{{.ContainerName}}", Format: FormatNydus}}}
+
+	opts, err := PodCommitOptions(opt, containers, "", "/pod")
+	require.NoError(t, err)
+	require.Len(t, opts, 2)
+	require.Equal(t, "docker://c1", opts[0].ContainerIDWithType)
+	require.Equal(t, "app", opts[0].ContainerName)
+	require.Equal(t, "docker://c2", opts[1].ContainerIDWithType)
+	require.Equal(t, "proxy", opts[1].ContainerName)
+	require.Empty(t, opts[1].Sidecars)
+	require.False(t, opts[0].appendDigestFile)
+	require.True(t, opts[1].appendDigestFile)
+
+	opt.Targets = []Target{{Ref: "example.com/app:latest", Format: FormatNydus}}
+	_, err = PodCommitOptions(opt, containers, "", "/pod")
+	require.ErrorContains(t, err, "{{.ContainerName}}")
+	opts, err = PodCommitOptions(opt, containers[:1], "", "/pod")
+	require.NoError(t, err)
+	require.Len(t, opts, 1)
+
+	opts, err = PodCommitOptions(opt, containers, "app", "/pod")
+	require.NoError(t, err)
+	require.Len(t, opts, 1)
+	require.Equal(t, "docker://c1", opts[0].ContainerIDWithType)
+	require.Equal(t, []Sidecar{{Name: "proxy", ContainerIDWithType: "docker://c2", PathPrefix: "/pod/proxy"}}, opts[0].Sidecars)
+
+	_, err = PodCommitOptions(opt, containers, "missing", "/pod")
+	require.Error(t, err)
+}
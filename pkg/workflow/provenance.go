@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/provenance"
+)
+
+// commitProvenance returns the provenance of commit in `state`.
+func commitProvenance(state *CommitState) provenance.Commit {
+	host, err := os.Hostname()
+	if err != nil {
+		logrus.WithError(err).Warn("get hostname for provenance")
+	}
+
+	commit := provenance.Commit{
+		ContainerID: state.Option.ContainerIDWithType,
+		Targets:     append(append([]string{}, state.NydusTargetRefs...), targetRefs(state.Option.targets(FormatOCI))...),
+		Host:        host,
+		Version:     state.Option.Version,
+		StartedOn:   state.StartedAt,
+		FinishedOn:  time.Now(),
+	}
+	if state.Inspect != nil {
+		commit.BaseRef = state.Inspect.Image
+	}
+	if state.Base != nil {
+		commit.BaseDigest = state.Base.Desc.Digest
+	}
+	for _, mountBlob := range state.MountBlobs {
+		commit.Blobs = append(commit.Blobs, mountBlob.Desc.Digest)
+	}
+	if state.UpperBlob != nil {
+		commit.Blobs = append(commit.Blobs, state.UpperBlob.Desc.Digest)
+	}
+	return commit
+}
+
+func targetRefs(targets []Target) []string {
+	refs := []string{}
+	for _, target := range targets {
+		refs = append(refs, target.Ref)
+	}
+	return refs
+}
+
+// pushProvenance pushes the provenance attestation of manifest `subject`
+// pushed to `targetRef`, as an artifact referring to the manifest.
+func (wf *Workflow) pushProvenance(ctx context.Context, state *CommitState, targetRef string, subject ocispec.Descriptor) error {
+	remoter, err := wf.newRemote(targetRef)
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+
+	statement, err := provenance.New(targetRef, subject.Digest, commitProvenance(state)).Marshal()
+	if err != nil {
+		return err
+	}
+	manifestDesc, err := wf.pushArtifact(
+		ctx, remoter, provenance.MediaType,
+		ocispec.Descriptor{
+			MediaType:   provenance.MediaType,
+			Annotations: map[string]string{"in-toto.io/predicate-type": provenance.PredicateType},
+		},
+		statement, subject,
+		map[string]string{ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339)},
+	)
+	if err != nil {
+		return errors.Wrap(err, "push provenance")
+	}
+	logrus.Infof("pushed provenance attestation %s referring to %s@%s", manifestDesc.Digest, targetRef, subject.Digest)
+	return nil
+}
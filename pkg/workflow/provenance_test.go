@@ -0,0 +1,42 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+func TestCommitProvenance(t *testing.T) {
+	started := time.Now().Add(-time.Minute)
+	state := &CommitState{
+		Option: CommitOption{
+			ContainerIDWithType: "docker://abc",
+			Targets: []Target{
+				{Ref: "registry.example.com/app:v2", Format: FormatNydus},
+				{Ref: "registry.example.com/app:v2-oci", Format: FormatOCI},
+			},
+			Version: "v1.0.0",
+		},
+		NydusTargetRefs: []string{"registry.example.com/app:v2_nydus_v2"},
+		StartedAt:       started,
+		Inspect:         &container.InspectResult{Image: "registry.example.com/app:v1_nydus_v2"},
+		Base:            &parserPkg.Image{Desc: ocispec.Descriptor{Digest: digest.FromString("base")}},
+		MountBlobs:      []Blob{{Desc: ocispec.Descriptor{Digest: digest.FromString("mount")}}},
+		UpperBlob:       &Blob{Desc: ocispec.Descriptor{Digest: digest.FromString("upper")}},
+	}
+
+	commit := commitProvenance(state)
+	require.Equal(t, "docker://abc", commit.ContainerID)
+	require.Equal(t, []string{"registry.example.com/app:v2_nydus_v2", "registry.example.com/app:v2-oci"}, commit.Targets)
+	require.Equal(t, "registry.example.com/app:v1_nydus_v2", commit.BaseRef)
+	require.Equal(t, digest.FromString("base"), commit.BaseDigest)
+	require.Equal(t, []digest.Digest{digest.FromString("mount"), digest.FromString("upper")}, commit.Blobs)
+	require.Equal(t, started, commit.StartedOn)
+	require.False(t, commit.FinishedOn.Before(started))
+}
@@ -0,0 +1,175 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+// PsOption is the option of Ps.
+type PsOption struct {
+	// Parallelism is the maximum count of containers inspected
+	// concurrently, 0 means no limit.
+	Parallelism int
+}
+
+// ContainerStatus is a running container of nydus image listed by Ps.
+type ContainerStatus struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// UpperDir and UpperSize are the upper dir of container and the total
+	// size of regular files in it, which is committed.
+	UpperDir  string `json:"upper_dir,omitempty"`
+	UpperSize uint64 `json:"upper_size"`
+	// Commits is the count of commits in the chain of image, 0 if the
+	// image is not committed by nydus-cli.
+	Commits int `json:"commits"`
+	// Error is set if the upper dir or the image can't be inspected, the
+	// other fields are still set as far as possible.
+	Error string `json:"error,omitempty"`
+}
+
+// PsReport is the containers listed by Ps.
+type PsReport struct {
+	Containers []ContainerStatus `json:"containers"`
+}
+
+// Print writes report as a table.
+func (r *PsReport) Print(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTAINER\tNAME\tIMAGE\tUPPER\tCOMMITS\tERROR")
+	for _, status := range r.Containers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", status.ID, status.Name, status.Image, humanize.IBytes(status.UpperSize), status.Commits, status.Error)
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes report in json.
+func (r *PsReport) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Ps lists the running containers of nydus image on all configured
+// engines, with the size of their upper dirs and the count of commits in
+// their images, to pick the candidates of commit and monitor the growth.
+func (wf *Workflow) Ps(ctx context.Context, opt PsOption) (*PsReport, error) {
+	containers, err := wf.cm.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "list containers")
+	}
+	candidates := []container.Summary{}
+	for _, container := range containers {
+		if container.Nydus {
+			candidates = append(candidates, container)
+		}
+	}
+
+	report := &PsReport{Containers: make([]ContainerStatus, len(candidates))}
+	// The commits are counted once per image shared by containers.
+	var mutex sync.Mutex
+	commits := map[string]*imageCommits{}
+
+	eg := errgroup.Group{}
+	if opt.Parallelism > 0 {
+		eg.SetLimit(opt.Parallelism)
+	}
+	for idx := range candidates {
+		idx := idx
+		eg.Go(func() error {
+			summary := candidates[idx]
+			status := ContainerStatus{
+				ID:    summary.ID,
+				Name:  summary.Name,
+				Image: summary.Image,
+			}
+			errs := []string{}
+			if inspect, err := wf.cm.Inspect(ctx, summary.ID); err != nil {
+				errs = append(errs, errors.Wrap(err, "inspect container").Error())
+			} else {
+				status.UpperDir = inspect.UpperDir
+				if status.UpperSize, err = dirSize(inspect.UpperDir); err != nil {
+					errs = append(errs, errors.Wrap(err, "get size of upper dir").Error())
+				}
+			}
+
+			mutex.Lock()
+			counted, ok := commits[summary.Image]
+			if !ok {
+				counted = &imageCommits{}
+				commits[summary.Image] = counted
+			}
+			mutex.Unlock()
+			counted.once.Do(func() {
+				counted.count, counted.err = wf.countCommits(ctx, summary.Image)
+			})
+			status.Commits = counted.count
+			if counted.err != nil {
+				errs = append(errs, errors.Wrap(counted.err, "count commits").Error())
+			}
+
+			status.Error = strings.Join(errs, "; ")
+			report.Containers[idx] = status
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	return report, nil
+}
+
+// imageCommits is the count of commits in an image counted once.
+type imageCommits struct {
+	once  sync.Once
+	count int
+	err   error
+}
+
+// countCommits returns the count of commits in the chain of nydus image
+// `ref` from the annotations of its bootstrap layer.
+func (wf *Workflow) countCommits(ctx context.Context, ref string) (int, error) {
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return 0, errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return 0, errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "parse nydus image")
+	}
+	if parsed.NydusImage == nil {
+		return 0, fmt.Errorf("not a nydus image: %s", ref)
+	}
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&parsed.NydusImage.Manifest)
+	if bootstrapDesc == nil {
+		return 0, fmt.Errorf("not found nydus bootstrap layer")
+	}
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return 0, err
+	}
+	history, err := parseCommitHistory(bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return 0, err
+	}
+	if history == nil {
+		return 0, nil
+	}
+	return history.Total, nil
+}
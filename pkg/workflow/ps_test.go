@@ -0,0 +1,29 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPsReport(t *testing.T) {
+	report := &PsReport{Containers: []ContainerStatus{
+		{ID: "docker://c1", Name: "web", Image: "nginx:latest_nydus_v2", UpperDir: "/upper", UpperSize: 3 << 20, Commits: 2},
+		{ID: "pouch://c2", Name: "app", Image: "app:v1_nydus_v2", Error: "inspect container: container not found"},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, report.Print(&buf))
+	require.Equal(t, `CONTAINER    NAME  IMAGE                  UPPER    COMMITS  ERROR
+docker://c1  web   nginx:latest_nydus_v2  3.0 MiB  2        
+pouch://c2   app   app:v1_nydus_v2        0 B      0        inspect container: container not found
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, report.WriteJSON(&buf))
+	decoded := PsReport{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, *report, decoded)
+}
@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/pkg/errors"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+// credFunc returns the credentials of registry `host` from config, or
+// from the credential providers serving it, or from the docker config of
+// node if not configured, so the credentials in the keychain of docker
+// credential helpers needn't be duplicated.
+func (wf *Workflow) credFunc(host string) (string, string, error) {
+	distribution := wf.cfg.Distribution
+	if distribution.Token != "" {
+		if distribution.TokenType != config.TokenTypeIdentity {
+			return "", "", nil
+		}
+		// The empty username makes the identity token used as refresh token.
+		token, err := wf.tokenFunc(host)
+		return "", token, err
+	}
+	if distribution.Username != "" || distribution.Password != "" {
+		return distribution.Username, distribution.Password, nil
+	}
+	if username, password, ok, err := wf.credentials.Credentials(context.Background(), host); ok {
+		return username, password, err
+	}
+	if wf.dockerCreds == nil {
+		return "", "", nil
+	}
+	return wf.dockerCreds(host)
+}
+
+// tokenFunc returns the token of registries from config, the token file
+// is read again on each call as the token may be rotated.
+func (wf *Workflow) tokenFunc(host string) (string, error) {
+	distribution := wf.cfg.Distribution
+	if distribution.TokenFile == "" {
+		return distribution.Token, nil
+	}
+	content, err := os.ReadFile(distribution.TokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "read token_file")
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// bearerToken returns the token sent to registries as bearer token, nil
+// if the registries are authorized by credentials.
+func (wf *Workflow) bearerToken() remote.TokenFunc {
+	distribution := wf.cfg.Distribution
+	if distribution.Token == "" || distribution.TokenType == config.TokenTypeIdentity {
+		return nil
+	}
+	return wf.tokenFunc
+}
+
+// hostsFunc returns the registry hosts created by NewWorkflow, or new ones
+// if the workflow isn't created by it.
+func (wf *Workflow) hostsFunc(plainHTTP bool) docker.RegistryHosts {
+	if hosts, ok := wf.registryHosts[plainHTTP]; ok {
+		return hosts
+	}
+	return wf.newRegistryHosts(plainHTTP)
+}
+
+// newRegistryHosts returns the registry hosts with mirrors, the hosts not
+// configured with TLS are verified by system CAs.
+func (wf *Workflow) newRegistryHosts(plainHTTP bool) docker.RegistryHosts {
+	return wf.mirrors.RegistryHosts(remote.NewRegistryHostsWithOptions(remote.RegistryOptions{
+		PlainHTTP:  plainHTTP,
+		CredFunc:   wf.credFunc,
+		Proxy:      wf.proxy,
+		TLSConfigs: wf.tlsConfigs,
+		Schemes:    wf.schemes,
+		TokenFunc:  wf.bearerToken(),
+	}))
+}
@@ -0,0 +1,84 @@
+package workflow
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+)
+
+func TestCredFunc(t *testing.T) {
+	dockerCreds := func(host string) (string, string, error) {
+		return "docker", host, nil
+	}
+	wf := &Workflow{cfg: &config.Config{}, dockerCreds: dockerCreds}
+	username, password, err := wf.credFunc("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "docker", username)
+	require.Equal(t, "registry.example.com", password)
+
+	// The configured credentials take precedence.
+	wf.cfg.Distribution = config.Distribution{Username: "user", Password: "password"}
+	username, password, err = wf.credFunc("registry.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "user", username)
+	require.Equal(t, "password", password)
+}
+
+func TestHostsFunc(t *testing.T) {
+	var tokens int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			atomic.AddInt32(&tokens, 1)
+			fmt.Fprint(w, `{"token":"token"}`)
+		case r.Header.Get("Authorization") != "Bearer token":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="https://%s/token",service="registry"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			w.Header().Set("Content-Length", "5")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	stat := func(registries map[string]config.Registry) error {
+		cfg := &config.Config{Registries: registries}
+		cfg.Base.WorkDir = t.TempDir()
+		wf, err := NewWorkflow(cfg)
+		require.NoError(t, err)
+		remoter, err := remote.New(host+"/library/test:latest", wf.resolverFunc)
+		require.NoError(t, err)
+		for idx := 0; idx < 2; idx++ {
+			if _, err := remoter.Stat(context.Background(), wf.hostsFunc, digest.FromString("blob")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The registry not configured with TLS is verified by system CAs.
+	require.Error(t, stat(nil))
+	require.Zero(t, atomic.LoadInt32(&tokens))
+
+	// The token is reused by the requests of workflow.
+	require.NoError(t, stat(map[string]config.Registry{host: {CA: caPath, Insecure: "false"}}))
+	require.Equal(t, int32(1), atomic.LoadInt32(&tokens))
+}
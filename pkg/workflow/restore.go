@@ -0,0 +1,291 @@
+package workflow
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/dustin/go-humanize"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff/archive"
+	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
+)
+
+type RestoreOption struct {
+	ContainerIDWithType string
+	// Target is the committed nydus image whose commits are restored.
+	Target string
+	// PauseContainer pauses the running container while the blobs are
+	// extracted into its upper dir.
+	PauseContainer bool
+	PauseMode      container.PauseMode
+}
+
+// restoreBlob is the upper blob of a commit restored from backend `be`.
+type restoreBlob struct {
+	desc ocispec.Descriptor
+	be   backend.Backend
+	name string
+}
+
+// Restore extracts the upper blobs of the commits in image `opt.Target`
+// into the upper dir of container, oldest first. The commits already in
+// the chain of container image are skipped, so a container of the base
+// image gets all commits. The mount blobs are skipped as the mount paths
+// are not in the upper dir.
+func (wf *Workflow) Restore(ctx context.Context, opt RestoreOption) error {
+	inspect, err := wf.cm.Inspect(ctx, opt.ContainerIDWithType)
+	if err != nil {
+		return errors.Wrap(err, "inspect container")
+	}
+	records, manifest, err := wf.imageCommitRecords(ctx, opt.Target)
+	if err != nil {
+		return errors.Wrap(classify(err), "read commit history of target")
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s is not a committed image", opt.Target)
+	}
+
+	skip := 0
+	if inspect.Nydus {
+		base, _, err := wf.imageCommitRecords(ctx, inspect.Image)
+		if err != nil {
+			return errors.Wrap(classify(err), "read commit history of container image")
+		}
+		if skip, err = commitsInChain(base, records); err != nil {
+			return errors.Wrapf(err, "image %s of container", inspect.Image)
+		}
+	}
+	if skip == len(records) {
+		logrus.Infof("container has all commits of %s", opt.Target)
+		return nil
+	}
+
+	blobs, err := wf.restoreBlobs(opt.Target, manifest, records[skip:])
+	if err != nil {
+		return err
+	}
+	for idx := range blobs {
+		if _, err := wf.pullBlob(blobs[idx].be, blobs[idx].desc, blobs[idx].name); err != nil {
+			return classify(errors.Wrapf(err, "pull blob %s", blobs[idx].desc.Digest))
+		}
+	}
+
+	apply := func() error {
+		for _, blob := range blobs {
+			if err := wf.applyRestoreBlob(ctx, blob, inspect.UpperDir); err != nil {
+				return errors.Wrapf(err, "apply blob %s", blob.desc.Digest)
+			}
+		}
+		if err := syncFs(inspect.UpperDir); err != nil {
+			logrus.WithError(err).Warn("sync upper dir")
+		}
+		return nil
+	}
+	if opt.PauseContainer && inspect.Pid > 0 {
+		if err := wf.pause(ctx, opt.ContainerIDWithType, opt.PauseMode, apply); err != nil {
+			return err
+		}
+	} else if err := apply(); err != nil {
+		return err
+	}
+	logrus.Infof("restored %d commits of %s into container %s", len(records)-skip, opt.Target, opt.ContainerIDWithType)
+	return nil
+}
+
+// imageCommitRecords returns all records of commit history in nydus image
+// `ref` and its manifest, the records are empty if not committed.
+func (wf *Workflow) imageCommitRecords(ctx context.Context, ref string) ([]CommitRecord, *ocispec.Manifest, error) {
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create remote")
+	}
+	parser, err := parserPkg.New(remoter, "amd64")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create parser")
+	}
+	parsed, err := parser.Parse(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse nydus image")
+	}
+	if parsed.NydusImage == nil {
+		return nil, nil, withClass(fmt.Errorf("not a nydus image: %s", ref), ErrNotNydusImage)
+	}
+	manifest := &parsed.NydusImage.Manifest
+	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(manifest)
+	if bootstrapDesc == nil {
+		return nil, nil, fmt.Errorf("not found nydus bootstrap layer")
+	}
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	history, err := parseCommitHistory(bootstrapDesc.Annotations, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, err := wf.pullCommitRecords(ctx, ref, history)
+	if err != nil {
+		return nil, nil, err
+	}
+	return records, manifest, nil
+}
+
+// commitsInChain returns the count of `base` records, which must be the
+// leading records of `records`.
+func commitsInChain(base, records []CommitRecord) (int, error) {
+	if len(base) > len(records) {
+		return 0, fmt.Errorf("has %d commits, more than %d commits of target", len(base), len(records))
+	}
+	for idx := range base {
+		if !sameBlobs(base[idx].Blobs, records[idx].Blobs) {
+			return 0, fmt.Errorf("is not in the commit chain of target, commit %d differs", idx+1)
+		}
+	}
+	return len(base), nil
+}
+
+func sameBlobs(a, b []digest.Digest) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreBlobs returns the upper blobs of `records`, i.e. the last blob
+// of each record, in registry if referenced by the layers of `manifest`,
+// otherwise in the external backend.
+func (wf *Workflow) restoreBlobs(ref string, manifest *ocispec.Manifest, records []CommitRecord) ([]restoreBlob, error) {
+	layers := map[digest.Digest]ocispec.Descriptor{}
+	for _, layer := range manifest.Layers {
+		layers[layer.Digest] = layer
+	}
+
+	remoter, err := wf.newRemote(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "create remote")
+	}
+	registry, err := backend.NewRegistryBackend(remoter, wf.hostsFunc, wf.workDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "new registry backend")
+	}
+	var external backend.Backend
+
+	blobs := []restoreBlob{}
+	for idx, record := range records {
+		if len(record.Blobs) == 0 {
+			continue
+		}
+		if len(record.Blobs) > 1 {
+			logrus.Infof("skipped %d mount blobs of commit %s", len(record.Blobs)-1, record.Time.Format(time.RFC3339))
+		}
+		blobDigest := record.Blobs[len(record.Blobs)-1]
+		blob := restoreBlob{name: fmt.Sprintf("blob-restore-%d", idx)}
+		if layer, ok := layers[blobDigest]; ok {
+			blob.desc, blob.be = layer, registry
+		} else {
+			if external == nil {
+				externalType := wf.cfg.ExternalBackendType()
+				if externalType == "" {
+					return nil, fmt.Errorf("blob %s of %s is in external backend, but it's not configured", blobDigest, ref)
+				}
+				if external, err = wf.backendOfType(ref, externalType); err != nil {
+					return nil, err
+				}
+			}
+			blob.desc, blob.be = ocispec.Descriptor{Digest: blobDigest, Size: -1}, external
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, nil
+}
+
+// applyRestoreBlob converts the pulled nydus `blob` to tar by builder,
+// and extracts it into `upperDir` with the whiteouts of overlay.
+func (wf *Workflow) applyRestoreBlob(ctx context.Context, blob restoreBlob, upperDir string) error {
+	ra, err := local.OpenReader(filepath.Join(wf.workDir, blob.name))
+	if err != nil {
+		return errors.Wrap(err, "open reader for blob")
+	}
+	defer ra.Close()
+
+	builder, builderLog, err := wf.builder(blob.name)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		err := converter.Unpack(ctx, ra, pw, converter.UnpackOption{
+			WorkDir:     wf.workDir,
+			BuilderPath: builder,
+		})
+		pw.CloseWithError(builderLog.wrap(err))
+	}()
+	defer pr.Close()
+
+	size, err := archive.Apply(ctx, upperDir, pr, archive.WithConvertWhiteout(restoreWhiteout()))
+	if err != nil {
+		return errors.Wrap(err, "extract blob")
+	}
+	logrus.Infof("applied blob %s, size: %s", blob.desc.Digest, humanize.Bytes(uint64(size)))
+	return nil
+}
+
+// restoreWhiteout returns the converter of the whiteouts of tar to
+// overlay as archive.OverlayConvertWhiteout for a blob applied on upper
+// dir, the path whited out and the children of opaque dir which are not
+// unpacked from the blob are removed first as they may exist in upper dir.
+func restoreWhiteout() archive.ConvertWhiteout {
+	unpackedPaths := map[string]bool{}
+	return func(hdr *tar.Header, path string) (bool, error) {
+		base := filepath.Base(path)
+		if base == ".wh..wh..opq" {
+			dir := filepath.Dir(path)
+			if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if path == dir || unpackedPaths[path] {
+					return nil
+				}
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}); err != nil {
+				return false, err
+			}
+		} else if strings.HasPrefix(base, ".wh.") {
+			if err := os.RemoveAll(filepath.Join(filepath.Dir(path), strings.TrimPrefix(base, ".wh."))); err != nil {
+				return false, err
+			}
+		} else {
+			unpackedPaths[path] = true
+		}
+		return archive.OverlayConvertWhiteout(hdr, path)
+	}
+}
@@ -0,0 +1,86 @@
+package workflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff/archive"
+)
+
+func TestCommitsInChain(t *testing.T) {
+	first := CommitRecord{Blobs: []digest.Digest{digest.FromString("mount-1"), digest.FromString("upper-1")}}
+	second := CommitRecord{Blobs: []digest.Digest{digest.FromString("upper-2")}}
+	other := CommitRecord{Blobs: []digest.Digest{digest.FromString("upper-3")}}
+
+	skip, err := commitsInChain(nil, []CommitRecord{first, second})
+	require.NoError(t, err)
+	require.Equal(t, 0, skip)
+
+	skip, err = commitsInChain([]CommitRecord{first}, []CommitRecord{first, second})
+	require.NoError(t, err)
+	require.Equal(t, 1, skip)
+
+	skip, err = commitsInChain([]CommitRecord{first, second}, []CommitRecord{first, second})
+	require.NoError(t, err)
+	require.Equal(t, 2, skip)
+
+	_, err = commitsInChain([]CommitRecord{other}, []CommitRecord{first, second})
+	require.EqualError(t, err, "is not in the commit chain of target, commit 1 differs")
+
+	_, err = commitsInChain([]CommitRecord{first, second, other}, []CommitRecord{first, second})
+	require.EqualError(t, err, "has 3 commits, more than 2 commits of target")
+}
+
+func TestRestoreWhiteout(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("overlay whiteouts require root")
+	}
+	blob := func(entries ...tar.Header) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, hdr := range entries {
+			hdr := hdr
+			require.NoError(t, tw.WriteHeader(&hdr))
+		}
+		require.NoError(t, tw.Close())
+		return buf
+	}
+	dir := func(name string) tar.Header {
+		return tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}
+	}
+	file := func(name string) tar.Header {
+		return tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644}
+	}
+
+	upperDir := t.TempDir()
+	_, err := archive.Apply(context.Background(), upperDir, blob(
+		dir("dir/"), file("dir/old"), dir("dir/sub/"), file("dir/sub/old"), file("removed"),
+	), archive.WithConvertWhiteout(restoreWhiteout()))
+	require.NoError(t, err)
+
+	// The opaque marker may follow the entries of the dir in blob.
+	_, err = archive.Apply(context.Background(), upperDir, blob(
+		dir("dir/"), dir("dir/sub/"), file("dir/sub/new"), file("dir/.wh..wh..opq"), file(".wh.removed"),
+	), archive.WithConvertWhiteout(restoreWhiteout()))
+	require.NoError(t, err)
+
+	require.NoFileExists(t, filepath.Join(upperDir, "dir/old"))
+	require.NoFileExists(t, filepath.Join(upperDir, "dir/sub/old"))
+	require.FileExists(t, filepath.Join(upperDir, "dir/sub/new"))
+	opaque := make([]byte, 1)
+	_, err = unix.Getxattr(filepath.Join(upperDir, "dir"), "trusted.overlay.opaque", opaque)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'y'}, opaque)
+
+	var stat unix.Stat_t
+	require.NoError(t, unix.Lstat(filepath.Join(upperDir, "removed"), &stat))
+	require.Equal(t, uint32(unix.S_IFCHR), stat.Mode&unix.S_IFMT)
+}
@@ -0,0 +1,200 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+)
+
+// retainTags deletes the tags expanded from templated targets beyond the
+// latest `KeepLast` ones, it's best effort after the commit is pushed.
+func (wf *Workflow) retainTags(ctx context.Context, state *CommitState) {
+	nydusTargets := state.Option.targets(FormatNydus)
+	for idx, targetRef := range state.NydusTargetRefs {
+		if template := nydusTargets[idx].Template; template != "" {
+			if err := wf.retainTagsOf(ctx, template, targetRef, true, state.Option.KeepLast); err != nil {
+				logrus.WithError(err).Warnf("retain tags of %s", targetRef)
+			}
+		}
+	}
+	for _, target := range state.Option.targets(FormatOCI) {
+		if target.Template != "" {
+			if err := wf.retainTagsOf(ctx, target.Template, target.Ref, false, state.Option.KeepLast); err != nil {
+				logrus.WithError(err).Warnf("retain tags of %s", target.Ref)
+			}
+		}
+	}
+}
+
+// retainTagsOf deletes the tags in the repository of pushed `targetRef`
+// matching reference template `template` beyond the latest `keep` ones,
+// the nydus tags are matched by their OCI references.
+func (wf *Workflow) retainTagsOf(ctx context.Context, template, targetRef string, nydus bool, keep int) error {
+	templateRepo, pattern, err := distribution.TagPattern(template)
+	if err != nil {
+		return err
+	}
+	templateNamed, err := reference.ParseNormalizedNamed(templateRepo)
+	if err != nil {
+		return errors.Wrap(err, "parse template repository")
+	}
+	targetNamed, err := reference.ParseNormalizedNamed(targetRef)
+	if err != nil {
+		return errors.Wrap(err, "parse target reference")
+	}
+	targetTagged, ok := reference.TagNameOnly(targetNamed).(reference.Tagged)
+	if !ok {
+		return fmt.Errorf("no tag in target reference %s", targetRef)
+	}
+
+	remoter, err := wf.newRemote(targetNamed.Name())
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	tags, err := remoter.ListTags(ctx, wf.hostsFunc)
+	if err != nil {
+		return errors.Wrap(err, "list tags")
+	}
+
+	matched := []string{}
+	for _, tag := range tags {
+		ref := targetNamed.Name() + ":" + tag
+		// The nydus and OCI targets may share the repository, the tags of
+		// the other format are never matched.
+		isNydus, err := wf.naming.IsNydusRef(ref)
+		if err != nil || isNydus != nydus {
+			continue
+		}
+		if nydus {
+			if ref, err = wf.naming.OCIRef(ref); err != nil {
+				continue
+			}
+		}
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil || named.Name() != templateNamed.Name() {
+			continue
+		}
+		if tagged, ok := named.(reference.Tagged); ok && pattern.MatchString(tagged.Tag()) {
+			matched = append(matched, tag)
+		}
+	}
+
+	_, stale := staleTags(matched, targetTagged.Tag(), keep)
+	if len(stale) == 0 {
+		return nil
+	}
+
+	// Deleting a manifest deletes all tags pointing to it, so the stale
+	// tags sharing manifest with any other tag in the repository, kept or
+	// not matched like `latest`, are skipped.
+	staleSet := map[string]bool{}
+	for _, tag := range stale {
+		staleSet[tag] = true
+	}
+	keptDigests := map[digest.Digest]bool{}
+	for _, tag := range tags {
+		if staleSet[tag] {
+			continue
+		}
+		manifestDigest, err := wf.resolveTag(ctx, targetNamed, tag)
+		if err != nil {
+			return err
+		}
+		keptDigests[manifestDigest] = true
+	}
+	for _, tag := range stale {
+		manifestDigest, err := wf.resolveTag(ctx, targetNamed, tag)
+		if err != nil {
+			return err
+		}
+		if keptDigests[manifestDigest] {
+			logrus.Infof("skip deleting tag %s:%s sharing manifest %s with other tags", targetNamed.Name(), tag, manifestDigest)
+			continue
+		}
+		if err := remoter.DeleteManifest(ctx, wf.hostsFunc, manifestDigest); err != nil {
+			return errors.Wrapf(err, "delete tag %s", tag)
+		}
+		logrus.Infof("deleted tag %s:%s (%s) beyond the latest %d", targetNamed.Name(), tag, manifestDigest, keep)
+	}
+
+	return nil
+}
+
+// resolveTag returns the digest of manifest tagged `tag` in repository
+// `named`.
+func (wf *Workflow) resolveTag(ctx context.Context, named reference.Named, tag string) (digest.Digest, error) {
+	remoter, err := wf.newRemote(named.Name() + ":" + tag)
+	if err != nil {
+		return "", errors.Wrap(err, "create remote")
+	}
+	desc, err := remoter.Resolve(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "resolve tag %s", tag)
+	}
+	return desc.Digest, nil
+}
+
+// staleTags splits `tags` into the latest `keep` ones in natural order,
+// which always include `pushed`, and the stale others.
+func staleTags(tags []string, pushed string, keep int) ([]string, []string) {
+	sorted := append([]string{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return naturalLess(sorted[j], sorted[i])
+	})
+
+	kept := []string{pushed}
+	stale := []string{}
+	for _, tag := range sorted {
+		if tag == pushed {
+			continue
+		}
+		if len(kept) < keep {
+			kept = append(kept, tag)
+		} else {
+			stale = append(stale, tag)
+		}
+	}
+	return kept, stale
+}
+
+// naturalLess compares `a` and `b` by natural order, where the runs of
+// digits are compared by their numeric values, e.g. "v9" < "v10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aDigits, bDigits := leadingDigits(a), leadingDigits(b)
+		if aDigits != "" && bDigits != "" {
+			aNum, aErr := strconv.ParseUint(aDigits, 10, 64)
+			bNum, bErr := strconv.ParseUint(bDigits, 10, 64)
+			if aErr == nil && bErr == nil && aNum != bNum {
+				return aNum < bNum
+			}
+			if aDigits != bDigits {
+				return aDigits < bDigits
+			}
+			a, b = a[len(aDigits):], b[len(bDigits):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	for idx, r := range s {
+		if r < '0' || r > '9' {
+			return s[:idx]
+		}
+	}
+	return s
+}
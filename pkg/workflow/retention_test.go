@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+)
+
+func TestNaturalLess(t *testing.T) {
+	require.True(t, naturalLess("v9", "v10"))
+	require.True(t, naturalLess("20240102-9", "20240102-10"))
+	require.True(t, naturalLess("20240102-10", "20240103-1"))
+	require.True(t, naturalLess("v1", "v1-1"))
+	require.True(t, naturalLess("v01", "v1"))
+	require.False(t, naturalLess("v1", "v1"))
+	require.False(t, naturalLess("b", "a1"))
+}
+
+func TestStaleTags(t *testing.T) {
+	tags := []string{"v-1", "v-10", "v-2", "v-9", "v-11"}
+
+	kept, stale := staleTags(tags, "v-11", 3)
+	require.Equal(t, []string{"v-11", "v-10", "v-9"}, kept)
+	require.Equal(t, []string{"v-2", "v-1"}, stale)
+
+	// The pushed tag is always kept.
+	kept, stale = staleTags(tags, "v-2", 2)
+	require.Equal(t, []string{"v-2", "v-11"}, kept)
+	require.Equal(t, []string{"v-10", "v-9", "v-1"}, stale)
+
+	kept, stale = staleTags([]string{"v-1"}, "v-1", 1)
+	require.Equal(t, []string{"v-1"}, kept)
+	require.Empty(t, stale)
+}
+
+func TestRetainTagsOf(t *testing.T) {
+	// The nydus and OCI tags share the repository, `v0` shares manifest
+	// with the unmatched `latest`.
+	manifests := map[string]digest.Digest{
+		"v0":          digest.FromString("v0"),
+		"latest":      digest.FromString("v0"),
+		"v1":          digest.FromString("v1"),
+		"v1_nydus_v2": digest.FromString("v1_nydus_v2"),
+		"v2":          digest.FromString("v2"),
+		"v2_nydus_v2": digest.FromString("v2_nydus_v2"),
+		"v3":          digest.FromString("v3"),
+		"v3_nydus_v2": digest.FromString("v3_nydus_v2"),
+	}
+	var mu sync.Mutex
+	var deleted []digest.Digest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v2/library/test/tags/list" {
+			tags := []string{}
+			for tag := range manifests {
+				tags = append(tags, tag)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "library/test", "tags": tags})
+			return
+		}
+		ref := strings.TrimPrefix(req.URL.Path, "/v2/library/test/manifests/")
+		if req.Method == http.MethodDelete {
+			mu.Lock()
+			deleted = append(deleted, digest.Digest(ref))
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if manifestDigest, ok := manifests[ref]; ok {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+			w.Header().Set("Content-Length", "8")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	template := host + "/library/test:v{{.Sequence}}"
+
+	wf := &Workflow{cfg: &config.Config{}, naming: distribution.DefaultNaming}
+	require.NoError(t, wf.retainTagsOf(context.Background(), template, host+"/library/test:v3", false, 2))
+	require.Equal(t, []digest.Digest{manifests["v1"]}, deleted)
+
+	deleted = nil
+	require.NoError(t, wf.retainTagsOf(context.Background(), template, host+"/library/test:v3_nydus_v2", true, 2))
+	require.Equal(t, []digest.Digest{manifests["v1_nydus_v2"]}, deleted)
+}
@@ -0,0 +1,205 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/scan"
+)
+
+// newSecretScanner returns the scanner of secrets and the policy applied
+// to its findings, the scanner is nil if secrets aren't scanned.
+func newSecretScanner(cfg *config.SecretScan) (scan.Scanner, scan.Policy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	policy, err := scan.ParsePolicy(cfg.Actions)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch cfg.Scanner {
+	case config.SecretScannerBuiltin:
+		maxFileSize, err := cfg.MaxFileSizeBytes()
+		if err != nil {
+			return nil, nil, err
+		}
+		return scan.NewRegexScanner(maxFileSize), policy, nil
+	case config.SecretScannerExec:
+		return scan.NewExecScanner(cfg.Command, cfg.Args), policy, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// scanLayer tees the tar stream of layer `name` written to `w` into the
+// secret scanner, the returned scan writer is nil if secrets aren't
+// scanned.
+func (wf *Workflow) scanLayer(ctx context.Context, name string, w io.Writer) (io.Writer, *scan.Writer) {
+	if wf.secretScanner == nil {
+		return w, nil
+	}
+	sw := scan.NewWriter(ctx, wf.secretScanner, name)
+	return io.MultiWriter(w, sw), sw
+}
+
+// checkSecrets waits for the scan of layer `name` by `sw` and applies the
+// policy to its findings, the blob of layer must not be pushed if failed.
+func (wf *Workflow) checkSecrets(name string, sw *scan.Writer) error {
+	if sw == nil {
+		return nil
+	}
+	findings, err := sw.Close()
+	if err != nil {
+		return err
+	}
+	logrus.Infof("scanned %s for secrets, %d found", name, len(findings))
+	return errors.Wrap(wf.secretPolicy.Check(name, findings), "check secrets")
+}
+
+// abortScan aborts the scan of layer by `sw` with `err` if any.
+func abortScan(sw *scan.Writer, err error) {
+	if sw != nil && err != nil {
+		sw.CloseWithError(err)
+	}
+}
+
+// newVulnScanner returns the scanner of pushed images, nil if images
+// aren't scanned.
+func newVulnScanner(cfg *config.VulnScan) (scan.ImageScanner, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Scanner {
+	case config.VulnScannerTrivy:
+		return scan.NewTrivyScanner(cfg.Trivy.Path, cfg.Trivy.Server, cfg.Trivy.Token), nil
+	case config.VulnScannerExec:
+		return scan.NewExecImageScanner(cfg.Command, cfg.Args), nil
+	default:
+		return nil, nil
+	}
+}
+
+// checkScanTargets refuses to commit without OCI targets if the images
+// are scanned for vulnerabilities, as the layers of nydus images are not
+// tar and can't be scanned, so the vulnerable images would pass.
+func (wf *Workflow) checkScanTargets(opt CommitOption) error {
+	if wf.vulnScanner == nil || len(opt.targets(FormatOCI)) > 0 {
+		return nil
+	}
+	return fmt.Errorf("scan.vulnerabilities requires an OCI target, the nydus images can't be scanned")
+}
+
+// scanStage scans the pushed OCI images by digest for vulnerabilities, the
+// images exceeding the threshold fail the commit, and their manifests are
+// deleted if configured. The nydus images are not scanned, their OCI
+// counterparts of the same commit are.
+func (wf *Workflow) scanStage(ctx context.Context, state *CommitState) error {
+	if wf.vulnScanner == nil {
+		return nil
+	}
+	cfg := wf.cfg.Scan.Vulnerabilities
+	timeout, err := cfg.TimeoutDuration()
+	if err != nil {
+		return err
+	}
+	if err := wf.checkScanTargets(state.Option); err != nil {
+		return err
+	}
+
+	refs := []string{}
+	for _, target := range state.Option.targets(FormatOCI) {
+		if _, ok := state.ManifestDigests[target.Ref]; ok {
+			refs = append(refs, target.Ref)
+		}
+	}
+	sort.Strings(refs)
+	exceeded := []string{}
+	for _, ref := range refs {
+		manifestDigest := state.ManifestDigests[ref]
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return errors.Wrapf(err, "parse reference %s", ref)
+		}
+		image := scan.Image{Ref: fmt.Sprintf("%s@%s", named.Name(), manifestDigest)}
+		if image.Username, image.Password, err = wf.credFunc(reference.Domain(named)); err != nil {
+			return errors.Wrapf(err, "get credentials of %s", ref)
+		}
+		if tokenFunc := wf.bearerToken(); tokenFunc != nil {
+			if image.Token, err = tokenFunc(reference.Domain(named)); err != nil {
+				return errors.Wrapf(err, "get token of %s", ref)
+			}
+		}
+
+		logrus.Infof("scanning %s for vulnerabilities", image.Ref)
+		var vulns []scan.Vulnerability
+		if err := state.Timings.Time("scan "+ref, func() error {
+			scanCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			vulns, err = wf.vulnScanner.ScanImage(scanCtx, image)
+			return err
+		}); err != nil {
+			return errors.Wrapf(err, "scan %s", image.Ref)
+		}
+		logrus.Infof("scanned %s, vulnerabilities: %s", image.Ref, scan.Summary(vulns))
+
+		if cfg.Threshold == "" {
+			continue
+		}
+		matched := scan.AtOrAbove(vulns, scan.Severity(cfg.Threshold))
+		if len(matched) == 0 {
+			continue
+		}
+		for _, vuln := range matched {
+			logrus.Errorf("vulnerability found in %s: %s", ref, vuln)
+		}
+		exceeded = append(exceeded, ref)
+		if cfg.DeleteTag {
+			if err := wf.deleteManifest(ctx, named, manifestDigest); err != nil {
+				logrus.WithError(err).Errorf("delete %s", image.Ref)
+			} else {
+				logrus.Infof("deleted %s exceeding vulnerability threshold %s", image.Ref, cfg.Threshold)
+			}
+		}
+	}
+
+	if len(exceeded) > 0 {
+		// The nydus images of the commit have the same content as the
+		// vulnerable OCI images.
+		for _, ref := range state.NydusTargetRefs {
+			manifestDigest, ok := state.ManifestDigests[ref]
+			if !cfg.DeleteTag || !ok {
+				continue
+			}
+			named, err := reference.ParseNormalizedNamed(ref)
+			if err != nil {
+				return errors.Wrapf(err, "parse reference %s", ref)
+			}
+			if err := wf.deleteManifest(ctx, named, manifestDigest); err != nil {
+				logrus.WithError(err).Errorf("delete %s@%s", named.Name(), manifestDigest)
+			} else {
+				logrus.Infof("deleted %s@%s of vulnerable commit", named.Name(), manifestDigest)
+			}
+		}
+		return errors.Wrapf(ErrVulnerabilities, "%s or above in %s", cfg.Threshold, strings.Join(exceeded, ", "))
+	}
+	return nil
+}
+
+// deleteManifest deletes the manifest of `manifestDigest` in repository
+// `named`, all tags pointing to it are deleted too.
+func (wf *Workflow) deleteManifest(ctx context.Context, named reference.Named, manifestDigest digest.Digest) error {
+	remoter, err := wf.newRemote(named.Name())
+	if err != nil {
+		return errors.Wrap(err, "create remote")
+	}
+	return remoter.DeleteManifest(ctx, wf.hostsFunc, manifestDigest)
+}
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/scan"
+)
+
+type fakeImageScanner map[string][]scan.Vulnerability
+
+func (s fakeImageScanner) ScanImage(ctx context.Context, image scan.Image) ([]scan.Vulnerability, error) {
+	return s[image.Ref], nil
+}
+
+func TestScanStage(t *testing.T) {
+	appDigest, dbDigest := digest.FromString("app"), digest.FromString("db")
+	scanner := fakeImageScanner{
+		"localhost:5000/app@" + appDigest.String(): {
+			{ID: "CVE-2023-38545", Severity: scan.SeverityCritical, Package: "curl"},
+			{ID: "CVE-2023-0001", Severity: scan.SeverityLow, Package: "busybox"},
+		},
+		"localhost:5000/db@" + dbDigest.String(): {
+			{ID: "CVE-2023-0002", Severity: scan.SeverityMedium, Package: "openssl"},
+		},
+	}
+	cfg := &config.Config{}
+	cfg.Scan.Vulnerabilities = config.VulnScan{Scanner: config.VulnScannerExec, Command: "/bin/scanner"}
+	wf := &Workflow{cfg: cfg, vulnScanner: scanner}
+	state := &CommitState{
+		Option: CommitOption{Targets: []Target{
+			{Ref: "localhost:5000/app:v1", Format: FormatOCI},
+			{Ref: "localhost:5000/db:v1", Format: FormatOCI},
+		}},
+		ManifestDigests: map[string]digest.Digest{
+			"localhost:5000/app:v1": appDigest,
+			"localhost:5000/db:v1":  dbDigest,
+		},
+	}
+
+	// The vulnerabilities are only logged without threshold.
+	require.NoError(t, wf.scanStage(context.Background(), state))
+
+	cfg.Scan.Vulnerabilities.Threshold = "medium"
+	err := wf.scanStage(context.Background(), state)
+	require.ErrorIs(t, err, ErrVulnerabilities)
+	require.EqualError(t, err, "medium or above in localhost:5000/app:v1, localhost:5000/db:v1: vulnerabilities found")
+	require.Equal(t, 20, ExitCode(err))
+
+	cfg.Scan.Vulnerabilities.Threshold = "critical"
+	require.EqualError(t, wf.scanStage(context.Background(), state), "critical or above in localhost:5000/app:v1: vulnerabilities found")
+
+	// The images aren't scanned if not configured.
+	require.NoError(t, (&Workflow{cfg: cfg}).scanStage(context.Background(), state))
+}
+
+func TestScanStageNydusTarget(t *testing.T) {
+	ociDigest, nydusDigest := digest.FromString("oci"), digest.FromString("nydus")
+	scanner := fakeImageScanner{
+		"localhost:5000/app@" + ociDigest.String(): {
+			{ID: "CVE-2023-38545", Severity: scan.SeverityCritical, Package: "curl"},
+		},
+		// The nydus image has no packages found in its layers.
+		"localhost:5000/app@" + nydusDigest.String(): {},
+	}
+	cfg := &config.Config{}
+	cfg.Scan.Vulnerabilities = config.VulnScan{Scanner: config.VulnScannerExec, Command: "/bin/scanner", Threshold: "critical"}
+	wf := &Workflow{cfg: cfg, vulnScanner: scanner}
+	state := &CommitState{
+		Option: CommitOption{Targets: []Target{
+			{Ref: "localhost:5000/app:v1", Format: FormatOCI},
+			{Ref: "localhost:5000/app:v1", Format: FormatNydus},
+		}},
+		NydusTargetRefs: []string{"localhost:5000/app:v1_nydus_v2"},
+		ManifestDigests: map[string]digest.Digest{
+			"localhost:5000/app:v1":          ociDigest,
+			"localhost:5000/app:v1_nydus_v2": nydusDigest,
+		},
+	}
+
+	// Only the OCI image is scanned, so the vulnerability is found.
+	require.EqualError(t, wf.scanStage(context.Background(), state), "critical or above in localhost:5000/app:v1: vulnerabilities found")
+
+	// The commit with only nydus targets is refused before pushing.
+	state.Option.Targets = state.Option.targets(FormatNydus)
+	require.ErrorContains(t, wf.checkScanTargets(state.Option), "requires an OCI target")
+	require.ErrorContains(t, wf.scanStage(context.Background(), state), "requires an OCI target")
+	require.NoError(t, (&Workflow{cfg: cfg}).checkScanTargets(state.Option))
+}
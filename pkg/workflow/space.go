@@ -0,0 +1,110 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/nsenter"
+)
+
+// dirSize returns the total size of regular files in `dir`.
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	return size, err
+}
+
+// containerDirSize returns the total size of `dirs` in the mount namespace
+// of container process `containerPid`.
+func containerDirSize(ctx context.Context, containerPid int, dirs []string) (uint64, error) {
+	config := &nsenter.Config{
+		Mount:  true,
+		Target: containerPid,
+	}
+
+	var stdout bytes.Buffer
+	args := append([]string{"-s", "-c", "-b", "--"}, dirs...)
+	stderr, err := config.ExecuteContext(ctx, &stdout, "du", args...)
+	if err != nil {
+		return 0, errors.Wrapf(err, "execute du: %s", strings.TrimSpace(stderr))
+	}
+
+	// The last line is the grand total in format `<bytes>\ttotal`.
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected du output: %s", stdout.String())
+	}
+	return strconv.ParseUint(fields[0], 10, 64)
+}
+
+// estimateSize estimates the bytes written into work dir by committing
+// the upper dir and mounts, the data is written once for nydus blob unless
+// pushed by stream, and once more for OCI layer if there are OCI targets.
+func (wf *Workflow) estimateSize(ctx context.Context, opt CommitOption, containerPid int, upperDir string) (uint64, error) {
+	copies := uint64(0)
+	if !opt.StreamPush {
+		copies++
+	}
+	if len(opt.targets(FormatOCI)) > 0 {
+		copies++
+	}
+	// Only the tail of blob is written with stream push, which is small.
+	if copies == 0 {
+		return 0, nil
+	}
+
+	size, err := dirSize(upperDir)
+	if err != nil {
+		return 0, errors.Wrapf(err, "calculate size of upper dir %s", upperDir)
+	}
+
+	if len(opt.WithPaths) > 0 {
+		mountSize, err := containerDirSize(ctx, containerPid, opt.WithPaths)
+		if err != nil {
+			return 0, errors.Wrap(err, "calculate size of mounts")
+		}
+		size += mountSize
+	}
+
+	return size * copies, nil
+}
+
+// checkSpace fails fast if the estimated size of committed data can't fit
+// in the work dir, the check is skipped with a warning if the size can't
+// be estimated, or if it's disabled by option.
+func (wf *Workflow) checkSpace(ctx context.Context, opt CommitOption, containerPid int, upperDir string) error {
+	if opt.SkipSpaceCheck {
+		logrus.Info("skip work dir space check as disabled")
+		return nil
+	}
+	required, err := wf.estimateSize(ctx, opt, containerPid, upperDir)
+	if err != nil {
+		logrus.WithError(err).Warn("skip work dir space check")
+		return nil
+	}
+	logrus.Infof("estimated work dir usage: %s", humanize.IBytes(required))
+	return wf.quota.Check(wf.workDir, required)
+}
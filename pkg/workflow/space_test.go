@@ -0,0 +1,42 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/workdir"
+)
+
+func TestEstimateSize(t *testing.T) {
+	upperDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(upperDir, "file"), make([]byte, 1000), 0644))
+	wf := &Workflow{workDir: t.TempDir(), quota: workdir.NewQuota(0)}
+	ctx := context.Background()
+	nydus := []Target{{Ref: "localhost:5000/app:v1", Format: FormatNydus}}
+	both := append([]Target{{Ref: "localhost:5000/app:v1", Format: FormatOCI}}, nydus...)
+
+	size, err := wf.estimateSize(ctx, CommitOption{Targets: nydus}, 0, upperDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), size)
+	size, err = wf.estimateSize(ctx, CommitOption{Targets: both}, 0, upperDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2000), size)
+
+	// The data of nydus blob isn't written to work dir by stream push.
+	size, err = wf.estimateSize(ctx, CommitOption{Targets: nydus, StreamPush: true}, 0, upperDir)
+	require.NoError(t, err)
+	require.Zero(t, size)
+	size, err = wf.estimateSize(ctx, CommitOption{Targets: both, StreamPush: true}, 0, upperDir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1000), size)
+
+	// The space isn't checked if disabled.
+	wf.quota = workdir.NewQuota(500)
+	require.ErrorContains(t, wf.checkSpace(ctx, CommitOption{Targets: nydus}, 0, upperDir), "quota too small")
+	require.NoError(t, wf.checkSpace(ctx, CommitOption{Targets: nydus, SkipSpaceCheck: true}, 0, upperDir))
+	require.NoError(t, wf.checkSpace(ctx, CommitOption{Targets: nydus, StreamPush: true}, 0, upperDir))
+}
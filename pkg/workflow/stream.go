@@ -0,0 +1,218 @@
+package workflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+)
+
+// blobStreams pushes the packed blob to the backends of all nydus targets
+// while packing, instead of pushing the blob file after packed.
+type blobStreams struct {
+	pws     []*io.PipeWriter
+	eg      errgroup.Group
+	digests []digest.Digest
+	sizes   []int64
+	tail    *blobTail
+}
+
+func (wf *Workflow) newBlobStreams(ctx context.Context, targetRefs []string) (*blobStreams, io.Writer, error) {
+	bes := []backend.StreamPusher{}
+	seen := map[backend.Backend]bool{}
+	for _, targetRef := range targetRefs {
+		be, err := wf.backend(targetRef)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seen[be] {
+			continue
+		}
+		seen[be] = true
+		sp, ok := be.(backend.StreamPusher)
+		if !ok {
+			return nil, nil, fmt.Errorf("backend of %s doesn't support stream push", targetRef)
+		}
+		bes = append(bes, sp)
+	}
+
+	streams := &blobStreams{
+		digests: make([]digest.Digest, len(bes)),
+		sizes:   make([]int64, len(bes)),
+	}
+	writers := []io.Writer{}
+	for idx := range bes {
+		idx := idx
+		pr, pw := io.Pipe()
+		streams.pws = append(streams.pws, pw)
+		writers = append(writers, pw)
+		streams.eg.Go(func() error {
+			blobDigest, size, err := bes[idx].PushStream(ctx, pr)
+			// Unblock the writer if push failed in the middle.
+			pr.CloseWithError(err)
+			if err != nil {
+				return err
+			}
+			streams.digests[idx] = blobDigest
+			streams.sizes[idx] = size
+			return nil
+		})
+	}
+
+	return streams, io.MultiWriter(writers...), nil
+}
+
+// blobWriter returns the writer which packed blob is written to, it writes
+// the blob to `blob` file and `w`. If stream push is enabled, it also
+// writes to blob streams, and only the tail of blob after the data is
+// written to file.
+func (wf *Workflow) blobWriter(ctx context.Context, opt CommitOption, blob *os.File, blobPath string, w io.Writer) (io.Writer, *blobStreams, error) {
+	if !opt.StreamPush {
+		return io.MultiWriter(wf.quota.Writer(blobPath, blob), w), nil, nil
+	}
+	// The size of blob is unknown until it's pushed.
+	if len(wf.cfg.Routing.Rules) > 0 {
+		return nil, nil, fmt.Errorf("stream push doesn't support routing blobs by size")
+	}
+	targetRefs, err := opt.nydusTargetRefs(wf.naming)
+	if err != nil {
+		return nil, nil, err
+	}
+	streams, sw, err := wf.newBlobStreams(ctx, targetRefs)
+	if err != nil {
+		return nil, nil, err
+	}
+	streams.tail = wf.newBlobTail(blob, blobPath)
+	return io.MultiWriter(streams.tail, w, sw), streams, nil
+}
+
+// finishBlobStreams waits for the blob streams, the blob file has only
+// the tail of blob after data.
+func (wf *Workflow) finishBlobStreams(streams *blobStreams, desc ocispec.Descriptor) error {
+	if streams == nil {
+		return nil
+	}
+	if err := streams.tail.Close(); err != nil {
+		return err
+	}
+	return streams.Close(desc.Digest, desc.Size)
+}
+
+// CloseWithError aborts the pushes with `err`.
+func (s *blobStreams) CloseWithError(err error) {
+	for _, pw := range s.pws {
+		pw.CloseWithError(err)
+	}
+	_ = s.eg.Wait()
+}
+
+// Close finishes the pushes and checks the pushed blobs are the same as
+// the local one.
+func (s *blobStreams) Close(expected digest.Digest, size int64) error {
+	for _, pw := range s.pws {
+		pw.Close()
+	}
+	if err := s.eg.Wait(); err != nil {
+		return errors.Wrap(err, "push blob stream")
+	}
+	for idx := range s.digests {
+		if s.digests[idx] != expected || s.sizes[idx] != size {
+			return fmt.Errorf("pushed blob %s (%d bytes) mismatches packed blob %s (%d bytes)", s.digests[idx], s.sizes[idx], expected, size)
+		}
+	}
+	return nil
+}
+
+// blobTail writes the packed blob to work dir except the data of entry
+// image.blob, which is pushed by streams and not needed by merging. Nydus
+// blob is in format `data | tar_header | data | tar_header`, see
+// converter.Pack, where image.blob is the first entry, so everything before
+// its header is skipped, and the rest is written at its offset of blob,
+// leaving a hole in place of the data, the offsets in TOC are still valid.
+type blobTail struct {
+	file *os.File
+	w    io.Writer
+	// offset is the size of blob written so far.
+	offset int64
+	// pending is the end of blob not written yet, which may be the start
+	// of image.blob header.
+	pending []byte
+	found   bool
+}
+
+func (wf *Workflow) newBlobTail(file *os.File, blobPath string) *blobTail {
+	return &blobTail{
+		file: file,
+		w:    wf.quota.Writer(blobPath, file),
+	}
+}
+
+func (t *blobTail) Write(p []byte) (int, error) {
+	if t.found {
+		return t.w.Write(p)
+	}
+	start := t.offset - int64(len(t.pending))
+	t.offset += int64(len(p))
+	buf := append(t.pending, p...)
+
+	name := []byte(converter.EntryBlob + "\x00")
+	for from := 0; ; {
+		idx := bytes.Index(buf[from:], name)
+		if idx < 0 {
+			break
+		}
+		pos := from + idx
+		if len(buf)-pos < blobHeaderSize {
+			// Wait for the rest of header.
+			t.pending = append([]byte{}, buf[pos:]...)
+			return len(p), nil
+		}
+		if isBlobHeader(buf[pos:pos+blobHeaderSize], start+int64(pos)) {
+			t.found = true
+			t.pending = nil
+			if _, err := t.file.Seek(start+int64(pos), io.SeekStart); err != nil {
+				return 0, errors.Wrap(err, "seek to blob header")
+			}
+			if _, err := t.w.Write(buf[pos:]); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+		from = pos + 1
+	}
+
+	// Keep the end which may be the start of a name split across writes.
+	keep := len(name) - 1
+	if keep > len(buf) {
+		keep = len(buf)
+	}
+	t.pending = append([]byte{}, buf[len(buf)-keep:]...)
+	return len(p), nil
+}
+
+// Close checks the blob data has been skipped by its header.
+func (t *blobTail) Close() error {
+	if !t.found {
+		return fmt.Errorf("no %s entry in packed blob", converter.EntryBlob)
+	}
+	return nil
+}
+
+const blobHeaderSize = 512
+
+// isBlobHeader checks whether `block` is the tar header of image.blob at
+// `offset`, whose data is all before it.
+func isBlobHeader(block []byte, offset int64) bool {
+	hdr, err := tar.NewReader(bytes.NewReader(block)).Next()
+	return err == nil && hdr.Name == converter.EntryBlob && hdr.Size == offset
+}
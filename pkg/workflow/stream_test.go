@@ -0,0 +1,73 @@
+package workflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/content/local"
+	"github.com/containerd/nydus-snapshotter/pkg/converter"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func nydusEntry(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0444,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}))
+	return append(append([]byte{}, data...), buf.Bytes()[:512]...)
+}
+
+func TestBlobTail(t *testing.T) {
+	wf := &Workflow{cfg: &config.Config{}, workDir: t.TempDir(), fileMode: 0600}
+	blobPath := filepath.Join(wf.workDir, "blob-upper")
+	file, err := wf.createFile(blobPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	// The data containing the name of entry is not taken as its header.
+	blobData := append(bytes.Repeat([]byte("data"), 1024*1024), []byte(converter.EntryBlob+"\x00")...)
+	blobData = append(blobData, bytes.Repeat([]byte("data"), 1024)...)
+	bootstrap := []byte("bootstrap")
+	blob := append(nydusEntry(t, converter.EntryBlob, blobData), nydusEntry(t, converter.EntryBootstrap, bootstrap)...)
+
+	tail := wf.newBlobTail(file, blobPath)
+	for len(blob) > 0 {
+		n := 1000
+		if n > len(blob) {
+			n = len(blob)
+		}
+		_, err := tail.Write(blob[:n])
+		require.NoError(t, err)
+		blob = blob[n:]
+	}
+	require.NoError(t, tail.Close())
+
+	// The data is a hole of blob file.
+	info, err := os.Stat(blobPath)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(blobData)+512+len(bootstrap)+512), info.Size())
+	require.Less(t, info.Sys().(*syscall.Stat_t).Blocks*512, int64(len(blobData)))
+
+	ra, err := local.OpenReader(blobPath)
+	require.NoError(t, err)
+	defer ra.Close()
+	var unpacked bytes.Buffer
+	_, err = converter.UnpackEntry(ra, converter.EntryBootstrap, &unpacked)
+	require.NoError(t, err)
+	require.Equal(t, bootstrap, unpacked.Bytes())
+
+	// The blob without data entry is invalid.
+	tail = wf.newBlobTail(file, blobPath)
+	_, err = tail.Write(nydusEntry(t, converter.EntryBootstrap, bootstrap))
+	require.NoError(t, err)
+	require.Error(t, tail.Close())
+}
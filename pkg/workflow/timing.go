@@ -0,0 +1,113 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Formats of the summary written at the end of commit.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// PhaseTiming is the elapsed time of a phase of commit, e.g. the pack of a
+// blob or the push of a manifest.
+type PhaseTiming struct {
+	Phase   string        `json:"phase"`
+	Elapsed time.Duration `json:"elapsed"`
+	start   time.Time
+}
+
+// Timings collects the phases of commit, which may be timed concurrently.
+type Timings struct {
+	mutex  sync.Mutex
+	phases []PhaseTiming
+}
+
+// Time runs `fn` as `phase` and records its elapsed time, even if failed.
+func (t *Timings) Time(phase string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	t.Record(phase, start, time.Since(start))
+	return err
+}
+
+// Record records `phase` started at `start`.
+func (t *Timings) Record(phase string, start time.Time, elapsed time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.phases = append(t.phases, PhaseTiming{Phase: phase, Elapsed: elapsed, start: start})
+}
+
+// Phases returns the recorded phases in the order of start.
+func (t *Timings) Phases() []PhaseTiming {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	phases := append([]PhaseTiming{}, t.phases...)
+	sort.SliceStable(phases, func(i, j int) bool {
+		return phases[i].start.Before(phases[j].start)
+	})
+	return phases
+}
+
+// CommitSummary is written at the end of commit with the timing of each
+// phase, so regressions in specific phases are easy to spot.
+type CommitSummary struct {
+	Container string `json:"container"`
+	// Manifests are the digests of manifests keyed by the pushed
+	// references.
+	Manifests map[string]digest.Digest `json:"manifests,omitempty"`
+	// NoChanges is set if the image isn't pushed as nothing changed.
+	NoChanges bool          `json:"no_changes,omitempty"`
+	Phases    []PhaseTiming `json:"phases"`
+	Elapsed   time.Duration `json:"elapsed"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Print writes the phases of summary as a table.
+func (s *CommitSummary) Print(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tELAPSED")
+	for _, phase := range s.Phases {
+		fmt.Fprintf(tw, "%s\t%s\n", phase.Phase, phase.Elapsed.Round(time.Millisecond))
+	}
+	fmt.Fprintf(tw, "total\t%s\n", s.Elapsed.Round(time.Millisecond))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if s.NoChanges {
+		_, err := fmt.Fprintln(writer, "no changes")
+		return err
+	}
+	return nil
+}
+
+// WriteJSON writes summary in json.
+func (s *CommitSummary) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+// summary returns the summary of commit `state` finished with `err`.
+func (state *CommitState) summary(err error) *CommitSummary {
+	summary := &CommitSummary{
+		Container: state.Option.ContainerIDWithType,
+		Manifests: state.ManifestDigests,
+		NoChanges: state.NoChanges,
+		Phases:    state.Timings.Phases(),
+		Elapsed:   time.Since(state.StartedAt),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
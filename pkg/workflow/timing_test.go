@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimings(t *testing.T) {
+	timings := Timings{}
+	start := time.Now()
+	timings.Record("push blob-upper", start.Add(-time.Second), time.Second)
+	timings.Record("pack blob-upper", start.Add(-2*time.Second), time.Second)
+	err := timings.Time("inspect", func() error {
+		return fmt.Errorf("container not found")
+	})
+	require.EqualError(t, err, "container not found")
+
+	phases := []string{}
+	for _, phase := range timings.Phases() {
+		phases = append(phases, phase.Phase)
+	}
+	require.Equal(t, []string{"pack blob-upper", "push blob-upper", "inspect"}, phases)
+}
+
+func TestCommitSummary(t *testing.T) {
+	summary := &CommitSummary{
+		Container: "docker://c1",
+		Manifests: map[string]digest.Digest{
+			"localhost:5000/nginx:committed_nydus_v2": digest.FromString("manifest"),
+		},
+		Phases: []PhaseTiming{
+			{Phase: "inspect", Elapsed: 12 * time.Millisecond},
+			{Phase: "pack blob-upper", Elapsed: 2500 * time.Millisecond},
+			{Phase: "push manifest localhost:5000/nginx:committed_nydus_v2", Elapsed: 1234567 * time.Microsecond},
+		},
+		Elapsed: 4 * time.Second,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeSummary(&buf, OutputText, summary))
+	require.Equal(t, `PHASE                                                  ELAPSED
+inspect                                                12ms
+pack blob-upper                                        2.5s
+push manifest localhost:5000/nginx:committed_nydus_v2  1.235s
+total                                                  4s
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeSummary(&buf, OutputJSON, summary))
+	decoded := CommitSummary{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, *summary, decoded)
+
+	require.EqualError(t, writeSummary(&buf, "yaml", summary), "invalid output format yaml")
+
+	buf.Reset()
+	noChanges := &CommitSummary{Container: "docker://c1", NoChanges: true, Elapsed: time.Second}
+	require.NoError(t, writeSummary(&buf, OutputText, noChanges))
+	require.Equal(t, `PHASE  ELAPSED
+total  1s
+no changes
+`, buf.String())
+}
@@ -1,16 +1,28 @@
 package workflow
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/containerd/containerd/content"
+	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
 	"github.com/nydusaccelerator/nydus-cli/pkg/nsenter"
+	"github.com/nydusaccelerator/nydus-cli/pkg/progress"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
 
+	"github.com/dustin/go-humanize"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 type Counter struct {
@@ -26,19 +38,290 @@ func (c *Counter) Size() (n int64) {
 	return c.n
 }
 
-func copyFromContainer(ctx context.Context, containerPid int, source string, target io.Writer) error {
+// syncFs flushes the filesystem containing `dir` by syncfs(2), so the data
+// recently written by container and still in page cache is durable before
+// it's committed.
+func syncFs(dir string) error {
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return errors.Wrapf(err, "open %s", dir)
+	}
+	defer unix.Close(fd)
+	return errors.Wrapf(unix.Syncfs(fd), "syncfs %s", dir)
+}
+
+// newProgress starts reporting the progress of `name` with the estimated
+// size `total`, 0 if unknown.
+func (wf *Workflow) newProgress(name string, total int64) *progress.Reporter {
+	return progress.New(name, total, wf.progress, os.Stderr)
+}
+
+// progressReaderAt reports the bytes read from blob while pushing, the
+// retried reads are counted again.
+type progressReaderAt struct {
+	content.ReaderAt
+	reporter *progress.Reporter
+}
+
+func (r *progressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.reporter.Write(p[:n])
+	return n, err
+}
+
+// bytesReaderAt reads the blob in memory as content.ReaderAt.
+type bytesReaderAt struct {
+	*bytes.Reader
+}
+
+func (r *bytesReaderAt) Close() error {
+	return nil
+}
+
+type copyOption struct {
+	// Sort entries by name instead of directory order to make the tar
+	// stream reproducible.
+	sortByName bool
+	// Kill tar if it streams nothing for the duration, 0 disables it.
+	stallTimeout time.Duration
+	// Fail on the sockets ignored by tar.
+	failOnSocket bool
+	// Fail on the files tar fails to read.
+	failOnUnreadable bool
+	// Archive the owners by uid and gid without names.
+	numericOwner bool
+	// Don't archive POSIX ACLs.
+	noACLs bool
+	// Archive SELinux contexts.
+	selinux bool
+}
+
+// checkSockets fails on the sockets reported in `stderr` of tar as
+// ignored if failOnSocket is set.
+func checkSockets(stderr string, opt copyOption) error {
+	if !opt.failOnSocket {
+		return nil
+	}
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasSuffix(line, ": socket ignored") {
+			return fmt.Errorf("%w: %s", tarstream.ErrSpecialFile, strings.TrimPrefix(line, "tar: "))
+		}
+	}
+	return nil
+}
+
+// copyProgressInterval is the interval of logging the bytes copied from
+// container, which tells a slow copy from a stalled one.
+var copyProgressInterval = time.Minute
+
+// tarArgs returns the arguments of tar command executed in container,
+// hardlinks are kept by tar itself, `--sparse` avoids expanding holes,
+// `--acls` and `--xattrs-include` keep POSIX ACLs and security.capability
+// which are not archived by `--xattrs` alone.
+func tarArgs(sources []string, opt copyOption) []string {
+	args := []string{"--xattrs", "--xattrs-include=*", "--sparse"}
+	if !opt.noACLs {
+		args = append(args, "--acls")
+	}
+	if opt.selinux {
+		args = append(args, "--selinux")
+	}
+	if opt.numericOwner {
+		args = append(args, "--numeric-owner")
+	}
+	if opt.sortByName {
+		args = append(args, "--sort=name")
+	}
+	args = append(args, "--ignore-failed-read", "--absolute-names", "-cf", "-")
+	return append(args, sources...)
+}
+
+func copyFromContainer(ctx context.Context, containerPid int, sources []string, target io.Writer, opt copyOption) error {
 	config := &nsenter.Config{
-		Mount:  true,
-		Target: containerPid,
+		Mount:            true,
+		Target:           containerPid,
+		Name:             "tar " + strings.Join(sources, " "),
+		StallTimeout:     opt.stallTimeout,
+		ProgressInterval: copyProgressInterval,
 	}
 
-	stderr, err := config.ExecuteContext(ctx, target, "tar", "--xattrs", "--ignore-failed-read", "--absolute-names", "-cf", "-", source)
+	stderr, err := config.ExecuteContext(ctx, target, "tar", tarArgs(sources, opt)...)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("execute tar: %s", strings.TrimSpace(stderr)))
 	}
+	if err := checkSockets(stderr, opt); err != nil {
+		return err
+	}
 	if stderr != "" {
 		logrus.Warnf("from container: %s", stderr)
 	}
 
-	return nil
+	return checkSkipped(stderr, opt)
+}
+
+func chown(path string, cfg *config.Artifact) error {
+	if cfg.UID == nil && cfg.GID == nil {
+		return nil
+	}
+	uid, gid := -1, -1
+	if cfg.UID != nil {
+		uid = *cfg.UID
+	}
+	if cfg.GID != nil {
+		gid = *cfg.GID
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+const maxSymlinks = 255
+
+// secureJoin joins `unsafePath` to `root` and resolves the symlinks in it
+// as if `root` is the filesystem root, so that the result never escapes
+// from `root`. Components not existing are joined as is.
+func secureJoin(root, unsafePath string) (string, error) {
+	resolved := "/"
+	remaining := unsafePath
+	links := 0
+	for remaining != "" {
+		var part string
+		if idx := strings.Index(remaining, "/"); idx != -1 {
+			part, remaining = remaining[:idx], remaining[idx+1:]
+		} else {
+			part, remaining = remaining, ""
+		}
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// Never goes above root as `resolved` is an absolute path.
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, part)
+		fullPath := filepath.Join(root, next)
+		info, err := os.Lstat(fullPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "stat %s", fullPath)
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", unsafePath)
+		}
+		target, err := os.Readlink(fullPath)
+		if err != nil {
+			return "", errors.Wrapf(err, "read link %s", fullPath)
+		}
+		// Resolve the link target in place of the link.
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+
+	return filepath.Join(root, resolved), nil
+}
+
+// openInRoot opens `unsafePath` in `root` as an O_PATH file, resolving the
+// symlinks in it as if `root` is the filesystem root like secureJoin, but
+// the resolving and opening are atomic, so the opened file is never out of
+// `root` even if the components are swapped while resolving.
+func openInRoot(root, unsafePath string) (*os.File, error) {
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", root)
+	}
+	defer unix.Close(rootFd)
+
+	fd, err := unix.Openat2(rootFd, unsafePath, &unix.OpenHow{
+		Flags:   unix.O_PATH | unix.O_CLOEXEC,
+		Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+	})
+	if err == unix.ENOSYS {
+		return openInRootByWalk(rootFd, root, unsafePath)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s in %s", unsafePath, root)
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, unsafePath)), nil
+}
+
+// openInRootByWalk is openInRoot on kernels without openat2(2) (< 5.6),
+// the path resolved by secureJoin is opened component by component from
+// `rootFd` without following symlinks, so a component swapped for a
+// symlink after resolved fails the open instead of escaping.
+func openInRootByWalk(rootFd int, root, unsafePath string) (*os.File, error) {
+	resolved, err := secureJoin(root, unsafePath)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get rel path of %s", resolved)
+	}
+
+	fd, err := unix.Openat(rootFd, ".", unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", root)
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == "." {
+			continue
+		}
+		next, err := unix.Openat(fd, part, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+		if err != nil {
+			return nil, errors.Wrapf(err, "open %s in %s", part, resolved)
+		}
+		fd = next
+		var stat unix.Stat_t
+		if err := unix.Fstat(fd, &stat); err != nil {
+			unix.Close(fd)
+			return nil, errors.Wrapf(err, "stat %s in %s", part, resolved)
+		}
+		if stat.Mode&unix.S_IFMT == unix.S_IFLNK {
+			unix.Close(fd)
+			return nil, fmt.Errorf("%s in %s is changed to a symlink while resolving", part, resolved)
+		}
+	}
+	return os.NewFile(uintptr(fd), resolved), nil
+}
+
+// pullBlob pulls blob `desc` from `be` into work dir as `name`, verifies
+// its digest and returns its size. The size of `desc` is only used for
+// progress, -1 if unknown.
+func (wf *Workflow) pullBlob(be backend.Backend, desc ocispec.Descriptor, name string) (int64, error) {
+	logrus.Infof("pulling blob %s", desc.Digest)
+	start := time.Now()
+	reader, err := be.Pull(desc.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	blobPath := filepath.Join(wf.workDir, name)
+	file, err := wf.createFile(blobPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "create blob file")
+	}
+	defer file.Close()
+
+	digester := desc.Digest.Algorithm().Digester()
+	reporter := wf.newProgress("pull "+name, desc.Size)
+	defer reporter.Finish()
+	size, err := io.Copy(io.MultiWriter(wf.quota.Writer(blobPath, file), digester.Hash(), reporter), reader)
+	if err != nil {
+		return 0, errors.Wrap(err, "read blob")
+	}
+	if digester.Digest() != desc.Digest {
+		return 0, fmt.Errorf("digest mismatch: %s != %s", digester.Digest(), desc.Digest)
+	}
+	logrus.Infof("pulled blob %s, size: %s, elapsed: %s", desc.Digest, humanize.Bytes(uint64(size)), time.Since(start))
+	return size, nil
 }
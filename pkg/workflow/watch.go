@@ -0,0 +1,132 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	"github.com/nydusaccelerator/nydus-cli/pkg/schedule"
+)
+
+// WatchOption is the option of Watch.
+type WatchOption struct {
+	Schedule schedule.Schedule
+	// Jitter is the maximum random delay added to each scheduled run, so
+	// the commits of a fleet are not pushed at the same time. It's a tenth
+	// of the time until the scheduled run if nil.
+	Jitter *time.Duration
+}
+
+// CheckWatchTargets refuses the target references of commits scheduled by
+// `sched` which repeat across the runs, the target pushed by a run exists
+// on the following runs, which are refused by checkTargets unless forced
+// or pushed by digest. Every run commits on top of the same image of
+// container, so only {{.Timestamp}} differs between runs, and {{.Date}}
+// if the runs are at least a day apart.
+func CheckWatchTargets(opt CommitOption, sched schedule.Schedule) error {
+	if opt.Force || opt.PushByDigest {
+		return nil
+	}
+	next := sched.Next(time.Now())
+	// A day of cron schedule in local time is 23 hours on the switch to
+	// daylight saving time.
+	daily := sched.Next(next).Sub(next) >= 23*time.Hour
+	for _, target := range opt.Targets {
+		ref := target.Ref
+		if target.Template != "" {
+			ref = target.Template
+		}
+		fields, err := distribution.RefTemplateFields(ref)
+		if err != nil {
+			return err
+		}
+		if fields["Timestamp"] || (daily && fields["Date"]) {
+			continue
+		}
+		return fmt.Errorf("target %s repeats across the commits of --interval, use a reference template with {{.Timestamp}} (or {{.Date}} if committed at most daily), or --force", ref)
+	}
+	return nil
+}
+
+// after is replaced in tests.
+var after = time.After
+
+// Watch runs `commit` on schedule until `ctx` is done, the run is skipped
+// if `fingerprint` of committed files is unchanged since the last
+// successful commit. The failed commits are logged and retried on the next
+// run, the fingerprint failure just disables the skipping for the run.
+func Watch(ctx context.Context, opt WatchOption, fingerprint func(context.Context) (digest.Digest, error), commit func(context.Context) error) error {
+	var committed digest.Digest
+	for {
+		now := time.Now()
+		next := opt.Schedule.Next(now)
+		if next.IsZero() {
+			return errors.New("no next run in schedule")
+		}
+		maxJitter := next.Sub(now) / 10
+		if opt.Jitter != nil {
+			maxJitter = *opt.Jitter
+		}
+		next = next.Add(schedule.Jitter(maxJitter))
+		logrus.Infof("next commit at %s", next.Format(time.RFC3339))
+
+		select {
+		case <-ctx.Done():
+			logrus.Infof("stopped watching: %s", ctx.Err())
+			return nil
+		case <-after(time.Until(next)):
+		}
+
+		current, err := fingerprint(ctx)
+		if err != nil {
+			logrus.WithError(err).Warn("fingerprint committed files, commit anyway")
+			current = ""
+		}
+		if current != "" && current == committed {
+			logrus.Infof("skipped commit as nothing changed since last commit")
+			continue
+		}
+		if err := commit(ctx); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			logrus.WithError(err).Error("commit failed, retry on next run")
+			continue
+		}
+		committed = current
+	}
+}
+
+// CommitFingerprint returns the fingerprint of files committed by `opts`,
+// i.e. the upper dirs of containers and sidecars, and the paths to commit
+// by --with-path, see diff.Fingerprint.
+func CommitFingerprint(ctx context.Context, cm *container.Manager, opts []CommitOption) (digest.Digest, error) {
+	dirs := []string{}
+	for _, opt := range opts {
+		inspect, err := cm.Inspect(ctx, opt.ContainerIDWithType)
+		if err != nil {
+			return "", errors.Wrapf(err, "inspect container %s", opt.ContainerIDWithType)
+		}
+		dirs = append(dirs, inspect.UpperDir)
+		for _, withPath := range opt.WithPaths {
+			dirs = append(dirs, filepath.Join("/proc", strconv.Itoa(inspect.Pid), "root", withPath))
+		}
+		for _, sidecar := range opt.Sidecars {
+			inspect, err := cm.Inspect(ctx, sidecar.ContainerIDWithType)
+			if err != nil {
+				return "", errors.Wrapf(err, "inspect sidecar %s", sidecar.Name)
+			}
+			dirs = append(dirs, inspect.UpperDir)
+		}
+	}
+	return diff.Fingerprint(dirs...)
+}
@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	"github.com/nydusaccelerator/nydus-cli/pkg/schedule"
+)
+
+type everySecond struct{}
+
+func (everySecond) Next(t time.Time) time.Time {
+	return t.Add(time.Second)
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	delays := []time.Duration{}
+	after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		ch := make(chan time.Time, 1)
+		if ctx.Err() == nil {
+			ch <- time.Now()
+		}
+		return ch
+	}
+	defer func() {
+		after = time.After
+	}()
+
+	// The fingerprints of runs, the commits of the 2nd and 4th runs are
+	// skipped, and the 5th run retries the failed commit.
+	fingerprints := []digest.Digest{"a", "a", "b", "b", "b", "", "c"}
+	failures := map[int]bool{3: true}
+	runs, commits := 0, []int{}
+	fingerprint := func(context.Context) (digest.Digest, error) {
+		fp := fingerprints[runs]
+		runs++
+		if fp == "" {
+			return "", fmt.Errorf("container not found")
+		}
+		return fp, nil
+	}
+	commit := func(context.Context) error {
+		if runs == len(fingerprints) {
+			cancel()
+		}
+		commits = append(commits, runs)
+		if failures[runs] {
+			return fmt.Errorf("push failed")
+		}
+		return nil
+	}
+
+	jitter := time.Duration(0)
+	require.NoError(t, Watch(ctx, WatchOption{Schedule: everySecond{}, Jitter: &jitter}, fingerprint, commit))
+	require.Equal(t, []int{1, 3, 4, 6, 7}, commits)
+	for _, delay := range delays {
+		require.True(t, delay > 0 && delay <= time.Second, delay)
+	}
+}
+
+func TestCheckWatchTargets(t *testing.T) {
+	hourly, err := schedule.Parse("1h")
+	require.NoError(t, err)
+	daily, err := schedule.Parse("@daily")
+	require.NoError(t, err)
+
+	opt := CommitOption{Targets: []Target{
+		{Ref: "localhost:5000/app:{{.Timestamp}}", Format: FormatNydus},
+		{Ref: "localhost:5000/app:latest", Format: FormatOCI},
+	}}
+	err = CheckWatchTargets(opt, hourly)
+	require.ErrorContains(t, err, "localhost:5000/app:latest")
+
+	// The fixed target is allowed to be overwritten.
+	opt.Force = true
+	require.NoError(t, CheckWatchTargets(opt, hourly))
+	opt.Force = false
+	opt.PushByDigest = true
+	require.NoError(t, CheckWatchTargets(opt, hourly))
+
+	// The sequence is the same in every run on top of the same image, and
+	// the date only differs between daily runs.
+	opt = CommitOption{Targets: []Target{{Ref: "localhost:5000/app:{{.Date}}-{{.Sequence}}", Format: FormatNydus}}}
+	require.ErrorContains(t, CheckWatchTargets(opt, hourly), "{{.Timestamp}}")
+	require.NoError(t, CheckWatchTargets(opt, daily))
+	opt = CommitOption{Targets: []Target{{Ref: "localhost:5000/app:{{.Sequence}}", Format: FormatNydus}}}
+	require.Error(t, CheckWatchTargets(opt, daily))
+	opt = CommitOption{Targets: []Target{{Ref: "localhost:5000/app:{{.Sequence}}-{{.Timestamp}}", Format: FormatNydus}}}
+	require.NoError(t, CheckWatchTargets(opt, hourly))
+}
+
+func TestWatchTargets(t *testing.T) {
+	pushed := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if pushed[req.URL.Path] {
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromString("manifest").String())
+			w.Header().Set("Content-Length", "8")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	clock := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	timeNow = func() time.Time {
+		return clock
+	}
+	defer func() {
+		timeNow = time.Now
+		after = time.After
+	}()
+	sched, err := schedule.Parse("1h")
+	require.NoError(t, err)
+	jitter := time.Duration(0)
+	wf := &Workflow{cfg: &config.Config{}, naming: distribution.DefaultNaming}
+
+	// watch runs two scheduled commits of `template` on top of the same
+	// image, and returns the pushed references and the commit errors.
+	watch := func(template string) ([]string, []error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		runs := 0
+		after = func(d time.Duration) <-chan time.Time {
+			if runs == 2 {
+				cancel()
+			}
+			clock = clock.Add(time.Hour)
+			ch := make(chan time.Time, 1)
+			if ctx.Err() == nil {
+				ch <- clock
+			}
+			return ch
+		}
+		opt := CommitOption{Targets: []Target{{Ref: host + "/library/app:" + template, Format: FormatOCI}}}
+		refs, errs := []string{}, []error{}
+		fingerprint := func(context.Context) (digest.Digest, error) {
+			return digest.FromString(clock.String()), nil
+		}
+		commit := func(ctx context.Context) error {
+			runs++
+			state := &CommitState{Option: opt, Inspect: &container.InspectResult{Image: "nginx:1.25"}}
+			err := wf.expandTargets(state)
+			if err == nil {
+				err = wf.checkTargets(ctx, state)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				return err
+			}
+			for _, target := range state.Option.Targets {
+				tag, err := distribution.Tag(target.Ref)
+				require.NoError(t, err)
+				pushed["/v2/library/app/manifests/"+tag] = true
+				refs = append(refs, target.Ref)
+			}
+			return nil
+		}
+		require.NoError(t, Watch(ctx, WatchOption{Schedule: sched, Jitter: &jitter}, fingerprint, commit))
+		return refs, errs
+	}
+
+	refs, errs := watch("{{.Sequence}}-{{.Timestamp}}")
+	require.Empty(t, errs)
+	require.Equal(t, []string{host + "/library/app:1-20240102160405", host + "/library/app:1-20240102170405"}, refs)
+
+	// The target expanded from sequence exists on the second run.
+	refs, errs = watch("seq-{{.Sequence}}")
+	require.Equal(t, []string{host + "/library/app:seq-1"}, refs)
+	require.Len(t, errs, 1)
+	require.ErrorIs(t, errs[0], ErrTargetExists)
+}
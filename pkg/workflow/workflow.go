@@ -2,10 +2,11 @@ package workflow
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"io"
 	"os"
@@ -17,49 +18,287 @@ import (
 	"github.com/nydusaccelerator/nydus-cli/pkg/backend"
 	"github.com/nydusaccelerator/nydus-cli/pkg/container"
 	"github.com/nydusaccelerator/nydus-cli/pkg/diff"
+	"github.com/nydusaccelerator/nydus-cli/pkg/diff/archive"
 	"github.com/nydusaccelerator/nydus-cli/pkg/distribution"
+	"github.com/nydusaccelerator/nydus-cli/pkg/identity"
+	"github.com/nydusaccelerator/nydus-cli/pkg/metrics"
+	"github.com/nydusaccelerator/nydus-cli/pkg/notify"
 	parserPkg "github.com/nydusaccelerator/nydus-cli/pkg/nydus/parser"
 	"github.com/nydusaccelerator/nydus-cli/pkg/nydus/utils"
+	"github.com/nydusaccelerator/nydus-cli/pkg/progress"
 	"github.com/nydusaccelerator/nydus-cli/pkg/remote"
+	"github.com/nydusaccelerator/nydus-cli/pkg/scan"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+	"github.com/nydusaccelerator/nydus-cli/pkg/workdir"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/containerd/containerd/archive"
 	"github.com/containerd/containerd/content/local"
 	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
 	"github.com/containerd/nydus-snapshotter/pkg/converter"
 	"github.com/dustin/go-humanize"
 	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/credential"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
-const layerAnnotationNydusCommitBlobs = "containerd.io/snapshot/nydus-commit-blobs"
-const layerAnnotationNydusBlobIDs = "containerd.io/snapshot/nydus-blob-ids"
+// fsVersion is the fs version of committed bootstraps.
+const fsVersion = "5"
 
 type Workflow struct {
-	cfg     *config.Config
-	workDir string
-	cm      *container.Manager
-	be      backend.Backend
-	beMutex sync.Mutex
+	cfg      *config.Config
+	workDir  string
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	// Set when work dir is mounted from an ephemeral encrypted device.
+	encrypted *workdir.Encrypted
+	cm        *container.Manager
+	// Backends keyed by target reference, or by "" if the backend is
+	// shared by all targets.
+	bes      map[string]backend.Backend
+	beMutex  sync.Mutex
+	cleanups cleanups
+	// Set when committing with chunk dict.
+	chunkDict *chunkDict
+	quota     *workdir.Quota
+	// Set to keep work dir on destroying for debugging.
+	keepWorkDir bool
+	// Shared by all requests to skip the mirrors already failed.
+	mirrors *remote.Mirrors
+	// Proxy of requests to registries.
+	proxy remote.ProxyFunc
+	// Providers of the credentials of cloud registries, used if not
+	// configured.
+	credentials credential.Providers
+	// Credentials from docker config, used if not configured.
+	dockerCreds remote.CredentialFunc
+	// TLS configs of registries keyed by host.
+	tlsConfigs map[string]*tls.Config
+	// Negotiated schemes of registries, shared by all requests.
+	schemes *remote.Schemes
+	// Registry hosts keyed by plain HTTP, created once so that the clients
+	// and auth tokens of hosts are shared by all requests.
+	registryHosts map[bool]docker.RegistryHosts
+	// Set if the committed images are attested by identity of node.
+	identity identity.Provider
+	// Set if the metrics of commits are written.
+	metrics *metrics.Recorder
+	// Maps the references between OCI images and nydus images.
+	naming *distribution.Naming
+	// Webhooks and publisher notified of the start and result of commits.
+	notifiers []notify.Notifier
+	// How the progress of packing and pushing blobs is displayed, not
+	// displayed if not set.
+	progress progress.Mode
+	// The strategy of copying mount paths, resolved once for the commit.
+	mountStrategy     MountStrategy
+	mountStrategyOnce sync.Once
+	// Scans the committed layers for secrets if set, the findings are
+	// handled by policy.
+	secretScanner scan.Scanner
+	secretPolicy  scan.Policy
+	// Scans the pushed images for vulnerabilities if set.
+	vulnScanner scan.ImageScanner
 }
 
 type Blob struct {
 	Name          string
 	BootstrapName string
 	Desc          ocispec.Descriptor
+	// OCILayer is set only if there are OCI targets.
+	OCILayer *OCILayer
+	// Stats of the committed files, zero for the blobs converted from
+	// base image.
+	Stats tarstream.Stats
+	// Reused is set if the blob is pushed by a previous commit of the
+	// unchanged mount, only its tail with bootstrap is in work dir, see
+	// dedupMount.
+	Reused bool
 }
 
 type CommitOption struct {
 	ContainerIDWithType string
-	TargetRef           string
+	Targets             []Target
 	WithPaths           []string
 	WithoutPaths        []string
 	PauseContainer      bool
-	MaximumTimes        int
+	// PauseMode is how the container is paused if PauseContainer is set.
+	PauseMode    container.PauseMode
+	MaximumTimes int
+	// MaximumTimesPolicy is how the commits are counted against
+	// MaximumTimes, MaximumTimesLayers if not set.
+	MaximumTimesPolicy MaximumTimesPolicy
+	// Ownership rewrites the owner of files in committed layers if set.
+	Ownership *tarstream.Ownership
+	// SourceDateEpoch makes committed layers reproducible if set, see
+	// https://reproducible-builds.org/specs/source-date-epoch.
+	SourceDateEpoch *time.Time
+	// SpecialFiles is the policy of device nodes, FIFOs and sockets in
+	// the upper dir and mount paths, they are kept if not set.
+	SpecialFiles tarstream.SpecialFiles
+	// ChunkDict is the ref of nydus image or the path of bootstrap used as
+	// chunk dict, the chunks of committed blobs existing in it are
+	// deduplicated.
+	ChunkDict string
+	// MaxMountEntries and MaxMountSize cap the entry count and total size
+	// of the tar stream copied from container for each mount, 0 means
+	// no limit.
+	MaxMountEntries int
+	MaxMountSize    int64
+	// MountStrategy is how the mount paths are copied, MountAuto if not
+	// set.
+	MountStrategy MountStrategy
+	// MountStallTimeout aborts the copy of a mount from container if
+	// nothing is copied for the duration, e.g. on a dead network mount,
+	// 0 means no timeout.
+	MountStallTimeout time.Duration
+	// MountNumericOwner archives the owners of files in mount paths by
+	// uid and gid only, without the names resolved in container.
+	MountNumericOwner bool
+	// MountNoACLs doesn't archive the POSIX ACLs of files in mount paths.
+	MountNoACLs bool
+	// MountSELinux archives the SELinux contexts of files in mount paths.
+	MountSELinux bool
+	// MountUnreadable is how the files in mount paths which tar fails to
+	// read are handled, UnreadableWarn if not set.
+	MountUnreadable UnreadablePolicy
+	// StreamPush pushes blobs while packing instead of after packed, the
+	// data of blob is not written to work dir.
+	StreamPush bool
+	// SkipSpaceCheck doesn't check the estimated size of committed data
+	// against the space of work dir before packing.
+	SkipSpaceCheck bool
+	// ReadOnlyUpper makes diff against a read-only bind mount of the upper
+	// dir instead of the upper dir itself, the caller should run the
+	// workflow in a private mount namespace, see mountns.Reexec.
+	ReadOnlyUpper bool
+	// ConvertBase commits the container of OCI image on top of the nydus
+	// image converted from it, see convertBase.
+	ConvertBase bool
+	// CloneUpper makes diff against a reflink clone of the upper dir, the
+	// container is paused only while cloning if PauseContainer is set.
+	CloneUpper bool
+	// Force overwrites the existing target images.
+	Force bool
+	// AllowEmpty pushes the image even if nothing changed since the last
+	// commit in the chain, see CommitState.NoChanges.
+	AllowEmpty bool
+	// PushByDigest pushes the manifests by digest without tagging them.
+	PushByDigest bool
+	// DigestFile is written with the digests of pushed manifests, one line
+	// per target in order, or the ones of base images if nothing changed.
+	DigestFile string
+	// KeepLast keeps only the latest N tags expanded from each templated
+	// target after pushed, 0 means keeping all.
+	KeepLast int
+	// Provenance attaches the SLSA provenance attestation to each pushed
+	// manifest, see pkg/provenance.
+	Provenance bool
+	// Metadata attaches the commit metadata to each pushed manifest, see
+	// CommitMetadata.
+	Metadata bool
+	// Version of nydus-cli recorded in provenance and metadata.
+	Version string
+	// TimeBudget aborts the commit if it isn't finished in time, 0 means
+	// no limit.
+	TimeBudget time.Duration
+	// Output is the format of CommitSummary written to stdout at the end
+	// of commit, OutputText or OutputJSON, not written if empty.
+	Output string
+	// Parallelism bounds the count of concurrent pack and push jobs, 0
+	// means no limit.
+	Parallelism int
+	// DiffWorkers is the count of workers walking the top-level entries of
+	// upper dir concurrently for diff, 0 or 1 walks it serially.
+	DiffWorkers int
+	// ContainerName is the name of container in kubernetes pod, it's
+	// expanded in target templates as {{.ContainerName}}.
+	ContainerName string
+	// Sidecars are the other containers of pod committed along with the
+	// container, see PodCommitOptions.
+	Sidecars []Sidecar
+
+	// pathPrefix moves the committed files under the directory.
+	pathPrefix string
+	// appendDigestFile appends to DigestFile instead of overwriting it,
+	// for the containers of pod committed after the first one.
+	appendDigestFile bool
+}
+
+func (opt *CommitOption) newGroup() *errgroup.Group {
+	eg := errgroup.Group{}
+	if opt.Parallelism > 0 {
+		eg.SetLimit(opt.Parallelism)
+	}
+	return &eg
+}
+
+// nydusTargetRefs returns the references of nydus targets named by
+// `naming`.
+func (opt *CommitOption) nydusTargetRefs(naming *distribution.Naming) ([]string, error) {
+	targetRefs := []string{}
+	for _, target := range opt.targets(FormatNydus) {
+		targetRef, err := naming.NydusRef(target.Ref)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse target image name")
+		}
+		targetRefs = append(targetRefs, targetRef)
+	}
+	return targetRefs, nil
+}
+
+func (opt *CommitOption) targets(format string) []Target {
+	targets := []Target{}
+	for _, target := range opt.Targets {
+		if target.Format == format {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// tarOptions returns the rewrite options applied to all tar streams
+// of committed layers.
+func (opt *CommitOption) tarOptions() []tarstream.Option {
+	opts := []tarstream.Option{}
+	if opt.Ownership != nil {
+		opts = append(opts, tarstream.WithOwnership(*opt.Ownership))
+	}
+	if opt.SourceDateEpoch != nil {
+		opts = append(opts, tarstream.WithSourceDateEpoch(*opt.SourceDateEpoch))
+	}
+	if opt.pathPrefix != "" {
+		opts = append(opts, tarstream.WithPathPrefix(opt.pathPrefix))
+	}
+	if opt.SpecialFiles != "" {
+		opts = append(opts, tarstream.WithSpecialFiles(opt.SpecialFiles))
+	}
+	return opts
+}
+
+// mountTarOptions returns the options rewriting the tar stream of mount
+// `sourceDir`, the sparse files are staged in `tempDir`.
+func (opt *CommitOption) mountTarOptions(sourceDir, tempDir string, stats *tarstream.Stats) []tarstream.Option {
+	return append(opt.tarOptions(), tarstream.WithOpaqueDir(sourceDir), tarstream.WithStats(stats), tarstream.WithTempDir(tempDir), tarstream.WithLimits(tarstream.Limits{
+		Root:       sourceDir,
+		MaxEntries: opt.MaxMountEntries,
+		MaxSize:    opt.MaxMountSize,
+	}))
+}
+
+// filesTarOptions returns the options of tar stream of the files copied
+// from container by commitFiles, which are replaced one by one without
+// opaque dir.
+func (opt *CommitOption) filesTarOptions(tempDir string, stats *tarstream.Stats) []tarstream.Option {
+	return append(opt.tarOptions(), tarstream.WithStats(stats), tarstream.WithTempDir(tempDir), tarstream.WithLimits(tarstream.Limits{
+		MaxEntries: opt.MaxMountEntries,
+		MaxSize:    opt.MaxMountSize,
+	}))
 }
 
 func calcDigest(path string) (string, error) {
@@ -79,63 +318,273 @@ func calcDigest(path string) (string, error) {
 	return digest, nil
 }
 
-func NewWorkflow(cfg *config.Config) (*Workflow, error) {
+func NewWorkflow(cfg *config.Config) (_ *Workflow, retErr error) {
+	proxy, err := remote.NewProxyFunc(cfg.Distribution.Proxy)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid distribution config")
+	}
+	credentials, err := credential.NewProviders(&cfg.CredentialProviders)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid credential providers config")
+	}
+	identityProvider, err := identity.NewProvider(&cfg.Identity)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid identity config")
+	}
+	naming, err := distribution.NewNaming(&cfg.Naming)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid naming config")
+	}
+	schemes := &remote.Schemes{
+		Insecure: map[string]string{},
+		Proxy:    proxy,
+	}
+	tlsConfigs := map[string]*tls.Config{}
+	for host, registry := range cfg.Registries {
+		schemes.Insecure[host] = registry.Insecure
+		tlsConfig, err := remote.RegistryTLSConfig(registry.CA, registry.Cert, registry.Key, registry.Insecure)
+		if err != nil {
+			return nil, errors.Wrapf(err, "load tls config of registry %s", host)
+		}
+		if tlsConfig != nil {
+			tlsConfigs[host] = tlsConfig
+		}
+	}
+	if err := schemes.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid registries config")
+	}
+	mirrors := &remote.Mirrors{
+		Endpoints:  cfg.Mirrors,
+		Push:       cfg.MirrorPush,
+		TLSConfigs: tlsConfigs,
+		Proxy:      proxy,
+	}
+	if err := mirrors.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid mirrors config")
+	}
+
+	notifiers := []notify.Notifier{}
+	for idx := range cfg.Webhooks {
+		webhook, err := notify.NewWebhook(&cfg.Webhooks[idx])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid webhooks config #%d", idx)
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	publisher, err := notify.NewPublisher(&cfg.Publisher)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid publisher config")
+	}
+	if publisher != nil {
+		notifiers = append(notifiers, publisher)
+	}
+
+	secretScanner, secretPolicy, err := newSecretScanner(&cfg.Scan.Secrets)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid scan.secrets config")
+	}
+	vulnScanner, err := newVulnScanner(&cfg.Scan.Vulnerabilities)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid scan.vulnerabilities config")
+	}
+
+	var recorder *metrics.Recorder
+	if cfg.Metrics.File != "" {
+		latency, err := cfg.Metrics.SLO.LatencyDuration()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid metrics config")
+		}
+		recorder = metrics.NewRecorder(cfg.Metrics.File, cfg.Metrics.SLO.Objective, latency)
+	}
+
 	if err := os.MkdirAll(cfg.Base.WorkDir, 0755); err != nil {
 		return nil, errors.Wrap(err, "prepare work dir")
 	}
 
+	fileMode, dirMode, err := cfg.Artifact.Modes()
+	if err != nil {
+		return nil, errors.Wrap(err, "parse artifact modes")
+	}
 	workDir, err := os.MkdirTemp(cfg.Base.WorkDir, "nydus-cli-")
 	if err != nil {
 		return nil, errors.Wrap(err, "create temp dir")
 	}
 
+	var encrypted *workdir.Encrypted
+	// The encrypted work dir is torn down before removed, as Destory does.
+	defer func() {
+		if retErr == nil {
+			return
+		}
+		if encrypted != nil {
+			if err := encrypted.Close(); err != nil {
+				logrus.WithError(err).Warn("destroy encrypted work dir")
+			}
+		}
+		if err := os.RemoveAll(workDir); err != nil {
+			logrus.WithError(err).Warn("clean up work dir")
+		}
+	}()
+	switch cfg.Base.WorkDirEncryption {
+	case workdir.EncryptionNone:
+	case workdir.EncryptionRequire:
+		if err := workdir.VerifyEncrypted(workDir); err != nil {
+			return nil, errors.Wrap(err, "verify encrypted work dir")
+		}
+	case workdir.EncryptionEphemeral:
+		encrypted, err = workdir.SetupEncrypted(workDir, cfg.Base.WorkDirEncryptionSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "setup encrypted work dir")
+		}
+	default:
+		return nil, fmt.Errorf("invalid work dir encryption: %s", cfg.Base.WorkDirEncryption)
+	}
+
+	if err := os.Chmod(workDir, dirMode); err != nil {
+		return nil, errors.Wrap(err, "chmod temp dir")
+	}
+	if err := chown(workDir, &cfg.Artifact); err != nil {
+		return nil, errors.Wrap(err, "chown temp dir")
+	}
+
 	cm, err := container.NewManager(&cfg.Base.Runtime)
 	if err != nil {
 		return nil, errors.Wrap(err, "new container manager")
 	}
+	cm = cm.WithNaming(naming)
+
+	wf := &Workflow{
+		cfg:         cfg,
+		workDir:     workDir,
+		fileMode:    fileMode,
+		dirMode:     dirMode,
+		encrypted:   encrypted,
+		cm:          cm,
+		bes:         map[string]backend.Backend{},
+		quota:       workdir.NewQuota(cfg.Base.WorkDirQuota),
+		mirrors:     mirrors,
+		proxy:       proxy,
+		dockerCreds: remote.NewDockerConfigCredFunc(cfg.Distribution.DockerConfig),
+		tlsConfigs:  tlsConfigs,
+		schemes:     schemes,
+		identity:    identityProvider,
+		credentials: credentials,
+		metrics:     recorder,
+		naming:      naming,
+		notifiers:   notifiers,
+
+		secretScanner: secretScanner,
+		secretPolicy:  secretPolicy,
+		vulnScanner:   vulnScanner,
+	}
+	wf.registryHosts = map[bool]docker.RegistryHosts{
+		false: wf.newRegistryHosts(false),
+		true:  wf.newRegistryHosts(true),
+	}
+	return wf, nil
+}
+
+// SetProgress displays the progress of packing and pushing blobs in `mode`
+// on stderr, ModeAuto must be resolved already.
+func (wf *Workflow) SetProgress(mode progress.Mode) {
+	wf.progress = mode
+}
+
+// createFile creates an artifact file with configured mode and owner.
+func (wf *Workflow) createFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, wf.fileMode)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(wf.fileMode); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "chmod %s", path)
+	}
+	if err := chown(path, &wf.cfg.Artifact); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "chown %s", path)
+	}
+	return file, nil
+}
 
-	return &Workflow{
-		cfg:     cfg,
-		workDir: workDir,
-		cm:      cm,
-	}, nil
+// createDir creates an artifact dir and its missing parents in work dir
+// with configured mode and owner.
+func (wf *Workflow) createDir(path string) error {
+	if err := os.MkdirAll(path, wf.dirMode); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, wf.dirMode); err != nil {
+		return errors.Wrapf(err, "chmod %s", path)
+	}
+	if err := chown(path, &wf.cfg.Artifact); err != nil {
+		return errors.Wrapf(err, "chown %s", path)
+	}
+	return nil
 }
 
+// backend returns the backend of config for target `ref`.
 func (wf *Workflow) backend(ref string) (backend.Backend, error) {
+	return wf.backendOfType(ref, wf.cfg.BackendType())
+}
+
+// backendOfType returns the backend of `backendType` for target `ref`.
+func (wf *Workflow) backendOfType(ref, backendType string) (backend.Backend, error) {
 	wf.beMutex.Lock()
 	defer wf.beMutex.Unlock()
 
-	if wf.be != nil {
-		return wf.be, nil
+	// All targets share the blobs in external backend.
+	if backendType != config.BackendTypeRegistry {
+		ref = ""
+	}
+	key := backendType + "/" + ref
+	if be, ok := wf.bes[key]; ok {
+		return be, nil
 	}
 
+	var be backend.Backend
 	var err error
-	if wf.cfg.OSS.Endpoint != "" {
-		wf.be, err = backend.NewOSSBackend(&wf.cfg.OSS, false)
+	switch backendType {
+	case config.BackendTypePlugin:
+		be, err = backend.NewPluginBackend(&wf.cfg.Backend)
+		if err != nil {
+			return nil, errors.Wrap(err, "new plugin backend")
+		}
+	case config.BackendTypeOSS:
+		be, err = backend.NewOSSBackend(&wf.cfg.OSS, false)
 		if err != nil {
 			return nil, errors.Wrap(err, "new oss backend")
 		}
-	} else {
-		remoter, err := remote.New(ref, wf.resolverFunc)
+	default:
+		remoter, err := wf.newRemote(ref)
 		if err != nil {
 			return nil, errors.Wrap(err, "create remote")
 		}
-		wf.be, err = backend.NewRegistryBackend(remoter)
+		be, err = backend.NewRegistryBackend(remoter, wf.hostsFunc, wf.workDir)
 		if err != nil {
 			return nil, errors.Wrap(err, "new registry backend")
 		}
 	}
-	return wf.be, nil
+	wf.bes[key] = be
+
+	return be, nil
 }
 
 func (wf *Workflow) resolverFunc(plainHTTP bool) remotes.Resolver {
-	return remote.NewResolver(true, plainHTTP, func(ref string) (string, string, error) {
-		return wf.cfg.Distribution.Username, wf.cfg.Distribution.Password, nil
-	})
+	return remote.NewResolverWithHosts(wf.hostsFunc(plainHTTP))
 }
 
-func (wf *Workflow) pullBootstrap(ctx context.Context, ref, bootstrapName string) (*parserPkg.Image, int, error) {
+func (wf *Workflow) newRemote(ref string) (*remote.Remote, error) {
 	remoter, err := remote.New(ref, wf.resolverFunc)
+	if err != nil {
+		return nil, err
+	}
+	return remoter.WithMirrors(wf.mirrors).WithSchemes(wf.schemes), nil
+}
+
+// pullBootstrap pulls the bootstrap of nydus image `ref` into work dir as
+// `bootstrapName`, the returned NydusImage is always set.
+func (wf *Workflow) pullBootstrap(ctx context.Context, ref, bootstrapName string) (*parserPkg.Parsed, int, error) {
+	remoter, err := wf.newRemote(ref)
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "create remote")
 	}
@@ -150,40 +599,128 @@ func (wf *Workflow) pullBootstrap(ctx context.Context, ref, bootstrapName string
 		return nil, 0, errors.Wrap(err, "parse nydus image")
 	}
 	if parsed.NydusImage == nil {
-		return nil, 0, fmt.Errorf("not a nydus image: %s", ref)
+		return nil, 0, withClass(fmt.Errorf("not a nydus image: %s", ref), ErrNotNydusImage)
 	}
 
 	bootstrapDesc := parserPkg.FindNydusBootstrapDesc(&parsed.NydusImage.Manifest)
 	if bootstrapDesc == nil {
 		return nil, 0, fmt.Errorf("not found nydus bootstrap layer")
 	}
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return nil, 0, err
+	}
 	committedLayers := 0
-	_commitBlobs := bootstrapDesc.Annotations[layerAnnotationNydusCommitBlobs]
+	_commitBlobs := bootstrapDesc.Annotations[keys.CommitBlobs]
 	if _commitBlobs != "" {
 		committedLayers = len(strings.Split(_commitBlobs, ","))
 		logrus.Infof("detected the committed layers: %d", committedLayers)
 	}
 
 	target := filepath.Join(wf.workDir, bootstrapName)
+	cached, err := wf.loadCachedBootstrap(bootstrapDesc.Digest, target)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "load cached bootstrap")
+	}
+	if cached {
+		logrus.Infof("found bootstrap %s in cache", bootstrapDesc.Digest)
+		return parsed, committedLayers, nil
+	}
+
 	reader, err := parser.PullNydusBootstrap(ctx, parsed.NydusImage)
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "pull bootstrap layer")
 	}
 	defer reader.Close()
 
+	// The bootstrap is unpacked into the file created with artifact mode.
+	file, err := wf.createFile(target)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "create bootstrap file")
+	}
+	file.Close()
 	if err := utils.UnpackFile(reader, utils.BootstrapFileNameInLayer, target); err != nil {
 		return nil, 0, errors.Wrap(err, "unpack bootstrap layer")
 	}
+	if err := wf.cacheBootstrap(bootstrapDesc.Digest, target); err != nil {
+		logrus.WithError(err).Warnf("cache bootstrap %s", bootstrapDesc.Digest)
+	}
 
-	return parsed.NydusImage, committedLayers, nil
+	return parsed, committedLayers, nil
 }
 
-func (wf *Workflow) commitUpperByDiff(ctx context.Context, appendMount func(path string), withPaths []string, withoutPaths []string, lowerDirs, upperDir, blobName string) (*digest.Digest, error) {
+// layerWriter returns the writer which the tar stream of committed layer
+// is written to, the stream is also written into an OCI layer named
+// `name` if there are OCI targets.
+func (wf *Workflow) layerWriter(opt CommitOption, tarWc io.Writer, name string) (io.Writer, *ociLayerWriter, error) {
+	if len(opt.targets(FormatOCI)) == 0 {
+		return tarWc, nil, nil
+	}
+	lw, err := wf.newOCILayerWriter(name + ".tar.gz")
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.MultiWriter(tarWc, lw), lw, nil
+}
+
+func (lw *ociLayerWriter) finish() (*OCILayer, error) {
+	if lw == nil {
+		return nil, nil
+	}
+	return lw.Close()
+}
+
+// bindReadOnly bind mounts `source` read-only to a private mount point in
+// work dir, the returned func unmounts it. The mount is made in the mount
+// namespace of process, which is private to commit with ReadOnlyUpper.
+func (wf *Workflow) bindReadOnly(source, name string) (string, func() error, error) {
+	target := filepath.Join(wf.workDir, name)
+	if err := wf.createDir(target); err != nil {
+		return "", nil, errors.Wrapf(err, "create mount point %s", target)
+	}
+
+	m := mount.Mount{
+		Type:    "bind",
+		Source:  source,
+		Options: []string{"ro", "rbind"},
+	}
+	if err := m.Mount(target); err != nil {
+		return "", nil, errors.Wrapf(err, "bind mount %s to %s", source, target)
+	}
+	done := wf.cleanups.add(fmt.Sprintf("unmount %s", target), func() error {
+		return mount.UnmountAll(target, unix.MNT_DETACH)
+	})
+	release := func() error {
+		if err := mount.UnmountAll(target, unix.MNT_DETACH); err != nil {
+			return errors.Wrapf(err, "unmount %s", target)
+		}
+		done()
+		return nil
+	}
+
+	// Don't propagate mount events to or from the container's mounts.
+	if err := unix.Mount("", target, "", unix.MS_PRIVATE|unix.MS_REC, ""); err != nil {
+		return "", nil, goerrors.Join(errors.Wrapf(err, "make %s private", target), release())
+	}
+
+	return target, release, nil
+}
+
+func (wf *Workflow) commitUpperByDiff(ctx context.Context, opt CommitOption, appendMount func(path string, metacopy bool), lowerDirs, upperDir, blobName string) (_ *Blob, retErr error) {
+	withPaths, withoutPaths := opt.WithPaths, opt.WithoutPaths
 	logrus.Infof("committing upper")
 	start := time.Now()
 
+	// The page cache is consistent already for the diff, a failed sync
+	// only loses the durability.
+	if err := syncFs(upperDir); err != nil {
+		logrus.WithError(err).Warn("sync upper dir")
+	} else {
+		logrus.Debugf("synced upper dir in %s", time.Since(start))
+	}
+
 	blobPath := filepath.Join(wf.workDir, blobName)
-	blob, err := os.Create(blobPath)
+	blob, err := wf.createFile(blobPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "create upper blob file")
 	}
@@ -191,28 +728,100 @@ func (wf *Workflow) commitUpperByDiff(ctx context.Context, appendMount func(path
 
 	digester := digest.SHA256.Digester()
 	counter := Counter{}
-	tarWc, err := converter.Pack(ctx, io.MultiWriter(blob, digester.Hash(), &counter), converter.PackOption{
-		WorkDir:     wf.workDir,
-		FsVersion:   "5",
-		Compressor:  "lz4_block",
-		BuilderPath: wf.cfg.Base.Builder,
-	})
+	dest, streams, err := wf.blobWriter(ctx, opt, blob, blobPath, io.MultiWriter(digester.Hash(), &counter))
+	if err != nil {
+		return nil, errors.Wrap(err, "create blob streams")
+	}
+	if streams != nil {
+		defer func() {
+			if retErr != nil {
+				streams.CloseWithError(retErr)
+			}
+		}()
+	}
+
+	packOpt, builderLog, err := wf.packOption(blobName)
+	if err != nil {
+		return nil, err
+	}
+	tarWc, err := converter.Pack(ctx, dest, packOpt)
 	if err != nil {
 		return nil, errors.Wrap(err, "initialize pack to blob")
 	}
 
-	if err := diff.Diff(ctx, appendMount, withPaths, withoutPaths, tarWc, lowerDirs, upperDir); err != nil {
-		return nil, errors.Wrap(err, "make diff")
+	w, lw, err := wf.layerWriter(opt, tarWc, blobName)
+	if err != nil {
+		return nil, errors.Wrap(err, "create oci layer writer")
+	}
+	defer func() {
+		if retErr != nil {
+			lw.abort()
+		}
+	}()
+
+	if opt.ReadOnlyUpper {
+		roUpperDir, release, err := wf.bindReadOnly(upperDir, blobName+"-ro")
+		if err != nil {
+			return nil, errors.Wrap(err, "bind mount upper dir read-only")
+		}
+		defer func() {
+			if err := release(); err != nil {
+				logrus.WithError(err).Warn("release read-only upper dir")
+			}
+		}()
+		upperDir = roUpperDir
+	}
+
+	// The diff is estimated by the size of upper dir, which may include
+	// the files excluded by paths.
+	var total uint64
+	if wf.progress.Enabled() {
+		if total, err = dirSize(upperDir); err != nil {
+			logrus.WithError(err).Warn("get size of upper dir for progress")
+		}
+	}
+	reporter := wf.newProgress("pack "+blobName, int64(total))
+	defer reporter.Finish()
+
+	// The sockets are ignored by diff as they can't be archived.
+	var diffOpts []archive.ChangeWriterOpt
+	if opt.SpecialFiles == tarstream.SpecialFilesFail {
+		diffOpts = append(diffOpts, archive.WithSocketFunc(func(name string) error {
+			return fmt.Errorf("%w: socket %s", tarstream.ErrSpecialFile, name)
+		}))
+	}
+	scanned, sw := wf.scanLayer(ctx, blobName, io.MultiWriter(w, reporter))
+	defer func() {
+		abortScan(sw, retErr)
+	}()
+	stats := tarstream.Stats{}
+	tw := tarstream.NewWriter(scanned, append(opt.tarOptions(), tarstream.WithStats(&stats), tarstream.WithTempDir(wf.workDir))...)
+	if err := diff.Diff(ctx, opt.DiffWorkers, appendMount, withPaths, withoutPaths, tw, lowerDirs, upperDir, diffOpts...); err != nil {
+		return nil, errors.Wrap(tw.CloseWithError(err), "make diff")
+	}
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrap(err, "rewrite tar stream of upper")
+	}
+	if err := wf.checkSecrets(blobName, sw); err != nil {
+		return nil, err
 	}
 
 	if err := tarWc.Close(); err != nil {
-		return nil, errors.Wrap(err, "pack to blob")
+		return nil, errors.Wrap(builderLog.wrap(err), "pack to blob")
 	}
 
-	blobDigest := digester.Digest()
-	logrus.Infof("committed upper, size: %s, elapsed: %s", humanize.Bytes(uint64(counter.Size())), time.Since(start))
+	ociLayer, err := lw.finish()
+	if err != nil {
+		return nil, errors.Wrap(err, "write oci layer")
+	}
+
+	desc := blobDesc(digester.Digest(), counter.Size())
+	if err := wf.finishBlobStreams(streams, *desc); err != nil {
+		return nil, err
+	}
+	logrus.Infof("committed upper, size: %s, files: %d, elapsed: %s", humanize.Bytes(uint64(counter.Size())), stats.Files, time.Since(start))
 
-	return &blobDigest, nil
+	return &Blob{Name: blobName, Desc: *desc, OCILayer: ociLayer, Stats: stats}, nil
 }
 
 func (wf *Workflow) mergeBootstrap(
@@ -225,7 +834,7 @@ func (wf *Workflow) mergeBootstrap(
 	}
 
 	mergedBootstrap := filepath.Join(wf.workDir, mergedBootstrapName)
-	bootstrap, err := os.Create(mergedBootstrap)
+	bootstrap, err := wf.createFile(mergedBootstrap)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "create upper blob file")
 	}
@@ -241,7 +850,7 @@ func (wf *Workflow) mergeBootstrap(
 	})
 	for idx := range mountBlobs {
 		mountBlob := mountBlobs[idx]
-		mountBlobRa, err := local.OpenReader(filepath.Join(wf.workDir, mountBlob.Name))
+		mountBlobRa, err := wf.openBlob(mountBlob)
 		if err != nil {
 			return nil, nil, errors.Wrap(err, "open reader for mount blob")
 		}
@@ -251,43 +860,113 @@ func (wf *Workflow) mergeBootstrap(
 		})
 	}
 
-	blobDigests, err := converter.Merge(ctx, layers, writer, converter.MergeOption{
+	builder, builderLog, err := wf.builder(mergedBootstrapName)
+	if err != nil {
+		return nil, nil, err
+	}
+	mergeOpt := converter.MergeOption{
 		WorkDir:             wf.workDir,
-		FsVersion:           "5",
+		FsVersion:           fsVersion,
 		ParentBootstrapPath: baseBootstrap,
 		WithTar:             true,
-		BuilderPath:         wf.cfg.Base.Builder,
-	})
+		BuilderPath:         builder,
+	}
+	if wf.chunkDict != nil {
+		mergeOpt.ChunkDictPath = wf.chunkDict.path
+	}
+	blobDigests, err := converter.Merge(ctx, layers, writer, mergeOpt)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "merge bootstraps")
+		return nil, nil, errors.Wrap(builderLog.wrap(err), "merge bootstraps")
 	}
 	bootstrapDiffID := digester.Digest()
 
 	return blobDigests, &bootstrapDiffID, nil
 }
 
-func (wf *Workflow) pushBlob(ctx context.Context, blobName string, blobDigest digest.Digest, targetRef string) (*ocispec.Descriptor, error) {
-	blobRa, err := local.OpenReader(filepath.Join(wf.workDir, blobName))
+// packOption returns the option packing blob `name`, along with the log
+// of builder.
+func (wf *Workflow) packOption(name string) (converter.PackOption, *builderLog, error) {
+	builder, log, err := wf.builder(name)
 	if err != nil {
-		return nil, errors.Wrap(err, "open reader for upper blob")
+		return converter.PackOption{}, nil, err
+	}
+	opt := converter.PackOption{
+		WorkDir:     wf.workDir,
+		FsVersion:   fsVersion,
+		Compressor:  "lz4_block",
+		BuilderPath: builder,
+	}
+	if wf.chunkDict != nil {
+		opt.ChunkDictPath = wf.chunkDict.path
 	}
+	return opt, log, nil
+}
 
-	blobDesc := ocispec.Descriptor{
+func blobDesc(blobDigest digest.Digest, size int64) *ocispec.Descriptor {
+	return &ocispec.Descriptor{
 		Digest:    blobDigest,
-		Size:      blobRa.Size(),
+		Size:      size,
 		MediaType: utils.MediaTypeNydusBlob,
 		Annotations: map[string]string{
 			utils.LayerAnnotationUncompressed: blobDigest.String(),
 			utils.LayerAnnotationNydusBlob:    "true",
 		},
 	}
+}
 
-	backend, err := wf.backend(targetRef)
+func (wf *Workflow) pushBlob(ctx context.Context, blobName string, blobDesc ocispec.Descriptor, targetRef string) error {
+	data, err := wf.readSmallBlob(blobName, blobDesc)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	return wf.pushBlobData(ctx, blobName, data, blobDesc, targetRef)
+}
+
+// readSmallBlob reads blob `blobName` into memory if it's smaller than the
+// small blob size of config, otherwise it returns nil.
+func (wf *Workflow) readSmallBlob(blobName string, blobDesc ocispec.Descriptor) ([]byte, error) {
+	// The config is validated on parsing.
+	threshold, _ := wf.cfg.Push.SmallBlobThreshold()
+	if blobDesc.Size >= threshold {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(wf.workDir, blobName))
+	if err != nil {
+		return nil, errors.Wrap(err, "read small blob")
+	}
+	if int64(len(data)) != blobDesc.Size {
+		return nil, fmt.Errorf("size mismatch of blob %s: %d != %d", blobName, len(data), blobDesc.Size)
+	}
+	return data, nil
+}
+
+// pushBlobData pushes blob `blobName` to the backend of `targetRef`, from
+// `data` in memory if it's not nil, otherwise from the file in work dir.
+func (wf *Workflow) pushBlobData(ctx context.Context, blobName string, data []byte, blobDesc ocispec.Descriptor, targetRef string) error {
+	be, err := wf.backendOfType(targetRef, wf.cfg.BlobBackendType(blobDesc.Size))
+	if err != nil {
+		return err
+	}
+
+	reporter := wf.newProgress("push "+blobName, blobDesc.Size)
+	defer reporter.Finish()
+	if data != nil {
+		if pusher, ok := be.(backend.BytesPusher); ok {
+			if err := pusher.PushBytes(ctx, data, blobDesc); err != nil {
+				return err
+			}
+			reporter.Write(data)
+			return nil
+		}
+		return be.Push(ctx, &progressReaderAt{ReaderAt: &bytesReaderAt{bytes.NewReader(data)}, reporter: reporter}, blobDesc)
 	}
 
-	return &blobDesc, backend.Push(ctx, blobRa, blobDesc)
+	blobRa, err := local.OpenReader(filepath.Join(wf.workDir, blobName))
+	if err != nil {
+		return errors.Wrap(err, "open reader for upper blob")
+	}
+	defer blobRa.Close()
+	return be.Push(ctx, &progressReaderAt{ReaderAt: blobRa, reporter: reporter}, blobDesc)
 }
 
 func (wf *Workflow) makeDesc(ctx context.Context, x interface{}, oldDesc ocispec.Descriptor) ([]byte, *ocispec.Descriptor, error) {
@@ -304,52 +983,82 @@ func (wf *Workflow) makeDesc(ctx context.Context, x interface{}, oldDesc ocispec
 	return data, &newDesc, nil
 }
 
+// blobInRegistry checks whether blob `desc` is referenced by layer in
+// registry, otherwise it's in external backend referenced by blob ids
+// annotation. The blobs `pushed` by commit are routed by size, and the
+// lower blobs of base are kept as is if routed.
+func (wf *Workflow) blobInRegistry(desc ocispec.Descriptor, pushed bool) bool {
+	if !pushed && len(wf.cfg.Routing.Rules) > 0 {
+		return true
+	}
+	return wf.cfg.BlobBackendType(desc.Size) == config.BackendTypeRegistry
+}
+
 func (wf *Workflow) pushManifest(
-	ctx context.Context, nydusImage parserPkg.Image, bootstrapDiffID digest.Digest, targetRef, bootstrapName string, blobDigests []digest.Digest, upperBlob *Blob, mountBlobs []Blob,
-) error {
-	lowerBlobLayers := []ocispec.Descriptor{}
+	ctx context.Context, state *CommitState, targetRef, bootstrapName string, records []CommitRecord, byDigest bool,
+) (*ocispec.Descriptor, error) {
+	nydusImage := *state.Base
+	blobDigests, upperBlob, mountBlobs := state.BlobDigests, state.UpperBlob, state.MountBlobs
+
+	// The converted base blobs are pushed by commit.
+	pushed := map[digest.Digest]bool{}
+	for _, baseBlob := range state.BaseBlobs {
+		pushed[baseBlob.Desc.Digest] = true
+	}
+	blobLayers := []ocispec.Descriptor{}
 	for idx := range nydusImage.Manifest.Layers {
 		layer := nydusImage.Manifest.Layers[idx]
-		if layer.MediaType == utils.MediaTypeNydusBlob {
-			lowerBlobLayers = append(lowerBlobLayers, layer)
+		if layer.MediaType == utils.MediaTypeNydusBlob && wf.blobInRegistry(layer, pushed[layer.Digest]) {
+			blobLayers = append(blobLayers, layer)
 		}
 	}
 
-	// Push image config
-	config := nydusImage.Config
-	if wf.be.External() {
-		config.RootFS.DiffIDs = []digest.Digest{bootstrapDiffID}
-	} else {
-		config.RootFS.DiffIDs = []digest.Digest{}
-		for idx := range lowerBlobLayers {
-			config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, lowerBlobLayers[idx].Digest)
+	be, err := wf.backend(targetRef)
+	if err != nil {
+		return nil, err
+	}
+
+	if wf.chunkDict != nil && !be.External() {
+		dictBlobLayers, err := wf.chunkDict.dictBlobLayers(blobDigests, nydusImage, append([]Blob{*upperBlob}, mountBlobs...))
+		if err != nil {
+			return nil, errors.Wrap(err, "find chunk dict blobs")
 		}
-		for idx := range mountBlobs {
-			mountBlob := mountBlobs[idx]
-			config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, mountBlob.Desc.Digest)
+		blobLayers = append(blobLayers, dictBlobLayers...)
+	}
+	for idx := range mountBlobs {
+		if wf.blobInRegistry(mountBlobs[idx].Desc, true) {
+			blobLayers = append(blobLayers, mountBlobs[idx].Desc)
 		}
-		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, upperBlob.Desc.Digest)
-		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, bootstrapDiffID)
 	}
+	if wf.blobInRegistry(upperBlob.Desc, true) {
+		blobLayers = append(blobLayers, upperBlob.Desc)
+	}
+
+	// Push image config
+	config := nydusImage.Config
+	config.RootFS.DiffIDs = []digest.Digest{}
+	for idx := range blobLayers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, blobLayers[idx].Digest)
+	}
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, *state.BootstrapDiffID)
 
 	configBytes, configDesc, err := wf.makeDesc(ctx, config, nydusImage.Manifest.Config)
 	if err != nil {
-		return errors.Wrap(err, "make config desc")
+		return nil, errors.Wrap(err, "make config desc")
 	}
 
-	remoter, err := remote.New(targetRef, wf.resolverFunc)
+	remoter, err := wf.newRemote(targetRef)
 	if err != nil {
-		return errors.Wrap(err, "create remote")
+		return nil, errors.Wrap(err, "create remote")
 	}
 
 	if err := remoter.Push(ctx, *configDesc, true, bytes.NewReader(configBytes)); err != nil {
-		if remote.RetryWithHTTP(err) {
-			remoter.MaybeWithHTTP(err)
+		if remoter.MaybeWithHTTP(err) {
 			if err := remoter.Push(ctx, *configDesc, true, bytes.NewReader(configBytes)); err != nil {
-				return errors.Wrap(err, "push image config")
+				return nil, errors.Wrap(err, "push image config")
 			}
 		} else {
-			return errors.Wrap(err, "push image config")
+			return nil, errors.Wrap(err, "push image config")
 		}
 	}
 
@@ -357,38 +1066,49 @@ func (wf *Workflow) pushManifest(
 	bootstrapTarPath := filepath.Join(wf.workDir, bootstrapName)
 	bootstrapTar, err := os.Open(bootstrapTarPath)
 	if err != nil {
-		return errors.Wrap(err, "open bootstrap tar file")
+		return nil, errors.Wrap(err, "open bootstrap tar file")
 	}
 
-	bootstrapTarGzPath := filepath.Join(wf.workDir, bootstrapName+".gz")
-	bootstrapTarGz, err := os.Create(bootstrapTarGzPath)
+	ext, mediaType, err := bootstrapLayerFormat(wf.cfg.Bootstrap)
 	if err != nil {
-		return errors.Wrap(err, "create bootstrap tar.gz file")
+		return nil, err
+	}
+	bootstrapLayerPath := filepath.Join(wf.workDir, bootstrapName+ext)
+	bootstrapLayer, err := wf.createFile(bootstrapLayerPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "create compressed bootstrap file")
 	}
-	defer bootstrapTarGz.Close()
+	defer bootstrapLayer.Close()
 
 	digester := digest.SHA256.Digester()
-	gzWriter := gzip.NewWriter(io.MultiWriter(bootstrapTarGz, digester.Hash()))
-	if _, err := io.Copy(gzWriter, bootstrapTar); err != nil {
-		return errors.Wrap(err, "compress bootstrap tar to tar.gz")
+	compressor, err := compressBootstrap(io.MultiWriter(bootstrapLayer, digester.Hash()), wf.cfg.Bootstrap)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(compressor, bootstrapTar); err != nil {
+		compressor.Close()
+		return nil, errors.Wrap(err, "compress bootstrap tar")
 	}
-	if err := gzWriter.Close(); err != nil {
-		return errors.Wrap(err, "close gzip writer")
+	if err := compressor.Close(); err != nil {
+		return nil, errors.Wrap(err, "close bootstrap compressor")
 	}
 
-	ra, err := local.OpenReader(bootstrapTarGzPath)
+	ra, err := local.OpenReader(bootstrapLayerPath)
 	if err != nil {
-		return errors.Wrap(err, "open reader for upper blob")
+		return nil, errors.Wrap(err, "open reader for upper blob")
 	}
 	defer ra.Close()
 
+	// The blobs not in layers are in external backend.
+	inLayers := map[digest.Digest]bool{}
+	for _, layer := range blobLayers {
+		inLayers[layer.Digest] = true
+	}
 	blobIDs := []string{}
 	for _, blobDigest := range blobDigests {
-		blobIDs = append(blobIDs, blobDigest.Hex())
-	}
-	blobIDsBytes, err := json.Marshal(blobIDs)
-	if err != nil {
-		return errors.Wrap(err, "marshal blob ids")
+		if !inLayers[blobDigest] {
+			blobIDs = append(blobIDs, blobDigest.Hex())
+		}
 	}
 
 	commitBlobs := []string{}
@@ -398,117 +1118,180 @@ func (wf *Workflow) pushManifest(
 	}
 	commitBlobs = append(commitBlobs, upperBlob.Desc.Digest.String())
 
+	keys, err := wf.cfg.Annotations.ResolvedKeys()
+	if err != nil {
+		return nil, err
+	}
 	bootstrapDesc := ocispec.Descriptor{
 		Digest:    digester.Digest(),
 		Size:      ra.Size(),
-		MediaType: ocispec.MediaTypeImageLayerGzip,
+		MediaType: mediaType,
 		Annotations: map[string]string{
-			converter.LayerAnnotationFSVersion:      "5",
+			converter.LayerAnnotationFSVersion:      fsVersion,
 			converter.LayerAnnotationNydusBootstrap: "true",
-			layerAnnotationNydusCommitBlobs:         strings.Join(commitBlobs, ","),
+			keys.CommitBlobs:                        strings.Join(commitBlobs, ","),
 		},
 	}
-	if wf.be.External() {
-		bootstrapDesc.Annotations[layerAnnotationNydusBlobIDs] = string(blobIDsBytes)
+	limit, err := wf.cfg.Annotations.Limit()
+	if err != nil {
+		return nil, err
+	}
+	var fullBlobIDs []byte
+	if be.External() || (len(wf.cfg.Routing.Rules) > 0 && len(blobIDs) > 0) {
+		var value, fullValue string
+		value, fullValue, fullBlobIDs, err = boundBlobIDs(blobIDs, keys.BlobIDs, keys.BlobIDsFull, limit)
+		if err != nil {
+			return nil, err
+		}
+		bootstrapDesc.Annotations[keys.BlobIDs] = value
+		if fullBlobIDs != nil {
+			bootstrapDesc.Annotations[keys.BlobIDsFull] = fullValue
+		}
 	}
+	var fullHistory []byte
+	if keys.CommitHistory != "" {
+		var history string
+		history, fullHistory, err = boundCommitHistory(records, keys.CommitHistory, limit)
+		if err != nil {
+			return nil, err
+		}
+		bootstrapDesc.Annotations[keys.CommitHistory] = history
+	}
+	warnAnnotations(bootstrapDesc.Annotations, limit)
 
-	bootstrapRc, err := os.Open(bootstrapTarGzPath)
+	bootstrapRc, err := os.Open(bootstrapLayerPath)
 	if err != nil {
-		return errors.Wrapf(err, "open bootstrap %s", bootstrapTarGzPath)
+		return nil, errors.Wrapf(err, "open bootstrap %s", bootstrapLayerPath)
 	}
 	defer bootstrapRc.Close()
 	if err := remoter.Push(ctx, bootstrapDesc, true, bootstrapRc); err != nil {
-		return errors.Wrap(err, "push bootstrap layer")
+		return nil, errors.Wrap(err, "push bootstrap layer")
 	}
 
 	// Push image manifest
-	layers := lowerBlobLayers
-	for idx := range mountBlobs {
-		mountBlob := mountBlobs[idx]
-		layers = append(layers, mountBlob.Desc)
-	}
-	layers = append(layers, upperBlob.Desc)
-	layers = append(layers, bootstrapDesc)
-
 	nydusImage.Manifest.Config = *configDesc
-	if wf.be.External() {
-		nydusImage.Manifest.Layers = []ocispec.Descriptor{bootstrapDesc}
-	} else {
-		nydusImage.Manifest.Layers = layers
+	nydusImage.Manifest.Layers = append(blobLayers, bootstrapDesc)
+
+	var attested *identity.Identity
+	if wf.identity != nil {
+		attested, err = wf.identity.Identity(ctx, bootstrapDesc.Digest)
+		if err != nil {
+			return nil, errors.Wrap(err, "obtain identity")
+		}
+	}
+	if attested != nil && identity.Verifiable(attested.Source) {
+		// The annotations are shared with base image.
+		annotations := map[string]string{}
+		for key, value := range nydusImage.Manifest.Annotations {
+			annotations[key] = value
+		}
+		nydusImage.Manifest.Annotations, err = identity.Annotate(annotations, attested)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	manifestBytes, manifestDesc, err := wf.makeDesc(ctx, nydusImage.Manifest, nydusImage.Desc)
 	if err != nil {
-		return errors.Wrap(err, "make config desc")
+		return nil, errors.Wrap(err, "make config desc")
 	}
-	if err := remoter.Push(ctx, *manifestDesc, false, bytes.NewReader(manifestBytes)); err != nil {
-		return errors.Wrap(err, "push image manifest")
+	if err := remoter.Push(ctx, *manifestDesc, byDigest, bytes.NewReader(manifestBytes)); err != nil {
+		return nil, errors.Wrap(err, "push image manifest")
 	}
 
-	return nil
-}
+	if fullHistory != nil {
+		logrus.Infof("commit history of %d commits exceeds annotation limit, keeping full history in artifact", len(records))
+		if err := wf.pushCommitHistory(ctx, remoter, fullHistory, *manifestDesc); err != nil {
+			return nil, err
+		}
+	}
+	if fullBlobIDs != nil {
+		logrus.Infof("%d blob ids exceed annotation limit, keeping full blob ids in artifact", len(blobIDs))
+		if err := wf.pushBlobIDs(ctx, remoter, fullBlobIDs, *manifestDesc); err != nil {
+			return nil, err
+		}
+	}
 
-func (wf *Workflow) Destory() error {
-	return errors.Wrap(os.RemoveAll(wf.workDir), "clean up work dir")
+	if attested != nil {
+		logrus.WithFields(logrus.Fields{
+			"audit":    "commit",
+			"target":   targetRef,
+			"manifest": manifestDesc.Digest,
+			"source":   attested.Source,
+			"identity": attested.ID,
+		}).Info("pushed attested image")
+	}
+
+	return manifestDesc, nil
 }
 
-func prepareMounts(containerMounts []container.Mount, targetPaths []string) ([]mount.Mount, error) {
-	targetMounts := []mount.Mount{}
+// Destory releases everything held by the workflow: unpauses the paused
+// container, unmounts the bind mounts, aborts the uploads in progress and
+// removes work dir, all failures are returned as a joined error.
+func (wf *Workflow) Destory() error {
+	errs := []error{}
+	if err := wf.cleanups.run(); err != nil {
+		errs = append(errs, err)
+	}
 
-	findMount := func(mounts []container.Mount, targetPath string) *container.Mount {
-		var matched *container.Mount
-		for idx, mount := range mounts {
-			dest := mount.Destination
-			if strings.HasPrefix(targetPath, dest) {
-				if matched == nil || len(matched.Destination) <= len(dest) {
-					matched = &mounts[idx]
-				}
+	wf.beMutex.Lock()
+	for _, be := range wf.bes {
+		if aborter, ok := be.(backend.Aborter); ok {
+			if err := aborter.Abort(); err != nil {
+				errs = append(errs, errors.Wrap(err, "abort uploads"))
 			}
 		}
-		return matched
 	}
+	wf.beMutex.Unlock()
 
-	for _, targetPath := range targetPaths {
-		if !filepath.IsAbs(targetPath) {
-			return nil, fmt.Errorf("not a absolute path: %s", targetPath)
+	if wf.encrypted != nil {
+		if err := wf.encrypted.Close(); err != nil {
+			errs = append(errs, errors.Wrap(err, "destroy encrypted work dir"))
 		}
-
-		logrus.Infof("for target: %s", targetPath)
-
-		sourceMount := findMount(containerMounts, targetPath)
-		if sourceMount == nil {
-			return nil, fmt.Errorf("not found mount path: %s", targetPath)
+	}
+	if wf.keepWorkDir && wf.encrypted == nil {
+		logrus.Infof("kept work dir %s", wf.workDir)
+	} else {
+		if wf.keepWorkDir {
+			logrus.Warnf("work dir %s is not kept as it's ephemeral encrypted", wf.workDir)
+		}
+		if err := os.RemoveAll(wf.workDir); err != nil {
+			errs = append(errs, errors.Wrap(err, "clean up work dir"))
 		}
-		logrus.Infof("\tcontainer: %s -> %s", sourceMount.Source, sourceMount.Destination)
-
-		hostBase, err := filepath.Rel(sourceMount.Destination, targetPath)
-		if err != nil {
-			return nil, errors.Wrapf(err, "get rel path for %s", targetPath)
-		}
-		hostPath := filepath.Join(sourceMount.Source, hostBase)
-		target := strings.TrimLeft(targetPath, "/")
-		logrus.Infof("\tmount: %s -> %s", hostPath, target)
-
-		targetMounts = append(targetMounts, mount.Mount{
-			Type:   "bind",
-			Source: hostPath,
-			Target: target,
-			Options: []string{
-				"ro",
-				"rbind",
-			},
-		})
 	}
 
-	return targetMounts, nil
+	return goerrors.Join(errs...)
 }
 
-func (wf *Workflow) commitMountByNSEnter(ctx context.Context, containerPid int, sourceDir, name string) (*digest.Digest, error) {
-	logrus.Infof("committing mount: %s", sourceDir)
+// commitMount commits the mount path `sourceDir` of container by the
+// strategy of option, see MountStrategy.
+func (wf *Workflow) commitMount(ctx context.Context, opt CommitOption, inspect *container.InspectResult, sourceDir, name string) (*Blob, error) {
+	return wf.commitPaths(ctx, opt, inspect, sourceDir, nil, name)
+}
+
+// commitFiles commits the `files` of container unsupported by differ,
+// i.e. the metacopy files whose data is in lower dirs, into one blob,
+// they are always copied from container.
+func (wf *Workflow) commitFiles(ctx context.Context, opt CommitOption, inspect *container.InspectResult, files []string, name string) (*Blob, error) {
+	return wf.commitPaths(ctx, opt, inspect, "", files, name)
+}
+
+// commitPaths commits the mount path `sourceDir` of container replacing
+// the whole directory, or the `files` of container one by one if the
+// mount path is empty.
+func (wf *Workflow) commitPaths(ctx context.Context, opt CommitOption, inspect *container.InspectResult, sourceDir string, files []string, name string) (_ *Blob, retErr error) {
+	source, sources := sourceDir, []string{sourceDir}
+	strategy := MountNSEnter
+	if sourceDir == "" {
+		source, sources = fmt.Sprintf("%d files", len(files)), files
+	} else {
+		strategy = wf.resolveMountStrategy(ctx, opt.MountStrategy, inspect.Pid)
+	}
+	logrus.Infof("committing mount: %s", source)
 	start := time.Now()
 
 	blobPath := filepath.Join(wf.workDir, name)
-	blob, err := os.Create(blobPath)
+	blob, err := wf.createFile(blobPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "create mount blob file")
 	}
@@ -516,123 +1299,146 @@ func (wf *Workflow) commitMountByNSEnter(ctx context.Context, containerPid int,
 
 	digester := digest.SHA256.Digester()
 	counter := Counter{}
-	tarWc, err := converter.Pack(ctx, io.MultiWriter(blob, &counter, digester.Hash()), converter.PackOption{
-		WorkDir:     wf.workDir,
-		FsVersion:   "5",
-		Compressor:  "lz4_block",
-		BuilderPath: wf.cfg.Base.Builder,
-	})
+	dest, streams, err := wf.blobWriter(ctx, opt, blob, blobPath, io.MultiWriter(&counter, digester.Hash()))
 	if err != nil {
-		return nil, errors.Wrap(err, "initialize pack to blob")
-	}
-
-	if err := copyFromContainer(ctx, containerPid, sourceDir, tarWc); err != nil {
-		return nil, errors.Wrapf(err, "copy %s from pid %d", sourceDir, containerPid)
+		return nil, errors.Wrap(err, "create blob streams")
 	}
-
-	if err := tarWc.Close(); err != nil {
-		return nil, errors.Wrap(err, "pack to blob")
+	if streams != nil {
+		defer func() {
+			if retErr != nil {
+				streams.CloseWithError(retErr)
+			}
+		}()
 	}
 
-	mountBlobDigest := digester.Digest()
-
-	logrus.Infof("committed mount: %s, size: %s, elapsed %s", sourceDir, humanize.Bytes(uint64(counter.Size())), time.Since(start))
-
-	return &mountBlobDigest, nil
-}
-
-//nolint:unused
-func (wf *Workflow) commitMountByBindMount(ctx context.Context, containerMounts []container.Mount, targetPaths []string) (*digest.Digest, error) {
-	bindPath, err := os.MkdirTemp(wf.workDir, "mount-")
+	packOpt, builderLog, err := wf.packOption(name)
 	if err != nil {
-		return nil, errors.Wrap(err, "create temp dir")
+		return nil, err
 	}
-	absBindPath, err := filepath.Abs(bindPath)
+	tarWc, err := converter.Pack(ctx, dest, packOpt)
 	if err != nil {
-		return nil, errors.Wrapf(err, "get abs path of %s", bindPath)
+		return nil, errors.Wrap(err, "initialize pack to blob")
 	}
 
-	targetMounts, err := prepareMounts(containerMounts, targetPaths)
+	// The mount blob replaces the whole directory, files removed from the
+	// mount since last commit are hidden by an opaque whiteout.
+	w, lw, err := wf.layerWriter(opt, tarWc, name)
 	if err != nil {
-		return nil, errors.Wrap(err, "prepare target mounts")
+		return nil, errors.Wrap(err, "create oci layer writer")
 	}
-
-	for _, targetMount := range targetMounts {
-		if _, err := os.Stat(targetMount.Source); err != nil {
-			return nil, errors.Wrapf(err, "check host path: %s", targetMount.Source)
+	defer func() {
+		if retErr != nil {
+			lw.abort()
 		}
-		target := filepath.Join(absBindPath, targetMount.Target)
-		if err := os.MkdirAll(target, 0755); err != nil {
-			return nil, errors.Wrapf(err, "prepare target path %s", target)
+	}()
+
+	// The tar stream comes from the container which is untrusted, validate
+	// it before packing.
+	stats := tarstream.Stats{}
+	tarOpts := opt.mountTarOptions(sourceDir, wf.workDir, &stats)
+	if sourceDir == "" {
+		tarOpts = opt.filesTarOptions(wf.workDir, &stats)
+	}
+	// The size of mount is unknown until copied from container.
+	reporter := wf.newProgress("pack "+name, 0)
+	defer reporter.Finish()
+	scanned, sw := wf.scanLayer(ctx, name, io.MultiWriter(w, reporter))
+	defer func() {
+		abortScan(sw, retErr)
+	}()
+	tw := tarstream.NewWriter(scanned, tarOpts...)
+	copyOpt := copyOption{
+		sortByName:       opt.SourceDateEpoch != nil,
+		stallTimeout:     opt.MountStallTimeout,
+		failOnSocket:     opt.SpecialFiles == tarstream.SpecialFilesFail,
+		failOnUnreadable: opt.MountUnreadable == UnreadableFail,
+		numericOwner:     opt.MountNumericOwner,
+		noACLs:           opt.MountNoACLs,
+		selinux:          opt.MountSELinux,
+	}
+	if strategy == MountHostPath {
+		if err := wf.copyFromHost(ctx, inspect.Mounts, sourceDir, name, tw, copyOpt); err != nil {
+			return nil, errors.Wrapf(tw.CloseWithError(err), "copy %s from host path", sourceDir)
 		}
-		defer mount.Unmount(target, 0) //nolint:errcheck
+	} else if err := copyFromContainer(ctx, inspect.Pid, sources, tw, copyOpt); err != nil {
+		return nil, errors.Wrapf(tw.CloseWithError(err), "copy %s from pid %d", source, inspect.Pid)
 	}
-
-	logrus.Infof("\tbinding mounts to %s", absBindPath)
-	if err := mount.All(targetMounts, absBindPath); err != nil {
-		return nil, errors.Wrapf(err, "bind mounts to %s", absBindPath)
+	if err := tw.Close(); err != nil {
+		return nil, errors.Wrapf(err, "rewrite tar stream of %s", source)
 	}
-
-	blobPath := filepath.Join(wf.workDir, "blob-mount-by-bind")
-	blob, err := os.Create(blobPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "create mount blob file")
+	if err := wf.checkSecrets(name, sw); err != nil {
+		return nil, err
 	}
-	defer blob.Close()
 
-	logrus.Infof("\tpacking mount directory")
-	digester := digest.SHA256.Digester()
-	tarWc, err := converter.Pack(ctx, io.MultiWriter(blob, digester.Hash()), converter.PackOption{
-		WorkDir:     wf.workDir,
-		FsVersion:   "5",
-		Compressor:  "lz4_block",
-		BuilderPath: wf.cfg.Base.Builder,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "initialize pack to blob")
+	if err := tarWc.Close(); err != nil {
+		return nil, errors.Wrap(builderLog.wrap(err), "pack to blob")
 	}
 
-	if err := archive.WriteDiff(ctx, tarWc, "", bindPath); err != nil {
-		return nil, errors.Wrapf(err, "write diff for path: %s", bindPath)
+	ociLayer, err := lw.finish()
+	if err != nil {
+		return nil, errors.Wrap(err, "write oci layer")
 	}
 
-	if err := tarWc.Close(); err != nil {
-		return nil, errors.Wrap(err, "pack to blob")
+	desc := blobDesc(digester.Digest(), counter.Size())
+	if err := wf.finishBlobStreams(streams, *desc); err != nil {
+		return nil, err
 	}
 
-	mountBlobDigest := digester.Digest()
+	logrus.Infof("committed mount: %s, size: %s, files: %d, elapsed %s", source, humanize.Bytes(uint64(counter.Size())), stats.Files, time.Since(start))
 
-	return &mountBlobDigest, nil
+	return &Blob{Name: name, Desc: *desc, OCILayer: ociLayer, Stats: stats}, nil
 }
 
-func (wf *Workflow) pause(ctx context.Context, containerIDWithType string, handle func() error) error {
+func (wf *Workflow) pause(ctx context.Context, containerIDWithType string, mode container.PauseMode, handle func() error) error {
 	logrus.Infof("pausing container: %s", containerIDWithType)
-	if err := wf.cm.Pause(ctx, containerIDWithType); err != nil {
+	if err := wf.cm.Pause(ctx, containerIDWithType, mode); err != nil {
 		return errors.Wrap(err, "pause container")
 	}
+	// Unpause with a new context as the commit context may be canceled.
+	done := wf.cleanups.add(fmt.Sprintf("unpause container %s", containerIDWithType), func() error {
+		return wf.cm.UnPause(context.Background(), containerIDWithType, mode)
+	})
+	unpause := func() error {
+		logrus.Infof("unpausing container: %s", containerIDWithType)
+		unpauseCtx := ctx
+		if ctx.Err() != nil {
+			unpauseCtx = context.Background()
+		}
+		if err := wf.cm.UnPause(unpauseCtx, containerIDWithType, mode); err != nil {
+			return err
+		}
+		done()
+		return nil
+	}
 
 	if err := handle(); err != nil {
-		logrus.Infof("unpausing container: %s", containerIDWithType)
-		if err := wf.cm.UnPause(ctx, containerIDWithType); err != nil {
+		if err := unpause(); err != nil {
 			logrus.Errorf("unpause container: %s", containerIDWithType)
 		}
 		return err
 	}
 
-	logrus.Infof("unpausing container: %s", containerIDWithType)
-	return wf.cm.UnPause(ctx, containerIDWithType)
+	return unpause()
 }
 
-func withRetry(handle func() error, total int) error {
+// withRetry calls `handle` at most `total` times until it succeeds, the
+// retries are recorded as operation `name`.
+func withRetry(name string, handle func() error, total int) error {
 	for {
 		total--
+		start := time.Now()
 		err := handle()
 		if err == nil {
 			return nil
 		}
+		// The secrets found won't go away by retrying.
+		if errors.Is(err, scan.ErrSecretsFound) {
+			return err
+		}
 
 		if total > 0 {
 			logrus.WithError(err).Warnf("retry (remain %d times)", total)
+			remote.Retries.Record(name, time.Since(start), 0)
 			continue
 		}
 
@@ -643,171 +1449,46 @@ func withRetry(handle func() error, total int) error {
 type MountList struct {
 	mutex sync.Mutex
 	paths []string
+	// files are the metacopy files committed into one blob.
+	files []string
 }
 
 func NewMountList() *MountList {
 	return &MountList{
 		paths: make([]string, 0),
+		files: make([]string, 0),
 	}
 }
 
-func (ml *MountList) Add(path string) {
+func (ml *MountList) Add(path string, metacopy bool) {
 	ml.mutex.Lock()
 	defer ml.mutex.Unlock()
 
-	ml.paths = append(ml.paths, path)
-}
-
-func (wf *Workflow) Commit(ctx context.Context, opt CommitOption) error {
-	logrus.Infof("current envs:")
-	logrus.Infof("\thostname: %s", os.Getenv("HOSTNAME"))
-	logrus.Infof("\tpod name: %s", os.Getenv("ALIPAY_POD_NAME"))
-
-	targetRef, err := distribution.AppendNydusSuffix(opt.TargetRef)
-	if err != nil {
-		return errors.Wrap(err, "parse target image name")
-	}
-
-	inspect, err := wf.cm.Inspect(ctx, opt.ContainerIDWithType)
-	if err != nil {
-		return errors.Wrap(err, "inspect container")
-	}
-	logrus.Infof("inspected container %s:", opt.ContainerIDWithType)
-	logrus.Infof("\timage: %s", inspect.Image)
-	logrus.Infof("\tpid: %d", inspect.Pid)
-
-	logrus.Infof("pulling base bootstrap")
-	start := time.Now()
-	image, committedLayers, err := wf.pullBootstrap(ctx, inspect.Image, "bootstrap-base")
-	if err != nil {
-		return errors.Wrap(err, "pull base bootstrap")
+	if metacopy {
+		ml.files = append(ml.files, path)
+	} else {
+		ml.paths = append(ml.paths, path)
 	}
-	logrus.Infof("pulled base bootstrap, elapsed: %s", time.Since(start))
+}
 
-	if committedLayers >= opt.MaximumTimes {
-		return fmt.Errorf("reached maximum committed times %d", opt.MaximumTimes)
+// pushBlobToTargets pushes the committed blob to the backends of all
+// nydus targets of `state`.
+func (wf *Workflow) pushBlobToTargets(ctx context.Context, state *CommitState, blobName string, blobDesc ocispec.Descriptor) error {
+	if state.Option.StreamPush {
+		// Already pushed while packing.
+		return nil
 	}
-
-	mountList := NewMountList()
-
-	var upperBlob *Blob
-	mountBlobs := make([]Blob, len(opt.WithPaths))
-	commit := func() error {
-		eg := errgroup.Group{}
-		eg.Go(func() error {
-			var upperBlobDigest *digest.Digest
-			if err := withRetry(func() error {
-				upperBlobDigest, err = wf.commitUpperByDiff(ctx, mountList.Add, opt.WithPaths, opt.WithoutPaths, inspect.LowerDirs, inspect.UpperDir, "blob-upper")
-				return err
-			}, 3); err != nil {
-				return errors.Wrap(err, "commit upper")
-			}
-			logrus.Infof("pushing blob for upper")
-			start := time.Now()
-			upperBlobDesc, err := wf.pushBlob(ctx, "blob-upper", *upperBlobDigest, opt.TargetRef)
-			if err != nil {
-				return errors.Wrap(err, "push upper blob")
-			}
-			upperBlob = &Blob{
-				Name: "blob-upper",
-				Desc: *upperBlobDesc,
-			}
-			logrus.Infof("pushed blob for upper, elapsed: %s", time.Since(start))
-			return nil
-		})
-
-		if len(opt.WithPaths) > 0 {
-			for idx := range opt.WithPaths {
-				func(idx int) {
-					eg.Go(func() error {
-						withPath := opt.WithPaths[idx]
-						name := fmt.Sprintf("blob-mount-%d", idx)
-						var mountBlobDigest *digest.Digest
-						if err := withRetry(func() error {
-							mountBlobDigest, err = wf.commitMountByNSEnter(ctx, inspect.Pid, withPath, name)
-							return err
-						}, 3); err != nil {
-							return errors.Wrap(err, "commit mount")
-						}
-						logrus.Infof("pushing blob for mount")
-						start := time.Now()
-						mountBlobDesc, err := wf.pushBlob(ctx, name, *mountBlobDigest, opt.TargetRef)
-						if err != nil {
-							return errors.Wrap(err, "push mount blob")
-						}
-						mountBlobs[idx] = Blob{
-							Name: name,
-							Desc: *mountBlobDesc,
-						}
-						logrus.Infof("pushed blob for mount, elapsed: %s", time.Since(start))
-						return nil
-					})
-				}(idx)
-			}
-		}
-
-		if err := eg.Wait(); err != nil {
-			return err
-		}
-
-		appendedEg := errgroup.Group{}
-		appendedMutex := sync.Mutex{}
-		if len(mountList.paths) > 0 {
-			logrus.Infof("need commit appened mount path: %s", strings.Join(mountList.paths, ", "))
-		}
-		for idx := range mountList.paths {
-			func(idx int) {
-				appendedEg.Go(func() error {
-					mountPath := mountList.paths[idx]
-					name := fmt.Sprintf("blob-appended-mount-%d", idx)
-					var mountBlobDigest *digest.Digest
-					if err := withRetry(func() error {
-						mountBlobDigest, err = wf.commitMountByNSEnter(ctx, inspect.Pid, mountPath, name)
-						return err
-					}, 3); err != nil {
-						return errors.Wrap(err, "commit appended mount")
-					}
-					logrus.Infof("pushing blob for appended mount")
-					start := time.Now()
-					mountBlobDesc, err := wf.pushBlob(ctx, name, *mountBlobDigest, opt.TargetRef)
-					if err != nil {
-						return errors.Wrap(err, "push appended mount blob")
-					}
-					appendedMutex.Lock()
-					mountBlobs = append(mountBlobs, Blob{
-						Name: name,
-						Desc: *mountBlobDesc,
-					})
-					appendedMutex.Unlock()
-					logrus.Infof("pushed blob for appended mount, elapsed: %s", time.Since(start))
-					return nil
-				})
-			}(idx)
-		}
-
-		return appendedEg.Wait()
-	}
-
-	if opt.PauseContainer {
-		if err := wf.pause(ctx, opt.ContainerIDWithType, commit); err != nil {
-			return errors.Wrap(err, "pause container to commit")
+	return state.Timings.Time("push "+blobName, func() error {
+		// The small blob is read once for all targets.
+		data, err := wf.readSmallBlob(blobName, blobDesc)
+		if err != nil {
+			return withClass(err, ErrPush)
 		}
-	} else {
-		if err := commit(); err != nil {
-			return err
+		for _, targetRef := range state.NydusTargetRefs {
+			if err := wf.pushBlobData(ctx, blobName, data, blobDesc, targetRef); err != nil {
+				return withClass(errors.Wrapf(err, "push to %s", targetRef), ErrPush)
+			}
 		}
-	}
-
-	logrus.Infof("merging base and upper bootstraps")
-	blobDigests, bootstrapDiffID, err := wf.mergeBootstrap(ctx, *upperBlob, mountBlobs, "bootstrap-base", "bootstrap-merged.tar")
-	if err != nil {
-		return errors.Wrap(err, "merge bootstrap")
-	}
-
-	logrus.Infof("pushing committed image to %s", targetRef)
-	if err := wf.pushManifest(ctx, *image, *bootstrapDiffID, targetRef, "bootstrap-merged.tar", blobDigests, upperBlob, mountBlobs); err != nil {
-		return errors.Wrap(err, "push manifest")
-	}
-
-	return nil
+		return nil
+	})
 }
@@ -1,46 +1,218 @@
 package workflow
 
 import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/containerd/containerd/mount"
-	"github.com/nydusaccelerator/nydus-cli/pkg/container"
+	"github.com/nydusaccelerator/nydus-cli/pkg/config"
+	"github.com/nydusaccelerator/nydus-cli/pkg/tarstream"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
 )
 
-func TestPrepareMounts(t *testing.T) {
-	containerMounts := []container.Mount{
-		{
-			Source:      "/host/ossfs",
-			Destination: "/guest/ossfs",
-		},
-	}
-	targetPaths := []string{
-		"/guest/ossfs/foo",
-		"/guest/ossfs/bar",
+func TestTarArgs(t *testing.T) {
+	args := tarArgs([]string{"/data"}, copyOption{})
+	for _, flag := range []string{"--xattrs", "--xattrs-include=*", "--acls", "--sparse"} {
+		require.Contains(t, args, flag)
 	}
+	require.NotContains(t, args, "--sort=name")
+	require.Equal(t, "/data", args[len(args)-1])
+
+	args = tarArgs([]string{"/data"}, copyOption{sortByName: true})
+	require.Contains(t, args, "--sort=name")
+	require.NotContains(t, args, "--numeric-owner")
+	require.NotContains(t, args, "--selinux")
+
+	args = tarArgs([]string{"/data"}, copyOption{numericOwner: true, noACLs: true, selinux: true})
+	require.Contains(t, args, "--numeric-owner")
+	require.Contains(t, args, "--selinux")
+	require.NotContains(t, args, "--acls")
+	require.Contains(t, args, "--ignore-failed-read")
+
+	args = tarArgs([]string{"/etc/hosts", "/usr/bin/app"}, copyOption{})
+	require.Equal(t, []string{"-", "/etc/hosts", "/usr/bin/app"}, args[len(args)-3:])
+}
+
+func TestMountList(t *testing.T) {
+	mountList := NewMountList()
+	mountList.Add("/redirect", false)
+	mountList.Add("/etc/hosts", true)
+	mountList.Add("/usr/bin/app", true)
+	require.Equal(t, []string{"/redirect"}, mountList.paths)
+	require.Equal(t, []string{"/etc/hosts", "/usr/bin/app"}, mountList.files)
+}
+
+func TestCheckSockets(t *testing.T) {
+	stderr := "tar: Removing leading `/' from member names\ntar: /data/app.sock: socket ignored\n"
+	require.NoError(t, checkSockets(stderr, copyOption{}))
+	err := checkSockets(stderr, copyOption{failOnSocket: true})
+	require.ErrorIs(t, err, tarstream.ErrSpecialFile)
+	require.EqualError(t, err, "special file found: /data/app.sock: socket ignored")
+	require.NoError(t, checkSockets("", copyOption{failOnSocket: true}))
+}
+
+func TestCheckSkipped(t *testing.T) {
+	stderr := "tar: Removing leading `/' from member names\n" +
+		"tar: /data/key: Warning: Cannot open: Permission denied\n" +
+		"tar: /data/dir: Warning: Cannot savedir: Permission denied\n" +
+		"tar: /data/app.sock: socket ignored\n"
+	require.Equal(t, []string{"/data/key", "/data/dir"}, skippedFiles(stderr))
+	require.NoError(t, checkSkipped(stderr, copyOption{}))
+	require.EqualError(t, checkSkipped(stderr, copyOption{failOnUnreadable: true}), "skipped 2 unreadable files: /data/key, /data/dir")
+	require.NoError(t, checkSkipped("tar: /data/app.sock: socket ignored\n", copyOption{failOnUnreadable: true}))
+}
+
+func TestParseUnreadablePolicy(t *testing.T) {
+	policy, err := ParseUnreadablePolicy("")
+	require.NoError(t, err)
+	require.Equal(t, UnreadableWarn, policy)
+	policy, err = ParseUnreadablePolicy("fail")
+	require.NoError(t, err)
+	require.Equal(t, UnreadableFail, policy)
+	_, err = ParseUnreadablePolicy("ignore")
+	require.Error(t, err)
+}
+
+func TestSyncFs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, syncFs(dir))
+	require.ErrorContains(t, syncFs(filepath.Join(dir, "missing")), "open "+filepath.Join(dir, "missing"))
+}
+
+func TestCleanups(t *testing.T) {
+	c := cleanups{}
+	called := []string{}
+	c.add("first", func() error {
+		called = append(called, "first")
+		return fmt.Errorf("first failed")
+	})
+	done := c.add("released", func() error {
+		called = append(called, "released")
+		return nil
+	})
+	c.add("last", func() error {
+		called = append(called, "last")
+		return fmt.Errorf("last failed")
+	})
+	done()
+
+	err := c.run()
+	require.Equal(t, []string{"last", "first"}, called)
+	require.ErrorContains(t, err, "first failed")
+	require.ErrorContains(t, err, "last failed")
+	require.NoError(t, c.run())
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("localhost:5000/nginx:committed")
+	require.NoError(t, err)
+	require.Equal(t, Target{Ref: "localhost:5000/nginx:committed", Format: FormatNydus}, *target)
+
+	target, err = ParseTarget("localhost:5000/nginx:committed=oci")
+	require.NoError(t, err)
+	require.Equal(t, Target{Ref: "localhost:5000/nginx:committed", Format: FormatOCI}, *target)
+
+	_, err = ParseTarget("localhost:5000/nginx:committed=estargz")
+	require.Error(t, err)
+}
+
+func TestParseChunkDict(t *testing.T) {
+	source, err := ParseChunkDict("bootstrap=localhost:5000/nginx:nydus")
+	require.NoError(t, err)
+	require.Equal(t, "localhost:5000/nginx:nydus", source)
 
-	targetMounts, err := prepareMounts(containerMounts, targetPaths)
-	require.NoError(t, err)
-
-	require.Equal(t, []mount.Mount{
-		{
-			Type:   "bind",
-			Source: "/host/ossfs/foo",
-			Target: "guest/ossfs/foo",
-			Options: []string{
-				"ro",
-				"rbind",
-			},
-		},
-		{
-			Type:   "bind",
-			Source: "/host/ossfs/bar",
-			Target: "guest/ossfs/bar",
-			Options: []string{
-				"ro",
-				"rbind",
-			},
-		},
-	}, targetMounts)
+	_, err = ParseChunkDict("localhost:5000/nginx:nydus")
+	require.Error(t, err)
+	_, err = ParseChunkDict("bootstrap=")
+	require.Error(t, err)
+}
+
+func TestBlobInRegistry(t *testing.T) {
+	small := ocispec.Descriptor{Size: 1 << 10}
+	large := ocispec.Descriptor{Size: 128 << 20}
+
+	wf := &Workflow{cfg: &config.Config{}}
+	require.True(t, wf.blobInRegistry(large, true))
+	require.True(t, wf.blobInRegistry(large, false))
+
+	wf.cfg.OSS.Endpoint = "oss-cn-hangzhou.aliyuncs.com"
+	require.False(t, wf.blobInRegistry(small, true))
+	require.False(t, wf.blobInRegistry(small, false))
+
+	wf.cfg.Routing.Rules = []config.RoutingRule{{MaxSize: "64MiB", Backend: config.BackendTypeRegistry}}
+	require.True(t, wf.blobInRegistry(small, true))
+	require.False(t, wf.blobInRegistry(large, true))
+	// The lower blobs of base are kept in registry.
+	require.True(t, wf.blobInRegistry(large, false))
+}
+
+func TestNewWorkflowCleanup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Base.WorkDir = t.TempDir()
+	cfg.Base.WorkDirEncryption = "invalid"
+
+	_, err := NewWorkflow(cfg)
+	require.ErrorContains(t, err, "invalid work dir encryption")
+	// The temp work dir created is removed on failure.
+	entries, err := os.ReadDir(cfg.Base.WorkDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestArtifactModes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Base.WorkDir = t.TempDir()
+	// The modes beyond the usual umask 022 are kept as is.
+	cfg.Artifact.FileMode = "0666"
+	cfg.Artifact.DirMode = "0777"
+
+	umask := unix.Umask(0022)
+	unix.Umask(umask)
+	wf, err := NewWorkflow(cfg)
+	require.NoError(t, err)
+	require.Equal(t, umask, unix.Umask(umask), "umask of process is changed")
+
+	info, err := os.Stat(wf.workDir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0777), info.Mode().Perm())
+
+	dir := filepath.Join(wf.workDir, "dir", "sub")
+	require.NoError(t, wf.createDir(dir))
+	info, err = os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0777), info.Mode().Perm())
+
+	file, err := wf.createFile(filepath.Join(dir, "file"))
+	require.NoError(t, err)
+	file.Close()
+	info, err = os.Stat(file.Name())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0666), info.Mode().Perm())
+}
+
+func TestAbortLayerWriter(t *testing.T) {
+	wf := &Workflow{cfg: &config.Config{}, workDir: t.TempDir(), fileMode: 0600}
+	opt := CommitOption{Targets: []Target{{Ref: "localhost:5000/app:v1", Format: FormatOCI}}}
+
+	_, lw, err := wf.layerWriter(opt, io.Discard, "blob")
+	require.NoError(t, err)
+	_, err = lw.Write([]byte("layer"))
+	require.NoError(t, err)
+
+	// The failed commit leaves neither the file open nor the file.
+	lw.abort()
+	_, err = lw.file.Write([]byte("layer"))
+	require.ErrorIs(t, err, os.ErrClosed)
+	_, err = os.Stat(filepath.Join(wf.workDir, "blob.tar.gz"))
+	require.True(t, os.IsNotExist(err))
+
+	// No layer is written without OCI targets.
+	_, lw, err = wf.layerWriter(CommitOption{}, io.Discard, "blob")
+	require.NoError(t, err)
+	require.Nil(t, lw)
+	lw.abort()
 }
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nydusaccelerator/nydus-cli/pkg/doctor"
+	"github.com/nydusaccelerator/nydus-cli/pkg/harness"
+	"github.com/stretchr/testify/require"
+)
+
+// findResult returns the result of check `name` in `report`.
+func findResult(t *testing.T, report *doctor.Report, name string) doctor.Result {
+	for _, result := range report.Results {
+		if result.Name == name {
+			return result
+		}
+	}
+	require.Failf(t, "result not found", "no result of %s", name)
+	return doctor.Result{}
+}
+
+func testSelftest(t *testing.T, backend string) {
+	if os.Geteuid() != 0 {
+		t.Skip("selftest requires root")
+	}
+	for _, binary := range []string{"nydus-image", "nydusd"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			t.Skipf("selftest requires %s", binary)
+		}
+	}
+
+	report := harness.Qualify(context.Background(), harness.Config{
+		WorkDir:    t.TempDir(),
+		Containers: 4,
+		Backend:    backend,
+		Builder:    "nydus-image",
+		Nydusd:     "nydusd",
+		Output:     os.Stderr,
+	})
+	report.Print(os.Stderr)
+	require.True(t, findResult(t, report, "builder").Passed)
+	require.NoError(t, report.Err())
+}
+
+func TestSelftest(t *testing.T) {
+	testSelftest(t, harness.BackendRegistry)
+	testSelftest(t, harness.BackendOSS)
+}
+
+func TestSelftestBuilderCheck(t *testing.T) {
+	if _, err := exec.LookPath("nydus-image"); err != nil {
+		t.Skip("builder check requires nydus-image")
+	}
+
+	// The builder is found in PATH if not set, the missing nydusd fails
+	// the preflight so the commit cases are not run.
+	report := harness.Qualify(context.Background(), harness.Config{
+		WorkDir:    t.TempDir(),
+		Containers: 1,
+		Backend:    harness.BackendRegistry,
+		Nydusd:     filepath.Join(t.TempDir(), "nydusd"),
+	})
+	builder := findResult(t, report, "builder")
+	require.True(t, builder.Passed, builder.Error)
+	require.False(t, report.Passed)
+}